@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/auth"
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/storage"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Lifetime of access tokens minted without a ttl, and the longest allowed
+const (
+	defaultAccessTokenTTL = time.Hour
+	maxAccessTokenTTL     = 24 * time.Hour
+)
+
+// Subprotocol browsers offer next to an access token, since they can't set
+// headers on WebSockets: new WebSocket(url, ["access-token", token])
+const accessTokenProtocol = "access-token"
+
+var (
+	// Key access tokens are signed with; /ws is open to anyone when empty
+	accessTokenSecret []byte
+	accessVerifier    *auth.Verifier
+
+	errMissingAccessToken = errors.New("access token required")
+)
+
+// accessGrant is what an access token lets its holder do
+type accessGrant struct {
+	UserID string
+	RoomID string
+	Role   signaling.Role
+}
+
+// registerAccessTokenAPI adds the endpoint that mints access tokens
+func registerAccessTokenAPI(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/token", requireScope(storage.ScopeRoomsWrite, handleMintAccessToken))
+}
+
+// enableAccessTokens requires an access token signed with secret on /ws
+func enableAccessTokens(secret string) error {
+	verifier, err := auth.NewVerifier(auth.Config{Secret: []byte(secret)})
+	if err != nil {
+		return err
+	}
+	accessTokenSecret = []byte(secret)
+	accessVerifier = verifier
+	// The protobuf subprotocol stays first, so clients can offer it along
+	// with their token
+	upgrader.Subprotocols = append(upgrader.Subprotocols, accessTokenProtocol)
+	return nil
+}
+
+// handleMintAccessToken signs a token from {"userId", "roomId", "role",
+// "ttl"}, where role defaults to participant and ttl is a duration such as
+// "30m"
+func handleMintAccessToken(w http.ResponseWriter, r *http.Request) {
+	if accessVerifier == nil {
+		writeError(w, http.StatusServiceUnavailable, "access tokens are disabled")
+		return
+	}
+	var request struct {
+		UserID string `json:"userId"`
+		RoomID string `json:"roomId"`
+		Role   string `json:"role"`
+		TTL    string `json:"ttl"`
+	}
+	if err := decodeJSON(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if request.UserID == "" || request.RoomID == "" {
+		writeError(w, http.StatusBadRequest, "userId and roomId are required")
+		return
+	}
+	role := signaling.RoleParticipant
+	if request.Role != "" {
+		parsed, err := signaling.ParseRole(request.Role)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		role = parsed
+	}
+	ttl := defaultAccessTokenTTL
+	if request.TTL != "" {
+		parsed, err := time.ParseDuration(request.TTL)
+		if err != nil || parsed <= 0 || parsed > maxAccessTokenTTL {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("ttl must be a duration up to %s", maxAccessTokenTTL))
+			return
+		}
+		ttl = parsed
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	token, err := auth.Sign(accessTokenSecret, map[string]interface{}{
+		"sub":  request.UserID,
+		"room": request.RoomID,
+		"role": string(role),
+		"iat":  now.Unix(),
+		"exp":  expiresAt.Unix(),
+	})
+	if err != nil {
+		util.Error("Error signing access token: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not sign access token")
+		return
+	}
+
+	util.Info("Access token minted for user %s in room %s as %s by %s", request.UserID, request.RoomID, role, r.RemoteAddr)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"token":     token,
+		"userId":    request.UserID,
+		"roomId":    request.RoomID,
+		"role":      role,
+		"expiresAt": expiresAt.UTC().Truncate(time.Second),
+	})
+}
+
+// authorizeAccess checks the access token of a WebSocket request, passed as
+// access_token or as the subprotocol after access-token
+func authorizeAccess(r *http.Request) (accessGrant, error) {
+	token := r.URL.Query().Get("access_token")
+	if token == "" {
+		protocols := websocketProtocols(r)
+		for i, protocol := range protocols {
+			if protocol == accessTokenProtocol && i+1 < len(protocols) {
+				token = protocols[i+1]
+				break
+			}
+		}
+	}
+	if token == "" {
+		return accessGrant{}, errMissingAccessToken
+	}
+
+	claims, err := accessVerifier.Verify(token)
+	if err != nil {
+		return accessGrant{}, err
+	}
+	roomID, _ := claims.Raw["room"].(string)
+	if roomID == "" {
+		return accessGrant{}, fmt.Errorf("%w: no room", auth.ErrInvalidToken)
+	}
+	name, _ := claims.Raw["role"].(string)
+	role, err := signaling.ParseRole(name)
+	if err != nil {
+		return accessGrant{}, fmt.Errorf("%w: %v", auth.ErrInvalidToken, err)
+	}
+	return accessGrant{UserID: claims.Subject, RoomID: roomID, Role: role}, nil
+}
+
+// websocketProtocols lists the subprotocols a WebSocket request offers
+func websocketProtocols(r *http.Request) []string {
+	var protocols []string
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return protocols
+}
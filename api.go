@@ -0,0 +1,825 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/integrations"
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/storage"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Maximum size of JSON request bodies accepted by the REST API
+const maxRequestBody = 1 << 20
+
+// Maximum size of an uploaded recording
+const maxRecordingSize = 2 << 30
+
+var (
+	// Key required by the admin endpoints; they are disabled when it is empty
+	adminAPIKey string
+
+	// Key required by chat bridges; the bridge endpoints are disabled when it is empty
+	bridgeAPIKey string
+
+	// Key required by transcription services posting captions
+	captionsAPIKey string
+
+	// Relays room chat back to bridged platforms
+	bridgeRelay = integrations.NewBridgeRelay()
+
+	// Recording metadata and artifacts; recordings are disabled when nil
+	recordingStore *storage.RecordingStore
+
+	// Meeting records; the meetings API is disabled when nil
+	meetingStore *storage.MeetingStore
+
+	// Tamper-evident log of compliance events, if configured
+	auditLog *storage.AuditLog
+)
+
+// registerRoomAPI adds the room configuration endpoints to the router
+func registerRoomAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/rooms/{id}", requireAdmin(handleGetRoom))
+	mux.HandleFunc("DELETE /api/rooms/{id}", requireAdmin(handleCloseRoom))
+	mux.HandleFunc("DELETE /api/rooms/{id}/clients/{clientId}", requireAdmin(handleDisconnectClient))
+	mux.HandleFunc("GET /api/rooms/{id}/config", requireScope(storage.ScopeRoomsRead, handleExportRoom))
+	mux.HandleFunc("GET /api/rooms/{id}/can-join", handleCanJoin)
+	mux.HandleFunc("GET /api/capabilities", handleCapabilities)
+	mux.HandleFunc("GET /api/rooms/{id}/roster", requireScope(storage.ScopeRoomsRead, handleRoomRoster))
+	mux.HandleFunc("GET /api/rooms/{id}/members", requireScope(storage.ScopeRoomsRead, handleRoomMembers))
+	mux.HandleFunc("GET /api/rooms/{id}/messages", requireScope(storage.ScopeRoomsRead, handleRoomMessages))
+	mux.HandleFunc("POST /api/rooms", idempotent(handleCreateRoom))
+	mux.HandleFunc("POST /api/rooms/import", requireScope(storage.ScopeRoomsWrite, idempotent(handleImportRoom)))
+	mux.HandleFunc("POST /api/client-errors", handleClientError)
+	mux.HandleFunc("GET /api/rooms/{id}/events", requireScope(storage.ScopeRoomsRead, handleRoomEvents))
+	mux.HandleFunc("GET /api/rooms/{id}/replay", requireScope(storage.ScopeRoomsRead, handleReplayRoom))
+	mux.HandleFunc("GET /api/rooms/{id}/settings", requireScope(storage.ScopeRoomsRead, handleGetSettings))
+	mux.HandleFunc("PUT /api/rooms/{id}/settings", requireScope(storage.ScopeRoomsWrite, handleReplaceSettings))
+	mux.HandleFunc("PUT /api/rooms/{id}/watermark", requireScope(storage.ScopeRoomsWrite, handleSetWatermark))
+	mux.HandleFunc("DELETE /api/rooms/{id}/watermark", requireScope(storage.ScopeRoomsWrite, handleRemoveWatermark))
+}
+
+// registerAdminAPI adds the operator endpoints to the router
+func registerAdminAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/admin/traces/{traceId}", requireAdmin(handleDeliveryTrace))
+	mux.HandleFunc("GET /api/admin/client-errors", requireAdmin(handleListClientErrors))
+	mux.HandleFunc("GET /api/admin/audit", requireAdmin(handleAuditLog))
+	mux.HandleFunc("GET /api/admin/nodes", requireAdmin(handleClusterNodes))
+	mux.HandleFunc("GET /api/admin/log-levels", requireAdmin(handleGetLogLevels))
+	mux.HandleFunc("PUT /api/admin/log-levels", requireAdmin(handleSetLogLevels))
+	mux.HandleFunc("GET /api/admin/ice-health", requireAdmin(handleICEHealth))
+	mux.HandleFunc("GET /api/admin/client-config", requireAdmin(handleGetClientConfig))
+	mux.HandleFunc("PUT /api/admin/client-config", requireAdmin(handleSetClientConfig))
+}
+
+// registerRecordingAPI adds the endpoints to upload and download recordings
+func registerRecordingAPI(mux *http.ServeMux) {
+	mux.HandleFunc("PUT /api/rooms/{id}/recordings/{recordingId}/artifact", handleUploadRecording)
+	mux.HandleFunc("GET /api/recordings", requireScope(storage.ScopeRecordingsRead, handleListRecordings))
+	mux.HandleFunc("GET /api/recordings/{recordingId}", requireScope(storage.ScopeRecordingsRead, handleGetRecording))
+	mux.HandleFunc("GET /api/recordings/{recordingId}/artifact", requireScope(storage.ScopeRecordingsRead, handleDownloadRecording))
+	mux.HandleFunc("DELETE /api/recordings/{recordingId}", requireAdmin(handleDeleteRecording))
+}
+
+// registerMeetingAPI adds the endpoints to look up meeting records
+func registerMeetingAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/meetings", requireScope(storage.ScopeRoomsRead, handleListMeetings))
+	mux.HandleFunc("GET /api/meetings/{meetingId}", requireScope(storage.ScopeRoomsRead, handleGetMeeting))
+	mux.HandleFunc("GET /api/meetings/{meetingId}/analytics", requireScope(storage.ScopeRoomsRead, handleMeetingAnalytics))
+	mux.HandleFunc("DELETE /api/meetings/{meetingId}", requireAdmin(handleDeleteMeeting))
+}
+
+// requireAdmin only lets requests through that carry the admin API key or
+// an API token with the admin scope as a bearer token
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireScope(storage.ScopeAdmin, next)
+}
+
+// requireScope only lets requests through that carry an API token granting
+// scope, or the admin API key, which grants every scope
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminAPIKey == "" && apiTokens == nil {
+			writeError(w, http.StatusForbidden, "admin API is disabled")
+			return
+		}
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if adminAPIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(adminAPIKey)) == 1 {
+			next(w, r)
+			return
+		}
+		if apiTokens != nil {
+			token, err := apiTokens.Authenticate(key, scope)
+			if err == nil {
+				next(w, r)
+				return
+			}
+			if errors.Is(err, storage.ErrTokenScope) {
+				util.WarnContext(requestLogContext(r), "Rejected request to %s with API token %s lacking %s", r.URL.Path, token.ID, scope)
+				writeError(w, http.StatusForbidden, "API token lacks the "+scope+" scope")
+				return
+			}
+		}
+		util.WarnContext(requestLogContext(r), "Rejected %s request to %s from %s", scope, r.URL.Path, r.RemoteAddr)
+		writeError(w, http.StatusUnauthorized, "invalid API key")
+	}
+}
+
+// requestLogContext returns a request's context with the room and client it
+// concerns, and the room's tenant, as log attributes. The room comes from
+// the path of room endpoints or the roomId query parameter, the client from
+// the path or the clientId query parameter
+func requestLogContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	roomID := r.URL.Query().Get("roomId")
+	if strings.HasPrefix(r.URL.Path, "/api/rooms/") {
+		roomID = cmp.Or(r.PathValue("id"), roomID)
+	}
+	if room := hub.FindRoom(roomID); room != nil {
+		ctx = room.LogContext(ctx)
+	} else if roomID != "" {
+		ctx = util.WithRoom(ctx, roomID)
+	}
+	if clientID := cmp.Or(r.PathValue("clientId"), r.URL.Query().Get("clientId")); clientID != "" {
+		ctx = util.WithClient(ctx, clientID)
+	}
+	return ctx
+}
+
+// requireKey only lets requests through that carry the given key as a bearer
+// token. The key is read per request so it can be set after routes are registered
+func requireKey(expected *string, name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if *expected == "" {
+			writeError(w, http.StatusForbidden, name+" API is disabled")
+			return
+		}
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(key), []byte(*expected)) != 1 {
+			util.WarnContext(requestLogContext(r), "Rejected %s request to %s from %s", name, r.URL.Path, r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, "invalid "+name+" API key")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// registerBridgeAPI adds the endpoints used by chat bridges
+func registerBridgeAPI(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/rooms/{id}/bridge/messages", requireKey(&bridgeAPIKey, "bridge", handleBridgeMessage))
+	mux.HandleFunc("PUT /api/rooms/{id}/bridge", requireKey(&bridgeAPIKey, "bridge", handleSetBridge))
+	mux.HandleFunc("DELETE /api/rooms/{id}/bridge", requireKey(&bridgeAPIKey, "bridge", handleRemoveBridge))
+}
+
+// registerCaptionAPI adds the endpoint transcription services post captions to
+func registerCaptionAPI(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/rooms/{id}/captions", requireKey(&captionsAPIKey, "captions", handleCaption))
+}
+
+// handleCaption fans a caption from a transcription service out to the room
+func handleCaption(w http.ResponseWriter, r *http.Request) {
+	room := hub.FindRoom(r.PathValue("id"))
+	if room == nil {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	var caption signaling.Caption
+	if err := decodeJSON(w, r, &caption); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := room.PublishCaption(caption); errors.Is(err, signaling.ErrTranscriptionDisabled) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleBridgeMessage injects a message from an external platform into a room's chat
+func handleBridgeMessage(w http.ResponseWriter, r *http.Request) {
+	room := hub.FindRoom(r.PathValue("id"))
+	if room == nil {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	var message signaling.BridgeMessage
+	if err := decodeJSON(w, r, &message); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := room.InjectBridgeMessage(message); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleSetBridge relays a room's chat back to a bridge
+func handleSetBridge(w http.ResponseWriter, r *http.Request) {
+	roomID := r.PathValue("id")
+	var target integrations.BridgeTarget
+	if err := decodeJSON(w, r, &target); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if target.URL == "" {
+		writeError(w, http.StatusBadRequest, "url is required")
+		return
+	}
+	bridgeRelay.SetTarget(roomID, target)
+	util.InfoContext(requestLogContext(r), "Relaying chat of room %s to %s bridge", roomID, target.Source)
+	writeJSON(w, http.StatusOK, target)
+}
+
+// handleRemoveBridge stops relaying a room's chat
+func handleRemoveBridge(w http.ResponseWriter, r *http.Request) {
+	if !bridgeRelay.RemoveTarget(r.PathValue("id")) {
+		writeError(w, http.StatusNotFound, "room has no bridge")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetSettings returns a room's settings, with their version as ETag
+func handleGetSettings(w http.ResponseWriter, r *http.Request) {
+	room := hub.FindRoom(r.PathValue("id"))
+	if room == nil {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	settings, version := room.VersionedSettings()
+	w.Header().Set("ETag", settingsETag(version))
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// handleReplaceSettings replaces a room's settings. With If-Match, they are
+// only replaced if nobody changed them since that ETag was read
+func handleReplaceSettings(w http.ResponseWriter, r *http.Request) {
+	room := hub.FindRoom(r.PathValue("id"))
+	if room == nil {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	var settings signaling.RoomSettings
+	if err := decodeJSON(w, r, &settings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, err := room.ReplaceSettings(ifMatchVersion(r), settings)
+	if err != nil {
+		writeSettingsError(w, version, err)
+		return
+	}
+	util.InfoContext(requestLogContext(r), "Settings of room %s replaced by %s", room.ID, r.RemoteAddr)
+	settings, version = room.VersionedSettings()
+	w.Header().Set("ETag", settingsETag(version))
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// handleSetWatermark turns on the per-viewer watermark of a room
+func handleSetWatermark(w http.ResponseWriter, r *http.Request) {
+	room := hub.FindRoom(r.PathValue("id"))
+	if room == nil {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	var watermark signaling.Watermark
+	if err := decodeJSON(w, r, &watermark); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, err := room.SetWatermarkIf(ifMatchVersion(r), &watermark)
+	if err != nil {
+		writeSettingsError(w, version, err)
+		return
+	}
+	util.InfoContext(requestLogContext(r), "Watermark enabled in room %s by %s", room.ID, r.RemoteAddr)
+	w.Header().Set("ETag", settingsETag(version))
+	writeJSON(w, http.StatusOK, watermark)
+}
+
+// handleRemoveWatermark turns a room's watermark off
+func handleRemoveWatermark(w http.ResponseWriter, r *http.Request) {
+	room := hub.FindRoom(r.PathValue("id"))
+	if room == nil || room.Settings().Watermark == nil {
+		writeError(w, http.StatusNotFound, "room has no watermark")
+		return
+	}
+	version, err := room.SetWatermarkIf(ifMatchVersion(r), nil)
+	if err != nil {
+		writeSettingsError(w, version, err)
+		return
+	}
+	util.InfoContext(requestLogContext(r), "Watermark removed from room %s by %s", room.ID, r.RemoteAddr)
+	w.Header().Set("ETag", settingsETag(version))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// settingsETag formats a settings version as a strong ETag
+func settingsETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatchVersion returns the settings version a request's If-Match header
+// requires, or 0 when it has none or accepts any. An ETag that isn't ours
+// yields -1, which never matches
+func ifMatchVersion(r *http.Request) int64 {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0
+	}
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil || version <= 0 {
+		return -1
+	}
+	return version
+}
+
+// writeSettingsError answers a failed settings change: 412 with the current
+// ETag when someone else changed them first, 400 otherwise
+func writeSettingsError(w http.ResponseWriter, version int64, err error) {
+	if errors.Is(err, signaling.ErrSettingsConflict) {
+		w.Header().Set("ETag", settingsETag(version))
+		writeError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, err.Error())
+}
+
+// handleUploadRecording stores the artifact a participant's browser
+// recorded. The upload token from recording-start authorizes it
+func handleUploadRecording(w http.ResponseWriter, r *http.Request) {
+	if recordingStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "recordings are disabled")
+		return
+	}
+	room := hub.FindRoom(r.PathValue("id"))
+	if room == nil {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	recording, err := room.AuthorizeRecordingUpload(r.PathValue("recordingId"), token)
+	if errors.Is(err, signaling.ErrRecordingNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	artifact, err := recordingStore.WriteArtifact(recording.ID, contentType, r.Body, maxRecordingSize)
+	if errors.Is(err, storage.ErrArtifactTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if err != nil {
+		util.ErrorContext(requestLogContext(r), "Error storing recording %s: %v", recording.ID, err)
+		writeError(w, http.StatusInternalServerError, "could not store recording")
+		return
+	}
+	recording, err = hub.CompleteRecording(room.ID, recording.ID, artifact)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, recording)
+}
+
+// handleListRecordings returns the recordings of a room (?roomId=) or of all rooms
+func handleListRecordings(w http.ResponseWriter, r *http.Request) {
+	if recordingStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "recordings are disabled")
+		return
+	}
+	recordings, err := recordingStore.Recordings(r.URL.Query().Get("roomId"))
+	if err != nil {
+		util.Error("Error listing recordings: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not list recordings")
+		return
+	}
+	writeJSON(w, http.StatusOK, recordings)
+}
+
+// handleGetRecording returns the metadata of a recording
+func handleGetRecording(w http.ResponseWriter, r *http.Request) {
+	if recordingStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "recordings are disabled")
+		return
+	}
+	recording, err := recordingStore.Recording(r.PathValue("recordingId"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "recording not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, recording)
+}
+
+// handleDeleteRecording deletes a recording and its artifact unless it is
+// under legal hold
+func handleDeleteRecording(w http.ResponseWriter, r *http.Request) {
+	if recordingStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "recordings are disabled")
+		return
+	}
+	recording, err := recordingStore.Recording(r.PathValue("recordingId"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "recording not found")
+		return
+	}
+	entry := signaling.AuditEntry{
+		RoomID:      recording.RoomID,
+		ClientID:    recording.ClientID,
+		RecordingID: recording.ID,
+		SHA256:      recording.SHA256,
+		Details:     "recording " + recording.ID,
+	}
+	hold, held := legalHolds.CoversRecording(recording)
+	if refuseHeldDeletion(w, r, hold, held, entry) {
+		return
+	}
+	if err := recordingStore.DeleteRecording(recording); err != nil {
+		util.Error("Error deleting recording %s: %v", recording.ID, err)
+		writeError(w, http.StatusInternalServerError, "could not delete recording")
+		return
+	}
+
+	util.Info("Recording %s deleted by %s", recording.ID, r.RemoteAddr)
+	entry.Action = signaling.AuditDeleted
+	recordAudit(entry)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDownloadRecording serves the media of a finished recording
+func handleDownloadRecording(w http.ResponseWriter, r *http.Request) {
+	if recordingStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "recordings are disabled")
+		return
+	}
+	recording, err := recordingStore.Recording(r.PathValue("recordingId"))
+	if err != nil || recording.Status != signaling.RecordingAvailable {
+		writeError(w, http.StatusNotFound, "recording not found")
+		return
+	}
+	file, err := recordingStore.OpenArtifact(recording)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "recording not found")
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", recording.ContentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+recording.File+"\"")
+	http.ServeContent(w, r, recording.File, recording.UpdatedAt, file)
+}
+
+// handleListMeetings returns the meetings of a room (?roomId=) or of all rooms
+func handleListMeetings(w http.ResponseWriter, r *http.Request) {
+	if meetingStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "meetings are disabled")
+		return
+	}
+	meetings, err := meetingStore.Meetings(r.URL.Query().Get("roomId"))
+	if err != nil {
+		util.Error("Error listing meetings: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not list meetings")
+		return
+	}
+	writeJSON(w, http.StatusOK, meetings)
+}
+
+// handleGetMeeting returns a meeting record with its transcript and summary
+func handleGetMeeting(w http.ResponseWriter, r *http.Request) {
+	if meetingStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "meetings are disabled")
+		return
+	}
+	meeting, err := meetingStore.Meeting(r.PathValue("meetingId"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "meeting not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, meeting)
+}
+
+// handleDeleteMeeting deletes a finished meeting record unless it is under
+// legal hold
+func handleDeleteMeeting(w http.ResponseWriter, r *http.Request) {
+	if meetingStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "meetings are disabled")
+		return
+	}
+	meeting, err := meetingStore.Meeting(r.PathValue("meetingId"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "meeting not found")
+		return
+	}
+	if meeting.EndedAt.IsZero() {
+		writeError(w, http.StatusConflict, "meeting is still running")
+		return
+	}
+	entry := signaling.AuditEntry{RoomID: meeting.RoomID, Details: "meeting " + meeting.ID}
+	hold, held := legalHolds.CoversMeeting(meeting)
+	if refuseHeldDeletion(w, r, hold, held, entry) {
+		return
+	}
+	if err := meetingStore.DeleteMeeting(meeting.ID); err != nil {
+		util.Error("Error deleting meeting %s: %v", meeting.ID, err)
+		writeError(w, http.StatusInternalServerError, "could not delete meeting")
+		return
+	}
+
+	util.Info("Meeting %s deleted by %s", meeting.ID, r.RemoteAddr)
+	entry.Action = signaling.AuditDeleted
+	recordAudit(entry)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMeetingAnalytics returns the talk time and participation of each
+// participant of a meeting. Running meetings are read from their room so the
+// report is current
+func handleMeetingAnalytics(w http.ResponseWriter, r *http.Request) {
+	if meetingStore == nil {
+		writeError(w, http.StatusServiceUnavailable, "meetings are disabled")
+		return
+	}
+	meeting, err := meetingStore.Meeting(r.PathValue("meetingId"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "meeting not found")
+		return
+	}
+	if meeting.EndedAt.IsZero() {
+		if room := hub.FindRoom(meeting.RoomID); room != nil {
+			if current, ok := room.Meeting(); ok && current.ID == meeting.ID {
+				current.Transcript = room.Transcript()
+				meeting = current
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, meeting.Analytics())
+}
+
+// handleAuditLog returns the audit log and whether its hash chain is intact.
+// Entries after a break in the chain are left out
+func handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	if auditLog == nil {
+		writeError(w, http.StatusServiceUnavailable, "audit log is disabled")
+		return
+	}
+	entries, err := auditLog.Entries()
+	if err != nil && !errors.Is(err, storage.ErrAuditTampered) {
+		util.Error("Error reading audit log: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not read audit log")
+		return
+	}
+	response := map[string]interface{}{
+		"entries": entries,
+		"intact":  err == nil,
+	}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleDeliveryTrace returns the delivery events of a traced message
+func handleDeliveryTrace(w http.ResponseWriter, r *http.Request) {
+	traceID := r.PathValue("traceId")
+	events := hub.DeliveryTrace(traceID)
+	if events == nil {
+		writeError(w, http.StatusNotFound, "trace not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"traceId": traceID,
+		"events":  events,
+	})
+}
+
+// handleListClientErrors returns the client error summaries of all rooms
+func handleListClientErrors(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, hub.ClientErrors())
+}
+
+// handleClientError records an error from a browser that has no working
+// WebSocket to report it on
+func handleClientError(w http.ResponseWriter, r *http.Request) {
+	var report signaling.ClientErrorReport
+	if err := decodeJSON(w, r, &report); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The server decides when a report arrived
+	report.At = time.Time{}
+	hub.ReportClientError(report)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// roomDetails describes a live room to operators
+type roomDetails struct {
+	signaling.RoomStats
+	Host         string                  `json:"host,omitempty"`
+	CreatedAt    time.Time               `json:"createdAt"`
+	Participants []signaling.Participant `json:"participants"`
+}
+
+// handleGetRoom returns a room's participants, host, creation time and
+// message counts
+func handleGetRoom(w http.ResponseWriter, r *http.Request) {
+	room := hub.FindRoom(r.PathValue("id"))
+	if room == nil {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, roomDetails{
+		RoomStats:    room.Stats(),
+		Host:         room.GetHost(),
+		CreatedAt:    room.CreatedAt(),
+		Participants: room.Participants(),
+	})
+}
+
+// handleCloseRoom ends a room, disconnecting everyone in it with the
+// ?reason= query parameter
+func handleCloseRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := r.PathValue("id")
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "room closed by an operator"
+	}
+	if !hub.CloseRoom(roomID, reason) {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	util.InfoContext(requestLogContext(r), "Room %s closed by %s", roomID, r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDisconnectClient removes one participant from a room with the
+// ?reason= query parameter. The participant may join again
+func handleDisconnectClient(w http.ResponseWriter, r *http.Request) {
+	roomID, clientID := r.PathValue("id"), r.PathValue("clientId")
+	room := hub.FindRoom(roomID)
+	if room == nil {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "removed by an operator"
+	}
+	err := room.Disconnect(clientID, reason)
+	if errors.Is(err, signaling.ErrRecipientNotFound) {
+		writeError(w, http.StatusNotFound, "client not found")
+		return
+	}
+	if err != nil {
+		// The backplane is unavailable; the request can be retried
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	util.InfoContext(requestLogContext(r), "Client %s disconnected from room %s by %s", clientID, roomID, r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleExportRoom returns a room's configuration as JSON: its settings,
+// which include its users' roles, its host and its bans
+func handleExportRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := r.PathValue("id")
+	room := hub.FindRoom(roomID)
+	if room == nil {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+
+	util.DebugContext(requestLogContext(r), "Exporting configuration of room %s for %s", roomID, r.RemoteAddr)
+	snapshot := publicSnapshot(room)
+	w.Header().Set("Content-Disposition", "attachment; filename=\"room-config.json\"")
+	w.Header().Set("ETag", settingsETag(snapshot.SettingsVersion))
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// handleCreateRoom creates a room from {"id", "settings", "password"}.
+// Settings that aren't given keep their defaults, and a room ID is generated
+// when none is. Clients must give the password, if any, to join
+func handleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		ID       string                 `json:"id"`
+		Settings signaling.RoomSettings `json:"settings"`
+		Password string                 `json:"password"`
+	}{Settings: hub.DefaultSettings()}
+	if err := decodeJSON(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if request.ID == "" {
+		request.ID = generateRoomID()
+	}
+	snapshot := signaling.RoomSnapshot{ID: request.ID, Settings: request.Settings}
+	if request.Password != "" {
+		hash, err := signaling.HashPassword(request.Password)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		snapshot.PasswordHash = hash
+	}
+
+	room, err := hub.ImportRoom(snapshot)
+	if errors.Is(err, signaling.ErrRoomExists) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	util.InfoContext(room.LogContext(r.Context()), "Room %s created by %s", room.ID, r.RemoteAddr)
+	w.Header().Set("Location", "/api/rooms/"+url.PathEscape(room.ID)+"/config")
+	writeJSON(w, http.StatusCreated, publicSnapshot(room))
+}
+
+// publicSnapshot returns a room's configuration without its password hash
+func publicSnapshot(room *signaling.Room) signaling.RoomSnapshot {
+	snapshot := room.Snapshot()
+	snapshot.PasswordHash = ""
+	return snapshot
+}
+
+// generateRoomID creates a random, hard to guess room ID
+func generateRoomID() string {
+	id := make([]byte, 6)
+	rand.Read(id)
+	return "room-" + hex.EncodeToString(id)
+}
+
+// handleImportRoom creates a room from an exported configuration. The ?id=
+// query parameter overrides the room ID in the body, so the same export can
+// create a copy under another name
+func handleImportRoom(w http.ResponseWriter, r *http.Request) {
+	var snapshot signaling.RoomSnapshot
+	if err := decodeJSON(w, r, &snapshot); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if id := r.URL.Query().Get("id"); id != "" {
+		snapshot.ID = id
+	}
+
+	room, err := hub.ImportRoom(snapshot)
+	if errors.Is(err, signaling.ErrRoomExists) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	util.InfoContext(room.LogContext(r.Context()), "Room %s imported by %s", room.ID, r.RemoteAddr)
+	writeJSON(w, http.StatusCreated, publicSnapshot(room))
+}
+
+// decodeJSON reads a size-limited JSON request body
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		util.Error("Error writing JSON response: %v", err)
+	}
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
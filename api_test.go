@@ -0,0 +1,1328 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nikhilsahni7/chat-video-app/pkg/config"
+	"github.com/nikhilsahni7/chat-video-app/pkg/ratelimit"
+	"github.com/nikhilsahni7/chat-video-app/pkg/sfu"
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/storage"
+	"github.com/nikhilsahni7/chat-video-app/pkg/turnserver"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+func TestExportImportRoom(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoomAPI(mux)
+	defer func(key string) { adminAPIKey = key }(adminAPIKey)
+	adminAPIKey = "secret"
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	settings := hub.DefaultSettings()
+	settings.Profile = signaling.ProfileLowPower
+	settings.Roles = map[string]signaling.Role{"bob@example.com": signaling.RoleViewer}
+	bans := signaling.Bans{Clients: []string{"mallory"}, Users: []string{"mallory@example.com"}}
+	if _, err := hub.ImportRoom(signaling.RoomSnapshot{ID: "export-source", Settings: settings, Bans: bans}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Exports need the rooms:read scope
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/rooms/export-source/config", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an export without a key, got %d", rec.Code)
+	}
+
+	// Export the source room
+	rec = call("GET", "/api/rooms/export-source/config", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from export, got %d", rec.Code)
+	}
+	exported := rec.Body.String()
+
+	// Imports need the rooms:write scope, since they choose the host and bans
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/api/rooms/import?id=export-copy", strings.NewReader(exported)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an import without a key, got %d", rec.Code)
+	}
+	if hub.FindRoom("export-copy") != nil {
+		t.Error("Expected the unauthenticated import not to create the room")
+	}
+
+	// Import it under a new ID, with its roles and bans
+	rec = call("POST", "/api/rooms/import?id=export-copy", exported)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 from import, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var imported signaling.RoomSnapshot
+	json.NewDecoder(rec.Body).Decode(&imported)
+	if imported.ID != "export-copy" || imported.Settings.Profile != signaling.ProfileLowPower {
+		t.Errorf("Expected a low-power copy named export-copy, got %+v", imported)
+	}
+	if !reflect.DeepEqual(imported.Settings.Roles, settings.Roles) || !reflect.DeepEqual(imported.Bans, bans) {
+		t.Errorf("Expected the copy to keep roles %v and bans %+v, got %v and %+v", settings.Roles, bans, imported.Settings.Roles, imported.Bans)
+	}
+	if check := hub.CheckJoin("export-copy", "mallory-2", "mallory@example.com", "", nil); check.Reason != "banned" {
+		t.Errorf("Expected the copy to refuse a banned user, got %+v", check)
+	}
+	copied := hub.FindRoom("export-copy")
+	bob := &signaling.Client{ID: "bob", UserID: "bob@example.com"}
+	if _, err := copied.Join(bob); err != nil || bob.Role() != signaling.RoleViewer {
+		t.Errorf("Expected bob to join the copy as a viewer, got %s and %v", bob.Role(), err)
+	}
+
+	// Importing again conflicts
+	rec = call("POST", "/api/rooms/import?id=export-copy", exported)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for an existing room, got %d", rec.Code)
+	}
+
+	// Unknown rooms can't be exported
+	rec = call("GET", "/api/rooms/missing/config", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing room, got %d", rec.Code)
+	}
+}
+
+func TestSettingsIfMatch(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoomAPI(mux)
+	defer func(key string) { adminAPIKey = key }(adminAPIKey)
+	adminAPIKey = "secret"
+	hub.GetRoom("settings-etag")
+
+	call := func(method, path, etag, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		if etag != "" {
+			req.Header.Set("If-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := call("GET", "/api/rooms/settings-etag/settings", "", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected settings with an ETag, got %d %q", rec.Code, etag)
+	}
+
+	// The host's co-host and a dashboard both start from the same version
+	rec = call("PUT", "/api/rooms/settings-etag/settings", etag, `{"duplicatePolicy": "reject", "profile": "standard", "keywords": ["Budget"]}`)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Fatalf("Expected the first change to bump the ETag, got %d: %s", rec.Code, rec.Body.String())
+	}
+	updated := rec.Header().Get("ETag")
+	rec = call("PUT", "/api/rooms/settings-etag/settings", etag, `{"duplicatePolicy": "replace", "profile": "standard"}`)
+	if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("ETag") != updated {
+		t.Fatalf("Expected 412 with the current ETag for a stale change, got %d %q", rec.Code, rec.Header().Get("ETag"))
+	}
+	if rec := call("PUT", "/api/rooms/settings-etag/watermark", etag, `{}`); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 for a stale watermark change, got %d", rec.Code)
+	}
+
+	settings := hub.FindRoom("settings-etag").Settings()
+	if settings.DuplicatePolicy != signaling.DuplicateReject || len(settings.Keywords) != 1 || settings.Keywords[0] != "budget" {
+		t.Errorf("Expected only the first change to apply, got %+v", settings)
+	}
+
+	// Without If-Match the change applies unconditionally
+	if rec := call("PUT", "/api/rooms/settings-etag/watermark", "", `{}`); rec.Code != http.StatusOK {
+		t.Errorf("Expected an unconditional watermark change to apply, got %d", rec.Code)
+	}
+	if rec := call("PUT", "/api/rooms/settings-etag/settings", "", `{"duplicatePolicy": "replace", "profile": "standard", "trace": true}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for turning tracing on later, got %d", rec.Code)
+	}
+}
+
+func TestRoomAdminAPI(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoomAPI(mux)
+	defer func(key string) { adminAPIKey = key }(adminAPIKey)
+	adminAPIKey = "secret"
+
+	room := hub.GetRoom("admin-room")
+	room.AddClient(&signaling.Client{ID: "admin-alice", Room: room})
+	room.AddClient(&signaling.Client{ID: "admin-bob", Room: room})
+
+	call := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := call("GET", "/api/rooms/admin-room", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the admin key, got %d", rec.Code)
+	}
+	if rec := call("DELETE", "/api/rooms/admin-room", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 closing a room without the admin key, got %d", rec.Code)
+	}
+
+	rec := call("GET", "/api/rooms/admin-room", "secret")
+	var details struct {
+		RoomID       string            `json:"roomId"`
+		Host         string            `json:"host"`
+		CreatedAt    time.Time         `json:"createdAt"`
+		Participants []json.RawMessage `json:"participants"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&details); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || details.RoomID != "admin-room" || details.Host != "admin-alice" || len(details.Participants) != 2 || details.CreatedAt.IsZero() {
+		t.Errorf("Expected the room's details, got %d %+v", rec.Code, details)
+	}
+	if rec := call("GET", "/api/rooms/missing-room", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing room, got %d", rec.Code)
+	}
+
+	if rec := call("DELETE", "/api/rooms/admin-room/clients/admin-carol", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a client not in the room, got %d", rec.Code)
+	}
+	if rec := call("DELETE", "/api/rooms/admin-room/clients/admin-bob?reason=spam", "secret"); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 disconnecting bob, got %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := call("DELETE", "/api/rooms/admin-room", "secret"); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 closing the room, got %d", rec.Code)
+	}
+	if hub.FindRoom("admin-room") != nil {
+		t.Error("Expected the room to be closed")
+	}
+	if rec := call("DELETE", "/api/rooms/admin-room", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 closing it again, got %d", rec.Code)
+	}
+}
+
+func TestRoomMessagesAPI(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoomAPI(mux)
+	defer func(key string) { adminAPIKey = key }(adminAPIKey)
+	adminAPIKey = "secret"
+
+	store := signaling.NewMemoryChatStore(signaling.DefaultChatHistory)
+	hub.SetChatStore(store)
+	for i := range 5 {
+		store.AppendChat(signaling.ChatMessage{RoomID: "history-room", From: "alice", Data: map[string]interface{}{"n": float64(i)}})
+	}
+
+	get := func(path string) (int, []signaling.ChatMessage) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		var response struct {
+			Messages []signaling.ChatMessage `json:"messages"`
+		}
+		json.NewDecoder(rec.Body).Decode(&response)
+		return rec.Code, response.Messages
+	}
+
+	code, messages := get("/api/rooms/history-room/messages?limit=2")
+	if code != http.StatusOK || len(messages) != 2 || messages[0].Seq != 4 || messages[1].Seq != 5 {
+		t.Fatalf("Expected the latest two messages, got %d %+v", code, messages)
+	}
+	if _, earlier := get("/api/rooms/history-room/messages?before=4"); len(earlier) != 3 || earlier[2].Seq != 3 {
+		t.Errorf("Expected the three messages before seq 4, got %+v", earlier)
+	}
+	if code, empty := get("/api/rooms/quiet-room/messages"); code != http.StatusOK || empty == nil || len(empty) != 0 {
+		t.Errorf("Expected an empty history for a room without chat, got %d %+v", code, empty)
+	}
+	if code, _ := get("/api/rooms/history-room/messages?limit=none"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid limit, got %d", code)
+	}
+}
+
+func TestAdminAPIKey(t *testing.T) {
+	mux := http.NewServeMux()
+	registerAdminAPI(mux)
+	defer func(key string) { adminAPIKey = key }(adminAPIKey)
+
+	request := func(key string) int {
+		req := httptest.NewRequest("GET", "/api/admin/traces/missing", nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	adminAPIKey = ""
+	if code := request("anything"); code != http.StatusForbidden {
+		t.Errorf("Expected 403 while the admin API is disabled, got %d", code)
+	}
+
+	adminAPIKey = "secret"
+	if code := request("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong key, got %d", code)
+	}
+	if code := request("secret"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown trace with the right key, got %d", code)
+	}
+}
+
+func TestBridgeMessage(t *testing.T) {
+	mux := http.NewServeMux()
+	registerBridgeAPI(mux)
+	defer func(key string) { bridgeAPIKey = key }(bridgeAPIKey)
+	bridgeAPIKey = "bridge-secret"
+
+	room := hub.GetRoom("bridge-room")
+	client := &signaling.Client{ID: "listener", Room: room}
+	room.AddClient(client)
+
+	post := func(roomID, body string) int {
+		req := httptest.NewRequest("POST", "/api/rooms/"+roomID+"/bridge/messages", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer bridge-secret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post("bridge-room", `{"source": "slack", "author": "bob", "text": "hi"}`); code != http.StatusAccepted {
+		t.Errorf("Expected 202 for a bridged message, got %d", code)
+	}
+	if code := post("bridge-room", `{"source": "slack", "author": "bob"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without text, got %d", code)
+	}
+	if code := post("missing-room", `{"text": "hi"}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing room, got %d", code)
+	}
+}
+
+func TestSCIMProvisioning(t *testing.T) {
+	mux := http.NewServeMux()
+	registerSCIMAPI(mux)
+	defer func(key string, store *storage.AccountStore) { scimAPIKey, accounts = key, store }(scimAPIKey, accounts)
+	scimAPIKey = "scim-secret"
+	accounts, _ = storage.NewAccountStore(filepath.Join(t.TempDir(), "accounts.json"))
+
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer scim-secret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := call("POST", "/scim/v2/Users", `{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "carol@example.com", "name": {"givenName": "Carol"}, "emails": [{"value": "carol@example.com", "primary": true}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 for a new user, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var user scimUser
+	json.NewDecoder(rec.Body).Decode(&user)
+	if user.ID == "" || user.Active == nil || !*user.Active {
+		t.Fatalf("Expected an active user, got %+v", user)
+	}
+	if rec := call("POST", "/scim/v2/Users", `{"userName": "carol@example.com"}`); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a taken user name, got %d", rec.Code)
+	}
+
+	rec = call("POST", "/scim/v2/Groups", `{"displayName": "eng-leads", "members": [{"value": "`+user.ID+`"}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 for a new group, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if groups := accounts.GroupsOf("carol@example.com"); len(groups) != 1 {
+		t.Errorf("Expected Carol to be in eng-leads, got %v", groups)
+	}
+
+	rec = call("GET", `/scim/v2/Users?filter=userName+eq+"carol@example.com"`, "")
+	var list struct {
+		TotalResults int `json:"totalResults"`
+	}
+	json.NewDecoder(rec.Body).Decode(&list)
+	if rec.Code != http.StatusOK || list.TotalResults != 1 {
+		t.Errorf("Expected the filter to find Carol, got %d %+v", rec.Code, list)
+	}
+
+	// Deactivating a signed-in user disconnects them
+	room := hub.GetRoom("scim-room")
+	room.AddClient(&signaling.Client{ID: "carol-laptop", UserID: "carol@example.com", Verified: true, Room: room})
+	rec = call("PATCH", "/scim/v2/Users/"+user.ID, `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "Replace", "path": "active", "value": "False"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for the deactivation, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !accounts.Deprovisioned("carol@example.com") || len(room.GetClients()) != 0 {
+		t.Errorf("Expected Carol to be deprovisioned and disconnected, got %d clients", len(room.GetClients()))
+	}
+
+	if rec := call("DELETE", "/scim/v2/Users/"+user.ID, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 for the deletion, got %d", rec.Code)
+	}
+	if rec := call("GET", "/scim/v2/Users/"+user.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted user, got %d", rec.Code)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	mux := http.NewServeMux()
+	registerTokenAPI(mux)
+	registerRecordingAPI(mux)
+	registerRoomAPI(mux)
+	defer func(key string, store *storage.TokenStore) { adminAPIKey, apiTokens = key, store }(adminAPIKey, apiTokens)
+	adminAPIKey = "secret"
+	apiTokens, _ = storage.NewTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+
+	call := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := call("POST", "/api/tokens", "secret", `{"name": "archiver", "scopes": ["recordings:read"], "ttl": "24h"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 for a new token, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	json.NewDecoder(rec.Body).Decode(&created)
+
+	// The recordings store is disabled, so an authorized request gets 503
+	if rec := call("GET", "/api/recordings", created.Token, ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the token to be accepted for recordings, got %d", rec.Code)
+	}
+	if rec := call("PUT", "/api/rooms/any/watermark", created.Token, `{}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without rooms:write, got %d", rec.Code)
+	}
+	if rec := call("GET", "/api/tokens", created.Token, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for token management without admin, got %d", rec.Code)
+	}
+	if rec := call("GET", "/api/tokens", "secret", ""); strings.Contains(rec.Body.String(), created.Token) {
+		t.Error("Expected token secrets not to be listed")
+	}
+
+	if rec := call("DELETE", "/api/tokens/"+created.ID, "secret", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 for the revocation, got %d", rec.Code)
+	}
+	if rec := call("GET", "/api/recordings", created.Token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a revoked token, got %d", rec.Code)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	defer func(api, rooms *ratelimit.Limiter) { apiRateLimit, roomCreationLimit = api, rooms }(apiRateLimit, roomCreationLimit)
+	apiRateLimit = ratelimit.New(3, time.Minute)
+	roomCreationLimit = ratelimit.New(1, time.Minute)
+	handler := rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "203.0.113.7:5000"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := request("POST", "/api/rooms/import", ""); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Expected the first room creation with the tighter limit reported, got %d %v", rec.Code, rec.Header())
+	}
+	rec := request("POST", "/api/rooms/import", "made-up")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a second room creation from the address to get 429, got %d", rec.Code)
+	}
+
+	request("GET", "/api/recordings", "")
+	request("GET", "/api/recordings", "")
+	if rec := request("GET", "/api/recordings", ""); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the address to run out of requests, got %d", rec.Code)
+	}
+	if rec := request("GET", "/api/recordings", "token-a"); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "3" {
+		t.Errorf("Expected a token to have its own limit, got %d", rec.Code)
+	}
+	if rec := request("GET", "/ws", ""); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+		t.Error("Expected WebSocket requests not to be limited")
+	}
+}
+
+func TestConnectionRateLimit(t *testing.T) {
+	defer func(limit *ratelimit.Limiter) { connectionRateLimit = limit }(connectionRateLimit)
+	connectionRateLimit = ratelimit.New(1, time.Minute)
+
+	connect := func(address string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/ws?roomId=limited", nil)
+		req.RemoteAddr = address
+		rec := httptest.NewRecorder()
+		handleWebSocket(rec, req)
+		return rec
+	}
+
+	if rec := connect("203.0.113.8:5000"); rec.Code == http.StatusTooManyRequests {
+		t.Fatal("Expected the first connection to pass the limit")
+	}
+	rec := connect("203.0.113.8:5001")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a second connection from the address to get 429, got %d", rec.Code)
+	}
+	if rec := connect("203.0.113.9:5000"); rec.Code == http.StatusTooManyRequests {
+		t.Error("Expected other addresses to have their own limit")
+	}
+	if rec := connect("[::ffff:203.0.113.9]:5001"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected an IPv4-mapped address to count as IPv4, got %d", rec.Code)
+	}
+
+	// IPv6 clients are limited by their /64
+	if rec := connect("[2001:db8:1:1::1]:5000"); rec.Code == http.StatusTooManyRequests {
+		t.Fatal("Expected the first IPv6 connection to pass the limit")
+	}
+	if rec := connect("[2001:db8:1:1::2]:5000"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected another address of the /64 to share its limit, got %d", rec.Code)
+	}
+	if rec := connect("[2001:db8:1:2::1]:5000"); rec.Code == http.StatusTooManyRequests {
+		t.Error("Expected another /64 to have its own limit")
+	}
+}
+
+func TestIdempotentRoomCreation(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoomAPI(mux)
+
+	create := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/rooms", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := create("retry-1", `{"settings": {"profile": "low-power"}}`)
+	if first.Code != http.StatusCreated || first.Header().Get("Location") == "" {
+		t.Fatalf("Expected 201 with a Location, got %d: %s", first.Code, first.Body.String())
+	}
+	var room signaling.RoomSnapshot
+	json.NewDecoder(strings.NewReader(first.Body.String())).Decode(&room)
+	if !strings.HasPrefix(room.ID, "room-") || room.Settings.Profile != signaling.ProfileLowPower {
+		t.Errorf("Expected a generated low-power room, got %+v", room)
+	}
+
+	// A retry gets the same room instead of a second one
+	retry := create("retry-1", `{"settings": {"profile": "low-power"}}`)
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected the first response replayed, got %d: %s", retry.Code, retry.Body.String())
+	}
+	if rec := create("retry-1", `{"id": "other"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a reused key, got %d", rec.Code)
+	}
+	if rec := create("", `{"id": "`+room.ID+`"}`); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 without a key for an existing room, got %d", rec.Code)
+	}
+}
+
+func TestBulkOperations(t *testing.T) {
+	mux := http.NewServeMux()
+	registerBulkAPI(mux)
+	defer func(key string) { adminAPIKey = key }(adminAPIKey)
+	adminAPIKey = "secret"
+
+	call := func(path, body string) bulkResponse {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 from %s, got %d: %s", path, rec.Code, rec.Body.String())
+		}
+		var response bulkResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		return response
+	}
+
+	hub.GetRoom("bulk-existing")
+	created := call("/api/bulk/rooms", `{"rooms": [{"id": "bulk-1"}, {"id": "bulk-existing"}, {"id": "bulk-2", "settings": {"profile": "low-power"}}]}`)
+	if created.Succeeded != 2 || created.Failed != 1 || created.Results[1].Status != http.StatusConflict {
+		t.Errorf("Expected the existing room to fail alone, got %+v", created)
+	}
+	if room := created.Results[2].Room; room == nil || room.Settings.Profile != signaling.ProfileLowPower {
+		t.Errorf("Expected the second room's settings to be applied, got %+v", room)
+	}
+
+	var invited []signaling.Event
+	hub.OnEvent(func(event signaling.Event) {
+		if event.Type == signaling.EventRoomInvite {
+			invited = append(invited, event)
+		}
+	})
+	invites := call("/api/bulk/invites", `{"invites": [{"roomId": "bulk-1", "email": "ada@school.edu"}, {"roomId": "missing", "userId": "bob"}, {"roomId": "bulk-1"}]}`)
+	if invites.Succeeded != 1 || invites.Results[1].Status != http.StatusNotFound || invites.Results[2].Status != http.StatusBadRequest {
+		t.Errorf("Expected one invite to go out, got %+v", invites)
+	}
+	if len(invited) != 1 || invited[0].Data["email"] != "ada@school.edu" {
+		t.Errorf("Expected a room-invite event for ada, got %+v", invited)
+	}
+
+	closed := call("/api/bulk/rooms/close", `{"rooms": ["bulk-1", "bulk-2", "bulk-1"]}`)
+	if closed.Succeeded != 2 || closed.Results[2].Status != http.StatusNotFound || hub.FindRoom("bulk-1") != nil {
+		t.Errorf("Expected both rooms closed once, got %+v", closed)
+	}
+}
+
+func TestPrewarmRoom(t *testing.T) {
+	mux := http.NewServeMux()
+	registerPrewarmAPI(mux)
+	defer func(key string, media *sfu.SFU) { adminAPIKey, mediaSFU = key, media }(adminAPIKey, mediaSFU)
+	adminAPIKey = "secret"
+	mediaSFU, _ = sfu.New(sfu.Config{MaxSessions: 100})
+	hub.OnRoomTransition(releaseClosedPrewarm)
+
+	call := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/rooms/keynote/prewarm", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := call("PUT", `{"expectedParticipants": 500}`); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 beyond the node's capacity, got %d", rec.Code)
+	}
+	if hub.FindRoom("keynote") != nil {
+		t.Error("Expected no room when the node can't take the event")
+	}
+
+	rec := call("PUT", `{"expectedParticipants": 80, "startsAt": "2030-01-01T09:00:00Z"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var report prewarmReport
+	json.NewDecoder(rec.Body).Decode(&report)
+	if !report.Ready || report.SFUReserved != 80 || report.Capacity.Available != 20 || hub.FindRoom("keynote") == nil {
+		t.Errorf("Expected a ready room with 80 reserved sessions, got %+v", report)
+	}
+
+	hub.CloseRoom("keynote", "event over")
+	if rec := call("GET", ""); rec.Code != http.StatusNotFound || mediaSFU.Reservation("keynote") != 0 {
+		t.Errorf("Expected closing the room to release it, got %d", rec.Code)
+	}
+}
+
+func TestAccessTokens(t *testing.T) {
+	mux := http.NewServeMux()
+	registerAccessTokenAPI(mux)
+	mux.HandleFunc("/ws", handleWebSocket)
+	defer func(key string) { adminAPIKey = key }(adminAPIKey)
+	defer func(protocols []string) {
+		accessTokenSecret, accessVerifier, upgrader.Subprotocols = nil, nil, protocols
+	}(upgrader.Subprotocols)
+	adminAPIKey = "secret"
+	if err := enableAccessTokens("signing-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	mint := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/token", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := mint(`{"userId": "alice", "roomId": "standup", "role": "owner"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown role, got %d", rec.Code)
+	}
+	rec := mint(`{"userId": "alice", "roomId": "token-room", "role": "host", "ttl": "10m"}`)
+	var minted struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(rec.Body).Decode(&minted)
+	if rec.Code != http.StatusCreated || minted.Token == "" {
+		t.Fatalf("Expected a token, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Missing, tampered and other rooms' tokens are refused before the upgrade
+	for name, test := range map[string]struct {
+		query  string
+		status int
+	}{
+		"missing":     {"roomId=token-room", http.StatusUnauthorized},
+		"tampered":    {"access_token=" + minted.Token + "x", http.StatusUnauthorized},
+		"wrong room":  {"roomId=other&access_token=" + minted.Token, http.StatusForbidden},
+		"wrong token": {"access_token=not.a.token", http.StatusUnauthorized},
+		"borrowed":    {"clientId=bob&access_token=" + minted.Token, http.StatusForbidden},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/ws?"+test.query, nil))
+		if rec.Code != test.status {
+			t.Errorf("Expected %d for a %s token, got %d", test.status, name, rec.Code)
+		}
+	}
+
+	// Browsers pass the token as a subprotocol
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	dialer := websocket.Dialer{Subprotocols: []string{accessTokenProtocol, minted.Token}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Expected the token to be accepted, got %v", err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != accessTokenProtocol {
+		t.Errorf("Expected the %s subprotocol to be selected, got %q", accessTokenProtocol, conn.Subprotocol())
+	}
+	var welcome signaling.Message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&welcome); err != nil || welcome.Type != "welcome" {
+		t.Fatalf("Expected a welcome, got %+v, %v", welcome, err)
+	}
+	if welcome.Data["roomId"] != "token-room" || welcome.Data["clientId"] != "alice" || welcome.Data["isHost"] != true {
+		t.Errorf("Expected alice to host token-room, got %+v", welcome.Data)
+	}
+}
+
+func TestRoomFull(t *testing.T) {
+	hub.GetRoomWithSettings("capped", func(settings *signaling.RoomSettings) {
+		settings.MaxParticipants = 1
+	})
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?roomId=capped&clientId="
+
+	first, _, err := websocket.DefaultDialer.Dial(url+"alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	first.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var welcome signaling.Message
+	if err := first.ReadJSON(&welcome); err != nil || welcome.Type != "welcome" {
+		t.Fatalf("Expected alice to be welcomed, got %+v, %v", welcome, err)
+	}
+
+	second, _, err := websocket.DefaultDialer.Dial(url+"bob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var full, denied signaling.Message
+	if err := second.ReadJSON(&full); err != nil || full.Type != "room-full" || full.Data["maxParticipants"] != float64(1) {
+		t.Fatalf("Expected room-full with the limit, got %+v, %v", full, err)
+	}
+	if err := second.ReadJSON(&denied); err != nil || denied.Type != "join-denied" || denied.Data["reason"] != "full" {
+		t.Errorf("Expected join-denied, got %+v, %v", denied, err)
+	}
+	if _, _, err := second.ReadMessage(); !websocket.IsCloseError(err, signaling.CloseJoinDenied) {
+		t.Errorf("Expected the socket to be closed, got %v", err)
+	}
+}
+
+func TestRoomPassword(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoomAPI(mux)
+	req := httptest.NewRequest("POST", "/api/rooms", strings.NewReader(`{"id": "board", "password": "s3cret"}`))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated || strings.Contains(rec.Body.String(), "passwordHash") {
+		t.Fatalf("Expected 201 without the password hash, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?roomId=board&clientId="
+	dial := func(query string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(url+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	expect := func(conn *websocket.Conn, msgType string) signaling.Message {
+		t.Helper()
+		var msg signaling.Message
+		if err := conn.ReadJSON(&msg); err != nil || msg.Type != msgType {
+			t.Fatalf("Expected %s, got %+v, %v", msgType, msg, err)
+		}
+		return msg
+	}
+
+	// The password is given in the URL
+	alice := dial("alice&password=s3cret")
+	defer alice.Close()
+	expect(alice, "welcome")
+	wrong := dial("mallory&password=guess")
+	defer wrong.Close()
+	if denied := expect(wrong, "join-denied"); denied.Data["reason"] != "password" {
+		t.Errorf("Expected join-denied for the wrong password, got %+v", denied)
+	}
+
+	// Or in the join message, once the server asks for it
+	bob := dial("bob")
+	defer bob.Close()
+	expect(bob, "password-required")
+	bob.WriteJSON(&signaling.Message{Type: "join", Data: map[string]interface{}{"password": "s3cret"}})
+	expect(bob, "welcome")
+	carol := dial("carol")
+	defer carol.Close()
+	expect(carol, "password-required")
+	carol.WriteJSON(&signaling.Message{Type: "join", Data: map[string]interface{}{"password": "guess"}})
+	if denied := expect(carol, "join-denied"); denied.Data["reason"] != "password" {
+		t.Errorf("Expected join-denied for the wrong password, got %+v", denied)
+	}
+}
+
+func TestResumeConnection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?"
+	dial := func(query string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(url+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	// await reads messages until one of each type arrived
+	await := func(conn *websocket.Conn, types ...string) map[string]signaling.Message {
+		t.Helper()
+		received := make(map[string]signaling.Message)
+		for len(received) < len(types) {
+			var msg signaling.Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("Expected %v, got %v after %v", types, err, received)
+			}
+			if slices.Contains(types, msg.Type) {
+				received[msg.Type] = msg
+			}
+		}
+		return received
+	}
+
+	alice := dial("roomId=migration&clientId=alice")
+	token, _ := await(alice, "welcome")["welcome"].Data["resumeToken"].(string)
+	if token == "" {
+		t.Fatal("Expected a resume token in the welcome")
+	}
+	alice.WriteJSON(&signaling.Message{Type: "ready"})
+	bob := dial("roomId=migration&clientId=bob")
+	defer bob.Close()
+	await(bob, "welcome")
+
+	// Alice's network goes away without a close frame; the client keeps its
+	// place and what is sent to it meanwhile
+	alice.UnderlyingConn().Close()
+	if state := await(bob, "peer-reconnecting", "peer-state")["peer-state"]; state.Data["state"] != signaling.ConnectionReconnecting || state.Data["deadline"] == nil {
+		t.Errorf("Expected bob to hear alice is reconnecting, got %+v", state)
+	}
+	for _, participant := range hub.FindRoom("migration").Participants() {
+		if device := participant.Devices[0]; device.ClientID == "alice" && device.Connection != signaling.ConnectionReconnecting {
+			t.Errorf("Expected alice to be reconnecting in the roster, got %q", device.Connection)
+		}
+	}
+	bob.WriteJSON(&signaling.Message{Type: "chat", Data: map[string]interface{}{"text": "still there?"}})
+	hub.FindRoom("migration").SetHost("bob")
+	await(bob, "host-change")
+
+	resumed := dial("resume=" + token)
+	defer resumed.Close()
+	messages := await(resumed, "resumed", "chat", "host-change")
+	if backlog, _ := messages["resumed"].Data["backlog"].(float64); messages["host-change"].Data["isHost"] != false || backlog < 2 {
+		t.Errorf("Expected alice's loss of host status in the backlog, got %+v", messages)
+	}
+	if messages["resumed"].Data["clientId"] != "alice" || messages["resumed"].Data["iceRestart"] != true {
+		t.Errorf("Expected alice to resume and restart ICE, got %+v", messages["resumed"])
+	}
+	if next, _ := messages["resumed"].Data["resumeToken"].(string); next == "" || next == token {
+		t.Errorf("Expected a new resume token, got %q", next)
+	}
+	migrated := await(bob, "peer-migrated", "peer-state")
+	if migrated["peer-migrated"].From != "alice" || migrated["peer-state"].Data["state"] != signaling.ConnectionConnected {
+		t.Errorf("Expected bob to hear alice migrated and is connected again, got %+v", migrated)
+	}
+	if clients := hub.FindRoom("migration").GetClients(); len(clients) != 2 {
+		t.Errorf("Expected both clients still in the room, got %d", len(clients))
+	}
+
+	// Tokens resume a client once
+	again := dial("resume=" + token)
+	defer again.Close()
+	if denied := await(again, "join-denied")["join-denied"]; denied.Data["reason"] != "resume" {
+		t.Errorf("Expected a used token to be refused, got %+v", denied)
+	}
+
+	// Clients that close the connection themselves leave right away
+	bob.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	left := await(resumed, "user-left", "peer-state")
+	if left["user-left"].From != "bob" || left["peer-state"].Data["state"] != signaling.ConnectionLeft {
+		t.Errorf("Expected bob to leave, got %+v", left)
+	}
+}
+
+func TestDisplayNames(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?roomId=named&clientId="
+	dial := func(query string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(url+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	await := func(conn *websocket.Conn, msgType string) signaling.Message {
+		t.Helper()
+		for {
+			var msg signaling.Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("Expected %s, got %v", msgType, err)
+			}
+			if msg.Type == msgType {
+				return msg
+			}
+		}
+	}
+
+	alice := dial("alice&displayName=%20Alice%20Liddell&metadata=%7B%22team%22%3A%22blue%22%7D")
+	defer alice.Close()
+	if welcome := await(alice, "welcome"); welcome.Data["displayName"] != "Alice Liddell" {
+		t.Errorf("Expected alice's trimmed display name in the welcome, got %+v", welcome.Data)
+	}
+
+	bob := dial("bob&displayName=Bob&avatarUrl=https%3A%2F%2Fexample.com%2Fbob.png")
+	defer bob.Close()
+	joined := await(alice, "user-joined")
+	if joined.Data["displayName"] != "Bob" || joined.Data["avatarUrl"] != "https://example.com/bob.png" {
+		t.Errorf("Expected bob's display name and avatar in user-joined, got %+v", joined.Data)
+	}
+	list := await(bob, "user-list")
+	participants, _ := list.Data["participants"].([]interface{})
+	found := false
+	for _, participant := range participants {
+		devices, _ := participant.(map[string]interface{})["devices"].([]interface{})
+		for _, device := range devices {
+			device := device.(map[string]interface{})
+			if device["clientId"] == "alice" {
+				metadata, _ := device["metadata"].(map[string]interface{})
+				found = device["displayName"] == "Alice Liddell" && metadata["team"] == "blue"
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected alice's display name and metadata in bob's user list, got %+v", participants)
+	}
+
+	// The join message may change them
+	bob.WriteJSON(&signaling.Message{Type: "join", Data: map[string]interface{}{"displayName": "Robert"}})
+	if joined := await(alice, "user-joined"); joined.Data["displayName"] != "Robert" || joined.Data["avatarUrl"] != "https://example.com/bob.png" {
+		t.Errorf("Expected bob's new display name and old avatar, got %+v", joined.Data)
+	}
+	bob.WriteJSON(&signaling.Message{Type: "join", Data: map[string]interface{}{"metadata": "blue"}})
+	if refused := await(bob, "error"); refused.Data["code"] != signaling.ErrorInvalid || refused.Data["type"] != "join" {
+		t.Errorf("Expected metadata that isn't an object to be refused, got %+v", refused.Data)
+	}
+
+	for _, query := range []string{
+		"carol&avatarUrl=javascript%3Aalert(1)",
+		"carol&metadata=%5B1%5D",
+		"carol&displayName=" + strings.Repeat("x", 65),
+	} {
+		if _, resp, err := websocket.DefaultDialer.Dial(url+query, nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected %s to be refused with 400, got %v", query, err)
+		}
+	}
+}
+
+func TestProtobufSubprotocol(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?roomId=binary&clientId="
+
+	dialer := websocket.Dialer{Subprotocols: []string{signaling.ProtobufSubprotocol}}
+	alice, _, err := dialer.Dial(url+"alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	if alice.Subprotocol() != signaling.ProtobufSubprotocol {
+		t.Fatalf("Expected the %s subprotocol to be selected, got %q", signaling.ProtobufSubprotocol, alice.Subprotocol())
+	}
+	alice.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// await reads binary frames until a message of the type arrives
+	await := func(msgType string) *signaling.Message {
+		t.Helper()
+		for {
+			frameType, frame, err := alice.ReadMessage()
+			if err != nil || frameType != websocket.BinaryMessage {
+				t.Fatalf("Expected a binary frame with %s, got type %d, %v", msgType, frameType, err)
+			}
+			messages, err := signaling.ProtobufCodec.Decode(frame)
+			if err != nil {
+				t.Fatalf("Expected a protobuf frame, got %v", err)
+			}
+			for _, msg := range messages {
+				if msg.Type == msgType {
+					return msg
+				}
+			}
+		}
+	}
+	await("welcome")
+	ready, _ := signaling.ProtobufCodec.Encode([]*signaling.Message{{Type: "ready"}})
+	alice.WriteMessage(websocket.BinaryMessage, ready)
+
+	// JSON and binary clients talk to each other
+	bob, _, err := websocket.DefaultDialer.Dial(url+"bob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+	bob.WriteJSON(&signaling.Message{Type: "ready"})
+	bob.WriteJSON(&signaling.Message{Type: "chat", Data: map[string]interface{}{"text": "hello", "count": 2}})
+	if chat := await("chat"); chat.From != "bob" || chat.Data["text"] != "hello" || chat.Data["count"] != float64(2) {
+		t.Errorf("Expected bob's chat as protobuf, got %+v", chat)
+	}
+
+	chat, _ := signaling.ProtobufCodec.Encode([]*signaling.Message{{Type: "chat", Data: map[string]interface{}{"text": "hi"}}})
+	alice.WriteMessage(websocket.BinaryMessage, chat)
+	bob.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg signaling.Message
+		if err := bob.ReadJSON(&msg); err != nil {
+			t.Fatalf("Expected alice's chat as JSON, got %v", err)
+		}
+		if msg.Type == "chat" {
+			if msg.From != "alice" || msg.Data["text"] != "hi" {
+				t.Errorf("Expected alice's chat, got %+v", msg)
+			}
+			break
+		}
+	}
+}
+
+func TestBinaryAppData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?roomId=whiteboard&clientId="
+
+	// appFrame lays out the header of application data by hand, as a
+	// client would
+	appFrame := func(appType, from, to string, payload []byte) []byte {
+		frame := []byte{0}
+		for _, field := range []string{appType, from, to} {
+			frame = append(append(frame, byte(len(field))), field...)
+		}
+		return append(frame, payload...)
+	}
+	dial := func(clientID string, dialer *websocket.Dialer) *websocket.Conn {
+		t.Helper()
+		conn, _, err := dialer.Dial(url+clientID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	// awaitBinary skips the signaling messages until application data
+	// arrives
+	awaitBinary := func(conn *websocket.Conn) []byte {
+		t.Helper()
+		for {
+			frameType, frame, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("Expected application data, got %v", err)
+			}
+			if frameType == websocket.BinaryMessage && len(frame) > 0 && frame[0] == 0 {
+				return frame
+			}
+		}
+	}
+
+	alice := dial("alice", websocket.DefaultDialer)
+	defer alice.Close()
+	alice.WriteJSON(&signaling.Message{Type: "ready"})
+	bob := dial("bob", &websocket.Dialer{Subprotocols: []string{signaling.ProtobufSubprotocol}})
+	defer bob.Close()
+	ready, _ := signaling.ProtobufCodec.Encode([]*signaling.Message{{Type: "ready"}})
+	bob.WriteMessage(websocket.BinaryMessage, ready)
+
+	// The server fills in the sender, whatever the header says
+	stroke := []byte{1, 2, 3, 0, 255}
+	alice.WriteMessage(websocket.BinaryMessage, appFrame("whiteboard", "mallory", "", stroke))
+	if frame, expected := awaitBinary(bob), appFrame("whiteboard", "alice", "", stroke); !bytes.Equal(frame, expected) {
+		t.Errorf("Expected bob to get %v, got %v", expected, frame)
+	}
+	bob.WriteMessage(websocket.BinaryMessage, appFrame("cursor", "", "alice", []byte{42}))
+	if frame, expected := awaitBinary(alice), appFrame("cursor", "bob", "alice", []byte{42}); !bytes.Equal(frame, expected) {
+		t.Errorf("Expected alice to get %v, got %v", expected, frame)
+	}
+
+	// Application data can't come as JSON, nor with a broken header
+	alice.WriteJSON(&signaling.Message{Type: signaling.AppDataType, Data: map[string]interface{}{"type": "whiteboard"}})
+	alice.WriteMessage(websocket.BinaryMessage, []byte{0, 10, 'w'})
+	for _, code := range []string{signaling.ErrorInvalid, signaling.ErrorMalformed} {
+		for {
+			var msg signaling.Message
+			if err := alice.ReadJSON(&msg); err != nil {
+				t.Fatalf("Expected a %s error, got %v", code, err)
+			}
+			if msg.Type == "error" {
+				if msg.Data["code"] != code {
+					t.Errorf("Expected a %s error, got %+v", code, msg)
+				}
+				break
+			}
+		}
+	}
+}
+
+func TestMessageSizeLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+	alice, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?roomId=limits&clientId=alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	alice.SetReadDeadline(time.Now().Add(5 * time.Second))
+	alice.WriteJSON(&signaling.Message{Type: "ready"})
+	nextError := func() signaling.Message {
+		t.Helper()
+		for {
+			var msg signaling.Message
+			if err := alice.ReadJSON(&msg); err != nil {
+				t.Fatalf("Expected an error, got %v", err)
+			}
+			if msg.Type == "error" {
+				return msg
+			}
+		}
+	}
+
+	// Chat is kept small, while offers over the default limit get through
+	alice.WriteJSON(&signaling.Message{Type: "chat", Data: map[string]interface{}{"text": strings.Repeat("a", 5000)}})
+	if msg := nextError(); msg.Data["code"] != signaling.ErrorTooLarge || msg.Data["type"] != "chat" {
+		t.Errorf("Expected the chat to be too large, got %+v", msg)
+	}
+	alice.WriteJSON(&signaling.Message{Type: "offer", To: "nobody", Data: map[string]interface{}{"sdp": strings.Repeat("a", 20000)}})
+	if msg := nextError(); msg.Data["code"] != signaling.ErrorNotFound {
+		t.Errorf("Expected the offer to pass the size check, got %+v", msg)
+	}
+
+	recorder := httptest.NewRecorder()
+	handleCapabilities(recorder, httptest.NewRequest(http.MethodGet, "/api/capabilities", nil))
+	var capabilities struct {
+		MessageLimits signaling.MessageLimits `json:"messageLimits"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&capabilities); err != nil {
+		t.Fatal(err)
+	}
+	if limits := capabilities.MessageLimits; limits.Default != 10000 || limits.Types["chat"] != 4000 || limits.Types["offer"] != 65536 {
+		t.Errorf("Expected the size limits in the capabilities, got %+v", limits)
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	defer util.SetModuleLevels(nil)
+
+	request := httptest.NewRequest(http.MethodPut, "/api/admin/log-levels", strings.NewReader(`{"modules": {"signaling": "debug", "sfu": "loud"}}`))
+	recorder := httptest.NewRecorder()
+	handleSetLogLevels(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an invalid level to be refused, got %d", recorder.Code)
+	}
+	if _, modules := util.LogLevels(); len(modules) != 0 {
+		t.Fatalf("Expected nothing changed by a refused request, got %v", modules)
+	}
+
+	request = httptest.NewRequest(http.MethodPut, "/api/admin/log-levels", strings.NewReader(`{"modules": {"signaling": "debug", "sfu": "warn"}}`))
+	recorder = httptest.NewRecorder()
+	handleSetLogLevels(recorder, request)
+	var levels logLevels
+	if err := json.NewDecoder(recorder.Body).Decode(&levels); err != nil {
+		t.Fatal(err)
+	}
+	if levels.Modules["signaling"] != util.LevelDebug || levels.Modules["sfu"] != util.LevelWarn {
+		t.Fatalf("Expected the module levels set, got %+v", levels)
+	}
+
+	request = httptest.NewRequest(http.MethodPut, "/api/admin/log-levels", strings.NewReader(`{"modules": {"sfu": ""}}`))
+	recorder = httptest.NewRecorder()
+	handleSetLogLevels(recorder, request)
+	if _, modules := util.LogLevels(); len(modules) != 1 || modules["signaling"] != util.LevelDebug {
+		t.Errorf("Expected sfu back on the global level, got %v", modules)
+	}
+}
+
+func TestClientConfigAPI(t *testing.T) {
+	defer hub.SetClientConfig(signaling.ClientConfig{})
+
+	request := httptest.NewRequest(http.MethodPut, "/api/admin/client-config", strings.NewReader(`{"bitrate": {"maxBitrate": -1}}`))
+	recorder := httptest.NewRecorder()
+	handleSetClientConfig(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a negative bitrate to be refused, got %d", recorder.Code)
+	}
+
+	request = httptest.NewRequest(http.MethodPut, "/api/admin/client-config", strings.NewReader(`{"features": {"reactions": false}, "bitrate": {"audioOnly": true}}`))
+	recorder = httptest.NewRecorder()
+	handleSetClientConfig(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the configuration to be accepted, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	handleGetClientConfig(recorder, httptest.NewRequest(http.MethodGet, "/api/admin/client-config", nil))
+	var response clientConfigResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Version == 0 || response.Config.Bitrate == nil || !response.Config.Bitrate.AudioOnly || response.Config.Features["reactions"] {
+		t.Errorf("Expected the configuration just set, got %+v", response)
+	}
+}
+
+// writeCertificate writes a self-signed certificate for name and its key
+func writeCertificate(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "old.example.com")
+
+	server := &http.Server{Addr: ":8443"}
+	if _, err := configureTLS(server, config.TLSConfig{CertFile: certFile, KeyFile: "missing.pem"}); err == nil {
+		t.Error("Expected a missing key to fail at startup")
+	}
+	redirect, err := configureTLS(server, config.TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, _ := server.TLSConfig.GetCertificate(nil)
+	if certificate.Leaf == nil || certificate.Leaf.Subject.CommonName != "old.example.com" {
+		t.Fatalf("Expected the certificate from the files, got %+v", certificate.Leaf)
+	}
+
+	// A renewed certificate is picked up on the next check
+	loader := &certificateLoader{certFile: certFile, keyFile: keyFile}
+	loader.load()
+	writeCertificate(t, dir, "new.example.com")
+	os.Chtimes(certFile, time.Now(), time.Now().Add(time.Minute))
+	loader.checked = time.Time{}
+	if certificate, _ := loader.getCertificate(nil); certificate.Leaf.Subject.CommonName != "new.example.com" {
+		t.Errorf("Expected the renewed certificate, got %s", certificate.Leaf.Subject.CommonName)
+	}
+
+	recorder := httptest.NewRecorder()
+	redirect.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com:80/room?id=1", nil))
+	if location := recorder.Header().Get("Location"); recorder.Code != http.StatusMovedPermanently || location != "https://example.com:8443/room?id=1" {
+		t.Errorf("Expected a redirect to the HTTPS port, got %d %s", recorder.Code, location)
+	}
+	for host, want := range map[string]string{"[2001:db8::1]": "https://[2001:db8::1]:8443/", "[2001:db8::1]:80": "https://[2001:db8::1]:8443/"} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Host = host
+		recorder := httptest.NewRecorder()
+		redirect.ServeHTTP(recorder, request)
+		if location := recorder.Header().Get("Location"); location != want {
+			t.Errorf("Expected %s redirected to %s, got %s", host, want, location)
+		}
+	}
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Host = "[2001:db8::1]"
+	redirectToHTTPS(":443").ServeHTTP(recorder, request)
+	if location := recorder.Header().Get("Location"); location != "https://[2001:db8::1]/" {
+		t.Errorf("Expected the IPv6 host kept in brackets on the default port, got %s", location)
+	}
+}
+
+func TestUsageAPI(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	handleUsage(recorder, httptest.NewRequest(http.MethodGet, "/api/usage", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected usage to be unavailable without metering, got %d", recorder.Code)
+	}
+
+	room := hub.GetRoomWithSettings("metered", func(settings *signaling.RoomSettings) { settings.Tenant = "acme" })
+	defer hub.CloseRoom(room.ID, "test")
+	room.AddClient(&signaling.Client{ID: "metered-alice", Room: room})
+	if attribution := attributeTURNClient("metered-alice"); attribution.Room != "metered" || attribution.Tenant != "acme" {
+		t.Errorf("Expected alice's relays billed to the tenant of alice's room, got %+v", attribution)
+	}
+
+	turnMeter = turnserver.NewMeter(attributeTURNClient, nil)
+	defer func() { turnMeter = nil }()
+	turnMeter.Record(turnserver.Attribution{Room: "metered", Tenant: "acme"}, 100, 40)
+	turnMeter.Record(turnserver.Attribution{Room: "other", Tenant: "acme"}, 10, 4)
+	turnMeter.Record(turnserver.Attribution{Room: "elsewhere", Tenant: "globex"}, 1, 1)
+
+	recorder = httptest.NewRecorder()
+	handleUsage(recorder, httptest.NewRequest(http.MethodGet, "/api/usage?tenant=acme", nil))
+	var response struct {
+		Relay struct {
+			Rooms   []turnserver.Usage `json:"rooms"`
+			Tenants []tenantUsage      `json:"tenants"`
+		} `json:"relay"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Relay.Rooms) != 2 || len(response.Relay.Tenants) != 1 || response.Relay.Tenants[0].BytesSent != 110 {
+		t.Errorf("Expected acme's two rooms and their total, got %+v", response.Relay)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/storage"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Most items a bulk request may carry
+const maxBulkItems = 500
+
+// bulkResult is the outcome of one item of a bulk request. Results are in
+// the order of the request's items
+type bulkResult struct {
+	ID     string                  `json:"id"`
+	Status int                     `json:"status"` // HTTP status the item would have got on its own
+	Error  string                  `json:"error,omitempty"`
+	Room   *signaling.RoomSnapshot `json:"room,omitempty"`
+}
+
+// bulkResponse reports every item of a bulk request, so callers can retry
+// just the ones that failed
+type bulkResponse struct {
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []bulkResult `json:"results"`
+}
+
+// add records the outcome of an item
+func (b *bulkResponse) add(result bulkResult) {
+	if result.Status < http.StatusBadRequest {
+		b.Succeeded++
+	} else {
+		b.Failed++
+	}
+	b.Results = append(b.Results, result)
+}
+
+// registerBulkAPI adds the endpoints to provision many rooms at once
+func registerBulkAPI(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/bulk/rooms", requireScope(storage.ScopeRoomsWrite, idempotent(handleBulkCreateRooms)))
+	mux.HandleFunc("POST /api/bulk/rooms/close", requireScope(storage.ScopeRoomsWrite, handleBulkCloseRooms))
+	mux.HandleFunc("POST /api/bulk/invites", requireScope(storage.ScopeRoomsWrite, handleBulkInvites))
+}
+
+// checkBulkSize rejects bulk requests without items or with too many
+func checkBulkSize(w http.ResponseWriter, count int) bool {
+	if count == 0 || count > maxBulkItems {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("between 1 and %d items are required", maxBulkItems))
+		return false
+	}
+	return true
+}
+
+// handleBulkCreateRooms creates the rooms in {"rooms": [{"id", "settings"}]}
+// like POST /api/rooms does, reporting each room's outcome
+func handleBulkCreateRooms(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Rooms []struct {
+			ID       string                  `json:"id"`
+			Settings *signaling.RoomSettings `json:"settings"`
+		} `json:"rooms"`
+	}
+	if err := decodeJSON(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !checkBulkSize(w, len(request.Rooms)) {
+		return
+	}
+
+	response := bulkResponse{Results: make([]bulkResult, 0, len(request.Rooms))}
+	for _, item := range request.Rooms {
+		settings := hub.DefaultSettings()
+		if item.Settings != nil {
+			settings = *item.Settings
+		}
+		if item.ID == "" {
+			item.ID = generateRoomID()
+		}
+		room, err := hub.ImportRoom(signaling.RoomSnapshot{ID: item.ID, Settings: settings})
+		switch {
+		case errors.Is(err, signaling.ErrRoomExists):
+			response.add(bulkResult{ID: item.ID, Status: http.StatusConflict, Error: err.Error()})
+		case err != nil:
+			response.add(bulkResult{ID: item.ID, Status: http.StatusBadRequest, Error: err.Error()})
+		default:
+			snapshot := room.Snapshot()
+			response.add(bulkResult{ID: room.ID, Status: http.StatusCreated, Room: &snapshot})
+		}
+	}
+
+	util.Info("Bulk room creation by %s: %d created, %d failed", r.RemoteAddr, response.Succeeded, response.Failed)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleBulkCloseRooms ends the rooms in {"rooms": [ids], "reason"}
+func handleBulkCloseRooms(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Rooms  []string `json:"rooms"`
+		Reason string   `json:"reason"`
+	}
+	if err := decodeJSON(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !checkBulkSize(w, len(request.Rooms)) {
+		return
+	}
+	if request.Reason == "" {
+		request.Reason = "room closed by an operator"
+	}
+
+	response := bulkResponse{Results: make([]bulkResult, 0, len(request.Rooms))}
+	for _, id := range request.Rooms {
+		if hub.CloseRoom(id, request.Reason) {
+			response.add(bulkResult{ID: id, Status: http.StatusNoContent})
+		} else {
+			response.add(bulkResult{ID: id, Status: http.StatusNotFound, Error: signaling.ErrRoomNotFound.Error()})
+		}
+	}
+
+	util.Info("Bulk room closing by %s: %d closed, %d failed", r.RemoteAddr, response.Succeeded, response.Failed)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleBulkInvites invites everyone in {"invites": [{"roomId", "userId",
+// "name", "email"}]} to their room
+func handleBulkInvites(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Invites []struct {
+			RoomID string `json:"roomId"`
+			signaling.Invitee
+		} `json:"invites"`
+	}
+	if err := decodeJSON(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !checkBulkSize(w, len(request.Invites)) {
+		return
+	}
+
+	response := bulkResponse{Results: make([]bulkResult, 0, len(request.Invites))}
+	for _, item := range request.Invites {
+		err := hub.Invite(item.RoomID, item.Invitee)
+		switch {
+		case errors.Is(err, signaling.ErrRoomNotFound):
+			response.add(bulkResult{ID: item.RoomID, Status: http.StatusNotFound, Error: err.Error()})
+		case err != nil:
+			response.add(bulkResult{ID: item.RoomID, Status: http.StatusBadRequest, Error: err.Error()})
+		default:
+			response.add(bulkResult{ID: item.RoomID, Status: http.StatusAccepted})
+		}
+	}
+
+	util.Info("Bulk invites by %s: %d sent, %d failed", r.RemoteAddr, response.Succeeded, response.Failed)
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// sfuRecorder records rooms in SFU mode with the SFU, one file per track,
+// and stores the tracks as one zip archive per recording
+type sfuRecorder struct{}
+
+// StartRecording starts writing the room's tracks next to the recordings
+func (sfuRecorder) StartRecording(recording signaling.Recording) error {
+	dir, err := recordingStore.TrackDir(recording.ID)
+	if err != nil {
+		return err
+	}
+	return mediaSFU.StartRecording(recording.RoomID, recording.ID, dir)
+}
+
+// StopRecording stops writing and packs the tracks in the background
+func (sfuRecorder) StopRecording(recording signaling.Recording) error {
+	files, err := mediaSFU.StopRecording(recording.ID)
+	if err != nil {
+		return err
+	}
+	go storeCallRecording(recording, files)
+	return nil
+}
+
+// storeCallRecording packs the track files of a call recording into its
+// artifact and removes them
+func storeCallRecording(recording signaling.Recording, files []string) {
+	if dir, err := recordingStore.TrackDir(recording.ID); err == nil {
+		defer os.RemoveAll(dir)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(zipFiles(writer, files))
+	}()
+	artifact, err := recordingStore.WriteArtifact(recording.ID, "application/zip", reader, maxRecordingSize)
+	reader.Close()
+	if err != nil {
+		util.Error("Error storing recording %s of room %s: %v", recording.ID, recording.RoomID, err)
+		return
+	}
+	hub.CompleteCallRecording(recording, artifact)
+}
+
+// zipFiles writes files into a zip archive under their base names
+func zipFiles(w io.Writer, files []string) error {
+	archive := zip.NewWriter(w)
+	for _, path := range files {
+		entry, err := archive.Create(filepath.Base(path))
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(entry, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+// handleCapabilities describes what clients may rely on before connecting:
+// the WebSocket subprotocols the server speaks and the size limits of the
+// messages it accepts
+func handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"subprotocols":  upgrader.Subprotocols,
+		"messageLimits": signaling.CurrentMessageLimits(),
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/storage"
+)
+
+// Messages a page of chat history holds by default and at most
+const (
+	defaultChatPage = 50
+	maxChatPage     = 200
+)
+
+// newChatStore opens the store CHAT_STORE names for rooms' chat history:
+// memory (the default) keeping CHAT_HISTORY_SIZE messages per room, sql to
+// keep it across restarts and nodes, or none to keep no history
+func newChatStore(kind string) (signaling.ChatStore, error) {
+	switch kind {
+	case "", "memory":
+		size := signaling.DefaultChatHistory
+		if value := os.Getenv("CHAT_HISTORY_SIZE"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				return nil, fmt.Errorf("invalid CHAT_HISTORY_SIZE %q", value)
+			}
+			size = parsed
+		}
+		return signaling.NewMemoryChatStore(size), nil
+	case "sql":
+		driver, dsn := os.Getenv("CHAT_SQL_DRIVER"), os.Getenv("CHAT_SQL_DSN")
+		if driver == "" || dsn == "" {
+			return nil, fmt.Errorf("CHAT_STORE=sql requires CHAT_SQL_DRIVER and CHAT_SQL_DSN")
+		}
+		db, err := sql.Open(driver, dsn)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Ping(); err != nil {
+			return nil, err
+		}
+		return storage.NewSQLChatStore(db, driver)
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown CHAT_STORE %q", kind)
+	}
+}
+
+// handleRoomMessages returns a page of a room's chat history, oldest first.
+// The latest messages come first; passing the seq of the oldest one as
+// before fetches the page ahead of it
+func handleRoomMessages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var before int64
+	if value := query.Get("before"); value != "" {
+		var err error
+		if before, err = strconv.ParseInt(value, 10, 64); err != nil || before < 0 {
+			writeError(w, http.StatusBadRequest, "before must be a sequence number")
+			return
+		}
+	}
+	limit := defaultChatPage
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = min(limit, maxChatPage)
+	}
+
+	roomID := r.PathValue("id")
+	messages, err := hub.ChatHistory(roomID, before, limit)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if messages == nil {
+		messages = []signaling.ChatMessage{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"roomId": roomID, "messages": messages})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// clientConfigResponse is the body returned by the client config endpoints
+type clientConfigResponse struct {
+	Version int                    `json:"version"`
+	Config  signaling.ClientConfig `json:"config"`
+}
+
+// handleGetClientConfig returns the configuration pushed to clients
+func handleGetClientConfig(w http.ResponseWriter, r *http.Request) {
+	config, version := hub.ClientConfig()
+	writeJSON(w, http.StatusOK, clientConfigResponse{Version: version, Config: config})
+}
+
+// handleSetClientConfig replaces the configuration pushed to clients; every
+// client connected to this node gets it in a config-update message
+func handleSetClientConfig(w http.ResponseWriter, r *http.Request) {
+	var config signaling.ClientConfig
+	if err := decodeJSON(w, r, &config); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, err := hub.SetClientConfig(config)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	util.Info("Client configuration changed by %s", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, clientConfigResponse{Version: version, Config: config})
+}
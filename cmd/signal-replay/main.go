@@ -0,0 +1,53 @@
+// Command signal-replay feeds a signal trace recorded by a traced room
+// through a test hub and prints every message the server sends, so
+// negotiation bugs can be reproduced offline
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func main() {
+	asJSON := flag.Bool("json", false, "print deliveries as JSON lines")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-json] trace.jsonl\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening trace: %v\n", err)
+		os.Exit(1)
+	}
+	entries, err := signaling.ReadTrace(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading trace: %v\n", err)
+		os.Exit(1)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	err = signaling.Replay(entries, func(delivery signaling.Delivery) {
+		if *asJSON {
+			encoder.Encode(delivery)
+			return
+		}
+		data, _ := json.Marshal(delivery.Message.Data)
+		fmt.Printf("%10s  %-12s -> %-12s %-14s %s\n",
+			delivery.Offset, delivery.Message.From, delivery.To, delivery.Message.Type, data)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Replay failed: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+// Database drivers the sql state and chat stores can be opened with, as
+// STATE_SQL_DRIVER and CHAT_SQL_DRIVER name them: "sqlite" for a SQLite
+// file, "postgres" for PostgreSQL
+import (
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+// Log of room events; the room events API is disabled when nil
+var eventLog signaling.EventLog
+
+// auditModeration copies moderation from the event log to the audit log
+func auditModeration(event signaling.RoomEvent) {
+	if event.Type != signaling.RoomEventModeration {
+		return
+	}
+	entry := signaling.AuditEntry{
+		Action:   signaling.AuditModeration,
+		RoomID:   event.RoomID,
+		ClientID: event.ClientID,
+		Details:  event.Action,
+	}
+	if event.By != "" {
+		entry.Details += " by " + event.By
+	}
+	if event.Detail != "" {
+		entry.Details = fmt.Sprintf("%s (%s)", entry.Details, event.Detail)
+	}
+	if room := hub.FindRoom(event.RoomID); room != nil {
+		entry.Tenant = room.Settings().Tenant
+	}
+	recordAudit(entry)
+}
+
+// handleRoomEvents returns a room's logged events; ?after= skips those up to
+// a sequence number, so consumers can follow the log
+func handleRoomEvents(w http.ResponseWriter, r *http.Request) {
+	if eventLog == nil {
+		writeError(w, http.StatusServiceUnavailable, "event log is disabled")
+		return
+	}
+	var after int64
+	if value := r.URL.Query().Get("after"); value != "" {
+		var err error
+		if after, err = strconv.ParseInt(value, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "after must be a sequence number")
+			return
+		}
+	}
+	events, err := eventLog.Events(r.PathValue("id"), after)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if events == nil {
+		events = []signaling.RoomEvent{}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// handleReplayRoom returns a room's state rebuilt from its event log
+func handleReplayRoom(w http.ResponseWriter, r *http.Request) {
+	if eventLog == nil {
+		writeError(w, http.StatusServiceUnavailable, "event log is disabled")
+		return
+	}
+	view, err := signaling.ReplayRoom(eventLog, r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if view.Seq == 0 {
+		writeError(w, http.StatusNotFound, "room has no events")
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
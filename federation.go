@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/storage"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// registerChatFederationAPI adds the endpoints to let rooms share one chat
+func registerChatFederationAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/chat-federations", requireScope(storage.ScopeRoomsRead, handleListChatFederations))
+	mux.HandleFunc("PUT /api/chat-federations/{id}", requireScope(storage.ScopeRoomsWrite, handleFederateChat))
+	mux.HandleFunc("DELETE /api/chat-federations/{id}", requireScope(storage.ScopeRoomsWrite, handleDissolveChatFederation))
+}
+
+// handleListChatFederations returns all chat federations
+func handleListChatFederations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, hub.ChatFederations())
+}
+
+// handleFederateChat makes {"rooms": [ids]} share one chat stream
+func handleFederateChat(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Rooms []string `json:"rooms"`
+	}
+	if err := decodeJSON(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	federation, err := hub.FederateChat(r.PathValue("id"), request.Rooms)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	util.Info("Chat federation %s set by %s", federation.ID, r.RemoteAddr)
+	writeJSON(w, http.StatusOK, federation)
+}
+
+// handleDissolveChatFederation stops a federation's rooms from sharing chat
+func handleDissolveChatFederation(w http.ResponseWriter, r *http.Request) {
+	err := hub.DissolveChatFederation(r.PathValue("id"))
+	if errors.Is(err, signaling.ErrFederationNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	util.Info("Chat federation %s dissolved by %s", r.PathValue("id"), r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
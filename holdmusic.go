@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/nikhilsahni7/chat-video-app/pkg/ingest"
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Plays music into the rooms of calls on hold; nil when disabled
+var holdMusic *ingest.HoldMusic
+
+// playHoldMusic is an event handler starting and stopping the music of a
+// call's room as the call is held and resumed
+func playHoldMusic(event signaling.Event) {
+	if holdMusic == nil {
+		return
+	}
+	switch event.Type {
+	case signaling.EventCallHeld:
+		if err := holdMusic.Start(event.RoomID); err != nil {
+			util.Error("Error starting hold music in room %s: %v", event.RoomID, err)
+		}
+	case signaling.EventCallResumed:
+		holdMusic.Stop(event.RoomID)
+	}
+}
+
+// stopClosedHoldMusic is a room hook ending the music of calls that end
+// while on hold
+func stopClosedHoldMusic(room *signaling.Room, transition signaling.RoomTransition) {
+	if transition.To == signaling.RoomClosed && holdMusic != nil {
+		holdMusic.Stop(room.ID)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/storage"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Legal holds; the legal hold API is disabled when nil
+var legalHolds *storage.HoldStore
+
+// registerLegalHoldAPI adds the endpoints to place and release legal holds
+func registerLegalHoldAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/admin/legal-holds", requireAdmin(handleListHolds))
+	mux.HandleFunc("POST /api/admin/legal-holds", requireAdmin(handlePlaceHold))
+	mux.HandleFunc("DELETE /api/admin/legal-holds/{holdId}", requireAdmin(handleReleaseHold))
+}
+
+// handleListHolds returns the active legal holds
+func handleListHolds(w http.ResponseWriter, r *http.Request) {
+	if legalHolds == nil {
+		writeError(w, http.StatusServiceUnavailable, "legal holds are disabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, legalHolds.Holds())
+}
+
+// handlePlaceHold puts a user or room on hold from {"kind", "target", "reason"}
+func handlePlaceHold(w http.ResponseWriter, r *http.Request) {
+	if legalHolds == nil {
+		writeError(w, http.StatusServiceUnavailable, "legal holds are disabled")
+		return
+	}
+	var request struct {
+		Kind   string `json:"kind"`
+		Target string `json:"target"`
+		Reason string `json:"reason"`
+	}
+	if err := decodeJSON(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hold, err := legalHolds.Place(request.Kind, request.Target, request.Reason)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	util.Info("Legal hold %s placed on %s %s by %s", hold.ID, hold.Kind, hold.Target, r.RemoteAddr)
+	recordAudit(signaling.AuditEntry{
+		Action:  signaling.AuditHoldPlaced,
+		Details: holdDetails(hold),
+	})
+	writeJSON(w, http.StatusCreated, hold)
+}
+
+// handleReleaseHold lifts a legal hold
+func handleReleaseHold(w http.ResponseWriter, r *http.Request) {
+	if legalHolds == nil {
+		writeError(w, http.StatusServiceUnavailable, "legal holds are disabled")
+		return
+	}
+	hold, err := legalHolds.Release(r.PathValue("holdId"))
+	if errors.Is(err, storage.ErrHoldNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		util.Error("Error releasing legal hold: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not release legal hold")
+		return
+	}
+
+	util.Info("Legal hold %s on %s %s released by %s", hold.ID, hold.Kind, hold.Target, r.RemoteAddr)
+	recordAudit(signaling.AuditEntry{
+		Action:  signaling.AuditHoldReleased,
+		Details: holdDetails(hold),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refuseHeldDeletion answers a deletion of something under legal hold with
+// 409 and flags the attempt in the audit log. It reports whether it did
+func refuseHeldDeletion(w http.ResponseWriter, r *http.Request, hold storage.LegalHold, found bool, entry signaling.AuditEntry) bool {
+	if !found {
+		return false
+	}
+	util.Warn("Refused deletion of %s under legal hold %s from %s", entry.Details, hold.ID, r.RemoteAddr)
+	entry.Action = signaling.AuditDeletionBlocked
+	entry.Details = fmt.Sprintf("%s (%s, requested from %s)", entry.Details, holdDetails(hold), r.RemoteAddr)
+	recordAudit(entry)
+	writeError(w, http.StatusConflict, "under legal hold "+hold.ID)
+	return true
+}
+
+// recordAudit appends an entry to the audit log if one is configured
+func recordAudit(entry signaling.AuditEntry) {
+	if auditLog == nil {
+		return
+	}
+	entry.Time = time.Now()
+	if err := auditLog.Append(entry); err != nil {
+		util.Error("Error writing audit entry %s: %v", entry.Action, err)
+	}
+}
+
+// holdDetails describes a hold for the audit log
+func holdDetails(hold storage.LegalHold) string {
+	return fmt.Sprintf("legal hold %s on %s %s", hold.ID, hold.Kind, hold.Target)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/turnserver"
+)
+
+// How often ICE servers are checked unless ICE_HEALTH_INTERVAL says otherwise
+const defaultICEHealthInterval = 30 * time.Second
+
+// runICEHealthChecks checks the ICE servers handed to clients right away and
+// then every interval until ctx is done
+func runICEHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		checkICEServers(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkICEServers probes every configured STUN and TURN URL at once and
+// records the outcomes; URLs that can't be probed are left alone
+func checkICEServers(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, url := range hub.ICEServerURLs() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := turnserver.Probe(ctx, url)
+			if errors.Is(err, turnserver.ErrProbeUnsupported) || ctx.Err() != nil {
+				return
+			}
+			hub.RecordICEServerCheck(url, err)
+		}()
+	}
+	wg.Wait()
+}
+
+// handleICEHealth returns the health of the checked ICE servers
+func handleICEHealth(w http.ResponseWriter, r *http.Request) {
+	servers := hub.ICEServerHealth()
+	if servers == nil {
+		servers = []signaling.ICEServerStatus{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"servers": servers})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// How long responses to requests with an Idempotency-Key are replayed unless
+// IDEMPOTENCY_WINDOW says otherwise
+const defaultIdempotencyWindow = 24 * time.Hour
+
+// Longest Idempotency-Key accepted
+const maxIdempotencyKey = 255
+
+// Responses of idempotent requests, kept in memory
+var idempotencyCache = newIdempotencyStore(defaultIdempotencyWindow)
+
+// idempotentResponse is a stored response, or a request still being handled
+type idempotentResponse struct {
+	fingerprint string // Hash of the request body
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+}
+
+// idempotencyStore keeps the responses to idempotent requests for a window
+type idempotencyStore struct {
+	window    time.Duration
+	responses map[string]*idempotentResponse
+	swept     time.Time
+	mutex     sync.Mutex
+}
+
+// newIdempotencyStore creates a store replaying responses for window
+func newIdempotencyStore(window time.Duration) *idempotencyStore {
+	return &idempotencyStore{window: window, responses: make(map[string]*idempotentResponse)}
+}
+
+// idempotent makes a handler replay its stored response when a request is
+// retried with the same Idempotency-Key, instead of running again. Keys are
+// scoped to the caller's credentials and the endpoint. A key reused with a
+// different body gets 422, and a retry while the first try is still running
+// gets 409. Server errors aren't stored, so they can be retried
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			writeError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		credential := sha256.Sum256([]byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")))
+		scoped := hex.EncodeToString(credential[:8]) + " " + r.Method + " " + r.URL.Path + " " + key
+
+		stored, fresh := idempotencyCache.begin(scoped, fingerprint)
+		switch {
+		case !fresh && stored.fingerprint != fingerprint:
+			writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was used for a different request")
+			return
+		case !fresh && !stored.done:
+			writeError(w, http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+			return
+		case !fresh:
+			util.Debug("Replaying response to %s %s for Idempotency-Key %s", r.Method, r.URL.Path, key)
+			for name, values := range stored.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.status)
+			w.Write(stored.body)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		idempotencyCache.finish(scoped, recorder.status, w.Header().Clone(), recorder.body.Bytes())
+	}
+}
+
+// begin looks up a key, reserving it for this request if it is new
+func (s *idempotencyStore) begin(key, fingerprint string) (idempotentResponse, bool) {
+	now := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sweepLocked(now)
+	if stored, exists := s.responses[key]; exists && now.Before(stored.expires) {
+		return *stored, false
+	}
+	s.responses[key] = &idempotentResponse{fingerprint: fingerprint, expires: now.Add(s.window)}
+	return idempotentResponse{}, true
+}
+
+// finish stores the response to a reserved key. Server errors release the
+// key so the request can be retried
+func (s *idempotencyStore) finish(key string, status int, header http.Header, body []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored := s.responses[key]
+	if stored == nil {
+		return
+	}
+	if status >= http.StatusInternalServerError {
+		delete(s.responses, key)
+		return
+	}
+	stored.done = true
+	stored.status = status
+	stored.header = header
+	stored.body = body
+}
+
+// sweepLocked drops expired responses at most once a minute; the caller
+// must hold the mutex
+func (s *idempotencyStore) sweepLocked(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	s.swept = now
+	for key, stored := range s.responses {
+		if !now.Before(stored.expires) {
+			delete(s.responses, key)
+		}
+	}
+}
+
+// responseRecorder keeps a copy of a response while writing it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status code
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the body
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
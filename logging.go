@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// logLevels is the body of the log level endpoints
+type logLevels struct {
+	Level   string            `json:"level"`
+	Modules map[string]string `json:"modules"`
+}
+
+// handleGetLogLevels returns the current log level and the modules logging
+// at another
+func handleGetLogLevels(w http.ResponseWriter, r *http.Request) {
+	level, modules := util.LogLevels()
+	writeJSON(w, http.StatusOK, logLevels{Level: level, Modules: modules})
+}
+
+// handleSetLogLevels changes log levels at runtime. The level is left alone
+// when empty; modules not named keep theirs, and an empty module level makes
+// the module log at the current level again
+func handleSetLogLevels(w http.ResponseWriter, r *http.Request) {
+	var request logLevels
+	if err := decodeJSON(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if request.Level != "" {
+		if _, err := util.ParseLogLevel(request.Level); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	for module, level := range request.Modules {
+		if module == "" {
+			writeError(w, http.StatusBadRequest, "module names must not be empty")
+			return
+		}
+		if _, err := util.ParseLogLevel(level); level != "" && err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if request.Level != "" {
+		util.SetLogLevel(request.Level)
+	}
+	for module, level := range request.Modules {
+		util.SetModuleLevel(module, level)
+	}
+	level, modules := util.LogLevels()
+	util.Info("Log levels changed by %s: %s %v", r.RemoteAddr, level, modules)
+	writeJSON(w, http.StatusOK, logLevels{Level: level, Modules: modules})
+}
@@ -1,17 +1,33 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/nikhilsahni7/chat-video-app/pkg/alerting"
+	"github.com/nikhilsahni7/chat-video-app/pkg/auth"
+	"github.com/nikhilsahni7/chat-video-app/pkg/config"
+	"github.com/nikhilsahni7/chat-video-app/pkg/ingest"
+	"github.com/nikhilsahni7/chat-video-app/pkg/integrations"
+	"github.com/nikhilsahni7/chat-video-app/pkg/matrix"
+	"github.com/nikhilsahni7/chat-video-app/pkg/metrics"
+	"github.com/nikhilsahni7/chat-video-app/pkg/redis"
+	"github.com/nikhilsahni7/chat-video-app/pkg/sfu"
 	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/storage"
 	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+	"github.com/pion/webrtc/v4"
 )
 
 var (
@@ -19,6 +35,8 @@ var (
 	upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
+		// Clients offering the protobuf subprotocol get binary frames
+		Subprotocols: []string{signaling.ProtobufSubprotocol},
 		CheckOrigin: func(r *http.Request) bool {
 			// Allow all connections for development
 			return true
@@ -33,8 +51,49 @@ var (
 
 	// Create the signaling hub
 	hub = signaling.NewHub()
+
+	// Verifies identity tokens of signed-in users; everyone joins as a guest when nil
+	identityVerifier *auth.Verifier
+
+	// Asks tenants' webhooks whether a client may join; every join is allowed when nil
+	joinAuthorizer *integrations.JoinAuthorizer
+
+	// Maps identity token claims to roles and permissions; roles are unlimited when nil
+	roleMapping *signaling.RoleMapping
+)
+
+// Window used to coalesce messages for clients that opt into batching
+const batchWindow = 20 * time.Millisecond
+
+// How often hosts are sent their room's health score
+const healthInterval = 15 * time.Second
+
+// How often idle rooms and stale clients are swept, and how long a room
+// nobody is in lasts unless ROOM_IDLE_TTL says otherwise
+const (
+	janitorInterval = time.Minute
+	defaultRoomTTL  = 30 * time.Minute
 )
 
+// How long a client joining a password-protected room has to send the
+// password in its join message
+const joinPasswordTimeout = 30 * time.Second
+
+// How often nodes send heartbeats unless CLUSTER_HEARTBEAT says otherwise
+const defaultHeartbeatInterval = 2 * time.Second
+
+// How often metrics are pushed to StatsD
+const metricsInterval = 10 * time.Second
+
+// Rooms with their own metric series by default; larger rooms come first
+const defaultTopRooms = 50
+
+// How often expired recordings and meeting records are purged
+const retentionInterval = time.Hour
+
+// Model asked for meeting summaries unless SUMMARY_MODEL says otherwise
+const defaultSummaryModel = "gpt-4o-mini"
+
 // CORS middleware to allow requests from any origin (for development)
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -44,9 +103,10 @@ func corsMiddleware(next http.Handler) http.Handler {
 			origin = "*"
 		}
 		w.Header().Set("Access-Control-Allow-Origin", origin)
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After, Idempotent-Replayed")
 		w.Header().Set("Access-Control-Max-Age", "86400") // 24 hours
 
 		// Handle preflight requests
@@ -64,12 +124,594 @@ func main() {
 	// Initialize logger
 	util.Init()
 
+	// Load the startup settings from the config file, environment and flags
+	cfg, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		util.Fatal("Invalid configuration: %v", err)
+	}
+	upgrader.ReadBufferSize = cfg.WebSocket.ReadBufferSize
+	upgrader.WriteBufferSize = cfg.WebSocket.WriteBufferSize
+	messageLimits, err := signaling.ParseMessageLimits(cfg.WebSocket.MessageLimits)
+	if err != nil {
+		util.Fatal("Invalid configuration: %v", err)
+	}
+	if err := signaling.Configure(signaling.ConnectionConfig{
+		WriteWait:         cfg.WebSocket.WriteWait,
+		PongWait:          cfg.WebSocket.PongWait,
+		PingPeriod:        cfg.WebSocket.PingPeriod,
+		MaxMessageSize:    cfg.WebSocket.MaxMessageSize,
+		MessageLimits:     messageLimits,
+		SendBuffer:        cfg.WebSocket.SendBuffer,
+		BroadcastBuffer:   cfg.Room.BroadcastBuffer,
+		MaxPendingSignals: cfg.WebSocket.MaxPendingSignals,
+		MessageRate:       cfg.WebSocket.MessageRate,
+		MessageBurst:      cfg.WebSocket.MessageBurst,
+		ResumeWindow:      cfg.WebSocket.ResumeWindow,
+	}); err != nil {
+		util.Fatal("Invalid configuration: %v", err)
+	}
+
+	// Jobs that must run on one node of the cluster, such as retention and
+	// the idle room sweep
+	var singletonJobs []func(ctx context.Context)
+
+	// Apply the default duplicate join policy for new rooms
+	if value := os.Getenv("DUPLICATE_JOIN_POLICY"); value != "" {
+		policy, err := signaling.ParseDuplicateJoinPolicy(value)
+		if err != nil {
+			util.Fatal("Invalid DUPLICATE_JOIN_POLICY: %v", err)
+		}
+		settings := signaling.DefaultRoomSettings()
+		settings.DuplicatePolicy = policy
+		hub.SetDefaultSettings(settings)
+		util.Info("Default duplicate join policy: %s", policy)
+	}
+
+	// Rooms take at most this many participants unless they set their own limit
+	if value := os.Getenv("MAX_PARTICIPANTS"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			util.Fatal("Invalid MAX_PARTICIPANTS: %q", value)
+		}
+		settings := hub.DefaultSettings()
+		settings.MaxParticipants = limit
+		hub.SetDefaultSettings(settings)
+		util.Info("Rooms take at most %d participants by default", limit)
+	}
+
+	// How long 1:1 calls ring before the caller gets call-timeout
+	if value := os.Getenv("CALL_RING_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			util.Fatal("Invalid CALL_RING_TIMEOUT: %q", value)
+		}
+		hub.SetRingTimeout(timeout)
+	}
+
+	// Identity tokens from the SSO provider mark participants as verified
+	if secret, keyFile := os.Getenv("AUTH_JWT_SECRET"), os.Getenv("AUTH_JWT_PUBLIC_KEY_FILE"); secret != "" || keyFile != "" {
+		config := auth.Config{
+			Secret:   []byte(secret),
+			Issuer:   os.Getenv("AUTH_JWT_ISSUER"),
+			Audience: os.Getenv("AUTH_JWT_AUDIENCE"),
+		}
+		if keyFile != "" {
+			key, err := auth.LoadPublicKey(keyFile)
+			if err != nil {
+				util.Fatal("Error loading AUTH_JWT_PUBLIC_KEY_FILE: %v", err)
+			}
+			config.PublicKey = key
+		}
+		verifier, err := auth.NewVerifier(config)
+		if err != nil {
+			util.Fatal("Invalid identity token settings: %v", err)
+		}
+		identityVerifier = verifier
+		util.Info("Identity tokens enabled (issuer %q)", config.Issuer)
+	}
+
+	// Access tokens minted through POST /api/token gate every WebSocket
+	if secret := os.Getenv("ACCESS_TOKEN_SECRET"); secret != "" {
+		if err := enableAccessTokens(secret); err != nil {
+			util.Fatal("Invalid ACCESS_TOKEN_SECRET: %v", err)
+		}
+		util.Info("Access tokens required on /ws")
+	}
+
+	// Group and role claims of identity tokens decide who may host or record
+	if path := os.Getenv("ROLE_MAPPING_FILE"); path != "" {
+		mapping, err := signaling.LoadRoleMapping(path)
+		if err != nil {
+			util.Fatal("Error loading role mapping: %v", err)
+		}
+		roleMapping = mapping
+		util.Info("Role mapping with %d rules loaded from %s", len(mapping.Rules), path)
+	}
+
+	// Users and groups provisioned by the identity provider over SCIM
+	if path := os.Getenv("ACCOUNTS_FILE"); path != "" {
+		store, err := storage.NewAccountStore(path)
+		if err != nil {
+			util.Fatal("Error opening accounts: %v", err)
+		}
+		accounts = store
+	}
+
+	// Tenants may approve every join through their own webhook
+	if path := os.Getenv("JOIN_AUTHORIZATION_FILE"); path != "" {
+		authorizer, err := integrations.LoadJoinAuthorizer(path)
+		if err != nil {
+			util.Fatal("Error loading join authorization webhooks: %v", err)
+		}
+		joinAuthorizer = authorizer
+		util.Info("Join authorization webhooks loaded from %s", path)
+	}
+
+	// Machine translation for caption channels in other languages
+	if endpoint := os.Getenv("TRANSLATE_URL"); endpoint != "" {
+		hub.SetTranslator(integrations.NewLibreTranslate(endpoint, os.Getenv("TRANSLATE_API_KEY")))
+		util.Info("Caption translation enabled with %s", endpoint)
+	}
+
+	// Participant recordings are kept when a recording directory is configured
+	if dir := os.Getenv("RECORDINGS_DIR"); dir != "" {
+		store, err := storage.NewRecordingStore(dir)
+		if err != nil {
+			util.Fatal("Error opening recording store: %v", err)
+		}
+		recordingStore = store
+		hub.SetRecordingStore(store)
+	}
+
+	// Tenants in compliance mode have every participant recorded, with the
+	// recordings' hashes written to the audit log
+	if path := os.Getenv("AUDIT_LOG_FILE"); path != "" {
+		audit, err := storage.NewAuditLog(path)
+		if err != nil {
+			util.Fatal("Error opening audit log: %v", err)
+		}
+		auditLog = audit
+		hub.SetAuditLog(audit)
+	}
+	if path := os.Getenv("COMPLIANCE_FILE"); path != "" {
+		if recordingStore == nil || auditLog == nil {
+			util.Fatal("COMPLIANCE_FILE requires RECORDINGS_DIR and AUDIT_LOG_FILE")
+		}
+		policies, err := signaling.LoadCompliancePolicies(path)
+		if err != nil {
+			util.Fatal("Error loading compliance policies: %v", err)
+		}
+		hub.SetCompliancePolicies(policies)
+		util.Info("Compliance mode enabled for %d tenants", len(policies))
+	}
+
+	// Meeting records, summarized after each call when a summarizer is configured
+	if dir := os.Getenv("MEETINGS_DIR"); dir != "" {
+		store, err := storage.NewMeetingStore(dir)
+		if err != nil {
+			util.Fatal("Error opening meeting store: %v", err)
+		}
+		meetingStore = store
+		hub.SetMeetingStore(store)
+	}
+	if endpoint := os.Getenv("SUMMARY_URL"); endpoint != "" {
+		model := os.Getenv("SUMMARY_MODEL")
+		if model == "" {
+			model = defaultSummaryModel
+		}
+		hub.SetSummarizer(integrations.NewLLMSummarizer(endpoint, os.Getenv("SUMMARY_API_KEY"), model))
+		var recipients []string
+		if to := os.Getenv("SUMMARY_EMAIL_TO"); to != "" {
+			recipients = strings.Split(to, ",")
+		}
+		delivery := integrations.NewSummaryDelivery(os.Getenv("SUMMARY_WEBHOOK_URL"), integrations.SMTPConfig{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SUMMARY_EMAIL_FROM"),
+			To:       recipients,
+		})
+		hub.OnEvent(delivery.Handle)
+		util.Info("Meeting summaries enabled with %s (%s)", endpoint, model)
+	}
+
+	// Legal holds keep records of users and rooms past their retention
+	if path := os.Getenv("LEGAL_HOLDS_FILE"); path != "" {
+		store, err := storage.NewHoldStore(path)
+		if err != nil {
+			util.Fatal("Error opening legal holds: %v", err)
+		}
+		legalHolds = store
+	}
+	if value := os.Getenv("RETENTION_DAYS"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			util.Fatal("Invalid RETENTION_DAYS: %q", value)
+		}
+		retention := &storage.Retention{
+			MaxAge:     time.Duration(days) * 24 * time.Hour,
+			Recordings: recordingStore,
+			Meetings:   meetingStore,
+			Holds:      legalHolds,
+		}
+		if auditLog != nil {
+			retention.Audit = auditLog
+		}
+		singletonJobs = append(singletonJobs, func(ctx context.Context) {
+			retention.Run(ctx, retentionInterval)
+		})
+		util.Info("Purging recordings and meetings after %d days", days)
+	}
+
+	// A pre-warmed room's reservation ends with the room
+	hub.OnRoomTransition(releaseClosedPrewarm)
+
+	// Overflow rooms watch their event room's media through the SFU
+	hub.OnEvent(cascadeOverflow)
+	hub.OnRoomTransition(uncascadeClosedRoom)
+
+	// Room membership and state are shared with other nodes through the state store
+	nodeName = os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		nodeName, _ = os.Hostname()
+	}
+	hub.SetNodeName(nodeName)
+	state, err := newStateStore(os.Getenv("STATE_STORE"))
+	if err != nil {
+		util.Fatal("Error opening state store: %v", err)
+	}
+	hub.SetStateStore(state)
+
+	// Chat history is shown to late joiners and served over the API
+	chatStore, err := newChatStore(os.Getenv("CHAT_STORE"))
+	if err != nil {
+		util.Fatal("Error opening chat store: %v", err)
+	}
+	hub.SetChatStore(chatStore)
+
+	// Client traffic reaches the room's clients on other nodes through the backplane
+	backplane, err := newBackplane(os.Getenv("BACKPLANE"), nodeName)
+	if err != nil {
+		util.Fatal("Error opening backplane: %v", err)
+	}
+	if backplane != nil {
+		if os.Getenv("STATE_STORE") == "" || os.Getenv("STATE_STORE") == "memory" {
+			util.Warn("BACKPLANE is set but STATE_STORE is not shared; clients on other nodes won't be found")
+		}
+		hub.SetBackplane(backplane)
+		util.Info("Relaying client traffic through the %s backplane", os.Getenv("BACKPLANE"))
+
+		// Nodes find each other and drop the clients of dead nodes
+		interval := defaultHeartbeatInterval
+		if value := os.Getenv("CLUSTER_HEARTBEAT"); value != "" {
+			interval, err = time.ParseDuration(value)
+			if err != nil || interval <= 0 {
+				util.Fatal("Invalid CLUSTER_HEARTBEAT: %q", value)
+			}
+		}
+		hub.StartGossip(interval)
+	}
+
+	// Rooms nobody is in are closed by one node, which has every node on the
+	// backplane close its own. Without a backplane to reach the others,
+	// every node sweeps its own rooms
+	roomTTL := defaultRoomTTL
+	if value := os.Getenv("ROOM_IDLE_TTL"); value != "" {
+		roomTTL, err = time.ParseDuration(value)
+		if err != nil || roomTTL < 0 {
+			util.Fatal("Invalid ROOM_IDLE_TTL: %q", value)
+		}
+	}
+	sweepIdleRooms := func(ctx context.Context) {
+		hub.SweepIdleRooms(ctx, janitorInterval, roomTTL)
+	}
+	switch {
+	case roomTTL == 0:
+	case backplane != nil:
+		singletonJobs = append(singletonJobs, sweepIdleRooms)
+	default:
+		go sweepIdleRooms(context.Background())
+	}
+
+	// Singleton jobs run on the elected node only, or here when there is no
+	// election
+	election, err := newLeaderElection(os.Getenv("LEADER_ELECTION"), nodeName)
+	if err != nil {
+		util.Fatal("Error setting up leader election: %v", err)
+	}
+	stopElection := func() {}
+	if election != nil {
+		for _, job := range singletonJobs {
+			election.OnElected(job)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			election.Run(ctx)
+			close(done)
+		}()
+		stopElection = func() {
+			cancel()
+			<-done
+		}
+		util.Info("Running %d singleton jobs on the elected leader", len(singletonJobs))
+	} else {
+		for _, job := range singletonJobs {
+			go job(context.Background())
+		}
+	}
+
+	// Room events are journaled so open rooms survive a crash, and
+	// moderation in them is audited
+	if dir := os.Getenv("EVENT_LOG_DIR"); dir != "" {
+		log, err := storage.NewFileEventLog(dir)
+		if err != nil {
+			util.Fatal("Error opening event log: %v", err)
+		}
+		eventLog = log
+		hub.SetEventLog(log)
+		hub.OnRoomEvent(auditModeration)
+	}
+
+	// Restore persistent rooms when a room store is configured
+	if dir := os.Getenv("ROOM_STORE_DIR"); dir != "" {
+		store, err := storage.NewFileStore(dir)
+		if err != nil {
+			util.Fatal("Error opening room store: %v", err)
+		}
+		hub.SetRoomStore(store)
+		restored, err := hub.RestoreRooms()
+		if err != nil {
+			util.Fatal("Error restoring persistent rooms: %v", err)
+		}
+		util.Info("Restored %d persistent rooms from %s", restored, dir)
+	}
+	if eventLog != nil {
+		recovered, err := hub.RecoverRooms()
+		if err != nil {
+			util.Fatal("Error recovering rooms from the event log: %v", err)
+		}
+		util.Info("Recovered %d open rooms from the event log", recovered)
+	}
+
+	// Operator endpoints require this key or a scoped API token
+	adminAPIKey = os.Getenv("ADMIN_API_KEY")
+	if path := os.Getenv("API_TOKENS_FILE"); path != "" {
+		store, err := storage.NewTokenStore(path)
+		if err != nil {
+			util.Fatal("Error opening API tokens: %v", err)
+		}
+		apiTokens = store
+	}
+	bridgeAPIKey = os.Getenv("BRIDGE_API_KEY")
+	captionsAPIKey = os.Getenv("CAPTIONS_API_KEY")
+	scimAPIKey = os.Getenv("SCIM_API_KEY")
+	hub.OnEvent(bridgeRelay.Handle)
+
+	// HTTPS and WSS, and TURN over TLS, share the certificate
+	server := &http.Server{Addr: cfg.Server.Addr}
+	var redirect http.Handler
+	if cfg.TLS.Enabled() {
+		if redirect, err = configureTLS(server, cfg.TLS); err != nil {
+			util.Fatal("Error setting up TLS: %v", err)
+		}
+	}
+
+	// TURN servers offered to clients in welcome and to those whose direct
+	// connections keep failing, the embedded one included
+	turnConfig := signaling.TURNConfig{Secret: os.Getenv("TURN_SECRET")}
+	if urls := os.Getenv("TURN_URLS"); urls != "" {
+		turnConfig.URLs = strings.Split(urls, ",")
+	}
+	if os.Getenv("TURN_ENABLED") == "true" || os.Getenv("COTURN_REDIS_URL") != "" {
+		if turnMeter, err = newTURNMeter(); err != nil {
+			util.Fatal("Invalid TURN_BANDWIDTH_CAPS: %v", err)
+		}
+	}
+	if raw := os.Getenv("COTURN_REDIS_URL"); raw != "" {
+		options, err := redis.ParseURL(raw)
+		if err != nil {
+			util.Fatal("Invalid COTURN_REDIS_URL: %v", err)
+		}
+		go consumeCoturnReports(options)
+		util.Info("Metering coturn's relayed traffic from its Redis reports")
+	}
+	if os.Getenv("TURN_ENABLED") == "true" {
+		turnServer, turnConfig.Secret, err = startTURNServer(cfg, server.TLSConfig)
+		if err != nil {
+			util.Fatal("Error starting TURN server: %v", err)
+		}
+		turnConfig.URLs = append(turnServer.URLs(), turnConfig.URLs...)
+	}
+	if len(turnConfig.URLs) > 0 {
+		hub.SetTURNConfig(turnConfig)
+		util.Info("TURN enabled with %s", strings.Join(turnConfig.URLs, ","))
+	}
+
+	// Leave STUN and TURN servers that stop answering out of what clients get
+	iceHealthInterval := defaultICEHealthInterval
+	if value := os.Getenv("ICE_HEALTH_INTERVAL"); value != "" {
+		iceHealthInterval, err = time.ParseDuration(value)
+		if err != nil || iceHealthInterval < 0 {
+			util.Fatal("Invalid ICE_HEALTH_INTERVAL: %q", value)
+		}
+	}
+	if iceHealthInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go runICEHealthChecks(ctx, iceHealthInterval)
+	}
+	if path := os.Getenv("CHAT_WEBHOOKS_FILE"); path != "" {
+		webhooks, err := integrations.LoadWebhooks(path)
+		if err != nil {
+			util.Fatal("Error loading chat webhooks: %v", err)
+		}
+		notifier, err := integrations.NewChatNotifier(webhooks)
+		if err != nil {
+			util.Fatal("Invalid chat webhooks: %v", err)
+		}
+		hub.OnEvent(notifier.Handle)
+		util.Info("Posting room events to %d chat webhooks", len(webhooks))
+	}
+	if path := os.Getenv("EVENT_WEBHOOKS_FILE"); path != "" {
+		webhooks, err := integrations.LoadEventWebhooks(path)
+		if err != nil {
+			util.Fatal("Error loading event webhooks: %v", err)
+		}
+		dispatcher, err := integrations.NewEventDispatcher(webhooks)
+		if err != nil {
+			util.Fatal("Invalid event webhooks: %v", err)
+		}
+		hub.OnEvent(dispatcher.Handle)
+		util.Info("Posting room events to %d webhooks", len(webhooks))
+	}
+	hub.OnEvent(func(event signaling.Event) {
+		util.Info("Event %s in room %s for client %s: %v", event.Type, event.RoomID, event.ClientID, event.Data)
+	})
+
+	// Hosts get periodic room-health messages
+	hub.StartHealthReports(healthInterval)
+
+	// Connections that went silent are cleaned up by the node they're on
+	hub.StartJanitor(janitorInterval)
+
+	// WHIP publishing and WHEP playback through the SFU
+	if os.Getenv("SFU_ENABLED") == "true" {
+		var iceServers []webrtc.ICEServer
+		if urls := os.Getenv("SFU_STUN_URLS"); urls != "" {
+			iceServers = append(iceServers, webrtc.ICEServer{URLs: strings.Split(urls, ",")})
+		}
+		var err error
+		config := sfu.Config{
+			ICEServers: iceServers,
+			PortMin:    uint16(cfg.Media.UDPPortMin),
+			PortMax:    uint16(cfg.Media.UDPPortMax),
+			PublicIPs:  cfg.Media.PublicIPList(),
+			Interfaces: cfg.Media.InterfaceList(),
+			BindIPs:    cfg.Media.BindIPs(),
+			ICETCPAddr: cfg.Media.ICETCPAddr,
+		}
+		if value := os.Getenv("SFU_MAX_SESSIONS"); value != "" {
+			if config.MaxSessions, err = strconv.Atoi(value); err != nil || config.MaxSessions < 0 {
+				util.Fatal("Invalid SFU_MAX_SESSIONS: %q", value)
+			}
+		}
+		mediaSFU, err = sfu.New(config)
+		if err != nil {
+			util.Fatal("Error starting SFU: %v", err)
+		}
+		hub.SetMediaServer(mediaSFU)
+		if recordingStore != nil {
+			hub.SetCallRecorder(sfuRecorder{})
+		}
+		whipAPIKey = os.Getenv("WHIP_API_KEY")
+	}
+
+	// Serve metrics on /metrics, and push them to a StatsD or DogStatsD
+	// agent and evaluate alerting rules when either is configured
+	var exporters []metrics.Exporter
+	if addr := os.Getenv("STATSD_ADDR"); addr != "" {
+		exporter, err := metrics.NewStatsDExporter(metrics.StatsDConfig{
+			Addr:   addr,
+			Format: os.Getenv("STATSD_FORMAT"),
+			Prefix: os.Getenv("STATSD_PREFIX"),
+			Tags:   metrics.Tags{"node": nodeName},
+		})
+		if err != nil {
+			util.Fatal("Invalid StatsD configuration: %v", err)
+		}
+		exporters = append(exporters, exporter)
+	}
+	if path := os.Getenv("ALERT_RULES_FILE"); path != "" {
+		rules, err := alerting.LoadRules(path)
+		if err != nil {
+			util.Fatal("Invalid alerting rules: %v", err)
+		}
+		exporters = append(exporters, alerting.NewEngine(rules, nodeName))
+		util.Info("Loaded %d alerting rules from %s", len(rules), path)
+	}
+	opts := metrics.HubOptions{TopRooms: defaultTopRooms}
+	if value := os.Getenv("METRICS_TOP_ROOMS"); value != "" {
+		top, err := strconv.Atoi(value)
+		if err != nil || top < 0 {
+			util.Fatal("Invalid METRICS_TOP_ROOMS: %q", value)
+		}
+		opts.TopRooms = top
+	}
+	metricsSource = metrics.Combine(metrics.HubSourceWithOptions(hub, opts), metrics.ProcessSource())
+	if turnMeter != nil {
+		metricsSource = metrics.Combine(metricsSource, metrics.TURNSource(turnMeter))
+	}
+	if mediaSFU != nil {
+		metricsSource = metrics.Combine(metricsSource, metrics.SFUSource(mediaSFU))
+	}
+	metricsAPIKey = os.Getenv("METRICS_API_KEY")
+	if len(exporters) > 0 {
+		reporter := metrics.NewReporter(metricsInterval, metricsSource, exporters...)
+		reporter.Start()
+		defer reporter.Stop()
+	}
+
+	// Experimental: let Matrix users call into mapped rooms
+	if homeserver := os.Getenv("MATRIX_HOMESERVER"); homeserver != "" {
+		client := matrix.NewClient(homeserver, os.Getenv("MATRIX_ACCESS_TOKEN"))
+		adapter := matrix.NewAdapter(client, hub, matrix.ParseRoomMap(os.Getenv("MATRIX_ROOMS")))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			if err := adapter.Run(ctx); err != nil && ctx.Err() == nil {
+				util.Error("Matrix adapter stopped: %v", err)
+			}
+		}()
+	}
+
+	// Publish RTSP cameras into rooms; viewers watch them over WHEP
+	if path := os.Getenv("CAMERAS_FILE"); path != "" {
+		if mediaSFU == nil {
+			util.Fatal("CAMERAS_FILE requires SFU_ENABLED=true")
+		}
+		cameras, err := ingest.LoadCameras(path)
+		if err != nil {
+			util.Fatal("Error loading cameras: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ingester := ingest.NewIngester(mediaSFU)
+		for _, camera := range cameras {
+			go ingester.Run(ctx, camera)
+		}
+		util.Info("Ingesting %d cameras from %s", len(cameras), path)
+	}
+
+	// Calls on hold hear this file over WHEP in their call's room
+	if path := os.Getenv("HOLD_MUSIC_FILE"); path != "" {
+		if mediaSFU == nil {
+			util.Fatal("HOLD_MUSIC_FILE requires SFU_ENABLED=true")
+		}
+		var err error
+		if holdMusic, err = ingest.NewHoldMusic(mediaSFU, path); err != nil {
+			util.Fatal("Error loading hold music: %v", err)
+		}
+		hub.SetHoldMusic(true)
+		hub.OnEvent(playHoldMusic)
+		hub.OnRoomTransition(stopClosedHoldMusic)
+	}
+
+	// Rooms created with trace=true record their signaling to this directory
+	if dir := os.Getenv("TRACE_DIR"); dir != "" {
+		hub.SetTraceDir(dir)
+		util.Info("Signal traces enabled in %s", dir)
+	}
+
 	// Setup signal handling for graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	// Initialize server
-	port := ":8080"
+	port := cfg.Server.Addr
 	util.Info("Starting server on %s", port)
 
 	// Create a new router
@@ -97,18 +739,61 @@ func main() {
 
 		util.Debug("Returned %d active rooms", len(activeRooms))
 	})
+	registerRoomAPI(mux)
+	registerAdminAPI(mux)
+	registerBridgeAPI(mux)
+	registerMediaAPI(mux)
+	registerRecordingAPI(mux)
+	registerCaptionAPI(mux)
+	registerMeetingAPI(mux)
+	registerLegalHoldAPI(mux)
+	registerSCIMAPI(mux)
+	registerTokenAPI(mux)
+	registerAccessTokenAPI(mux)
+	registerBulkAPI(mux)
+	registerPrewarmAPI(mux)
+	registerChatFederationAPI(mux)
+	registerTURNAPI(mux)
+	registerMetricsAPI(mux)
 	mux.HandleFunc("/ws", handleWebSocket)
 
 	// Keep the old routes for backward compatibility
 	mux.HandleFunc("/", handleHome)
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
-	// Apply CORS middleware
-	handler := corsMiddleware(mux)
+	// Apply CORS and rate limiting middleware
+	apiRateLimit = newRateLimit("RATE_LIMIT_PER_MINUTE", defaultRateLimit)
+	roomCreationLimit = newRateLimit("ROOM_CREATION_RATE_LIMIT", defaultRoomCreationLimit)
+	connectionRateLimit = newRateLimit("WS_CONNECTION_RATE_LIMIT", defaultConnectionRateLimit)
+	handler := corsMiddleware(rateLimitMiddleware(mux))
+	if value := os.Getenv("IDEMPOTENCY_WINDOW"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			util.Fatal("Invalid IDEMPOTENCY_WINDOW: %q", value)
+		}
+		idempotencyCache = newIdempotencyStore(window)
+	}
 
 	// Start server in a goroutine
+	server.Handler = handler
+	var redirectServer *http.Server
+	if redirect != nil && cfg.TLS.RedirectAddr != "" {
+		redirectServer = &http.Server{Addr: cfg.TLS.RedirectAddr, Handler: redirect}
+		go func() {
+			if err := redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				util.Fatal("Error starting HTTP redirect server: %v", err)
+			}
+		}()
+		util.Info("Redirecting plain HTTP on %s to HTTPS", cfg.TLS.RedirectAddr)
+	}
 	go func() {
-		if err := http.ListenAndServe(port, handler); err != nil {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			util.Fatal("Error starting server: %v", err)
 		}
 	}()
@@ -116,6 +801,14 @@ func main() {
 	// Wait for shutdown signal
 	<-stop
 	util.Info("Shutting down server...")
+	shutdownServer(server, cfg.Server.ShutdownGracePeriod, stop)
+	if redirectServer != nil {
+		redirectServer.Close()
+	}
+	stopElection()
+	if turnServer != nil {
+		turnServer.Close()
+	}
 }
 
 // handleHome serves the home page
@@ -159,6 +852,10 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
 
 // handleWebSocket handles WebSocket connections for signaling
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !allowConnection(w, r) {
+		return
+	}
+
 	// Set proper CORS headers for WebSocket handshake
 	origin := r.Header.Get("Origin")
 	if origin != "" {
@@ -166,6 +863,13 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 	}
 
+	// A client whose connection dropped, e.g. as it switched networks,
+	// takes its place in the room back with its resume token
+	if token := r.URL.Query().Get("resume"); token != "" {
+		resumeConnection(w, r, token)
+		return
+	}
+
 	// Get the room ID from the query parameters
 	roomID := r.URL.Query().Get("roomId")
 	if roomID == "" {
@@ -178,12 +882,140 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Check for debug mode (testing on same machine)
 	isDebug := r.URL.Query().Get("debug") == "true"
 
-	// Generate a unique client ID
-	clientID := generateClientID()
+	// Devices of the same user are linked through userId
+	opts := signaling.ClientOptions{
+		UserID:   r.URL.Query().Get("userId"),
+		DeviceID: r.URL.Query().Get("deviceId"),
+		Password: r.URL.Query().Get("password"),
+		Address:  clientAddress(r),
+	}
+
+	// With access tokens enabled, the token decides who joins which room and
+	// whether they may host
+	if accessVerifier != nil {
+		grant, err := authorizeAccess(r)
+		if err != nil {
+			util.WarnContext(requestLogContext(r), "Rejected access token from %s: %v", r.RemoteAddr, err)
+			http.Error(w, "invalid or missing access token", http.StatusUnauthorized)
+			return
+		}
+		if query := r.URL.Query().Get("roomId"); query != "" && query != grant.RoomID {
+			util.WarnContext(requestLogContext(r), "Refused user %s from room %s; their token is for room %s", grant.UserID, query, grant.RoomID)
+			http.Error(w, "access token is for another room", http.StatusForbidden)
+			return
+		}
+		roomID = grant.RoomID
+		opts.UserID = grant.UserID
+		opts.Verified = true
+		opts.MaxRole = grant.Role
+		isHost = grant.Role == signaling.RoleHost
+	}
+
+	// A signed identity token replaces the self-declared user ID and marks
+	// the participant as verified
+	claims, err := authenticate(r)
+	if err != nil {
+		util.WarnContext(requestLogContext(r), "Rejected identity token from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "invalid identity token", http.StatusUnauthorized)
+		return
+	}
+	if claims != nil {
+		opts.UserID = claims.UserID()
+		opts.Verified = true
+
+		// Deprovisioned users' tokens stay valid until they expire
+		if accounts.Deprovisioned(opts.UserID) {
+			util.WarnContext(requestLogContext(r), "Refused deprovisioned user %s from %s", opts.UserID, r.RemoteAddr)
+			http.Error(w, "account deprovisioned", http.StatusForbidden)
+			return
+		}
+	}
+
+	// The token's claims, plus any groups provisioned over SCIM, decide the
+	// client's role and permissions
+	if roleMapping != nil {
+		var lookup func(name string) []string
+		if claims != nil {
+			lookup = func(name string) []string {
+				values := claims.Strings(name)
+				if name == "groups" {
+					values = append(values, accounts.GroupsOf(opts.UserID)...)
+				}
+				return values
+			}
+		}
+		var maxRole signaling.Role
+		maxRole, opts.Permissions = roleMapping.Evaluate(lookup)
+		opts.MaxRole = opts.MaxRole.Min(maxRole)
+	}
+
+	// Participants see each other by display name and avatar rather than by
+	// client ID. An identity token's name is the default display name
+	opts.Persona = signaling.Persona{
+		DisplayName: r.URL.Query().Get("displayName"),
+		AvatarURL:   r.URL.Query().Get("avatarUrl"),
+	}
+	if opts.Persona.DisplayName == "" && claims != nil {
+		opts.Persona.DisplayName = claims.Name
+	}
+	opts.Persona.Metadata, err = signaling.ParseMetadata(r.URL.Query().Get("metadata"))
+	if err == nil {
+		err = opts.Persona.Validate()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Clients may ask for batched frames: JSON arrays, or for binary clients
+	// frames of several length-prefixed messages
+	if r.URL.Query().Get("batch") == "true" {
+		opts.BatchWindow = batchWindow
+	}
+
+	// Use the client-supplied ID (e.g. when reconnecting), derive one from the
+	// user and device, or generate a unique one. A verified user's ID is
+	// always derived, so a token can't be used to claim someone else's
+	clientID := r.URL.Query().Get("clientId")
+	if opts.UserID != "" && (clientID == "" || opts.Verified) {
+		derived := opts.UserID
+		if opts.DeviceID != "" {
+			derived = opts.UserID + "-" + opts.DeviceID
+		}
+		if clientID != "" && clientID != derived {
+			util.WarnContext(requestLogContext(r), "Refused client ID %s for user %s from %s", clientID, opts.UserID, r.RemoteAddr)
+			http.Error(w, "clientId doesn't match the token's user", http.StatusForbidden)
+			return
+		}
+		clientID = derived
+	}
+	if clientID == "" {
+		clientID = generateClientID()
+	}
 	if isDebug {
 		// For same-machine testing, add a random suffix to ensure uniqueness
 		clientID = fmt.Sprintf("%s-%d", clientID, time.Now().UnixNano()%1000)
 	}
+	ctx := util.WithClient(util.WithRoom(r.Context(), roomID), clientID)
+
+	// The tenant's authorization webhook may refuse the join or limit the
+	// client's role
+	if joinAuthorizer != nil {
+		maxRole, err := authorizeJoin(r, roomID, clientID, isHost, opts, claims)
+		if err != nil {
+			util.WarnContext(ctx, "Join of client %s to room %s not authorized: %v", clientID, roomID, err)
+			status := http.StatusForbidden
+			if !errors.Is(err, errJoinDenied) {
+				status = http.StatusServiceUnavailable
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		opts.MaxRole = opts.MaxRole.Min(maxRole)
+	}
+	if !opts.MaxRole.Allows(signaling.RoleHost) {
+		isHost = false
+	}
 
 	// Create log message with role information
 	role := "participant"
@@ -191,40 +1023,274 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		role = "host"
 	}
 
-	util.Info("New WebSocket connection attempt: client %s for room %s as %s from %s",
+	util.InfoContext(ctx, "New WebSocket connection attempt: client %s for room %s as %s from %s",
 		clientID, roomID, role, r.RemoteAddr)
 
 	// Upgrade the HTTP connection to a WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		util.Error("Error upgrading to WebSocket for client %s: %v", clientID, err)
+		util.ErrorContext(ctx, "Error upgrading to WebSocket for client %s: %v", clientID, err)
+		hub.CountWebSocketError(signaling.WebSocketUpgrade)
 		return
 	}
 
 	// Set proper ping/pong handlers to keep connection alive
 	conn.SetPingHandler(func(appData string) error {
-		util.Debug("Received ping from client %s", clientID)
+		util.DebugContext(ctx, "Received ping from client %s", clientID)
 		return conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(10*time.Second))
 	})
 
 	conn.SetPongHandler(func(appData string) error {
-		util.Debug("Received pong from client %s", clientID)
+		util.DebugContext(ctx, "Received pong from client %s", clientID)
 		return nil
 	})
 
+	// The first participant may choose the room's settings
+	room := hub.GetRoomWithSettings(roomID, func(settings *signaling.RoomSettings) {
+		applyRoomSettings(settings, r.URL.Query(), clientID)
+	})
+	ctx = room.LogContext(util.WithClient(r.Context(), clientID))
+
+	// Clients that didn't give the room's password in the URL send it in
+	// their join message
+	var joinMsg *signaling.Message
+	if opts.Password == "" && room.HasPassword() {
+		joinMsg = awaitJoinPassword(conn, roomID)
+		if joinMsg != nil {
+			opts.Password, _ = joinMsg.Data["password"].(string)
+			delete(joinMsg.Data, "password")
+		}
+	}
+
 	// Create a new client with host status
-	_ = signaling.NewClient(clientID, conn, hub, roomID)
+	client, err := signaling.NewClient(clientID, conn, hub, roomID, opts)
+	if err != nil {
+		util.WarnContext(ctx, "Join denied for client %s in room %s: %v", clientID, roomID, err)
+		reason := "duplicate"
+		if errors.Is(err, signaling.ErrRoomFull) {
+			reason = "full"
+			sendRoomFull(conn, roomID)
+		} else if errors.Is(err, signaling.ErrRoomLocked) {
+			reason = "locked"
+		} else if errors.Is(err, signaling.ErrBanned) {
+			reason = "banned"
+		} else if errors.Is(err, signaling.ErrShuttingDown) {
+			reason = "shutdown"
+		} else if errors.Is(err, signaling.ErrPasswordRequired) || errors.Is(err, signaling.ErrWrongPassword) {
+			reason = "password"
+		}
+		rejectConnection(conn, reason, err)
+		return
+	}
+	clientID = client.ID
 
-	// Set host status if applicable
-	if isHost {
-		room := hub.GetRoom(roomID)
-		if room != nil {
-			room.SetHost(clientID)
-			util.Info("Client %s set as host for room %s", clientID, roomID)
+	// Latecomers to a full event room are moved to an overflow room
+	roomID = client.Room.ID
+	joined := client.Room.LogContext(util.WithClient(r.Context(), clientID))
+
+	// Set host status if applicable; clients in the waiting room have to be
+	// admitted first
+	if isHost && !client.Waiting() {
+		client.Room.SetHost(clientID)
+		util.InfoContext(joined, "Client %s set as host for room %s", clientID, roomID)
+	}
+
+	// The join message that carried the password is handled like any other
+	if joinMsg != nil {
+		client.Receive(joinMsg)
+	}
+
+	util.InfoContext(joined, "WebSocket connection established: client %s in room %s", clientID, roomID)
+}
+
+// resumeConnection hands a client that lost its connection to a new one,
+// which may come from another address. Unknown or expired tokens are
+// refused with join-denied, and the client joins afresh
+func resumeConnection(w http.ResponseWriter, r *http.Request, token string) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		util.Error("Error upgrading to WebSocket to resume a client: %v", err)
+		hub.CountWebSocketError(signaling.WebSocketUpgrade)
+		return
+	}
+	conn.SetPingHandler(func(appData string) error {
+		return conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(10*time.Second))
+	})
+
+	client, err := hub.Resume(token, conn, clientAddress(r))
+	if err != nil {
+		util.Warn("Refused to resume a client from %s: %v", r.RemoteAddr, err)
+		reason := "resume"
+		if errors.Is(err, signaling.ErrBanned) {
+			reason = "banned"
 		}
+		rejectConnection(conn, reason, err)
+		return
 	}
+	util.Info("WebSocket connection resumed: client %s in room %s from %s", client.ID, client.Room.ID, r.RemoteAddr)
+}
+
+// errJoinDenied is returned when an authorization webhook refuses a join
+var errJoinDenied = errors.New("join denied")
 
-	util.Info("WebSocket connection established: client %s in room %s", clientID, roomID)
+// authorizeJoin asks the authorization webhook of the room's tenant about a
+// join and returns the most the client may be in the room. The tenant of a
+// room that doesn't exist yet is the one its first client asks for
+func authorizeJoin(r *http.Request, roomID, clientID string, isHost bool, opts signaling.ClientOptions, claims *auth.Claims) (signaling.Role, error) {
+	tenant := r.URL.Query().Get("tenant")
+	if room := hub.FindRoom(roomID); room != nil {
+		tenant = room.Settings().Tenant
+	}
+	req := integrations.JoinRequest{
+		Tenant:     tenant,
+		RoomID:     roomID,
+		ClientID:   clientID,
+		UserID:     opts.UserID,
+		DeviceID:   opts.DeviceID,
+		Verified:   opts.Verified,
+		Host:       isHost,
+		RemoteAddr: r.RemoteAddr,
+	}
+	if claims != nil {
+		req.Claims = claims.Raw
+	}
+
+	decision, err := joinAuthorizer.Authorize(r.Context(), req)
+	if err != nil {
+		return "", err
+	}
+	switch decision.Decision {
+	case integrations.DecisionDeny:
+		if decision.Reason != "" {
+			return "", fmt.Errorf("%w: %s", errJoinDenied, decision.Reason)
+		}
+		return "", errJoinDenied
+	case integrations.DecisionLimitRole:
+		return decision.Role, nil
+	default:
+		return "", nil
+	}
+}
+
+// authenticate verifies the identity token of a connection, passed as the
+// token query parameter since browsers can't set headers on WebSockets, or
+// as a bearer token. It returns nil claims for guests without a token
+func authenticate(r *http.Request) (*auth.Claims, error) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		return nil, nil
+	}
+	if identityVerifier == nil {
+		return nil, errors.New("identity tokens are not configured")
+	}
+	claims, err := identityVerifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// applyRoomSettings overrides room settings from the query parameters of the
+// connection that creates the room, ignoring invalid values
+func applyRoomSettings(settings *signaling.RoomSettings, query url.Values, clientID string) {
+	if value := query.Get("duplicatePolicy"); value != "" {
+		policy, err := signaling.ParseDuplicateJoinPolicy(value)
+		if err != nil {
+			util.Warn("Ignoring duplicatePolicy from client %s: %v", clientID, err)
+		} else {
+			settings.DuplicatePolicy = policy
+		}
+	}
+	if value := query.Get("profile"); value != "" {
+		profile, err := signaling.ParseRoomProfile(value)
+		if err != nil {
+			util.Warn("Ignoring profile from client %s: %v", clientID, err)
+		} else {
+			settings.Profile = profile
+		}
+	}
+	if value := query.Get("mode"); value != "" {
+		mode, err := signaling.ParseRoomMode(value)
+		if err != nil {
+			util.Warn("Ignoring mode from client %s: %v", clientID, err)
+		} else if mode == signaling.ModeSFU && mediaSFU == nil {
+			util.Warn("Ignoring mode from client %s: %v", clientID, signaling.ErrNoMediaServer)
+		} else {
+			settings.Mode = mode
+		}
+	}
+	if query.Get("persistent") == "true" {
+		settings.Persistent = true
+	}
+	if query.Get("trace") == "true" {
+		settings.Trace = true
+	}
+	if query.Get("waitingRoom") == "true" {
+		settings.WaitingRoom = true
+	}
+	if tenant := query.Get("tenant"); tenant != "" {
+		settings.Tenant = tenant
+	}
+	if value := query.Get("maxParticipants"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			util.Warn("Ignoring maxParticipants from client %s: %q", clientID, value)
+		} else {
+			settings.MaxParticipants = limit
+		}
+	}
+	if query.Get("transcription") == "true" {
+		settings.Transcription = true
+		settings.CaptionLanguages = signaling.ParseCaptionLanguages(query.Get("captionLanguages"))
+		if keywords := query.Get("keywords"); keywords != "" {
+			settings.Keywords = signaling.ParseKeywords(strings.Split(keywords, ","))
+		}
+	}
+}
+
+// sendRoomFull tells a client refused by a full room how many participants
+// the room takes, ahead of the join-denied message
+func sendRoomFull(conn *websocket.Conn, roomID string) {
+	data := map[string]interface{}{"roomId": roomID}
+	if room := hub.FindRoom(roomID); room != nil {
+		data["maxParticipants"] = room.Capacity()
+	}
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	signaling.WriteMessage(conn, &signaling.Message{Type: "room-full", Data: data})
+}
+
+// awaitJoinPassword asks a client joining a password-protected room for the
+// password and returns its join message, or nil when it sends anything else
+// or nothing within joinPasswordTimeout
+func awaitJoinPassword(conn *websocket.Conn, roomID string) *signaling.Message {
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	signaling.WriteMessage(conn, &signaling.Message{Type: "password-required", Data: map[string]interface{}{"roomId": roomID}})
+
+	conn.SetReadDeadline(time.Now().Add(joinPasswordTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	msg, err := signaling.ReadMessage(conn)
+	if err != nil || msg.Type != "join" || msg.Data == nil {
+		return nil
+	}
+	return msg
+}
+
+// rejectConnection tells the client why its join was refused and closes the socket
+func rejectConnection(conn *websocket.Conn, reason string, err error) {
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	signaling.WriteMessage(conn, &signaling.Message{
+		Type: "join-denied",
+		Data: map[string]interface{}{
+			"reason":  reason,
+			"message": err.Error(),
+		},
+	})
+	conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(signaling.CloseJoinDenied, reason))
+	conn.Close()
 }
 
 // generateClientID creates a unique ID for a client
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/sfu"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Maximum size of SDP offers accepted by the WHIP and WHEP endpoints
+const maxSDPSize = 64 << 10
+
+var (
+	// Media backbone behind WHIP and WHEP; nil when the SFU is disabled
+	mediaSFU *sfu.SFU
+
+	// Key required to publish over WHIP; publishing is disabled when it is empty
+	whipAPIKey string
+)
+
+// registerMediaAPI adds the WHIP ingestion and WHEP playback endpoints
+func registerMediaAPI(mux *http.ServeMux) {
+	mux.HandleFunc("POST /whip/{room}", requireKey(&whipAPIKey, "WHIP", handleWHIP))
+	mux.HandleFunc("DELETE /whip/{room}/{session}", requireKey(&whipAPIKey, "WHIP", handleEndSession))
+	mux.HandleFunc("POST /whep/{room}", handleWHEP)
+	mux.HandleFunc("DELETE /whep/{room}/{session}", handleEndSession)
+
+	// Answers carry all candidates, so trickle ICE is not needed
+	mux.HandleFunc("PATCH /whip/{room}/{session}", handleNoTrickle)
+	mux.HandleFunc("PATCH /whep/{room}/{session}", handleNoTrickle)
+}
+
+// handleWHIP accepts a broadcast tool's offer and publishes its media into a room
+func handleWHIP(w http.ResponseWriter, r *http.Request) {
+	serveOffer(w, r, "/whip/", mediaSFU.Publish)
+}
+
+// handleWHEP accepts a player's offer and sends it the media of a room
+func handleWHEP(w http.ResponseWriter, r *http.Request) {
+	serveOffer(w, r, "/whep/", mediaSFU.Subscribe)
+}
+
+// serveOffer reads an SDP offer, starts a session with it and answers with
+// the session's resource URL
+func serveOffer(w http.ResponseWriter, r *http.Request, prefix string,
+	start func(roomID, offer string) (*sfu.Session, string, error)) {
+	if mediaSFU == nil {
+		writeError(w, http.StatusServiceUnavailable, "SFU is disabled")
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/sdp" {
+		writeError(w, http.StatusUnsupportedMediaType, "offer must be application/sdp")
+		return
+	}
+	offer, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSDPSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	roomID := r.PathValue("room")
+	session, answer, err := start(roomID, string(offer))
+	if errors.Is(err, sfu.ErrNoPublisher) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, sfu.ErrNoCapacity) {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		util.Warn("Rejected %s offer for room %s from %s: %v", prefix, roomID, r.RemoteAddr, err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	util.Info("Started %s session %s in room %s for %s", session.Role, session.ID, roomID, r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", prefix+roomID+"/"+session.ID)
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answer)
+}
+
+// handleEndSession ends a WHIP or WHEP session
+func handleEndSession(w http.ResponseWriter, r *http.Request) {
+	if mediaSFU == nil {
+		writeError(w, http.StatusServiceUnavailable, "SFU is disabled")
+		return
+	}
+	session, err := mediaSFU.Session(r.PathValue("session"))
+	if err != nil || session.RoomID != r.PathValue("room") {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	session.Close()
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleNoTrickle rejects trickle ICE updates
+func handleNoTrickle(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, "trickle ICE is not supported")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/metrics"
+)
+
+// Prefix of the metric names served on /metrics unless METRICS_NAMESPACE
+// sets another
+const defaultMetricsNamespace = "chatvideo"
+
+var (
+	// Samples served on /metrics and pushed to the exporters
+	metricsSource metrics.Source
+
+	// Bearer key scrapers must present; /metrics is open when unset
+	metricsAPIKey string
+)
+
+// registerMetricsAPI serves the server's metrics for Prometheus to scrape
+func registerMetricsAPI(mux *http.ServeMux) {
+	namespace := defaultMetricsNamespace
+	if value, set := os.LookupEnv("METRICS_NAMESPACE"); set {
+		namespace = value
+	}
+	handler := metrics.PrometheusHandler(metricsSource, namespace).ServeHTTP
+	if metricsAPIKey != "" {
+		handler = requireKey(&metricsAPIKey, "metrics", handler)
+	}
+	mux.HandleFunc("GET /metrics", handler)
+}
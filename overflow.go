@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// handleRoomRoster returns a room's participants and the event or overflow
+// rooms it is linked to
+func handleRoomRoster(w http.ResponseWriter, r *http.Request) {
+	room := hub.FindRoom(r.PathValue("id"))
+	if room == nil {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		RoomID       string                  `json:"roomId"`
+		Participants []signaling.Participant `json:"participants"`
+		signaling.RoomLinks
+	}{room.ID, room.Participants(), hub.Links(room.ID)})
+}
+
+// cascadeOverflow is an event handler letting WHEP viewers of a new overflow
+// room watch its event room's media
+func cascadeOverflow(event signaling.Event) {
+	if event.Type != signaling.EventOverflowCreated || mediaSFU == nil {
+		return
+	}
+	overflowRoomID, _ := event.Data["overflowRoomId"].(string)
+	mediaSFU.Cascade(event.RoomID, overflowRoomID)
+}
+
+// uncascadeClosedRoom is a room hook ending the cascade of closed overflow rooms
+func uncascadeClosedRoom(room *signaling.Room, transition signaling.RoomTransition) {
+	if transition.To == signaling.RoomClosed && room.OverflowOf() != "" && mediaSFU != nil {
+		util.Debug("Overflow room %s of %s closed", room.ID, room.OverflowOf())
+		mediaSFU.Uncascade(room.ID)
+	}
+}
@@ -0,0 +1,197 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/metrics"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Alert statuses
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Alert is a notification about a rule changing state
+type Alert struct {
+	Rule      string    `json:"rule"`
+	Status    string    `json:"status"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Node      string    `json:"node,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// ruleState tracks one rule between evaluations
+type ruleState struct {
+	previous     float64
+	previousAt   time.Time
+	pendingSince time.Time
+	firing       bool
+	notified     bool // The current firing was notified, so its resolution is too
+	notifiedAt   time.Time
+}
+
+// Engine evaluates alerting rules against metric samples. It implements
+// metrics.Exporter so it runs on the same schedule as the other exporters
+type Engine struct {
+	rules  []Rule
+	node   string
+	client *http.Client
+	mutex  sync.Mutex
+	states []ruleState
+
+	// Replaced in tests
+	notify func(rule Rule, alert Alert)
+}
+
+// NewEngine creates an engine for validated rules; node names the server in
+// notifications
+func NewEngine(rules []Rule, node string) *Engine {
+	engine := &Engine{
+		rules:  rules,
+		node:   node,
+		client: &http.Client{Timeout: 10 * time.Second},
+		states: make([]ruleState, len(rules)),
+	}
+	engine.notify = engine.post
+	return engine
+}
+
+// Export evaluates all rules against the samples
+func (e *Engine) Export(samples []metrics.Sample) error {
+	e.evaluate(samples, time.Now())
+	return nil
+}
+
+// Close does nothing; notifications in flight finish on their own
+func (e *Engine) Close() error {
+	return nil
+}
+
+// evaluate checks every rule at the given time
+func (e *Engine) evaluate(samples []metrics.Sample, now time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for i, rule := range e.rules {
+		state := &e.states[i]
+		value, found := sum(samples, rule)
+		if !found {
+			continue
+		}
+
+		// Rates need two observations
+		observed := value
+		if rule.Rate {
+			previous, previousAt := state.previous, state.previousAt
+			state.previous, state.previousAt = value, now
+			if previousAt.IsZero() || !now.After(previousAt) {
+				continue
+			}
+			observed = (value - previous) / now.Sub(previousAt).Seconds()
+		}
+
+		breached := (rule.Op == ">" && observed > rule.Threshold) ||
+			(rule.Op == "<" && observed < rule.Threshold)
+		switch {
+		case breached && !state.firing:
+			if state.pendingSince.IsZero() {
+				state.pendingSince = now
+			}
+			if now.Sub(state.pendingSince) < time.Duration(rule.For) {
+				continue
+			}
+			state.firing = true
+			state.notified = state.notifiedAt.IsZero() || now.Sub(state.notifiedAt) >= time.Duration(rule.Cooldown)
+			if state.notified {
+				state.notifiedAt = now
+				e.send(rule, StatusFiring, observed, now)
+			}
+		case !breached:
+			state.pendingSince = time.Time{}
+			if state.firing && state.notified {
+				e.send(rule, StatusResolved, observed, now)
+			}
+			state.firing = false
+		}
+	}
+}
+
+// sum adds up the samples a rule watches
+func sum(samples []metrics.Sample, rule Rule) (float64, bool) {
+	total := 0.0
+	found := false
+	for _, sample := range samples {
+		if sample.Name != rule.Metric || !matches(sample.Tags, rule.Tags) {
+			continue
+		}
+		total += sample.Value
+		found = true
+	}
+	return total, found
+}
+
+// matches reports whether tags contain every wanted tag
+func matches(tags, want map[string]string) bool {
+	for k, v := range want {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// send hands an alert to the notifier without blocking evaluation
+func (e *Engine) send(rule Rule, status string, value float64, now time.Time) {
+	alert := Alert{
+		Rule:      rule.Name,
+		Status:    status,
+		Metric:    rule.Metric,
+		Value:     value,
+		Threshold: rule.Threshold,
+		Node:      e.node,
+		At:        now,
+	}
+	util.Warn("Alert %s %s: %s = %g (threshold %s %g)", rule.Name, status, rule.Metric, value, rule.Op, rule.Threshold)
+	go e.notify(rule, alert)
+}
+
+// post delivers an alert to the rule's webhook
+func (e *Engine) post(rule Rule, alert Alert) {
+	body, err := json.Marshal(payload(rule, alert))
+	if err != nil {
+		util.Error("Error encoding alert %s: %v", rule.Name, err)
+		return
+	}
+	resp, err := e.client.Post(rule.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		util.Error("Error sending alert %s: %v", rule.Name, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		util.Error("Webhook for alert %s returned %s", rule.Name, resp.Status)
+	}
+}
+
+// payload formats an alert for the rule's webhook
+func payload(rule Rule, alert Alert) interface{} {
+	text := fmt.Sprintf("[%s] %s on %s: %s is %g (threshold %s %g)",
+		alert.Status, alert.Rule, alert.Node, alert.Metric, alert.Value, rule.Op, alert.Threshold)
+	switch rule.Format {
+	case FormatSlack:
+		return map[string]string{"text": text}
+	case FormatDiscord:
+		return map[string]string{"content": text}
+	default:
+		return alert
+	}
+}
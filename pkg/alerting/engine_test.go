@@ -0,0 +1,74 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/metrics"
+)
+
+func TestEngineRateRule(t *testing.T) {
+	rule := Rule{
+		Name:      "dropped-messages",
+		Metric:    "room.messages.dropped",
+		Rate:      true,
+		Op:        ">",
+		Threshold: 1,
+		For:       Duration(20 * time.Second),
+		Webhook:   "http://example.invalid",
+	}
+	engine := NewEngine([]Rule{rule}, "node-1")
+	alerts := make(chan Alert, 10)
+	engine.notify = func(rule Rule, alert Alert) { alerts <- alert }
+
+	start := time.Now()
+	dropped := func(total float64) []metrics.Sample {
+		return []metrics.Sample{
+			{Name: "room.messages.dropped", Kind: metrics.Counter, Value: total / 2, Tags: metrics.Tags{"room": "a"}},
+			{Name: "room.messages.dropped", Kind: metrics.Counter, Value: total / 2, Tags: metrics.Tags{"room": "b"}},
+		}
+	}
+
+	// 5 drops per second across rooms, which must hold for 20 seconds
+	engine.evaluate(dropped(0), start)
+	engine.evaluate(dropped(50), start.Add(10*time.Second))
+	engine.evaluate(dropped(100), start.Add(20*time.Second))
+	if len(alerts) != 0 {
+		t.Fatal("Expected no alert before the rule held for 20 seconds")
+	}
+	engine.evaluate(dropped(150), start.Add(30*time.Second))
+	if alert := <-alerts; alert.Status != StatusFiring || alert.Value != 5 || alert.Node != "node-1" {
+		t.Errorf("Expected a firing alert at 5/s, got %+v", alert)
+	}
+
+	// No repeats while firing; a resolution once the rate drops
+	engine.evaluate(dropped(200), start.Add(40*time.Second))
+	engine.evaluate(dropped(200), start.Add(50*time.Second))
+	if alert := <-alerts; alert.Status != StatusResolved {
+		t.Errorf("Expected a resolved alert, got %+v", alert)
+	}
+	if len(alerts) != 0 {
+		t.Errorf("Expected exactly one firing and one resolved alert, got %d more", len(alerts))
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	os.WriteFile(path, []byte(`[{"name": "cpu", "metric": "process.cpu.seconds", "rate": true,
+		"op": ">", "threshold": 0.9, "for": "2m", "webhook": "https://hooks.slack.com/x", "format": "slack"}]`), 0o644)
+
+	rules, err := LoadRules(path)
+	if err != nil {
+		t.Fatalf("Error loading rules: %v", err)
+	}
+	if len(rules) != 1 || time.Duration(rules[0].For) != 2*time.Minute || rules[0].Format != FormatSlack {
+		t.Errorf("Unexpected rules: %+v", rules)
+	}
+
+	os.WriteFile(path, []byte(`[{"name": "bad", "metric": "x", "op": ">=", "webhook": "http://x"}]`), 0o644)
+	if _, err := LoadRules(path); err == nil {
+		t.Error("Expected an error for an unknown operator")
+	}
+}
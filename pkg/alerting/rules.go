@@ -0,0 +1,106 @@
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Duration is a time.Duration written as a string such as "5m" in rule files
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Notification formats
+const (
+	FormatJSON    = "json"
+	FormatSlack   = "slack"
+	FormatDiscord = "discord"
+)
+
+// Rule fires when a metric crosses a threshold for a while
+type Rule struct {
+	Name string `json:"name"`
+
+	// Metric name; the values of all series matching Tags are summed
+	Metric string            `json:"metric"`
+	Tags   map[string]string `json:"tags,omitempty"`
+
+	// Compare the per-second increase instead of the value, for counters
+	Rate bool `json:"rate,omitempty"`
+
+	// ">" or "<"
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+
+	// How long the condition must hold before the rule fires
+	For Duration `json:"for,omitempty"`
+
+	// Minimum time between two notifications of the rule
+	Cooldown Duration `json:"cooldown,omitempty"`
+
+	// Where notifications are posted and in which format
+	Webhook string `json:"webhook"`
+	Format  string `json:"format,omitempty"`
+}
+
+// Validate checks that a rule can be evaluated
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("rule name is required")
+	}
+	if r.Metric == "" {
+		return fmt.Errorf("rule %s: metric is required", r.Name)
+	}
+	if r.Op != ">" && r.Op != "<" {
+		return fmt.Errorf("rule %s: op must be \">\" or \"<\"", r.Name)
+	}
+	if r.Webhook == "" {
+		return fmt.Errorf("rule %s: webhook is required", r.Name)
+	}
+	switch r.Format {
+	case "":
+		r.Format = FormatJSON
+	case FormatJSON, FormatSlack, FormatDiscord:
+	default:
+		return fmt.Errorf("rule %s: unknown format %q", r.Name, r.Format)
+	}
+	return nil
+}
+
+// LoadRules reads a JSON array of rules from a file
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
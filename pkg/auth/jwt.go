@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// How far clocks may drift when checking exp and nbf
+const clockSkew = time.Minute
+
+// ErrInvalidToken is returned for tokens that are malformed, badly signed,
+// expired or issued for someone else
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the verified contents of an identity token
+type Claims struct {
+	Subject string
+	Issuer  string
+	Email   string
+	Name    string
+
+	// All claims of the token, for mapping groups and roles
+	Raw map[string]interface{}
+}
+
+// UserID returns the identity participants are known by: the e-mail address
+// if the token has one, the subject otherwise
+func (c Claims) UserID() string {
+	if c.Email != "" {
+		return c.Email
+	}
+	return c.Subject
+}
+
+// Config selects how tokens are verified. Set Secret for HS256 or PublicKey
+// for RS256 tokens, as issued by most SSO providers
+type Config struct {
+	Secret    []byte
+	PublicKey *rsa.PublicKey
+
+	// Expected iss and aud claims; not checked when empty
+	Issuer   string
+	Audience string
+}
+
+// Verifier checks JWTs of an identity provider
+type Verifier struct {
+	config Config
+}
+
+// NewVerifier creates a verifier; at least one key is required
+func NewVerifier(config Config) (*Verifier, error) {
+	if len(config.Secret) == 0 && config.PublicKey == nil {
+		return nil, errors.New("a secret or public key is required")
+	}
+	return &Verifier{config: config}, nil
+}
+
+// LoadPublicKey reads a PEM-encoded RSA public key or certificate
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	var key interface{}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	} else if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA public key", path)
+	}
+	return rsaKey, nil
+}
+
+// Verify checks a token's signature and validity and returns its claims
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: bad signature encoding", ErrInvalidToken)
+	}
+	if err := v.checkSignature(header.Alg, parts[0]+"."+parts[1], signature); err != nil {
+		return Claims{}, err
+	}
+
+	var raw map[string]interface{}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return Claims{}, err
+	}
+	now := time.Now()
+	if exp, ok := raw["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return Claims{}, fmt.Errorf("%w: expired or without exp", ErrInvalidToken)
+	}
+	if nbf, ok := raw["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return Claims{}, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+
+	claims := Claims{Raw: raw}
+	claims.Subject, _ = raw["sub"].(string)
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Email, _ = raw["email"].(string)
+	claims.Name, _ = raw["name"].(string)
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	if v.config.Issuer != "" && claims.Issuer != v.config.Issuer {
+		return Claims{}, fmt.Errorf("%w: issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+	if v.config.Audience != "" && !slices.Contains(claims.Strings("aud"), v.config.Audience) {
+		return Claims{}, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// Sign issues an HS256 token with the given claims
+func Sign(secret []byte, claims map[string]interface{}) (string, error) {
+	if len(secret) == 0 {
+		return "", errors.New("a secret is required")
+	}
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Strings returns a claim that is a string or a list of strings, such as
+// aud or groups
+func (c Claims) Strings(name string) []string {
+	switch value := c.Raw[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// checkSignature verifies the signature of the signed part of a token
+func (v *Verifier) checkSignature(alg, signed string, signature []byte) error {
+	switch {
+	case alg == "HS256" && len(v.config.Secret) > 0:
+		mac := hmac.New(sha256.New, v.config.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	case alg == "RS256" && v.config.PublicKey != nil:
+		digest := sha256.Sum256([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(v.config.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+}
+
+// decodeSegment decodes a base64url JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: bad encoding", ErrInvalidToken)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: bad JSON", ErrInvalidToken)
+	}
+	return nil
+}
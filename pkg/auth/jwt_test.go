@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// sign builds a token with the given claims; sign creates the signature
+func sign(t *testing.T, alg string, claims map[string]interface{}, signer func([]byte) []byte) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signer([]byte(signed)))
+}
+
+func TestVerifyHS256(t *testing.T) {
+	secret := []byte("s3cret")
+	hs256 := func(data []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+	verifier, _ := NewVerifier(Config{Secret: secret, Issuer: "https://sso.example.com", Audience: "calls"})
+	exp := float64(time.Now().Add(time.Hour).Unix())
+
+	token := sign(t, "HS256", map[string]interface{}{
+		"sub": "u1", "email": "alice@example.com", "iss": "https://sso.example.com",
+		"aud": []string{"calls", "mail"}, "exp": exp, "groups": []string{"admins"},
+	}, hs256)
+	claims, err := verifier.Verify(token)
+	if err != nil || claims.UserID() != "alice@example.com" || claims.Strings("groups")[0] != "admins" {
+		t.Fatalf("Expected Alice's claims, got %+v, %v", claims, err)
+	}
+
+	for name, claims := range map[string]map[string]interface{}{
+		"expired":      {"sub": "u1", "iss": "https://sso.example.com", "aud": "calls", "exp": float64(time.Now().Add(-time.Hour).Unix())},
+		"wrong issuer": {"sub": "u1", "iss": "https://evil.example.com", "aud": "calls", "exp": exp},
+		"wrong aud":    {"sub": "u1", "iss": "https://sso.example.com", "aud": "other", "exp": exp},
+	} {
+		if _, err := verifier.Verify(sign(t, "HS256", claims, hs256)); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Expected %s token to be rejected, got %v", name, err)
+		}
+	}
+	if _, err := verifier.Verify(token[:len(token)-2] + "xx"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a tampered signature to be rejected, got %v", err)
+	}
+	if signed, err := Sign(secret, map[string]interface{}{"sub": "u2", "iss": "https://sso.example.com", "aud": "calls", "exp": exp}); err != nil {
+		t.Fatal(err)
+	} else if claims, err := verifier.Verify(signed); err != nil || claims.Subject != "u2" {
+		t.Errorf("Expected a token from Sign to verify, got %+v, %v", claims, err)
+	}
+	none := sign(t, "none", map[string]interface{}{"sub": "u1", "exp": exp}, func([]byte) []byte { return nil })
+	if _, err := verifier.Verify(none); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected alg none to be rejected, got %v", err)
+	}
+}
+
+func TestVerifyRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, _ := NewVerifier(Config{PublicKey: &key.PublicKey})
+	token := sign(t, "RS256", map[string]interface{}{"sub": "u2", "exp": float64(time.Now().Add(time.Hour).Unix())}, func(data []byte) []byte {
+		digest := sha256.Sum256(data)
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		return signature
+	})
+	if claims, err := verifier.Verify(token); err != nil || claims.UserID() != "u2" {
+		t.Errorf("Expected the RS256 token to verify, got %+v, %v", claims, err)
+	}
+}
@@ -0,0 +1,373 @@
+// Package config loads the server's startup settings. Defaults are
+// overridden by a TOML file, then by environment variables, then by
+// command-line flags
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileEnv names the environment variable with the path of the config file,
+// unless the -config flag gives one
+const FileEnv = "CONFIG_FILE"
+
+// Config holds the server's startup settings. Each setting is named by its
+// tags: the key in its file section, the environment variable and the flag
+type Config struct {
+	Server    ServerConfig    `toml:"server"`
+	WebSocket WebSocketConfig `toml:"websocket"`
+	Room      RoomConfig      `toml:"room"`
+	TLS       TLSConfig       `toml:"tls"`
+	Media     MediaConfig     `toml:"media"`
+}
+
+// ServerConfig holds the HTTP server's settings
+type ServerConfig struct {
+	Addr                string        `toml:"addr" env:"LISTEN_ADDR" flag:"addr" usage:"Address the server listens on"`
+	ShutdownGracePeriod time.Duration `toml:"shutdown_grace_period" env:"SHUTDOWN_GRACE_PERIOD" flag:"shutdown-grace-period" usage:"How long clients have to reconnect elsewhere when the server stops"`
+}
+
+// WebSocketConfig holds the settings of client connections
+type WebSocketConfig struct {
+	ReadBufferSize    int           `toml:"read_buffer_size" env:"WS_READ_BUFFER_SIZE" flag:"ws-read-buffer-size" usage:"Read buffer of each connection, in bytes"`
+	WriteBufferSize   int           `toml:"write_buffer_size" env:"WS_WRITE_BUFFER_SIZE" flag:"ws-write-buffer-size" usage:"Write buffer of each connection, in bytes"`
+	MaxMessageSize    int64         `toml:"max_message_size" env:"WS_MAX_MESSAGE_SIZE" flag:"ws-max-message-size" usage:"Largest message accepted from a client, in bytes, unless its type has a limit"`
+	MessageLimits     string        `toml:"message_limits" env:"WS_MESSAGE_LIMITS" flag:"ws-message-limits" usage:"Size limits of message types, e.g. offer=65536,chat=4000, in bytes"`
+	WriteWait         time.Duration `toml:"write_wait" env:"WS_WRITE_WAIT" flag:"ws-write-wait" usage:"Time allowed to write a message to a client"`
+	PongWait          time.Duration `toml:"pong_wait" env:"WS_PONG_WAIT" flag:"ws-pong-wait" usage:"Time allowed to read the next pong from a client"`
+	PingPeriod        time.Duration `toml:"ping_period" env:"WS_PING_PERIOD" flag:"ws-ping-period" usage:"How often clients are pinged; shorter than the pong wait"`
+	SendBuffer        int           `toml:"send_buffer" env:"WS_SEND_BUFFER" flag:"ws-send-buffer" usage:"Messages queued for a client before they are dropped"`
+	MaxPendingSignals int           `toml:"max_pending_signals" env:"WS_MAX_PENDING_SIGNALS" flag:"ws-max-pending-signals" usage:"Signaling messages held for a client that isn't ready yet"`
+	MessageRate       int           `toml:"message_rate" env:"WS_MESSAGE_RATE" flag:"ws-message-rate" usage:"Messages each client may send per second on average"`
+	MessageBurst      int           `toml:"message_burst" env:"WS_MESSAGE_BURST" flag:"ws-message-burst" usage:"Messages each client may send at once over its rate"`
+	ResumeWindow      time.Duration `toml:"resume_window" env:"WS_RESUME_WINDOW" flag:"ws-resume-window" usage:"How long a client that lost its connection may resume it, e.g. from another network"`
+}
+
+// RoomConfig holds the settings shared by every room
+type RoomConfig struct {
+	BroadcastBuffer int `toml:"broadcast_buffer" env:"ROOM_BROADCAST_BUFFER" flag:"room-broadcast-buffer" usage:"Messages queued for broadcast in each room"`
+}
+
+// TLSConfig holds the settings of HTTPS and WSS. The server speaks TLS when
+// it has a certificate and key, or domains to get certificates for
+type TLSConfig struct {
+	CertFile         string `toml:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert" usage:"PEM certificate chain to serve HTTPS and WSS with"`
+	KeyFile          string `toml:"key_file" env:"TLS_KEY_FILE" flag:"tls-key" usage:"PEM private key of the certificate"`
+	AutocertDomains  string `toml:"autocert_domains" env:"TLS_AUTOCERT_DOMAINS" flag:"tls-autocert-domains" usage:"Comma-separated domains to get Let's Encrypt certificates for"`
+	AutocertCacheDir string `toml:"autocert_cache_dir" env:"TLS_AUTOCERT_CACHE_DIR" flag:"tls-autocert-cache-dir" usage:"Directory where Let's Encrypt certificates and the account key are kept"`
+	AutocertEmail    string `toml:"autocert_email" env:"TLS_AUTOCERT_EMAIL" flag:"tls-autocert-email" usage:"Contact address for the Let's Encrypt account"`
+	RedirectAddr     string `toml:"redirect_addr" env:"TLS_REDIRECT_ADDR" flag:"tls-redirect-addr" usage:"Address of a plain HTTP listener that redirects to HTTPS and answers ACME challenges, e.g. :80"`
+}
+
+// MediaConfig holds the network settings of the SFU and the embedded TURN
+// server, which deployments behind NAT such as Docker and Kubernetes need
+type MediaConfig struct {
+	UDPPortMin int    `toml:"udp_port_min" env:"MEDIA_UDP_PORT_MIN" flag:"media-udp-port-min" usage:"Lowest UDP port media and relays use; 0 lets the system choose"`
+	UDPPortMax int    `toml:"udp_port_max" env:"MEDIA_UDP_PORT_MAX" flag:"media-udp-port-max" usage:"Highest UDP port media and relays use"`
+	PublicIPs  string `toml:"public_ips" env:"MEDIA_PUBLIC_IPS" flag:"media-public-ips" usage:"Comma-separated addresses advertised behind a 1:1 NAT, each an IP or a public/private pair; one IPv4 and one IPv6 for dual-stack hosts"`
+	Interfaces string `toml:"interfaces" env:"MEDIA_INTERFACES" flag:"media-interfaces" usage:"Comma-separated network interfaces media is gathered on; empty means all"`
+	BindIP     string `toml:"bind_ip" env:"MEDIA_BIND_IP" flag:"media-bind-ip" usage:"Local IPs media and relays bind to, at most one IPv4 and one IPv6; empty means all"`
+	ICETCPAddr string `toml:"ice_tcp_addr" env:"MEDIA_ICE_TCP_ADDR" flag:"media-ice-tcp-addr" usage:"TCP address the SFU accepts ICE-TCP on, e.g. :443, for networks that block UDP; empty disables it"`
+}
+
+// PublicIPList returns the NAT 1:1 addresses, without the brackets IPv6
+// addresses may be written in
+func (m MediaConfig) PublicIPList() []string {
+	entries := splitList(m.PublicIPs)
+	for i, entry := range entries {
+		public, private, paired := strings.Cut(entry, "/")
+		entries[i] = unbracket(public)
+		if paired {
+			entries[i] += "/" + unbracket(private)
+		}
+	}
+	return entries
+}
+
+// BindIPs returns the local addresses media and relays bind to
+func (m MediaConfig) BindIPs() []net.IP {
+	var ips []net.IP
+	for _, entry := range splitList(m.BindIP) {
+		if ip := net.ParseIP(unbracket(entry)); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// InterfaceList returns the network interfaces media is gathered on
+func (m MediaConfig) InterfaceList() []string {
+	return splitList(m.Interfaces)
+}
+
+// Enabled reports whether the server speaks TLS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.AutocertDomains != ""
+}
+
+// Domains returns the autocert domains
+func (t TLSConfig) Domains() []string {
+	return splitList(t.AutocertDomains)
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// unbracket removes the brackets around an IPv6 address, as in [2001:db8::1]
+func unbracket(address string) string {
+	if strings.HasPrefix(address, "[") && strings.HasSuffix(address, "]") {
+		return address[1 : len(address)-1]
+	}
+	return address
+}
+
+// Default returns the settings used when nothing overrides them
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:                ":8080",
+			ShutdownGracePeriod: 20 * time.Second,
+		},
+		WebSocket: WebSocketConfig{
+			ReadBufferSize:    1024,
+			WriteBufferSize:   1024,
+			MaxMessageSize:    10000,
+			WriteWait:         10 * time.Second,
+			PongWait:          60 * time.Second,
+			PingPeriod:        54 * time.Second,
+			SendBuffer:        100,
+			MaxPendingSignals: 200,
+			MessageRate:       20,
+			MessageBurst:      50,
+			ResumeWindow:      30 * time.Second,
+		},
+		Room: RoomConfig{
+			BroadcastBuffer: 100,
+		},
+		TLS: TLSConfig{
+			AutocertCacheDir: "autocert",
+		},
+	}
+}
+
+// Load builds the settings from the defaults, the config file, the
+// environment and the command-line arguments, in that order, and validates
+// them. lookupEnv is usually os.LookupEnv
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	config := Default()
+	settings := config.settings()
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := flags.String("config", "", "TOML file with the settings; overrides "+FileEnv)
+	flagValues := make(map[string]*string, len(settings))
+	for _, setting := range settings {
+		flagValues[setting.flag] = flags.String(setting.flag, "", fmt.Sprintf("%s (env %s, default %s)", setting.usage, setting.env, setting.text()))
+	}
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+
+	if *path == "" {
+		*path, _ = lookupEnv(FileEnv)
+	}
+	if *path != "" {
+		if err := config.loadFile(*path); err != nil {
+			return config, err
+		}
+	}
+
+	for _, setting := range settings {
+		if value, found := lookupEnv(setting.env); found && value != "" {
+			if err := setting.set(value); err != nil {
+				return config, fmt.Errorf("%s: %w", setting.env, err)
+			}
+		}
+	}
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || err != nil {
+			return
+		}
+		for _, setting := range settings {
+			if setting.flag == f.Name {
+				if setErr := setting.set(*flagValues[f.Name]); setErr != nil {
+					err = fmt.Errorf("-%s: %w", f.Name, setErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return config, err
+	}
+
+	return config, config.Validate()
+}
+
+// Validate checks that the settings can be used together
+func (c Config) Validate() error {
+	var errs []error
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	} else if err := validateAddr("server.addr", c.Server.Addr); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Server.ShutdownGracePeriod < 0 {
+		errs = append(errs, errors.New("server.shutdown_grace_period can't be negative"))
+	}
+	for _, setting := range c.settings() {
+		if setting.section == "server" || setting.section == "media" || !setting.value.CanInt() {
+			continue
+		}
+		if setting.value.Int() <= 0 {
+			errs = append(errs, fmt.Errorf("%s.%s must be positive", setting.section, setting.key))
+		}
+	}
+	if c.WebSocket.PingPeriod >= c.WebSocket.PongWait {
+		errs = append(errs, errors.New("websocket.ping_period must be shorter than websocket.pong_wait"))
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls.cert_file and tls.key_file must be set together"))
+	}
+	if c.TLS.CertFile != "" && c.TLS.AutocertDomains != "" {
+		errs = append(errs, errors.New("tls.autocert_domains can't be combined with tls.cert_file"))
+	}
+	if c.TLS.AutocertDomains != "" && c.TLS.AutocertCacheDir == "" {
+		errs = append(errs, errors.New("tls.autocert_cache_dir is required with tls.autocert_domains"))
+	}
+	if c.TLS.RedirectAddr != "" {
+		if !c.TLS.Enabled() {
+			errs = append(errs, errors.New("tls.redirect_addr needs a certificate or autocert domains"))
+		} else if err := validateAddr("tls.redirect_addr", c.TLS.RedirectAddr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	errs = append(errs, c.Media.validate()...)
+	return errors.Join(errs...)
+}
+
+// validate checks the media settings
+func (m MediaConfig) validate() []error {
+	var errs []error
+	switch {
+	case m.UDPPortMin < 0 || m.UDPPortMax > 65535:
+		errs = append(errs, errors.New("media UDP ports must be between 1 and 65535"))
+	case (m.UDPPortMin == 0) != (m.UDPPortMax == 0):
+		errs = append(errs, errors.New("media.udp_port_min and media.udp_port_max must be set together"))
+	case m.UDPPortMin > m.UDPPortMax:
+		errs = append(errs, errors.New("media.udp_port_min can't be above media.udp_port_max"))
+	}
+	for _, entry := range m.PublicIPList() {
+		public, private, paired := strings.Cut(entry, "/")
+		if net.ParseIP(public) == nil || (paired && net.ParseIP(private) == nil) {
+			errs = append(errs, fmt.Errorf("media.public_ips: invalid address %q", entry))
+		}
+	}
+	families := make(map[bool]bool)
+	for _, entry := range splitList(m.BindIP) {
+		ip := net.ParseIP(unbracket(entry))
+		if ip == nil {
+			errs = append(errs, fmt.Errorf("media.bind_ip: invalid address %q", entry))
+			continue
+		}
+		if ipv4 := ip.To4() != nil; families[ipv4] {
+			errs = append(errs, fmt.Errorf("media.bind_ip: more than one address of the family of %q", entry))
+		} else {
+			families[ipv4] = true
+		}
+	}
+	if m.ICETCPAddr != "" {
+		if err := validateAddr("media.ice_tcp_addr", m.ICETCPAddr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// validateAddr checks a listen address such as ":8080", "0.0.0.0:8080" or
+// "[::1]:8080"
+func validateAddr(name, addr string) error {
+	_, _, err := net.SplitHostPort(addr)
+	switch {
+	case err == nil:
+		return nil
+	case !strings.HasPrefix(addr, "[") && strings.Count(addr, ":") > 1:
+		return fmt.Errorf("%s: IPv6 addresses must be in brackets, as in [::1]:8080, got %q", name, addr)
+	default:
+		return fmt.Errorf("%s: %w", name, err)
+	}
+}
+
+// setting is one field of Config along with its names
+type setting struct {
+	section, key, env, flag, usage string
+	value                          reflect.Value
+}
+
+// settings lists the fields of c, in declaration order
+func (c *Config) settings() []setting {
+	var settings []setting
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		sectionName := sections.Type().Field(i).Tag.Get("toml")
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			settings = append(settings, setting{
+				section: sectionName,
+				key:     field.Tag.Get("toml"),
+				env:     field.Tag.Get("env"),
+				flag:    field.Tag.Get("flag"),
+				usage:   field.Tag.Get("usage"),
+				value:   section.Field(j),
+			})
+		}
+	}
+	return settings
+}
+
+// set parses text into the setting
+func (s setting) set(text string) error {
+	switch {
+	case s.value.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", text)
+		}
+		s.value.SetInt(int64(d))
+	case s.value.Kind() == reflect.Int || s.value.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", text)
+		}
+		s.value.SetInt(n)
+	case s.value.Kind() == reflect.String:
+		s.value.SetString(text)
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+	return nil
+}
+
+// text formats the setting's current value the way set parses it
+func (s setting) text() string {
+	if d, ok := s.value.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	return fmt.Sprint(s.value.Interface())
+}
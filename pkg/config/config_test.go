@@ -0,0 +1,166 @@
+package config
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a lookup function over fixed variables
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, found := vars[name]
+		return value, found
+	}
+}
+
+// writeFile writes a config file and returns its path
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	config, err := Load("test", nil, env(nil))
+	if err != nil {
+		t.Fatalf("Expected the defaults to be valid, got %v", err)
+	}
+	if config != Default() {
+		t.Errorf("Expected the defaults, got %+v", config)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+# Settings for the test
+[server]
+addr = ":9000"   # overridden by the environment
+shutdown_grace_period = "5s"
+
+[websocket]
+max_message_size = 20_000
+pong_wait = "30s"
+ping_period = "25s"
+`)
+	config, err := Load("test", []string{"-ws-send-buffer", "300", "-addr", ":9200"}, env(map[string]string{
+		FileEnv:          path,
+		"LISTEN_ADDR":    ":9100",
+		"WS_SEND_BUFFER": "250",
+	}))
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+
+	if config.Server.Addr != ":9200" {
+		t.Errorf("Expected the flag to override the environment and file, got %q", config.Server.Addr)
+	}
+	if config.WebSocket.SendBuffer != 300 {
+		t.Errorf("Expected the flag to override the environment, got %d", config.WebSocket.SendBuffer)
+	}
+	if config.Server.ShutdownGracePeriod != 5*time.Second || config.WebSocket.MaxMessageSize != 20000 || config.WebSocket.PongWait != 30*time.Second {
+		t.Errorf("Expected the file's settings, got %+v", config)
+	}
+	if config.WebSocket.WriteWait != 10*time.Second {
+		t.Errorf("Expected settings missing from the file to keep their defaults, got %v", config.WebSocket.WriteWait)
+	}
+
+	config, err = Load("test", []string{"-config", path}, env(map[string]string{FileEnv: "/missing.toml"}))
+	if err != nil || config.Server.Addr != ":9000" {
+		t.Errorf("Expected -config to override %s, got %q, %v", FileEnv, config.Server.Addr, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{name: "unknown section", file: "[http]\naddr = \":80\"", want: "config.toml:1: unknown section"},
+		{name: "unknown key", file: "[server]\n\nport = 80", want: "config.toml:3: unknown setting port"},
+		{name: "bad duration", file: "[websocket]\nwrite_wait = \"soon\"", want: "invalid duration"},
+		{name: "bad env", env: map[string]string{"WS_READ_BUFFER_SIZE": "big"}, want: "WS_READ_BUFFER_SIZE: invalid number"},
+		{name: "bad flag", args: []string{"-ws-pong-wait", "1"}, want: "-ws-pong-wait: invalid duration"},
+		{name: "unknown flag", args: []string{"-verbose"}, want: "flag provided but not defined"},
+		{name: "ping after pong", args: []string{"-ws-ping-period", "2m"}, want: "ping_period must be shorter"},
+		{name: "zero size", env: map[string]string{"ROOM_BROADCAST_BUFFER": "0"}, want: "room.broadcast_buffer must be positive"},
+		{name: "empty addr", file: "[server]\naddr = \"\"", want: "server.addr is required"},
+		{name: "cert without key", args: []string{"-tls-cert", "cert.pem"}, want: "must be set together"},
+		{name: "cert and autocert", file: "[tls]\ncert_file = \"cert.pem\"\nkey_file = \"key.pem\"\nautocert_domains = \"example.com\"", want: "can't be combined"},
+		{name: "redirect without tls", env: map[string]string{"TLS_REDIRECT_ADDR": ":80"}, want: "needs a certificate"},
+		{name: "half a port range", env: map[string]string{"MEDIA_UDP_PORT_MIN": "40000"}, want: "must be set together"},
+		{name: "inverted port range", file: "[media]\nudp_port_min = 50000\nudp_port_max = 40000", want: "can't be above"},
+		{name: "port out of range", args: []string{"-media-udp-port-min", "1", "-media-udp-port-max", "70000"}, want: "between 1 and 65535"},
+		{name: "bad public ip", env: map[string]string{"MEDIA_PUBLIC_IPS": "203.0.113.10/node-1"}, want: "invalid address"},
+		{name: "bad bind ip", args: []string{"-media-bind-ip", "eth0"}, want: "media.bind_ip"},
+		{name: "two bind ips of a family", env: map[string]string{"MEDIA_BIND_IP": "10.0.0.5, 10.0.0.6"}, want: "more than one address"},
+		{name: "unbracketed ipv6 addr", args: []string{"-addr", "::1:8080"}, want: "must be in brackets"},
+		{name: "bad ice-tcp addr", env: map[string]string{"MEDIA_ICE_TCP_ADDR": "443"}, want: "media.ice_tcp_addr"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vars := map[string]string{}
+			for name, value := range test.env {
+				vars[name] = value
+			}
+			if test.file != "" {
+				vars[FileEnv] = writeFile(t, test.file)
+			}
+			_, err := Load("test", test.args, env(vars))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Expected an error containing %q, got %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestTLSDomains(t *testing.T) {
+	config, err := Load("test", []string{"-tls-autocert-domains", "example.com, www.example.com,"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if domains := config.TLS.Domains(); !config.TLS.Enabled() || len(domains) != 2 || domains[1] != "www.example.com" {
+		t.Errorf("Expected TLS with two domains, got %v", domains)
+	}
+	if Default().TLS.Enabled() {
+		t.Error("Expected TLS off by default")
+	}
+}
+
+func TestMedia(t *testing.T) {
+	config, err := Load("test", nil, env(map[string]string{
+		"MEDIA_UDP_PORT_MIN": "40000",
+		"MEDIA_UDP_PORT_MAX": "40100",
+		"MEDIA_PUBLIC_IPS":   "203.0.113.10, 198.51.100.7/10.0.0.7",
+		"MEDIA_INTERFACES":   "eth0",
+		"MEDIA_BIND_IP":      "10.0.0.7, [fd00::7]",
+		"LISTEN_ADDR":        "[::]:8080",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ips := config.Media.PublicIPList(); len(ips) != 2 || ips[1] != "198.51.100.7/10.0.0.7" {
+		t.Errorf("Expected two public IPs, got %v", ips)
+	}
+	if interfaces := config.Media.InterfaceList(); len(interfaces) != 1 || interfaces[0] != "eth0" {
+		t.Errorf("Expected one interface, got %v", interfaces)
+	}
+	if ips := config.Media.BindIPs(); len(ips) != 2 || !ips[1].Equal(net.ParseIP("fd00::7")) {
+		t.Errorf("Expected an IPv4 and an IPv6 bind address, got %v", ips)
+	}
+
+	// IPv6 addresses may be bracketed, as in listen addresses
+	config.Media.PublicIPs = "[2001:db8::10], [2001:db8::11]/[fd00::11]"
+	if ips := config.Media.PublicIPList(); len(ips) != 2 || ips[0] != "2001:db8::10" || ips[1] != "2001:db8::11/fd00::11" {
+		t.Errorf("Expected the brackets removed, got %v", ips)
+	}
+}
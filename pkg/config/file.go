@@ -0,0 +1,97 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadFile applies a config file. Files are a subset of TOML: [section]
+// headers and key = value lines, where values are quoted strings, such as
+// durations like "30s", or bare numbers. Unknown sections and keys are
+// errors, so typos don't go unnoticed
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening config file: %w", err)
+	}
+	defer file.Close()
+
+	settings := make(map[string]setting)
+	sections := make(map[string]bool)
+	for _, s := range c.settings() {
+		settings[s.section+"."+s.key] = s
+		sections[s.section] = true
+	}
+
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			name, found := strings.CutSuffix(strings.TrimPrefix(line, "["), "]")
+			name = strings.TrimSpace(name)
+			if !found || !sections[name] {
+				return fmt.Errorf("%s:%d: unknown section %s", path, number, line)
+			}
+			section = name
+			continue
+		}
+
+		key, raw, found := strings.Cut(line, "=")
+		if !found {
+			return fmt.Errorf("%s:%d: expected key = value", path, number)
+		}
+		key = strings.TrimSpace(key)
+		s, known := settings[section+"."+key]
+		if !known {
+			return fmt.Errorf("%s:%d: unknown setting %s in section [%s]", path, number, key, section)
+		}
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, number, key, err)
+		}
+		if err := s.set(value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, number, key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	return nil
+}
+
+// stripComment removes a # comment that isn't inside a quoted string
+func stripComment(line string) string {
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == '#' && !quoted:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseValue returns the text of a quoted string or bare value
+func parseValue(raw string) (string, error) {
+	if raw == "" {
+		return "", fmt.Errorf("missing value")
+	}
+	if strings.HasPrefix(raw, `"`) {
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return value, nil
+	}
+	// TOML allows underscores between digits, e.g. 10_000
+	return strings.ReplaceAll(raw, "_", ""), nil
+}
@@ -0,0 +1,264 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/sfu"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// How often the RTSP session is kept alive while playing
+const keepaliveInterval = 25 * time.Second
+
+// How long a camera may send nothing before it is reconnected
+const readTimeout = 15 * time.Second
+
+// Bounds of the reconnect backoff
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Camera is an RTSP source published into a room
+type Camera struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Room string `json:"room"`
+}
+
+// LoadCameras reads a JSON array of cameras from a file
+func LoadCameras(path string) ([]Camera, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cameras []Camera
+	if err := json.Unmarshal(data, &cameras); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, camera := range cameras {
+		if camera.Name == "" || camera.URL == "" || camera.Room == "" {
+			return nil, fmt.Errorf("camera %d: name, url and room are required", i)
+		}
+	}
+	return cameras, nil
+}
+
+// Ingester pulls camera streams and publishes them through the SFU
+type Ingester struct {
+	sfu *sfu.SFU
+}
+
+// NewIngester creates an ingester publishing into the given SFU
+func NewIngester(s *sfu.SFU) *Ingester {
+	return &Ingester{sfu: s}
+}
+
+// Run ingests a camera until the context is cancelled, reconnecting with
+// backoff when the stream fails
+func (i *Ingester) Run(ctx context.Context, camera Camera) {
+	backoff := minBackoff
+	for ctx.Err() == nil {
+		started := time.Now()
+		err := i.ingest(ctx, camera)
+		if ctx.Err() != nil {
+			return
+		}
+		// A stream that ran for a while starts over with a short backoff
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+		util.Warn("Camera %s stopped: %v; reconnecting in %s", camera.Name, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// ingest publishes one RTSP session of a camera until it fails
+func (i *Ingester) ingest(ctx context.Context, camera Camera) error {
+	conn, err := dialRTSP(ctx, camera.URL)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	stop := context.AfterFunc(ctx, func() { conn.close() })
+	defer stop()
+
+	target := conn.url.String()
+	resp, err := conn.request("DESCRIBE", target, map[string]string{"Accept": "application/sdp"})
+	if err != nil {
+		return err
+	}
+	base := resp.header.Get("Content-Base")
+	if base == "" {
+		base = target
+	}
+	medias, err := parseMedias(resp.body, base)
+	if err != nil {
+		return err
+	}
+
+	session := i.sfu.Ingest(camera.Room)
+	defer session.Close()
+
+	tracks := make(map[byte]*ingestTrack)
+	for n, media := range medias {
+		channel := byte(2 * n)
+		transport := fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", channel, channel+1)
+		if _, err := conn.request("SETUP", media.control, map[string]string{"Transport": transport}); err != nil {
+			return err
+		}
+		local, err := session.AddTrack(media.codec, media.kind)
+		if err != nil {
+			return err
+		}
+		tracks[channel] = &ingestTrack{local: local, parameterSets: media.parameterSets}
+	}
+	if _, err := conn.request("PLAY", base, map[string]string{"Range": "npt=0.000-"}); err != nil {
+		return err
+	}
+	util.Info("Camera %s is live in room %s with %d tracks", camera.Name, camera.Room, len(tracks))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(keepaliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.send("GET_PARAMETER", base, nil); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		conn.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		channel, data, err := conn.next()
+		if err != nil {
+			return err
+		}
+		// Odd channels carry RTCP, which the SFU generates itself
+		track := tracks[channel]
+		if track == nil {
+			continue
+		}
+		packet := &rtp.Packet{}
+		if err := packet.Unmarshal(data); err != nil {
+			util.Debug("Dropping malformed RTP packet from camera %s: %v", camera.Name, err)
+			continue
+		}
+		if err := track.write(packet); err != nil {
+			return err
+		}
+	}
+}
+
+// media is a stream of a camera that can be published
+type media struct {
+	kind          string
+	control       string
+	codec         webrtc.RTPCodecCapability
+	parameterSets [][]byte
+}
+
+// parseMedias picks the streams of a camera's session description whose
+// codecs browsers can play without transcoding
+func parseMedias(body []byte, base string) ([]media, error) {
+	var desc sdp.SessionDescription
+	if err := desc.Unmarshal(body); err != nil {
+		return nil, fmt.Errorf("parsing session description: %w", err)
+	}
+
+	var medias []media
+	for _, md := range desc.MediaDescriptions {
+		kind := md.MediaName.Media
+		if kind != "video" && kind != "audio" || len(md.MediaName.Formats) == 0 {
+			continue
+		}
+		format := md.MediaName.Formats[0]
+		rtpmap, _ := attribute(md, "rtpmap", format)
+		fmtp, _ := attribute(md, "fmtp", format)
+		codec, ok := codecFor(rtpmap, fmtp)
+		if !ok {
+			util.Warn("Skipping %s stream with unsupported codec %q", kind, rtpmap)
+			continue
+		}
+		control, _ := md.Attribute("control")
+		m := media{kind: kind, control: controlURL(base, control), codec: codec}
+		if codec.MimeType == webrtc.MimeTypeH264 {
+			m.parameterSets = parameterSets(fmtp)
+		}
+		medias = append(medias, m)
+	}
+	if len(medias) == 0 {
+		return nil, errors.New("camera has no stream with a supported codec")
+	}
+	return medias, nil
+}
+
+// attribute returns the value of an attribute such as rtpmap for a payload
+// type, without the payload type
+func attribute(md *sdp.MediaDescription, key, format string) (string, bool) {
+	for _, attr := range md.Attributes {
+		if attr.Key != key {
+			continue
+		}
+		if value, ok := strings.CutPrefix(attr.Value, format+" "); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// codecFor maps an rtpmap and fmtp of the camera to a WebRTC codec
+func codecFor(rtpmap, fmtp string) (webrtc.RTPCodecCapability, bool) {
+	name, _, _ := strings.Cut(strings.ToUpper(rtpmap), "/")
+	switch name {
+	case "H264":
+		profile := "42e01f"
+		for _, param := range strings.Split(fmtp, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "profile-level-id="); ok {
+				profile = strings.ToLower(value)
+			}
+		}
+		return webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeH264,
+			ClockRate:   90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + profile,
+		}, true
+	case "PCMU":
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000}, true
+	case "PCMA":
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000}, true
+	case "OPUS":
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, true
+	default:
+		return webrtc.RTPCodecCapability{}, false
+	}
+}
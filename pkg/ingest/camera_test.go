@@ -0,0 +1,153 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/sfu"
+)
+
+const cameraSDP = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=camera\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1;profile-level-id=42e01f;sprop-parameter-sets=Z0LgH9oBQBbsBEAAAAMAQAAADCPGDKg=,aM4yyA==\r\n" +
+	"a=control:trackID=1\r\n" +
+	"m=audio 0 RTP/AVP 97\r\n" +
+	"a=rtpmap:97 MPEG4-GENERIC/16000/1\r\n" +
+	"a=control:trackID=2\r\n"
+
+// fakeCamera serves one RTSP session that requires digest authentication,
+// sends a few RTP packets and hangs up
+func fakeCamera(listener net.Listener, methods chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	tp := textproto.NewReader(bufio.NewReader(conn))
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		method := strings.Fields(line)[0]
+		cseq := header.Get("CSeq")
+
+		if !strings.Contains(header.Get("Authorization"), `username="admin"`) {
+			fmt.Fprintf(conn, "RTSP/1.0 401 Unauthorized\r\nCSeq: %s\r\nWWW-Authenticate: Digest realm=\"cam\", nonce=\"abc\"\r\n\r\n", cseq)
+			continue
+		}
+		methods <- method
+		switch method {
+		case "DESCRIBE":
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nContent-Base: rtsp://%s/stream/\r\nContent-Type: application/sdp\r\nContent-Length: %d\r\n\r\n%s",
+				cseq, listener.Addr(), len(cameraSDP), cameraSDP)
+		case "SETUP":
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nSession: 1234;timeout=60\r\nTransport: %s\r\n\r\n", cseq, header.Get("Transport"))
+		case "PLAY":
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\n\r\n", cseq)
+			for i := 0; i < 5; i++ {
+				packet := &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: uint16(i)}, Payload: []byte{0x65, 0x88}}
+				data, _ := packet.Marshal()
+				frame := []byte{'$', 0, 0, 0}
+				binary.BigEndian.PutUint16(frame[2:], uint16(len(data)))
+				conn.Write(append(frame, data...))
+			}
+			time.Sleep(200 * time.Millisecond)
+			return
+		}
+	}
+}
+
+func TestIngestCamera(t *testing.T) {
+	s, err := sfu.New(sfu.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	methods := make(chan string, 10)
+	go fakeCamera(listener, methods)
+
+	camera := Camera{Name: "door", URL: "rtsp://admin:secret@" + listener.Addr().String() + "/stream", Room: "lobby"}
+	errs := make(chan error, 1)
+	go func() { errs <- NewIngester(s).ingest(context.Background(), camera) }()
+
+	for _, want := range []string{"DESCRIBE", "SETUP", "PLAY"} {
+		select {
+		case got := <-methods:
+			if got != want {
+				t.Fatalf("request: got %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("camera never received %s", want)
+		}
+	}
+
+	// Only the H.264 stream is published; AAC is skipped
+	time.Sleep(50 * time.Millisecond)
+	tracks := s.Tracks("lobby")
+	if len(tracks) != 1 || tracks[0].Codec().MimeType != webrtc.MimeTypeH264 {
+		t.Fatalf("published tracks: %v", tracks)
+	}
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("ingest returned no error after the camera hung up")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ingest did not stop after the camera hung up")
+	}
+	if tracks := s.Tracks("lobby"); len(tracks) != 0 {
+		t.Fatalf("tracks after camera hung up: %d", len(tracks))
+	}
+}
+
+func TestParameterSetsBeforeKeyframes(t *testing.T) {
+	sets := parameterSets("packetization-mode=1;sprop-parameter-sets=Z0LgH9oBQBbsBEAAAAMAQAAADCPGDKg=,aM4yyA==")
+	if len(sets) != 2 || naluType(sets[0]) != naluSPS {
+		t.Fatalf("parameter sets: %v", sets)
+	}
+
+	local, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "camera")
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := &ingestTrack{local: local, parameterSets: sets}
+
+	// Non-keyframes pass through; an IDR fragment gets both sets in front
+	track.write(&rtp.Packet{Payload: []byte{0x41, 0x9a}})
+	track.write(&rtp.Packet{Payload: []byte{0x7c, 0x85}})
+	if track.offset != 2 {
+		t.Fatalf("offset after keyframe: got %d, want 2", track.offset)
+	}
+
+	// Cameras that send SPS in band are left alone
+	track.write(&rtp.Packet{Payload: []byte{0x67, 0x42}})
+	track.write(&rtp.Packet{Payload: []byte{0x65, 0x88}})
+	if track.offset != 2 {
+		t.Fatalf("offset with in-band SPS: got %d, want 2", track.offset)
+	}
+}
@@ -0,0 +1,85 @@
+package ingest
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// H.264 NAL unit types
+const (
+	naluIDR  = 5
+	naluSPS  = 7
+	naluFUA  = 28
+	naluMask = 0x1f
+)
+
+// ingestTrack writes a camera's RTP stream to a published track
+type ingestTrack struct {
+	local *webrtc.TrackLocalStaticRTP
+
+	// SPS and PPS from the session description. Many cameras only announce
+	// them there, but browsers need them in band before each keyframe
+	parameterSets [][]byte
+
+	// Added to sequence numbers to make room for injected packets
+	offset uint16
+}
+
+// write forwards a packet, inserting the parameter sets before keyframes
+func (t *ingestTrack) write(packet *rtp.Packet) error {
+	if len(t.parameterSets) > 0 {
+		switch naluType(packet.Payload) {
+		case naluSPS:
+			// The camera sends them in band itself
+			t.parameterSets = nil
+		case naluIDR:
+			for _, nalu := range t.parameterSets {
+				injected := &rtp.Packet{Header: packet.Header, Payload: nalu}
+				injected.Marker = false
+				injected.SequenceNumber = packet.SequenceNumber + t.offset
+				t.offset++
+				if err := t.local.WriteRTP(injected); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	packet.SequenceNumber += t.offset
+	return t.local.WriteRTP(packet)
+}
+
+// naluType returns the type of the NAL unit a packet starts, looking into
+// the first fragment of fragmented units
+func naluType(payload []byte) byte {
+	if len(payload) == 0 {
+		return 0
+	}
+	typ := payload[0] & naluMask
+	if typ == naluFUA {
+		if len(payload) < 2 || payload[1]&0x80 == 0 {
+			return 0
+		}
+		return payload[1] & naluMask
+	}
+	return typ
+}
+
+// parameterSets decodes sprop-parameter-sets from an H.264 fmtp line
+func parameterSets(fmtp string) [][]byte {
+	var sets [][]byte
+	for _, param := range strings.Split(fmtp, ";") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(param), "sprop-parameter-sets=")
+		if !ok {
+			continue
+		}
+		for _, encoded := range strings.Split(value, ",") {
+			if nalu, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(nalu) > 0 {
+				sets = append(sets, nalu)
+			}
+		}
+	}
+	return sets
+}
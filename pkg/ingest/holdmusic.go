@@ -0,0 +1,139 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/sfu"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Clock rate of Opus RTP timestamps
+const opusClockRate = 48000
+
+// HoldMusic loops an Ogg Opus file into the rooms of calls on hold, so the
+// waiting party hears music over WHEP instead of silence
+type HoldMusic struct {
+	sfu  *sfu.SFU
+	path string
+
+	mutex   sync.Mutex
+	playing map[string]context.CancelFunc // By room ID
+}
+
+// NewHoldMusic checks that the file is Ogg Opus and returns a player for it
+func NewHoldMusic(s *sfu.SFU, path string) (*HoldMusic, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, header, err := oggreader.NewWith(file); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	} else if header.Channels == 0 {
+		return nil, fmt.Errorf("%s has no audio channels", path)
+	}
+	return &HoldMusic{sfu: s, path: path, playing: make(map[string]context.CancelFunc)}, nil
+}
+
+// Start plays the music into a room until Stop. The track is published
+// before Start returns, so players subscribing afterwards receive it
+func (m *HoldMusic) Start(roomID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, playing := m.playing[roomID]; playing {
+		return nil
+	}
+
+	session := m.sfu.Ingest(roomID)
+	track, err := session.AddTrack(webrtc.RTPCodecCapability{
+		MimeType:  webrtc.MimeTypeOpus,
+		ClockRate: opusClockRate,
+		Channels:  2,
+	}, "hold-music")
+	if err != nil {
+		session.Close()
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.playing[roomID] = cancel
+	util.Info("Playing hold music in room %s", roomID)
+
+	go func() {
+		defer session.Close()
+		// Sequence numbers and timestamps run on across loops of the file
+		packet := &rtp.Packet{Header: rtp.Header{Version: 2, Marker: true}}
+		for ctx.Err() == nil {
+			if err := m.play(ctx, track, packet); err != nil {
+				util.Error("Hold music in room %s stopped: %v", roomID, err)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends the music in a room
+func (m *HoldMusic) Stop(roomID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if cancel, playing := m.playing[roomID]; playing {
+		cancel()
+		delete(m.playing, roomID)
+		util.Info("Stopped hold music in room %s", roomID)
+	}
+}
+
+// play sends the file once, paced by the pages' granule positions
+func (m *HoldMusic) play(ctx context.Context, track *webrtc.TrackLocalStaticRTP, packet *rtp.Packet) error {
+	file, err := os.Open(m.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, _, err := oggreader.NewWith(file)
+	if err != nil {
+		return err
+	}
+
+	var lastGranule uint64
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		payload, header, err := reader.ParseNextPage()
+		if errors.Is(err, io.EOF) && lastGranule > 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(payload, []byte("OpusTags")) || header.GranulePosition <= lastGranule {
+			continue
+		}
+		samples := header.GranulePosition - lastGranule
+		lastGranule = header.GranulePosition
+		ticker.Reset(time.Duration(samples) * time.Second / opusClockRate)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		packet.Payload = payload
+		packet.SequenceNumber++
+		packet.Timestamp += uint32(samples)
+		if err := track.WriteRTP(packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package ingest
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/sfu"
+)
+
+func TestHoldMusic(t *testing.T) {
+	s, err := sfu.New(sfu.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "hold.ogg")
+	writer, err := oggwriter.New(path, opusClockRate, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		packet := &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i), Timestamp: uint32(i * 960)}, Payload: []byte{0xfc, 0xff, 0xfe}}
+		if err := writer.WriteRTP(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writer.Close()
+
+	if _, err := NewHoldMusic(s, filepath.Join(t.TempDir(), "missing.ogg")); err == nil {
+		t.Error("Expected a missing file to be refused")
+	}
+	music, err := NewHoldMusic(s, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := music.Start("call-1"); err != nil {
+		t.Fatal(err)
+	}
+	music.Start("call-1")
+	tracks := s.Tracks("call-1")
+	if len(tracks) != 1 || tracks[0].Codec().MimeType != webrtc.MimeTypeOpus {
+		t.Fatalf("Expected one Opus track, got %v", tracks)
+	}
+
+	music.Stop("call-1")
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.Tracks("call-1")) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the track to be removed after Stop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,298 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default RTSP port when the URL has none
+const defaultRTSPPort = "554"
+
+// How long a request may take before the camera counts as unreachable
+const requestTimeout = 10 * time.Second
+
+// rtspConn is a minimal RTSP client that receives media interleaved on the
+// control connection (RTP over TCP), which works through NAT and firewalls
+type rtspConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	url    *url.URL // Without credentials
+	user   *url.Userinfo
+
+	writeMutex sync.Mutex
+	cseq       int
+	session    string
+	challenge  map[string]string // Last WWW-Authenticate challenge
+	basic      bool
+}
+
+// rtspResponse is a parsed RTSP response
+type rtspResponse struct {
+	status int
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// dialRTSP connects to the camera at an rtsp:// URL
+func dialRTSP(ctx context.Context, rawURL string) (*rtspConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "rtsp" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), defaultRTSPPort)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	user := u.User
+	u.User = nil
+	return &rtspConn{conn: conn, reader: bufio.NewReader(conn), url: u, user: user}, nil
+}
+
+// close closes the connection
+func (c *rtspConn) close() error {
+	return c.conn.Close()
+}
+
+// request sends a request and waits for its response, answering one
+// authentication challenge with the URL's credentials
+func (c *rtspConn) request(method, target string, header map[string]string) (*rtspResponse, error) {
+	c.conn.SetDeadline(time.Now().Add(requestTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	for attempt := 0; ; attempt++ {
+		if err := c.send(method, target, header); err != nil {
+			return nil, err
+		}
+		resp, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if resp.status == 401 && attempt == 0 && c.user != nil {
+			if err := c.setChallenge(resp.header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.status != 200 {
+			return nil, fmt.Errorf("%s %s: status %d", method, target, resp.status)
+		}
+		if session := resp.header.Get("Session"); session != "" {
+			// Drop parameters such as ";timeout=60"
+			c.session, _, _ = strings.Cut(session, ";")
+		}
+		return resp, nil
+	}
+}
+
+// send writes a request without waiting for the response
+func (c *rtspConn) send(method, target string, header map[string]string) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.cseq++
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s RTSP/1.0\r\n", method, target)
+	fmt.Fprintf(&b, "CSeq: %d\r\n", c.cseq)
+	b.WriteString("User-Agent: chat-video-app\r\n")
+	if c.session != "" {
+		fmt.Fprintf(&b, "Session: %s\r\n", c.session)
+	}
+	if auth := c.authorization(method, target); auth != "" {
+		fmt.Fprintf(&b, "Authorization: %s\r\n", auth)
+	}
+	for key, value := range header {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	b.WriteString("\r\n")
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+// readResponse reads a response, skipping interleaved media that arrives
+// before it
+func (c *rtspConn) readResponse() (*rtspResponse, error) {
+	for {
+		first, err := c.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if first[0] != '$' {
+			break
+		}
+		if _, _, err := c.readFrame(); err != nil {
+			return nil, err
+		}
+	}
+
+	tp := textproto.NewReader(c.reader)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	proto, status, ok := strings.Cut(line, " ")
+	if !ok || !strings.HasPrefix(proto, "RTSP/") {
+		return nil, fmt.Errorf("malformed status line %q", line)
+	}
+	code, err := strconv.Atoi(strings.Fields(status)[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed status line %q", line)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &rtspResponse{status: code, header: header}
+	if length := header.Get("Content-Length"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 || n > 1<<20 {
+			return nil, fmt.Errorf("invalid Content-Length %q", length)
+		}
+		resp.body = make([]byte, n)
+		if _, err := io.ReadFull(c.reader, resp.body); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// readFrame reads one interleaved frame ("$", channel, 16-bit length, data)
+func (c *rtspConn) readFrame() (byte, []byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return 0, nil, err
+	}
+	if head[0] != '$' {
+		return 0, nil, errors.New("expected interleaved frame")
+	}
+	data := make([]byte, binary.BigEndian.Uint16(head[2:]))
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		return 0, nil, err
+	}
+	return head[1], data, nil
+}
+
+// next returns the next interleaved frame, discarding responses to requests
+// sent while playing, such as keepalives
+func (c *rtspConn) next() (byte, []byte, error) {
+	for {
+		first, err := c.reader.Peek(1)
+		if err != nil {
+			return 0, nil, err
+		}
+		if first[0] == '$' {
+			return c.readFrame()
+		}
+		if _, err := c.readResponse(); err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+// setChallenge remembers an authentication challenge
+func (c *rtspConn) setChallenge(header string) error {
+	scheme, params, _ := strings.Cut(header, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		c.basic = true
+	case "digest":
+		c.challenge = parseAuthParams(params)
+	default:
+		return fmt.Errorf("unsupported authentication %q", scheme)
+	}
+	return nil
+}
+
+// authorization returns the Authorization header for a request, if the
+// camera asked for credentials
+func (c *rtspConn) authorization(method, target string) string {
+	if c.user == nil {
+		return ""
+	}
+	password, _ := c.user.Password()
+	if c.basic {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.user.Username()+":"+password))
+	}
+	if c.challenge == nil {
+		return ""
+	}
+
+	realm, nonce := c.challenge["realm"], c.challenge["nonce"]
+	ha1 := md5Hex(c.user.Username() + ":" + realm + ":" + password)
+	ha2 := md5Hex(method + ":" + target)
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`,
+		c.user.Username(), realm, nonce, target)
+	if strings.Contains(c.challenge["qop"], "auth") {
+		nc := fmt.Sprintf("%08x", c.cseq)
+		cnonce := md5Hex(strconv.FormatInt(time.Now().UnixNano(), 10))[:16]
+		response := md5Hex(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+		header += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%s", response="%s"`, nc, cnonce, response)
+	} else {
+		header += fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+nonce+":"+ha2))
+	}
+	if opaque := c.challenge["opaque"]; opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return header
+}
+
+// parseAuthParams parses `key="value", key=value` pairs of a challenge
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		var key, value string
+		key, s, _ = strings.Cut(strings.TrimLeft(s, " ,"), "=")
+		if strings.HasPrefix(s, `"`) {
+			value, s, _ = strings.Cut(s[1:], `"`)
+		} else {
+			value, s, _ = strings.Cut(s, ",")
+		}
+		if key != "" {
+			params[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	return params
+}
+
+// md5Hex returns the hex MD5 digest used by digest authentication
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// controlURL resolves a media's control attribute against the content base
+func controlURL(base, control string) string {
+	if control == "" || control == "*" {
+		return base
+	}
+	if strings.HasPrefix(control, "rtsp://") {
+		return control
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return base + control
+}
@@ -0,0 +1,145 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+// Time a join waits for an authorization webhook before giving up
+const authorizationTimeout = 5 * time.Second
+
+// Decisions an authorization webhook may return
+const (
+	DecisionAllow     = "allow"
+	DecisionDeny      = "deny"
+	DecisionLimitRole = "limit-role"
+)
+
+// AuthorizationWebhook is a tenant's endpoint that approves joins
+type AuthorizationWebhook struct {
+	Tenant string `json:"tenant"`
+	URL    string `json:"url"`
+
+	// Sent as a bearer token so the endpoint can tell calls are genuine
+	Secret string `json:"secret,omitempty"`
+
+	// Admit clients when the endpoint can't be reached instead of refusing them
+	FailOpen bool `json:"failOpen,omitempty"`
+}
+
+// JoinRequest is the context sent to an authorization webhook
+type JoinRequest struct {
+	Tenant     string                 `json:"tenant"`
+	RoomID     string                 `json:"roomId"`
+	ClientID   string                 `json:"clientId"`
+	UserID     string                 `json:"userId,omitempty"`
+	DeviceID   string                 `json:"deviceId,omitempty"`
+	Verified   bool                   `json:"verified"`
+	Host       bool                   `json:"host"` // The client asked to join as host
+	Claims     map[string]interface{} `json:"claims,omitempty"`
+	RemoteAddr string                 `json:"remoteAddr"`
+}
+
+// JoinDecision is a webhook's answer. Limit-role decisions admit the client
+// with at most the given role
+type JoinDecision struct {
+	Decision string         `json:"decision"`
+	Role     signaling.Role `json:"role,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+}
+
+// JoinAuthorizer asks tenants' webhooks whether clients may join their rooms
+type JoinAuthorizer struct {
+	webhooks map[string]AuthorizationWebhook
+	client   *http.Client
+}
+
+// LoadJoinAuthorizer reads the authorization webhooks of tenants from a JSON file
+func LoadJoinAuthorizer(path string) (*JoinAuthorizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var webhooks []AuthorizationWebhook
+	if err := json.Unmarshal(data, &webhooks); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return NewJoinAuthorizer(webhooks)
+}
+
+// NewJoinAuthorizer creates an authorizer with one webhook per tenant
+func NewJoinAuthorizer(webhooks []AuthorizationWebhook) (*JoinAuthorizer, error) {
+	a := &JoinAuthorizer{
+		webhooks: make(map[string]AuthorizationWebhook, len(webhooks)),
+		client:   &http.Client{Timeout: authorizationTimeout},
+	}
+	for i, webhook := range webhooks {
+		if webhook.Tenant == "" || webhook.URL == "" {
+			return nil, fmt.Errorf("webhook %d: tenant and url are required", i)
+		}
+		if _, exists := a.webhooks[webhook.Tenant]; exists {
+			return nil, fmt.Errorf("webhook %d: duplicate tenant %s", i, webhook.Tenant)
+		}
+		a.webhooks[webhook.Tenant] = webhook
+	}
+	return a, nil
+}
+
+// Authorize asks the webhook of the request's tenant about a join. Tenants
+// without a webhook allow every join. Unreachable or invalid webhooks deny
+// the join unless they fail open
+func (a *JoinAuthorizer) Authorize(ctx context.Context, req JoinRequest) (JoinDecision, error) {
+	webhook, exists := a.webhooks[req.Tenant]
+	if !exists {
+		return JoinDecision{Decision: DecisionAllow}, nil
+	}
+	decision, err := a.call(ctx, webhook, req)
+	if err != nil && webhook.FailOpen {
+		return JoinDecision{Decision: DecisionAllow, Reason: err.Error()}, nil
+	}
+	return decision, err
+}
+
+// call posts the request to a webhook and validates its decision
+func (a *JoinAuthorizer) call(ctx context.Context, webhook AuthorizationWebhook, req JoinRequest) (JoinDecision, error) {
+	body, _ := json.Marshal(req)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return JoinDecision{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if webhook.Secret != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+webhook.Secret)
+	}
+
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return JoinDecision{}, fmt.Errorf("authorization webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return JoinDecision{}, fmt.Errorf("authorization webhook returned %s", resp.Status)
+	}
+	var decision JoinDecision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return JoinDecision{}, fmt.Errorf("authorization webhook: %w", err)
+	}
+
+	switch decision.Decision {
+	case DecisionAllow, DecisionDeny:
+	case DecisionLimitRole:
+		if _, err := signaling.ParseRole(string(decision.Role)); err != nil {
+			return JoinDecision{}, fmt.Errorf("authorization webhook: %w", err)
+		}
+	default:
+		return JoinDecision{}, fmt.Errorf("authorization webhook returned unknown decision %q", decision.Decision)
+	}
+	return decision, nil
+}
@@ -0,0 +1,71 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestJoinAuthorizer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req JoinRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.Header.Get("Authorization") != "Bearer secret" || req.RoomID != "standup" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch req.UserID {
+		case "alice":
+			json.NewEncoder(w).Encode(JoinDecision{Decision: DecisionAllow})
+		case "bob":
+			json.NewEncoder(w).Encode(JoinDecision{Decision: DecisionLimitRole, Role: signaling.RoleViewer})
+		default:
+			json.NewEncoder(w).Encode(JoinDecision{Decision: DecisionDeny, Reason: "not on the guest list"})
+		}
+	}))
+	defer server.Close()
+
+	authorizer, err := NewJoinAuthorizer([]AuthorizationWebhook{
+		{Tenant: "acme", URL: server.URL, Secret: "secret"},
+		{Tenant: "down", URL: "http://127.0.0.1:1", FailOpen: true},
+		{Tenant: "strict", URL: "http://127.0.0.1:1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cases := []struct {
+		tenant, user string
+		decision     string
+		role         signaling.Role
+		fails        bool
+	}{
+		{tenant: "acme", user: "alice", decision: DecisionAllow},
+		{tenant: "acme", user: "bob", decision: DecisionLimitRole, role: signaling.RoleViewer},
+		{tenant: "acme", user: "mallory", decision: DecisionDeny},
+		{tenant: "other", user: "mallory", decision: DecisionAllow},
+		{tenant: "down", user: "mallory", decision: DecisionAllow},
+		{tenant: "strict", user: "alice", fails: true},
+	}
+	for _, c := range cases {
+		decision, err := authorizer.Authorize(ctx, JoinRequest{Tenant: c.tenant, RoomID: "standup", UserID: c.user})
+		if c.fails {
+			if err == nil {
+				t.Errorf("Expected %s/%s to fail, got %+v", c.tenant, c.user, decision)
+			}
+			continue
+		}
+		if err != nil || decision.Decision != c.decision || decision.Role != c.role {
+			t.Errorf("Expected %s for %s/%s, got %+v, %v", c.decision, c.tenant, c.user, decision, err)
+		}
+	}
+
+	if _, err := NewJoinAuthorizer([]AuthorizationWebhook{{Tenant: "acme"}}); err == nil {
+		t.Error("Expected a webhook without a URL to be rejected")
+	}
+}
@@ -0,0 +1,110 @@
+package integrations
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// BridgeTarget is where a room's chat is relayed back to
+type BridgeTarget struct {
+	URL    string `json:"url"`
+	Source string `json:"source"` // Platform name, sent back so the bridge can route it
+}
+
+// relayedMessage is the body posted to a bridge for each room chat message
+type relayedMessage struct {
+	RoomID string    `json:"roomId"`
+	Source string    `json:"source"`
+	Author string    `json:"author"`
+	Text   string    `json:"text"`
+	At     time.Time `json:"at"`
+}
+
+// BridgeRelay relays room chat to the external platforms bridged into it.
+// Messages injected by a bridge are never relayed, so bridges don't loop
+type BridgeRelay struct {
+	mutex   sync.RWMutex
+	targets map[string]BridgeTarget
+	client  *http.Client
+	queue   chan relayedMessage
+}
+
+// NewBridgeRelay creates a relay and starts posting in the background
+func NewBridgeRelay() *BridgeRelay {
+	relay := &BridgeRelay{
+		targets: make(map[string]BridgeTarget),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan relayedMessage, queueSize),
+	}
+	go relay.run()
+	return relay
+}
+
+// SetTarget relays a room's chat to a bridge
+func (b *BridgeRelay) SetTarget(roomID string, target BridgeTarget) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.targets[roomID] = target
+}
+
+// RemoveTarget stops relaying a room's chat
+func (b *BridgeRelay) RemoveTarget(roomID string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	_, exists := b.targets[roomID]
+	delete(b.targets, roomID)
+	return exists
+}
+
+// target returns the bridge of a room, if any
+func (b *BridgeRelay) target(roomID string) (BridgeTarget, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	target, exists := b.targets[roomID]
+	return target, exists
+}
+
+// Handle queues chat messages of bridged rooms; register it with Hub.OnEvent
+func (b *BridgeRelay) Handle(event signaling.Event) {
+	if event.Type != signaling.EventChatMessage || event.ClientID == signaling.BridgeClientID {
+		return
+	}
+	target, exists := b.target(event.RoomID)
+	if !exists {
+		return
+	}
+
+	text, _ := event.Data["text"].(string)
+	author, _ := event.Data["author"].(string)
+	select {
+	case b.queue <- relayedMessage{RoomID: event.RoomID, Source: target.Source, Author: author, Text: text, At: event.At}:
+	default:
+		util.Warn("Bridge relay queue full, dropping chat from room %s", event.RoomID)
+	}
+}
+
+// run posts queued messages to their bridges
+func (b *BridgeRelay) run() {
+	for message := range b.queue {
+		target, exists := b.target(message.RoomID)
+		if !exists {
+			continue
+		}
+		body, _ := json.Marshal(message)
+		resp, err := b.client.Post(target.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			util.Error("Error relaying chat of room %s to bridge: %v", message.RoomID, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			util.Error("Bridge for room %s returned %s", message.RoomID, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package integrations
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestBridgeRelay(t *testing.T) {
+	posts := make(chan relayedMessage, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message relayedMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		posts <- message
+	}))
+	defer server.Close()
+
+	relay := NewBridgeRelay()
+	relay.SetTarget("bridged-room", BridgeTarget{URL: server.URL, Source: "matrix"})
+
+	chat := func(roomID, clientID string) signaling.Event {
+		return signaling.Event{Type: signaling.EventChatMessage, RoomID: roomID, ClientID: clientID,
+			Data: map[string]interface{}{"text": "hello", "author": "alice"}}
+	}
+	relay.Handle(chat("other-room", "alice"))
+	relay.Handle(chat("bridged-room", signaling.BridgeClientID))
+	relay.Handle(chat("bridged-room", "alice"))
+
+	select {
+	case message := <-posts:
+		if message.RoomID != "bridged-room" || message.Source != "matrix" || message.Author != "alice" {
+			t.Errorf("Unexpected relayed message %+v", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the chat message to be relayed")
+	}
+	select {
+	case message := <-posts:
+		t.Errorf("Expected only one relayed message, got %+v", message)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if !relay.RemoveTarget("bridged-room") || relay.RemoveTarget("bridged-room") {
+		t.Error("Expected the bridge to be removed exactly once")
+	}
+}
@@ -0,0 +1,176 @@
+package integrations
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Chat platforms a webhook can post to
+const (
+	KindSlack   = "slack"
+	KindDiscord = "discord"
+)
+
+// Notifications waiting to be posted; events beyond this are dropped
+const queueSize = 256
+
+// Default message templates per event type. Templates see the event, e.g.
+// {{.RoomID}}, {{.Tenant}}, {{.ClientID}} and {{.Data.name}}
+var defaultTemplates = map[string]string{
+	signaling.EventRoomCreated:    "Room *{{.RoomID}}* was created",
+	signaling.EventRoomInvite:     "{{with .Data.name}}{{.}}{{else}}{{with .Data.email}}{{.}}{{else}}{{.Data.userId}}{{end}}{{end}} is invited to *{{.RoomID}}*",
+	signaling.EventLobbyWaiting:   "{{with .Data.name}}{{.}}{{else}}Someone{{end}} is waiting to join *{{.RoomID}}*",
+	signaling.EventRecordingReady: "A recording of *{{.RoomID}}* is ready{{with .Data.url}}: {{.}}{{end}}",
+	signaling.EventMeetingSummary: "Summary of the call in *{{.RoomID}}*: {{.Data.summary}}{{range .Data.actionItems}}\n• {{.}}{{end}}",
+}
+
+// Webhook posts selected hub events of one tenant to a chat channel
+type Webhook struct {
+	// Tenant whose rooms are reported; empty matches rooms of every tenant
+	Tenant string `json:"tenant,omitempty"`
+
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+
+	// Event types to post; defaults to every type with a default template
+	Events []string `json:"events,omitempty"`
+
+	// Message templates by event type, overriding the defaults
+	Templates map[string]string `json:"templates,omitempty"`
+
+	templates map[string]*template.Template
+}
+
+// notification is a rendered message waiting to be posted
+type notification struct {
+	webhook *Webhook
+	text    string
+}
+
+// ChatNotifier posts hub events to Slack and Discord webhooks
+type ChatNotifier struct {
+	webhooks []*Webhook
+	client   *http.Client
+	queue    chan notification
+}
+
+// LoadWebhooks reads a JSON array of webhooks from a file
+func LoadWebhooks(path string) ([]*Webhook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var webhooks []*Webhook
+	if err := json.Unmarshal(data, &webhooks); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return webhooks, nil
+}
+
+// NewChatNotifier validates the webhooks and starts posting in the background
+func NewChatNotifier(webhooks []*Webhook) (*ChatNotifier, error) {
+	for i, webhook := range webhooks {
+		if err := webhook.compile(); err != nil {
+			return nil, fmt.Errorf("webhook %d: %w", i, err)
+		}
+	}
+	notifier := &ChatNotifier{
+		webhooks: webhooks,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan notification, queueSize),
+	}
+	go notifier.run()
+	return notifier, nil
+}
+
+// compile checks a webhook and parses its templates
+func (w *Webhook) compile() error {
+	if w.Kind != KindSlack && w.Kind != KindDiscord {
+		return fmt.Errorf("unknown kind %q", w.Kind)
+	}
+	if w.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if len(w.Events) == 0 {
+		for eventType := range defaultTemplates {
+			w.Events = append(w.Events, eventType)
+		}
+	}
+
+	w.templates = make(map[string]*template.Template, len(w.Events))
+	for _, eventType := range w.Events {
+		text, ok := w.Templates[eventType]
+		if !ok {
+			text, ok = defaultTemplates[eventType]
+		}
+		if !ok {
+			text = "{{.Type}} in room {{.RoomID}}"
+		}
+		tmpl, err := template.New(eventType).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return fmt.Errorf("template for %s: %w", eventType, err)
+		}
+		w.templates[eventType] = tmpl
+	}
+	return nil
+}
+
+// Handle queues an event for every matching webhook. It never blocks, so it
+// can be registered directly with Hub.OnEvent
+func (n *ChatNotifier) Handle(event signaling.Event) {
+	for _, webhook := range n.webhooks {
+		if webhook.Tenant != "" && webhook.Tenant != event.Tenant {
+			continue
+		}
+		tmpl, ok := webhook.templates[event.Type]
+		if !ok {
+			continue
+		}
+
+		var text strings.Builder
+		if err := tmpl.Execute(&text, event); err != nil {
+			util.Warn("Error rendering %s notification: %v", event.Type, err)
+			continue
+		}
+		select {
+		case n.queue <- notification{webhook: webhook, text: text.String()}:
+		default:
+			util.Warn("Chat notification queue full, dropping %s for room %s", event.Type, event.RoomID)
+		}
+	}
+}
+
+// run posts queued notifications one at a time
+func (n *ChatNotifier) run() {
+	for notification := range n.queue {
+		n.post(notification)
+	}
+}
+
+// post sends one message in the webhook's format
+func (n *ChatNotifier) post(notification notification) {
+	payload := map[string]string{"text": notification.text}
+	if notification.webhook.Kind == KindDiscord {
+		payload = map[string]string{"content": notification.text}
+	}
+	body, _ := json.Marshal(payload)
+
+	resp, err := n.client.Post(notification.webhook.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		util.Error("Error posting to %s webhook: %v", notification.webhook.Kind, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		util.Error("%s webhook returned %s", notification.webhook.Kind, resp.Status)
+	}
+}
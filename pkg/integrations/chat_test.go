@@ -0,0 +1,66 @@
+package integrations
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestChatNotifier(t *testing.T) {
+	posts := make(chan map[string]string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		posts <- body
+	}))
+	defer server.Close()
+
+	notifier, err := NewChatNotifier([]*Webhook{
+		{Tenant: "acme", Kind: KindSlack, URL: server.URL, Events: []string{signaling.EventRoomCreated},
+			Templates: map[string]string{signaling.EventRoomCreated: "New call {{.RoomID}} for {{.Tenant}}"}},
+		{Kind: KindDiscord, URL: server.URL, Events: []string{signaling.EventLobbyWaiting}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hub := signaling.NewHub()
+	hub.OnEvent(notifier.Handle)
+	hub.GetRoomWithSettings("acme-room", func(s *signaling.RoomSettings) { s.Tenant = "acme" })
+	hub.GetRoomWithSettings("other-room", func(s *signaling.RoomSettings) { s.Tenant = "other" })
+
+	select {
+	case body := <-posts:
+		if body["text"] != "New call acme-room for acme" {
+			t.Errorf("Unexpected Slack message %v", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a Slack post for the acme room")
+	}
+
+	// Default templates and Discord's payload format
+	notifier.Handle(signaling.Event{Type: signaling.EventLobbyWaiting, RoomID: "other-room",
+		Data: map[string]interface{}{"name": "Alice"}})
+	select {
+	case body := <-posts:
+		if body["content"] != "Alice is waiting to join *other-room*" {
+			t.Errorf("Unexpected Discord message %v", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a Discord post for the lobby event")
+	}
+
+	select {
+	case body := <-posts:
+		t.Errorf("Expected no post for other tenants, got %v", body)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := NewChatNotifier([]*Webhook{{Kind: "teams", URL: server.URL}}); err == nil {
+		t.Error("Expected an error for an unknown kind")
+	}
+}
@@ -0,0 +1,101 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+// Instructions sent to the model with every transcript
+const summaryPrompt = `You summarize video calls. Reply with a JSON object with two fields:
+"summary", a short paragraph on what was discussed and decided, and
+"actionItems", an array of strings naming each follow-up task and, if known, who owns it.`
+
+// LLMSummarizer summarizes meetings through an OpenAI-compatible chat
+// completions endpoint, such as OpenAI, vLLM or Ollama
+type LLMSummarizer struct {
+	url    string
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+// NewLLMSummarizer creates a summarizer for the API at baseURL, e.g.
+// https://api.openai.com/v1
+func NewLLMSummarizer(baseURL, apiKey, model string) *LLMSummarizer {
+	return &LLMSummarizer{
+		url:    strings.TrimSuffix(baseURL, "/") + "/chat/completions",
+		apiKey: apiKey,
+		model:  model,
+		client: &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// chatMessage is one message of a chat completion request or response
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Summarize sends the meeting's transcript to the model
+func (s *LLMSummarizer) Summarize(ctx context.Context, meeting signaling.Meeting) (signaling.MeetingSummary, error) {
+	var transcript strings.Builder
+	for _, caption := range meeting.Transcript {
+		fmt.Fprintf(&transcript, "[%s] %s: %s\n", caption.At.Sub(meeting.StartedAt).Round(time.Second), caption.Speaker, caption.Text)
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"model": s.model,
+		"messages": []chatMessage{
+			{Role: "system", Content: summaryPrompt},
+			{Role: "user", Content: transcript.String()},
+		},
+		"response_format": map[string]string{"type": "json_object"},
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return signaling.MeetingSummary{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return signaling.MeetingSummary{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return signaling.MeetingSummary{}, fmt.Errorf("summarization endpoint returned %s", resp.Status)
+	}
+	var result struct {
+		Choices []struct {
+			Message chatMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return signaling.MeetingSummary{}, err
+	}
+	if len(result.Choices) == 0 {
+		return signaling.MeetingSummary{}, errors.New("summarization endpoint returned no choices")
+	}
+
+	var summary signaling.MeetingSummary
+	content := result.Choices[0].Message.Content
+	if err := json.Unmarshal([]byte(content), &summary); err != nil {
+		// Models that ignore the JSON format still produce a usable summary
+		summary = signaling.MeetingSummary{Summary: strings.TrimSpace(content)}
+	}
+	if summary.ActionItems == nil {
+		summary.ActionItems = []string{}
+	}
+	return summary, nil
+}
@@ -0,0 +1,121 @@
+package integrations
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// SMTPConfig configures the mail server summaries are sent through
+type SMTPConfig struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// SummaryDelivery sends meeting summaries to a webhook and by email
+type SummaryDelivery struct {
+	webhook string
+	smtp    SMTPConfig
+	client  *http.Client
+	queue   chan signaling.Event
+
+	// Replaced in tests
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSummaryDelivery creates a delivery and starts sending in the
+// background. Either destination may be left empty
+func NewSummaryDelivery(webhook string, smtpConfig SMTPConfig) *SummaryDelivery {
+	d := &SummaryDelivery{
+		webhook:  webhook,
+		smtp:     smtpConfig,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan signaling.Event, queueSize),
+		sendMail: smtp.SendMail,
+	}
+	go d.run()
+	return d
+}
+
+// Handle queues meeting summaries; register it with Hub.OnEvent
+func (d *SummaryDelivery) Handle(event signaling.Event) {
+	if event.Type != signaling.EventMeetingSummary {
+		return
+	}
+	select {
+	case d.queue <- event:
+	default:
+		util.Warn("Summary delivery queue full, dropping summary of room %s", event.RoomID)
+	}
+}
+
+// run delivers queued summaries
+func (d *SummaryDelivery) run() {
+	for event := range d.queue {
+		if d.webhook != "" {
+			d.post(event)
+		}
+		if d.smtp.Addr != "" && len(d.smtp.To) > 0 {
+			d.mail(event)
+		}
+	}
+}
+
+// post sends the summary event as JSON to the webhook
+func (d *SummaryDelivery) post(event signaling.Event) {
+	body, _ := json.Marshal(event)
+	resp, err := d.client.Post(d.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		util.Error("Error posting summary of room %s: %v", event.RoomID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		util.Error("Summary webhook for room %s returned %s", event.RoomID, resp.Status)
+	}
+}
+
+// mail sends the summary as a plain text email
+func (d *SummaryDelivery) mail(event signaling.Event) {
+	var auth smtp.Auth
+	if d.smtp.Username != "" {
+		host, _, _ := strings.Cut(d.smtp.Addr, ":")
+		auth = smtp.PlainAuth("", d.smtp.Username, d.smtp.Password, host)
+	}
+	if err := d.sendMail(d.smtp.Addr, auth, d.smtp.From, d.smtp.To, summaryEmail(d.smtp, event)); err != nil {
+		util.Error("Error mailing summary of room %s: %v", event.RoomID, err)
+	}
+}
+
+// summaryEmail renders the message for a summary event
+func summaryEmail(config SMTPConfig, event signaling.Event) []byte {
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", config.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(config.To, ", "))
+	fmt.Fprintf(&body, "Subject: Summary of your call in %s\r\n", event.RoomID)
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	summary, _ := event.Data["summary"].(string)
+	body.WriteString(summary)
+	body.WriteString("\r\n")
+	if items, _ := event.Data["actionItems"].([]string); len(items) > 0 {
+		body.WriteString("\r\nAction items:\r\n")
+		for _, item := range items {
+			fmt.Fprintf(&body, "- %s\r\n", item)
+		}
+	}
+	if meetingID, _ := event.Data["meetingId"].(string); meetingID != "" {
+		fmt.Fprintf(&body, "\r\nMeeting ID: %s\r\n", meetingID)
+	}
+	return []byte(body.String())
+}
@@ -0,0 +1,83 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestLLMSummarizer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model    string        `json:"model"`
+			Messages []chatMessage `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer key" ||
+			req.Model != "test-model" || !strings.Contains(req.Messages[1].Content, "alice: ship on Friday") {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{
+				"message": chatMessage{Role: "assistant", Content: `{"summary": "Release plan", "actionItems": ["alice: ship"]}`},
+			}},
+		})
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	meeting := signaling.Meeting{ID: "m1", StartedAt: start, Transcript: []signaling.Caption{
+		{Speaker: "alice", Text: "ship on Friday", At: start.Add(time.Minute)},
+	}}
+	summary, err := NewLLMSummarizer(server.URL+"/v1", "key", "test-model").Summarize(context.Background(), meeting)
+	if err != nil || summary.Summary != "Release plan" || len(summary.ActionItems) != 1 {
+		t.Fatalf("Expected the model's summary, got %+v, %v", summary, err)
+	}
+}
+
+func TestSummaryDelivery(t *testing.T) {
+	posts := make(chan signaling.Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event signaling.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		posts <- event
+	}))
+	defer server.Close()
+
+	mails := make(chan string, 1)
+	delivery := NewSummaryDelivery(server.URL, SMTPConfig{Addr: "mail:25", From: "calls@example.com", To: []string{"team@example.com"}})
+	delivery.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mails <- string(msg)
+		return nil
+	}
+
+	delivery.Handle(signaling.Event{Type: signaling.EventRoomCreated, RoomID: "standup"})
+	delivery.Handle(signaling.Event{Type: signaling.EventMeetingSummary, RoomID: "standup", Data: map[string]interface{}{
+		"meetingId": "m1", "summary": "Release plan", "actionItems": []string{"alice: ship"},
+	}})
+
+	select {
+	case event := <-posts:
+		if event.Type != signaling.EventMeetingSummary {
+			t.Errorf("Expected the summary event, got %s", event.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the summary to be posted")
+	}
+	select {
+	case mail := <-mails:
+		if !strings.Contains(mail, "Subject: Summary of your call in standup") || !strings.Contains(mail, "- alice: ship") {
+			t.Errorf("Expected summary and action items in the mail, got %q", mail)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the summary to be mailed")
+	}
+}
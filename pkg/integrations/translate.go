@@ -0,0 +1,66 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LibreTranslate translates captions through a LibreTranslate-compatible
+// /translate endpoint
+type LibreTranslate struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewLibreTranslate creates a translator for the service at baseURL
+func NewLibreTranslate(baseURL, apiKey string) *LibreTranslate {
+	return &LibreTranslate{
+		url:    strings.TrimSuffix(baseURL, "/") + "/translate",
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// translateRequest is the body of a /translate request
+type translateRequest struct {
+	Q      string `json:"q"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Format string `json:"format"`
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// Translate translates text; an empty source language is detected by the service
+func (t *LibreTranslate) Translate(ctx context.Context, text, from, to string) (string, error) {
+	if from == "" {
+		from = "auto"
+	}
+	body, _ := json.Marshal(translateRequest{Q: text, Source: from, Target: to, Format: "text", APIKey: t.apiKey})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("translation service returned %s", resp.Status)
+	}
+	var result struct {
+		TranslatedText string `json:"translatedText"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.TranslatedText, nil
+}
@@ -0,0 +1,31 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLibreTranslate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req translateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/translate" || req.Source != "auto" || req.Target != "es" || req.APIKey != "key" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"translatedText": "hola"})
+	}))
+	defer server.Close()
+
+	translator := NewLibreTranslate(server.URL+"/", "key")
+	text, err := translator.Translate(context.Background(), "hello", "", "es")
+	if err != nil || text != "hola" {
+		t.Fatalf("Expected hola, got %q, %v", text, err)
+	}
+	if _, err := translator.Translate(context.Background(), "hello", "en", "es"); err == nil {
+		t.Error("Expected an error status to be reported")
+	}
+}
@@ -0,0 +1,207 @@
+package integrations
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Headers of event webhook requests
+const (
+	HeaderWebhookID        = "X-Webhook-Id"        // Same for every attempt of a delivery
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp" // Unix seconds of the attempt
+	HeaderWebhookSignature = "X-Webhook-Signature" // sha256=<hex HMAC of "<timestamp>.<body>">
+)
+
+// Attempts per delivery, and the bounds of the delay between them
+const (
+	webhookAttempts = 5
+	minRetryDelay   = time.Second
+	maxRetryDelay   = time.Minute
+)
+
+// DefaultWebhookEvents are the events posted to webhooks that don't list any
+var DefaultWebhookEvents = []string{
+	signaling.EventRoomCreated,
+	signaling.EventRoomDestroyed,
+	signaling.EventUserJoined,
+	signaling.EventUserLeft,
+	signaling.EventRecordingStarted,
+}
+
+// EventWebhook receives hub events of one tenant as signed JSON, for
+// billing, CRM or analytics systems
+type EventWebhook struct {
+	// Tenant whose rooms are reported; empty matches rooms of every tenant
+	Tenant string `json:"tenant,omitempty"`
+
+	URL string `json:"url"`
+
+	// Key of the HMAC-SHA256 signature of each request
+	Secret string `json:"secret"`
+
+	// Event types to post; defaults to DefaultWebhookEvents
+	Events []string `json:"events,omitempty"`
+
+	queue chan delivery
+}
+
+// delivery is an event waiting to be posted to one webhook
+type delivery struct {
+	id   string
+	body []byte
+}
+
+// EventDispatcher posts hub events to webhooks, retrying failed deliveries
+// with exponential backoff. Each webhook has its own queue, so a slow or
+// failing endpoint doesn't hold up the others
+type EventDispatcher struct {
+	webhooks []*EventWebhook
+	client   *http.Client
+
+	// Bounds of the delay between attempts; replaced in tests
+	minDelay, maxDelay time.Duration
+}
+
+// LoadEventWebhooks reads a JSON array of event webhooks from a file
+func LoadEventWebhooks(path string) ([]*EventWebhook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var webhooks []*EventWebhook
+	if err := json.Unmarshal(data, &webhooks); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return webhooks, nil
+}
+
+// NewEventDispatcher validates the webhooks and starts posting in the
+// background
+func NewEventDispatcher(webhooks []*EventWebhook) (*EventDispatcher, error) {
+	for i, webhook := range webhooks {
+		if webhook.URL == "" {
+			return nil, fmt.Errorf("webhook %d: url is required", i)
+		}
+		if webhook.Secret == "" {
+			return nil, fmt.Errorf("webhook %d: secret is required", i)
+		}
+		if len(webhook.Events) == 0 {
+			webhook.Events = DefaultWebhookEvents
+		}
+	}
+	dispatcher := &EventDispatcher{
+		webhooks: webhooks,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		minDelay: minRetryDelay,
+		maxDelay: maxRetryDelay,
+	}
+	for _, webhook := range webhooks {
+		webhook.queue = make(chan delivery, queueSize)
+		go dispatcher.run(webhook)
+	}
+	return dispatcher, nil
+}
+
+// Handle queues an event for every webhook subscribed to it. It never
+// blocks, so it can be registered directly with Hub.OnEvent
+func (d *EventDispatcher) Handle(event signaling.Event) {
+	var body []byte
+	for _, webhook := range d.webhooks {
+		if webhook.Tenant != "" && webhook.Tenant != event.Tenant {
+			continue
+		}
+		if !slices.Contains(webhook.Events, event.Type) {
+			continue
+		}
+		if body == nil {
+			body, _ = json.Marshal(event)
+		}
+		select {
+		case webhook.queue <- delivery{id: deliveryID(), body: body}:
+		default:
+			util.Warn("Webhook queue of %s full, dropping %s for room %s", webhook.URL, event.Type, event.RoomID)
+		}
+	}
+}
+
+// run posts a webhook's deliveries one at a time, in order
+func (d *EventDispatcher) run(webhook *EventWebhook) {
+	for delivery := range webhook.queue {
+		d.deliver(webhook, delivery)
+	}
+}
+
+// deliver posts an event until the webhook accepts it, it is refused for
+// good or the attempts run out
+func (d *EventDispatcher) deliver(webhook *EventWebhook, delivery delivery) {
+	delay := d.minDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := d.post(webhook, delivery)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == webhookAttempts {
+			util.Error("Giving up on webhook delivery %s to %s after %d attempts: %v", delivery.id, webhook.URL, attempt, err)
+			return
+		}
+		util.Warn("Webhook delivery %s to %s failed, retrying in %s: %v", delivery.id, webhook.URL, delay, err)
+		time.Sleep(delay)
+		delay = min(2*delay, d.maxDelay)
+	}
+}
+
+// post makes one attempt at a delivery and reports whether a failure is
+// worth retrying: network errors, 429 and server errors are
+func (d *EventDispatcher) post(webhook *EventWebhook, delivery delivery) (bool, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookID, delivery.id)
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	req.Header.Set(HeaderWebhookSignature, SignWebhook(webhook.Secret, timestamp, delivery.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, nil
+}
+
+// SignWebhook returns the signature header of a request: the HMAC-SHA256 of
+// the timestamp and body joined by a dot, under the webhook's secret.
+// Receivers compute the same to check that a request came from the server,
+// and reject old timestamps to stop replays
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliveryID returns a random ID receivers can drop repeated deliveries by
+func deliveryID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,93 @@
+package integrations
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestEventDispatcher(t *testing.T) {
+	type request struct {
+		id    string
+		event signaling.Event
+	}
+	requests := make(chan request, 20)
+	var mutex sync.Mutex
+	failures := 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(HeaderWebhookSignature) != SignWebhook("s3cret", r.Header.Get(HeaderWebhookTimestamp), body) {
+			t.Errorf("Expected a valid signature, got %q", r.Header.Get(HeaderWebhookSignature))
+		}
+		var event signaling.Event
+		json.Unmarshal(body, &event)
+		requests <- request{id: r.Header.Get(HeaderWebhookID), event: event}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if event.Type == signaling.EventRoomCreated && failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	if _, err := NewEventDispatcher([]*EventWebhook{{URL: server.URL}}); err == nil {
+		t.Error("Expected a webhook without a secret to be refused")
+	}
+	dispatcher, err := NewEventDispatcher([]*EventWebhook{{Tenant: "acme", URL: server.URL, Secret: "s3cret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.minDelay, dispatcher.maxDelay = time.Millisecond, 5*time.Millisecond
+
+	hub := signaling.NewHub()
+	hub.OnEvent(dispatcher.Handle)
+	room := hub.GetRoomWithSettings("billing", func(s *signaling.RoomSettings) { s.Tenant = "acme" })
+	hub.GetRoomWithSettings("elsewhere", func(s *signaling.RoomSettings) { s.Tenant = "other" })
+	room.AddClient(&signaling.Client{ID: "alice", UserID: "alice@example.com", Room: room})
+	hub.CloseRoom("billing", "done")
+
+	receive := func() request {
+		t.Helper()
+		select {
+		case r := <-requests:
+			return r
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected a webhook request")
+			return request{}
+		}
+	}
+
+	// The room's creation is retried until it gets through, then the rest
+	// follows in order
+	first := receive()
+	for range 2 {
+		if retry := receive(); retry.id != first.id || retry.event.Type != signaling.EventRoomCreated {
+			t.Errorf("Expected room-created retried with the same ID, got %+v", retry)
+		}
+	}
+	if first.event.RoomID != "billing" || first.event.Tenant != "acme" {
+		t.Errorf("Expected the acme room's creation, got %+v", first.event)
+	}
+	if joined := receive(); joined.event.Type != signaling.EventUserJoined || joined.event.ClientID != "alice" || joined.event.Data["userId"] != "alice@example.com" {
+		t.Errorf("Expected alice's join, got %+v", joined.event)
+	}
+	if left := receive(); left.event.Type != signaling.EventUserLeft || left.event.ClientID != "alice" {
+		t.Errorf("Expected alice to leave as the room closed, got %+v", left.event)
+	}
+	if destroyed := receive(); destroyed.event.Type != signaling.EventRoomDestroyed || destroyed.event.Tenant != "acme" {
+		t.Errorf("Expected the room's removal, got %+v", destroyed.event)
+	}
+	select {
+	case r := <-requests:
+		t.Errorf("Expected nothing from other tenants, got %+v", r.event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,366 @@
+package matrix
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Matrix VoIP event types (MSC2746)
+const (
+	eventInvite     = "m.call.invite"
+	eventAnswer     = "m.call.answer"
+	eventCandidates = "m.call.candidates"
+	eventHangup     = "m.call.hangup"
+)
+
+const (
+	// Long-poll timeout of /sync
+	syncTimeout = 30 * time.Second
+
+	// Pause after a failed sync before retrying
+	syncRetryDelay = 5 * time.Second
+
+	// How long an invite we send stays valid
+	inviteLifetime = 60000
+)
+
+// callContent holds the fields of m.call.* events the adapter uses
+type callContent struct {
+	CallID     string                   `json:"call_id"`
+	PartyID    string                   `json:"party_id,omitempty"`
+	Version    interface{}              `json:"version"`
+	Lifetime   int                      `json:"lifetime,omitempty"`
+	Offer      *sessionDescription      `json:"offer,omitempty"`
+	Answer     *sessionDescription      `json:"answer,omitempty"`
+	Candidates []map[string]interface{} `json:"candidates,omitempty"`
+}
+
+// sessionDescription is an SDP offer or answer
+type sessionDescription struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// Adapter maps rooms of the hub onto Matrix rooms. Each Matrix user calling
+// into a mapped room joins the local room as a virtual participant; their
+// m.call.* events become offers, answers and ICE candidates, and local
+// signaling addressed to them is sent back as m.call.* events.
+//
+// This is an experiment: Matrix 1:1 calls have no notion of several peers,
+// so each local participant negotiating with a Matrix user gets a call of
+// its own, keyed by call ID
+type Adapter struct {
+	client *Client
+	hub    *signaling.Hub
+	userID string
+
+	// Matrix room ID to local room ID
+	rooms map[string]string
+
+	mutex sync.Mutex
+	peers map[string]*peer
+}
+
+// peer is a Matrix user taking part in a local room
+type peer struct {
+	adapter    *Adapter
+	matrixRoom string
+	matrixUser string
+	partyID    string
+	client     *signaling.Client
+
+	mutex   sync.Mutex
+	calls   map[string]string // Call ID to local client ID
+	byLocal map[string]string // Local client ID to call ID
+}
+
+// NewAdapter creates an adapter for rooms mapped as Matrix room ID to local room ID
+func NewAdapter(client *Client, hub *signaling.Hub, rooms map[string]string) *Adapter {
+	return &Adapter{
+		client: client,
+		hub:    hub,
+		rooms:  rooms,
+		peers:  make(map[string]*peer),
+	}
+}
+
+// ParseRoomMap parses "local=!matrix:server,..." pairs into a Matrix to local room map
+func ParseRoomMap(value string) map[string]string {
+	rooms := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		local, matrixRoom, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && local != "" && matrixRoom != "" {
+			rooms[matrixRoom] = local
+		}
+	}
+	return rooms
+}
+
+// Run syncs with the homeserver until the context is cancelled. Events that
+// happened before the adapter started are skipped
+func (a *Adapter) Run(ctx context.Context) error {
+	userID, err := a.client.WhoAmI(ctx)
+	if err != nil {
+		return err
+	}
+	a.userID = userID
+
+	_, since, err := a.client.Sync(ctx, "", 0)
+	if err != nil {
+		return err
+	}
+	util.Info("Matrix adapter running as %s for %d rooms", userID, len(a.rooms))
+
+	for ctx.Err() == nil {
+		events, next, err := a.client.Sync(ctx, since, syncTimeout)
+		if err != nil {
+			if ctx.Err() == nil {
+				util.Warn("Matrix sync failed: %v", err)
+				time.Sleep(syncRetryDelay)
+			}
+			continue
+		}
+		since = next
+		for matrixRoom, roomEvents := range events {
+			for _, event := range roomEvents {
+				a.handleEvent(ctx, matrixRoom, event)
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// handleEvent turns a Matrix call event into local signaling
+func (a *Adapter) handleEvent(ctx context.Context, matrixRoom string, event Event) {
+	localRoom, mapped := a.rooms[matrixRoom]
+	if !mapped || event.Sender == a.userID || !strings.HasPrefix(event.Type, "m.call.") {
+		return
+	}
+	var content callContent
+	if err := json.Unmarshal(event.Content, &content); err != nil || content.CallID == "" {
+		util.Warn("Ignoring malformed %s from %s: %v", event.Type, event.Sender, err)
+		return
+	}
+
+	p, err := a.peer(ctx, matrixRoom, localRoom, event.Sender)
+	if err != nil {
+		util.Warn("Matrix user %s could not join room %s: %v", event.Sender, localRoom, err)
+		return
+	}
+
+	switch event.Type {
+	case eventInvite:
+		if content.Offer == nil {
+			return
+		}
+		target := p.answerer()
+		if target == "" {
+			util.Warn("No participant in room %s to answer the call from %s", localRoom, event.Sender)
+			return
+		}
+		p.track(content.CallID, target)
+		p.client.Receive(&signaling.Message{
+			Type: "offer",
+			To:   target,
+			Data: map[string]interface{}{"sdp": content.Offer, "callId": content.CallID},
+		})
+	case eventAnswer:
+		target := p.localPeer(content.CallID)
+		if content.Answer == nil || target == "" {
+			return
+		}
+		p.client.Receive(&signaling.Message{
+			Type: "answer",
+			To:   target,
+			Data: map[string]interface{}{"sdp": content.Answer, "callId": content.CallID},
+		})
+	case eventCandidates:
+		target := p.localPeer(content.CallID)
+		if target == "" {
+			return
+		}
+		for _, candidate := range content.Candidates {
+			p.client.Receive(&signaling.Message{
+				Type: "ice-candidate",
+				To:   target,
+				Data: map[string]interface{}{"candidate": candidate},
+			})
+		}
+	case eventHangup:
+		if p.forget(content.CallID) {
+			a.removePeer(p)
+		}
+	}
+}
+
+// peer returns the virtual participant of a Matrix user, joining them to the
+// local room on first contact
+func (a *Adapter) peer(ctx context.Context, matrixRoom, localRoom, matrixUser string) (*peer, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	key := matrixRoom + "|" + matrixUser
+	if p, exists := a.peers[key]; exists {
+		return p, nil
+	}
+
+	p := &peer{
+		adapter:    a,
+		matrixRoom: matrixRoom,
+		matrixUser: matrixUser,
+		partyID:    randomID(),
+		calls:      make(map[string]string),
+		byLocal:    make(map[string]string),
+	}
+	client, err := signaling.NewVirtualClient(clientID(matrixUser), a.hub, localRoom,
+		signaling.ClientOptions{UserID: matrixUser, DeviceID: "matrix"},
+		func(msg *signaling.Message) { p.deliver(ctx, msg) })
+	if err != nil {
+		return nil, err
+	}
+	p.client = client
+	a.peers[key] = p
+	util.Info("Matrix user %s joined room %s as %s", matrixUser, localRoom, client.ID)
+	return p, nil
+}
+
+// removePeer takes a Matrix user out of the local room
+func (a *Adapter) removePeer(p *peer) {
+	a.mutex.Lock()
+	delete(a.peers, p.matrixRoom+"|"+p.matrixUser)
+	a.mutex.Unlock()
+	p.client.Close()
+}
+
+// deliver turns local signaling addressed to the Matrix user into m.call.* events
+func (p *peer) deliver(ctx context.Context, msg *signaling.Message) {
+	var eventType string
+	content := callContent{PartyID: p.partyID, Version: "1"}
+
+	switch msg.Type {
+	case "offer":
+		content.CallID = p.callFor(msg.From, true)
+		content.Lifetime = inviteLifetime
+		content.Offer = descriptionFrom(msg, "offer")
+		eventType = eventInvite
+	case "answer":
+		content.CallID = p.callFor(msg.From, false)
+		content.Answer = descriptionFrom(msg, "answer")
+		eventType = eventAnswer
+	case "ice-candidate":
+		content.CallID = p.callFor(msg.From, false)
+		if candidate, ok := msg.Data["candidate"].(map[string]interface{}); ok {
+			content.Candidates = []map[string]interface{}{candidate}
+		}
+		eventType = eventCandidates
+	case "user-left":
+		leaver, _ := msg.Data["userId"].(string)
+		content.CallID = p.callFor(leaver, false)
+		p.forget(content.CallID)
+		eventType = eventHangup
+	default:
+		return
+	}
+	if content.CallID == "" || (eventType == eventInvite && content.Offer == nil) ||
+		(eventType == eventAnswer && content.Answer == nil) ||
+		(eventType == eventCandidates && len(content.Candidates) == 0) {
+		return
+	}
+
+	if err := p.adapter.client.SendEvent(ctx, p.matrixRoom, eventType, content); err != nil {
+		util.Warn("Error sending %s to Matrix room %s: %v", eventType, p.matrixRoom, err)
+	}
+}
+
+// answerer picks the local participant that answers an incoming call: the
+// host, or anyone else if the host is the Matrix user itself
+func (p *peer) answerer() string {
+	room := p.client.Room
+	if host := room.GetHost(); host != "" && host != p.client.ID {
+		return host
+	}
+	for _, client := range room.GetClients() {
+		if client.ID != p.client.ID {
+			return client.ID
+		}
+	}
+	return ""
+}
+
+// track links a call ID to a local participant
+func (p *peer) track(callID, localID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.calls[callID] = localID
+	p.byLocal[localID] = callID
+}
+
+// localPeer returns the local participant of a call
+func (p *peer) localPeer(callID string) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.calls[callID]
+}
+
+// callFor returns the call with a local participant, starting one if asked
+func (p *peer) callFor(localID string, start bool) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if callID, exists := p.byLocal[localID]; exists || !start {
+		return callID
+	}
+	callID := randomID()
+	p.calls[callID] = localID
+	p.byLocal[localID] = callID
+	return callID
+}
+
+// forget ends a call and reports whether the Matrix user has no calls left
+func (p *peer) forget(callID string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if localID, exists := p.calls[callID]; exists {
+		delete(p.calls, callID)
+		delete(p.byLocal, localID)
+	}
+	return len(p.calls) == 0
+}
+
+// descriptionFrom extracts the SDP of a local offer or answer, which clients
+// send either as {"sdp": {"type", "sdp"}} or as a plain string
+func descriptionFrom(msg *signaling.Message, sdpType string) *sessionDescription {
+	switch sdp := msg.Data["sdp"].(type) {
+	case string:
+		return &sessionDescription{Type: sdpType, SDP: sdp}
+	case map[string]interface{}:
+		if text, ok := sdp["sdp"].(string); ok {
+			return &sessionDescription{Type: sdpType, SDP: text}
+		}
+	case *sessionDescription:
+		return sdp
+	}
+	return nil
+}
+
+// clientID derives a local client ID from a Matrix user ID
+func clientID(matrixUser string) string {
+	replacer := strings.NewReplacer("@", "", ":", "-")
+	return "matrix-" + replacer.Replace(matrixUser)
+}
+
+// randomID returns a random call or party ID
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,83 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestAdapterCallFlow(t *testing.T) {
+	sent := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sent <- r.URL.Path + " " + string(body)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	hub := signaling.NewHub()
+	offers := make(chan *signaling.Message, 10)
+	host, err := signaling.NewVirtualClient("host", hub, "call-room", signaling.ClientOptions{}, func(msg *signaling.Message) {
+		if msg.Type == "offer" {
+			offers <- msg
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adapter := NewAdapter(NewClient(server.URL, "token"), hub, ParseRoomMap("call-room=!abc:example.org"))
+	adapter.userID = "@bot:example.org"
+	ctx := context.Background()
+
+	invite := Event{
+		Type:    eventInvite,
+		Sender:  "@alice:example.org",
+		Content: json.RawMessage(`{"call_id": "c1", "party_id": "p1", "version": "1", "offer": {"type": "offer", "sdp": "v=0"}}`),
+	}
+	adapter.handleEvent(ctx, "!abc:example.org", invite)
+
+	// The Matrix user joins the room and its invite reaches the host as an offer
+	select {
+	case offer := <-offers:
+		if offer.From != "matrix-alice-example.org" || offer.Data["callId"] != "c1" {
+			t.Errorf("Unexpected offer %+v", offer)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the host to receive the Matrix offer")
+	}
+
+	// The host's answer goes back as m.call.answer on the same call
+	host.Receive(&signaling.Message{Type: "answer", To: "matrix-alice-example.org",
+		Data: map[string]interface{}{"sdp": map[string]interface{}{"type": "answer", "sdp": "v=0 answer"}}})
+	select {
+	case request := <-sent:
+		if !strings.Contains(request, "/send/m.call.answer/") || !strings.Contains(request, `"call_id":"c1"`) {
+			t.Errorf("Unexpected Matrix request %s", request)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected an m.call.answer to be sent")
+	}
+
+	// Hanging up removes the Matrix user from the room
+	adapter.handleEvent(ctx, "!abc:example.org", Event{Type: eventHangup, Sender: "@alice:example.org",
+		Content: json.RawMessage(`{"call_id": "c1", "version": "1"}`)})
+	if len(hub.FindRoom("call-room").GetClients()) != 1 {
+		t.Error("Expected only the host to remain after the hangup")
+	}
+
+	// Events in unmapped rooms and our own events are ignored
+	adapter.handleEvent(ctx, "!other:example.org", invite)
+	invite.Sender = "@bot:example.org"
+	adapter.handleEvent(ctx, "!abc:example.org", invite)
+	if len(hub.FindRoom("call-room").GetClients()) != 1 {
+		t.Error("Expected ignored events not to add participants")
+	}
+}
@@ -0,0 +1,122 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Event is a Matrix room event as returned by /sync
+type Event struct {
+	Type    string          `json:"type"`
+	Sender  string          `json:"sender"`
+	EventID string          `json:"event_id"`
+	Content json.RawMessage `json:"content"`
+}
+
+// syncResponse holds the parts of a /sync response the adapter uses
+type syncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []Event `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+// Client talks to a Matrix homeserver's client-server API as one user
+type Client struct {
+	homeserver  string
+	accessToken string
+	http        *http.Client
+	txn         atomic.Uint64
+}
+
+// NewClient creates a client for a homeserver URL and access token
+func NewClient(homeserver, accessToken string) *Client {
+	return &Client{
+		homeserver:  homeserver,
+		accessToken: accessToken,
+		http:        &http.Client{Timeout: time.Minute},
+	}
+}
+
+// WhoAmI returns the user ID the access token belongs to
+func (c *Client) WhoAmI(ctx context.Context) (string, error) {
+	var resp struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.do(ctx, "GET", "/_matrix/client/v3/account/whoami", nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.UserID, nil
+}
+
+// Sync long-polls for new events, returning the events per joined room and
+// the token for the next call
+func (c *Client) Sync(ctx context.Context, since string, timeout time.Duration) (map[string][]Event, string, error) {
+	query := url.Values{"timeout": {strconv.FormatInt(timeout.Milliseconds(), 10)}}
+	if since != "" {
+		query.Set("since", since)
+	}
+
+	var resp syncResponse
+	if err := c.do(ctx, "GET", "/_matrix/client/v3/sync?"+query.Encode(), nil, &resp); err != nil {
+		return nil, since, err
+	}
+	events := make(map[string][]Event, len(resp.Rooms.Join))
+	for roomID, room := range resp.Rooms.Join {
+		events[roomID] = room.Timeline.Events
+	}
+	return events, resp.NextBatch, nil
+}
+
+// SendEvent sends a message event to a Matrix room
+func (c *Client) SendEvent(ctx context.Context, roomID, eventType string, content interface{}) error {
+	txnID := fmt.Sprintf("%d-%d", time.Now().UnixNano(), c.txn.Add(1))
+	path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/%s/%s",
+		url.PathEscape(roomID), url.PathEscape(eventType), txnID)
+	return c.do(ctx, "PUT", path, content, nil)
+}
+
+// do performs an authenticated API request
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.homeserver+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("matrix %s %s: %s", method, path, resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+// Room value used for series rolled up from rooms without their own series
+const otherRooms = "other"
+
+// Upper bounds of the room size and health score histograms
+var (
+	roomSizeBuckets    = []float64{1, 2, 5, 10, 25, 50, 100}
+	healthScoreBuckets = []float64{25, 50, 75, 90, 100}
+)
+
+// HubOptions controls the cardinality of hub metrics
+type HubOptions struct {
+	// Rooms with the most clients that keep individual series; the rest are
+	// rolled up into room="other" series and histograms. Zero keeps every
+	// room individual
+	TopRooms int
+}
+
+// HubSource reports the rooms of a signaling hub, tagged by room and tenant
+func HubSource(hub *signaling.Hub) Source {
+	return HubSourceWithOptions(hub, HubOptions{})
+}
+
+// HubSourceWithOptions reports the rooms of a hub, limiting per-room series
+// as configured. Room size and health histograms always cover every room,
+// so dashboards work the same however many rooms are rolled up
+func HubSourceWithOptions(hub *signaling.Hub, opts HubOptions) Source {
+	return func() []Sample {
+		rooms := hub.Stats()
+		clients := 0
+		for _, room := range rooms {
+			clients += room.Clients
+		}
+		samples := []Sample{
+			{Name: "rooms.active", Kind: Gauge, Value: float64(len(rooms))},
+			{Name: "clients.connected", Kind: Gauge, Value: float64(clients)},
+		}
+
+		individual, rolledUp := splitRooms(rooms, opts.TopRooms)
+		for _, room := range individual {
+			samples = append(samples, roomSamples(room)...)
+		}
+		if len(rolledUp) > 0 {
+			samples = append(samples, rollUp(rolledUp)...)
+		}
+
+		samples = append(samples, histogram("rooms.size", roomSizeBuckets, rooms, func(room signaling.RoomStats) float64 {
+			return float64(room.Clients)
+		})...)
+		samples = append(samples, histogram("rooms.health", healthScoreBuckets, rooms, func(room signaling.RoomStats) float64 {
+			return float64(room.Health.Score)
+		})...)
+		samples = append(samples, iceSamples(hub.ICEServerHealth())...)
+		samples = append(samples, transportSamples(hub.Transports())...)
+		return append(samples, trafficSamples(hub.Traffic())...)
+	}
+}
+
+// iceSamples returns the health of the checked STUN and TURN servers: one
+// ice.server.up series per URL, and healthy and unhealthy counts per kind
+func iceSamples(statuses []signaling.ICEServerStatus) []Sample {
+	if len(statuses) == 0 {
+		return nil
+	}
+	counts := map[string]map[bool]int{"stun": {}, "turn": {}}
+	var samples []Sample
+	for _, status := range statuses {
+		kind := "stun"
+		if status.IsTURN() {
+			kind = "turn"
+		}
+		counts[kind][status.Healthy]++
+		up := 0.0
+		if status.Healthy {
+			up = 1
+		}
+		samples = append(samples, Sample{Name: "ice.server.up", Kind: Gauge, Value: up, Tags: Tags{"url": status.URL, "kind": kind}})
+	}
+	for _, kind := range []string{"stun", "turn"} {
+		samples = append(samples,
+			Sample{Name: "ice.servers.healthy", Kind: Gauge, Value: float64(counts[kind][true]), Tags: Tags{"kind": kind}},
+			Sample{Name: "ice.servers.unhealthy", Kind: Gauge, Value: float64(counts[kind][false]), Tags: Tags{"kind": kind}},
+		)
+	}
+	return samples
+}
+
+// transportSamples returns how many clients reported each ICE transport,
+// as clients.transport
+func transportSamples(counts map[string]int) []Sample {
+	samples := make([]Sample, 0, len(signaling.Transports))
+	for _, transport := range signaling.Transports {
+		samples = append(samples, Sample{Name: "clients.transport", Kind: Gauge, Value: float64(counts[transport]), Tags: Tags{"transport": transport}})
+	}
+	return samples
+}
+
+// trafficSamples returns the hub-wide traffic counters, which keep counting
+// across rooms opening and closing
+func trafficSamples(traffic signaling.TrafficStats) []Sample {
+	samples := []Sample{
+		{Name: "messages.dropped", Kind: Counter, Value: float64(traffic.Dropped)},
+	}
+	for _, msgType := range sortedKeys(traffic.Relayed) {
+		samples = append(samples, Sample{
+			Name:  "messages.relayed",
+			Kind:  Counter,
+			Value: float64(traffic.Relayed[msgType]),
+			Tags:  Tags{"type": msgType},
+		})
+	}
+	for _, kind := range sortedKeys(traffic.WebSocketErrors) {
+		samples = append(samples, Sample{
+			Name:  "websocket.errors",
+			Kind:  Counter,
+			Value: float64(traffic.WebSocketErrors[kind]),
+			Tags:  Tags{"kind": kind},
+		})
+	}
+
+	fanOut := traffic.FanOut
+	for i, bound := range fanOut.Bounds {
+		samples = append(samples, Sample{
+			Name:  "messages.fanout_seconds.bucket",
+			Kind:  Counter,
+			Value: float64(fanOut.Counts[i]),
+			Tags:  Tags{"le": strconv.FormatFloat(bound, 'f', -1, 64)},
+		})
+	}
+	return append(samples,
+		Sample{Name: "messages.fanout_seconds.bucket", Kind: Counter, Value: float64(fanOut.Count), Tags: Tags{"le": "+Inf"}},
+		Sample{Name: "messages.fanout_seconds.sum", Kind: Counter, Value: fanOut.Sum},
+		Sample{Name: "messages.fanout_seconds.count", Kind: Counter, Value: float64(fanOut.Count)},
+	)
+}
+
+// sortedKeys returns the keys of a count map in order
+func sortedKeys(counts map[string]uint64) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitRooms returns the top rooms by client count and the remaining ones
+func splitRooms(rooms []signaling.RoomStats, top int) ([]signaling.RoomStats, []signaling.RoomStats) {
+	if top <= 0 || len(rooms) <= top {
+		return rooms, nil
+	}
+	sorted := append([]signaling.RoomStats(nil), rooms...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Clients > sorted[j].Clients
+	})
+	return sorted[:top], sorted[top:]
+}
+
+// roomSamples returns the per-room series of a room
+func roomSamples(room signaling.RoomStats) []Sample {
+	return statsSamples(room, Tags{"room": room.RoomID, "tenant": room.Tenant})
+}
+
+// rollUp sums rooms into series tagged room="other", one set per tenant
+func rollUp(rooms []signaling.RoomStats) []Sample {
+	byTenant := make(map[string]*signaling.RoomStats)
+	var tenants []string
+	for _, room := range rooms {
+		total, exists := byTenant[room.Tenant]
+		if !exists {
+			total = &signaling.RoomStats{Tenant: room.Tenant, ClientErrors: make(map[string]int)}
+			byTenant[room.Tenant] = total
+			tenants = append(tenants, room.Tenant)
+		}
+		total.Clients += room.Clients
+		total.ReadyClients += room.ReadyClients
+		total.MessagesReceived += room.MessagesReceived
+		total.MessagesDropped += room.MessagesDropped
+		total.Health.Reconnects += room.Health.Reconnects
+		for kind, count := range room.ClientErrors {
+			total.ClientErrors[kind] += count
+		}
+	}
+
+	sort.Strings(tenants)
+	var samples []Sample
+	for _, tenant := range tenants {
+		total := byTenant[tenant]
+		for _, sample := range statsSamples(*total, Tags{"room": otherRooms, "tenant": tenant}) {
+			// An average score of unrelated rooms means nothing; the health
+			// histogram covers them instead
+			if sample.Name != "room.health.score" {
+				samples = append(samples, sample)
+			}
+		}
+	}
+	return samples
+}
+
+// statsSamples returns the series of one room or roll-up with the given tags
+func statsSamples(room signaling.RoomStats, tags Tags) []Sample {
+	samples := []Sample{
+		{Name: "room.clients", Kind: Gauge, Value: float64(room.Clients), Tags: tags},
+		{Name: "room.clients.ready", Kind: Gauge, Value: float64(room.ReadyClients), Tags: tags},
+		{Name: "room.health.score", Kind: Gauge, Value: float64(room.Health.Score), Tags: tags},
+		{Name: "room.messages.received", Kind: Counter, Value: float64(room.MessagesReceived), Tags: tags},
+		{Name: "room.messages.dropped", Kind: Counter, Value: float64(room.MessagesDropped), Tags: tags},
+		{Name: "room.reconnects", Kind: Counter, Value: float64(room.Health.Reconnects), Tags: tags},
+	}
+	kinds := make([]string, 0, len(room.ClientErrors))
+	for kind := range room.ClientErrors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		errorTags := Tags{"kind": kind}
+		for k, v := range tags {
+			errorTags[k] = v
+		}
+		samples = append(samples, Sample{
+			Name:  "room.client_errors",
+			Kind:  Counter,
+			Value: float64(room.ClientErrors[kind]),
+			Tags:  errorTags,
+		})
+	}
+	return samples
+}
+
+// histogram returns cumulative bucket gauges in the Prometheus convention:
+// one series per upper bound tagged "le", plus "+Inf" and a sum
+func histogram(name string, bounds []float64, rooms []signaling.RoomStats, value func(signaling.RoomStats) float64) []Sample {
+	counts := make([]int, len(bounds))
+	sum := 0.0
+	for _, room := range rooms {
+		v := value(room)
+		sum += v
+		for i, bound := range bounds {
+			if v <= bound {
+				counts[i]++
+			}
+		}
+	}
+
+	samples := make([]Sample, 0, len(bounds)+2)
+	for i, bound := range bounds {
+		samples = append(samples, Sample{
+			Name:  name + ".bucket",
+			Kind:  Gauge,
+			Value: float64(counts[i]),
+			Tags:  Tags{"le": strconv.FormatFloat(bound, 'f', -1, 64)},
+		})
+	}
+	samples = append(samples,
+		Sample{Name: name + ".bucket", Kind: Gauge, Value: float64(len(rooms)), Tags: Tags{"le": "+Inf"}},
+		Sample{Name: name + ".sum", Kind: Gauge, Value: sum},
+	)
+	return samples
+}
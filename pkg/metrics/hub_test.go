@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestHubSourceTopRooms(t *testing.T) {
+	hub := signaling.NewHub()
+	for i := 1; i <= 4; i++ {
+		room := hub.GetRoom(fmt.Sprintf("room-%d", i))
+		for j := 0; j < i; j++ {
+			room.AddClient(&signaling.Client{ID: fmt.Sprintf("client-%d-%d", i, j)})
+		}
+	}
+
+	samples := HubSourceWithOptions(hub, HubOptions{TopRooms: 2})()
+	clients := make(map[string]float64)
+	buckets := make(map[string]float64)
+	for _, sample := range samples {
+		switch sample.Name {
+		case "room.clients":
+			clients[sample.Tags["room"]] = sample.Value
+		case "rooms.size.bucket":
+			buckets[sample.Tags["le"]] = sample.Value
+		}
+	}
+
+	// The two largest rooms keep their own series, the rest are summed
+	if len(clients) != 3 || clients["room-4"] != 4 || clients["room-3"] != 3 || clients[otherRooms] != 3 {
+		t.Errorf("Unexpected room series: %v", clients)
+	}
+	if buckets["1"] != 1 || buckets["2"] != 2 || buckets["5"] != 4 || buckets["+Inf"] != 4 {
+		t.Errorf("Unexpected room size buckets: %v", buckets)
+	}
+
+	// Without a limit every room is individual
+	samples = HubSource(hub)()
+	count := 0
+	for _, sample := range samples {
+		if sample.Name == "room.clients" {
+			count++
+		}
+	}
+	if count != 4 {
+		t.Errorf("Expected 4 room series without a limit, got %d", count)
+	}
+}
+
+func TestHubSourceICEHealth(t *testing.T) {
+	hub := signaling.NewHub()
+	hub.SetTURNConfig(signaling.TURNConfig{URLs: []string{"stun:a.example.com", "turn:a.example.com", "turn:b.example.com"}})
+	for i := 0; i < 2; i++ {
+		hub.RecordICEServerCheck("turn:b.example.com", errors.New("timeout"))
+	}
+	hub.RecordICEServerCheck("turn:a.example.com", nil)
+	hub.RecordICEServerCheck("stun:a.example.com", nil)
+
+	values := make(map[string]float64)
+	for _, sample := range HubSource(hub)() {
+		if strings.HasPrefix(sample.Name, "ice.") {
+			values[sample.Name+" "+sample.Tags["kind"]+" "+sample.Tags["url"]] = sample.Value
+		}
+	}
+	want := map[string]float64{
+		"ice.servers.healthy turn ":             1,
+		"ice.servers.unhealthy turn ":           1,
+		"ice.servers.healthy stun ":             1,
+		"ice.servers.unhealthy stun ":           0,
+		"ice.server.up turn turn:b.example.com": 0,
+		"ice.server.up turn turn:a.example.com": 1,
+		"ice.server.up stun stun:a.example.com": 1,
+	}
+	for key, value := range want {
+		if got, found := values[key]; !found || got != value {
+			t.Errorf("Expected %s = %v, got %v", key, value, values)
+		}
+	}
+}
+
+func TestHubSourceTransports(t *testing.T) {
+	hub := signaling.NewHub()
+	values := make(map[string]float64)
+	for _, sample := range HubSource(hub)() {
+		if sample.Name == "clients.transport" {
+			values[sample.Tags["transport"]] = sample.Value
+		}
+	}
+	if len(values) != len(signaling.Transports) || values[signaling.TransportTURNTLS] != 0 {
+		t.Errorf("Expected a zero series per transport, got %v", values)
+	}
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Kind tells exporters how to interpret a sample
+type Kind int
+
+const (
+	// Gauge is a value that goes up and down, e.g. connected clients
+	Gauge Kind = iota
+
+	// Counter is a running total since the server started
+	Counter
+)
+
+// Tags are the dimensions of a sample, such as room, tenant and node
+type Tags map[string]string
+
+// Sample is one metric value
+type Sample struct {
+	Name  string
+	Kind  Kind
+	Value float64
+	Tags  Tags
+}
+
+// key identifies a series by name and tags
+func (s Sample) key() string {
+	keys := make([]string, 0, len(s.Tags))
+	for k := range s.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(s.Name)
+	for _, k := range keys {
+		b.WriteString("|" + k + "=" + s.Tags[k])
+	}
+	return b.String()
+}
+
+// Source produces the current samples
+type Source func() []Sample
+
+// Exporter ships samples to a monitoring system
+type Exporter interface {
+	Export(samples []Sample) error
+	Close() error
+}
+
+// Reporter periodically collects samples from a source and hands them to
+// its exporters
+type Reporter struct {
+	source    Source
+	exporters []Exporter
+	interval  time.Duration
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewReporter creates a reporter; call Start to begin exporting
+func NewReporter(interval time.Duration, source Source, exporters ...Exporter) *Reporter {
+	return &Reporter{
+		source:    source,
+		exporters: exporters,
+		interval:  interval,
+		done:      make(chan struct{}),
+	}
+}
+
+// Start exports samples every interval until Stop is called
+func (r *Reporter) Start() {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.done:
+				return
+			case <-ticker.C:
+				r.Report()
+			}
+		}
+	}()
+	util.Info("Exporting metrics every %v to %d exporters", r.interval, len(r.exporters))
+}
+
+// Report collects and exports samples once
+func (r *Reporter) Report() {
+	samples := r.source()
+	for _, exporter := range r.exporters {
+		if err := exporter.Export(samples); err != nil {
+			util.Warn("Error exporting metrics: %v", err)
+		}
+	}
+}
+
+// Stop stops exporting and closes the exporters
+func (r *Reporter) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.done)
+		for _, exporter := range r.exporters {
+			exporter.Close()
+		}
+	})
+}
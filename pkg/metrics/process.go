@@ -0,0 +1,27 @@
+package metrics
+
+import "runtime"
+
+// ProcessSource reports the server process and the node it runs on
+func ProcessSource() Source {
+	return func() []Sample {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		samples := []Sample{
+			{Name: "process.goroutines", Kind: Gauge, Value: float64(runtime.NumGoroutine())},
+			{Name: "process.memory.heap_bytes", Kind: Gauge, Value: float64(mem.HeapAlloc)},
+		}
+		return append(samples, systemSamples()...)
+	}
+}
+
+// Combine merges the samples of several sources
+func Combine(sources ...Source) Source {
+	return func() []Sample {
+		var samples []Sample
+		for _, source := range sources {
+			samples = append(samples, source()...)
+		}
+		return samples
+	}
+}
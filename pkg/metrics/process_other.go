@@ -0,0 +1,8 @@
+//go:build !unix
+
+package metrics
+
+// systemSamples has nothing to report on this platform
+func systemSamples() []Sample {
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package metrics
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// systemSamples reports CPU time used by the process and, on Linux, the
+// node's load average
+func systemSamples() []Sample {
+	var samples []Sample
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err == nil {
+		cpu := time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+		samples = append(samples, Sample{Name: "process.cpu.seconds", Kind: Counter, Value: cpu.Seconds()})
+	}
+
+	if data, err := os.ReadFile("/proc/loadavg"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			if load, err := strconv.ParseFloat(fields[0], 64); err == nil {
+				samples = append(samples, Sample{Name: "node.load1", Kind: Gauge, Value: load})
+			}
+		}
+	}
+	return samples
+}
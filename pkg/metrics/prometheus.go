@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Content type of the Prometheus text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PrometheusHandler serves the samples of a source in the Prometheus text
+// format, collecting them on every scrape. Names get the namespace as a
+// prefix, e.g. "chatvideo", and underscores for dots, and counters get a
+// "_total" suffix. Samples named "<name>.bucket" with an "le" tag, with
+// "<name>.sum" and "<name>.count", make up a histogram
+func PrometheusHandler(source Source, namespace string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", prometheusContentType)
+		w.Write(formatPrometheus(source(), namespace))
+	})
+}
+
+// family is the samples of one Prometheus metric
+type family struct {
+	name    string
+	kind    string
+	samples []Sample
+	names   []string // Of each sample, with histogram suffixes
+}
+
+// formatPrometheus renders samples in the text exposition format, one
+// family after the other, ordered by name
+func formatPrometheus(samples []Sample, namespace string) []byte {
+	histograms := make(map[string]bool)
+	for _, sample := range samples {
+		if base, found := strings.CutSuffix(sample.Name, ".bucket"); found && sample.Tags["le"] != "" {
+			histograms[base] = true
+		}
+	}
+
+	families := make(map[string]*family)
+	counted := make(map[string]bool) // Histograms that report their count
+	for _, sample := range samples {
+		base, suffix, kind := sample.Name, "", "gauge"
+		if sample.Kind == Counter {
+			kind = "counter"
+		}
+		for _, part := range []string{".bucket", ".sum", ".count"} {
+			if trimmed, found := strings.CutSuffix(sample.Name, part); found && histograms[trimmed] {
+				base, suffix, kind = trimmed, "_"+part[1:], "histogram"
+				if part == ".count" {
+					counted[trimmed] = true
+				}
+				break
+			}
+		}
+
+		name := prometheusName(namespace, base)
+		if kind == "counter" {
+			name += "_total"
+		}
+		f, exists := families[name]
+		if !exists {
+			f = &family{name: name, kind: kind}
+			families[name] = f
+		}
+		f.samples = append(f.samples, sample)
+		f.names = append(f.names, name+suffix)
+	}
+
+	// Histograms without a count sample count what their +Inf bucket holds
+	for base := range histograms {
+		if counted[base] {
+			continue
+		}
+		f := families[prometheusName(namespace, base)]
+		for _, sample := range f.samples {
+			if sample.Tags["le"] == "+Inf" {
+				f.samples = append(f.samples, Sample{Value: sample.Value, Tags: withoutTag(sample.Tags, "le")})
+				f.names = append(f.names, f.name+"_count")
+			}
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out bytes.Buffer
+	for _, name := range names {
+		f := families[name]
+		out.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+		for i, sample := range f.samples {
+			out.WriteString(f.names[i])
+			writeLabels(&out, sample.Tags)
+			out.WriteString(" " + strconv.FormatFloat(sample.Value, 'g', -1, 64) + "\n")
+		}
+	}
+	return out.Bytes()
+}
+
+// prometheusName turns a sample name into a valid metric name
+func prometheusName(namespace, name string) string {
+	if namespace != "" {
+		name = namespace + "_" + name
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// writeLabels writes tags as labels, ordered by name
+func writeLabels(out *bytes.Buffer, tags Tags) {
+	if len(tags) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		out.WriteString(prometheusName("", key) + `="` + escapeLabel(tags[key]) + `"`)
+	}
+	out.WriteByte('}')
+}
+
+// escapeLabel escapes a label value
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// withoutTag copies tags without one of them
+func withoutTag(tags Tags, name string) Tags {
+	copied := make(Tags, len(tags))
+	for k, v := range tags {
+		if k != name {
+			copied[k] = v
+		}
+	}
+	return copied
+}
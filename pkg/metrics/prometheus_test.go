@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	source := func() []Sample {
+		return []Sample{
+			{Name: "rooms.active", Kind: Gauge, Value: 2},
+			{Name: "messages.relayed", Kind: Counter, Value: 5, Tags: Tags{"type": "chat"}},
+			{Name: "messages.relayed", Kind: Counter, Value: 7, Tags: Tags{"type": `odd"type`}},
+			{Name: "rooms.size.bucket", Kind: Gauge, Value: 1, Tags: Tags{"le": "1"}},
+			{Name: "rooms.size.bucket", Kind: Gauge, Value: 2, Tags: Tags{"le": "+Inf"}},
+			{Name: "rooms.size.sum", Kind: Gauge, Value: 4},
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	PrometheusHandler(source, "chatvideo").ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Unexpected content type %q", contentType)
+	}
+
+	expected := `# TYPE chatvideo_messages_relayed_total counter
+chatvideo_messages_relayed_total{type="chat"} 5
+chatvideo_messages_relayed_total{type="odd\"type"} 7
+# TYPE chatvideo_rooms_active gauge
+chatvideo_rooms_active 2
+# TYPE chatvideo_rooms_size histogram
+chatvideo_rooms_size_bucket{le="1"} 1
+chatvideo_rooms_size_bucket{le="+Inf"} 2
+chatvideo_rooms_size_sum 4
+chatvideo_rooms_size_count 2
+`
+	if recorder.Body.String() != expected {
+		t.Errorf("Unexpected exposition:\n%s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,18 @@
+package metrics
+
+import (
+	"github.com/nikhilsahni7/chat-video-app/pkg/sfu"
+)
+
+// SFUSource reports the SFU's connected sessions as sfu.sessions tagged by
+// the transport their media flows over
+func SFUSource(media *sfu.SFU) Source {
+	return func() []Sample {
+		counts := media.Transports()
+		var samples []Sample
+		for _, transport := range []string{sfu.TransportUDP, sfu.TransportTCP, sfu.TransportRelay} {
+			samples = append(samples, Sample{Name: "sfu.sessions", Kind: Gauge, Value: float64(counts[transport]), Tags: Tags{"transport": transport}})
+		}
+		return samples
+	}
+}
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StatsD line formats
+const (
+	// FormatStatsD is the plain Etsy format, which has no tags
+	FormatStatsD = "statsd"
+
+	// FormatDogStatsD adds tags as "|#key:value,..."
+	FormatDogStatsD = "dogstatsd"
+)
+
+// Maximum payload of one UDP packet; larger batches are split
+const maxPacketSize = 1432
+
+// StatsDConfig configures a StatsD exporter
+type StatsDConfig struct {
+	Addr   string // host:port of the agent
+	Format string // FormatStatsD or FormatDogStatsD
+	Prefix string // Prepended to every metric name, e.g. "chatvideo."
+
+	// Tags added to every sample, e.g. the node name
+	Tags Tags
+}
+
+// StatsDExporter sends samples to a StatsD or DogStatsD agent over UDP.
+// Gauges are sent as is; counters are sent as the increase since the last
+// export
+type StatsDExporter struct {
+	config StatsDConfig
+	conn   net.Conn
+	mutex  sync.Mutex
+	last   map[string]float64
+}
+
+// NewStatsDExporter creates an exporter sending to the configured agent
+func NewStatsDExporter(config StatsDConfig) (*StatsDExporter, error) {
+	switch config.Format {
+	case "":
+		config.Format = FormatDogStatsD
+	case FormatStatsD, FormatDogStatsD:
+	default:
+		return nil, fmt.Errorf("unknown StatsD format %q", config.Format)
+	}
+
+	conn, err := net.Dial("udp", config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to StatsD agent: %w", err)
+	}
+	return &StatsDExporter{config: config, conn: conn, last: make(map[string]float64)}, nil
+}
+
+// Export sends the samples, batching lines into packets
+func (e *StatsDExporter) Export(samples []Sample) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var packet []byte
+	for _, sample := range samples {
+		line, ok := e.line(sample)
+		if !ok {
+			continue
+		}
+		if len(packet) > 0 && len(packet)+1+len(line) > maxPacketSize {
+			if _, err := e.conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		_, err := e.conn.Write(packet)
+		return err
+	}
+	return nil
+}
+
+// line formats a sample, returning false for counters that didn't change
+func (e *StatsDExporter) line(sample Sample) (string, bool) {
+	value := sample.Value
+	metricType := "g"
+	if sample.Kind == Counter {
+		key := sample.key()
+		delta := value - e.last[key]
+		e.last[key] = value
+		if delta <= 0 {
+			return "", false
+		}
+		value = delta
+		metricType = "c"
+	}
+
+	line := e.config.Prefix + sanitize(sample.Name) + ":" +
+		strconv.FormatFloat(value, 'f', -1, 64) + "|" + metricType
+	if e.config.Format == FormatDogStatsD {
+		if tags := e.tags(sample.Tags); tags != "" {
+			line += "|#" + tags
+		}
+	}
+	return line, true
+}
+
+// tags formats the exporter's and the sample's tags in a stable order
+func (e *StatsDExporter) tags(sampleTags Tags) string {
+	merged := make(map[string]string, len(e.config.Tags)+len(sampleTags))
+	for k, v := range e.config.Tags {
+		merged[k] = v
+	}
+	for k, v := range sampleTags {
+		merged[k] = v
+	}
+
+	pairs := make([]string, 0, len(merged))
+	for k, v := range merged {
+		if v != "" {
+			pairs = append(pairs, sanitize(k)+":"+sanitize(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Close closes the UDP socket
+func (e *StatsDExporter) Close() error {
+	return e.conn.Close()
+}
+
+// sanitize replaces characters that would break the line protocol
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '#', ',', '@', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
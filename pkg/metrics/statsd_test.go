@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsDExporter(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	exporter, err := NewStatsDExporter(StatsDConfig{
+		Addr:   listener.LocalAddr().String(),
+		Prefix: "app.",
+		Tags:   Tags{"node": "node-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	read := func() string {
+		buf := make([]byte, maxPacketSize)
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Error reading packet: %v", err)
+		}
+		return string(buf[:n])
+	}
+
+	samples := []Sample{
+		{Name: "room.clients", Kind: Gauge, Value: 3, Tags: Tags{"room": "r1", "tenant": "acme"}},
+		{Name: "room.messages.received", Kind: Counter, Value: 10, Tags: Tags{"room": "r1"}},
+	}
+	exporter.Export(samples)
+	lines := strings.Split(read(), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", lines)
+	}
+	if lines[0] != "app.room.clients:3|g|#node:node-1,room:r1,tenant:acme" {
+		t.Errorf("Unexpected gauge line %q", lines[0])
+	}
+	if lines[1] != "app.room.messages.received:10|c|#node:node-1,room:r1" {
+		t.Errorf("Unexpected counter line %q", lines[1])
+	}
+
+	// Counters are sent as increases; unchanged counters are skipped
+	samples[1].Value = 15
+	exporter.Export(samples)
+	if packet := read(); !strings.Contains(packet, "app.room.messages.received:5|c") {
+		t.Errorf("Expected a counter increase of 5, got %q", packet)
+	}
+	exporter.Export(samples)
+	if packet := read(); strings.Contains(packet, "messages.received") {
+		t.Errorf("Expected an unchanged counter to be skipped, got %q", packet)
+	}
+}
+
+func TestStatsDFormats(t *testing.T) {
+	if _, err := NewStatsDExporter(StatsDConfig{Addr: "127.0.0.1:8125", Format: "graphite"}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+
+	exporter, err := NewStatsDExporter(StatsDConfig{Addr: "127.0.0.1:8125", Format: FormatStatsD})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	line, _ := exporter.line(Sample{Name: "room.clients", Value: 1, Tags: Tags{"room": "r1"}})
+	if line != "room.clients:1|g" {
+		t.Errorf("Expected plain StatsD lines without tags, got %q", line)
+	}
+}
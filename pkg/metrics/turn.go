@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"sort"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/turnserver"
+)
+
+// TURNSource reports the traffic relayed by TURN servers per tenant, as
+// turn.bytes_relayed tagged by direction and turn.bytes_dropped
+func TURNSource(meter *turnserver.Meter) Source {
+	return func() []Sample {
+		byTenant := make(map[string]*turnserver.Usage)
+		for _, usage := range meter.Usage() {
+			total, found := byTenant[usage.Tenant]
+			if !found {
+				total = &turnserver.Usage{}
+				byTenant[usage.Tenant] = total
+			}
+			total.BytesSent += usage.BytesSent
+			total.BytesReceived += usage.BytesReceived
+			total.BytesDropped += usage.BytesDropped
+		}
+		tenants := make([]string, 0, len(byTenant))
+		for tenant := range byTenant {
+			tenants = append(tenants, tenant)
+		}
+		sort.Strings(tenants)
+
+		var samples []Sample
+		for _, tenant := range tenants {
+			total := byTenant[tenant]
+			samples = append(samples,
+				Sample{Name: "turn.bytes_relayed", Kind: Counter, Value: float64(total.BytesSent), Tags: Tags{"tenant": tenant, "direction": "sent"}},
+				Sample{Name: "turn.bytes_relayed", Kind: Counter, Value: float64(total.BytesReceived), Tags: Tags{"tenant": tenant, "direction": "received"}},
+				Sample{Name: "turn.bytes_dropped", Kind: Counter, Value: float64(total.BytesDropped), Tags: Tags{"tenant": tenant}},
+			)
+		}
+		return samples
+	}
+}
@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/turnserver"
+)
+
+func TestTURNSource(t *testing.T) {
+	meter := turnserver.NewMeter(nil, nil)
+	meter.Record(turnserver.Attribution{Room: "standup", Tenant: "acme"}, 100, 50)
+	meter.Record(turnserver.Attribution{Room: "review", Tenant: "acme"}, 10, 5)
+	meter.Record(turnserver.Attribution{Room: "lobby"}, 1, 1)
+
+	values := make(map[string]float64)
+	for _, sample := range TURNSource(meter)() {
+		values[sample.Name+" "+sample.Tags["tenant"]+" "+sample.Tags["direction"]] = sample.Value
+	}
+	if values["turn.bytes_relayed acme sent"] != 110 || values["turn.bytes_relayed acme received"] != 55 || values["turn.bytes_relayed  sent"] != 1 {
+		t.Errorf("Expected traffic summed per tenant, got %v", values)
+	}
+	if len(values) != 6 {
+		t.Errorf("Expected three series per tenant, got %v", values)
+	}
+}
@@ -0,0 +1,32 @@
+package ratelimit
+
+import "time"
+
+// Bucket is a token bucket: it refills at a steady rate and holds up to a
+// burst, so short spikes pass while the average stays capped. It isn't safe
+// for concurrent use
+type Bucket struct {
+	rate    float64 // Tokens per second
+	burst   float64
+	tokens  float64
+	updated time.Time
+}
+
+// NewBucket creates a full bucket refilling at rate tokens per second and
+// holding up to burst
+func NewBucket(rate, burst float64, now time.Time) *Bucket {
+	return &Bucket{rate: rate, burst: burst, tokens: burst, updated: now}
+}
+
+// Take refills the bucket and takes n tokens if there are enough
+func (b *Bucket) Take(n float64, now time.Time) bool {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+b.rate*elapsed)
+		b.updated = now
+	}
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Result is the outcome of counting one request
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration // Until the window ends and the count starts over
+}
+
+// window is the count of one key in the current window
+type window struct {
+	start time.Time
+	count int
+}
+
+// Limiter allows each key a number of requests per window
+type Limiter struct {
+	limit  int
+	period time.Duration
+
+	mutex   sync.Mutex
+	windows map[string]*window
+	swept   time.Time
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// New creates a limiter allowing limit requests per key in each period
+func New(limit int, period time.Duration) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		period:  period,
+		windows: make(map[string]*window),
+		now:     time.Now,
+	}
+}
+
+// Allow counts a request for key and reports whether it is within the limit.
+// Refused requests aren't counted
+func (l *Limiter) Allow(key string) Result {
+	now := l.now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sweepLocked(now)
+	w := l.windows[key]
+	if w == nil || now.Sub(w.start) >= l.period {
+		w = &window{start: now}
+		l.windows[key] = w
+	}
+	result := Result{Limit: l.limit, Reset: w.start.Add(l.period).Sub(now)}
+	if w.count >= l.limit {
+		return result
+	}
+	w.count++
+	result.Allowed = true
+	result.Remaining = l.limit - w.count
+	return result
+}
+
+// sweepLocked forgets keys whose window ended, at most once per period;
+// the caller must hold the mutex
+func (l *Limiter) sweepLocked(now time.Time) {
+	if now.Sub(l.swept) < l.period {
+		return
+	}
+	l.swept = now
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.period {
+			delete(l.windows, key)
+		}
+	}
+}
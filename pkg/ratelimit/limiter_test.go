@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := New(2, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	if r := limiter.Allow("a"); !r.Allowed || r.Remaining != 1 || r.Reset != time.Minute {
+		t.Errorf("Expected the first request to pass, got %+v", r)
+	}
+	limiter.Allow("a")
+	now = now.Add(10 * time.Second)
+	if r := limiter.Allow("a"); r.Allowed || r.Remaining != 0 || r.Reset != 50*time.Second {
+		t.Errorf("Expected the third request to be refused until the window ends, got %+v", r)
+	}
+	if r := limiter.Allow("b"); !r.Allowed {
+		t.Error("Expected other keys to have their own limit")
+	}
+
+	now = now.Add(time.Minute)
+	if r := limiter.Allow("a"); !r.Allowed || r.Remaining != 1 {
+		t.Errorf("Expected a new window to start over, got %+v", r)
+	}
+	if len(limiter.windows) != 1 {
+		t.Errorf("Expected ended windows to be forgotten, got %d", len(limiter.windows))
+	}
+}
+
+func TestBucket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	bucket := NewBucket(2, 4, now)
+
+	for i := 0; i < 4; i++ {
+		if !bucket.Take(1, now) {
+			t.Fatalf("Expected the burst to pass, refused take %d", i+1)
+		}
+	}
+	if bucket.Take(1, now) {
+		t.Error("Expected an empty bucket to refuse")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !bucket.Take(1, now) || bucket.Take(1, now) {
+		t.Error("Expected half a second to refill one token")
+	}
+
+	now = now.Add(time.Hour)
+	if !bucket.Take(4, now) || bucket.Take(1, now) {
+		t.Error("Expected the refill to stop at the burst")
+	}
+}
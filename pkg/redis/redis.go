@@ -0,0 +1,315 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long connecting and each command may take
+const timeout = 5 * time.Second
+
+// Error is an error reply from the server
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Options says how to reach a Redis server
+type Options struct {
+	Addr     string
+	Username string
+	Password string
+	DB       int
+}
+
+// ParseURL reads options from a redis://[user:password@]host[:port][/db] URL
+func ParseURL(raw string) (Options, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Options{}, err
+	}
+	if u.Scheme != "redis" {
+		return Options{}, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	options := Options{Addr: u.Host}
+	if u.Port() == "" {
+		options.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		options.Username = u.User.Username()
+		options.Password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if options.DB, err = strconv.Atoi(db); err != nil {
+			return Options{}, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	return options, nil
+}
+
+// Client is a minimal Redis client that runs commands on one connection,
+// reconnecting after network errors
+type Client struct {
+	options Options
+
+	mutex  sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// New connects to a Redis server
+func New(options Options) (*Client, error) {
+	client := &Client{options: options}
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if err := client.connectLocked(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Do runs a command and returns its reply: a string for simple and bulk
+// strings, int64 for integers, []interface{} for arrays and nil for null
+// replies. Error replies are returned as Error
+func (c *Client) Do(args ...string) (interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn == nil {
+		if err := c.connectLocked(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.doLocked(args)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection is in an unknown state after a network error
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+// Strings runs a command whose reply is an array of strings
+func (c *Client) Strings(args ...string) ([]string, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list, nil
+}
+
+// Options returns the options the client connects with
+func (c *Client) Options() Options {
+	return c.options
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Subscription receives the messages published to channels. It holds a
+// connection of its own, since a subscribed connection can't run other
+// commands
+type Subscription struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mutex  sync.Mutex // Serializes writes; Receive reads without it
+}
+
+// Subscribe connects to the server and subscribes to channels
+func Subscribe(options Options, channels ...string) (*Subscription, error) {
+	client, err := New(options)
+	if err != nil {
+		return nil, err
+	}
+	sub := &Subscription{conn: client.conn, reader: client.reader}
+	if len(channels) > 0 {
+		if err := sub.Subscribe(channels...); err != nil {
+			sub.Close()
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// Subscribe adds channels; their confirmations are skipped by Receive
+func (s *Subscription) Subscribe(channels ...string) error {
+	return s.write(append([]string{"SUBSCRIBE"}, channels...))
+}
+
+// PSubscribe adds channel patterns such as "news.*"; messages on matching
+// channels are received with the channel they were published to
+func (s *Subscription) PSubscribe(patterns ...string) error {
+	return s.write(append([]string{"PSUBSCRIBE"}, patterns...))
+}
+
+// Unsubscribe removes channels
+func (s *Subscription) Unsubscribe(channels ...string) error {
+	return s.write(append([]string{"UNSUBSCRIBE"}, channels...))
+}
+
+// Receive waits for the next message and returns its channel and payload
+func (s *Subscription) Receive() (string, string, error) {
+	s.conn.SetReadDeadline(time.Time{})
+	for {
+		reply, err := readReply(s.reader)
+		if err != nil {
+			return "", "", err
+		}
+		items, _ := reply.([]interface{})
+		if len(items) < 3 {
+			continue
+		}
+		kind, _ := items[0].(string)
+		switch {
+		case kind == "message" && len(items) == 3:
+			channel, _ := items[1].(string)
+			payload, _ := items[2].(string)
+			return channel, payload, nil
+		case kind == "pmessage" && len(items) == 4:
+			channel, _ := items[2].(string)
+			payload, _ := items[3].(string)
+			return channel, payload, nil
+		}
+	}
+}
+
+// Close closes the subscription's connection, ending a blocked Receive
+func (s *Subscription) Close() error {
+	return s.conn.Close()
+}
+
+// write sends a command without waiting for its reply
+func (s *Subscription) write(args []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := s.conn.Write(command(args...))
+	return err
+}
+
+// connectLocked dials the server, authenticates and selects the database;
+// the caller must hold the mutex
+func (c *Client) connectLocked() error {
+	conn, err := dial(c.options)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	if c.options.Password != "" {
+		args := []string{"AUTH", c.options.Password}
+		if c.options.Username != "" {
+			args = []string{"AUTH", c.options.Username, c.options.Password}
+		}
+		if _, err := c.doLocked(args); err != nil {
+			conn.Close()
+			c.conn = nil
+			return fmt.Errorf("authenticating with redis: %w", err)
+		}
+	}
+	if c.options.DB != 0 {
+		if _, err := c.doLocked([]string{"SELECT", strconv.Itoa(c.options.DB)}); err != nil {
+			conn.Close()
+			c.conn = nil
+			return fmt.Errorf("selecting redis database: %w", err)
+		}
+	}
+	return nil
+}
+
+// doLocked writes a command and reads its reply; the caller must hold the
+// mutex
+func (c *Client) doLocked(args []string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := c.conn.Write(command(args...)); err != nil {
+		return nil, err
+	}
+	return readReply(c.reader)
+}
+
+// dial opens a raw connection to the server
+func dial(options Options) (net.Conn, error) {
+	return net.DialTimeout("tcp", options.Addr, timeout)
+}
+
+// command encodes a command as a RESP array of bulk strings
+func command(args ...string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return []byte(b.String())
+}
+
+// readReply reads one RESP reply
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				var replyErr Error
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				items[i] = replyErr
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected redis reply %q", line)
+	}
+}
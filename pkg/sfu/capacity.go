@@ -0,0 +1,132 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// ErrNoCapacity is returned when a session or reservation would exceed the
+// SFU's session limit
+var ErrNoCapacity = errors.New("SFU is at capacity")
+
+// Capacity reports how many sessions the SFU serves and has promised. With
+// no session limit, MaxSessions and Available are 0
+type Capacity struct {
+	MaxSessions int `json:"maxSessions"`
+	Sessions    int `json:"sessions"`
+	Reserved    int `json:"reserved"`  // Seats reserved for rooms and not yet taken
+	Available   int `json:"available"` // Sessions that can be added or reserved
+}
+
+// Reserve sets aside sessions for a room, e.g. ahead of a large scheduled
+// event, and creates its router so the first publishers don't wait for it.
+// A room's sessions count against its reservation before the rest of the
+// SFU's capacity. Reserving again replaces the room's reservation
+func (s *SFU) Reserve(roomID string, sessions int) error {
+	if sessions <= 0 {
+		return errors.New("reservation must be positive")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, reserved := s.reservations[roomID]
+	s.reservations[roomID] = sessions
+	if s.maxSessions > 0 && s.loadLocked("") > s.maxSessions {
+		if reserved {
+			s.reservations[roomID] = previous
+		} else {
+			delete(s.reservations, roomID)
+		}
+		return fmt.Errorf("%w: %d sessions available", ErrNoCapacity, s.maxSessions-s.loadLocked(""))
+	}
+	if _, exists := s.routers[roomID]; !exists {
+		s.routers[roomID] = newRouter(roomID)
+	}
+	util.Info("Reserved %d SFU sessions for room %s", sessions, roomID)
+	return nil
+}
+
+// Release drops a room's reservation
+func (s *SFU) Release(roomID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, reserved := s.reservations[roomID]; !reserved {
+		return
+	}
+	delete(s.reservations, roomID)
+	if router := s.routers[roomID]; s.roomSessionsLocked(roomID) == 0 && (router == nil || router.recording.Load() == nil) {
+		delete(s.routers, roomID)
+	}
+	util.Info("Released SFU reservation of room %s", roomID)
+}
+
+// Reservation returns the sessions reserved for a room, or 0
+func (s *SFU) Reservation(roomID string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.reservations[roomID]
+}
+
+// Capacity returns the SFU's current load
+func (s *SFU) Capacity() Capacity {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	capacity := Capacity{MaxSessions: s.maxSessions, Sessions: len(s.sessions)}
+	capacity.Reserved = s.loadLocked("") - capacity.Sessions
+	if s.maxSessions > 0 {
+		capacity.Available = max(s.maxSessions-s.loadLocked(""), 0)
+	}
+	return capacity
+}
+
+// admit registers a session unless the SFU is at capacity
+func (s *SFU) admit(session *Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.maxSessions > 0 && s.loadLocked(session.RoomID) > s.maxSessions {
+		return ErrNoCapacity
+	}
+	s.sessions[session.ID] = session
+	return nil
+}
+
+// loadLocked returns the sessions the SFU serves or has reserved, counting
+// one more session in the room joining, if any. Each room counts with its
+// sessions or its reservation, whichever is larger; the caller must hold the
+// mutex
+func (s *SFU) loadLocked(joining string) int {
+	perRoom := make(map[string]int, len(s.reservations))
+	for _, session := range s.sessions {
+		perRoom[session.RoomID]++
+	}
+	if joining != "" {
+		perRoom[joining]++
+	}
+	load := 0
+	for roomID, sessions := range perRoom {
+		load += max(sessions, s.reservations[roomID])
+	}
+	for roomID, reserved := range s.reservations {
+		if _, counted := perRoom[roomID]; !counted {
+			load += reserved
+		}
+	}
+	return load
+}
+
+// roomSessionsLocked counts the sessions of a room; the caller must hold the
+// mutex
+func (s *SFU) roomSessionsLocked(roomID string) int {
+	count := 0
+	for _, session := range s.sessions {
+		if session.RoomID == roomID {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,28 @@
+package sfu
+
+import "github.com/nikhilsahni7/chat-video-app/pkg/util"
+
+// Cascade lets the subscribers of one room also receive the tracks published
+// in another, e.g. so the overflow rooms of an event watch its stage. Media
+// is forwarded once per subscriber, without another hop through a publisher.
+// Cascading again replaces the room's source
+func (s *SFU) Cascade(sourceRoomID, roomID string) {
+	if sourceRoomID == roomID {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cascades[roomID] = sourceRoomID
+	util.Info("Room %s cascades media from room %s", roomID, sourceRoomID)
+}
+
+// Uncascade stops a room from receiving another room's tracks. Existing
+// subscribers keep the tracks they already have
+func (s *SFU) Uncascade(roomID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exists := s.cascades[roomID]; exists {
+		delete(s.cascades, roomID)
+		util.Info("Room %s no longer cascades media", roomID)
+	}
+}
@@ -0,0 +1,236 @@
+package sfu
+
+import (
+	"errors"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// RoleParticipant is a room participant that both publishes into the room
+// and receives everyone else's media over one peer connection
+const RoleParticipant = "participant"
+
+// negotiation is the state of a participant's peer connection. The SFU
+// makes every offer; changes made while an offer awaits its answer are
+// offered once the answer arrives
+type negotiation struct {
+	offer          func(sdp string)
+	senders        map[*webrtc.TrackLocalStaticRTP]*webrtc.RTPSender
+	awaitingAnswer bool
+	again          bool
+
+	// The next offer restarts ICE
+	restartICE bool
+}
+
+// Connect starts a participant's peer connection for a room. The SFU offers
+// to receive one audio and one video track from the participant and to send
+// every track published in the room by others. Offers, this first one and
+// those sent whenever tracks come and go, are passed to offer and must be
+// answered with Answer. It returns the session ID
+func (s *SFU) Connect(roomID, clientID string, offer func(sdp string)) (string, error) {
+	session, err := s.newSession(roomID, RoleParticipant)
+	if err != nil {
+		return "", err
+	}
+	session.ClientID = clientID
+	session.negotiation = &negotiation{offer: offer, senders: make(map[*webrtc.TrackLocalStaticRTP]*webrtc.RTPSender)}
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if _, err := session.pc.AddTransceiverFromKind(kind,
+			webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			session.Close()
+			return "", err
+		}
+	}
+	session.pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability,
+			remote.ID(), session.ID+"-"+remote.StreamID())
+		if err != nil {
+			util.Error("Error creating forwarded track in room %s: %v", roomID, err)
+			return
+		}
+		s.publishTrack(roomID, local, session.ID)
+		util.Info("Participant %s publishes %s track in room %s", clientID, remote.Kind(), roomID)
+
+		if remote.Kind() == webrtc.RTPCodecTypeVideo {
+			go session.requestKeyframes(uint32(remote.SSRC()))
+		}
+		forward(remote, local, s.router(roomID), clientID)
+	})
+
+	for _, track := range s.Tracks(roomID) {
+		session.sendTrack(track)
+	}
+	go session.negotiate()
+	util.Info("Participant %s connected to the SFU in room %s", clientID, roomID)
+	return session.ID, nil
+}
+
+// Answer applies a participant's answer to the SFU's last offer
+func (s *SFU) Answer(sessionID, sdp string) error {
+	session, err := s.Session(sessionID)
+	if err != nil {
+		return err
+	}
+	if session.Role != RoleParticipant {
+		return errors.New("only participant sessions are negotiated")
+	}
+	if err := session.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp}); err != nil {
+		return err
+	}
+
+	session.mutex.Lock()
+	session.negotiation.awaitingAnswer = false
+	again := session.negotiation.again
+	session.negotiation.again = false
+	session.mutex.Unlock()
+	if again {
+		go session.negotiate()
+	}
+	return nil
+}
+
+// RestartICE sends a participant an offer with new ICE credentials, so its
+// connection recovers after the participant moved to another network
+func (s *SFU) RestartICE(sessionID string) error {
+	session, err := s.Session(sessionID)
+	if err != nil {
+		return err
+	}
+	if session.Role != RoleParticipant {
+		return errors.New("only participant sessions are negotiated")
+	}
+	session.mutex.Lock()
+	session.negotiation.restartICE = true
+	session.mutex.Unlock()
+	go session.negotiate()
+	return nil
+}
+
+// publishTrack adds a track to a room's router and sends it to the room's
+// participants other than its publisher
+func (s *SFU) publishTrack(roomID string, track *webrtc.TrackLocalStaticRTP, publisher string) {
+	s.router(roomID).addTrack(track, publisher)
+	for _, participant := range s.participants(roomID, publisher) {
+		if participant.sendTrack(track) {
+			go participant.negotiate()
+		}
+	}
+}
+
+// withdrawTracks stops sending tracks that are no longer published to the
+// room's participants
+func (s *SFU) withdrawTracks(roomID string, tracks []*webrtc.TrackLocalStaticRTP) {
+	if len(tracks) == 0 {
+		return
+	}
+	for _, participant := range s.participants(roomID, "") {
+		if participant.stopTracks(tracks) {
+			go participant.negotiate()
+		}
+	}
+}
+
+// participants returns the participant sessions of a room, except one
+func (s *SFU) participants(roomID, except string) []*Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var participants []*Session
+	for _, session := range s.sessions {
+		if session.RoomID == roomID && session.Role == RoleParticipant && session.ID != except {
+			participants = append(participants, session)
+		}
+	}
+	return participants
+}
+
+// sendTrack adds a track to a participant's peer connection, reporting
+// whether it was added
+func (session *Session) sendTrack(track *webrtc.TrackLocalStaticRTP) bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if _, sent := session.negotiation.senders[track]; sent {
+		return false
+	}
+	sender, err := session.pc.AddTrack(track)
+	if err != nil {
+		util.Warn("Error sending track %s to participant %s: %v", track.ID(), session.ClientID, err)
+		return false
+	}
+	session.negotiation.senders[track] = sender
+	go drainRTCP(sender)
+	return true
+}
+
+// stopTracks removes tracks from a participant's peer connection, reporting
+// whether any was removed
+func (session *Session) stopTracks(tracks []*webrtc.TrackLocalStaticRTP) bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	removed := false
+	for _, track := range tracks {
+		sender, sent := session.negotiation.senders[track]
+		if !sent {
+			continue
+		}
+		delete(session.negotiation.senders, track)
+		if err := session.pc.RemoveTrack(sender); err != nil {
+			util.Warn("Error removing track %s from participant %s: %v", track.ID(), session.ClientID, err)
+			continue
+		}
+		removed = true
+	}
+	return removed
+}
+
+// negotiate sends the participant a new offer, or, while an offer awaits its
+// answer, makes another once it arrives
+func (session *Session) negotiate() {
+	session.mutex.Lock()
+	if session.negotiation.awaitingAnswer {
+		session.negotiation.again = true
+		session.mutex.Unlock()
+		return
+	}
+	session.negotiation.awaitingAnswer = true
+	restartICE := session.negotiation.restartICE
+	session.negotiation.restartICE = false
+	session.mutex.Unlock()
+
+	offer, err := session.localOffer(restartICE)
+	if err != nil {
+		select {
+		case <-session.done:
+		default:
+			util.Warn("Error making an offer to participant %s in room %s: %v", session.ClientID, session.RoomID, err)
+		}
+		session.mutex.Lock()
+		session.negotiation.awaitingAnswer = false
+		session.mutex.Unlock()
+		return
+	}
+	session.negotiation.offer(offer)
+}
+
+// localOffer creates an offer, restarting ICE if asked, and waits for ICE
+// gathering, so the offer carries all candidates and no trickle is needed
+func (session *Session) localOffer(restartICE bool) (string, error) {
+	offer, err := session.pc.CreateOffer(&webrtc.OfferOptions{ICERestart: restartICE})
+	if err != nil {
+		return "", err
+	}
+	gathered := webrtc.GatheringCompletePromise(session.pc)
+	if err := session.pc.SetLocalDescription(offer); err != nil {
+		return "", err
+	}
+	<-gathered
+
+	local := session.pc.LocalDescription()
+	if local == nil {
+		return "", errors.New("no local description")
+	}
+	return local.SDP, nil
+}
@@ -0,0 +1,173 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/h264writer"
+	"github.com/pion/webrtc/v4/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// ErrAlreadyRecording is returned when recording a room that is being
+// recorded
+var ErrAlreadyRecording = errors.New("room is already being recorded")
+
+// ErrRecordingNotFound is returned for unknown recording IDs
+var ErrRecordingNotFound = errors.New("recording not found")
+
+// Recording writes the tracks forwarded in a room to files in a directory,
+// one per track: Opus audio to Ogg, VP8, VP9 and AV1 video to IVF and H.264
+// to an Annex B stream. Tracks of other codecs, and those the server
+// ingests itself, are not recorded
+type Recording struct {
+	ID     string
+	RoomID string
+
+	dir     string
+	mutex   sync.Mutex
+	tracks  map[*webrtc.TrackLocalStaticRTP]mediaWriter
+	files   []string
+	stopped bool
+}
+
+// mediaWriter is a container writer of one track
+type mediaWriter interface {
+	WriteRTP(packet *rtp.Packet) error
+	Close() error
+}
+
+// StartRecording starts writing the tracks forwarded in a room to files in
+// dir, which is created if needed
+func (s *SFU) StartRecording(roomID, recordingID, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating recording directory: %w", err)
+	}
+	recording := &Recording{
+		ID:     recordingID,
+		RoomID: roomID,
+		dir:    dir,
+		tracks: make(map[*webrtc.TrackLocalStaticRTP]mediaWriter),
+	}
+
+	router := s.router(roomID)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !router.recording.CompareAndSwap(nil, recording) {
+		return ErrAlreadyRecording
+	}
+	s.recordings[recordingID] = recording
+	util.Info("Recording room %s to %s", roomID, dir)
+	return nil
+}
+
+// StopRecording stops a recording and returns the files it wrote
+func (s *SFU) StopRecording(recordingID string) ([]string, error) {
+	s.mutex.Lock()
+	recording, exists := s.recordings[recordingID]
+	if !exists {
+		s.mutex.Unlock()
+		return nil, ErrRecordingNotFound
+	}
+	delete(s.recordings, recordingID)
+	if router := s.routers[recording.RoomID]; router != nil {
+		router.recording.CompareAndSwap(recording, nil)
+	}
+	s.mutex.Unlock()
+
+	recording.mutex.Lock()
+	defer recording.mutex.Unlock()
+	recording.stopped = true
+	for track, writer := range recording.tracks {
+		if writer == nil {
+			continue
+		}
+		if err := writer.Close(); err != nil {
+			util.Warn("Error closing track %s of recording %s: %v", track.ID(), recordingID, err)
+		}
+	}
+	util.Info("Stopped recording room %s, %d tracks written", recording.RoomID, len(recording.files))
+	return recording.files, nil
+}
+
+// write adds a packet forwarded on a track to the track's file, starting
+// the file with the track's first packet
+func (recording *Recording) write(track *webrtc.TrackLocalStaticRTP, codec webrtc.RTPCodecParameters, publisher string, data []byte) {
+	recording.mutex.Lock()
+	defer recording.mutex.Unlock()
+	if recording.stopped {
+		return
+	}
+	writer, started := recording.tracks[track]
+	if !started {
+		writer = recording.startTrack(track, codec, publisher)
+		recording.tracks[track] = writer
+	}
+	if writer == nil {
+		return
+	}
+
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(data); err != nil {
+		return
+	}
+	if err := writer.WriteRTP(packet); err != nil {
+		util.Warn("Error recording track %s of room %s, skipping it: %v", track.ID(), recording.RoomID, err)
+		writer.Close()
+		recording.tracks[track] = nil
+	}
+}
+
+// startTrack creates the file of a track, or returns nil if its codec can't
+// be recorded; the caller must hold the mutex
+func (recording *Recording) startTrack(track *webrtc.TrackLocalStaticRTP, codec webrtc.RTPCodecParameters, publisher string) mediaWriter {
+	mimeType := strings.ToLower(codec.MimeType)
+	kind, _, _ := strings.Cut(mimeType, "/")
+	name := fmt.Sprintf("%02d-%s-%s", len(recording.files)+1, fileName(publisher), kind)
+	path := filepath.Join(recording.dir, name)
+
+	var writer mediaWriter
+	var err error
+	switch mimeType {
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		path += ".ogg"
+		writer, err = oggwriter.New(path, codec.ClockRate, max(codec.Channels, 1))
+	case strings.ToLower(webrtc.MimeTypeVP8), strings.ToLower(webrtc.MimeTypeVP9), strings.ToLower(webrtc.MimeTypeAV1):
+		path += ".ivf"
+		writer, err = ivfwriter.New(path, ivfwriter.WithCodec(codec.MimeType))
+	case strings.ToLower(webrtc.MimeTypeH264):
+		path += ".h264"
+		writer, err = h264writer.New(path)
+	default:
+		util.Warn("Not recording %s track %s of room %s", codec.MimeType, track.ID(), recording.RoomID)
+		return nil
+	}
+	if err != nil {
+		util.Error("Error creating recording of track %s in room %s: %v", track.ID(), recording.RoomID, err)
+		return nil
+	}
+	recording.files = append(recording.files, path)
+	return writer
+}
+
+// fileName turns a publisher's name into something safe for a file name
+func fileName(name string) string {
+	safe := strings.Map(func(c rune) rune {
+		if c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_' {
+			return c
+		}
+		return '_'
+	}, name)
+	if safe == "" {
+		return "track"
+	}
+	return safe
+}
@@ -0,0 +1,63 @@
+package sfu
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Router holds the tracks published in one room
+type Router struct {
+	RoomID string
+
+	mutex  sync.RWMutex
+	tracks map[string]*publishedTrack
+
+	// Recording of the room's tracks, if one is running
+	recording atomic.Pointer[Recording]
+}
+
+// publishedTrack is a track forwarded from a publisher to subscribers
+type publishedTrack struct {
+	local     *webrtc.TrackLocalStaticRTP
+	publisher string // Session ID
+}
+
+// newRouter creates an empty router
+func newRouter(roomID string) *Router {
+	return &Router{RoomID: roomID, tracks: make(map[string]*publishedTrack)}
+}
+
+// addTrack makes a track available to subscribers
+func (r *Router) addTrack(track *webrtc.TrackLocalStaticRTP, publisher string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tracks[track.ID()] = &publishedTrack{local: track, publisher: publisher}
+}
+
+// removeTracks drops all tracks of a publisher and returns them
+func (r *Router) removeTracks(publisher string) []*webrtc.TrackLocalStaticRTP {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var removed []*webrtc.TrackLocalStaticRTP
+	for id, track := range r.tracks {
+		if track.publisher == publisher {
+			delete(r.tracks, id)
+			removed = append(removed, track.local)
+		}
+	}
+	return removed
+}
+
+// Tracks returns the tracks currently published in the room
+func (r *Router) Tracks() []*webrtc.TrackLocalStaticRTP {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	tracks := make([]*webrtc.TrackLocalStaticRTP, 0, len(r.tracks))
+	for _, track := range r.tracks {
+		tracks = append(tracks, track.local)
+	}
+	return tracks
+}
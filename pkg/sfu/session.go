@@ -0,0 +1,254 @@
+package sfu
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Session roles
+const (
+	RolePublisher  = "publisher"
+	RoleSubscriber = "subscriber"
+
+	// Media pushed by the server itself, e.g. from an RTSP camera
+	RoleIngest = "ingest"
+)
+
+// How often publishers are asked for a keyframe so new subscribers can
+// start decoding quickly
+const keyframeInterval = 3 * time.Second
+
+// Session is one peer connection terminated by the SFU, or a server-side
+// media source
+type Session struct {
+	ID     string
+	RoomID string
+	Role   string
+
+	// Signaling client of a participant session
+	ClientID string
+
+	sfu       *SFU
+	pc        *webrtc.PeerConnection // nil for ingest sessions
+	closeOnce sync.Once
+	done      chan struct{}
+
+	// Offers and forwarded tracks of participant sessions
+	mutex       sync.Mutex
+	negotiation *negotiation
+}
+
+// Publish accepts an SDP offer that sends media into a room and returns the
+// answer. Every received track is forwarded to the room's subscribers
+func (s *SFU) Publish(roomID, offer string) (*Session, string, error) {
+	session, err := s.newSession(roomID, RolePublisher)
+	if err != nil {
+		return nil, "", err
+	}
+	session.pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability,
+			remote.ID(), session.ID+"-"+remote.StreamID())
+		if err != nil {
+			util.Error("Error creating forwarded track in room %s: %v", roomID, err)
+			return
+		}
+		s.publishTrack(roomID, local, session.ID)
+		util.Info("Session %s publishes %s track in room %s", session.ID, remote.Kind(), roomID)
+
+		if remote.Kind() == webrtc.RTPCodecTypeVideo {
+			go session.requestKeyframes(uint32(remote.SSRC()))
+		}
+		forward(remote, local, s.router(roomID), session.ID)
+	})
+
+	answer, err := session.answer(offer)
+	if err != nil {
+		session.Close()
+		return nil, "", err
+	}
+	return session, answer, nil
+}
+
+// Subscribe accepts an SDP offer that receives media from a room and returns
+// the answer. The subscriber gets the tracks published at that moment
+func (s *SFU) Subscribe(roomID, offer string) (*Session, string, error) {
+	tracks := s.Tracks(roomID)
+	if len(tracks) == 0 {
+		return nil, "", ErrNoPublisher
+	}
+
+	session, err := s.newSession(roomID, RoleSubscriber)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := session.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		session.Close()
+		return nil, "", err
+	}
+	for _, track := range tracks {
+		sender, err := session.pc.AddTrack(track)
+		if err != nil {
+			session.Close()
+			return nil, "", err
+		}
+		go drainRTCP(sender)
+	}
+
+	answer, err := session.localAnswer()
+	if err != nil {
+		session.Close()
+		return nil, "", err
+	}
+	util.Info("Session %s subscribes to %d tracks in room %s", session.ID, len(tracks), roomID)
+	return session, answer, nil
+}
+
+// Ingest starts a session whose tracks are written by the server instead of
+// a remote peer
+func (s *SFU) Ingest(roomID string) *Session {
+	session := &Session{
+		ID:     newID(),
+		RoomID: roomID,
+		Role:   RoleIngest,
+		sfu:    s,
+		done:   make(chan struct{}),
+	}
+	s.register(session)
+	return session
+}
+
+// AddTrack publishes a track of an ingest session into its room. The caller
+// writes RTP packets to the returned track
+func (session *Session) AddTrack(codec webrtc.RTPCodecCapability, id string) (*webrtc.TrackLocalStaticRTP, error) {
+	if session.Role != RoleIngest {
+		return nil, errors.New("only ingest sessions can add tracks")
+	}
+	track, err := webrtc.NewTrackLocalStaticRTP(codec, id, session.ID+"-"+id)
+	if err != nil {
+		return nil, err
+	}
+	session.sfu.publishTrack(session.RoomID, track, session.ID)
+	return track, nil
+}
+
+// newSession creates a session with a fresh peer connection, unless the SFU
+// is at capacity
+func (s *SFU) newSession(roomID, role string) (*Session, error) {
+	pc, err := s.newPeerConnection()
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		ID:     newID(),
+		RoomID: roomID,
+		Role:   role,
+		sfu:    s,
+		pc:     pc,
+		done:   make(chan struct{}),
+	}
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		util.Debug("Session %s in room %s is %s", session.ID, roomID, state)
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			session.Close()
+		}
+	})
+	if err := s.admit(session); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// answer applies a remote offer and returns the local answer
+func (session *Session) answer(offer string) (string, error) {
+	if err := session.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", err
+	}
+	return session.localAnswer()
+}
+
+// localAnswer creates the answer and waits for ICE gathering, so the answer
+// carries all candidates and no trickle is needed
+func (session *Session) localAnswer() (string, error) {
+	answer, err := session.pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	gathered := webrtc.GatheringCompletePromise(session.pc)
+	if err := session.pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	<-gathered
+
+	local := session.pc.LocalDescription()
+	if local == nil {
+		return "", errors.New("no local description")
+	}
+	return local.SDP, nil
+}
+
+// Close ends the session and removes its tracks from the room and from its
+// participants' peer connections
+func (session *Session) Close() {
+	session.closeOnce.Do(func() {
+		close(session.done)
+		removed := session.sfu.router(session.RoomID).removeTracks(session.ID)
+		session.sfu.unregister(session)
+		session.sfu.withdrawTracks(session.RoomID, removed)
+		if session.pc != nil {
+			session.pc.Close()
+		}
+		util.Info("Closed %s session %s in room %s", session.Role, session.ID, session.RoomID)
+	})
+}
+
+// requestKeyframes periodically asks the publisher for a keyframe
+func (session *Session) requestKeyframes(ssrc uint32) {
+	ticker := time.NewTicker(keyframeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-session.done:
+			return
+		case <-ticker.C:
+			if err := session.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// forward copies RTP packets from a received track to its local copy, and
+// to the room's recording while one runs, until the publisher goes away
+func forward(remote *webrtc.TrackRemote, local *webrtc.TrackLocalStaticRTP, router *Router, publisher string) {
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := remote.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := local.Write(buf[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+		if recording := router.recording.Load(); recording != nil {
+			recording.write(local, remote.Codec(), publisher, buf[:n])
+		}
+	}
+}
+
+// drainRTCP reads RTCP from a sender so interceptors such as NACK run
+func drainRTCP(sender *webrtc.RTPSender) {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(buf); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package sfu
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Packets buffered for each ICE-TCP connection before it is matched to a
+// session
+const iceTCPReadBuffer = 8
+
+// ErrNoPublisher is returned when subscribing to a room nobody publishes to
+var ErrNoPublisher = errors.New("no media is published in this room")
+
+// ErrSessionNotFound is returned for unknown session IDs
+var ErrSessionNotFound = errors.New("session not found")
+
+// Config configures the media engine
+type Config struct {
+	// STUN/TURN servers used for the server's own candidates
+	ICEServers []webrtc.ICEServer
+
+	// Most peer connections served at once; 0 means no limit
+	MaxSessions int
+
+	// UDP ports media is received on; both 0 lets the system choose
+	PortMin, PortMax uint16
+
+	// Addresses advertised in place of the host's own, for servers behind
+	// a 1:1 NAT such as cloud instances and Kubernetes nodes. Each is an IP
+	// or a "public/private" pair
+	PublicIPs []string
+
+	// Network interfaces candidates are gathered on; empty means all
+	Interfaces []string
+
+	// Local addresses candidates are gathered on, at most one per address
+	// family; empty means all
+	BindIPs []net.IP
+
+	// TCP address of ICE-TCP, such as ":443", for clients on networks that
+	// block UDP; empty disables it
+	ICETCPAddr string
+}
+
+// SFU terminates WebRTC peer connections and forwards media between the
+// publishers and subscribers of each room
+type SFU struct {
+	api    *webrtc.API
+	config webrtc.Configuration
+
+	maxSessions int
+
+	mutex        sync.Mutex
+	routers      map[string]*Router
+	sessions     map[string]*Session
+	reservations map[string]int    // Sessions set aside per room
+	cascades     map[string]string // Room whose tracks another room's subscribers also get
+	recordings   map[string]*Recording
+}
+
+// New creates an SFU with the default codecs and RTCP interceptors (NACK,
+// receiver reports)
+func New(config Config) (*SFU, error) {
+	media := &webrtc.MediaEngine{}
+	if err := media.RegisterDefaultCodecs(); err != nil {
+		return nil, fmt.Errorf("registering codecs: %w", err)
+	}
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(media, registry); err != nil {
+		return nil, fmt.Errorf("registering interceptors: %w", err)
+	}
+
+	settings, err := settingEngine(config)
+	if err != nil {
+		return nil, err
+	}
+
+	util.Info("SFU initialized")
+	return &SFU{
+		api:          webrtc.NewAPI(webrtc.WithMediaEngine(media), webrtc.WithInterceptorRegistry(registry), webrtc.WithSettingEngine(settings)),
+		config:       webrtc.Configuration{ICEServers: config.ICEServers},
+		maxSessions:  config.MaxSessions,
+		routers:      make(map[string]*Router),
+		sessions:     make(map[string]*Session),
+		reservations: make(map[string]int),
+		cascades:     make(map[string]string),
+		recordings:   make(map[string]*Recording),
+	}, nil
+}
+
+// settingEngine applies the network settings of config
+func settingEngine(config Config) (webrtc.SettingEngine, error) {
+	var settings webrtc.SettingEngine
+	if config.PortMin != 0 || config.PortMax != 0 {
+		if err := settings.SetEphemeralUDPPortRange(config.PortMin, config.PortMax); err != nil {
+			return settings, fmt.Errorf("setting the UDP port range: %w", err)
+		}
+	}
+	if len(config.PublicIPs) > 0 {
+		settings.SetNAT1To1IPs(config.PublicIPs, webrtc.ICECandidateTypeHost)
+	}
+	if len(config.Interfaces) > 0 {
+		settings.SetInterfaceFilter(func(name string) bool {
+			return slices.Contains(config.Interfaces, name)
+		})
+	}
+	if len(config.BindIPs) > 0 {
+		settings.SetIPFilter(func(ip net.IP) bool {
+			return slices.ContainsFunc(config.BindIPs, ip.Equal)
+		})
+	}
+	if config.ICETCPAddr != "" {
+		listener, err := net.Listen("tcp", config.ICETCPAddr)
+		if err != nil {
+			return settings, fmt.Errorf("listening for ICE-TCP: %w", err)
+		}
+		settings.SetICETCPMux(webrtc.NewICETCPMux(nil, listener, iceTCPReadBuffer))
+		settings.SetNetworkTypes([]webrtc.NetworkType{
+			webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6,
+			webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6,
+		})
+		util.Info("ICE-TCP listening on %s", listener.Addr())
+	}
+	return settings, nil
+}
+
+// Tracks returns the tracks currently published in a room, followed by
+// those of the room it cascades from, if any
+func (s *SFU) Tracks(roomID string) []*webrtc.TrackLocalStaticRTP {
+	s.mutex.Lock()
+	router := s.routers[roomID]
+	source := s.routers[s.cascades[roomID]]
+	s.mutex.Unlock()
+
+	var tracks []*webrtc.TrackLocalStaticRTP
+	if router != nil {
+		tracks = router.Tracks()
+	}
+	if source != nil {
+		tracks = append(tracks, source.Tracks()...)
+	}
+	return tracks
+}
+
+// router returns the media router of a room, creating it if needed
+func (s *SFU) router(roomID string) *Router {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	router, exists := s.routers[roomID]
+	if !exists {
+		router = newRouter(roomID)
+		s.routers[roomID] = router
+	}
+	return router
+}
+
+// Session returns a session by ID
+func (s *SFU) Session(id string) (*Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, exists := s.sessions[id]
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// CloseSession ends a session and its peer connection
+func (s *SFU) CloseSession(id string) error {
+	session, err := s.Session(id)
+	if err != nil {
+		return err
+	}
+	session.Close()
+	return nil
+}
+
+// register tracks a new session
+func (s *SFU) register(session *Session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessions[session.ID] = session
+}
+
+// unregister forgets a closed session, dropping the room's router once it
+// has no sessions left, nothing is reserved for it and it isn't recorded
+func (s *SFU) unregister(session *Session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, session.ID)
+	if _, reserved := s.reservations[session.RoomID]; reserved || s.roomSessionsLocked(session.RoomID) > 0 {
+		return
+	}
+	if router := s.routers[session.RoomID]; router != nil && router.recording.Load() != nil {
+		return
+	}
+	delete(s.routers, session.RoomID)
+}
+
+// newPeerConnection creates a peer connection with the SFU's settings
+func (s *SFU) newPeerConnection() (*webrtc.PeerConnection, error) {
+	return s.api.NewPeerConnection(s.config)
+}
+
+// newID returns a random session ID
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,493 @@
+package sfu
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// offer creates a local offer with all ICE candidates gathered
+func offer(t *testing.T, pc *webrtc.PeerConnection) string {
+	t.Helper()
+	sdp, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(sdp); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	return pc.LocalDescription().SDP
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.Subscribe("room", "v=0"); !errors.Is(err, ErrNoPublisher) {
+		t.Fatalf("Subscribe without publisher: got %v", err)
+	}
+
+	// Publish a video track like a WHIP encoder would
+	publisher, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "obs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publisher.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	session, answer, err := s.Publish("room", offer(t, publisher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		packet := &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96}, Payload: []byte{0x10, 0x00, 0x00}}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+				packet.SequenceNumber++
+				packet.Timestamp += 3000
+				track.WriteRTP(packet)
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.Tracks("room")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("published track never reached the router")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Subscribe like a WHEP player and wait for media
+	subscriber, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+	if _, err := subscriber.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan struct{}, 1)
+	subscriber.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if _, _, err := remote.ReadRTP(); err == nil {
+			received <- struct{}{}
+		}
+	})
+	_, answer, err = s.Subscribe("room", offer(t, subscriber))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := subscriber.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("subscriber received no media")
+	}
+
+	// Ending the publisher's session removes its tracks
+	if err := s.CloseSession(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if tracks := s.Tracks("room"); len(tracks) != 0 {
+		t.Fatalf("tracks after publisher left: %d", len(tracks))
+	}
+	if err := s.CloseSession(session.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("closing twice: got %v", err)
+	}
+}
+
+func TestReservations(t *testing.T) {
+	s, err := New(Config{MaxSessions: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Reserve("event", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reserve("other", 2); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("Expected a reservation beyond capacity to fail, got %v", err)
+	}
+	if capacity := s.Capacity(); capacity != (Capacity{MaxSessions: 3, Reserved: 2, Available: 1}) {
+		t.Errorf("Unexpected capacity %+v", capacity)
+	}
+
+	// Sessions of the event take its reserved seats; other rooms share the rest
+	s.Ingest("event")
+	other, err := s.newSession("other", RolePublisher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := s.newSession("other", RolePublisher); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("Expected the reserved seat to be kept from other rooms, got %v", err)
+	}
+	event, err := s.newSession("event", RolePublisher)
+	if err != nil {
+		t.Fatalf("Expected the event to get its reserved seat, got %v", err)
+	}
+	defer event.Close()
+
+	s.Release("event")
+	if capacity := s.Capacity(); capacity.Reserved != 0 || capacity.Sessions != 3 {
+		t.Errorf("Expected the reservation released, got %+v", capacity)
+	}
+}
+
+func TestCascade(t *testing.T) {
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stage := s.Ingest("event")
+	if _, err := stage.AddTrack(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video"); err != nil {
+		t.Fatal(err)
+	}
+	if tracks := s.Tracks("event-overflow-1"); len(tracks) != 0 {
+		t.Fatalf("Expected no tracks before cascading, got %d", len(tracks))
+	}
+
+	s.Cascade("event", "event-overflow-1")
+	if tracks := s.Tracks("event-overflow-1"); len(tracks) != 1 {
+		t.Errorf("Expected the event's track in its overflow room, got %d", len(tracks))
+	}
+
+	s.Uncascade("event-overflow-1")
+	if tracks := s.Tracks("event-overflow-1"); len(tracks) != 0 {
+		t.Errorf("Expected no tracks after uncascading, got %d", len(tracks))
+	}
+}
+
+// participant answers the SFU's offers like a browser in a room in SFU mode,
+// sending track, if any, and passing the tracks it receives to onTrack
+func participant(t *testing.T, s *SFU, roomID, clientID string, track *webrtc.TrackLocalStaticRTP,
+	onTrack func(*webrtc.TrackRemote)) *webrtc.PeerConnection {
+	t.Helper()
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if onTrack != nil {
+		pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) { onTrack(remote) })
+	}
+	offers := make(chan string, 10)
+	sessionID, err := s.Connect(roomID, clientID, func(sdp string) { offers <- sdp })
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for sdp := range offers {
+			if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}); err != nil {
+				return
+			}
+			if track != nil {
+				// Sent on the video transceiver the SFU offered
+				if _, err := pc.AddTrack(track); err != nil {
+					return
+				}
+				track = nil
+			}
+			answer, err := pc.CreateAnswer(nil)
+			if err != nil {
+				return
+			}
+			gathered := webrtc.GatheringCompletePromise(pc)
+			if err := pc.SetLocalDescription(answer); err != nil {
+				return
+			}
+			<-gathered
+			s.Answer(sessionID, pc.LocalDescription().SDP)
+		}
+	}()
+	return pc
+}
+
+func TestParticipants(t *testing.T) {
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := participant(t, s, "room", "alice", track, nil)
+	defer alice.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		packet := &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96}, Payload: []byte{0x10, 0x00, 0x00}}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+				packet.SequenceNumber++
+				packet.Timestamp += 3000
+				track.WriteRTP(packet)
+			}
+		}
+	}()
+
+	// Bob gets Alice's video with the first offer or a later one
+	received := make(chan struct{}, 1)
+	bob := participant(t, s, "room", "bob", nil, func(remote *webrtc.TrackRemote) {
+		if _, _, err := remote.ReadRTP(); err == nil {
+			received <- struct{}{}
+		}
+	})
+	defer bob.Close()
+
+	select {
+	case <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Bob received no media from Alice")
+	}
+}
+
+func TestRestartICE(t *testing.T) {
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	offers := make(chan string, 10)
+	sessionID, err := s.Connect("room", "alice", func(sdp string) { offers <- sdp })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.CloseSession(sessionID)
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	answer := func(sdp string) {
+		t.Helper()
+		if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}); err != nil {
+			t.Fatal(err)
+		}
+		local, err := pc.CreateAnswer(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pc.SetLocalDescription(local); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Answer(sessionID, local.SDP); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ufrag := func(sdp string) string {
+		for _, line := range strings.Split(sdp, "\r\n") {
+			if value, found := strings.CutPrefix(line, "a=ice-ufrag:"); found {
+				return value
+			}
+		}
+		return ""
+	}
+	receive := func() string {
+		t.Helper()
+		select {
+		case sdp := <-offers:
+			return sdp
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected an offer from the SFU")
+			return ""
+		}
+	}
+
+	first := receive()
+	answer(first)
+	if err := s.RestartICE(sessionID); err != nil {
+		t.Fatal(err)
+	}
+	if restart := receive(); ufrag(restart) == "" || ufrag(restart) == ufrag(first) {
+		t.Errorf("Expected new ICE credentials, got %q after %q", ufrag(restart), ufrag(first))
+	}
+	if err := s.RestartICE("missing"); err == nil {
+		t.Error("Expected restarting an unknown session to fail")
+	}
+}
+
+func TestRecording(t *testing.T) {
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := s.StartRecording("room", "rec", dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StartRecording("room", "again", dir); err != ErrAlreadyRecording {
+		t.Errorf("Expected ErrAlreadyRecording, got %v", err)
+	}
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := participant(t, s, "room", "alice", track, nil)
+	defer alice.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		// Whole key frames, so the IVF writer keeps every one
+		packet := &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, Marker: true}, Payload: []byte{0x10, 0x00, 0x00}}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+				packet.SequenceNumber++
+				packet.Timestamp += 3000
+				track.WriteRTP(packet)
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.ivf"))
+		if len(matches) == 1 {
+			if info, err := os.Stat(matches[0]); err == nil && info.Size() > 32 {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Alice's video was not recorded")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	files, err := s.StopRecording("rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || filepath.Ext(files[0]) != ".ivf" {
+		t.Errorf("Expected one IVF file, got %v", files)
+	}
+	if _, err := s.StopRecording("rec"); err != ErrRecordingNotFound {
+		t.Errorf("Expected ErrRecordingNotFound, got %v", err)
+	}
+}
+
+func TestNetworkSettings(t *testing.T) {
+	if _, err := New(Config{PortMin: 50100, PortMax: 50000}); err == nil {
+		t.Error("Expected an inverted port range to be refused")
+	}
+
+	s, err := New(Config{PortMin: 50000, PortMax: 50100, PublicIPs: []string{"203.0.113.10", "2001:db8::10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	if _, err := publisher.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly}); err != nil {
+		t.Fatal(err)
+	}
+	session, answer, err := s.Publish("room", offer(t, publisher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.CloseSession(session.ID)
+
+	var candidates int
+	for _, line := range strings.Split(answer, "\r\n") {
+		if !strings.HasPrefix(line, "a=candidate:") || !strings.Contains(line, " udp ") {
+			continue
+		}
+		candidates++
+		fields := strings.Fields(line)
+		port, _ := strconv.Atoi(fields[5])
+		if port < 50000 || port > 50100 {
+			t.Errorf("Expected candidates within the port range, got %s", line)
+		}
+		// Dual-stack hosts advertise a public IP of each family
+		if public := map[bool]string{false: "203.0.113.10", true: "2001:db8::10"}[strings.Contains(fields[4], ":")]; fields[4] != public {
+			t.Errorf("Expected candidates on the public IP %s, got %s", public, line)
+		}
+	}
+	if candidates == 0 {
+		t.Errorf("Expected UDP candidates in the answer:\n%s", answer)
+	}
+}
+
+func TestICETCP(t *testing.T) {
+	s, err := New(Config{ICETCPAddr: ":0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A client whose network blocks UDP
+	var settings webrtc.SettingEngine
+	settings.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeTCP4})
+	publisher, err := webrtc.NewAPI(webrtc.WithSettingEngine(settings)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	if _, err := publisher.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly}); err != nil {
+		t.Fatal(err)
+	}
+	session, answer, err := s.Publish("room", offer(t, publisher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.CloseSession(session.ID)
+	if !strings.Contains(answer, " tcp ") {
+		t.Fatalf("Expected TCP candidates in the answer:\n%s", answer)
+	}
+	if err := publisher.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for session.Transport() != TransportTCP {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the session to connect over ICE-TCP, got %q", session.Transport())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if counts := s.Transports(); counts[TransportTCP] != 1 || counts[TransportUDP] != 0 {
+		t.Errorf("Expected one session over TCP, got %v", counts)
+	}
+}
@@ -0,0 +1,63 @@
+package sfu
+
+import "github.com/pion/webrtc/v4"
+
+// Transports a session's media can flow over, as seen by the SFU
+const (
+	TransportUDP = "udp" // Directly over UDP
+	TransportTCP = "tcp" // Directly over ICE-TCP
+
+	// Through the client's TURN server, which the client may reach over
+	// UDP, TCP or TLS
+	TransportRelay = "relay"
+)
+
+// Transport returns the transport of the session's selected ICE candidate
+// pair, or "" until ICE connects
+func (session *Session) Transport() string {
+	if session.pc == nil {
+		return ""
+	}
+	for _, transceiver := range session.pc.GetTransceivers() {
+		var dtls *webrtc.DTLSTransport
+		if sender := transceiver.Sender(); sender != nil {
+			dtls = sender.Transport()
+		} else if receiver := transceiver.Receiver(); receiver != nil {
+			dtls = receiver.Transport()
+		}
+		if dtls == nil {
+			continue
+		}
+		pair, err := dtls.ICETransport().GetSelectedCandidatePair()
+		if err != nil || pair == nil {
+			continue
+		}
+		switch {
+		case pair.Remote.Typ == webrtc.ICECandidateTypeRelay:
+			return TransportRelay
+		case pair.Local.Protocol == webrtc.ICEProtocolTCP:
+			return TransportTCP
+		default:
+			return TransportUDP
+		}
+	}
+	return ""
+}
+
+// Transports counts the connected sessions by transport
+func (s *SFU) Transports() map[string]int {
+	s.mutex.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mutex.Unlock()
+
+	counts := map[string]int{TransportUDP: 0, TransportTCP: 0, TransportRelay: 0}
+	for _, session := range sessions {
+		if transport := session.Transport(); transport != "" {
+			counts[transport]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,179 @@
+package signaling
+
+import (
+	"math"
+	"time"
+)
+
+// Speaking rate used to estimate talk time from transcribed words when a
+// participant sent no audio-level reports
+const wordsPerMinute = 150
+
+// Sources of a participant's talk time
+const (
+	TalkSourceAudio      = "audio"
+	TalkSourceTranscript = "transcript"
+)
+
+// TalkTime is how much a client spoke in a meeting, from its audio-level reports
+type TalkTime struct {
+	Duration time.Duration `json:"duration"`
+
+	// Number of times the client started speaking
+	Turns int `json:"turns"`
+}
+
+// MeetingAnalytics is the participation report of a meeting
+type MeetingAnalytics struct {
+	MeetingID       string                 `json:"meetingId"`
+	RoomID          string                 `json:"roomId"`
+	StartedAt       time.Time              `json:"startedAt"`
+	EndedAt         time.Time              `json:"endedAt,omitzero"`
+	DurationSeconds float64                `json:"durationSeconds"`
+	TalkSeconds     float64                `json:"talkSeconds"`
+	Participants    []ParticipantAnalytics `json:"participants"`
+
+	// How evenly talk time was spread: 1 when everyone who joined spoke
+	// equally, 0 when one person did all the talking
+	Balance float64 `json:"balance"`
+}
+
+// ParticipantAnalytics is one client's participation in a meeting, summed
+// over all its stays
+type ParticipantAnalytics struct {
+	ClientID          string  `json:"clientId"`
+	UserID            string  `json:"userId,omitempty"`
+	AttendanceSeconds float64 `json:"attendanceSeconds"`
+	TalkSeconds       float64 `json:"talkSeconds"`
+
+	// Fraction of the meeting's talk time
+	TalkShare float64 `json:"talkShare"`
+
+	Turns    int    `json:"turns"`
+	Captions int    `json:"captions"`
+	Words    int    `json:"words"`
+	Source   string `json:"source,omitempty"`
+}
+
+// ReportSpeaking records a client starting or stopping to speak, as detected
+// by its browser's audio level
+func (r *Room) ReportSpeaking(clientID string, speaking bool) {
+	r.clientMutex.Lock()
+	defer r.clientMutex.Unlock()
+	if r.meeting == nil || r.clients[clientID] == nil {
+		return
+	}
+	if speaking {
+		if _, ok := r.speakingSince[clientID]; !ok {
+			if r.speakingSince == nil {
+				r.speakingSince = make(map[string]time.Time)
+			}
+			r.speakingSince[clientID] = time.Now()
+		}
+		return
+	}
+	r.stopSpeakingLocked(clientID, time.Now())
+}
+
+// stopSpeakingLocked ends a client's turn and adds it to the meeting's talk
+// time; the caller must hold clientMutex
+func (r *Room) stopSpeakingLocked(clientID string, at time.Time) {
+	since, ok := r.speakingSince[clientID]
+	if !ok {
+		return
+	}
+	delete(r.speakingSince, clientID)
+	if r.meeting == nil {
+		return
+	}
+	if r.meeting.TalkTime == nil {
+		r.meeting.TalkTime = make(map[string]TalkTime)
+	}
+	talk := r.meeting.TalkTime[clientID]
+	talk.Duration += at.Sub(since)
+	talk.Turns++
+	r.meeting.TalkTime[clientID] = talk
+}
+
+// Analytics computes the participation report of a meeting. Participants
+// without audio-level reports get their talk time estimated from their
+// transcribed words. A running meeting is reported up to now
+func (m Meeting) Analytics() MeetingAnalytics {
+	end := m.EndedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	analytics := MeetingAnalytics{
+		MeetingID:       m.ID,
+		RoomID:          m.RoomID,
+		StartedAt:       m.StartedAt,
+		EndedAt:         m.EndedAt,
+		DurationSeconds: seconds(end.Sub(m.StartedAt)),
+		Participants:    []ParticipantAnalytics{},
+	}
+
+	index := make(map[string]int)
+	participant := func(clientID string) *ParticipantAnalytics {
+		i, ok := index[clientID]
+		if !ok {
+			i = len(analytics.Participants)
+			index[clientID] = i
+			analytics.Participants = append(analytics.Participants, ParticipantAnalytics{ClientID: clientID})
+		}
+		return &analytics.Participants[i]
+	}
+
+	for _, stay := range m.Participants {
+		p := participant(stay.ClientID)
+		if stay.UserID != "" {
+			p.UserID = stay.UserID
+		}
+		left := stay.LeftAt
+		if left.IsZero() {
+			left = end
+		}
+		p.AttendanceSeconds += seconds(left.Sub(stay.JoinedAt))
+	}
+	for _, caption := range m.Transcript {
+		p := participant(caption.Speaker)
+		p.Captions++
+		p.Words += len(words(caption.Text))
+	}
+
+	var total float64
+	for i := range analytics.Participants {
+		p := &analytics.Participants[i]
+		if talk, ok := m.TalkTime[p.ClientID]; ok {
+			p.TalkSeconds = seconds(talk.Duration)
+			p.Turns = talk.Turns
+			p.Source = TalkSourceAudio
+		} else if p.Words > 0 {
+			p.TalkSeconds = seconds(time.Duration(p.Words) * time.Minute / wordsPerMinute)
+			p.Turns = p.Captions
+			p.Source = TalkSourceTranscript
+		}
+		total += p.TalkSeconds
+	}
+	analytics.TalkSeconds = total
+
+	// Balance is the normalized entropy of the talk shares
+	if total > 0 {
+		var entropy float64
+		for i := range analytics.Participants {
+			p := &analytics.Participants[i]
+			p.TalkShare = math.Round(p.TalkSeconds/total*1000) / 1000
+			if share := p.TalkSeconds / total; share > 0 {
+				entropy -= share * math.Log(share)
+			}
+		}
+		if n := len(analytics.Participants); n > 1 {
+			analytics.Balance = math.Round(entropy/math.Log(float64(n))*1000) / 1000
+		}
+	}
+	return analytics
+}
+
+// seconds converts a duration to seconds rounded to a tenth
+func seconds(d time.Duration) float64 {
+	return math.Round(d.Seconds()*10) / 10
+}
@@ -0,0 +1,88 @@
+package signaling
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// AppDataType is the message type binary application data is relayed as.
+// Its Data holds the application's own type, and its Payload the bytes
+const AppDataType = "app-data"
+
+// appFrameMarker starts binary frames of application data. Protobuf frames
+// never start with it, as field 0 doesn't exist
+const appFrameMarker = 0x00
+
+// Longest type, sender or recipient in the header of an application frame
+const maxAppHeaderField = 128
+
+// errAppFrame is returned for application frames whose header is cut short
+var errAppFrame = errors.New("truncated application data header")
+
+// isAppFrame reports whether a binary frame holds application data rather
+// than protobuf messages
+func isAppFrame(frame []byte) bool {
+	return len(frame) > 0 && frame[0] == appFrameMarker
+}
+
+// encodeAppFrame lays out a frame of application data: the marker, then the
+// application's type, the sender and the recipient, each as a varint length
+// and its bytes, then the payload
+func encodeAppFrame(msg *Message) []byte {
+	appType, _ := msg.Data["type"].(string)
+	frame := make([]byte, 0, 4+len(appType)+len(msg.From)+len(msg.To)+len(msg.Payload))
+	frame = append(frame, appFrameMarker)
+	frame = appendString(frame, appType)
+	frame = appendString(frame, msg.From)
+	frame = appendString(frame, msg.To)
+	return append(frame, msg.Payload...)
+}
+
+// decodeAppFrame reads a frame of application data a client sent. The
+// sender in its header is ignored; the server sets it
+func decodeAppFrame(frame []byte) (*Message, error) {
+	rest := frame[1:]
+	var fields [3]string
+	for i := range fields {
+		length, n := binary.Uvarint(rest)
+		if n <= 0 || uint64(len(rest)-n) < length {
+			return nil, errAppFrame
+		}
+		if length > maxAppHeaderField {
+			return nil, fmt.Errorf("application data header fields are limited to %d bytes", maxAppHeaderField)
+		}
+		fields[i], rest = string(rest[n:n+int(length)]), rest[n+int(length):]
+	}
+	if !namespacePattern.MatchString(fields[0]) {
+		return nil, fmt.Errorf("invalid application data type %q", fields[0])
+	}
+	return &Message{
+		Type:    AppDataType,
+		To:      fields[2],
+		Data:    map[string]interface{}{"type": fields[0]},
+		Payload: rest,
+	}, nil
+}
+
+// relayAppData delivers application data to its recipient, or to everyone
+// else in the room when it has none
+func (c *Client) relayAppData(msg *Message) error {
+	if len(msg.Payload) == 0 {
+		return errors.New("application data is sent in binary frames, with a payload")
+	}
+	if msg.To == "" {
+		c.Room.Broadcast(msg, c.ID)
+		return nil
+	}
+	return c.Room.SendTo(msg)
+}
+
+// writeAppData writes application data to a client in a frame of its own
+func (c *Client) writeAppData(conn *websocket.Conn, msg *Message) error {
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteMessage(websocket.BinaryMessage, encodeAppFrame(msg))
+}
@@ -0,0 +1,217 @@
+package signaling
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Backplane carries room traffic between the nodes of a cluster, such as
+// Redis pub/sub. Each node follows the rooms it has clients in, and receives
+// what is sent to it alone
+type Backplane interface {
+	// Publish sends a payload to the other nodes following a room
+	Publish(roomID string, payload []byte) error
+
+	// PublishNode sends a payload to one node, such as the node a message's
+	// recipient is connected to. The node's handler gets it with an empty
+	// room ID
+	PublishNode(node string, payload []byte) error
+
+	// PublishCluster sends a payload to every node, this one included, such
+	// as heartbeats. Handlers get it with an empty room ID
+	PublishCluster(payload []byte) error
+
+	// Subscribe and Unsubscribe start and stop following a room
+	Subscribe(roomID string) error
+	Unsubscribe(roomID string) error
+
+	// OnMessage sets the handler of payloads published for followed rooms,
+	// including this node's own
+	OnMessage(handler func(roomID string, payload []byte))
+
+	// Sequence claims a moderation command ID and returns the next sequence
+	// number of the room's commands. An ID claimed before returns the number
+	// it got then, with first false
+	Sequence(roomID, commandID string) (seq int64, first bool, err error)
+}
+
+// remoteMessage is a client's message, a host's moderation command, a
+// node's heartbeat or an idle room sweep relayed to the other nodes
+type remoteMessage struct {
+	Node    string             `json:"node"`
+	Room    string             `json:"room,omitempty"`
+	Exclude string             `json:"exclude,omitempty"`
+	Message *Message           `json:"message,omitempty"`
+	Command *ModerationCommand `json:"command,omitempty"`
+
+	Heartbeat *NodeStatus         `json:"heartbeat,omitempty"`
+	Down      string              `json:"down,omitempty"` // Node declared dead
+	Left      map[string][]string `json:"left,omitempty"` // Its clients removed, by room
+
+	// Rooms idle for longer are to be closed, as the leader's sweep asks
+	IdleTTL time.Duration `json:"idleTtl,omitempty"`
+}
+
+// SetBackplane relays client traffic through a backplane, so clients of one
+// room connected to different nodes reach each other. Membership is shared
+// through the state store, which should be shared too. It must be called
+// before rooms are created
+func (h *Hub) SetBackplane(backplane Backplane) {
+	h.roomsMutex.Lock()
+	h.backplane = backplane
+	h.hooks = append(h.hooks, h.followRoom)
+	h.roomsMutex.Unlock()
+	backplane.OnMessage(h.receiveRemote)
+}
+
+// followRoom subscribes to a room's traffic when it opens on this node and
+// unsubscribes when it closes
+func (h *Hub) followRoom(room *Room, transition RoomTransition) {
+	if room.backplane == nil {
+		return
+	}
+	var err error
+	switch {
+	case transition.From == roomNew:
+		err = room.backplane.Subscribe(room.ID)
+	case transition.To == RoomClosed:
+		err = room.backplane.Unsubscribe(room.ID)
+	}
+	if err != nil {
+		util.Warn("Error following room %s on the backplane: %v", room.ID, err)
+	}
+}
+
+// publishRemote relays a client's message to the other nodes. Messages the
+// server generates, such as host changes, stay on the node
+func (r *Room) publishRemote(msg *Message, exclude string) {
+	if r.backplane == nil || msg.From == "" {
+		return
+	}
+	payload, err := json.Marshal(remoteMessage{Node: r.node, Room: r.ID, Exclude: exclude, Message: msg})
+	if err != nil {
+		util.Error("Error encoding %s for the backplane: %v", msg.Type, err)
+		return
+	}
+	if err := r.backplane.Publish(r.ID, payload); err != nil {
+		util.Warn("Error relaying %s from %s in room %s: %v", msg.Type, msg.From, r.ID, err)
+	}
+}
+
+// publishNode routes a message addressed to a client on another node to that
+// node alone
+func (r *Room) publishNode(node string, msg *Message) {
+	payload, err := json.Marshal(remoteMessage{Node: r.node, Room: r.ID, Message: msg})
+	if err != nil {
+		util.Error("Error encoding %s for the backplane: %v", msg.Type, err)
+		return
+	}
+	if err := r.backplane.PublishNode(node, payload); err != nil {
+		util.Warn("Error routing %s to %s on node %s: %v", msg.Type, msg.To, node, err)
+	}
+}
+
+// publishCommand sends a moderation command to every node following the
+// room, this one included
+func (r *Room) publishCommand(command ModerationCommand) error {
+	payload, err := json.Marshal(remoteMessage{Node: r.node, Room: r.ID, Command: &command})
+	if err != nil {
+		return err
+	}
+	return r.backplane.Publish(r.ID, payload)
+}
+
+// remoteNode looks up the node a client of the room is connected to in the
+// state store, reporting whether it is another node
+func (r *Room) remoteNode(clientID string) (string, bool) {
+	if r.backplane == nil || r.stateStore == nil {
+		return "", false
+	}
+	member, exists, err := r.stateStore.Member(r.ID, clientID)
+	if err != nil {
+		util.Warn("Error looking up %s in room %s: %v", clientID, r.ID, err)
+		return "", false
+	}
+	if !exists || member.Node == r.node {
+		return "", false
+	}
+	return member.Node, true
+}
+
+// remoteClientIDs lists the room's clients on other nodes
+func (r *Room) remoteClientIDs() []string {
+	if r.backplane == nil || r.stateStore == nil {
+		return nil
+	}
+	members, err := r.stateStore.Members(r.ID)
+	if err != nil {
+		util.Warn("Error reading members of room %s: %v", r.ID, err)
+		return nil
+	}
+	var clientIDs []string
+	for _, member := range members {
+		if member.Node != r.node {
+			clientIDs = append(clientIDs, member.ClientID)
+		}
+	}
+	return clientIDs
+}
+
+// receiveRemote delivers a message relayed by another node to this node's
+// clients in the room, and applies moderation commands in order. Messages
+// routed to this node alone and cluster gossip come without a room ID
+func (h *Hub) receiveRemote(roomID string, payload []byte) {
+	var remote remoteMessage
+	if err := json.Unmarshal(payload, &remote); err != nil {
+		util.Warn("Ignoring invalid backplane message for room %s", roomID)
+		return
+	}
+	switch {
+	case remote.Heartbeat != nil:
+		h.receiveHeartbeat(*remote.Heartbeat)
+		return
+	case remote.Down != "":
+		h.receiveDown(remote.Down, remote.Left)
+		return
+	case remote.IdleTTL > 0:
+		h.sweepRooms(time.Now(), remote.IdleTTL)
+		return
+	case remote.Message == nil && remote.Command == nil:
+		util.Warn("Ignoring invalid backplane message for room %s", roomID)
+		return
+	}
+	if roomID == "" {
+		roomID = remote.Room
+	}
+	room := h.FindRoom(roomID)
+	if room == nil {
+		return
+	}
+	if remote.Command != nil {
+		// Every node applies commands, the issuing one included
+		room.receiveCommand(*remote.Command)
+		return
+	}
+	if remote.Node == room.node {
+		return
+	}
+	msg := remote.Message
+
+	if msg.To != "" {
+		if client := room.client(msg.To); client != nil && !(isPeerSignal(msg.Type) && client.asideFrom(room)) {
+			client.Send(msg)
+		}
+		return
+	}
+	for _, client := range room.GetClients() {
+		if client.ID == msg.From || client.ID == remote.Exclude {
+			continue
+		}
+		if isPeerSignal(msg.Type) && client.asideFrom(room) {
+			continue
+		}
+		client.Send(msg)
+	}
+}
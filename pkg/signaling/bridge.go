@@ -0,0 +1,65 @@
+package signaling
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Sender ID of chat messages injected from external platforms
+const BridgeClientID = "bridge"
+
+// EventChatMessage is emitted for every chat message a client sends, so
+// bridges can relay it to external platforms
+const EventChatMessage = "chat-message"
+
+// BridgeMessage is a chat message coming from an external platform
+type BridgeMessage struct {
+	Source string `json:"source"` // Platform name, e.g. "slack", "matrix" or "irc"
+	Author string `json:"author"`
+	Text   string `json:"text"`
+}
+
+// InjectBridgeMessage posts an external chat message into the room as the
+// bridge participant
+func (r *Room) InjectBridgeMessage(message BridgeMessage) error {
+	if message.Text == "" {
+		return errors.New("text is required")
+	}
+	if int64(len(message.Text)) > sizeLimit("chat") {
+		return errors.New("text is too long")
+	}
+
+	util.Debug("Bridging chat from %s (%s) into room %s", message.Author, message.Source, r.ID)
+	msg := &Message{
+		Type: "chat",
+		From: BridgeClientID,
+		Data: map[string]interface{}{
+			"text":   message.Text,
+			"author": message.Author,
+			"source": message.Source,
+			"bridge": true,
+			"sentAt": time.Now().UnixMilli(),
+		},
+	}
+	r.broadcastChat(msg)
+	return nil
+}
+
+// emitChat reports a chat message sent by a client to bridges
+func (c *Client) emitChat(msg *Message) {
+	text, _ := msg.Data["text"].(string)
+	if text == "" {
+		return
+	}
+	c.hub.emit(Event{
+		Type:     EventChatMessage,
+		RoomID:   c.Room.ID,
+		ClientID: c.ID,
+		Data: map[string]interface{}{
+			"text":   text,
+			"author": c.userKey(),
+		},
+	})
+}
@@ -0,0 +1,162 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// RecordingCall is the kind of recordings the server makes of the media
+// forwarded in a room in SFU mode
+const RecordingCall = "call"
+
+// CallRecorder records the media of rooms in SFU mode on the server, such
+// as the SFU writing the tracks it forwards to disk
+type CallRecorder interface {
+	// StartRecording starts writing the room's media
+	StartRecording(recording Recording) error
+
+	// StopRecording stops writing. The artifact is stored in the background
+	// and reported with Hub.CompleteCallRecording
+	StopRecording(recording Recording) error
+}
+
+// SetCallRecorder sets what records rooms in SFU mode on the server.
+// Recordings stop once their room is empty. It must be called before
+// clients connect
+func (h *Hub) SetCallRecorder(recorder CallRecorder) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.callRecorder = recorder
+}
+
+// StartCallRecording has the server record the room's media. Only clients
+// with the record permission may start it, by default the host, and only in
+// SFU mode. Everyone in the room is told it is being recorded
+func (r *Room) StartCallRecording(host *Client) (Recording, error) {
+	if !host.may(PermRecord) {
+		return Recording{}, fmt.Errorf("starting recordings: %w", ErrRoleDenied)
+	}
+	if r.Settings().MediaMode() != ModeSFU {
+		return Recording{}, errors.New("the server only records rooms in SFU mode")
+	}
+	if r.hub == nil || r.hub.callRecorder == nil {
+		return Recording{}, errors.New("server recording is disabled")
+	}
+
+	now := time.Now()
+	recording := Recording{
+		ID:          randomToken(8),
+		RoomID:      r.ID,
+		Kind:        RecordingCall,
+		RequestedBy: host.ID,
+		Status:      RecordingActive,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	r.recordingMutex.Lock()
+	for _, entry := range r.recordings {
+		if entry.Kind == RecordingCall && entry.Status == RecordingActive {
+			r.recordingMutex.Unlock()
+			return Recording{}, fmt.Errorf("recording %s is already running", entry.ID)
+		}
+	}
+	r.recordings[recording.ID] = &roomRecording{Recording: recording}
+	r.recordingMutex.Unlock()
+
+	if err := r.hub.callRecorder.StartRecording(recording); err != nil {
+		r.recordingMutex.Lock()
+		delete(r.recordings, recording.ID)
+		r.recordingMutex.Unlock()
+		return Recording{}, err
+	}
+	r.saveRecording(recording)
+
+	util.Info("Host %s started recording %s of room %s", host.ID, recording.ID, r.ID)
+	r.logModeration(ModerationStartRecording, host.ID, "", recording.ID)
+	r.Broadcast(recordingStatusMessage(recording), "")
+	r.hub.emit(Event{
+		Type:     EventRecordingStarted,
+		RoomID:   r.ID,
+		ClientID: host.ID,
+		Data: map[string]interface{}{
+			"recordingId": recording.ID,
+			"kind":        recording.Kind,
+		},
+	})
+	return recording, nil
+}
+
+// activeCallRecording returns the ID of the room's running call recording,
+// or an empty string
+func (r *Room) activeCallRecording() string {
+	r.recordingMutex.Lock()
+	defer r.recordingMutex.Unlock()
+	for _, entry := range r.recordings {
+		if entry.Kind == RecordingCall && entry.Status == RecordingActive {
+			return entry.ID
+		}
+	}
+	return ""
+}
+
+// stopCallRecording has the call recorder store a recording that stopped
+func (r *Room) stopCallRecording(recording Recording) {
+	if err := r.hub.callRecorder.StopRecording(recording); err != nil {
+		util.Error("Error stopping recording %s of room %s: %v", recording.ID, r.ID, err)
+	}
+}
+
+// stopEmptyRoomRecording stops the call recording of a room once its last
+// client left
+func (r *Room) stopEmptyRoomRecording(transition RoomTransition) {
+	if transition.To != RoomEnding && transition.To != RoomClosed {
+		return
+	}
+	var recording Recording
+	r.recordingMutex.Lock()
+	for _, entry := range r.recordings {
+		if entry.Kind == RecordingCall && entry.Status == RecordingActive {
+			entry.Status = RecordingStopped
+			entry.UpdatedAt = time.Now()
+			recording = entry.Recording
+		}
+	}
+	r.recordingMutex.Unlock()
+	if recording.ID == "" {
+		return
+	}
+	r.saveRecording(recording)
+
+	util.Info("Recording %s of room %s stopped as the room is empty", recording.ID, r.ID)
+	r.stopCallRecording(recording)
+}
+
+// CompleteCallRecording records the stored artifact of a call recording.
+// The room may have closed in the meantime; the metadata is then saved
+// without telling anyone in the room
+func (h *Hub) CompleteCallRecording(recording Recording, artifact Artifact) Recording {
+	if completed, err := h.CompleteRecording(recording.RoomID, recording.ID, artifact); err == nil {
+		return completed
+	}
+
+	recording.Status = RecordingAvailable
+	recording.File = artifact.File
+	recording.ContentType = artifact.ContentType
+	recording.Size = artifact.Size
+	recording.SHA256 = artifact.SHA256
+	recording.UpdatedAt = time.Now()
+	h.roomsMutex.RLock()
+	store := h.recordingStore
+	h.roomsMutex.RUnlock()
+	if store != nil {
+		if err := store.SaveRecording(recording); err != nil {
+			util.Error("Error saving recording %s of room %s: %v", recording.ID, recording.RoomID, err)
+		}
+	}
+	util.Info("Recording %s of closed room %s is ready (%d bytes)", recording.ID, recording.RoomID, recording.Size)
+	h.emitRecordingReady(recording)
+	return recording
+}
@@ -0,0 +1,487 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// DefaultRingTimeout is how long a call rings before it times out
+const DefaultRingTimeout = 30 * time.Second
+
+// ErrCallNotFound is returned for calls that aren't ringing or active
+var ErrCallNotFound = errors.New("call not found")
+
+// CallState is the state of a 1:1 call
+type CallState string
+
+const (
+	// The callee's devices are ringing
+	CallRinging CallState = "ringing"
+
+	// The callee answered; both talk in the call's room
+	CallActive CallState = "active"
+
+	// The call was not answered or is over
+	CallEnded CallState = "ended"
+)
+
+// Why a call ended
+const (
+	CallDeclined    = "declined"
+	CallBusy        = "busy"
+	CallTimeout     = "timeout"
+	CallCancelled   = "cancelled"
+	CallUnavailable = "unavailable"
+	CallHungUp      = "hung-up"
+	CallRoomClosed  = "room-closed"
+	CallTransferred = "transferred"
+)
+
+// Presence statuses a user can set; calls to users on do not disturb are
+// answered busy without ringing
+const (
+	PresenceAvailable = "available"
+	PresenceDND       = "dnd"
+)
+
+// Call is a 1:1 call one user places to another from any connection. The
+// server rings all of the callee's connections and, once one accepts,
+// creates a room for the two of them
+type Call struct {
+	ID         string     `json:"id"`
+	Caller     string     `json:"caller"`
+	Callee     string     `json:"callee"`
+	State      CallState  `json:"state"`
+	RoomID     string     `json:"roomId,omitempty"`
+	Outcome    string     `json:"outcome,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	AnsweredAt *time.Time `json:"answeredAt,omitempty"`
+	EndedAt    *time.Time `json:"endedAt,omitempty"`
+
+	// User who transferred the caller to the callee, if anyone
+	TransferredBy string `json:"transferredBy,omitempty"`
+
+	// Party who put the call on hold, if it is
+	HeldBy string `json:"heldBy,omitempty"`
+
+	caller   *Client   // Connection that placed the call
+	answerer *Client   // Connection that accepted it
+	ringing  []*Client // Callee's connections that were rung
+	timer    *time.Timer
+
+	transfer     *callTransfer // Call this one was placed to transfer
+	transferring bool          // An attended transfer of this call is ringing
+}
+
+// calls tracks the ringing and active calls of a hub
+type calls struct {
+	mutex       sync.Mutex
+	byID        map[string]*Call
+	byUser      map[string]string // Call ID by user key of either party
+	byRoom      map[string]string // Call ID by room ID once answered
+	presence    map[string]string // Status by user key; available if unset
+	ringTimeout time.Duration
+	holdMusic   bool
+}
+
+// newCalls creates an empty call registry
+func newCalls() calls {
+	return calls{
+		byID:        make(map[string]*Call),
+		byUser:      make(map[string]string),
+		byRoom:      make(map[string]string),
+		presence:    make(map[string]string),
+		ringTimeout: DefaultRingTimeout,
+	}
+}
+
+// SetRingTimeout sets how long calls placed from now on ring
+func (h *Hub) SetRingTimeout(timeout time.Duration) {
+	h.calls.mutex.Lock()
+	defer h.calls.mutex.Unlock()
+	h.calls.ringTimeout = timeout
+}
+
+// Calls returns the ringing and active calls
+func (h *Hub) Calls() []Call {
+	h.calls.mutex.Lock()
+	defer h.calls.mutex.Unlock()
+
+	list := make([]Call, 0, len(h.calls.byID))
+	for _, call := range h.calls.byID {
+		list = append(list, call.info())
+	}
+	return list
+}
+
+// SetPresence sets the status of the client's user for incoming calls
+func (c *Client) SetPresence(status string) error {
+	switch status {
+	case PresenceAvailable, PresenceDND:
+	default:
+		return fmt.Errorf("unknown status %q", status)
+	}
+	c.hub.calls.mutex.Lock()
+	defer c.hub.calls.mutex.Unlock()
+	if status == PresenceAvailable {
+		delete(c.hub.calls.presence, c.userKey())
+	} else {
+		c.hub.calls.presence[c.userKey()] = status
+	}
+	util.Info("User %s is now %s", c.userKey(), status)
+	return nil
+}
+
+// PlaceCall rings every connection of another user. The caller gets
+// call-ringing, or call-busy or call-unavailable if the callee can't be rung,
+// and later call-accepted, call-declined or call-timeout
+func (c *Client) PlaceCall(userID string) (Call, error) {
+	return c.hub.placeCall(c, userID, nil)
+}
+
+// placeCall rings the user for the client, transferring another call to
+// them if transfer is set
+func (h *Hub) placeCall(c *Client, userID string, transfer *callTransfer) (Call, error) {
+	if userID == "" || userID == c.userKey() {
+		return Call{}, errors.New("a call needs another user")
+	}
+	devices := h.userClients(userID)
+
+	h.calls.mutex.Lock()
+	if id, busy := h.calls.byUser[c.userKey()]; busy && (transfer == nil || id != transfer.from.ID) {
+		h.calls.mutex.Unlock()
+		return Call{}, errors.New("already in a call")
+	}
+	call := &Call{
+		ID:        randomToken(8),
+		Caller:    c.userKey(),
+		Callee:    userID,
+		State:     CallRinging,
+		StartedAt: time.Now(),
+		caller:    c,
+		transfer:  transfer,
+	}
+	if transfer != nil {
+		call.TransferredBy = transfer.by.userKey()
+	}
+	reason := ""
+	switch {
+	case h.calls.presence[userID] == PresenceDND:
+		call.end(CallBusy)
+		reason = PresenceDND
+	case h.calls.byUser[userID] != "":
+		call.end(CallBusy)
+		reason = "in-call"
+	case len(devices) == 0:
+		call.end(CallUnavailable)
+	}
+	if call.State == CallEnded {
+		h.forgetCallLocked(call)
+		h.calls.mutex.Unlock()
+		util.Info("Call %s from %s to %s not placed: %s", call.ID, call.Caller, userID, call.Outcome)
+		data := call.data()
+		if reason != "" {
+			data["reason"] = reason
+		}
+		c.Send(&Message{Type: "call-" + call.Outcome, To: c.ID, Data: data})
+		call.transferFailed()
+		return call.info(), nil
+	}
+
+	call.ringing = devices
+	h.calls.byID[call.ID] = call
+	h.calls.byUser[call.Caller] = call.ID
+	h.calls.byUser[call.Callee] = call.ID
+	call.timer = time.AfterFunc(h.calls.ringTimeout, func() { h.ringTimedOut(call.ID) })
+	info := call.info()
+	h.calls.mutex.Unlock()
+
+	util.Info("Call %s from %s rings %d connections of %s", call.ID, call.Caller, len(devices), userID)
+	for _, device := range devices {
+		incoming := map[string]interface{}{
+			"callId":   call.ID,
+			"from":     call.Caller,
+			"clientId": c.ID,
+		}
+		if call.TransferredBy != "" {
+			incoming["transferredBy"] = call.TransferredBy
+		}
+		device.Send(&Message{Type: "call-incoming", To: device.ID, Data: incoming})
+	}
+	c.Send(&Message{Type: "call-ringing", To: c.ID, Data: call.data()})
+	return info, nil
+}
+
+// AnswerCall accepts or declines a call ringing on the client. Accepting
+// creates the call's room, which both parties then join; the callee's other
+// connections stop ringing
+func (c *Client) AnswerCall(callID string, accept bool) (Call, error) {
+	h := c.hub
+	h.calls.mutex.Lock()
+	call := h.calls.byID[callID]
+	if call == nil || call.State != CallRinging || !call.rings(c) {
+		h.calls.mutex.Unlock()
+		return Call{}, ErrCallNotFound
+	}
+	call.timer.Stop()
+	if !accept {
+		call.end(CallDeclined)
+		h.forgetCallLocked(call)
+		info := call.info()
+		h.calls.mutex.Unlock()
+
+		util.Info("Call %s declined by %s", call.ID, c.ID)
+		call.caller.Send(&Message{Type: "call-declined", To: call.caller.ID, Data: call.data()})
+		call.stopRinging(c, CallDeclined)
+		call.transferFailed()
+		return info, nil
+	}
+
+	now := time.Now()
+	call.State = CallActive
+	call.AnsweredAt = &now
+	call.answerer = c
+	call.RoomID = "call-" + call.ID
+	h.calls.byRoom[call.RoomID] = call.ID
+	transferred := h.completeTransferLocked(call)
+	joinTimeout := h.calls.ringTimeout
+	info := call.info()
+	h.calls.mutex.Unlock()
+
+	if transferred != nil {
+		transferred.endTransferred(call)
+	}
+
+	h.GetRoomWithSettings(call.RoomID, func(settings *RoomSettings) {
+		settings.Persistent = false
+	})
+	// A call room nobody joins would keep both parties busy forever
+	time.AfterFunc(joinTimeout, func() { h.RemoveRoom(call.RoomID) })
+
+	util.Info("Call %s accepted by %s in room %s", call.ID, c.ID, call.RoomID)
+	data := call.data()
+	data["roomId"] = call.RoomID
+	data["clientId"] = c.ID
+	call.caller.Send(&Message{Type: "call-accepted", To: call.caller.ID, Data: data})
+	c.Send(&Message{Type: "call-accepted", To: c.ID, Data: data})
+	call.stopRinging(c, "answered-elsewhere")
+	return info, nil
+}
+
+// HangUp ends the client's call: a ringing call is cancelled for the callee,
+// an active one ends for the other party
+func (c *Client) HangUp(callID string) (Call, error) {
+	h := c.hub
+	h.calls.mutex.Lock()
+	call := h.calls.byID[callID]
+	if call == nil || !call.isParty(c) {
+		h.calls.mutex.Unlock()
+		return Call{}, ErrCallNotFound
+	}
+	ringing := call.State == CallRinging
+	if ringing && c.userKey() != call.Caller {
+		h.calls.mutex.Unlock()
+		return Call{}, errors.New("decline a ringing call instead")
+	}
+	call.timer.Stop()
+	if ringing {
+		call.end(CallCancelled)
+	} else {
+		call.end(CallHungUp)
+	}
+	h.forgetCallLocked(call)
+	info := call.info()
+	h.calls.mutex.Unlock()
+
+	util.Info("Call %s ended by %s: %s", call.ID, c.ID, info.Outcome)
+	if ringing {
+		call.stopRinging(nil, CallCancelled)
+		call.transferFailed()
+		return info, nil
+	}
+	other := call.otherParty(c)
+	data := call.data()
+	data["reason"] = CallHungUp
+	data["by"] = c.userKey()
+	other.Send(&Message{Type: "call-ended", To: other.ID, Data: data})
+	return info, nil
+}
+
+// ringTimedOut ends a call nobody answered within the ring timeout
+func (h *Hub) ringTimedOut(callID string) {
+	h.calls.mutex.Lock()
+	call := h.calls.byID[callID]
+	if call == nil || call.State != CallRinging {
+		h.calls.mutex.Unlock()
+		return
+	}
+	call.end(CallTimeout)
+	h.forgetCallLocked(call)
+	h.calls.mutex.Unlock()
+
+	util.Info("Call %s from %s to %s timed out", call.ID, call.Caller, call.Callee)
+	call.caller.Send(&Message{Type: "call-timeout", To: call.caller.ID, Data: call.data()})
+	for _, device := range call.ringing {
+		device.Send(&Message{Type: "call-timeout", To: device.ID, Data: call.data()})
+	}
+	call.transferFailed()
+}
+
+// endCallInRoom ends the call whose room closed, e.g. after both parties left
+func (h *Hub) endCallInRoom(roomID string) {
+	h.calls.mutex.Lock()
+	call := h.calls.byID[h.calls.byRoom[roomID]]
+	if call == nil {
+		h.calls.mutex.Unlock()
+		return
+	}
+	call.end(CallRoomClosed)
+	h.forgetCallLocked(call)
+	h.calls.mutex.Unlock()
+
+	util.Info("Call %s ended with its room %s", call.ID, roomID)
+	data := call.data()
+	data["reason"] = CallRoomClosed
+	for _, party := range []*Client{call.caller, call.answerer} {
+		party.Send(&Message{Type: "call-ended", To: party.ID, Data: data})
+	}
+}
+
+// cancelCallsOf cancels a call still ringing when the connection that
+// placed it closes
+func (h *Hub) cancelCallsOf(c *Client) {
+	h.calls.mutex.Lock()
+	call := h.calls.byID[h.calls.byUser[c.userKey()]]
+	if call == nil || call.State != CallRinging || call.caller != c {
+		h.calls.mutex.Unlock()
+		return
+	}
+	call.timer.Stop()
+	call.end(CallCancelled)
+	h.forgetCallLocked(call)
+	h.calls.mutex.Unlock()
+
+	util.Info("Call %s cancelled, caller %s disconnected", call.ID, c.ID)
+	call.stopRinging(nil, CallCancelled)
+	call.transferFailed()
+}
+
+// forgetCallLocked drops a call that ended; the caller must hold the calls
+// mutex
+func (h *Hub) forgetCallLocked(call *Call) {
+	delete(h.calls.byID, call.ID)
+	if h.calls.byUser[call.Caller] == call.ID {
+		delete(h.calls.byUser, call.Caller)
+	}
+	if h.calls.byUser[call.Callee] == call.ID {
+		delete(h.calls.byUser, call.Callee)
+	}
+	if call.RoomID != "" {
+		delete(h.calls.byRoom, call.RoomID)
+	}
+
+	// The call being transferred with attendance is the caller's again
+	if transfer := call.transfer; transfer != nil && transfer.attended {
+		transfer.from.transferring = false
+		if h.calls.byID[transfer.from.ID] == transfer.from {
+			h.calls.byUser[call.Caller] = transfer.from.ID
+		}
+	}
+}
+
+// userClients returns every open connection of a user across all rooms
+func (h *Hub) userClients(userID string) []*Client {
+	h.roomsMutex.RLock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.roomsMutex.RUnlock()
+
+	var clients []*Client
+	for _, room := range rooms {
+		for _, client := range room.GetClients() {
+			if client.userKey() == userID && client.State() != StateLeaving {
+				clients = append(clients, client)
+			}
+		}
+	}
+	return clients
+}
+
+// end marks the call as over; the caller must hold the calls mutex
+func (call *Call) end(outcome string) {
+	now := time.Now()
+	call.State = CallEnded
+	call.Outcome = outcome
+	call.EndedAt = &now
+}
+
+// isParty reports whether the client's user placed or was called in the call
+func (call *Call) isParty(c *Client) bool {
+	return c.userKey() == call.Caller || c.userKey() == call.Callee
+}
+
+// otherParty returns the call connection of the party the client isn't
+func (call *Call) otherParty(c *Client) *Client {
+	if c.userKey() == call.Caller {
+		return call.answerer
+	}
+	return call.caller
+}
+
+// rings reports whether the call rang on the connection
+func (call *Call) rings(c *Client) bool {
+	for _, device := range call.ringing {
+		if device == c {
+			return true
+		}
+	}
+	return false
+}
+
+// stopRinging tells the callee's connections, except the one that answered,
+// that the call no longer rings for them
+func (call *Call) stopRinging(except *Client, reason string) {
+	data := call.data()
+	data["reason"] = reason
+	for _, device := range call.ringing {
+		if device != except {
+			device.Send(&Message{Type: "call-cancelled", To: device.ID, Data: data})
+		}
+	}
+}
+
+// data returns the fields every call message carries
+func (call *Call) data() map[string]interface{} {
+	data := map[string]interface{}{
+		"callId": call.ID,
+		"caller": call.Caller,
+		"callee": call.Callee,
+	}
+	if call.TransferredBy != "" {
+		data["transferredBy"] = call.TransferredBy
+	}
+	return data
+}
+
+// info copies the call without its connections
+func (call *Call) info() Call {
+	return Call{
+		ID:            call.ID,
+		Caller:        call.Caller,
+		Callee:        call.Callee,
+		State:         call.State,
+		RoomID:        call.RoomID,
+		Outcome:       call.Outcome,
+		StartedAt:     call.StartedAt,
+		AnsweredAt:    call.AnsweredAt,
+		EndedAt:       call.EndedAt,
+		TransferredBy: call.TransferredBy,
+		HeldBy:        call.HeldBy,
+	}
+}
@@ -0,0 +1,229 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// CaptionOriginal is the channel carrying captions in the language spoken
+const CaptionOriginal = "original"
+
+// How long a translation may take before the caption is dropped for that language
+const translateTimeout = 5 * time.Second
+
+// Most final captions kept as the room's transcript
+const maxTranscript = 10000
+
+// Maximum length of one caption
+const maxCaptionLength = 1000
+
+// ErrTranscriptionDisabled is returned when captions are sent to a room
+// without transcription
+var ErrTranscriptionDisabled = errors.New("transcription is not enabled in this room")
+
+// Translator translates caption text between languages, e.g. through a
+// machine translation service
+type Translator interface {
+	Translate(ctx context.Context, text, from, to string) (string, error)
+}
+
+// Caption is one piece of transcribed speech
+type Caption struct {
+	// Client whose speech was transcribed
+	Speaker string `json:"speaker"`
+
+	Text string `json:"text"`
+
+	// Language of the text, e.g. "en"
+	Lang string `json:"lang"`
+
+	// Interim captions are replaced by later ones until a final caption ends the phrase
+	Final bool `json:"final"`
+
+	At time.Time `json:"at"`
+}
+
+// captionState holds the caption subscriptions and transcript of a room
+type captionState struct {
+	mutex         sync.Mutex
+	subscriptions map[string]string // Client ID -> channel
+	transcript    []Caption
+	seq           int
+}
+
+// SetTranslator sets the translator used for caption channels in other
+// languages. It must be called before rooms are created
+func (h *Hub) SetTranslator(translator Translator) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.translator = translator
+}
+
+// CaptionChannels returns the channels clients of the room can subscribe to
+func (r *Room) CaptionChannels() []string {
+	settings := r.Settings()
+	if !settings.Transcription {
+		return nil
+	}
+	channels := []string{CaptionOriginal}
+	if r.translator != nil {
+		channels = append(channels, settings.CaptionLanguages...)
+	}
+	return channels
+}
+
+// SubscribeCaptions selects the caption channel a client receives; an empty
+// channel unsubscribes
+func (r *Room) SubscribeCaptions(clientID, channel string) error {
+	if channel != "" && !slices.Contains(r.CaptionChannels(), channel) {
+		return fmt.Errorf("unknown caption channel %q", channel)
+	}
+
+	r.captions.mutex.Lock()
+	defer r.captions.mutex.Unlock()
+	if r.captions.subscriptions == nil {
+		r.captions.subscriptions = make(map[string]string)
+	}
+	if channel == "" {
+		delete(r.captions.subscriptions, clientID)
+	} else {
+		r.captions.subscriptions[clientID] = channel
+	}
+	return nil
+}
+
+// unsubscribeCaptions drops the subscription of a client that left
+func (r *Room) unsubscribeCaptions(clientID string) {
+	r.captions.mutex.Lock()
+	defer r.captions.mutex.Unlock()
+	delete(r.captions.subscriptions, clientID)
+}
+
+// PublishCaption fans a caption out to the subscribed clients. Subscribers
+// of the original channel or of the caption's language get it as is; final
+// captions are translated for the other language channels
+func (r *Room) PublishCaption(caption Caption) error {
+	settings := r.Settings()
+	if !settings.Transcription {
+		return ErrTranscriptionDisabled
+	}
+	caption.Lang = strings.ToLower(caption.Lang)
+	caption.Text = strings.TrimSpace(caption.Text)
+	if caption.Text == "" {
+		return errors.New("caption text is empty")
+	}
+	if len(caption.Text) > maxCaptionLength {
+		caption.Text = caption.Text[:maxCaptionLength]
+	}
+	if caption.At.IsZero() {
+		caption.At = time.Now()
+	}
+
+	r.captions.mutex.Lock()
+	r.captions.seq++
+	seq := r.captions.seq
+	if caption.Final {
+		r.captions.transcript = append(r.captions.transcript, caption)
+		if len(r.captions.transcript) > maxTranscript {
+			r.captions.transcript = r.captions.transcript[len(r.captions.transcript)-maxTranscript:]
+		}
+	}
+	byChannel := make(map[string][]string)
+	for clientID, channel := range r.captions.subscriptions {
+		if channel == caption.Lang {
+			channel = CaptionOriginal
+		}
+		byChannel[channel] = append(byChannel[channel], clientID)
+	}
+	r.captions.mutex.Unlock()
+
+	r.sendCaption(byChannel[CaptionOriginal], CaptionOriginal, caption, seq)
+	if !caption.Final {
+		return nil
+	}
+	r.alertKeywords(settings.Keywords, caption, seq)
+	if r.translator == nil {
+		return nil
+	}
+	for _, lang := range settings.CaptionLanguages {
+		if len(byChannel[lang]) == 0 || lang == caption.Lang {
+			continue
+		}
+		go r.translateCaption(byChannel[lang], lang, caption, seq)
+	}
+	return nil
+}
+
+// translateCaption translates a caption and sends it to one channel
+func (r *Room) translateCaption(clientIDs []string, lang string, caption Caption, seq int) {
+	ctx, cancel := context.WithTimeout(context.Background(), translateTimeout)
+	defer cancel()
+
+	text, err := r.translator.Translate(ctx, caption.Text, caption.Lang, lang)
+	if err != nil {
+		util.Warn("Error translating caption in room %s to %s: %v", r.ID, lang, err)
+		return
+	}
+	translated := caption
+	translated.Text = text
+	translated.Lang = lang
+	r.sendCaption(clientIDs, lang, translated, seq)
+}
+
+// sendCaption delivers a caption to clients on a channel
+func (r *Room) sendCaption(clientIDs []string, channel string, caption Caption, seq int) {
+	for _, clientID := range clientIDs {
+		client := r.client(clientID)
+		if client == nil {
+			continue
+		}
+		client.Send(&Message{
+			Type: "caption",
+			Data: map[string]interface{}{
+				"channel": channel,
+				"speaker": caption.Speaker,
+				"text":    caption.Text,
+				"lang":    caption.Lang,
+				"final":   caption.Final,
+				"seq":     seq,
+				"at":      caption.At,
+			},
+		})
+	}
+}
+
+// Transcript returns the final captions of the room's current meeting in the
+// spoken languages
+func (r *Room) Transcript() []Caption {
+	r.captions.mutex.Lock()
+	defer r.captions.mutex.Unlock()
+	return slices.Clone(r.captions.transcript)
+}
+
+// takeTranscript returns the transcript and starts a new one for the next meeting
+func (r *Room) takeTranscript() []Caption {
+	r.captions.mutex.Lock()
+	defer r.captions.mutex.Unlock()
+	transcript := r.captions.transcript
+	r.captions.transcript = nil
+	return transcript
+}
+
+// ParseCaptionLanguages splits a comma-separated list of language codes
+func ParseCaptionLanguages(value string) []string {
+	var langs []string
+	for _, lang := range strings.Split(value, ",") {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang != "" && lang != CaptionOriginal && !slices.Contains(langs, lang) {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
@@ -0,0 +1,75 @@
+package signaling
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Kinds of local screen capture a client can report
+const (
+	CaptureScreenshot = "screenshot"
+	CaptureRecording  = "screen-recording"
+)
+
+// AuditScreenCapture is the audit action of a reported screen capture
+const AuditScreenCapture = "screen-capture"
+
+// Reports from one client closer together than this are dropped, so a held
+// key or a chatty detector doesn't flood the host
+const captureReportInterval = 2 * time.Second
+
+// Longest detail kept from a capture report
+const maxCaptureDetail = 200
+
+// ReportScreenCapture handles a client reporting that it detected a local
+// screenshot or screen recording. The host is told, the report is written to
+// the audit log and an event is emitted
+func (r *Room) ReportScreenCapture(client *Client, kind, detail string) error {
+	if kind != CaptureScreenshot && kind != CaptureRecording {
+		return fmt.Errorf("unknown capture kind %q", kind)
+	}
+	if len(detail) > maxCaptureDetail {
+		detail = detail[:maxCaptureDetail]
+	}
+
+	now := time.Now()
+	client.mutex.Lock()
+	throttled := now.Sub(client.lastCaptureReport) < captureReportInterval
+	if !throttled {
+		client.lastCaptureReport = now
+	}
+	client.mutex.Unlock()
+	if throttled {
+		return nil
+	}
+
+	util.Warn("Client %s reported a %s in room %s", client.ID, kind, r.ID)
+	r.audit(AuditEntry{Action: AuditScreenCapture, ClientID: client.ID, Details: kind + " " + detail})
+
+	if host := r.client(r.GetHost()); host != nil && host != client {
+		host.Send(&Message{
+			Type: "screen-capture-alert",
+			To:   host.ID,
+			Data: map[string]interface{}{
+				"clientId": client.ID,
+				"userId":   client.UserID,
+				"kind":     kind,
+				"detail":   detail,
+				"at":       now,
+			},
+		})
+	}
+	r.hub.emit(Event{
+		Type:     EventScreenCapture,
+		RoomID:   r.ID,
+		ClientID: client.ID,
+		Data: map[string]interface{}{
+			"kind":   kind,
+			"detail": detail,
+			"userId": client.UserID,
+		},
+	})
+	return nil
+}
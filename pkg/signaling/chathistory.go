@@ -0,0 +1,169 @@
+package signaling
+
+import (
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Chat messages kept per room by the in-memory store, and rooms it keeps
+// history for before forgetting the least recently active
+const (
+	DefaultChatHistory = 200
+	maxChatRooms       = 1000
+)
+
+// Earlier messages sent to a client that joins a room
+const chatHistoryOnJoin = 50
+
+// ChatMessage is a chat message stored with its room's history
+type ChatMessage struct {
+	// Increases with each message of the room; pages of history are
+	// fetched before one
+	Seq    int64                  `json:"seq"`
+	RoomID string                 `json:"roomId"`
+	From   string                 `json:"from"`
+	Data   map[string]interface{} `json:"data"`
+	At     time.Time              `json:"at"`
+}
+
+// ChatStore keeps the chat history of rooms. Only messages to the whole
+// room are stored; private messages aren't
+type ChatStore interface {
+	// AppendChat stores a message, numbering it after the room's last one
+	AppendChat(message ChatMessage) (ChatMessage, error)
+
+	// ChatHistory returns up to limit of a room's messages before seq, or
+	// its latest messages if seq is 0, oldest first
+	ChatHistory(roomID string, before int64, limit int) ([]ChatMessage, error)
+}
+
+// SetChatStore sets where chat history is kept, replacing the in-memory
+// default; nil keeps no history
+func (h *Hub) SetChatStore(store ChatStore) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.chat = store
+}
+
+// chatStore returns the hub's chat store, or nil
+func (h *Hub) chatStore() ChatStore {
+	if h == nil {
+		return nil
+	}
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+	return h.chat
+}
+
+// ChatHistory returns up to limit of a room's messages before seq, or its
+// latest if seq is 0, oldest first. Rooms have no history when chat isn't
+// kept
+func (h *Hub) ChatHistory(roomID string, before int64, limit int) ([]ChatMessage, error) {
+	store := h.chatStore()
+	if store == nil {
+		return nil, nil
+	}
+	return store.ChatHistory(roomID, before, limit)
+}
+
+// storeChat adds a chat message to the room's history, unless it is private
+func (r *Room) storeChat(msg *Message) {
+	store := r.hub.chatStore()
+	if store == nil || msg.To != "" {
+		return
+	}
+	_, err := store.AppendChat(ChatMessage{RoomID: r.ID, From: msg.From, Data: maps.Clone(msg.Data), At: time.Now()})
+	if err != nil {
+		util.Warn("Error storing chat from %s in room %s: %v", msg.From, r.ID, err)
+	}
+}
+
+// sendChatHistory sends a client that just joined the room's latest chat
+// messages as one chat-history message, if there are any
+func (r *Room) sendChatHistory(client *Client) {
+	messages, err := r.hub.ChatHistory(r.ID, 0, chatHistoryOnJoin)
+	if err != nil {
+		util.Warn("Error reading chat history of room %s: %v", r.ID, err)
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+	client.Send(&Message{Type: "chat-history", To: client.ID, Data: map[string]interface{}{"messages": messages}})
+}
+
+// MemoryChatStore is the default chat store, keeping the latest messages
+// of each room in process. History doesn't survive a restart and isn't
+// shared between nodes
+type MemoryChatStore struct {
+	size int
+
+	mutex sync.Mutex
+	rooms map[string]*chatRing
+}
+
+// chatRing is the latest messages of one room
+type chatRing struct {
+	messages []ChatMessage
+	seq      int64
+	updated  time.Time
+}
+
+// NewMemoryChatStore creates a store that keeps size messages per room
+func NewMemoryChatStore(size int) *MemoryChatStore {
+	return &MemoryChatStore{size: size, rooms: make(map[string]*chatRing)}
+}
+
+// AppendChat stores a message, dropping the room's oldest beyond the size
+func (s *MemoryChatStore) AppendChat(message ChatMessage) (ChatMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ring, found := s.rooms[message.RoomID]
+	if !found {
+		s.evictLocked()
+		ring = &chatRing{}
+		s.rooms[message.RoomID] = ring
+	}
+	ring.seq++
+	ring.updated = time.Now()
+	message.Seq = ring.seq
+	ring.messages = append(ring.messages, message)
+	if len(ring.messages) > s.size {
+		ring.messages = ring.messages[len(ring.messages)-s.size:]
+	}
+	return message, nil
+}
+
+// ChatHistory returns up to limit of a room's messages before seq
+func (s *MemoryChatStore) ChatHistory(roomID string, before int64, limit int) ([]ChatMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ring, found := s.rooms[roomID]
+	if !found {
+		return nil, nil
+	}
+	end := len(ring.messages)
+	for before > 0 && end > 0 && ring.messages[end-1].Seq >= before {
+		end--
+	}
+	start := max(end-limit, 0)
+	return append([]ChatMessage(nil), ring.messages[start:end]...), nil
+}
+
+// evictLocked forgets the least recently active room when the store is
+// full; the caller must hold the mutex
+func (s *MemoryChatStore) evictLocked() {
+	if len(s.rooms) < maxChatRooms {
+		return
+	}
+	var oldest string
+	for roomID, ring := range s.rooms {
+		if oldest == "" || ring.updated.Before(s.rooms[oldest].updated) {
+			oldest = roomID
+		}
+	}
+	delete(s.rooms, oldest)
+}
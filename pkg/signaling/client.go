@@ -1,15 +1,19 @@
 package signaling
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/nikhilsahni7/chat-video-app/pkg/util"
 )
 
-const (
+// Connection settings; see Configure
+var (
 	// Time allowed to write a message to the peer
 	writeWait = 10 * time.Second
 
@@ -19,105 +23,383 @@ const (
 	// Send pings to peer with this period
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer
-	maxMessageSize = 10000
+	// Maximum message size allowed from peer, unless its type has a limit
+	// of its own
+	maxMessageSize int64 = 10000
+
+	// Maximum number of signaling messages held for a client that isn't ready yet
+	maxPendingSignals = 200
+
+	// Messages a client may send per second on average, and in a burst
+	messageRate  = 20
+	messageBurst = 50
+
+	// How long a client that lost its connection keeps its place in the
+	// room for a new connection to resume it
+	resumeWindow = 30 * time.Second
+)
+
+const (
+	// Time a paused (backgrounded) client may stay silent before it is cleaned up
+	pausedPongWait = 5 * time.Minute
+
+	// Maximum number of messages coalesced into one frame
+	maxBatchSize = 32
+
+	// Close code sent to a connection that was replaced by a newer one
+	CloseReplaced = 4000
+
+	// Close code sent when the server refuses a join
+	CloseJoinDenied = 4001
+
+	// Close code sent when the room is shut down
+	CloseRoomEnded = 4002
+
+	// Close code sent when the user's access was revoked
+	CloseRevoked = 4003
+
+	// Close code sent to a client the host kicked
+	CloseKicked = 4004
+
+	// Close code sent to clients still connected when the server shuts down
+	CloseShutdown = 4005
+
+	// Close code sent to a client that kept sending over its rate limit
+	CloseRateLimited = 4006
 )
 
 // Client represents a connected WebRTC client
 type Client struct {
 	ID         string
+	UserID     string // Identity shared by all devices of one user, if known
+	DeviceID   string
+	Verified   bool // UserID comes from a signed identity or access token
 	Room       *Room
 	conn       *websocket.Conn
-	send       chan *Message
+	send       chan *Message // Signaling lane; see lanes.go
+	lanes      [laneCount]chan *Message
 	hub        *Hub
 	isHost     bool
+	maxRole    Role // Most the client may be in its room; empty means no limit
 	closedOnce sync.Once
 	closed     bool
 	mutex      sync.Mutex
+
+	// Closed when the current connection is given up, stopping its pumps
+	connDone chan struct{}
+
+	// Token a new connection presents to take over from this one, the
+	// timer that ends the client while it has no connection, and the
+	// messages kept for it until it resumes
+	resumeToken string
+	lost        *time.Timer
+	backlog     []*Message
+
+	// When the connection last showed signs of life, a frame or a pong, in
+	// Unix nanoseconds; see Hub.StartJanitor
+	lastSeen atomic.Int64
+
+	// Close frame sent to the peer when the server closes the connection
+	closeCode int
+	closeText string
+
+	// Why the client is leaving when no close code says so, e.g. its
+	// connection timed out
+	leaveReason string
+
+	// Set while a mobile client is in the background
+	paused bool
+
+	// Display name, avatar and metadata the client joined with
+	persona Persona
+
+	// Lifecycle state; relayed peer messages are held in pending until the
+	// client is ready
+	state   ClientState
+	pending []*Message
+
+	// How long writePump waits to coalesce queued messages into one frame; zero disables batching
+	batchWindow time.Duration
+
+	// When the client last reported a screen capture
+	lastCaptureReport time.Time
+
+	// Abilities granted on top of the role; nil gives the role's defaults
+	permissions []Permission
+
+	// Sidebar room the client negotiates media in while pulled aside
+	aside *Room
+
+	// Password the client joins with; cleared once it is checked
+	password string
+
+	// SFU session of a client in a room in SFU mode
+	mediaSession string
+
+	// IP address the client connected from, if known
+	address string
+
+	// Set while the client is in its room's waiting room, with the join
+	// message it sent there
+	waiting   bool
+	lobbyJoin *Message
+
+	// Rate limit of the messages the client sends; only readPump uses it
+	messageLimit messageLimit
+
+	// ICE transport the client's media ended up on, as it reported it
+	transport string
+}
+
+// ClientOptions carries optional identity information for a new client
+type ClientOptions struct {
+	// User the connection belongs to; devices of the same user are linked
+	UserID string
+
+	// Device the user is connecting from, e.g. "phone" or "laptop"
+	DeviceID string
+
+	// The user ID was taken from a signed identity or access token
+	Verified bool
+
+	// Most the client may be in the room, e.g. as decided by a join
+	// authorization webhook; empty means no limit
+	MaxRole Role
+
+	// What the client may do, e.g. as mapped from its identity token's
+	// claims; nil gives the defaults of its role
+	Permissions []Permission
+
+	// Window for coalescing queued messages into one frame; zero sends every message on its own
+	BatchWindow time.Duration
+
+	// Password of a password-protected room
+	Password string
+
+	// IP address the client connects from, which a ban covers
+	Address string
+
+	// How the client presents itself to the other participants; it must
+	// have been validated
+	Persona Persona
 }
 
-// NewClient creates a new client and starts its message handling
-func NewClient(id string, conn *websocket.Conn, hub *Hub, roomID string) *Client {
-	// Get or create the room
-	room := hub.GetRoom(roomID)
+// NewClient creates a new client and starts its message handling. If the ID
+// is already connected to the room, the room's duplicate join policy decides
+// whether the old connection is replaced, the new one gets a per-device ID, or
+// the join is rejected with ErrDuplicateClient. In a room with a waiting
+// room the client is returned waiting; the host's admission completes the
+// join
+func NewClient(id string, conn *websocket.Conn, hub *Hub, roomID string, opts ClientOptions) (*Client, error) {
+	client := newClient(id, conn, hub, opts)
+	err := client.join(roomID)
+	if err != nil && err != ErrWaitingRoom {
+		return nil, err
+	}
+
+	// Start goroutines for reading and writing
+	go client.readPump(conn)
+	go client.writePump(conn, client.connDone, nil)
+
+	if err == ErrWaitingRoom {
+		return client, nil
+	}
+	client.announceJoin()
+	client.connectMedia()
+	return client, nil
+}
 
-	// Create the client
+// newClient creates a client that isn't in a room yet
+func newClient(id string, conn *websocket.Conn, hub *Hub, opts ClientOptions) *Client {
 	client := &Client{
-		ID:     id,
-		Room:   room,
-		conn:   conn,
-		send:   make(chan *Message, 100),
-		hub:    hub,
-		isHost: false, // Default to non-host
+		ID:       id,
+		UserID:   opts.UserID,
+		DeviceID: opts.DeviceID,
+		Verified: opts.Verified,
+		maxRole:  opts.MaxRole,
+
+		permissions: opts.Permissions,
+
+		batchWindow: opts.BatchWindow,
+		password:    opts.Password,
+		address:     opts.Address,
+		persona:     opts.Persona,
+		conn:        conn,
+		connDone:    make(chan struct{}),
+		lanes:       newLanes(),
+		hub:         hub,
+		isHost:      false, // Default to non-host
+	}
+	client.send = client.lanes[laneSignaling]
+	return client
+}
+
+// join adds the client to a room, creating the room if needed
+func (c *Client) join(roomID string) error {
+	if c.hub.Draining() {
+		return ErrShuttingDown
+	}
+	room := c.hub.GetRoom(roomID)
+	c.Room = room
+	if err := room.checkPassword(c.password); err != nil {
+		return err
 	}
+	c.password = ""
 
-	// Add the client to the room
-	room.AddClient(client)
+	// If the room was closed after we looked it up, a fresh one is created
+	replaced, err := room.Join(c)
+	if err == ErrRoomClosed {
+		room = c.hub.GetRoom(roomID)
+		c.Room = room
+		replaced, err = room.Join(c)
+	}
 
-	// Start goroutines for reading and writing
-	go client.readPump()
-	go client.writePump()
+	// Latecomers to a full event room spill over into an overflow room
+	if err == ErrRoomFull && room.OverflowOf() == "" && room.Settings().Overflow != nil {
+		replaced, err = c.hub.joinOverflow(room, c)
+		room = c.Room
+	}
+	if err == ErrWaitingRoom && replaced != nil {
+		// An earlier connection of the client was waiting too
+		replaced.CloseWithReason(CloseReplaced, "replaced")
+	}
+	if err != nil {
+		return err
+	}
+	if replaced != nil {
+		replaced.CloseWithReason(CloseReplaced, "replaced")
+	}
+	room.traceClient(TraceJoin, c)
+
+	c.mutex.Lock()
+	c.transitionLocked(StateJoined)
+	c.mutex.Unlock()
+	return nil
+}
 
-	// Send a welcome message to the client
-	client.Send(&Message{
+// announceJoin welcomes a client that just joined its room and tells the
+// other participants about it
+func (c *Client) announceJoin() {
+	room := c.Room
+	id := c.ID
+
+	// Send a welcome message to the client, including the media limits of
+	// the room's profile
+	settings, version := room.VersionedSettings()
+	profile := settings.Profile
+	welcome := &Message{
 		Type: "welcome",
 		To:   id,
 		Data: map[string]interface{}{
-			"roomId":   roomID,
-			"clientId": id,
-			"isHost":   client.isHost,
+			"roomId":           room.ID,
+			"clientId":         id,
+			"isHost":           c.IsHost(),
+			"role":             c.Role(),
+			"canRecord":        c.may(PermRecord),
+			"verified":         c.Verified,
+			"profile":          profile,
+			"mediaConstraints": profile.MediaConstraints(),
+			"settingsVersion":  version,
+			"mode":             settings.MediaMode(),
+			"resumeToken":      c.issueResumeToken(),
 		},
-	})
-
-	// Send the current user list to this client
-	currentClients := room.GetClients()
-	userList := make([]string, 0, len(currentClients))
-	for _, c := range currentClients {
-		if c.ID != id { // Don't include self in the list
-			userList = append(userList, c.ID)
+	}
+	c.Persona().addTo(welcome.Data)
+	if control := room.MediaControlOf(id); control != (MediaControl{}) {
+		// A reconnecting participant the host turned off stays off
+		welcome.Data["serverMuted"] = control.AudioMuted
+		welcome.Data["videoStopped"] = control.VideoStopped
+	}
+	if channels := room.CaptionChannels(); channels != nil {
+		welcome.Data["captionChannels"] = channels
+	}
+	config := c.configData()
+	if iceServers, found := config["iceServers"]; found {
+		welcome.Data["iceServers"] = iceServers
+	}
+	if config["version"] != 0 {
+		welcome.Data["config"] = config
+	}
+	if mainRoomID := room.OverflowOf(); mainRoomID != "" {
+		welcome.Data["overflowOf"] = mainRoomID
+	}
+	if policy := room.Compliance(); policy != nil {
+		welcome.Data["compliance"] = map[string]interface{}{
+			"recording":        true,
+			"blockPrivateChat": policy.BlockPrivateChat,
 		}
 	}
+	c.Send(welcome)
+	if room.Settings().Watermark != nil {
+		c.Send(room.watermarkMessage(c))
+	}
 
 	// Send user list even if empty so the client knows there are no other users
-	client.Send(&Message{
-		Type: "user-list",
-		To:   id,
-		Data: map[string]interface{}{
-			"users": userList,
-		},
-	})
+	currentClients := room.GetClients()
+	c.sendUserList()
+	c.Send(room.stateMessage(id))
+	if c.IsHost() {
+		room.sendAdmissionRequests(c)
+	}
+	room.sendCustomHistory(c)
+	room.sendChatHistory(c)
 
 	// Notify other clients that a new client has joined
 	joinMessage := &Message{
 		Type: "user-joined",
 		From: id,
 		Data: map[string]interface{}{
-			"clientId": id,
-			"isHost":   client.isHost,
+			"clientId":   id,
+			"isHost":     c.IsHost(),
+			"accountId":  c.userKey(),
+			"deviceId":   c.DeviceID,
+			"verified":   c.Verified,
+			"publishing": room.Publisher(c.userKey()) == id,
 		},
 	}
+	c.Persona().addTo(joinMessage.Data)
 
 	// Broadcast to all room participants
 	util.Info("Broadcasting user-joined message for client %s to %d other clients", id, len(currentClients)-1)
 	room.Broadcast(joinMessage, id) // Don't send to self
 
 	// Log clients in room after join
-	util.Info("Room %s now has %d clients", roomID, len(room.GetClients()))
+	util.Info("Room %s now has %d clients", room.ID, len(room.GetClients()))
 
-	return client
+	room.startCompliance(c)
 }
 
-// SetHost sets the host status for this client
-func (c *Client) SetHost(isHost bool) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// sendUserList sends the client the other participants in its room, both as
+// a flat list of client IDs, including those on other nodes, and grouped by
+// this node's users
+func (c *Client) sendUserList() {
+	userList := []string{}
+	for _, client := range c.Room.GetClients() {
+		if client.ID != c.ID { // Don't include self in the list
+			userList = append(userList, client.ID)
+		}
+	}
+	// Clients on other nodes can be negotiated with through the backplane
+	userList = append(userList, c.Room.remoteClientIDs()...)
 
-	if c.isHost == isHost {
+	util.Debug("Sending user list to client %s: %v", c.ID, userList)
+	c.Send(&Message{
+		Type: "user-list",
+		To:   c.ID,
+		Data: map[string]interface{}{
+			"users":        userList,
+			"participants": c.Room.Participants(),
+		},
+	})
+}
+
+// SetHost sets the host status for this client and tells the client about
+// it. Room-wide host-change notifications are sent by the room
+func (c *Client) SetHost(isHost bool) {
+	if !c.setHostFlag(isHost) {
 		return // No change needed
 	}
 
-	c.isHost = isHost
-
 	// Notify the client about their host status
 	c.Send(&Message{
 		Type: "host-status",
@@ -126,71 +408,268 @@ func (c *Client) SetHost(isHost bool) {
 			"isHost": isHost,
 		},
 	})
+}
 
-	// Notify other clients about the host change
-	c.Room.Broadcast(&Message{
-		Type: "host-change",
-		From: c.ID,
-		Data: map[string]interface{}{
-			"hostId": c.ID,
-			"isHost": isHost,
-		},
-	}, c.ID) // Don't send to self
+// setHostFlag updates the host flag without notifying anyone and reports
+// whether it changed
+func (c *Client) setHostFlag(isHost bool) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.isHost == isHost {
+		return false
+	}
+	c.isHost = isHost
+	return true
+}
+
+// setPaused records whether the client is in the background
+func (c *Client) setPaused(paused bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.paused != paused {
+		util.Info("Client %s paused=%v in room %s", c.ID, paused, c.Room.ID)
+	}
+	c.paused = paused
+}
+
+// IsPaused reports whether the client is in the background
+func (c *Client) IsPaused() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.paused
+}
+
+// readWait returns how long the client may stay silent before its
+// connection is considered dead. Paused clients get a longer grace period
+// since backgrounded mobile apps often can't answer pings
+func (c *Client) readWait() time.Duration {
+	if c.IsPaused() {
+		return pausedPongWait
+	}
+	return c.profile().params().pongWait
+}
+
+// profile returns the profile of the client's room
+func (c *Client) profile() RoomProfile {
+	if c.Room == nil {
+		return ProfileStandard
+	}
+	return c.Room.Settings().Profile
+}
+
+// IsHost reports whether this client is the room host
+func (c *Client) IsHost() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.isHost
 }
 
-// Send sends a message to the client
+// Send sends a message to the client. Relayed peer messages for a client that
+// isn't ready yet are held back and delivered by MarkReady, and messages for
+// a client that lost its connection wait for it to resume
 func (c *Client) Send(message *Message) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	if c.closed {
+		c.traceDelivery(message, DeliveryDropped, "client closed")
+		return
+	}
+	if c.lost != nil {
+		c.backlogLocked(message)
+		return
+	}
+	c.deliverLocked(message)
+}
+
+// deliverLocked queues a message, or holds it until the client is ready;
+// the caller must hold the mutex
+func (c *Client) deliverLocked(message *Message) {
+	if c.state < StateReady && isRelayed(message.Type) {
+		if len(c.pending) >= maxPendingSignals {
+			util.Warn("Pending message buffer full for client %s, dropping %s", c.ID, message.Type)
+			c.traceDelivery(message, DeliveryDropped, "pending buffer full")
+			c.countDropped()
+			return
+		}
+		c.pending = append(c.pending, message)
+		c.traceDelivery(message, DeliveryHeld, "")
+		util.Debug("Holding %s for client %s until it is ready", message.Type, c.ID)
+		return
+	}
+
+	c.sendLocked(message)
+}
+
+// MarkReady records that the client has created its peer connections and
+// delivers any negotiation messages that arrived before that
+func (c *Client) MarkReady() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.state == StateReady {
+		return
+	}
+	if err := c.transitionLocked(StateReady); err != nil {
+		util.Warn("Ignoring ready from client %s: %v", c.ID, err)
 		return
 	}
 
+	if len(c.pending) > 0 {
+		util.Info("Client %s is ready, delivering %d held messages", c.ID, len(c.pending))
+	}
+	// Flushing under the lock keeps held messages ahead of newer ones
+	for _, message := range c.pending {
+		if c.closed {
+			break
+		}
+		c.sendLocked(message)
+	}
+	c.pending = nil
+}
+
+// sendLocked queues a message on its lane; the caller must hold the mutex
+func (c *Client) sendLocked(message *Message) {
+	l := laneFor(message.Type)
 	select {
-	case c.send <- message:
+	case c.queue(l) <- message:
+		c.traceDelivery(message, DeliveryQueued, "")
+		c.countRelayed(message.Type)
 	default:
-		// Buffer full, close connection
+		c.traceDelivery(message, DeliveryDropped, "send queue full")
+		c.countDropped()
+		if l != laneSignaling {
+			// Lower lanes shed load instead of dropping the connection
+			util.Warn("Send lane %d full for client %s, dropping %s message", l, c.ID, message.Type)
+			return
+		}
+
+		// Buffer full, close connection. Close takes the mutex itself and
+		// broadcasts to the room, so it can't run from inside Send
 		util.Warn("Message buffer full for client %s, closing connection", c.ID)
+		c.leaveReason = LeaveSlowConnection
+		go c.Close()
+	}
+}
+
+// dismiss sends the client a last message and closes the connection with
+// the given close code and reason once the message is written, or after
+// writeWait when it can't be
+func (c *Client) dismiss(code int, reason string, farewell *Message) {
+	c.mutex.Lock()
+	if c.closeCode == 0 {
+		c.closeCode = code
+		c.closeText = reason
+	}
+	conn := c.conn
+	c.mutex.Unlock()
+
+	farewell.final = true
+	c.Send(farewell)
+	if conn == nil {
 		c.Close()
+		return
+	}
+	time.AfterFunc(writeWait, c.Close)
+}
+
+// CloseWithReason closes the client connection, sending the given close code
+// and reason to the peer
+func (c *Client) CloseWithReason(code int, reason string) {
+	c.mutex.Lock()
+	if c.closeCode == 0 {
+		c.closeCode = code
+		c.closeText = reason
 	}
+	c.mutex.Unlock()
+
+	c.Close()
 }
 
 // Close closes the client connection
 func (c *Client) Close() {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
 	if c.closed {
+		c.mutex.Unlock()
 		return
 	}
-
 	c.closed = true
+	waiting := c.waiting
+	c.transitionLocked(StateLeaving)
+	if c.lost != nil {
+		c.lost.Stop()
+		c.lost = nil
+	}
+	resumeToken := c.resumeToken
+	reason := c.leaveReasonLocked()
 
-	// Notify other clients in the room about the disconnection
-	if c.Room != nil {
+	// Close channels and connection
+	if c.send != nil {
+		close(c.send)
+	}
+	for l := laneModeration; l < laneCount; l++ {
+		if c.lanes[l] != nil {
+			close(c.lanes[l])
+		}
+	}
+	if c.conn != nil {
+		if c.closeCode != 0 {
+			closeMsg := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+			c.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(writeWait))
+		}
+		c.conn.Close()
+	}
+
+	// The room may need to lock other clients (e.g. when handing over host
+	// status), so the client mutex is released before touching the room
+	c.mutex.Unlock()
+
+	if resumeToken != "" && c.hub != nil {
+		c.hub.resumes.forget(resumeToken)
+	}
+
+	if c.Room != nil && waiting {
+		// Clients in the waiting room never joined, so nobody is told
+		c.Room.leaveLobby(c)
+		if c.Room.IsEmpty() && c.hub != nil {
+			c.hub.RemoveRoom(c.Room.ID)
+		}
+	} else if c.Room != nil {
+		c.Room.traceClient(TraceLeave, c)
+
+		// Notify other clients in the room about the disconnection
 		util.Info("Sending user-left message for client %s in room %s", c.ID, c.Room.ID)
 		leaveMsg := &Message{
 			Type: "user-left",
 			From: c.ID,
 			Data: map[string]interface{}{
 				"userId": c.ID,
+				"reason": reason,
 			},
 		}
-		// Don't exclude the current client as we're closing the connection anyway
 		c.Room.Broadcast(leaveMsg, "")
-	}
+		c.announceConnectionState(ConnectionLeft, time.Time{})
 
-	// Close channels and connection
-	close(c.send)
-	c.conn.Close()
+		// A sidebar ends when either participant disconnects
+		if sidebar := c.asideRoom(); sidebar != nil && c.hub != nil {
+			c.hub.endSidebar(sidebar)
+		}
 
-	// Remove client from room
-	if c.Room != nil {
-		c.Room.RemoveClient(c.ID)
+		// Calls still ringing from this connection are cancelled
+		if c.hub != nil {
+			c.hub.cancelCallsOf(c)
+		}
+
+		// Remove client from room
+		c.Room.removeConnection(c)
+		c.Room.endRecordingsOf(c.ID)
+		c.disconnectMedia()
+		c.Room.unsubscribeCaptions(c.ID)
 
 		// Check if room is empty and remove it
-		if c.Room.IsEmpty() {
+		if c.Room.IsEmpty() && c.hub != nil {
 			c.hub.RemoveRoom(c.Room.ID)
 		}
 	}
@@ -199,135 +678,665 @@ func (c *Client) Close() {
 }
 
 // readPump pumps messages from the websocket to the hub
-func (c *Client) readPump() {
-	defer c.Close()
+func (c *Client) readPump(conn *websocket.Conn) {
+	// Clients that close the connection themselves are leaving
+	reason := LeaveNetworkError
+	defer func() { c.connectionLost(conn, reason) }()
 
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetReadLimit(maxFrameSize)
+	conn.SetReadDeadline(time.Now().Add(c.readWait()))
+	c.lastSeen.Store(time.Now().UnixNano())
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(c.readWait()))
+		c.lastSeen.Store(time.Now().UnixNano())
 		return nil
 	})
 
+	codec := CodecFor(conn)
 	for {
-		_, rawMsg, err := c.conn.ReadMessage()
+		frameType, rawMsg, err := conn.ReadMessage()
+		if err == nil {
+			c.lastSeen.Store(time.Now().UnixNano())
+		}
 		if err != nil {
+			left := websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway)
+			reason = connectionLeaveReason(left, err)
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				util.Error("WebSocket read error for client %s: %v", c.ID, err)
+				c.countWebSocketError(WebSocketRead)
 			} else {
 				util.Debug("WebSocket connection closed for client %s: %v", c.ID, err)
 			}
 			break
 		}
-
-		var msg Message
-		if err := json.Unmarshal(rawMsg, &msg); err != nil {
+		var messages []*Message
+		if frameType == websocket.BinaryMessage && isAppFrame(rawMsg) {
+			var msg *Message
+			msg, err = decodeAppFrame(rawMsg)
+			messages = []*Message{msg}
+		} else {
+			messages, err = codec.Decode(rawMsg)
+			for _, msg := range messages {
+				// Application data only comes in binary frames
+				msg.Payload = nil
+			}
+		}
+		var sizes []int
+		if err == nil {
+			sizes, err = messageSizes(codec, rawMsg, messages)
+		}
+		if err != nil {
+			if !c.allowMessage(time.Now()) {
+				continue
+			}
 			util.Error("Error parsing message from client %s: %v", c.ID, err)
+			c.countWebSocketError(WebSocketMalformed)
+			c.sendError(nil, ErrorMalformed, err)
 			continue
 		}
 
-		// Set the sender ID
-		msg.From = c.ID
-
-		// Handle the message based on its type
-		switch msg.Type {
-		case "offer", "answer", "ice-candidate":
-			// For WebRTC signaling, broadcast to the room
-			util.Debug("Received %s from client %s to %s", msg.Type, c.ID, msg.To)
-
-			// If the message has a specific recipient, send only to that recipient
-			if msg.To != "" {
-				// Find the recipient client
-				recipientFound := false
-				for _, client := range c.Room.GetClients() {
-					if client.ID == msg.To {
-						client.Send(&msg)
-						recipientFound = true
-						util.Debug("Sent direct %s from %s to %s", msg.Type, c.ID, msg.To)
-						break
-					}
-				}
-				if !recipientFound {
-					util.Warn("Recipient %s not found for %s from %s", msg.To, msg.Type, c.ID)
-				}
-			} else {
-				// If no specific recipient, broadcast to all in the room (except sender)
-				c.Room.Broadcast(&msg, c.ID)
+		// Binary frames may hold several messages, each counted against
+		// the rate limit
+		for i, msg := range messages {
+			if !c.allowMessage(time.Now()) {
+				continue
 			}
-		case "chat":
-			// For chat messages, broadcast to the room
-			util.Debug("Received chat message from client %s", c.ID)
-			c.Room.Broadcast(&msg, "")
-		case "join":
-			// Client joining, notify others in the room
-			util.Info("Client %s joining room %s", c.ID, c.Room.ID)
-			joinMsg := &Message{
-				Type: "user-joined",
-				From: c.ID,
-				To:   "",
-				Data: map[string]interface{}{
-					"userId": c.ID,
-				},
+			if err := checkSize(msg, sizes[i]); err != nil {
+				util.WarnContext(c.logContext(), "Rejected %s from client %s: %v", msg.Type, c.ID, err)
+				c.sendError(msg, ErrorTooLarge, err)
+				continue
 			}
-			c.Room.Broadcast(joinMsg, c.ID)
-
-			// Send list of existing users to the new client
-			userList := []string{}
-			for _, client := range c.Room.GetClients() {
-				if client.ID != c.ID {
-					userList = append(userList, client.ID)
-				}
+			if err := validateMessage(msg); err != nil {
+				util.WarnContext(c.logContext(), "Rejected %s from client %s: %v", msg.Type, c.ID, err)
+				c.reject(msg, err)
+				continue
 			}
 
-			util.Debug("Sending user list to client %s: %v", c.ID, userList)
+			// Set the sender ID
+			msg.From = c.ID
+
+			c.handleMessage(msg)
+		}
+	}
+}
+
+// logContext returns a context whose log lines name the client, its room
+// and the room's tenant
+func (c *Client) logContext() context.Context {
+	return util.WithClient(c.Room.LogContext(context.Background()), c.ID)
+}
+
+// handleMessage routes one message received from the client
+func (c *Client) handleMessage(msg *Message) {
+	c.Room.traceMessage(c, msg)
+	c.traceDelivery(msg, DeliveryReceived, "")
+	c.Room.counters.received.Add(1)
+
+	if c.holdWhileWaiting(msg) {
+		return
+	}
+	if err := c.checkRole(msg.Type); err != nil {
+		util.WarnContext(c.logContext(), "Rejected %s from client %s: %v", msg.Type, c.ID, err)
+		c.Send(&Message{
+			Type: "not-permitted",
+			Data: map[string]interface{}{"type": msg.Type, "reason": err.Error()},
+		})
+		c.reject(msg, err)
+		return
+	}
+
+	// Handle the message based on its type
+	switch msg.Type {
+	case "offer", "answer", "ice-candidate":
+		// For WebRTC signaling, broadcast to the room
+		util.DebugContext(c.logContext(), "Received %s from client %s to %s", msg.Type, c.ID, msg.To)
+
+		// Clients pulled aside negotiate only within their sidebar
+		room := c.signalingRoom()
+
+		// Mesh calls address each peer connection's signals to that peer
+		// alone; only messages without a recipient go to the whole room
+		if msg.To == "" {
+			room.Broadcast(msg, c.ID)
+			break
+		}
+		if err := room.SendTo(msg); err != nil {
+			util.WarnContext(c.logContext(), "Recipient %s not found for %s from %s", msg.To, msg.Type, c.ID)
+			c.Send(&Message{
+				Type: "recipient-not-found",
+				Data: map[string]interface{}{"type": msg.Type, "to": msg.To},
+			})
+			c.reject(msg, err)
+			break
+		}
+		util.DebugContext(c.logContext(), "Sent direct %s from %s to %s", msg.Type, c.ID, msg.To)
+	case "sfu-answer":
+		// Answer to the SFU's offer in a room in SFU mode
+		sdp, _ := msg.Data["sdp"].(string)
+		if err := c.answerMedia(sdp); err != nil {
+			util.WarnContext(c.logContext(), "Rejected sfu-answer from client %s: %v", c.ID, err)
+			c.Send(&Message{Type: "sfu-error", To: c.ID, Data: map[string]interface{}{"reason": err.Error()}})
+			c.reject(msg, err)
+		}
+	case "dtmf":
+		// Keypad tones for phone menus, relayed within the signaling room
+		if err := c.signalingRoom().RelayDTMF(c, msg); err != nil {
+			util.WarnContext(c.logContext(), "Rejected dtmf from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "reaction", "stats":
+		// Non-critical updates; low-power rooms deliver these in digests
+		if msg.Type == "stats" {
+			c.Room.recordStats(c.ID, msg)
+		}
+		c.Room.Broadcast(msg, c.ID)
+	case "chat":
+		// For chat messages, broadcast to the room
+		util.DebugContext(c.logContext(), "Received chat message from client %s", c.ID)
+		if err := c.Room.checkChat(c, msg); err != nil {
+			util.WarnContext(c.logContext(), "Rejected chat from client %s: %v", c.ID, err)
+			c.Send(&Message{
+				Type: "chat-rejected",
+				Data: map[string]interface{}{"to": msg.To, "reason": err.Error()},
+			})
+			c.reject(msg, err)
+			break
+		}
+		c.Room.broadcastChat(msg)
+		c.emitChat(msg)
+	case "join":
+		// Client joining, notify others in the room
+		util.InfoContext(c.logContext(), "Client %s joining room %s", c.ID, c.Room.ID)
+
+		// The join message may set how the client presents itself
+		if err := c.updatePersona(msg.Data); err != nil {
+			util.WarnContext(c.logContext(), "Rejected persona of client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+		joinMsg := &Message{
+			Type: "user-joined",
+			From: c.ID,
+			To:   "",
+			Data: map[string]interface{}{
+				"userId": c.ID,
+			},
+		}
+		c.Persona().addTo(joinMsg.Data)
+		c.Room.Broadcast(joinMsg, c.ID)
+
+		// Send list of existing users to the new client
+		c.sendUserList()
+
+		// Clients that set deferReady send a separate "ready" once their
+		// peer connection exists; everyone else is ready on join
+		if deferReady, _ := msg.Data["deferReady"].(bool); !deferReady {
+			c.MarkReady()
+		}
+	case "ready":
+		util.DebugContext(c.logContext(), "Client %s is ready for negotiation", c.ID)
+		c.MarkReady()
+	case "client-paused", "client-resumed":
+		// Mobile app moved to the background or back to the foreground
+		c.setPaused(msg.Type == "client-paused")
+		if conn := c.connection(); conn != nil {
+			conn.SetReadDeadline(time.Now().Add(c.readWait()))
+		}
+		c.Room.Broadcast(&Message{
+			Type: msg.Type,
+			From: c.ID,
+			Data: map[string]interface{}{
+				"clientId": c.ID,
+			},
+		}, c.ID)
+	case "client-error":
+		// Browser-side failure report; kept for operators, not relayed
+		report := clientErrorFromMessage(msg)
+		c.Room.ReportClientError(report)
+		if normalizeClientErrorKind(report.Kind) == ClientErrorICE {
+			c.handleICEFailure(report.PeerID)
+		}
+	case "ice-transport":
+		// Which transport ICE settled on, for operators; not relayed
+		if err := c.reportTransport(msg); err != nil {
+			util.WarnContext(c.logContext(), "Rejected ice-transport from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "switch-device":
+		// Move media publishing to another device of the same user
+		target, _ := msg.Data["clientId"].(string)
+		if err := c.Room.SwitchDevice(c, target); err != nil {
+			util.WarnContext(c.logContext(), "Rejected switch-device from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "start-sidebar":
+		// Host pulls a participant aside for a private call
+		clientID, _ := msg.Data["clientId"].(string)
+		if _, err := c.Room.StartSidebar(c, clientID); err != nil {
+			util.WarnContext(c.logContext(), "Rejected start-sidebar from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "end-sidebar":
+		if err := c.EndSidebar(); err != nil {
+			util.WarnContext(c.logContext(), "Rejected end-sidebar from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "call":
+		// Ring another user on all of their connections
+		userID, _ := msg.Data["userId"].(string)
+		if _, err := c.PlaceCall(userID); err != nil {
+			util.WarnContext(c.logContext(), "Rejected call from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "call-accept", "call-decline":
+		callID, _ := msg.Data["callId"].(string)
+		if _, err := c.AnswerCall(callID, msg.Type == "call-accept"); err != nil {
+			util.WarnContext(c.logContext(), "Rejected %s from client %s: %v", msg.Type, c.ID, err)
+			c.reject(msg, err)
+		}
+	case "call-hangup":
+		callID, _ := msg.Data["callId"].(string)
+		if _, err := c.HangUp(callID); err != nil {
+			util.WarnContext(c.logContext(), "Rejected call-hangup from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "transfer-call":
+		// Hand the other party over to another user, blind or attended
+		callID, _ := msg.Data["callId"].(string)
+		userID, _ := msg.Data["userId"].(string)
+		mode, _ := msg.Data["mode"].(string)
+		if mode != "" && mode != "blind" && mode != "attended" {
+			util.WarnContext(c.logContext(), "Rejected transfer-call from client %s: unknown mode %q", c.ID, mode)
+			c.reject(msg, fmt.Errorf("unknown transfer mode %q", mode))
+			break
+		}
+		if _, err := c.TransferCall(callID, userID, mode == "attended"); err != nil {
+			util.WarnContext(c.logContext(), "Rejected transfer-call from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "hold", "resume":
+		callID, _ := msg.Data["callId"].(string)
+		if _, err := c.HoldCall(callID, msg.Type == "hold"); err != nil {
+			util.WarnContext(c.logContext(), "Rejected %s from client %s: %v", msg.Type, c.ID, err)
+			c.reject(msg, err)
+		}
+	case "set-status":
+		// Available or do not disturb, for incoming calls
+		status, _ := msg.Data["status"].(string)
+		if err := c.SetPresence(status); err != nil {
+			util.WarnContext(c.logContext(), "Rejected set-status from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "speaking":
+		// Voice activity detected by the client's browser, for talk-time analytics
+		speaking, _ := msg.Data["speaking"].(bool)
+		c.Room.ReportSpeaking(c.ID, speaking)
+	case "caption":
+		// Speech transcribed by the speaker's own browser
+		text, _ := msg.Data["text"].(string)
+		lang, _ := msg.Data["lang"].(string)
+		final, _ := msg.Data["final"].(bool)
+		caption := Caption{Speaker: c.ID, Text: text, Lang: lang, Final: final}
+		if err := c.Room.PublishCaption(caption); err != nil {
+			util.DebugContext(c.logContext(), "Dropped caption from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "caption-subscribe":
+		channel, _ := msg.Data["channel"].(string)
+		if err := c.Room.SubscribeCaptions(c.ID, channel); err != nil {
+			util.WarnContext(c.logContext(), "Rejected caption-subscribe from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+			break
+		}
+		c.Send(&Message{
+			Type: "caption-subscribed",
+			Data: map[string]interface{}{
+				"channel":  channel,
+				"channels": c.Room.CaptionChannels(),
+			},
+		})
+	case "screen-capture-detected":
+		kind, _ := msg.Data["kind"].(string)
+		detail, _ := msg.Data["detail"].(string)
+		if err := c.Room.ReportScreenCapture(c, kind, detail); err != nil {
+			util.WarnContext(c.logContext(), "Rejected screen-capture-detected from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "set-keywords":
+		// Host picks the words they want to be alerted about
+		var keywords []string
+		list, _ := msg.Data["keywords"].([]interface{})
+		for _, keyword := range list {
+			if keyword, ok := keyword.(string); ok {
+				keywords = append(keywords, keyword)
+			}
+		}
+		// An optional settings version guards against overwriting a
+		// co-host's or dashboard's change the client hasn't seen
+		version, _ := msg.Data["version"].(float64)
+		keywords, current, err := c.Room.SetKeywordsIf(c, int64(version), keywords)
+		if errors.Is(err, ErrSettingsConflict) {
+			settings, current := c.Room.VersionedSettings()
 			c.Send(&Message{
-				Type: "user-list",
-				To:   c.ID,
+				Type: "settings-conflict",
+				Data: map[string]interface{}{"request": "set-keywords", "settings": settings, "version": current},
+			})
+			c.reject(msg, err)
+			break
+		}
+		if err != nil {
+			util.WarnContext(c.logContext(), "Rejected set-keywords from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+			break
+		}
+		c.Send(&Message{
+			Type: "keywords-set",
+			Data: map[string]interface{}{"keywords": keywords, "version": current},
+		})
+	case "record-participant":
+		// Host asks a participant to record their own tracks
+		clientID, _ := msg.Data["clientId"].(string)
+		tracks, _ := msg.Data["tracks"].(string)
+		if _, err := c.Room.RequestRecording(c, clientID, tracks); err != nil {
+			util.WarnContext(c.logContext(), "Rejected record-participant from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "recording-consent":
+		recordingID, _ := msg.Data["recordingId"].(string)
+		accepted, _ := msg.Data["accepted"].(bool)
+		recording, err := c.Room.AnswerRecordingConsent(c, recordingID, accepted)
+		if err != nil {
+			util.WarnContext(c.logContext(), "Rejected recording-consent from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		} else if accepted {
+			c.hub.emit(Event{
+				Type:     EventRecordingStarted,
+				RoomID:   c.Room.ID,
+				ClientID: c.ID,
 				Data: map[string]interface{}{
-					"users": userList,
+					"recordingId": recording.ID,
+					"kind":        recording.Kind,
+					"tracks":      recording.Tracks,
 				},
 			})
-		default:
-			util.Warn("Received unknown message type '%s' from client %s", msg.Type, c.ID)
 		}
+	case "start-recording":
+		// Host has the server record the room's media
+		if _, err := c.Room.StartCallRecording(c); err != nil {
+			util.WarnContext(c.logContext(), "Rejected start-recording from client %s: %v", c.ID, err)
+			c.Send(&Message{Type: "recording-rejected", To: c.ID, Data: map[string]interface{}{"reason": err.Error()}})
+			c.reject(msg, err)
+		}
+	case "stop-recording":
+		recordingID, _ := msg.Data["recordingId"].(string)
+		if recordingID == "" {
+			recordingID = c.Room.activeCallRecording()
+		}
+		if _, err := c.Room.StopRecording(c, recordingID); err != nil {
+			util.WarnContext(c.logContext(), "Rejected stop-recording from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "stop-participant-recording":
+		recordingID, _ := msg.Data["recordingId"].(string)
+		if _, err := c.Room.StopRecording(c, recordingID); err != nil {
+			util.WarnContext(c.logContext(), "Rejected stop-participant-recording from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "admit":
+		// Host lets a client in from the waiting room
+		clientID, _ := msg.Data["clientId"].(string)
+		if err := c.Room.Admit(c, clientID); err != nil {
+			util.WarnContext(c.logContext(), "Rejected admit from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "deny":
+		clientID, _ := msg.Data["clientId"].(string)
+		reason, _ := msg.Data["reason"].(string)
+		if err := c.Room.Deny(c, clientID, reason); err != nil {
+			util.WarnContext(c.logContext(), "Rejected deny from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "mute-user", "stop-video-user":
+		// Host turns off a participant's microphone or camera
+		clientID, _ := msg.Data["clientId"].(string)
+		if err := c.Room.ControlMedia(c, msg.Type, clientID); err != nil {
+			util.WarnContext(c.logContext(), "Rejected %s from client %s: %v", msg.Type, c.ID, err)
+			c.reject(msg, err)
+		}
+	case "mute-all":
+		if err := c.Room.MuteAll(c); err != nil {
+			util.WarnContext(c.logContext(), "Rejected mute-all from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "unmute-self":
+		// A participant turned back on what the host turned off
+		kind, _ := msg.Data["kind"].(string)
+		if err := c.unmuteSelf(kind); err != nil {
+			util.WarnContext(c.logContext(), "Rejected unmute-self from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case AppDataType:
+		// Binary payloads of the application, e.g. whiteboard strokes
+		if err := c.relayAppData(msg); err != nil {
+			util.WarnContext(c.logContext(), "Rejected %s from client %s: %v", msg.Type, c.ID, err)
+			c.reject(msg, err)
+		}
+	case "media-state":
+		// A participant muted, turned its camera off or shared its screen
+		state := MediaState{}
+		state.AudioMuted, _ = msg.Data["audioMuted"].(bool)
+		state.VideoOff, _ = msg.Data["videoOff"].(bool)
+		state.ScreenSharing, _ = msg.Data["screenSharing"].(bool)
+		c.Room.SetMediaState(c, state)
+	case "raise-hand":
+		c.Room.RaiseHand(c)
+	case "lower-hand":
+		// Participants lower their own hand, the host anyone's
+		clientID, _ := msg.Data["clientId"].(string)
+		if err := c.Room.LowerHand(c, clientID); err != nil {
+			util.WarnContext(c.logContext(), "Rejected lower-hand from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case "pin":
+		// The host features a participant in everyone's layout
+		clientID, _ := msg.Data["clientId"].(string)
+		if err := c.Room.Pin(c, clientID); err != nil {
+			util.WarnContext(c.logContext(), "Rejected pin from client %s: %v", c.ID, err)
+			c.reject(msg, err)
+		}
+	case CommandKick, CommandBan, CommandEndMeeting, CommandLock, CommandUnlock:
+		// Host moderation, applied once on every node of the room
+		commandID, _ := msg.Data["commandId"].(string)
+		clientID, _ := msg.Data["clientId"].(string)
+		reason, _ := msg.Data["reason"].(string)
+		command := ModerationCommand{ID: commandID, Command: msg.Type, ClientID: clientID, Reason: reason}
+		if err := c.Room.Moderate(c, command); err != nil {
+			util.WarnContext(c.logContext(), "Rejected %s from client %s: %v", msg.Type, c.ID, err)
+			c.Send(&Message{
+				Type: "moderation-rejected",
+				Data: map[string]interface{}{"commandId": commandID, "command": msg.Type, "reason": err.Error()},
+			})
+			c.reject(msg, err)
+		}
+	default:
+		if c.handleCustom(msg) {
+			break
+		}
+		if isCustomEvent(msg.Type) {
+			// App-defined events, governed by the room's rules
+			if err := c.handleCustomEvent(msg); err != nil {
+				util.WarnContext(c.logContext(), "Rejected %s from client %s: %v", msg.Type, c.ID, err)
+				c.reject(msg, err)
+			}
+			break
+		}
+		util.WarnContext(c.logContext(), "Received unknown message type '%s' from client %s", msg.Type, c.ID)
+		c.sendError(msg, ErrorUnknownType, fmt.Errorf("unknown message type %q", msg.Type))
 	}
 }
 
 // writePump pumps messages from the hub to the websocket connection
-func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+func (c *Client) writePump(conn *websocket.Conn, done <-chan struct{}, first []*Message) {
+	ticker := time.NewTicker(c.profile().params().pingPeriod)
+	// A farewell message ends the client once it is written; otherwise
+	// the pump only stops early when a write fails
+	reason := LeaveNetworkError
 	defer func() {
 		ticker.Stop()
-		c.Close()
+		c.connectionLost(conn, reason)
 	}()
 
+	// A resumed client's backlog is written before its queues
+	for len(first) > 0 {
+		n := 1
+		if c.batchWindow > 0 {
+			n = min(len(first), maxBatchSize)
+		}
+		if err := c.writeBatch(conn, first[:n]); err != nil {
+			util.Warn("Error writing backlog to websocket for client %s: %v", c.ID, err)
+			c.countWebSocketError(WebSocketWrite)
+			reason = connectionLeaveReason(false, err)
+			for _, msg := range first {
+				c.traceDelivery(msg, DeliveryDropped, "write failed")
+			}
+			return
+		}
+		for _, msg := range first[:n] {
+			c.traceDelivery(msg, DeliveryWritten, "")
+		}
+		first = first[n:]
+	}
+
 	for {
+		// Keep pinging even while the queue never runs dry
 		select {
-		case msg, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel
-				util.Debug("Send channel closed for client %s", c.ID)
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case <-ticker.C:
+			if err := c.writePing(conn); err != nil {
+				reason = connectionLeaveReason(false, err)
 				return
 			}
+		case <-done:
+			// The connection was given up; queued messages wait for the
+			// one that resumes the client
+			return
+		default:
+		}
 
-			data, err := json.Marshal(msg)
-			if err != nil {
-				util.Error("Error marshaling message for client %s: %v", c.ID, err)
+		// Drain queued messages in priority order before blocking
+		msg, ok, found := c.nextMessage()
+		if !found {
+			var woke bool
+			msg, ok, woke = c.waitMessage(ticker.C, done)
+			if woke {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if err := c.writePing(conn); err != nil {
+					reason = connectionLeaveReason(false, err)
+					return
+				}
 				continue
 			}
+		}
 
-			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-				util.Warn("Error writing to websocket for client %s: %v", c.ID, err)
-				return
+		if !ok {
+			// The hub closed the channel
+			util.Debug("Send channel closed for client %s", c.ID)
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+		}
+
+		batch := []*Message{msg}
+		if c.batchWindow > 0 {
+			batch = c.collectBatch(batch, done)
+		}
+
+		if err := c.writeBatch(conn, batch); err != nil {
+			util.Warn("Error writing to websocket for client %s: %v", c.ID, err)
+			c.countWebSocketError(WebSocketWrite)
+			reason = connectionLeaveReason(false, err)
+			for _, msg := range batch {
+				c.traceDelivery(msg, DeliveryDropped, "write failed")
 			}
-		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				util.Debug("Error sending ping to client %s: %v", c.ID, err)
-				return
+			return
+		}
+		final := false
+		for _, msg := range batch {
+			c.traceDelivery(msg, DeliveryWritten, "")
+			final = final || msg.final
+		}
+		if final {
+			reason = LeaveNormal
+			return
+		}
+	}
+}
+
+// writePing sends a keepalive ping to the peer
+func (c *Client) writePing(conn *websocket.Conn) error {
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+		util.Debug("Error sending ping to client %s: %v", c.ID, err)
+		c.countWebSocketError(WebSocketWrite)
+		return err
+	}
+	return nil
+}
+
+// collectBatch adds messages queued within the batch window to the batch. If
+// the send channel closes meanwhile, writePump sees it on its next receive
+func (c *Client) collectBatch(batch []*Message, done <-chan struct{}) []*Message {
+	timer := time.NewTimer(c.batchWindow)
+	defer timer.Stop()
+
+	for len(batch) < maxBatchSize {
+		msg, ok, found := c.nextMessage()
+		if !found {
+			var expired bool
+			msg, ok, expired = c.waitMessage(timer.C, done)
+			if expired {
+				return batch
 			}
 		}
+		if !ok {
+			return batch
+		}
+		batch = append(batch, msg)
+	}
+	return batch
+}
+
+// writeBatch writes messages as one frame in the connection's codec: a JSON
+// array in a text frame, or a binary Frame in which each message is a field
+// prefixed with its length. Application data goes in frames of its own
+func (c *Client) writeBatch(conn *websocket.Conn, batch []*Message) error {
+	codec := CodecFor(conn)
+	for len(batch) > 0 {
+		// Application data goes in frames of its own
+		if batch[0].Payload != nil {
+			if err := c.writeAppData(conn, batch[0]); err != nil {
+				return err
+			}
+			batch = batch[1:]
+			continue
+		}
+		n := 1
+		for n < len(batch) && batch[n].Payload == nil {
+			n++
+		}
+
+		data, err := codec.Encode(batch[:n])
+		batch = batch[n:]
+		if err != nil {
+			util.Error("Error marshaling message for client %s: %v", c.ID, err)
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteMessage(codec.FrameType(), data); err != nil {
+			return err
+		}
 	}
+	return nil
 }
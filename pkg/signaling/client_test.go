@@ -0,0 +1,502 @@
+package signaling
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPausedClientReadWait(t *testing.T) {
+	room := NewRoom("test-room")
+	client := &Client{ID: "test-client", Room: room}
+	room.AddClient(client)
+
+	if wait := client.readWait(); wait != pongWait {
+		t.Errorf("Expected read wait %v for an active client, got %v", pongWait, wait)
+	}
+
+	client.setPaused(true)
+	if wait := client.readWait(); wait != pausedPongWait {
+		t.Errorf("Expected read wait %v for a paused client, got %v", pausedPongWait, wait)
+	}
+	if participants := room.Participants(); !participants[0].Devices[0].Paused {
+		t.Error("Expected the roster to show the client as paused")
+	}
+
+	client.setPaused(false)
+	if wait := client.readWait(); wait != pongWait {
+		t.Errorf("Expected read wait %v after resuming, got %v", pongWait, wait)
+	}
+}
+
+func TestCollectBatch(t *testing.T) {
+	client := &Client{
+		ID:          "test-client",
+		send:        make(chan *Message, 10),
+		batchWindow: 20 * time.Millisecond,
+	}
+	client.send <- &Message{Type: "chat"}
+	client.send <- &Message{Type: "reaction"}
+
+	batch := client.collectBatch([]*Message{{Type: "offer"}}, nil)
+	if len(batch) != 3 {
+		t.Fatalf("Expected 3 messages in the batch, got %d", len(batch))
+	}
+	if batch[0].Type != "offer" || batch[2].Type != "reaction" {
+		t.Error("Expected the batch to keep queue order")
+	}
+
+	// A closed channel ends the batch early
+	close(client.send)
+	if batch := client.collectBatch([]*Message{{Type: "offer"}}, nil); len(batch) != 1 {
+		t.Errorf("Expected 1 message after the channel closed, got %d", len(batch))
+	}
+}
+
+func TestWriteBatchFrames(t *testing.T) {
+	for _, subprotocol := range []string{"", ProtobufSubprotocol} {
+		t.Run("codec "+subprotocol, func(t *testing.T) {
+			accepted := make(chan *websocket.Conn, 1)
+			upgrader := websocket.Upgrader{Subprotocols: []string{ProtobufSubprotocol}}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, _ := upgrader.Upgrade(w, r, nil)
+				accepted <- conn
+			}))
+			defer server.Close()
+			dialer := websocket.Dialer{}
+			if subprotocol != "" {
+				dialer.Subprotocols = []string{subprotocol}
+			}
+			peer, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer peer.Close()
+			conn := <-accepted
+			defer conn.Close()
+
+			client := &Client{ID: "test-client"}
+			if err := client.writeBatch(conn, codecBatch()); err != nil {
+				t.Fatalf("Expected the batch to be written, got %v", err)
+			}
+			peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+			frameType, frame, err := peer.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var types []string
+			if subprotocol == "" {
+				// A JSON array in a text frame
+				var messages []Message
+				if err := json.Unmarshal(frame, &messages); frameType != websocket.TextMessage || err != nil {
+					t.Fatalf("Expected a JSON array in a text frame, got type %d, %v", frameType, err)
+				}
+				for _, msg := range messages {
+					types = append(types, msg.Type)
+				}
+			} else {
+				// Each message is a length-prefixed field of a binary frame
+				if frameType != websocket.BinaryMessage {
+					t.Fatalf("Expected a binary frame, got type %d", frameType)
+				}
+				for rest := frame; len(rest) > 0; {
+					length, n := binary.Uvarint(rest[1:])
+					if rest[0] != 1<<3|wireBytes || n <= 0 || uint64(len(rest)-1-n) < length {
+						t.Fatalf("Expected a length-prefixed message, got %x", rest)
+					}
+					element := rest[:1+n+int(length)]
+					messages, err := ProtobufCodec.Decode(element)
+					if err != nil || len(messages) != 1 {
+						t.Fatalf("Expected one message per prefix, got %d, %v", len(messages), err)
+					}
+					types = append(types, messages[0].Type)
+					rest = rest[len(element):]
+				}
+			}
+			if strings.Join(types, " ") != "ice-candidate chat user-list" {
+				t.Errorf("Expected the batch in one frame in order, got %v", types)
+			}
+		})
+	}
+}
+
+func TestSendPriorityLanes(t *testing.T) {
+	client := &Client{ID: "test-client", lanes: newLanes(), state: StateReady}
+	client.send = client.lanes[laneSignaling]
+
+	// Queue low-priority traffic first, then an offer
+	for i := 0; i < 5; i++ {
+		client.Send(&Message{Type: "reaction"})
+	}
+	client.Send(&Message{Type: "chat"})
+	client.Send(&Message{Type: "host-change"})
+	client.Send(&Message{Type: "offer"})
+
+	expected := []string{"offer", "host-change", "chat", "reaction"}
+	for _, want := range expected {
+		msg, ok, found := client.nextMessage()
+		if !found || !ok {
+			t.Fatalf("Expected a queued %s message", want)
+		}
+		if msg.Type != want {
+			t.Errorf("Expected %s next, got %s", want, msg.Type)
+		}
+	}
+
+	// A full bulk lane drops messages without closing the client
+	for i := 0; i < laneCapacity[laneBulk]+10; i++ {
+		client.Send(&Message{Type: "stats"})
+	}
+	if client.closed {
+		t.Error("Expected a full bulk lane not to close the client")
+	}
+}
+
+func TestNegotiationHeldUntilReady(t *testing.T) {
+	client := &Client{ID: "test-client", send: make(chan *Message, 10), state: StateJoined}
+
+	client.Send(&Message{Type: "offer"})
+	client.Send(&Message{Type: "host-change"})
+	client.Send(&Message{Type: "ice-candidate"})
+
+	// Only the control message is delivered before the client is ready
+	if len(client.send) != 1 {
+		t.Fatalf("Expected 1 delivered message before ready, got %d", len(client.send))
+	}
+	<-client.send
+
+	client.MarkReady()
+	client.Send(&Message{Type: "answer"})
+
+	expected := []string{"offer", "ice-candidate", "answer"}
+	for _, want := range expected {
+		select {
+		case msg := <-client.send:
+			if msg.Type != want {
+				t.Errorf("Expected %s, got %s", want, msg.Type)
+			}
+		default:
+			t.Fatalf("Expected a queued %s message", want)
+		}
+	}
+}
+
+func TestBacklogWhileConnectionLost(t *testing.T) {
+	client := &Client{ID: "test-client", send: make(chan *Message, 10), state: StateReady}
+	client.lost = time.AfterFunc(time.Hour, func() {})
+	defer client.lost.Stop()
+
+	client.Send(&Message{Type: "offer"})
+	client.Send(&Message{Type: "host-change", Data: map[string]interface{}{"isHost": true}})
+	client.Send(&Message{Type: "chat"})
+	client.Send(&Message{Type: "reaction"})
+	client.Send(&Message{Type: "sidebar-start"})
+
+	// Negotiation and bulk messages are stale by the time the client resumes
+	if len(client.send) != 0 {
+		t.Fatalf("Expected nothing queued without a connection, got %d", len(client.send))
+	}
+	var types []string
+	for _, msg := range client.backlog {
+		types = append(types, msg.Type)
+	}
+	if fmt.Sprint(types) != "[host-change chat sidebar-start]" {
+		t.Errorf("Expected state changes and chat in the backlog, got %v", types)
+	}
+
+	// A full backlog sheds its oldest chat for anything else, and chat once
+	// there is none left
+	for len(client.backlog) < maxBacklog {
+		client.Send(&Message{Type: "chat"})
+	}
+	client.Send(&Message{Type: "host-change", Data: map[string]interface{}{"isHost": false}})
+	if len(client.backlog) != maxBacklog || client.backlog[1].Type != "sidebar-start" {
+		t.Errorf("Expected the oldest chat to make room, got %s first", client.backlog[1].Type)
+	}
+	if last := client.backlog[maxBacklog-1]; last.Type != "host-change" || last.Data["isHost"] != false {
+		t.Errorf("Expected the latest host change kept, got %+v", last)
+	}
+}
+
+func TestClientStateTransitions(t *testing.T) {
+	client := &Client{ID: "test-client"}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	// Skipping joined is refused
+	if err := client.transitionLocked(StateReady); err == nil {
+		t.Error("Expected connected -> ready to be refused")
+	}
+
+	for _, to := range []ClientState{StateJoined, StateReady, StateLeaving} {
+		if err := client.transitionLocked(to); err != nil {
+			t.Errorf("Expected transition to %s to succeed, got %v", to, err)
+		}
+	}
+
+	// Nothing follows leaving
+	if err := client.transitionLocked(StateReady); err == nil {
+		t.Error("Expected leaving -> ready to be refused")
+	}
+}
+
+func TestDeliveryTrace(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("traced-room")
+	ready := &Client{ID: "ready", Room: room, lanes: newLanes(), state: StateReady}
+	ready.send = ready.lanes[laneSignaling]
+	waiting := &Client{ID: "waiting", Room: room, lanes: newLanes(), state: StateJoined}
+	waiting.send = waiting.lanes[laneSignaling]
+
+	offer := &Message{Type: "offer", From: "sender", TraceID: "trace-1"}
+	ready.Send(offer)
+	waiting.Send(offer)
+	ready.Send(&Message{Type: "offer", From: "sender"}) // Untraced
+
+	events := hub.DeliveryTrace("trace-1")
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 delivery events, got %d", len(events))
+	}
+	if events[0].ClientID != "ready" || events[0].Outcome != DeliveryQueued {
+		t.Errorf("Expected the offer queued for ready, got %+v", events[0])
+	}
+	if events[1].ClientID != "waiting" || events[1].Outcome != DeliveryHeld {
+		t.Errorf("Expected the offer held for waiting, got %+v", events[1])
+	}
+
+	// The log forgets the oldest traces first
+	for i := 0; i < maxDeliveryTraces; i++ {
+		hub.deliveries.record(fmt.Sprintf("filler-%d", i), DeliveryEvent{})
+	}
+	if hub.DeliveryTrace("trace-1") != nil {
+		t.Error("Expected the oldest trace to be evicted")
+	}
+}
+
+func TestErrorMessages(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("errors")
+	client := &Client{ID: "a", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(client)
+	room.settle()
+	drainTypes(client)
+
+	client.handleMessage(&Message{Type: "no-such-type", ID: "m1", From: client.ID})
+	msg := <-client.send
+	if msg.Type != "error" || msg.Data["code"] != ErrorUnknownType || msg.Data["relatedMessageId"] != "m1" {
+		t.Errorf("Expected an unknown-type error naming m1, got %+v", msg)
+	}
+	if msg.Data["retryable"] != false || msg.Data["message"] == "" {
+		t.Errorf("Expected a non-retryable error with a message, got %+v", msg.Data)
+	}
+
+	// Rejections that already had their own message type are followed by
+	// an error
+	client.handleMessage(&Message{Type: "answer", ID: "m2", From: client.ID, To: "gone"})
+	if msg := <-client.send; msg.Type != "recipient-not-found" {
+		t.Errorf("Expected recipient-not-found first, got %+v", msg)
+	}
+	if msg := <-client.send; msg.Data["code"] != ErrorNotFound || msg.Data["relatedMessageId"] != "m2" {
+		t.Errorf("Expected a not-found error for m2, got %+v", msg)
+	}
+
+	// Failures that used to be logged only are reported too
+	client.handleMessage(&Message{Type: "call-hangup", From: client.ID, Data: map[string]interface{}{"callId": "nope"}})
+	msg = <-client.send
+	if msg.Type != "error" || msg.Data["code"] != ErrorNotFound {
+		t.Errorf("Expected a not-found error for an unknown call, got %+v", msg)
+	}
+	if _, found := msg.Data["relatedMessageId"]; found {
+		t.Errorf("Expected no relatedMessageId without a message ID, got %+v", msg.Data)
+	}
+
+	for _, tc := range []struct {
+		err  error
+		code string
+	}{
+		{fmt.Errorf("chat: %w", ErrRoleDenied), ErrorNotPermitted},
+		{ErrSettingsConflict, ErrorConflict},
+		{fmt.Errorf("sequencing kick: %w: %w", errTemporary, fmt.Errorf("backplane down")), ErrorFailed},
+		{ErrNoMediaServer, ErrorUnavailable},
+		{fmt.Errorf("dtmf needs digits"), ErrorInvalid},
+	} {
+		if code := errorCode(tc.err); code != tc.code {
+			t.Errorf("Expected %s for %v, got %s", tc.code, tc.err, code)
+		}
+		if retryable := errorMessage(nil, tc.code, tc.err).Data["retryable"]; retryable != (tc.code == ErrorConflict || tc.code == ErrorFailed) {
+			t.Errorf("Unexpected retryable=%v for %s", retryable, tc.code)
+		}
+	}
+}
+
+func TestMessageSchemas(t *testing.T) {
+	description := map[string]interface{}{"type": "offer", "sdp": "v=0"}
+	for _, tc := range []struct {
+		msg   *Message
+		field string
+	}{
+		{&Message{Type: "offer", Data: map[string]interface{}{"sdp": description}}, ""},
+		{&Message{Type: "answer", Data: map[string]interface{}{"sdp": "v=0"}}, ""},
+		{&Message{Type: "offer"}, "sdp"},
+		{&Message{Type: "answer", Data: map[string]interface{}{"sdp": map[string]interface{}{"type": "answer"}}}, "sdp"},
+		{&Message{Type: "ice-candidate", Data: map[string]interface{}{"candidate": map[string]interface{}{"candidate": ""}}}, ""},
+		{&Message{Type: "ice-candidate", Data: map[string]interface{}{"candidate": 42.0}}, "candidate"},
+		{&Message{Type: "chat", Data: map[string]interface{}{"text": "hi"}}, ""},
+		{&Message{Type: "chat", Data: map[string]interface{}{"text": "  "}}, "text"},
+		{&Message{Type: "chat", Data: map[string]interface{}{"text": strings.Repeat("a", 4001)}}, "text"},
+		{&Message{Type: "reaction"}, ""},
+	} {
+		err := validateMessage(tc.msg)
+		var schemaErr *SchemaError
+		if tc.field == "" && err != nil {
+			t.Errorf("Expected %s with %v to be valid, got %v", tc.msg.Type, tc.msg.Data, err)
+		} else if tc.field != "" && (!errors.As(err, &schemaErr) || schemaErr.Field != tc.field) {
+			t.Errorf("Expected %s with %v to be refused for %s, got %v", tc.msg.Type, tc.msg.Data, tc.field, err)
+		}
+	}
+
+	// The error names the field at fault
+	err := validateMessage(&Message{Type: "chat"})
+	if msg := errorMessage(&Message{Type: "chat"}, errorCode(err), err); msg.Data["code"] != ErrorInvalid || msg.Data["field"] != "text" {
+		t.Errorf("Expected an invalid error naming text, got %+v", msg.Data)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	defer Configure(DefaultConnectionConfig())
+
+	if err := Configure(ConnectionConfig{PongWait: 10 * time.Second, PingPeriod: 20 * time.Second}); err == nil {
+		t.Error("Expected a ping period longer than the pong wait to be refused")
+	}
+	if err := Configure(ConnectionConfig{PongWait: 10 * time.Second, SendBuffer: 8, BroadcastBuffer: 4}); err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+	if pingPeriod != 9*time.Second || writeWait != 10*time.Second {
+		t.Errorf("Expected a derived ping period and the default write wait, got %v and %v", pingPeriod, writeWait)
+	}
+	lanes := newLanes()
+	if cap(lanes[laneSignaling]) != 8 || cap(lanes[laneBulk]) != 4 {
+		t.Errorf("Expected lanes sized from the send buffer, got %d and %d", cap(lanes[laneSignaling]), cap(lanes[laneBulk]))
+	}
+	if room := NewRoom("configured"); cap(room.broadcast) != 4 {
+		t.Errorf("Expected a broadcast buffer of 4, got %d", cap(room.broadcast))
+	}
+
+	// Per-type limits replace the defaults of their types only
+	limits, err := ParseMessageLimits("offer=100000, chat=1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseMessageLimits("offer=big"); err == nil {
+		t.Error("Expected an invalid limit to be refused")
+	}
+	if err := Configure(ConnectionConfig{MaxMessageSize: 2000, MessageLimits: limits}); err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+	if sizeLimit("chat") != 1000 || sizeLimit("answer") != 65536 || sizeLimit("join") != 2000 || maxFrameSize != 100000 {
+		t.Errorf("Expected the configured limits, got %v and a frame size of %d", CurrentMessageLimits(), maxFrameSize)
+	}
+	if err := checkSize(&Message{Type: "chat"}, 1001); err == nil {
+		t.Error("Expected a chat over its limit to be refused")
+	}
+}
+
+func TestMessageSizes(t *testing.T) {
+	chat := &Message{Type: "chat", Data: map[string]interface{}{"text": "hi"}}
+	offer := &Message{Type: "offer", To: "bob", Data: map[string]interface{}{"sdp": strings.Repeat("a", 20000)}}
+	frame, err := ProtobufCodec.Encode([]*Message{chat, offer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := ProtobufCodec.Decode(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := messageSizes(ProtobufCodec, frame, messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 2 || sizes[0] >= 100 || sizes[1] <= 20000 {
+		t.Fatalf("Expected each message's own size, got %v of a %d byte frame", sizes, len(frame))
+	}
+	// The chat is far below its limit even though the frame isn't
+	if err := checkSize(messages[0], sizes[0]); err != nil {
+		t.Errorf("Expected the batched chat to be accepted, got %v", err)
+	}
+	if err := checkSize(messages[0], len(frame)); err == nil {
+		t.Error("Expected the whole frame to be over the chat limit")
+	}
+
+	if sizes, _ := messageSizes(JSONCodec, []byte(`{"type":"chat"}`), []*Message{{Type: "chat"}}); len(sizes) != 1 || sizes[0] != 15 {
+		t.Errorf("Expected a single message to be its frame's size, got %v", sizes)
+	}
+}
+
+func TestMessageRateLimit(t *testing.T) {
+	defer Configure(DefaultConnectionConfig())
+	if err := Configure(ConnectionConfig{MessageRate: 1, MessageBurst: 2}); err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+	hub := NewHub()
+	room := hub.GetRoom("flood")
+	client := &Client{ID: "a", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	room.AddClient(client)
+	room.settle()
+	drainTypes(client)
+
+	now := time.Unix(1000, 0)
+	// flood sends until a message is refused and returns the warning it
+	// drew, if any
+	flood := func() *Message {
+		for client.allowMessage(now) {
+		}
+		select {
+		case msg := <-client.send:
+			return msg
+		default:
+			return nil
+		}
+	}
+
+	if !client.allowMessage(now) || !client.allowMessage(now) {
+		t.Fatal("Expected the burst to pass")
+	}
+	if msg := flood(); msg == nil || msg.Type != "rate-limited" || msg.Data["warnings"] != 1 || msg.Data["disconnect"] != false {
+		t.Fatalf("Expected a first warning, got %+v", msg)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if msg := flood(); msg != nil {
+		t.Errorf("Expected one warning per second, got %+v", msg)
+	}
+
+	// Warnings are forgotten after a quiet minute
+	now = now.Add(2 * time.Minute)
+	if msg := flood(); msg == nil || msg.Data["warnings"] != 1 {
+		t.Fatalf("Expected the warnings to start over, got %+v", msg)
+	}
+	for i := 2; i <= maxRateWarnings; i++ {
+		now = now.Add(rateWarningInterval)
+		if msg := flood(); msg == nil || msg.Data["warnings"] != i {
+			t.Fatalf("Expected warning %d, got %+v", i, msg)
+		}
+	}
+
+	now = now.Add(rateWarningInterval)
+	msg := flood()
+	if msg == nil || msg.Data["disconnect"] != true || !msg.final {
+		t.Errorf("Expected a last warning before disconnecting, got %+v", msg)
+	}
+	if client.closeCode != CloseRateLimited || room.client("a") != nil {
+		t.Errorf("Expected the client disconnected with %d, got %d", CloseRateLimited, client.closeCode)
+	}
+}
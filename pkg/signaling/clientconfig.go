@@ -0,0 +1,112 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// ClientConfig is runtime configuration operators push to clients, so calls
+// in progress adapt without clients reloading
+type ClientConfig struct {
+	// ICE servers in addition to the TURN server of SetTURNConfig, whose
+	// fresh credentials every client gets along with these
+	ICEServers []ICEServer `json:"iceServers,omitempty"`
+
+	// Feature toggles clients honour, e.g. {"screenShare": false}
+	Features map[string]bool `json:"features,omitempty"`
+
+	// Media limits clients apply on top of their room profile's
+	Bitrate *BitratePolicy `json:"bitrate,omitempty"`
+}
+
+// BitratePolicy limits what clients send; zero fields don't limit
+type BitratePolicy struct {
+	MaxBitrate   int  `json:"maxBitrate,omitempty"` // Bits per second of each video track
+	MaxFrameRate int  `json:"maxFrameRate,omitempty"`
+	AudioOnly    bool `json:"audioOnly,omitempty"` // Stop sending video altogether
+}
+
+// Validate checks a client configuration
+func (c ClientConfig) Validate() error {
+	for _, server := range c.ICEServers {
+		if len(server.URLs) == 0 {
+			return errors.New("ICE servers need at least one URL")
+		}
+		for _, url := range server.URLs {
+			if !strings.HasPrefix(url, "stun:") && !strings.HasPrefix(url, "turn:") && !strings.HasPrefix(url, "turns:") {
+				return fmt.Errorf("ICE server URL %q must start with stun:, turn: or turns:", url)
+			}
+		}
+	}
+	for name := range c.Features {
+		if name == "" {
+			return errors.New("feature names must not be empty")
+		}
+	}
+	if c.Bitrate != nil && (c.Bitrate.MaxBitrate < 0 || c.Bitrate.MaxFrameRate < 0) {
+		return errors.New("bitrate limits can't be negative")
+	}
+	return nil
+}
+
+// SetClientConfig replaces the client configuration and pushes it to every
+// connected client in a config-update message. It returns the new version,
+// which starts at 1 and grows with every change
+func (h *Hub) SetClientConfig(config ClientConfig) (int, error) {
+	if err := config.Validate(); err != nil {
+		return 0, err
+	}
+	config.ICEServers = slices.Clone(config.ICEServers)
+	config.Features = maps.Clone(config.Features)
+	h.roomsMutex.Lock()
+	h.clientConfig = config
+	h.clientConfigVersion++
+	version := h.clientConfigVersion
+	h.roomsMutex.Unlock()
+
+	clients := h.connectedClients()
+	for _, client := range clients {
+		client.Send(client.configUpdate())
+	}
+	util.Info("Pushed client configuration version %d to %d clients", version, len(clients))
+	return version, nil
+}
+
+// ClientConfig returns the client configuration and its version, 0 if it
+// was never set
+func (h *Hub) ClientConfig() (ClientConfig, int) {
+	if h == nil {
+		return ClientConfig{}, 0
+	}
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+	return h.clientConfig, h.clientConfigVersion
+}
+
+// configData returns the client configuration as sent to a client, with the
+// healthy ICE servers and its own TURN credentials
+func (c *Client) configData() map[string]interface{} {
+	config, version := c.hub.ClientConfig()
+	iceServers := c.hub.ICEServers(c.ID)
+	data := map[string]interface{}{"version": version}
+	if len(iceServers) > 0 {
+		data["iceServers"] = iceServers
+	}
+	if config.Features != nil {
+		data["features"] = config.Features
+	}
+	if config.Bitrate != nil {
+		data["bitrate"] = config.Bitrate
+	}
+	return data
+}
+
+// configUpdate builds the config-update message for a client
+func (c *Client) configUpdate() *Message {
+	return &Message{Type: "config-update", To: c.ID, Data: c.configData()}
+}
@@ -0,0 +1,162 @@
+package signaling
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Kinds of errors browsers report; anything else is counted as "other"
+const (
+	ClientErrorMedia     = "media"     // getUserMedia failures
+	ClientErrorICE       = "ice"       // ICE connection failures
+	ClientErrorException = "exception" // Uncaught JS exceptions
+	ClientErrorOther     = "other"
+)
+
+// Number of recent error reports kept per room
+const maxClientErrorReports = 50
+
+// Maximum length of a reported error message
+const maxClientErrorMessage = 500
+
+// ClientErrorReport is an error a browser reported about itself
+type ClientErrorReport struct {
+	At       time.Time              `json:"at"`
+	RoomID   string                 `json:"roomId,omitempty"`
+	ClientID string                 `json:"clientId"`
+	Kind     string                 `json:"kind"`
+	Message  string                 `json:"message"`
+	PeerID   string                 `json:"peerId,omitempty"` // Remote peer, for ICE failures
+	Context  map[string]interface{} `json:"context,omitempty"`
+}
+
+// ClientErrorSummary aggregates the errors reported in one room. Reports for
+// rooms that don't exist are summarized with an empty room ID
+type ClientErrorSummary struct {
+	RoomID string              `json:"roomId"`
+	Counts map[string]int      `json:"counts"`
+	Recent []ClientErrorReport `json:"recent"`
+}
+
+// clientErrorLog keeps a room's error counts and most recent reports
+type clientErrorLog struct {
+	mutex  sync.Mutex
+	counts map[string]int
+	recent []ClientErrorReport
+}
+
+// normalizeClientErrorKind maps reported kinds to the known ones
+func normalizeClientErrorKind(kind string) string {
+	switch kind {
+	case ClientErrorMedia, "getUserMedia":
+		return ClientErrorMedia
+	case ClientErrorICE:
+		return ClientErrorICE
+	case ClientErrorException:
+		return ClientErrorException
+	default:
+		return ClientErrorOther
+	}
+}
+
+// clientErrorFromMessage builds a report from a "client-error" message
+func clientErrorFromMessage(msg *Message) ClientErrorReport {
+	report := ClientErrorReport{ClientID: msg.From}
+	report.Kind, _ = msg.Data["kind"].(string)
+	report.Message, _ = msg.Data["message"].(string)
+	report.PeerID, _ = msg.Data["peerId"].(string)
+	report.Context, _ = msg.Data["context"].(map[string]interface{})
+	return report
+}
+
+// add records a report, normalizing its kind and size
+func (l *clientErrorLog) add(report ClientErrorReport) {
+	report.Kind = normalizeClientErrorKind(report.Kind)
+	if report.At.IsZero() {
+		report.At = time.Now()
+	}
+	if len(report.Message) > maxClientErrorMessage {
+		report.Message = report.Message[:maxClientErrorMessage]
+	}
+	util.Warn("Client %s in room %s reported %s error: %s", report.ClientID, report.RoomID, report.Kind, report.Message)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.counts == nil {
+		l.counts = make(map[string]int)
+	}
+	l.counts[report.Kind]++
+	l.recent = append(l.recent, report)
+	if len(l.recent) > maxClientErrorReports {
+		l.recent = l.recent[1:]
+	}
+}
+
+// summary returns the log's counts by kind and its recent reports
+func (l *clientErrorLog) summary(roomID string) ClientErrorSummary {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	summary := ClientErrorSummary{
+		RoomID: roomID,
+		Counts: make(map[string]int, len(l.counts)),
+		Recent: append([]ClientErrorReport{}, l.recent...),
+	}
+	for kind, count := range l.counts {
+		summary.Counts[kind] = count
+	}
+	return summary
+}
+
+// ReportClientError records an error reported by a client of the room
+func (r *Room) ReportClientError(report ClientErrorReport) {
+	report.RoomID = r.ID
+	r.errors.add(report)
+	if normalizeClientErrorKind(report.Kind) == ClientErrorICE {
+		r.health.recordICEFailure()
+	}
+}
+
+// ClientErrors returns the room's error counts by kind and its recent reports
+func (r *Room) ClientErrors() ClientErrorSummary {
+	return r.errors.summary(r.ID)
+}
+
+// ClientErrors returns the error summaries of all rooms with reported errors
+func (h *Hub) ClientErrors() []ClientErrorSummary {
+	h.roomsMutex.RLock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.roomsMutex.RUnlock()
+
+	summaries := []ClientErrorSummary{}
+	if summary := h.errors.summary(""); len(summary.Counts) > 0 {
+		summaries = append(summaries, summary)
+	}
+	for _, room := range rooms {
+		if summary := room.ClientErrors(); len(summary.Counts) > 0 {
+			summaries = append(summaries, summary)
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].RoomID < summaries[j].RoomID
+	})
+	return summaries
+}
+
+// ReportClientError records an error reported outside a WebSocket, e.g. when
+// the browser couldn't get a camera and never connected. Reports for rooms
+// that don't exist are kept hub-wide rather than creating the room
+func (h *Hub) ReportClientError(report ClientErrorReport) {
+	if room := h.FindRoom(report.RoomID); room != nil {
+		room.ReportClientError(report)
+		return
+	}
+	h.errors.add(report)
+}
@@ -0,0 +1,226 @@
+package signaling
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// How many heartbeats in a row a node may miss before the others declare it
+// dead
+const missedHeartbeats = 3
+
+// NodeStatus is what a node announces about itself in its heartbeats
+type NodeStatus struct {
+	Name     string    `json:"name"`
+	Rooms    int       `json:"rooms"`
+	Clients  int       `json:"clients"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// clusterView is the nodes a hub heard heartbeats from, by name
+type clusterView struct {
+	mutex     sync.Mutex
+	nodes     map[string]NodeStatus
+	deadAfter time.Duration
+}
+
+// StartGossip announces this node on the backplane at the given interval and
+// declares nodes dead once they miss missedHeartbeats in a row. Members of a
+// dead node are removed from the state store, so its clients can rejoin
+// through the nodes left, and rooms only it had open are forgotten. It runs
+// until the returned stop function is called
+func (h *Hub) StartGossip(interval time.Duration) (stop func()) {
+	h.cluster.mutex.Lock()
+	h.cluster.deadAfter = interval * missedHeartbeats
+	h.cluster.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		h.heartbeat()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				h.heartbeat()
+				h.reapNodes(time.Now())
+			}
+		}
+	}()
+	util.Info("Gossiping node health every %v", interval)
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// Nodes returns the live nodes of the cluster, this one included once its
+// first heartbeat went round, ordered by name
+func (h *Hub) Nodes() []NodeStatus {
+	h.cluster.mutex.Lock()
+	defer h.cluster.mutex.Unlock()
+	nodes := make([]NodeStatus, 0, len(h.cluster.nodes))
+	for _, node := range h.cluster.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+// heartbeat announces this node and its load to every node
+func (h *Hub) heartbeat() {
+	if h.backplane == nil {
+		return
+	}
+	status := NodeStatus{Name: h.node, LastSeen: time.Now()}
+	for _, room := range h.activeRooms() {
+		status.Rooms++
+		status.Clients += len(room.GetClients())
+	}
+	h.publishCluster(remoteMessage{Node: h.node, Heartbeat: &status})
+}
+
+// publishCluster sends a heartbeat, a death notice or an idle room sweep
+// to every node
+func (h *Hub) publishCluster(remote remoteMessage) {
+	payload, err := json.Marshal(remote)
+	if err != nil {
+		util.Error("Error encoding cluster message: %v", err)
+		return
+	}
+	if err := h.backplane.PublishCluster(payload); err != nil {
+		util.Warn("Error gossiping on the backplane: %v", err)
+	}
+}
+
+// receiveHeartbeat records that a node is alive
+func (h *Hub) receiveHeartbeat(status NodeStatus) {
+	h.cluster.mutex.Lock()
+	if h.cluster.nodes == nil {
+		h.cluster.nodes = make(map[string]NodeStatus)
+	}
+	_, known := h.cluster.nodes[status.Name]
+	status.LastSeen = time.Now()
+	h.cluster.nodes[status.Name] = status
+	h.cluster.mutex.Unlock()
+
+	if !known && status.Name != h.node {
+		util.Info("Node %s joined the cluster", status.Name)
+	}
+}
+
+// reapNodes declares the nodes silent for too long dead. Their members are
+// removed from the state store here, and every node, this one included, is
+// told who left which room
+func (h *Hub) reapNodes(now time.Time) {
+	h.cluster.mutex.Lock()
+	var dead []string
+	for name, node := range h.cluster.nodes {
+		if name != h.node && now.Sub(node.LastSeen) > h.cluster.deadAfter {
+			delete(h.cluster.nodes, name)
+			dead = append(dead, name)
+		}
+	}
+	h.cluster.mutex.Unlock()
+
+	for _, name := range dead {
+		util.Warn("Node %s missed %d heartbeats, removing it from the cluster", name, missedHeartbeats)
+		h.publishCluster(remoteMessage{Node: h.node, Down: name, Left: h.forgetNode(name)})
+	}
+}
+
+// forgetNode removes a dead node's members from the state store, and the
+// rooms it alone had open. It returns the clients removed, by room
+func (h *Hub) forgetNode(name string) map[string][]string {
+	h.roomsMutex.RLock()
+	store := h.state
+	h.roomsMutex.RUnlock()
+	records, err := store.Rooms()
+	if err != nil {
+		util.Warn("Error reading rooms of dead node %s: %v", name, err)
+		return nil
+	}
+
+	left := make(map[string][]string)
+	for _, record := range records {
+		members, err := store.Members(record.ID)
+		if err != nil {
+			util.Warn("Error reading members of room %s: %v", record.ID, err)
+			continue
+		}
+		remaining := 0
+		for _, member := range members {
+			if member.Node != name {
+				remaining++
+			} else if err := store.RemoveMember(record.ID, member.ClientID); err != nil {
+				util.Warn("Error removing %s of dead node %s: %v", member.ClientID, name, err)
+			} else {
+				left[record.ID] = append(left[record.ID], member.ClientID)
+			}
+		}
+		if remaining == 0 && record.Node == name && h.FindRoom(record.ID) == nil {
+			util.Info("Forgetting room %s of dead node %s", record.ID, name)
+			if err := store.DeleteRoom(record.ID); err != nil {
+				util.Warn("Error removing room %s from the state store: %v", record.ID, err)
+			}
+		}
+	}
+	return left
+}
+
+// receiveDown handles a node being declared dead: this node's clients are
+// told its clients left. A node declared dead while still alive records its
+// members again
+func (h *Hub) receiveDown(name string, left map[string][]string) {
+	if name == h.node {
+		util.Warn("This node was declared dead, recording its members again")
+		for _, room := range h.activeRooms() {
+			room.recordMembers()
+		}
+		return
+	}
+	h.cluster.mutex.Lock()
+	delete(h.cluster.nodes, name)
+	h.cluster.mutex.Unlock()
+
+	for roomID, clientIDs := range left {
+		if room := h.FindRoom(roomID); room != nil {
+			room.dropRemote(name, clientIDs)
+		}
+	}
+}
+
+// dropRemote tells this node's clients that clients of a dead node left. The
+// room's record is written again, so it names a live node
+func (r *Room) dropRemote(node string, clientIDs []string) {
+	for _, clientID := range clientIDs {
+		r.enqueue(&Message{
+			Type: "user-left",
+			From: clientID,
+			Data: map[string]interface{}{"userId": clientID, "reason": LeaveNetworkError},
+		})
+	}
+	util.Info("%d clients of dead node %s left room %s", len(clientIDs), node, r.ID)
+
+	r.clientMutex.Lock()
+	r.dirty = true
+	r.clientMutex.Unlock()
+	r.flushChanges()
+}
+
+// recordMembers writes this node's clients in the room to the state store
+// again
+func (r *Room) recordMembers() {
+	r.clientMutex.Lock()
+	for _, client := range r.clients {
+		r.recordJoinLocked(client)
+	}
+	r.dirty = true
+	r.clientMutex.Unlock()
+	r.flushChanges()
+}
@@ -0,0 +1,94 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProtobufSubprotocol is the WebSocket subprotocol clients offer to have
+// their messages encoded as protobuf frames instead of JSON
+const ProtobufSubprotocol = "signaling.v1.proto"
+
+// errFrameSize is returned by ReadMessage for frames that hold no message
+// or several
+var errFrameSize = errors.New("expected one message in the frame")
+
+// Codec encodes and decodes the frames of a client connection
+type Codec interface {
+	// Encode encodes messages as one frame. Clients that didn't ask for
+	// batching get one message per frame
+	Encode(batch []*Message) ([]byte, error)
+
+	// Decode decodes a frame the client sent into its messages
+	Decode(frame []byte) ([]*Message, error)
+
+	// FrameType is the WebSocket message type frames are sent as
+	FrameType() int
+}
+
+// Codecs of the two wire formats
+var (
+	JSONCodec     Codec = jsonCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+// CodecFor returns the codec of a connection from the subprotocol it
+// negotiated; JSON unless it is ProtobufSubprotocol
+func CodecFor(conn *websocket.Conn) Codec {
+	if conn != nil && conn.Subprotocol() == ProtobufSubprotocol {
+		return ProtobufCodec
+	}
+	return JSONCodec
+}
+
+// WriteMessage writes one message to a connection that has no client yet,
+// e.g. to refuse a join, in the connection's codec
+func WriteMessage(conn *websocket.Conn, msg *Message) error {
+	codec := CodecFor(conn)
+	data, err := codec.Encode([]*Message{msg})
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(codec.FrameType(), data)
+}
+
+// ReadMessage reads one message from a connection that has no client yet
+func ReadMessage(conn *websocket.Conn) (*Message, error) {
+	_, frame, err := conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	messages, err := CodecFor(conn).Decode(frame)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) != 1 {
+		return nil, errFrameSize
+	}
+	return messages[0], nil
+}
+
+// jsonCodec sends a single message as a JSON object and a batch as a JSON
+// array. Clients send one object per frame
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(batch []*Message) ([]byte, error) {
+	if len(batch) == 1 {
+		return json.Marshal(batch[0])
+	}
+	return json.Marshal(batch)
+}
+
+func (jsonCodec) Decode(frame []byte) ([]*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(frame, &msg); err != nil {
+		return nil, err
+	}
+	return []*Message{&msg}, nil
+}
+
+func (jsonCodec) FrameType() int {
+	return websocket.TextMessage
+}
@@ -0,0 +1,112 @@
+package signaling
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// codecBatch is a typical batch: an ICE candidate, a chat message and a
+// user list
+func codecBatch() []*Message {
+	return []*Message{
+		{
+			Type: "ice-candidate",
+			From: "alice",
+			To:   "bob",
+			Data: map[string]interface{}{
+				"candidate":     "candidate:842163049 1 udp 1677729535 203.0.113.7 46154 typ srflx raddr 0.0.0.0 rport 0",
+				"sdpMid":        "0",
+				"sdpMLineIndex": 0,
+			},
+		},
+		{
+			Type:    "chat",
+			From:    "alice",
+			ID:      "m-1",
+			TraceID: "t-1",
+			Data:    map[string]interface{}{"text": "", "mentions": []string{"bob"}, "edited": false, "reply": nil},
+		},
+		{
+			Type:   "user-list",
+			IsHost: true,
+			Data: map[string]interface{}{
+				"users": []map[string]interface{}{{"id": "alice", "muted": true}, {"id": "bob", "volume": 0.5}},
+				"empty": map[string]interface{}{},
+			},
+		},
+	}
+}
+
+func TestProtobufCodecMatchesJSON(t *testing.T) {
+	// Types JSON doesn't decode to are converted through their JSON form
+	batch := append(codecBatch(), &Message{
+		Type: "chat-history",
+		Data: map[string]interface{}{"messages": []ChatMessage{
+			{Seq: 1, RoomID: "room", From: "alice", Data: map[string]interface{}{"text": "hi"}, At: time.Unix(1700000000, 0).UTC()},
+		}},
+	})
+	frame, err := ProtobufCodec.Encode(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ProtobufCodec.Decode(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(batch) {
+		t.Fatalf("Expected %d messages, got %d", len(batch), len(decoded))
+	}
+
+	// Binary clients must see exactly what JSON clients see
+	for i, msg := range batch {
+		data, err := JSONCodec.Encode([]*Message{msg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := JSONCodec.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded[i], expected[0]) {
+			got, _ := json.Marshal(decoded[i])
+			t.Errorf("Message %d decoded as %s, expected %s", i, got, data)
+		}
+	}
+
+	if _, err := ProtobufCodec.Decode(frame[:len(frame)-1]); err == nil {
+		t.Error("Expected a truncated frame to fail to decode")
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	batch := codecBatch()
+	for _, codec := range []struct {
+		name  string
+		codec Codec
+	}{{"json", JSONCodec}, {"protobuf", ProtobufCodec}} {
+		b.Run(codec.name+"/encode", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := codec.codec.Encode(batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		// Clients send one message per frame
+		frame, err := codec.codec.Encode(batch[:1])
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(codec.name+"/decode", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := codec.codec.Decode(frame); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
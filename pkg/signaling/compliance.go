@@ -0,0 +1,207 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// RecordingCompliance is the kind of the mandatory recordings of compliance rooms
+const RecordingCompliance = "compliance"
+
+// Sender of compliance announcements in the room chat
+const complianceSender = "compliance"
+
+// Audit actions
+const (
+	AuditRecordingStarted  = "recording-started"
+	AuditRecordingStored   = "recording-stored"
+	AuditPrivateChatDenied = "private-chat-blocked"
+	AuditHoldPlaced        = "legal-hold-placed"
+	AuditHoldReleased      = "legal-hold-released"
+	AuditDeletionBlocked   = "deletion-blocked"
+	AuditDeleted           = "deleted"
+	AuditTokenCreated      = "api-token-created"
+	AuditTokenRevoked      = "api-token-revoked"
+	AuditModeration        = "moderation"
+)
+
+// ErrPrivateChatBlocked is returned for direct chat messages in rooms whose
+// tenant blocks private chat
+var ErrPrivateChatBlocked = errors.New("private chat is disabled in this room")
+
+// CompliancePolicy puts every room of a tenant in compliance mode: all
+// participants are recorded, hear or read an announcement when they join,
+// and recordings are hashed into the audit log
+type CompliancePolicy struct {
+	Tenant string `json:"tenant"`
+
+	// Notice posted to the chat of each participant who joins
+	Announcement string `json:"announcement"`
+
+	// Recorded announcement the client plays at join
+	AnnouncementAudioURL string `json:"announcementAudioUrl,omitempty"`
+
+	// Refuse chat messages addressed to a single participant
+	BlockPrivateChat bool `json:"blockPrivateChat,omitempty"`
+}
+
+// AuditEntry is one record of the audit log
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	Tenant      string    `json:"tenant,omitempty"`
+	RoomID      string    `json:"roomId,omitempty"`
+	ClientID    string    `json:"clientId,omitempty"`
+	RecordingID string    `json:"recordingId,omitempty"`
+
+	// SHA-256 of the stored recording
+	SHA256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+
+	// Free-form context, e.g. the record a deletion was attempted on
+	Details string `json:"details,omitempty"`
+
+	// Set by the audit log: the hash of the previous entry and of this one,
+	// chaining entries so edits and deletions can be detected
+	Prev string `json:"prev,omitempty"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// AuditLog appends entries to an append-only, tamper-evident log
+type AuditLog interface {
+	Append(entry AuditEntry) error
+}
+
+// LoadCompliancePolicies reads the compliance policies of tenants from a JSON file
+func LoadCompliancePolicies(path string) ([]CompliancePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policies []CompliancePolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for i, policy := range policies {
+		if policy.Tenant == "" || policy.Announcement == "" {
+			return nil, fmt.Errorf("policy %d: tenant and announcement are required", i)
+		}
+		if seen[policy.Tenant] {
+			return nil, fmt.Errorf("policy %d: duplicate tenant %s", i, policy.Tenant)
+		}
+		seen[policy.Tenant] = true
+	}
+	return policies, nil
+}
+
+// SetCompliancePolicies puts the rooms of the given tenants in compliance
+// mode. It must be called before rooms are created
+func (h *Hub) SetCompliancePolicies(policies []CompliancePolicy) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.compliance = make(map[string]*CompliancePolicy, len(policies))
+	for i := range policies {
+		h.compliance[policies[i].Tenant] = &policies[i]
+	}
+}
+
+// SetAuditLog sets where compliance rooms record their audit trail. It must
+// be called before rooms are created
+func (h *Hub) SetAuditLog(log AuditLog) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.auditLog = log
+}
+
+// Compliance returns the compliance policy of the room's tenant, or nil if
+// the room isn't in compliance mode
+func (r *Room) Compliance() *CompliancePolicy {
+	return r.compliance
+}
+
+// audit appends an entry to the audit log, logging instead of failing the caller
+func (r *Room) audit(entry AuditEntry) {
+	if r.auditLog == nil {
+		return
+	}
+	entry.Time = time.Now()
+	entry.Tenant = r.Settings().Tenant
+	entry.RoomID = r.ID
+	if err := r.auditLog.Append(entry); err != nil {
+		util.Error("Error writing audit entry %s for room %s: %v", entry.Action, r.ID, err)
+	}
+}
+
+// startCompliance announces compliance mode to a client who joined and
+// starts its mandatory recording. Joining the room is the participant's
+// consent, so they aren't asked
+func (r *Room) startCompliance(client *Client) {
+	policy := r.compliance
+	if policy == nil {
+		return
+	}
+
+	announcement := &Message{
+		Type: "chat",
+		From: complianceSender,
+		To:   client.ID,
+		Data: map[string]interface{}{
+			"text":         policy.Announcement,
+			"announcement": true,
+		},
+	}
+	if policy.AnnouncementAudioURL != "" {
+		announcement.Data["audioUrl"] = policy.AnnouncementAudioURL
+	}
+	client.Send(announcement)
+
+	now := time.Now()
+	entry := &roomRecording{
+		Recording: Recording{
+			ID:          randomToken(8),
+			RoomID:      r.ID,
+			Kind:        RecordingCompliance,
+			ClientID:    client.ID,
+			UserID:      client.UserID,
+			Tracks:      RecordTracksAll,
+			RequestedBy: complianceSender,
+			Status:      RecordingActive,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		},
+		uploadToken: randomToken(16),
+	}
+	r.recordingMutex.Lock()
+	r.recordings[entry.ID] = entry
+	r.recordingMutex.Unlock()
+	r.saveRecording(entry.Recording)
+	r.audit(AuditEntry{Action: AuditRecordingStarted, ClientID: client.ID, RecordingID: entry.ID})
+
+	util.Info("Compliance recording %s of client %s started in room %s", entry.ID, client.ID, r.ID)
+	client.Send(&Message{
+		Type: "recording-start",
+		Data: map[string]interface{}{
+			"recordingId": entry.ID,
+			"tracks":      entry.Tracks,
+			"mandatory":   true,
+			"uploadUrl":   fmt.Sprintf("/api/rooms/%s/recordings/%s/artifact", r.ID, entry.ID),
+			"uploadToken": entry.uploadToken,
+		},
+	})
+	r.Broadcast(recordingStatusMessage(entry.Recording), "")
+}
+
+// checkChat refuses chat messages the room's compliance policy forbids
+func (r *Room) checkChat(client *Client, msg *Message) error {
+	if r.compliance == nil || !r.compliance.BlockPrivateChat || msg.To == "" {
+		return nil
+	}
+	r.audit(AuditEntry{Action: AuditPrivateChatDenied, ClientID: client.ID})
+	return ErrPrivateChatBlocked
+}
@@ -0,0 +1,179 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// customPrefix starts the type of custom events: "custom:<namespace>:<event>"
+const customPrefix = "custom:"
+
+// Recipients of custom events
+const (
+	RecipientsRoom = "room" // Everyone else in the room, or the client in To
+	RecipientsHost = "host" // Only the host
+)
+
+// Most stored custom events a room keeps; older ones are dropped
+const maxCustomHistory = 100
+
+// namespacePattern matches valid custom event namespaces and event names
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// CustomEventRule controls the custom events of one namespace in a room.
+// Namespaces without a rule may be sent by participants to the room and
+// aren't stored
+type CustomEventRule struct {
+	Namespace string `json:"namespace"`
+
+	// Least role that may send the events; empty for participants
+	Sender Role `json:"sender,omitempty"`
+
+	// Who gets the events: RecipientsRoom, the default, or RecipientsHost
+	Recipients string `json:"recipients,omitempty"`
+
+	// Stored events are sent to clients that join later, e.g. the strokes
+	// of a shared whiteboard
+	History bool `json:"history,omitempty"`
+}
+
+// customEvent is a stored custom event
+type customEvent struct {
+	msg      *Message
+	hostOnly bool
+	at       time.Time
+}
+
+// validateCustomEventRules checks the custom event rules of a room
+func validateCustomEventRules(rules []CustomEventRule) error {
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if !namespacePattern.MatchString(rule.Namespace) {
+			return fmt.Errorf("invalid custom event namespace %q", rule.Namespace)
+		}
+		if seen[rule.Namespace] {
+			return fmt.Errorf("duplicate rule for custom event namespace %q", rule.Namespace)
+		}
+		seen[rule.Namespace] = true
+		if rule.Sender != "" {
+			if _, err := ParseRole(string(rule.Sender)); err != nil {
+				return fmt.Errorf("custom event namespace %s: %w", rule.Namespace, err)
+			}
+		}
+		switch rule.Recipients {
+		case "", RecipientsRoom, RecipientsHost:
+		default:
+			return fmt.Errorf("custom event namespace %s: unknown recipients %q", rule.Namespace, rule.Recipients)
+		}
+	}
+	return nil
+}
+
+// isCustomEvent reports whether a message type follows the custom event
+// convention
+func isCustomEvent(msgType string) bool {
+	return strings.HasPrefix(msgType, customPrefix)
+}
+
+// parseCustomType splits a custom event type into its namespace and event
+func parseCustomType(msgType string) (namespace, event string, err error) {
+	rest, found := strings.CutPrefix(msgType, customPrefix)
+	if !found {
+		return "", "", errors.New("not a custom event")
+	}
+	namespace, event, found = strings.Cut(rest, ":")
+	if !found || !namespacePattern.MatchString(namespace) || !namespacePattern.MatchString(event) {
+		return "", "", fmt.Errorf("custom events are typed custom:<namespace>:<event>, not %q", msgType)
+	}
+	return namespace, event, nil
+}
+
+// customEventRule returns the rule of a namespace, or the default
+func (r *Room) customEventRule(namespace string) CustomEventRule {
+	for _, rule := range r.Settings().CustomEvents {
+		if rule.Namespace == namespace {
+			return rule
+		}
+	}
+	return CustomEventRule{Namespace: namespace}
+}
+
+// handleCustomEvent checks a custom event against its namespace's rule,
+// relays it and stores it if the rule says so
+func (c *Client) handleCustomEvent(msg *Message) error {
+	namespace, _, err := parseCustomType(msg.Type)
+	if err != nil {
+		return err
+	}
+	room := c.Room
+	rule := room.customEventRule(namespace)
+	sender := rule.Sender
+	if sender == "" {
+		sender = RoleParticipant
+	}
+	if !c.Role().Allows(sender) {
+		return fmt.Errorf("%s: %w", msg.Type, ErrRoleDenied)
+	}
+
+	if rule.Recipients == RecipientsHost {
+		host := room.GetHost()
+		if host == "" || host == c.ID {
+			return fmt.Errorf("%s: no host to send it to", msg.Type)
+		}
+		msg.To = host
+	}
+	if msg.To != "" {
+		if err := room.SendTo(msg); err != nil {
+			return err
+		}
+	} else {
+		room.Broadcast(msg, c.ID)
+	}
+
+	if rule.History && (msg.To == "" || rule.Recipients == RecipientsHost) {
+		room.storeCustomEvent(msg, rule.Recipients == RecipientsHost)
+	}
+	return nil
+}
+
+// storeCustomEvent keeps a custom event for clients that join later
+func (r *Room) storeCustomEvent(msg *Message, hostOnly bool) {
+	stored := &Message{Type: msg.Type, From: msg.From, Data: msg.Data}
+	r.customMutex.Lock()
+	defer r.customMutex.Unlock()
+	r.customHistory = append(r.customHistory, customEvent{msg: stored, hostOnly: hostOnly, at: time.Now()})
+	if len(r.customHistory) > maxCustomHistory {
+		r.customHistory = r.customHistory[len(r.customHistory)-maxCustomHistory:]
+	}
+}
+
+// sendCustomHistory sends a client that just joined the stored custom
+// events it may see, oldest first, as one custom-history message
+func (r *Room) sendCustomHistory(client *Client) {
+	isHost := client.IsHost()
+	r.customMutex.Lock()
+	events := make([]map[string]interface{}, 0, len(r.customHistory))
+	for _, event := range r.customHistory {
+		if event.hostOnly && !isHost {
+			continue
+		}
+		events = append(events, map[string]interface{}{
+			"type": event.msg.Type,
+			"from": event.msg.From,
+			"data": event.msg.Data,
+			"at":   event.at.UnixMilli(),
+		})
+	}
+	r.customMutex.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+	util.Debug("Sending %d stored custom events to client %s in room %s", len(events), client.ID, r.ID)
+	client.Send(&Message{Type: "custom-history", To: client.ID, Data: map[string]interface{}{"events": events}})
+}
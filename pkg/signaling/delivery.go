@@ -0,0 +1,119 @@
+package signaling
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Outcomes recorded for messages that carry a trace ID
+const (
+	DeliveryReceived = "received" // Read from the sender
+	DeliveryHeld     = "held"     // Held until the recipient is ready
+	DeliveryQueued   = "queued"   // Queued on the recipient's send lane
+	DeliveryWritten  = "written"  // Written to the recipient's socket
+	DeliveryDropped  = "dropped"  // Never delivered; see Reason
+)
+
+const (
+	// Number of traced messages kept; the oldest are forgotten first
+	maxDeliveryTraces = 1000
+
+	// Events kept per traced message, e.g. for broadcasts to large rooms
+	maxDeliveryEvents = 256
+)
+
+// DeliveryEvent is one step in the delivery of a traced message
+type DeliveryEvent struct {
+	At       time.Time `json:"at"`
+	RoomID   string    `json:"roomId"`
+	Type     string    `json:"type"`
+	From     string    `json:"from,omitempty"`
+	ClientID string    `json:"clientId"` // Sender for received, recipient otherwise
+	Outcome  string    `json:"outcome"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// deliveryLog keeps the delivery events of recently traced messages
+type deliveryLog struct {
+	mutex  sync.Mutex
+	traces map[string][]DeliveryEvent
+	order  []string
+}
+
+// newDeliveryLog creates an empty delivery log
+func newDeliveryLog() *deliveryLog {
+	return &deliveryLog{traces: make(map[string][]DeliveryEvent)}
+}
+
+// record adds an event to a trace, forgetting the oldest trace when full
+func (l *deliveryLog) record(traceID string, event DeliveryEvent) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	events, exists := l.traces[traceID]
+	if !exists {
+		if len(l.order) >= maxDeliveryTraces {
+			delete(l.traces, l.order[0])
+			l.order = l.order[1:]
+		}
+		l.order = append(l.order, traceID)
+	}
+	if len(events) >= maxDeliveryEvents {
+		return
+	}
+	l.traces[traceID] = append(events, event)
+}
+
+// get returns a copy of a trace's events
+func (l *deliveryLog) get(traceID string) []DeliveryEvent {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	events, exists := l.traces[traceID]
+	if !exists {
+		return nil
+	}
+	return append([]DeliveryEvent(nil), events...)
+}
+
+// DeliveryTrace returns what happened to the message with the given trace
+// ID, or nil if it isn't known
+func (h *Hub) DeliveryTrace(traceID string) []DeliveryEvent {
+	return h.deliveries.get(traceID)
+}
+
+// traceDelivery records a delivery step of a traced message for this client.
+// Messages without a trace ID cost nothing
+func (c *Client) traceDelivery(msg *Message, outcome, reason string) {
+	if msg.TraceID == "" || c.Room == nil || c.Room.deliveries == nil {
+		return
+	}
+	util.Debug("Trace %s: %s %s for client %s %s", msg.TraceID, msg.Type, outcome, c.ID, reason)
+	c.Room.deliveries.record(msg.TraceID, DeliveryEvent{
+		At:       time.Now(),
+		RoomID:   c.Room.ID,
+		Type:     msg.Type,
+		From:     msg.From,
+		ClientID: c.ID,
+		Outcome:  outcome,
+		Reason:   reason,
+	})
+}
+
+// traceUndeliverable records a traced message whose recipient isn't in the room
+func (r *Room) traceUndeliverable(msg *Message) {
+	if msg.TraceID == "" || r.deliveries == nil {
+		return
+	}
+	r.deliveries.record(msg.TraceID, DeliveryEvent{
+		At:       time.Now(),
+		RoomID:   r.ID,
+		Type:     msg.Type,
+		From:     msg.From,
+		ClientID: msg.To,
+		Outcome:  DeliveryDropped,
+		Reason:   "recipient not in room",
+	})
+}
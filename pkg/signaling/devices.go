@@ -0,0 +1,173 @@
+package signaling
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Participant groups the connections of one user so the roster can show a
+// person with several devices as a single entry. The user ID is sent as
+// "accountId" because "userId" already means the client ID in user-left
+// and legacy user-joined messages
+type Participant struct {
+	UserID  string         `json:"accountId"`
+	Devices []DeviceStatus `json:"devices"`
+
+	// Set when every device of the participant signed in through the
+	// identity provider; guests and self-declared user IDs are unverified
+	Verified bool `json:"verified"`
+
+	// Put on hold by the other party of a 1:1 call
+	OnHold bool `json:"onHold,omitempty"`
+}
+
+// DeviceStatus describes one connection of a participant
+type DeviceStatus struct {
+	ClientID   string      `json:"clientId"`
+	DeviceID   string      `json:"deviceId,omitempty"`
+	Publishing bool        `json:"publishing"`
+	Paused     bool        `json:"paused,omitempty"`
+	Aside      bool        `json:"aside,omitempty"` // In a sidebar with the host
+	State      ClientState `json:"state"`
+	Verified   bool        `json:"verified"`
+	Transport  string      `json:"transport,omitempty"` // ICE transport of its media, as it reported it
+	Connection string      `json:"connection"`          // Connected, or reconnecting after losing its connection
+
+	// Display name, avatar and metadata of the connection
+	Persona
+
+	// What the host turned off of its media
+	MediaControl
+}
+
+// Participants returns the room roster grouped by user. Clients without a
+// user ID are listed as their own participant
+func (r *Room) Participants() []Participant {
+	held := r.hub.heldParty(r.ID)
+
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+
+	byUser := make(map[string]*Participant)
+	order := make([]string, 0, len(r.clients))
+	for _, client := range r.clients {
+		userID := client.userKey()
+		participant, exists := byUser[userID]
+		if !exists {
+			participant = &Participant{UserID: userID, Verified: true, OnHold: userID == held}
+			byUser[userID] = participant
+			order = append(order, userID)
+		}
+		participant.Devices = append(participant.Devices, DeviceStatus{
+			ClientID:     client.ID,
+			DeviceID:     client.DeviceID,
+			Publishing:   r.isPublisherLocked(client),
+			Paused:       client.IsPaused(),
+			Aside:        client.asideFrom(r),
+			State:        client.State(),
+			Verified:     client.Verified,
+			Transport:    client.Transport(),
+			Connection:   client.ConnectionState(),
+			Persona:      client.Persona(),
+			MediaControl: r.controlled[client.ID],
+		})
+		participant.Verified = participant.Verified && client.Verified
+	}
+
+	sort.Strings(order)
+	participants := make([]Participant, 0, len(order))
+	for _, userID := range order {
+		participant := byUser[userID]
+		sort.Slice(participant.Devices, func(i, j int) bool {
+			return participant.Devices[i].ClientID < participant.Devices[j].ClientID
+		})
+		participants = append(participants, *participant)
+	}
+	return participants
+}
+
+// Publisher returns the client ID of the device currently publishing media
+// for a user
+func (r *Room) Publisher(userID string) string {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.publishers[userID]
+}
+
+// SwitchDevice hands media publishing for the user of the sending client over
+// to another of that user's devices and tells the room about it
+func (r *Room) SwitchDevice(sender *Client, targetClientID string) error {
+	r.clientMutex.Lock()
+
+	target, exists := r.clients[targetClientID]
+	if !exists {
+		r.clientMutex.Unlock()
+		return fmt.Errorf("device %s is not in room %s", targetClientID, r.ID)
+	}
+	userID := sender.userKey()
+	if target.userKey() != userID {
+		r.clientMutex.Unlock()
+		return fmt.Errorf("device %s does not belong to user %s", targetClientID, userID)
+	}
+
+	previous := r.publishers[userID]
+	r.publishers[userID] = target.ID
+	r.clientMutex.Unlock()
+
+	util.Info("User %s switched publishing device in room %s: %s -> %s", userID, r.ID, previous, target.ID)
+	r.Broadcast(deviceSwitchedMessage(userID, previous, target.ID), "")
+	return nil
+}
+
+// isPublisherLocked reports whether a client is its user's publishing
+// device; the caller must hold clientMutex
+func (r *Room) isPublisherLocked(client *Client) bool {
+	return r.publishers[client.userKey()] == client.ID
+}
+
+// handOverPublishingLocked picks another device of the user to publish when
+// the publishing device leaves; the caller must hold clientMutex
+func (r *Room) handOverPublishingLocked(left *Client) {
+	userID := left.userKey()
+	if r.publishers[userID] != left.ID {
+		return
+	}
+	delete(r.publishers, userID)
+
+	for _, client := range r.clients {
+		if client.userKey() == userID {
+			r.publishers[userID] = client.ID
+			util.Info("Publishing for user %s in room %s moved to remaining device %s", userID, r.ID, client.ID)
+			r.enqueue(deviceSwitchedMessage(userID, left.ID, client.ID))
+			return
+		}
+	}
+}
+
+// deviceSwitchedMessage builds the notification sent when a user's publishing device changes
+func deviceSwitchedMessage(userID, previousClientID, clientID string) *Message {
+	return &Message{
+		Type: "device-switched",
+		Data: map[string]interface{}{
+			"accountId":        userID,
+			"previousClientId": previousClientID,
+			"clientId":         clientID,
+		},
+	}
+}
+
+// userKey returns the identity used to group this client's devices
+func (c *Client) userKey() string {
+	if c.UserID != "" {
+		return c.UserID
+	}
+	return c.ID
+}
+
+// sameParticipant reports whether a new connection is provably the
+// participant this client is: both carry the same verified user ID
+func (c *Client) sameParticipant(other *Client) bool {
+	return c.Verified && other.Verified && c.UserID != "" && c.UserID == other.UserID
+}
@@ -0,0 +1,56 @@
+package signaling
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Longest digit string one dtmf message may carry
+const maxDTMFDigits = 32
+
+// Tone duration bounds in milliseconds, as for RTCDTMFSender.insertDTMF
+const (
+	defaultDTMFDuration = 100
+	minDTMFDuration     = 40
+	maxDTMFDuration     = 6000
+)
+
+// RelayDTMF passes keypad tones to a participant, or to everyone else in
+// the room if the message has no recipient. Gateways to the phone network
+// play them to the far end as RFC 4733 events and send the tones they
+// receive the same way, so browser users can navigate phone menus
+func (r *Room) RelayDTMF(sender *Client, msg *Message) error {
+	digits, _ := msg.Data["digits"].(string)
+	digits = strings.ToUpper(digits)
+	if digits == "" || len(digits) > maxDTMFDigits {
+		return fmt.Errorf("dtmf needs 1 to %d digits", maxDTMFDigits)
+	}
+	for _, digit := range digits {
+		if !strings.ContainsRune("0123456789*#ABCD,", digit) {
+			return fmt.Errorf("invalid dtmf digit %q", digit)
+		}
+	}
+	duration := defaultDTMFDuration
+	if value, ok := msg.Data["duration"].(float64); ok {
+		duration = min(max(int(value), minDTMFDuration), maxDTMFDuration)
+	}
+
+	relayed := &Message{
+		Type:    "dtmf",
+		From:    sender.ID,
+		To:      msg.To,
+		TraceID: msg.TraceID,
+		Data: map[string]interface{}{
+			"digits":   digits,
+			"duration": duration,
+		},
+	}
+	if msg.To == "" {
+		r.Broadcast(relayed, sender.ID)
+		return nil
+	}
+	if err := r.SendTo(relayed); err != nil {
+		return fmt.Errorf("recipient %s not found", msg.To)
+	}
+	return nil
+}
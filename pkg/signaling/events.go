@@ -0,0 +1,113 @@
+package signaling
+
+import "time"
+
+// Event types emitted by the hub
+const (
+	// A room was created
+	EventRoomCreated = "room-created"
+
+	// A room was closed and removed from the hub
+	EventRoomDestroyed = "room-destroyed"
+
+	// A client joined or left a room
+	EventUserJoined = "user-joined"
+	EventUserLeft   = "user-left"
+
+	// Someone was invited to a room
+	EventRoomInvite = "room-invite"
+
+	// Someone is waiting to be let into a room
+	EventLobbyWaiting = "lobby-waiting"
+
+	// A participant consented and their recording began
+	EventRecordingStarted = "recording-started"
+
+	// A recording finished and can be downloaded
+	EventRecordingReady = "recording-ready"
+
+	// The last participant left and the meeting record is final
+	EventMeetingEnded = "meeting-ended"
+
+	// A finished meeting was summarized
+	EventMeetingSummary = "meeting-summary"
+
+	// A keyword the host watches for was spoken
+	EventKeywordDetected = "keyword-detected"
+
+	// A participant's client detected a local screenshot or screen recording
+	EventScreenCapture = "screen-capture"
+
+	// A client keeps failing to connect to a peer and was told to use TURN
+	EventTURNRequired = "turn-required"
+
+	// A party put a 1:1 call on hold or resumed it; the room is the call's
+	EventCallHeld    = "call-held"
+	EventCallResumed = "call-resumed"
+)
+
+// Event is something operators or integrations may want to know about
+type Event struct {
+	Type     string                 `json:"type"`
+	RoomID   string                 `json:"roomId"`
+	Tenant   string                 `json:"tenant,omitempty"`
+	ClientID string                 `json:"clientId,omitempty"`
+	At       time.Time              `json:"at"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventHandler receives hub events. Handlers run on the goroutine that
+// emitted the event and must not block
+type EventHandler func(Event)
+
+// OnEvent registers a handler for all events of the hub
+func (h *Hub) OnEvent(handler EventHandler) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.eventHandlers = append(h.eventHandlers, handler)
+}
+
+// emit sends an event to all handlers. It must not be called with room or
+// client locks held
+func (h *Hub) emit(event Event) {
+	if h == nil {
+		return
+	}
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	if event.Tenant == "" && event.RoomID != "" {
+		if room := h.FindRoom(event.RoomID); room != nil {
+			event.Tenant = room.Settings().Tenant
+		}
+	}
+
+	h.roomsMutex.RLock()
+	handlers := h.eventHandlers
+	h.roomsMutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// emitChanges emits the joins and leaves flushed from a room, and its
+// removal once it closes. Events of a room are emitted in order
+func (r *Room) emitChanges(tenant string, transitions []RoomTransition, changes []memberChange) {
+	for _, change := range changes {
+		event := Event{Type: EventUserJoined, RoomID: r.ID, Tenant: tenant, ClientID: change.member.ClientID}
+		if change.left {
+			event.Type = EventUserLeft
+			event.Data = map[string]interface{}{"reason": change.reason}
+		} else {
+			event.At = change.member.JoinedAt
+			event.Data = map[string]interface{}{"userId": change.member.UserID, "deviceId": change.member.DeviceID}
+		}
+		r.hub.emit(event)
+	}
+	for _, transition := range transitions {
+		if transition.To == RoomClosed {
+			r.hub.emit(Event{Type: EventRoomDestroyed, RoomID: r.ID, Tenant: tenant, At: transition.At})
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// MessageHandler processes a message of a custom type from a client.
+// Returning an error rejects the message: the client gets an "error"
+// message and nothing is relayed
+type MessageHandler func(client *Client, msg *Message) error
+
+// RelayPolicy says where a custom message goes once its handler accepts it
+type RelayPolicy string
+
+// Relay policies of custom message types
+const (
+	RelayNone   RelayPolicy = "none"   // Only the handler sees the message
+	RelayRoom   RelayPolicy = "room"   // To the rest of the room, or to the recipient in To
+	RelayDirect RelayPolicy = "direct" // To the recipient in To; messages without one are rejected
+)
+
+// errNoRecipient rejects direct custom messages without a recipient
+var errNoRecipient = errors.New("the message needs a recipient in to")
+
+// messageExtension is a custom message type registered by an embedder
+type messageExtension struct {
+	policy  RelayPolicy
+	handler MessageHandler
+}
+
+// HandleMessageType registers a custom message type, e.g. for
+// application-specific messages of an embedding app. The handler, if any,
+// runs first; messages it accepts are relayed per the policy. Types the
+// server handles itself always take precedence, and registering a type again
+// replaces its handler
+func (h *Hub) HandleMessageType(msgType string, policy RelayPolicy, handler MessageHandler) error {
+	if msgType == "" {
+		return errors.New("message type is required")
+	}
+	switch policy {
+	case RelayNone:
+		if handler == nil {
+			return fmt.Errorf("%s: a handler is required when messages aren't relayed", msgType)
+		}
+	case RelayRoom, RelayDirect:
+	default:
+		return fmt.Errorf("%s: unknown relay policy %q", msgType, policy)
+	}
+
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	if h.extensions == nil {
+		h.extensions = make(map[string]messageExtension)
+	}
+	h.extensions[msgType] = messageExtension{policy: policy, handler: handler}
+	util.Info("Registered message type %s (relay: %s)", msgType, policy)
+	return nil
+}
+
+// extension returns the registration of a custom message type
+func (h *Hub) extension(msgType string) (messageExtension, bool) {
+	if h == nil {
+		return messageExtension{}, false
+	}
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+	extension, found := h.extensions[msgType]
+	return extension, found
+}
+
+// handleCustom processes a message of a registered custom type, reporting
+// whether the type is registered
+func (c *Client) handleCustom(msg *Message) bool {
+	extension, found := c.hub.extension(msg.Type)
+	if !found {
+		return false
+	}
+
+	if extension.policy == RelayDirect && msg.To == "" {
+		c.reject(msg, errNoRecipient)
+		return true
+	}
+	if extension.handler != nil {
+		if err := extension.handler(c, msg); err != nil {
+			util.Warn("Rejected %s from client %s: %v", msg.Type, c.ID, err)
+			c.reject(msg, err)
+			return true
+		}
+	}
+
+	switch {
+	case extension.policy == RelayNone:
+	case msg.To == "":
+		c.Room.Broadcast(msg, c.ID)
+	default:
+		if err := c.Room.SendTo(msg); err != nil {
+			c.reject(msg, err)
+		}
+	}
+	return true
+}
@@ -0,0 +1,240 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// ErrFederationNotFound is returned for chat federations the hub doesn't have
+var ErrFederationNotFound = errors.New("chat federation not found")
+
+// ChatFederation is a set of rooms sharing one chat stream, such as an event
+// room with its overflow rooms or a main room with its breakout rooms. Chat
+// in any of the rooms reaches all of them, tagged with the room it was sent in
+type ChatFederation struct {
+	ID    string   `json:"id"`
+	Rooms []string `json:"rooms"`
+
+	// Created for an event room's overflow rooms rather than by an operator
+	Overflow bool `json:"overflow,omitempty"`
+}
+
+// federations tracks the chat federations of a hub
+type federations struct {
+	mutex  sync.RWMutex
+	byID   map[string]*ChatFederation
+	byRoom map[string]string // Federation ID by room ID
+}
+
+// FederateChat makes the given rooms share one chat stream under the
+// federation ID, replacing the federation's rooms if it exists. Rooms don't
+// need to exist yet, but existing ones must belong to the same tenant, and a
+// room can only be in one federation
+func (h *Hub) FederateChat(id string, roomIDs []string) (ChatFederation, error) {
+	if id == "" {
+		return ChatFederation{}, errors.New("federation ID is required")
+	}
+	rooms := uniqueRoomIDs(roomIDs)
+	if len(rooms) < 2 {
+		return ChatFederation{}, errors.New("a federation needs at least two rooms")
+	}
+	var tenant *string
+	for _, roomID := range rooms {
+		room := h.FindRoom(roomID)
+		if room == nil {
+			continue
+		}
+		t := room.Settings().Tenant
+		if tenant != nil && t != *tenant {
+			return ChatFederation{}, fmt.Errorf("room %s belongs to another tenant", roomID)
+		}
+		tenant = &t
+	}
+
+	h.federations.mutex.Lock()
+	defer h.federations.mutex.Unlock()
+	for _, roomID := range rooms {
+		if other, exists := h.federations.byRoom[roomID]; exists && other != id {
+			return ChatFederation{}, fmt.Errorf("room %s already shares chat in %s", roomID, other)
+		}
+	}
+
+	if previous := h.federations.byID[id]; previous != nil {
+		for _, roomID := range previous.Rooms {
+			delete(h.federations.byRoom, roomID)
+		}
+	}
+	federation := &ChatFederation{ID: id, Rooms: rooms}
+	h.federations.byID[id] = federation
+	for _, roomID := range rooms {
+		h.federations.byRoom[roomID] = id
+	}
+	util.Info("Chat federation %s links rooms %v", id, rooms)
+	return *federation, nil
+}
+
+// DissolveChatFederation stops the rooms of a federation from sharing chat
+func (h *Hub) DissolveChatFederation(id string) error {
+	h.federations.mutex.Lock()
+	defer h.federations.mutex.Unlock()
+
+	federation, exists := h.federations.byID[id]
+	if !exists {
+		return ErrFederationNotFound
+	}
+	for _, roomID := range federation.Rooms {
+		delete(h.federations.byRoom, roomID)
+	}
+	delete(h.federations.byID, id)
+	util.Info("Chat federation %s dissolved", id)
+	return nil
+}
+
+// ChatFederations returns all chat federations, ordered by ID
+func (h *Hub) ChatFederations() []ChatFederation {
+	h.federations.mutex.RLock()
+	defer h.federations.mutex.RUnlock()
+
+	list := make([]ChatFederation, 0, len(h.federations.byID))
+	for _, federation := range h.federations.byID {
+		list = append(list, copyFederation(federation))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// ChatFederationOf returns the federation a room shares chat in, if any
+func (h *Hub) ChatFederationOf(roomID string) (ChatFederation, bool) {
+	h.federations.mutex.RLock()
+	defer h.federations.mutex.RUnlock()
+
+	federation, exists := h.federations.byID[h.federations.byRoom[roomID]]
+	if !exists {
+		return ChatFederation{}, false
+	}
+	return copyFederation(federation), true
+}
+
+// federateOverflow adds a new overflow room to its event room's federation,
+// creating one named after the event room if it has none
+func (h *Hub) federateOverflow(mainID, roomID string) {
+	h.federations.mutex.Lock()
+	defer h.federations.mutex.Unlock()
+
+	id, exists := h.federations.byRoom[mainID]
+	if !exists {
+		id = mainID
+		if _, taken := h.federations.byID[id]; taken {
+			id = mainID + "-overflow"
+		}
+		h.federations.byID[id] = &ChatFederation{ID: id, Rooms: []string{mainID}, Overflow: true}
+		h.federations.byRoom[mainID] = id
+	}
+	federation := h.federations.byID[id]
+	federation.Rooms = append(federation.Rooms, roomID)
+	h.federations.byRoom[roomID] = id
+}
+
+// leaveFederation takes a closed overflow room out of its federation and
+// dissolves federations created for overflow rooms once none is left.
+// Rooms an operator federated stay, since they may be opened again
+func (h *Hub) leaveFederation(room *Room) {
+	if room.overflowOf == "" {
+		return
+	}
+	h.federations.mutex.Lock()
+	defer h.federations.mutex.Unlock()
+
+	id, exists := h.federations.byRoom[room.ID]
+	if !exists {
+		return
+	}
+	federation := h.federations.byID[id]
+	delete(h.federations.byRoom, room.ID)
+	for i, roomID := range federation.Rooms {
+		if roomID == room.ID {
+			federation.Rooms = append(federation.Rooms[:i:i], federation.Rooms[i+1:]...)
+			break
+		}
+	}
+	if federation.Overflow && len(federation.Rooms) < 2 {
+		for _, roomID := range federation.Rooms {
+			delete(h.federations.byRoom, roomID)
+		}
+		delete(h.federations.byID, id)
+	}
+}
+
+// chatPeers returns the open rooms sharing chat with the room
+func (h *Hub) chatPeers(room *Room) []*Room {
+	if h == nil {
+		return nil
+	}
+	federation, exists := h.ChatFederationOf(room.ID)
+	if !exists {
+		return nil
+	}
+	peers := make([]*Room, 0, len(federation.Rooms)-1)
+	for _, roomID := range federation.Rooms {
+		if roomID == room.ID {
+			continue
+		}
+		if peer := h.FindRoom(roomID); peer != nil {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// broadcastChat sends a chat message to the room and, unless it is addressed
+// to one participant, to every room federated with it and the room's chat
+// history. Federated chat carries the room it was sent in as originRoomId
+func (r *Room) broadcastChat(msg *Message) {
+	var peers []*Room
+	if msg.To == "" {
+		peers = r.hub.chatPeers(r)
+	}
+	if len(peers) == 0 {
+		r.Broadcast(msg, "")
+		r.storeChat(msg)
+		return
+	}
+
+	data := make(map[string]interface{}, len(msg.Data)+1)
+	for key, value := range msg.Data {
+		data[key] = value
+	}
+	data["originRoomId"] = r.ID
+	msg.Data = data
+	r.Broadcast(msg, "")
+	r.storeChat(msg)
+
+	util.Debug("Sharing chat from room %s with %d federated rooms", r.ID, len(peers))
+	for _, peer := range peers {
+		peer.Broadcast(&Message{Type: msg.Type, From: msg.From, Data: data}, "")
+	}
+}
+
+// uniqueRoomIDs drops empty and repeated room IDs, keeping their order
+func uniqueRoomIDs(roomIDs []string) []string {
+	seen := make(map[string]bool, len(roomIDs))
+	unique := make([]string, 0, len(roomIDs))
+	for _, roomID := range roomIDs {
+		if roomID != "" && !seen[roomID] {
+			seen[roomID] = true
+			unique = append(unique, roomID)
+		}
+	}
+	return unique
+}
+
+// copyFederation copies a federation so callers can't change the hub's
+func copyFederation(federation *ChatFederation) ChatFederation {
+	copied := *federation
+	copied.Rooms = append([]string(nil), federation.Rooms...)
+	return copied
+}
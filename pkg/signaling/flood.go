@@ -0,0 +1,70 @@
+package signaling
+
+import (
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/ratelimit"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+const (
+	// Warnings a client sending over its rate limit gets; it is
+	// disconnected when it goes over once more
+	maxRateWarnings = 3
+
+	// Least time between two warnings, so a flood draws one per interval
+	rateWarningInterval = time.Second
+
+	// Time without going over the limit after which warnings are forgotten
+	rateWarningReset = time.Minute
+)
+
+// messageLimit holds a client's message rate limit and how often it went
+// over it
+type messageLimit struct {
+	bucket      *ratelimit.Bucket
+	warnings    int
+	lastWarning time.Time
+}
+
+// allowMessage takes one message from the client's rate limit. Messages
+// over it are dropped, and the client gets a rate-limited warning at most
+// once per rateWarningInterval; after maxRateWarnings it is disconnected
+// with CloseRateLimited
+func (c *Client) allowMessage(now time.Time) bool {
+	limit := &c.messageLimit
+	if limit.bucket == nil {
+		limit.bucket = ratelimit.NewBucket(float64(messageRate), float64(messageBurst), now)
+	}
+	if limit.bucket.Take(1, now) {
+		return true
+	}
+
+	if limit.warnings > maxRateWarnings || now.Sub(limit.lastWarning) < rateWarningInterval {
+		return false
+	}
+	if now.Sub(limit.lastWarning) >= rateWarningReset {
+		limit.warnings = 0
+	}
+	limit.warnings++
+	limit.lastWarning = now
+	warning := &Message{
+		Type: "rate-limited",
+		To:   c.ID,
+		Data: map[string]interface{}{
+			"rate":        messageRate,
+			"burst":       messageBurst,
+			"warnings":    limit.warnings,
+			"maxWarnings": maxRateWarnings,
+			"disconnect":  limit.warnings > maxRateWarnings,
+		},
+	}
+	if limit.warnings > maxRateWarnings {
+		util.Warn("Disconnecting client %s for sending over %d messages per second", c.ID, messageRate)
+		c.dismiss(CloseRateLimited, "rate limited", warning)
+		return false
+	}
+	util.Warn("Client %s is sending over %d messages per second (warning %d of %d)", c.ID, messageRate, limit.warnings, maxRateWarnings)
+	c.Send(warning)
+	return false
+}
@@ -0,0 +1,198 @@
+package signaling
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Health statuses of a room
+const (
+	HealthGood = "good"
+	HealthFair = "fair"
+	HealthPoor = "poor"
+)
+
+// Packet loss at or above this fraction takes the full loss penalty
+const maxPenalizedLoss = 0.2
+
+// RoomHealth summarizes how well a call is going, from 0 (unusable) to 100
+type RoomHealth struct {
+	Score             int     `json:"score"`
+	Status            string  `json:"status"`
+	ConnectionSuccess float64 `json:"connectionSuccess"` // Share of peer connections without ICE failures
+	PacketLoss        float64 `json:"packetLoss"`        // Average of the latest loss reported by each client
+	Reconnects        int     `json:"reconnects"`
+	Recommendation    string  `json:"recommendation,omitempty"` // "audio-only" or "restart"
+}
+
+// healthTracker collects the inputs of a room's health score
+type healthTracker struct {
+	mutex       sync.Mutex
+	joins       int
+	reconnects  int
+	iceFailures int
+	seen        map[string]bool
+	loss        map[string]float64 // Latest reported packet loss per client
+}
+
+// recordJoin counts a join; clients joining under an ID seen before are reconnects
+func (t *healthTracker) recordJoin(clientID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.seen == nil {
+		t.seen = make(map[string]bool)
+	}
+	t.joins++
+	if t.seen[clientID] {
+		t.reconnects++
+	}
+	t.seen[clientID] = true
+}
+
+// recordLeave drops the loss sample of a client that left
+func (t *healthTracker) recordLeave(clientID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.loss, clientID)
+}
+
+// recordICEFailure counts a failed peer connection
+func (t *healthTracker) recordICEFailure() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.iceFailures++
+}
+
+// recordLoss stores the packet loss fraction a client reported in its stats
+func (t *healthTracker) recordLoss(clientID string, loss float64) {
+	if math.IsNaN(loss) || loss < 0 {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.loss == nil {
+		t.loss = make(map[string]float64)
+	}
+	t.loss[clientID] = math.Min(loss, 1)
+}
+
+// score computes the room's health
+func (t *healthTracker) score() RoomHealth {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	health := RoomHealth{ConnectionSuccess: 1, Reconnects: t.reconnects}
+	if attempts := t.joins + t.iceFailures; attempts > 0 && t.iceFailures > 0 {
+		health.ConnectionSuccess = float64(t.joins) / float64(attempts)
+	}
+	for _, loss := range t.loss {
+		health.PacketLoss += loss
+	}
+	if len(t.loss) > 0 {
+		health.PacketLoss /= float64(len(t.loss))
+	}
+
+	// Failed connections and packet loss weigh the most; each reconnect
+	// costs a little, up to a cap
+	failurePenalty := (1 - health.ConnectionSuccess) * 40
+	lossPenalty := math.Min(health.PacketLoss/maxPenalizedLoss, 1) * 40
+	reconnectPenalty := math.Min(float64(t.reconnects)*2, 20)
+	health.Score = int(math.Round(math.Max(0, 100-failurePenalty-lossPenalty-reconnectPenalty)))
+
+	switch {
+	case health.Score >= 80:
+		health.Status = HealthGood
+	case health.Score >= 50:
+		health.Status = HealthFair
+	default:
+		health.Status = HealthPoor
+	}
+	if health.Status != HealthGood {
+		if lossPenalty >= failurePenalty+reconnectPenalty {
+			health.Recommendation = "audio-only"
+		} else {
+			health.Recommendation = "restart"
+		}
+	}
+	return health
+}
+
+// Health returns the room's current health score
+func (r *Room) Health() RoomHealth {
+	return r.health.score()
+}
+
+// recordStats takes the packet loss out of a client's stats message. Clients
+// report it as a fraction in data.packetLoss
+func (r *Room) recordStats(clientID string, msg *Message) {
+	if loss, ok := msg.Data["packetLoss"].(float64); ok {
+		r.health.recordLoss(clientID, loss)
+	}
+}
+
+// sendHealth sends the room's health to its host
+func (r *Room) sendHealth() {
+	hostID := r.GetHost()
+	if hostID == "" {
+		return
+	}
+	r.clientMutex.RLock()
+	host := r.clients[hostID]
+	r.clientMutex.RUnlock()
+	if host == nil {
+		return
+	}
+
+	health := r.Health()
+	host.Send(&Message{
+		Type: "room-health",
+		To:   hostID,
+		Data: map[string]interface{}{
+			"roomId": r.ID,
+			"health": health,
+		},
+	})
+}
+
+// StartHealthReports sends every active room's health to its host at the
+// given interval until the returned stop function is called
+func (h *Hub) StartHealthReports(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, room := range h.activeRooms() {
+					room.sendHealth()
+				}
+			}
+		}
+	}()
+	util.Info("Sending room health to hosts every %v", interval)
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// activeRooms returns the rooms that currently have participants
+func (h *Hub) activeRooms() []*Room {
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		if room.State() == RoomActive {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms
+}
@@ -0,0 +1,101 @@
+package signaling
+
+import (
+	"errors"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// SetHoldMusic tells clients whether the server plays music into the rooms
+// of calls on hold
+func (h *Hub) SetHoldMusic(enabled bool) {
+	h.calls.mutex.Lock()
+	defer h.calls.mutex.Unlock()
+	h.calls.holdMusic = enabled
+}
+
+// HoldCall puts the client's active call on hold or resumes it. Only the
+// party who held a call can resume it. Both parties get call-held or
+// call-resumed, and the call's room gets participant-hold for its roster
+func (c *Client) HoldCall(callID string, hold bool) (Call, error) {
+	h := c.hub
+	h.calls.mutex.Lock()
+	call := h.calls.byID[callID]
+	if call == nil || call.State != CallActive || !call.isParty(c) {
+		h.calls.mutex.Unlock()
+		return Call{}, ErrCallNotFound
+	}
+	var err error
+	switch {
+	case hold && call.HeldBy != "":
+		err = errors.New("call is already on hold")
+	case !hold && call.HeldBy == "":
+		err = errors.New("call is not on hold")
+	case !hold && call.HeldBy != c.userKey():
+		err = errors.New("only the party who held the call can resume it")
+	}
+	if err != nil {
+		h.calls.mutex.Unlock()
+		return Call{}, err
+	}
+	if hold {
+		call.HeldBy = c.userKey()
+	} else {
+		call.HeldBy = ""
+	}
+	held := call.Caller
+	if c.userKey() == call.Caller {
+		held = call.Callee
+	}
+	music := hold && h.calls.holdMusic
+	info := call.info()
+	h.calls.mutex.Unlock()
+
+	msgType, event := "call-resumed", EventCallResumed
+	if hold {
+		msgType, event = "call-held", EventCallHeld
+	}
+	util.Info("Call %s %s by %s", call.ID, msgType[len("call-"):], c.ID)
+
+	// Hold music starts before the parties hear about it, so the held
+	// party's player finds the track when it subscribes
+	h.emit(Event{Type: event, RoomID: call.RoomID, ClientID: c.ID, Data: map[string]interface{}{
+		"callId": call.ID,
+		"by":     c.userKey(),
+	}})
+
+	data := call.data()
+	data["by"] = c.userKey()
+	data["roomId"] = call.RoomID
+	if music {
+		data["holdMusic"] = true
+	}
+	for _, party := range []*Client{call.caller, call.answerer} {
+		party.Send(&Message{Type: msgType, To: party.ID, Data: data})
+	}
+	if room := h.FindRoom(call.RoomID); room != nil {
+		room.Broadcast(&Message{Type: "participant-hold", Data: map[string]interface{}{
+			"accountId": held,
+			"onHold":    hold,
+		}}, "")
+	}
+	return info, nil
+}
+
+// heldParty returns the user put on hold in a call's room, or ""
+func (h *Hub) heldParty(roomID string) string {
+	if h == nil {
+		return ""
+	}
+	h.calls.mutex.Lock()
+	defer h.calls.mutex.Unlock()
+
+	call := h.calls.byID[h.calls.byRoom[roomID]]
+	if call == nil || call.HeldBy == "" {
+		return ""
+	}
+	if call.HeldBy == call.Caller {
+		return call.Callee
+	}
+	return call.Caller
+}
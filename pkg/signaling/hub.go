@@ -2,6 +2,7 @@ package signaling
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/nikhilsahni7/chat-video-app/pkg/util"
 )
@@ -11,41 +12,259 @@ type Hub struct {
 	// Registered rooms with their participants
 	rooms      map[string]*Room
 	roomsMutex sync.RWMutex
+
+	// Settings applied to newly created rooms
+	defaultSettings RoomSettings
+
+	// Hooks run on room state changes
+	hooks []RoomHook
+
+	// Where persistent rooms are saved, if anywhere
+	store RoomStore
+
+	// Where recording metadata is saved, if anywhere
+	recordingStore RecordingStore
+
+	// Records rooms in SFU mode on the server, if configured
+	callRecorder CallRecorder
+
+	// Translates captions into other languages, if configured
+	translator Translator
+
+	// Forwards the media of rooms in SFU mode, if configured
+	media MediaServer
+
+	// Where meeting records are saved and how they are summarized, if at all
+	meetingStore MeetingStore
+	summarizer   Summarizer
+
+	// Compliance policies by tenant and the audit log they record to
+	compliance map[string]*CompliancePolicy
+	auditLog   AuditLog
+
+	// Directory for signal traces of rooms with tracing enabled
+	traceDir string
+
+	// Delivery events of messages that carry a trace ID
+	deliveries *deliveryLog
+
+	// Errors reported for rooms that don't exist
+	errors clientErrorLog
+
+	// Signaling traffic of all rooms, for metrics
+	traffic hubTraffic
+
+	// TURN servers suggested to clients that can't connect directly
+	turn TURNConfig
+
+	// Configuration pushed to clients and its version
+	clientConfig        ClientConfig
+	clientConfigVersion int
+
+	// Health of the ICE servers handed to clients, by URL
+	iceHealth map[string]*ICEServerStatus
+
+	// Handlers for hub events
+	eventHandlers []EventHandler
+
+	// Custom message types registered by the embedding app
+	extensions map[string]messageExtension
+
+	// Overflow rooms of event rooms, by the event room's ID
+	overflows map[string][]string
+
+	// Rooms sharing one chat stream
+	federations federations
+
+	// Ringing and active 1:1 calls
+	calls calls
+
+	// Where room membership and state are shared, and this node's name
+	state StateStore
+	node  string
+
+	// Where chat history is kept, if anywhere
+	chat ChatStore
+
+	// Clients by the token a new connection resumes them with
+	resumes resumeTokens
+
+	// Log of room events, if enabled, and the handlers of its events
+	eventLog          EventLog
+	roomEventHandlers []func(RoomEvent)
+
+	// Relays client traffic between nodes, if clustered, and the nodes
+	// heard from through it
+	backplane Backplane
+	cluster   clusterView
+
+	// Set once Shutdown starts; new joins are refused
+	draining atomic.Bool
 }
 
 // NewHub creates a new Hub instance
 func NewHub() *Hub {
 	hub := &Hub{
-		rooms: make(map[string]*Room),
+		rooms:           make(map[string]*Room),
+		defaultSettings: DefaultRoomSettings(),
+		deliveries:      newDeliveryLog(),
+		overflows:       make(map[string][]string),
+		federations: federations{
+			byID:   make(map[string]*ChatFederation),
+			byRoom: make(map[string]string),
+		},
+		calls: newCalls(),
+		state: NewMemoryStore(),
+		chat:  NewMemoryChatStore(DefaultChatHistory),
 	}
 	util.Info("Hub initialized")
 	return hub
 }
 
+// SetDefaultSettings sets the settings applied to rooms created from now on
+func (h *Hub) SetDefaultSettings(settings RoomSettings) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.defaultSettings = settings
+}
+
+// DefaultSettings returns the settings new rooms start with
+func (h *Hub) DefaultSettings() RoomSettings {
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+	return h.defaultSettings
+}
+
 // GetRoom returns a room by ID, creating it if it doesn't exist
 func (h *Hub) GetRoom(roomID string) *Room {
+	return h.GetRoomWithSettings(roomID, nil)
+}
+
+// GetRoomWithSettings returns a room by ID, creating it if it doesn't exist.
+// The configure callback is only applied when the room is newly created, so
+// the first participant decides the room's settings
+func (h *Hub) GetRoomWithSettings(roomID string, configure func(*RoomSettings)) *Room {
+	room, _ := h.getOrCreateRoom(roomID, configure)
+	return room
+}
+
+// getOrCreateRoom returns a room and whether this call created it
+func (h *Hub) getOrCreateRoom(roomID string, configure func(*RoomSettings)) (*Room, bool) {
 	h.roomsMutex.Lock()
-	defer h.roomsMutex.Unlock()
 
 	room, exists := h.rooms[roomID]
 	if !exists {
-		room = NewRoom(roomID)
-		h.rooms[roomID] = room
-		util.Info("Created new room: %s", roomID)
+		settings := h.defaultSettings
+		if configure != nil {
+			configure(&settings)
+		}
+		room = h.createRoomLocked(roomID, settings)
+	}
+	h.roomsMutex.Unlock()
+
+	if !exists {
+		room.flushChanges()
+		h.emit(Event{Type: EventRoomCreated, RoomID: roomID})
+	}
+	return room, !exists
+}
+
+// createRoomLocked registers a new room with the given settings. The caller
+// must hold roomsMutex and flush the room's changes once it is released
+func (h *Hub) createRoomLocked(roomID string, settings RoomSettings) *Room {
+	room := NewRoom(roomID)
+	room.settings = settings
+	room.hooks = h.hooks
+	room.store = h.store
+	room.recordingStore = h.recordingStore
+	room.translator = h.translator
+	room.media = h.media
+	room.meetingStore = h.meetingStore
+	room.summarizer = h.summarizer
+	room.compliance = h.compliance[settings.Tenant]
+	room.auditLog = h.auditLog
+	room.hub = h
+	room.deliveries = h.deliveries
+	room.stateStore = h.state
+	room.node = h.node
+	room.backplane = h.backplane
+	if h.eventLog != nil {
+		room.eventLog = h.eventLog
+		room.view = &RoomView{RoomID: roomID}
+	}
+	if settings.Trace && h.traceDir != "" {
+		room.trace = newSignalTrace(h.traceDir, roomID)
 	}
+	room.transitionLocked(RoomCreated)
+	room.dirty = true
+	h.rooms[roomID] = room
+	util.Info("Created new room: %s", roomID)
 	return room
 }
 
-// RemoveRoom removes a room when it's empty
+// FindRoom returns a room by ID, or nil if it doesn't exist
+func (h *Hub) FindRoom(roomID string) *Room {
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+	return h.rooms[roomID]
+}
+
+// LocateClient returns the room a client is connected to on this node, or
+// nil if it isn't in any
+func (h *Hub) LocateClient(clientID string) *Room {
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+	for _, room := range h.rooms {
+		room.clientMutex.RLock()
+		_, found := room.clients[clientID]
+		room.clientMutex.RUnlock()
+		if found {
+			return room
+		}
+	}
+	return nil
+}
+
+// RemoveRoom removes a room when it's empty. Persistent rooms stay
+// registered and go back to the created state
 func (h *Hub) RemoveRoom(roomID string) {
+	h.removeRoom(roomID, false)
+}
+
+// removeRoom removes an empty room; force also removes persistent rooms
+func (h *Hub) removeRoom(roomID string, force bool) {
 	h.roomsMutex.Lock()
-	defer h.roomsMutex.Unlock()
 
-	if room, exists := h.rooms[roomID]; exists {
-		if room.IsEmpty() {
-			delete(h.rooms, roomID)
-			util.Info("Removed empty room: %s", roomID)
+	room, exists := h.rooms[roomID]
+	changed, closed := false, false
+	if exists {
+		room.clientMutex.Lock()
+		// Clients in the waiting room keep the room until they leave
+		if len(room.clients) == 0 && (force || len(room.lobby) == 0) {
+			changed = true
+			if room.settings.Persistent && !force {
+				room.transitionLocked(RoomCreated)
+				util.Info("Keeping empty persistent room: %s", roomID)
+			} else {
+				room.transitionLocked(RoomClosed)
+				closed = true
+				room.closeTrace()
+				room.stop()
+				delete(h.rooms, roomID)
+				h.unlinkOverflowLocked(room)
+				h.leaveFederation(room)
+				util.Info("Removed empty room: %s", roomID)
+			}
 		}
+		room.clientMutex.Unlock()
+	}
+	h.roomsMutex.Unlock()
+
+	if changed {
+		room.flushChanges()
+	}
+	if closed {
+		h.endCallInRoom(roomID)
 	}
 }
 
@@ -61,3 +280,26 @@ func (h *Hub) GetActiveRooms() []string {
 	util.Debug("GetActiveRooms returning %d rooms", len(rooms))
 	return rooms
 }
+
+// DisconnectUser closes every connection of a verified user, e.g. when their
+// account is deprovisioned, and returns how many were closed
+func (h *Hub) DisconnectUser(userID, reason string) int {
+	h.roomsMutex.RLock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.roomsMutex.RUnlock()
+
+	closed := 0
+	for _, room := range rooms {
+		for _, client := range room.GetClients() {
+			if client.Verified && client.UserID == userID {
+				util.Info("Disconnecting client %s of user %s from room %s: %s", client.ID, userID, room.ID, reason)
+				client.CloseWithReason(CloseRevoked, reason)
+				closed++
+			}
+		}
+	}
+	return closed
+}
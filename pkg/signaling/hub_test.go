@@ -1,7 +1,18 @@
 package signaling
 
 import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 func TestNewHub(t *testing.T) {
@@ -117,3 +128,1312 @@ func TestGetActiveRooms(t *testing.T) {
 		t.Error("Expected to find room2 in active rooms list")
 	}
 }
+
+func TestRoomLifecycleHooks(t *testing.T) {
+	hub := NewHub()
+
+	var states []RoomState
+	hub.OnRoomTransition(func(room *Room, transition RoomTransition) {
+		states = append(states, transition.To)
+	})
+
+	room := hub.GetRoom("test-room")
+	client := &Client{ID: "test-client"}
+	room.AddClient(client)
+	room.RemoveClient(client.ID)
+	hub.RemoveRoom(room.ID)
+
+	expected := []RoomState{RoomCreated, RoomActive, RoomEnding, RoomClosed}
+	if len(states) != len(expected) {
+		t.Fatalf("Expected transitions %v, got %v", expected, states)
+	}
+	for i, state := range expected {
+		if states[i] != state {
+			t.Errorf("Expected transition %d to be %s, got %s", i, state, states[i])
+		}
+	}
+
+	// A closed room refuses joins so callers fetch a fresh one
+	if _, err := room.Join(&Client{ID: "late"}); err != ErrRoomClosed {
+		t.Errorf("Expected ErrRoomClosed, got %v", err)
+	}
+}
+
+func TestCloseRoom(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("test-room")
+	room.AddClient(&Client{ID: "client1", Room: room, hub: hub})
+	room.AddClient(&Client{ID: "client2", Room: room, hub: hub})
+
+	if !hub.CloseRoom("test-room", "ended by test") {
+		t.Fatal("Expected CloseRoom to find the room")
+	}
+	if room.State() != RoomClosed {
+		t.Errorf("Expected room to be closed, got %s", room.State())
+	}
+	if _, exists := hub.rooms["test-room"]; exists {
+		t.Error("Expected closed room to be removed from the hub")
+	}
+	if hub.CloseRoom("test-room", "again") {
+		t.Error("Expected CloseRoom on a missing room to report false")
+	}
+}
+
+// memoryMeetingStore keeps the latest version of each meeting record
+type memoryMeetingStore struct {
+	mutex    sync.Mutex
+	meetings map[string]Meeting
+}
+
+func (s *memoryMeetingStore) SaveMeeting(meeting Meeting) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.meetings[meeting.ID] = meeting
+	return nil
+}
+
+func (s *memoryMeetingStore) get(id string) Meeting {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.meetings[id]
+}
+
+// fixedSummarizer returns the same summary for every meeting
+type fixedSummarizer struct{}
+
+func (fixedSummarizer) Summarize(ctx context.Context, meeting Meeting) (MeetingSummary, error) {
+	return MeetingSummary{Summary: "Discussed " + meeting.Transcript[0].Text, ActionItems: []string{"Ship it"}}, nil
+}
+
+func TestMeetingSummary(t *testing.T) {
+	hub := NewHub()
+	store := &memoryMeetingStore{meetings: make(map[string]Meeting)}
+	hub.SetMeetingStore(store)
+	hub.SetSummarizer(fixedSummarizer{})
+	summaries := make(chan Event, 1)
+	hub.OnEvent(func(event Event) {
+		if event.Type == EventMeetingSummary {
+			summaries <- event
+		}
+	})
+
+	room := hub.GetRoomWithSettings("standup", func(settings *RoomSettings) {
+		settings.Transcription = true
+	})
+	alice := &Client{ID: "alice", Room: room, hub: hub, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: room, hub: hub, send: make(chan *Message, 10)}
+	room.AddClient(alice)
+	room.AddClient(bob)
+
+	meeting, running := room.Meeting()
+	if !running || len(meeting.Participants) != 2 {
+		t.Fatalf("Expected a running meeting with 2 participants, got %+v", meeting)
+	}
+	if saved := store.get(meeting.ID); saved.ID == "" || !saved.EndedAt.IsZero() {
+		t.Errorf("Expected the started meeting to be saved, got %+v", saved)
+	}
+
+	room.PublishCaption(Caption{Speaker: "alice", Text: "the release", Lang: "en", Final: true})
+	room.RemoveClient(bob.ID)
+	room.RemoveClient(alice.ID)
+	hub.RemoveRoom(room.ID)
+
+	select {
+	case event := <-summaries:
+		if event.Data["meetingId"] != meeting.ID || event.Data["summary"] != "Discussed the release" {
+			t.Errorf("Expected the meeting's summary, got %+v", event.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a meeting-summary event")
+	}
+
+	saved := store.get(meeting.ID)
+	if saved.EndedAt.IsZero() || len(saved.Transcript) != 1 || saved.Summary == nil || len(saved.Summary.ActionItems) != 1 {
+		t.Errorf("Expected an ended meeting with transcript and summary, got %+v", saved)
+	}
+	for _, participant := range saved.Participants {
+		if participant.LeftAt.IsZero() {
+			t.Errorf("Expected %s to have left, got %+v", participant.ClientID, participant)
+		}
+	}
+}
+
+func TestMeetingAnalytics(t *testing.T) {
+	start := time.Now().Add(-10 * time.Minute)
+	meeting := Meeting{
+		ID:        "m1",
+		StartedAt: start,
+		EndedAt:   start.Add(10 * time.Minute),
+		Participants: []MeetingParticipant{
+			{ClientID: "teacher", JoinedAt: start, LeftAt: start.Add(10 * time.Minute)},
+			{ClientID: "student", JoinedAt: start, LeftAt: start.Add(2 * time.Minute)},
+			{ClientID: "student", JoinedAt: start.Add(5 * time.Minute), LeftAt: start.Add(10 * time.Minute)},
+		},
+		Transcript: []Caption{{Speaker: "student", Text: strings.Repeat("word ", 75), Final: true}},
+		TalkTime:   map[string]TalkTime{"teacher": {Duration: 90 * time.Second, Turns: 3}},
+	}
+
+	analytics := meeting.Analytics()
+	if len(analytics.Participants) != 2 || analytics.DurationSeconds != 600 || analytics.TalkSeconds != 120 {
+		t.Fatalf("Expected two participants, 600s and 120s of talk, got %+v", analytics)
+	}
+	teacher, student := analytics.Participants[0], analytics.Participants[1]
+	if teacher.Source != TalkSourceAudio || teacher.TalkShare != 0.75 || teacher.Turns != 3 {
+		t.Errorf("Expected the teacher's reported talk time, got %+v", teacher)
+	}
+	if student.Source != TalkSourceTranscript || student.TalkSeconds != 30 || student.AttendanceSeconds != 420 {
+		t.Errorf("Expected 30s estimated from 75 words over two stays, got %+v", student)
+	}
+	if analytics.Balance <= 0 || analytics.Balance >= 1 {
+		t.Errorf("Expected an uneven balance, got %v", analytics.Balance)
+	}
+}
+
+func TestReportSpeaking(t *testing.T) {
+	hub := NewHub()
+	store := &memoryMeetingStore{meetings: make(map[string]Meeting)}
+	hub.SetMeetingStore(store)
+	room := hub.GetRoom("class")
+	alice := &Client{ID: "alice", Room: room, hub: hub, send: make(chan *Message, 10)}
+	room.AddClient(alice)
+
+	alice.handleMessage(&Message{Type: "speaking", From: alice.ID, Data: map[string]interface{}{"speaking": true}})
+	room.ReportSpeaking(alice.ID, true)
+	room.ReportSpeaking(alice.ID, false)
+	room.ReportSpeaking(alice.ID, true)
+	meeting, _ := room.Meeting()
+	room.RemoveClient(alice.ID)
+	room.ReportSpeaking(alice.ID, false)
+	hub.RemoveRoom(room.ID)
+
+	if talk := store.get(meeting.ID).TalkTime["alice"]; talk.Turns != 2 {
+		t.Errorf("Expected two turns, the second ended by leaving, got %+v", talk)
+	}
+}
+
+func TestCallRinging(t *testing.T) {
+	hub := NewHub()
+	hub.SetRingTimeout(50 * time.Millisecond)
+	lobby := hub.GetRoom("lobby")
+	alice := &Client{ID: "alice-laptop", UserID: "alice", Room: lobby, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob-laptop", UserID: "bob", Room: lobby, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	phone := &Client{ID: "bob-phone", UserID: "bob", Room: lobby, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	lobby.AddClient(alice)
+	lobby.AddClient(bob)
+	lobby.AddClient(phone)
+	lobby.settle()
+	drainTypes(alice)
+	drainTypes(bob)
+	drainTypes(phone)
+
+	// Nobody answers: both sides hear the timeout
+	alice.handleMessage(&Message{Type: "call", From: alice.ID, Data: map[string]interface{}{"userId": "bob"}})
+	if msg := <-alice.send; msg.Type != "call-ringing" {
+		t.Fatalf("Expected the caller to hear ringing, got %+v", msg)
+	}
+	incoming := <-phone.send
+	if incoming.Type != "call-incoming" || incoming.Data["from"] != "alice" {
+		t.Fatalf("Expected every device of bob to ring, got %+v", incoming)
+	}
+	if msg := <-alice.send; msg.Type != "call-timeout" {
+		t.Errorf("Expected the call to time out, got %+v", msg)
+	}
+	drainTypes(bob)
+	drainTypes(phone)
+
+	// The phone accepts; the laptop stops ringing and the pair gets a room
+	call, err := alice.PlaceCall("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.PlaceCall("bob"); err == nil {
+		t.Error("Expected a caller with a ringing call to be refused")
+	}
+	drainTypes(alice)
+	drainTypes(bob)
+	drainTypes(phone)
+	phone.handleMessage(&Message{Type: "call-accept", From: phone.ID, Data: map[string]interface{}{"callId": call.ID}})
+	accepted := <-alice.send
+	if accepted.Type != "call-accepted" || accepted.Data["roomId"] != "call-"+call.ID {
+		t.Fatalf("Expected the caller to learn the call was accepted, got %+v", accepted)
+	}
+	if msg := <-bob.send; msg.Type != "call-cancelled" || msg.Data["reason"] != "answered-elsewhere" {
+		t.Errorf("Expected the laptop to stop ringing, got %+v", msg)
+	}
+	if hub.FindRoom("call-"+call.ID) == nil {
+		t.Error("Expected the call's room to be created")
+	}
+
+	// A third user calling either party hears busy
+	carol := &Client{ID: "carol", Room: lobby, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	carol.PlaceCall("bob")
+	if msg := <-carol.send; msg.Type != "call-busy" || msg.Data["reason"] != "in-call" {
+		t.Errorf("Expected bob to be busy, got %+v", msg)
+	}
+
+	drainTypes(phone)
+	alice.HangUp(call.ID)
+	if msg := <-phone.send; msg.Type != "call-ended" || msg.Data["reason"] != CallHungUp {
+		t.Errorf("Expected the call to end for bob, got %+v", msg)
+	}
+	if len(hub.Calls()) != 0 {
+		t.Errorf("Expected no calls left, got %+v", hub.Calls())
+	}
+
+	// Do not disturb answers busy without ringing, and a decline reaches the caller
+	bob.SetPresence(PresenceDND)
+	alice.PlaceCall("bob")
+	if msg := <-alice.send; msg.Type != "call-busy" || msg.Data["reason"] != PresenceDND {
+		t.Errorf("Expected bob to be on do not disturb, got %+v", msg)
+	}
+	if types := drainTypes(phone); len(types) != 0 {
+		t.Errorf("Expected no ringing on do not disturb, got %v", types)
+	}
+	bob.SetPresence(PresenceAvailable)
+	call, _ = alice.PlaceCall("bob")
+	drainTypes(alice)
+	if _, err := bob.AnswerCall(call.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-alice.send; msg.Type != "call-declined" {
+		t.Errorf("Expected the call to be declined, got %+v", msg)
+	}
+	if _, err := carol.PlaceCall("nobody"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-carol.send; msg.Type != "call-unavailable" {
+		t.Errorf("Expected a user without connections to be unavailable, got %+v", msg)
+	}
+}
+
+func TestCallTransfer(t *testing.T) {
+	hub := NewHub()
+	lobby := hub.GetRoom("lobby")
+	newUser := func(id string) *Client {
+		client := &Client{ID: id, UserID: id, Room: lobby, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+		lobby.AddClient(client)
+		return client
+	}
+	alice, bob, carol := newUser("alice"), newUser("bob"), newUser("carol")
+	lobby.settle()
+	connect := func(caller, callee *Client) Call {
+		call, err := caller.PlaceCall(callee.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := callee.AnswerCall(call.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		drainTypes(alice)
+		drainTypes(bob)
+		drainTypes(carol)
+		return call
+	}
+
+	// Attended: carol declines, so alice keeps talking to bob
+	call := connect(alice, bob)
+	bob.handleMessage(&Message{Type: "transfer-call", From: bob.ID, Data: map[string]interface{}{
+		"callId": call.ID, "userId": "carol", "mode": "attended",
+	}})
+	if msg := <-alice.send; msg.Type != "call-ringing" || msg.Data["transferredBy"] != "bob" {
+		t.Fatalf("Expected alice to ring carol on bob's behalf, got %+v", msg)
+	}
+	incoming := <-carol.send
+	if incoming.Type != "call-incoming" || incoming.Data["from"] != "alice" {
+		t.Fatalf("Expected carol to be rung by alice, got %+v", incoming)
+	}
+	if _, err := bob.TransferCall(call.ID, "carol", true); err == nil {
+		t.Error("Expected a second transfer of the same call to be refused")
+	}
+	carol.AnswerCall(incoming.Data["callId"].(string), false)
+	if msg := <-bob.send; msg.Type != "transfer-failed" || msg.Data["reason"] != CallDeclined {
+		t.Errorf("Expected bob to learn the transfer failed, got %+v", msg)
+	}
+	if calls := hub.Calls(); len(calls) != 1 || calls[0].ID != call.ID || calls[0].State != CallActive {
+		t.Fatalf("Expected the original call to stay up, got %+v", calls)
+	}
+	drainTypes(alice)
+
+	// Attended: carol accepts, and the original call ends for both
+	bob.TransferCall(call.ID, "carol", true)
+	<-alice.send
+	incoming = <-carol.send
+	carol.AnswerCall(incoming.Data["callId"].(string), true)
+	if msg := <-bob.send; msg.Type != "call-ended" || msg.Data["reason"] != CallTransferred {
+		t.Errorf("Expected bob's leg to be torn down, got %+v", msg)
+	}
+	if types := drainTypes(alice); len(types) != 2 || types[0] != "call-ended" || types[1] != "call-accepted" {
+		t.Errorf("Expected alice to move to the new call, got %v", types)
+	}
+	if calls := hub.Calls(); len(calls) != 1 || calls[0].Callee != "carol" || calls[0].TransferredBy != "bob" {
+		t.Fatalf("Expected only the transferred call, got %+v", calls)
+	}
+	transferred := hub.Calls()[0]
+	drainTypes(carol)
+	carol.HangUp(transferred.ID)
+	drainTypes(alice)
+
+	// Blind: the call ends at once and carol rings
+	call = connect(alice, bob)
+	if _, err := alice.TransferCall(call.ID, "carol", false); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-bob.send; msg.Type != "call-ended" || msg.Data["transferredTo"] != "carol" {
+		t.Errorf("Expected bob's call to end, got %+v", msg)
+	}
+	if msg := <-carol.send; msg.Type != "call-incoming" || msg.Data["from"] != "bob" {
+		t.Errorf("Expected carol to be rung by bob, got %+v", msg)
+	}
+	if calls := hub.Calls(); len(calls) != 1 || calls[0].Caller != "bob" || calls[0].State != CallRinging {
+		t.Errorf("Expected only bob's call to carol, got %+v", calls)
+	}
+}
+
+func TestCallHold(t *testing.T) {
+	hub := NewHub()
+	hub.SetHoldMusic(true)
+	var events []string
+	hub.OnEvent(func(event Event) {
+		if event.Type == EventCallHeld || event.Type == EventCallResumed {
+			events = append(events, event.Type+" "+event.RoomID)
+		}
+	})
+	lobby := hub.GetRoom("lobby")
+	alice := &Client{ID: "alice", UserID: "alice", Room: lobby, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	bob := &Client{ID: "bob", UserID: "bob", Room: lobby, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	lobby.AddClient(alice)
+	lobby.AddClient(bob)
+	call, _ := alice.PlaceCall("bob")
+	call, _ = bob.AnswerCall(call.ID, true)
+	room := hub.FindRoom(call.RoomID)
+	inCall := &Client{ID: "alice-media", UserID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	room.AddClient(inCall)
+	room.AddClient(&Client{ID: "bob-media", UserID: "bob", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)})
+	room.settle()
+	drainTypes(alice)
+	drainTypes(bob)
+	drainTypes(inCall)
+
+	if _, err := alice.HoldCall(call.ID, false); err == nil {
+		t.Error("Expected resuming a call that isn't held to fail")
+	}
+	bob.handleMessage(&Message{Type: "hold", From: bob.ID, Data: map[string]interface{}{"callId": call.ID}})
+	held := <-alice.send
+	if held.Type != "call-held" || held.Data["by"] != "bob" || held.Data["holdMusic"] != true {
+		t.Fatalf("Expected alice to be put on hold with music, got %+v", held)
+	}
+	room.settle()
+	if msg := <-inCall.send; msg.Type != "participant-hold" || msg.Data["accountId"] != "alice" || msg.Data["onHold"] != true {
+		t.Errorf("Expected the call's room to show alice on hold, got %+v", msg)
+	}
+	for _, participant := range room.Participants() {
+		if participant.OnHold != (participant.UserID == "alice") {
+			t.Errorf("Expected only alice on hold in the roster, got %+v", participant)
+		}
+	}
+	if _, err := alice.HoldCall(call.ID, false); err == nil {
+		t.Error("Expected only the party who held the call to resume it")
+	}
+
+	bob.handleMessage(&Message{Type: "resume", From: bob.ID, Data: map[string]interface{}{"callId": call.ID}})
+	if msg := <-alice.send; msg.Type != "call-resumed" {
+		t.Errorf("Expected the call to be resumed, got %+v", msg)
+	}
+	if hub.Calls()[0].HeldBy != "" || room.Participants()[0].OnHold {
+		t.Error("Expected nobody on hold after resuming")
+	}
+	want := []string{EventCallHeld + " " + call.RoomID, EventCallResumed + " " + call.RoomID}
+	if len(events) != 2 || events[0] != want[0] || events[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, events)
+	}
+}
+
+func TestStateStore(t *testing.T) {
+	hub := NewHub()
+	store := NewMemoryStore()
+	hub.SetStateStore(store)
+	hub.SetNodeName("node-a")
+
+	room := hub.GetRoom("standup")
+	alice := &Client{ID: "alice-laptop", UserID: "alice", DeviceID: "laptop", Room: room, hub: hub, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: room, hub: hub, send: make(chan *Message, 10)}
+	room.AddClient(alice)
+	room.AddClient(bob)
+
+	members, _ := hub.Members("standup")
+	if len(members) != 2 || members[0].ClientID != "alice-laptop" || members[0].UserID != "alice" || members[0].Node != "node-a" {
+		t.Fatalf("Expected both members on node-a, got %+v", members)
+	}
+	records, _ := hub.RoomRecords()
+	if len(records) != 1 || records[0].State != RoomActive || records[0].HostID != "alice-laptop" {
+		t.Fatalf("Expected the active room hosted by alice, got %+v", records)
+	}
+
+	room.removeConnection(alice)
+	members, _ = store.Members("standup")
+	if len(members) != 1 || members[0].ClientID != "bob" {
+		t.Errorf("Expected only bob after alice left, got %+v", members)
+	}
+	if records, _ := store.Rooms(); records[0].HostID != "bob" {
+		t.Errorf("Expected the host handover to be recorded, got %+v", records[0])
+	}
+
+	room.removeConnection(bob)
+	hub.RemoveRoom("standup")
+	if records, _ := store.Rooms(); len(records) != 0 {
+		t.Errorf("Expected the closed room to be removed from the store, got %+v", records)
+	}
+	if members, _ := store.Members("standup"); len(members) != 0 {
+		t.Errorf("Expected no members of a closed room, got %+v", members)
+	}
+}
+
+// memoryEventLog keeps room events in memory for tests
+type memoryEventLog struct {
+	mutex     sync.Mutex
+	events    map[string][]RoomEvent
+	snapshots map[string]RoomView
+}
+
+func newMemoryEventLog() *memoryEventLog {
+	return &memoryEventLog{events: make(map[string][]RoomEvent), snapshots: make(map[string]RoomView)}
+}
+
+func (l *memoryEventLog) Append(events []RoomEvent) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for i := range events {
+		events[i].Seq = int64(len(l.events[events[i].RoomID]) + 1)
+		l.events[events[i].RoomID] = append(l.events[events[i].RoomID], events[i])
+	}
+	return nil
+}
+
+func (l *memoryEventLog) Events(roomID string, afterSeq int64) ([]RoomEvent, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return slices.Clone(l.events[roomID][afterSeq:]), nil
+}
+
+func (l *memoryEventLog) SaveSnapshot(view RoomView) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.snapshots[view.RoomID] = view
+	return nil
+}
+
+func (l *memoryEventLog) Snapshot(roomID string) (RoomView, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.snapshots[roomID], nil
+}
+
+func (l *memoryEventLog) RoomIDs() ([]string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return slices.Collect(maps.Keys(l.events)), nil
+}
+
+func TestEventLog(t *testing.T) {
+	log := newMemoryEventLog()
+	hub := NewHub()
+	hub.SetEventLog(log)
+	var moderation []RoomEvent
+	hub.OnRoomEvent(func(event RoomEvent) {
+		if event.Type == RoomEventModeration {
+			moderation = append(moderation, event)
+		}
+	})
+
+	room := hub.GetRoom("standup")
+	alice := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(alice)
+	room.AddClient(bob)
+	room.SetKeywords(alice, []string{"budget"})
+	room.RequestRecording(alice, bob.ID, "")
+	room.SetHost(bob.ID)
+
+	view, _ := ReplayRoom(log, "standup")
+	if view.State != RoomActive || view.HostID != "bob" || len(view.Members) != 2 {
+		t.Fatalf("Expected the active room with two members hosted by bob, got %+v", view)
+	}
+	if _, version := room.VersionedSettings(); view.SettingsVersion != version || len(view.Settings.Keywords) != 1 {
+		t.Errorf("Expected settings version %d with the keyword, got %+v", version, view)
+	}
+	if len(moderation) != 1 || moderation[0].Action != ModerationRecordRequest || moderation[0].By != "alice" || moderation[0].ClientID != "bob" {
+		t.Errorf("Expected the recording request as moderation, got %+v", moderation)
+	}
+
+	// After a crash, a new hub reopens the room with its settings and host;
+	// the former members are logged as gone until they reconnect
+	recovering := NewHub()
+	recovering.SetEventLog(log)
+	if recovered, err := recovering.RecoverRooms(); err != nil || recovered != 1 {
+		t.Fatalf("Expected one room to be recovered, got %d: %v", recovered, err)
+	}
+	reopened := recovering.FindRoom("standup")
+	if reopened == nil || reopened.GetHost() != "bob" || reopened.Settings().Keywords[0] != "budget" {
+		t.Fatalf("Expected standup back with its host and keywords, got %+v", reopened)
+	}
+	view, _ = ReplayRoom(log, "standup")
+	if len(view.Members) != 0 || view.HostID != "bob" || view.State != RoomCreated {
+		t.Errorf("Expected the recovered room empty but hosted by bob, got %+v", view)
+	}
+
+	recovering.CloseRoom("standup", "meeting over")
+	view, _ = ReplayRoom(log, "standup")
+	if view.State != RoomClosed || log.snapshots["standup"].Seq != view.Seq {
+		t.Errorf("Expected a snapshot of the closed room, got %+v", view)
+	}
+	restarted := NewHub()
+	restarted.SetEventLog(log)
+	if recovered, _ := restarted.RecoverRooms(); recovered != 0 {
+		t.Errorf("Expected closed rooms not to be recovered, got %d", recovered)
+	}
+}
+
+// memoryBus connects the backplanes of hubs in one process for tests
+type memoryBus struct {
+	mutex  sync.Mutex
+	nodes  []*memoryBackplane
+	seqs   map[string]int64
+	claims map[string]int64
+}
+
+type memoryBackplane struct {
+	bus      *memoryBus
+	name     string
+	rooms    map[string]bool
+	handler  func(roomID string, payload []byte)
+	received int
+}
+
+func (bus *memoryBus) node(name string) *memoryBackplane {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	node := &memoryBackplane{bus: bus, name: name, rooms: make(map[string]bool)}
+	bus.nodes = append(bus.nodes, node)
+	return node
+}
+
+func (b *memoryBackplane) Publish(roomID string, payload []byte) error {
+	b.bus.mutex.Lock()
+	var handlers []func(string, []byte)
+	for _, node := range b.bus.nodes {
+		if node.rooms[roomID] {
+			node.received++
+			handlers = append(handlers, node.handler)
+		}
+	}
+	b.bus.mutex.Unlock()
+	for _, handler := range handlers {
+		handler(roomID, payload)
+	}
+	return nil
+}
+
+func (b *memoryBackplane) PublishNode(name string, payload []byte) error {
+	b.bus.mutex.Lock()
+	var handler func(string, []byte)
+	for _, node := range b.bus.nodes {
+		if node.name == name {
+			node.received++
+			handler = node.handler
+		}
+	}
+	b.bus.mutex.Unlock()
+	if handler != nil {
+		handler("", payload)
+	}
+	return nil
+}
+
+func (b *memoryBackplane) PublishCluster(payload []byte) error {
+	b.bus.mutex.Lock()
+	var handlers []func(string, []byte)
+	for _, node := range b.bus.nodes {
+		if node.handler != nil {
+			handlers = append(handlers, node.handler)
+		}
+	}
+	b.bus.mutex.Unlock()
+	for _, handler := range handlers {
+		handler("", payload)
+	}
+	return nil
+}
+
+func (b *memoryBackplane) Subscribe(roomID string) error {
+	b.bus.mutex.Lock()
+	defer b.bus.mutex.Unlock()
+	b.rooms[roomID] = true
+	return nil
+}
+
+func (b *memoryBackplane) Unsubscribe(roomID string) error {
+	b.bus.mutex.Lock()
+	defer b.bus.mutex.Unlock()
+	delete(b.rooms, roomID)
+	return nil
+}
+
+func (b *memoryBackplane) OnMessage(handler func(roomID string, payload []byte)) {
+	b.handler = handler
+}
+
+func (b *memoryBackplane) Sequence(roomID, commandID string) (int64, bool, error) {
+	b.bus.mutex.Lock()
+	defer b.bus.mutex.Unlock()
+	if b.bus.seqs == nil {
+		b.bus.seqs = make(map[string]int64)
+		b.bus.claims = make(map[string]int64)
+	}
+	if seq, claimed := b.bus.claims[roomID+":"+commandID]; claimed {
+		return seq, false, nil
+	}
+	b.bus.seqs[roomID]++
+	b.bus.claims[roomID+":"+commandID] = b.bus.seqs[roomID]
+	return b.bus.seqs[roomID], true, nil
+}
+
+func TestBackplane(t *testing.T) {
+	bus := &memoryBus{}
+	store := NewMemoryStore()
+	var hubs []*Hub
+	for _, node := range []string{"node-a", "node-b", "node-c"} {
+		hub := NewHub()
+		hub.SetNodeName(node)
+		hub.SetStateStore(store)
+		hub.SetBackplane(bus.node(node))
+		hubs = append(hubs, hub)
+	}
+
+	roomA, roomB, roomC := hubs[0].GetRoom("standup"), hubs[1].GetRoom("standup"), hubs[2].GetRoom("standup")
+	alice := &Client{ID: "alice", Room: roomA, hub: hubs[0], state: StateReady, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: roomB, hub: hubs[1], state: StateReady, send: make(chan *Message, 10)}
+	carol := &Client{ID: "carol", Room: roomB, hub: hubs[1], state: StateReady, send: make(chan *Message, 10)}
+	dave := &Client{ID: "dave", Room: roomC, hub: hubs[2], state: StateReady, send: make(chan *Message, 10)}
+	roomA.AddClient(alice)
+	roomB.AddClient(bob)
+	roomB.AddClient(carol)
+	roomC.AddClient(dave)
+	for _, room := range []*Room{roomA, roomB, roomC} {
+		room.settle()
+	}
+	for _, client := range []*Client{alice, bob, carol, dave} {
+		drainTypes(client)
+	}
+
+	// Alice learns about the clients on the other nodes and negotiates with
+	// bob alone; only bob's node gets the offer
+	alice.sendUserList()
+	if msg := <-alice.send; len(msg.Data["users"].([]string)) != 3 {
+		t.Errorf("Expected bob, carol and dave in alice's user list, got %+v", msg.Data["users"])
+	}
+	nodeC := bus.nodes[2].received
+	alice.handleMessage(&Message{Type: "offer", From: alice.ID, To: bob.ID, Data: map[string]interface{}{"sdp": "v=0"}})
+	if msg := <-bob.send; msg.Type != "offer" || msg.From != "alice" || msg.Data["sdp"] != "v=0" {
+		t.Errorf("Expected alice's offer on node-b, got %+v", msg)
+	}
+	if types := drainTypes(carol); len(types) != 0 {
+		t.Errorf("Expected the offer to reach bob only, got %v for carol", types)
+	}
+	if types := drainTypes(alice); len(types) != 0 {
+		t.Errorf("Expected no recipient-not-found for a remote peer, got %v", types)
+	}
+
+	// So does private chat
+	roomA.Broadcast(&Message{Type: "chat", From: alice.ID, To: carol.ID, Data: map[string]interface{}{"text": "psst"}}, "")
+	roomB.settle()
+	if types := drainTypes(carol); len(types) != 1 || types[0] != "chat" {
+		t.Errorf("Expected alice's private chat for carol, got %v", types)
+	}
+	if bus.nodes[2].received != nodeC || len(dave.send) != 0 {
+		t.Errorf("Expected messages for node-b's clients not to reach node-c, got %d", bus.nodes[2].received-nodeC)
+	}
+
+	// Chat from node-b reaches node-a once, and node-b through its own room
+	roomB.Broadcast(&Message{Type: "chat", From: bob.ID, Data: map[string]interface{}{"text": "hi"}}, "")
+	roomB.settle()
+	if types := drainTypes(alice); len(types) != 1 || types[0] != "chat" {
+		t.Errorf("Expected bob's chat on node-a once, got %v", types)
+	}
+	if types := drainTypes(carol); len(types) != 1 || types[0] != "chat" {
+		t.Errorf("Expected bob's chat for carol once, got %v", types)
+	}
+
+	// The room closing on node-c leaves the other nodes' clients routable
+	hubs[2].CloseRoom("standup", "maintenance")
+	if _, exists, _ := store.Member("standup", "dave"); exists {
+		t.Error("Expected dave to leave the directory with node-c's room")
+	}
+	if member, exists, _ := store.Member("standup", "bob"); !exists || member.Node != "node-b" {
+		t.Errorf("Expected bob to stay routable to node-b, got %+v", member)
+	}
+}
+
+func TestGossip(t *testing.T) {
+	bus := &memoryBus{}
+	store := NewMemoryStore()
+	var hubs []*Hub
+	for _, node := range []string{"node-a", "node-b", "node-c"} {
+		hub := NewHub()
+		hub.SetNodeName(node)
+		hub.SetStateStore(store)
+		hub.SetBackplane(bus.node(node))
+		hub.cluster.deadAfter = time.Second
+		hubs = append(hubs, hub)
+	}
+
+	roomA, roomB, roomC := hubs[0].GetRoom("standup"), hubs[1].GetRoom("standup"), hubs[2].GetRoom("standup")
+	alice := &Client{ID: "alice", Room: roomA, hub: hubs[0], state: StateReady, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: roomB, hub: hubs[1], state: StateReady, send: make(chan *Message, 10)}
+	carol := &Client{ID: "carol", Room: roomC, hub: hubs[2], state: StateReady, send: make(chan *Message, 10)}
+	retro := hubs[2].GetRoom("retro")
+	dave := &Client{ID: "dave", Room: retro, hub: hubs[2], state: StateReady, send: make(chan *Message, 10)}
+	roomA.AddClient(alice)
+	roomB.AddClient(bob)
+	roomC.AddClient(carol)
+	retro.AddClient(dave)
+	for _, room := range []*Room{roomA, roomB, roomC, retro} {
+		room.settle()
+	}
+	drainTypes(alice)
+	drainTypes(bob)
+
+	// Nodes find each other through their heartbeats
+	for _, hub := range hubs {
+		hub.heartbeat()
+	}
+	if nodes := hubs[0].Nodes(); len(nodes) != 3 || nodes[2].Name != "node-c" || nodes[2].Rooms != 2 || nodes[2].Clients != 2 {
+		t.Fatalf("Expected node-a to know all three nodes and node-c's load, got %+v", nodes)
+	}
+
+	// Node-c drops off the backplane; node-a declares it dead and the other
+	// nodes tell their clients node-c's clients left
+	hubs[0].cluster.mutex.Lock()
+	node := hubs[0].cluster.nodes["node-c"]
+	node.LastSeen = time.Now().Add(-time.Minute)
+	hubs[0].cluster.nodes["node-c"] = node
+	hubs[0].cluster.mutex.Unlock()
+	bus.mutex.Lock()
+	bus.nodes = bus.nodes[:2]
+	bus.mutex.Unlock()
+	hubs[0].reapNodes(time.Now())
+	roomA.settle()
+	roomB.settle()
+
+	for i, hub := range hubs[:2] {
+		if nodes := hub.Nodes(); len(nodes) != 2 || nodes[1].Name != "node-b" {
+			t.Errorf("Expected node-c gone from hub %d, got %+v", i, nodes)
+		}
+	}
+	for _, client := range []*Client{alice, bob} {
+		if types := drainTypes(client); len(types) != 1 || types[0] != "user-left" {
+			t.Errorf("Expected %s to see carol leave, got %v", client.ID, types)
+		}
+	}
+	if _, exists, _ := store.Member("standup", "carol"); exists {
+		t.Error("Expected carol removed from the directory")
+	}
+	records, _ := store.Rooms()
+	if len(records) != 1 || records[0].ID != "standup" || records[0].Node == "node-c" {
+		t.Errorf("Expected retro forgotten and standup taken over, got %+v", records)
+	}
+
+	// A node declared dead while alive records its members again
+	hubs[2].receiveDown("node-c", nil)
+	if member, exists, _ := store.Member("retro", "dave"); !exists || member.Node != "node-c" {
+		t.Errorf("Expected dave recorded again, got %+v", member)
+	}
+}
+
+func TestClusterModeration(t *testing.T) {
+	bus := &memoryBus{}
+	store := NewMemoryStore()
+	var hubs []*Hub
+	for _, node := range []string{"node-a", "node-b"} {
+		hub := NewHub()
+		hub.SetNodeName(node)
+		hub.SetStateStore(store)
+		hub.SetBackplane(bus.node(node))
+		hubs = append(hubs, hub)
+	}
+
+	roomA, roomB := hubs[0].GetRoom("standup"), hubs[1].GetRoom("standup")
+	host := &Client{ID: "host", Room: roomA, hub: hubs[0], state: StateReady, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: roomB, hub: hubs[1], state: StateReady, send: make(chan *Message, 10)}
+	carol := &Client{ID: "carol", Room: roomB, hub: hubs[1], state: StateReady, send: make(chan *Message, 10)}
+	roomA.AddClient(host)
+	roomB.AddClient(bob)
+	roomB.AddClient(carol)
+	roomA.settle()
+	roomB.settle()
+	drainTypes(host)
+	drainTypes(bob)
+
+	moderate := func(msgType, commandID, clientID string) *Message {
+		t.Helper()
+		host.handleMessage(&Message{Type: msgType, From: host.ID, Data: map[string]interface{}{"commandId": commandID, "clientId": clientID}})
+		roomA.settle()
+		roomB.settle()
+		for len(host.send) > 0 {
+			if msg := <-host.send; msg.Type == "moderation-ack" || msg.Type == "moderation-rejected" {
+				return msg
+			}
+		}
+		t.Fatalf("Expected an answer to %s %s", msgType, commandID)
+		return nil
+	}
+
+	// Carol is on node-b; node-b kicks her and node-a acknowledges the host
+	if msg := moderate(CommandKick, "kick-1", "carol"); msg.Type != "moderation-ack" || msg.Data["seq"] != int64(1) || msg.Data["clientId"] != "carol" {
+		t.Fatalf("Expected the kick to be acknowledged as command 1, got %+v", msg)
+	}
+	if roomB.client("carol") != nil || carol.closeCode != CloseKicked {
+		t.Errorf("Expected carol to be kicked from node-b, got close code %d", carol.closeCode)
+	}
+
+	// A retried command is acknowledged again without running twice
+	if msg := moderate(CommandKick, "kick-1", "carol"); msg.Type != "moderation-rejected" {
+		t.Errorf("Expected a kick of a departed client to be rejected, got %+v", msg)
+	}
+	if msg := moderate(CommandLock, "lock-1", ""); msg.Data["seq"] != int64(2) {
+		t.Errorf("Expected the lock to be command 2, got %+v", msg)
+	}
+	if msg := moderate(CommandLock, "lock-1", ""); msg.Data["duplicate"] != true || msg.Data["seq"] != int64(2) {
+		t.Errorf("Expected the repeated lock to be acknowledged as a duplicate, got %+v", msg)
+	}
+	if types := drainTypes(bob); !slices.Equal(types, []string{"user-left", "peer-state", "room-locked"}) {
+		t.Errorf("Expected bob to see carol leave and the room locked once, got %v", types)
+	}
+	dave := &Client{ID: "dave", Room: roomB, hub: hubs[1], send: make(chan *Message, 10)}
+	if _, err := roomB.Join(dave); err != ErrRoomLocked {
+		t.Errorf("Expected node-b to refuse new clients while locked, got %v", err)
+	}
+
+	// Commands are applied in sequence order, whatever order they arrive in
+	lock := ModerationCommand{ID: "lock-2", Command: CommandLock, By: "host", Node: "node-a"}
+	unlock := ModerationCommand{ID: "unlock-1", Command: CommandUnlock, By: "host", Node: "node-a"}
+	lock.Seq, _, _ = bus.nodes[0].Sequence("standup", lock.ID)
+	unlock.Seq, _, _ = bus.nodes[0].Sequence("standup", unlock.ID)
+	for _, room := range []*Room{roomA, roomB} {
+		room.receiveCommand(unlock)
+		if !room.Locked() {
+			t.Error("Expected command 4 to wait for command 3")
+		}
+		room.receiveCommand(lock)
+		if room.Locked() {
+			t.Error("Expected commands 3 and 4 to be applied in order")
+		}
+	}
+	roomA.settle()
+	drainTypes(host)
+
+	if msg := moderate(CommandEndMeeting, "end-1", ""); msg.Type != "moderation-ack" {
+		t.Errorf("Expected the end of the meeting to be acknowledged, got %+v", msg)
+	}
+	if hubs[0].FindRoom("standup") != nil || hubs[1].FindRoom("standup") != nil {
+		t.Error("Expected the meeting to end on both nodes")
+	}
+	if bob.closeCode != CloseRoomEnded {
+		t.Errorf("Expected bob to be disconnected as the room ended, got close code %d", bob.closeCode)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("draining")
+	alice := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(alice)
+	room.AddClient(bob)
+	room.settle()
+	drainTypes(alice)
+	drainTypes(bob)
+
+	done := make(chan int)
+	go func() { done <- hub.Shutdown(context.Background(), 300*time.Millisecond) }()
+
+	// Alice reconnects elsewhere on the notice; bob stays until the end
+	msg := <-alice.send
+	if msg.Type != "server-shutdown" || msg.Data["gracePeriod"] != 0.3 {
+		t.Errorf("Expected server-shutdown with the grace period, got %+v", msg)
+	}
+	alice.Close()
+	if err := newClient("carol", nil, hub, ClientOptions{}).join("draining"); err != ErrShuttingDown {
+		t.Errorf("Expected joins to be refused while draining, got %v", err)
+	}
+
+	if disconnected := <-done; disconnected != 1 {
+		t.Errorf("Expected 1 client disconnected after the grace period, got %d", disconnected)
+	}
+	if bob.closeCode != CloseShutdown {
+		t.Errorf("Expected bob closed with %d, got %d", CloseShutdown, bob.closeCode)
+	}
+	if hub.FindRoom("draining") != nil {
+		t.Error("Expected the room to close once empty")
+	}
+
+	// A cancelled context cuts the grace period short
+	hub = NewHub()
+	room = hub.GetRoom("cut-short")
+	room.AddClient(&Client{ID: "dave", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if disconnected := hub.Shutdown(ctx, time.Minute); disconnected != 1 || time.Since(start) > time.Second {
+		t.Errorf("Expected dave disconnected right away, got %d after %v", disconnected, time.Since(start))
+	}
+}
+
+func TestHandleMessageType(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("custom")
+	alice := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(alice)
+	room.AddClient(bob)
+	room.settle()
+	drainTypes(alice)
+	drainTypes(bob)
+
+	if err := hub.HandleMessageType("audit", RelayNone, nil); err == nil {
+		t.Error("Expected a handler to be required for messages that aren't relayed")
+	}
+	if err := hub.HandleMessageType("audit", "everyone", nil); err == nil {
+		t.Error("Expected an unknown relay policy to be refused")
+	}
+
+	var handled []string
+	hub.HandleMessageType("whiteboard", RelayRoom, func(client *Client, msg *Message) error {
+		if _, found := msg.Data["stroke"]; !found {
+			return errors.New("stroke is required")
+		}
+		handled = append(handled, client.ID)
+		return nil
+	})
+	hub.HandleMessageType("audit", RelayNone, func(client *Client, msg *Message) error {
+		handled = append(handled, "audit")
+		return nil
+	})
+	hub.HandleMessageType("poke", RelayDirect, nil)
+
+	// Accepted messages are relayed per the policy
+	alice.handleMessage(&Message{Type: "whiteboard", From: alice.ID, Data: map[string]interface{}{"stroke": "M0 0"}})
+	room.settle()
+	alice.handleMessage(&Message{Type: "audit", From: alice.ID})
+	alice.handleMessage(&Message{Type: "poke", From: alice.ID, To: "bob"})
+	room.settle()
+	if types := drainTypes(bob); !slices.Equal(types, []string{"whiteboard", "poke"}) {
+		t.Errorf("Expected bob to get the whiteboard stroke and the poke, got %v", types)
+	}
+	if !slices.Equal(handled, []string{"alice", "audit"}) {
+		t.Errorf("Expected both handlers to run, got %v", handled)
+	}
+	if types := drainTypes(alice); len(types) != 0 {
+		t.Errorf("Expected nothing back for accepted messages, got %v", types)
+	}
+
+	// Rejected messages get an error and go nowhere
+	alice.handleMessage(&Message{Type: "whiteboard", From: alice.ID})
+	alice.handleMessage(&Message{Type: "poke", From: alice.ID})
+	room.settle()
+	if msg := <-alice.send; msg.Type != "error" || msg.Data["message"] != "stroke is required" {
+		t.Errorf("Expected the handler's error, got %+v", msg)
+	}
+	if msg := <-alice.send; msg.Type != "error" || msg.Data["type"] != "poke" {
+		t.Errorf("Expected a direct message without a recipient to be refused, got %+v", msg)
+	}
+	if types := drainTypes(bob); len(types) != 0 {
+		t.Errorf("Expected bob to get nothing rejected, got %v", types)
+	}
+}
+
+func TestClientConfigPush(t *testing.T) {
+	hub := NewHub()
+	hub.SetTURNConfig(TURNConfig{URLs: []string{"turn:turn.example.com:3478"}, Secret: "secret"})
+	room := hub.GetRoom("pushed")
+	alice := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(alice)
+	room.settle()
+	drainTypes(alice)
+
+	if _, err := hub.SetClientConfig(ClientConfig{ICEServers: []ICEServer{{URLs: []string{"http://stun.example.com"}}}}); err == nil {
+		t.Error("Expected ICE server URLs without a STUN or TURN scheme to be refused")
+	}
+	if _, version := hub.ClientConfig(); version != 0 {
+		t.Errorf("Expected a refused configuration not to count, got version %d", version)
+	}
+
+	features := map[string]bool{"screenShare": false}
+	version, err := hub.SetClientConfig(ClientConfig{
+		ICEServers: []ICEServer{{URLs: []string{"stun:stun.example.com:3478"}}},
+		Features:   features,
+		Bitrate:    &BitratePolicy{MaxBitrate: 500000},
+	})
+	if err != nil || version != 1 {
+		t.Fatalf("Expected version 1, got %d, %v", version, err)
+	}
+	features["screenShare"] = true
+
+	msg := <-alice.send
+	if msg.Type != "config-update" || msg.Data["version"] != 1 {
+		t.Fatalf("Expected config-update with version 1, got %+v", msg)
+	}
+	iceServers, _ := msg.Data["iceServers"].([]ICEServer)
+	if len(iceServers) != 2 || iceServers[1].Username == "" || !strings.HasSuffix(iceServers[1].Username, ":alice") {
+		t.Errorf("Expected the configured STUN server and alice's own TURN credentials, got %+v", iceServers)
+	}
+	if msg.Data["features"].(map[string]bool)["screenShare"] {
+		t.Error("Expected the pushed configuration not to change with the caller's map")
+	}
+	if bitrate := msg.Data["bitrate"].(*BitratePolicy); bitrate.MaxBitrate != 500000 {
+		t.Errorf("Expected the bitrate policy, got %+v", bitrate)
+	}
+
+	// Clients joining later get the configuration in their welcome
+	bob := newClient("bob", nil, hub, ClientOptions{})
+	if err := bob.join("pushed"); err != nil {
+		t.Fatal(err)
+	}
+	bob.announceJoin()
+	welcome := <-bob.send
+	config, _ := welcome.Data["config"].(map[string]interface{})
+	if welcome.Type != "welcome" || config["version"] != 1 || len(welcome.Data["iceServers"].([]ICEServer)) != 2 {
+		t.Errorf("Expected the configuration in the welcome, got %+v", welcome)
+	}
+}
+
+func TestICEServerHealth(t *testing.T) {
+	hub := NewHub()
+	hub.SetTURNConfig(TURNConfig{URLs: []string{"turn:a.example.com", "turn:b.example.com"}, Secret: "secret"})
+	if _, err := hub.SetClientConfig(ClientConfig{ICEServers: []ICEServer{{URLs: []string{"stun:s.example.com"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if urls := hub.ICEServerURLs(); len(urls) != 3 {
+		t.Fatalf("Expected the TURN and pushed URLs to be checked, got %v", urls)
+	}
+	room := hub.GetRoom("rotation")
+	alice := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(alice)
+	room.settle()
+	drainTypes(alice)
+
+	// One failure may be a lost packet; the second takes the server out
+	failure := errors.New("i/o timeout")
+	hub.RecordICEServerCheck("turn:b.example.com", failure)
+	if len(alice.send) != 0 || len(hub.TURNCredentials("alice").URLs) != 2 {
+		t.Fatal("Expected a single failed check to keep the server")
+	}
+	hub.RecordICEServerCheck("turn:b.example.com", failure)
+	msg := <-alice.send
+	iceServers, _ := msg.Data["iceServers"].([]ICEServer)
+	if msg.Type != "config-update" || len(iceServers) != 2 || !slices.Equal(iceServers[1].URLs, []string{"turn:a.example.com"}) {
+		t.Errorf("Expected a config-update without the unhealthy server, got %+v", msg)
+	}
+
+	hub.RecordICEServerCheck("stun:s.example.com", failure)
+	hub.RecordICEServerCheck("stun:s.example.com", failure)
+	hub.RecordICEServerCheck("turn:a.example.com", failure)
+	hub.RecordICEServerCheck("turn:a.example.com", failure)
+	drainTypes(alice)
+	if servers := hub.ICEServers("alice"); len(servers) != 0 || hub.TURNCredentials("alice") != nil {
+		t.Errorf("Expected no servers handed out once all are unhealthy, got %+v", servers)
+	}
+
+	hub.RecordICEServerCheck("turn:b.example.com", nil)
+	if msg := <-alice.send; len(msg.Data["iceServers"].([]ICEServer)) != 1 {
+		t.Errorf("Expected the recovered server pushed again, got %+v", msg)
+	}
+	health := hub.ICEServerHealth()
+	if len(health) != 3 || health[0].URL != "stun:s.example.com" || health[0].Healthy || health[0].Failures != 2 || !health[2].Healthy {
+		t.Errorf("Unexpected health: %+v", health)
+	}
+}
+
+func TestTransportReports(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("transports")
+	alice := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(alice)
+	room.AddClient(bob)
+	room.settle()
+	drainTypes(alice)
+	drainTypes(bob)
+
+	alice.handleMessage(&Message{Type: "ice-transport", From: "alice", Data: map[string]interface{}{"peerId": "bob", "transport": TransportTURNTLS}})
+	bob.handleMessage(&Message{Type: "ice-transport", From: "bob", Data: map[string]interface{}{"peerId": "alice", "transport": "carrier-pigeon"}})
+	if types := drainTypes(bob); len(types) != 1 || types[0] != "error" {
+		t.Errorf("Expected an unknown transport to be rejected, got %v", types)
+	}
+	if types := drainTypes(alice); len(types) != 0 {
+		t.Errorf("Expected transport reports not to be relayed, got %v", types)
+	}
+
+	counts := hub.Transports()
+	if counts[TransportTURNTLS] != 1 || counts[TransportUDP] != 0 || len(counts) != len(Transports) {
+		t.Errorf("Expected alice counted over TURN/TLS, got %v", counts)
+	}
+	for _, participant := range room.Participants() {
+		want := ""
+		if participant.UserID == "alice" {
+			want = TransportTURNTLS
+		}
+		if got := participant.Devices[0].Transport; got != want {
+			t.Errorf("Expected %s's transport %q, got %q", participant.UserID, want, got)
+		}
+	}
+}
+
+func TestCheckJoin(t *testing.T) {
+	hub := NewHub()
+	if check := hub.CheckJoin("new-room", "", "", "", nil); !check.CanJoin || check.Exists {
+		t.Errorf("Expected a room that doesn't exist yet to be joinable, got %+v", check)
+	}
+
+	room := hub.GetRoomWithSettings("checked", func(settings *RoomSettings) {
+		settings.MaxParticipants = 1
+		settings.Mode = ModeSFU
+	})
+	alice := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	if _, err := room.Join(alice); err != nil {
+		t.Fatal(err)
+	}
+
+	check := hub.CheckJoin("checked", "bob", "", "", nil)
+	if check.CanJoin || check.Reason != "full" || check.Participants != 1 || check.MaxParticipants != 1 {
+		t.Errorf("Expected bob to be refused from the full room, got %+v", check)
+	}
+	if !slices.Equal(check.RequiredCapabilities, []string{CapabilitySFU}) || !slices.Equal(check.MissingCapabilities, []string{CapabilitySFU}) {
+		t.Errorf("Expected the SFU capability to be required and missing, got %+v", check)
+	}
+
+	// Preflight can't verify who claims a taken ID, so it counts a
+	// reconnecting client as a new participant, which needs the SFU too
+	if check := hub.CheckJoin("checked", "alice", "alice", "", []string{CapabilitySFU}); check.CanJoin || check.Reason != "full" {
+		t.Errorf("Expected an unverified alice to count against the limit, got %+v", check)
+	}
+	room.clientMutex.Lock()
+	room.settings.MaxParticipants = 2
+	room.clientMutex.Unlock()
+	if check := hub.CheckJoin("checked", "alice", "", "", nil); check.CanJoin || check.Reason != "capabilities" {
+		t.Errorf("Expected alice to lack the SFU capability, got %+v", check)
+	}
+	if check := hub.CheckJoin("checked", "alice", "", "", []string{CapabilitySFU}); !check.CanJoin {
+		t.Errorf("Expected alice to be able to join, got %+v", check)
+	}
+
+	room.clientMutex.Lock()
+	room.settings.MaxParticipants = 0
+	room.banned.add(ModerationCommand{ClientID: "mallory", Address: "203.0.113.9"})
+	room.locked = true
+	room.clientMutex.Unlock()
+	if check := hub.CheckJoin("checked", "carol", "", "203.0.113.9", []string{CapabilitySFU}); check.Reason != "banned" {
+		t.Errorf("Expected the banned address to be refused, got %+v", check)
+	}
+	if check := hub.CheckJoin("checked", "carol", "", "198.51.100.1", []string{CapabilitySFU}); check.Reason != "locked" || !check.Locked {
+		t.Errorf("Expected carol to be refused from the locked room, got %+v", check)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	hub := NewHub()
+	idle := hub.GetRoom("idle")
+	kept := hub.GetRoomWithSettings("kept", func(settings *RoomSettings) { settings.Persistent = true })
+	busy := hub.GetRoom("busy")
+
+	// A connection that went silent without its pumps noticing
+	accepted := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _ := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		accepted <- conn
+	}))
+	defer server.Close()
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	host := &Client{ID: "host", Room: busy, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	carol := &Client{ID: "carol", Room: busy, hub: hub, conn: <-accepted, state: StateReady, send: make(chan *Message, 10)}
+	busy.AddClient(host)
+	busy.AddClient(carol)
+	busy.settle()
+	drainTypes(host)
+
+	now := time.Now()
+	carol.lastSeen.Store(now.Add(-pongWait).UnixNano())
+	hub.sweepClients(now)
+	hub.sweepRooms(now, time.Hour)
+	if carol.State() == StateLeaving || hub.FindRoom("idle") == nil {
+		t.Fatal("Expected nothing swept within the pong window and the TTL")
+	}
+
+	hub.sweepClients(now.Add(2 * time.Hour))
+	hub.sweepRooms(now.Add(2*time.Hour), time.Hour)
+	if hub.FindRoom("idle") != nil || hub.FindRoom("kept") == nil || hub.FindRoom("busy") == nil {
+		t.Errorf("Expected only the idle room closed, got %v", hub.GetActiveRooms())
+	}
+	if kept.State() != RoomCreated || idle.State() != RoomClosed {
+		t.Errorf("Expected the persistent room kept, got %v and %v", kept.State(), idle.State())
+	}
+	if carol.State() != StateLeaving || busy.client("carol") != nil {
+		t.Error("Expected the silent client evicted")
+	}
+	busy.settle()
+	if msg := <-host.send; msg.Type != "user-left" || msg.Data["reason"] != LeaveTimeout {
+		t.Errorf("Expected carol to leave timed out, got %+v", msg)
+	}
+
+	// A closed room's broadcast loop is stopped, and messages to it are
+	// dropped rather than blocking
+	select {
+	case <-idle.stopped:
+	default:
+		t.Error("Expected the idle room stopped")
+	}
+	for range broadcastBuffer + 1 {
+		idle.Broadcast(&Message{Type: "chat"}, "")
+	}
+	idle.settle()
+}
+
+func TestSweepIdleRoomsAcrossCluster(t *testing.T) {
+	bus := &memoryBus{}
+	store := NewMemoryStore()
+	var hubs []*Hub
+	for _, node := range []string{"node-a", "node-b"} {
+		hub := NewHub()
+		hub.SetNodeName(node)
+		hub.SetStateStore(store)
+		hub.SetBackplane(bus.node(node))
+		hubs = append(hubs, hub)
+	}
+	leader, follower := hubs[0], hubs[1]
+	follower.GetRoom("idle")
+	follower.GetRoomWithSettings("kept", func(settings *RoomSettings) { settings.Persistent = true })
+
+	// Only the leader runs the sweep; the follower closes its own rooms when
+	// told to
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		leader.SweepIdleRooms(ctx, time.Millisecond, time.Nanosecond)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for follower.FindRoom("idle") != nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if follower.FindRoom("idle") != nil {
+		t.Error("Expected the leader's sweep to close the follower's idle room")
+	}
+	if follower.FindRoom("kept") == nil {
+		t.Error("Expected the persistent room kept")
+	}
+}
@@ -0,0 +1,151 @@
+package signaling
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+const (
+	// ICE failures with the same peer within iceFailureWindow after which the
+	// client is told to fall back to TURN
+	iceFailureThreshold = 2
+	iceFailureWindow    = 5 * time.Minute
+
+	// Default lifetime of generated TURN credentials
+	defaultTURNCredentialTTL = 12 * time.Hour
+)
+
+// TURNConfig describes the TURN servers handed to clients that can't connect
+// directly. Credentials follow the TURN REST API convention, so Secret must
+// match the static-auth-secret of the TURN server (e.g. coturn)
+type TURNConfig struct {
+	URLs   []string
+	Secret string
+	TTL    time.Duration
+}
+
+// ICEServer is one entry of an RTCConfiguration iceServers list
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// iceFailureTracker counts recent ICE failures per client and peer
+type iceFailureTracker struct {
+	mutex    sync.Mutex
+	failures map[string][]time.Time
+}
+
+// SetTURNConfig sets the TURN servers suggested to clients with repeated ICE failures
+func (h *Hub) SetTURNConfig(config TURNConfig) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.turn = config
+}
+
+// TURNCredentials returns short-lived TURN credentials for a client, or nil
+// if no TURN server is configured or none of its URLs is healthy
+func (h *Hub) TURNCredentials(clientID string) *ICEServer {
+	if h == nil {
+		return nil
+	}
+	h.roomsMutex.RLock()
+	config := h.turn
+	urls := slices.DeleteFunc(slices.Clone(config.URLs), func(url string) bool {
+		return !h.iceHealthyLocked(url)
+	})
+	h.roomsMutex.RUnlock()
+
+	if len(urls) == 0 {
+		return nil
+	}
+	server := &ICEServer{URLs: urls}
+	if config.Secret != "" {
+		ttl := config.TTL
+		if ttl <= 0 {
+			ttl = defaultTURNCredentialTTL
+		}
+		server.Username = fmt.Sprintf("%d:%s", time.Now().Add(ttl).Unix(), clientID)
+		mac := hmac.New(sha1.New, []byte(config.Secret))
+		mac.Write([]byte(server.Username))
+		server.Credential = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return server
+}
+
+// record adds a failure and returns how many happened within the window
+func (t *iceFailureTracker) record(clientID, peerID string, now time.Time) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.failures == nil {
+		t.failures = make(map[string][]time.Time)
+	}
+	key := clientID + "|" + peerID
+	recent := t.failures[key][:0]
+	for _, at := range t.failures[key] {
+		if now.Sub(at) < iceFailureWindow {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, now)
+	t.failures[key] = recent
+	return len(recent)
+}
+
+// reset forgets the failures between a client and a peer
+func (t *iceFailureTracker) reset(clientID, peerID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.failures, clientID+"|"+peerID)
+}
+
+// handleICEFailure counts an ICE failure the client reported with a peer.
+// After repeated failures the client gets diagnostic hints and, if
+// available, fresh TURN credentials, and operators get a turn-required event.
+// The count is reset afterwards, so hints repeat only if failures continue
+func (c *Client) handleICEFailure(peerID string) {
+	if peerID == "" {
+		return
+	}
+	count := c.Room.iceFailures.record(c.ID, peerID, time.Now())
+	if count < iceFailureThreshold {
+		return
+	}
+	c.Room.iceFailures.reset(c.ID, peerID)
+
+	util.Warn("Client %s failed ICE with %s %d times in room %s, suggesting TURN", c.ID, peerID, count, c.Room.ID)
+	data := map[string]interface{}{
+		"peerId":   peerID,
+		"failures": count,
+		"hints": []string{
+			"Set iceTransportPolicy to \"relay\" and restart ICE with this peer",
+			"A firewall or symmetric NAT is likely blocking direct connections",
+		},
+		"iceTransportPolicy": "relay",
+	}
+	turn := c.hub.TURNCredentials(c.ID)
+	if turn != nil {
+		data["iceServers"] = []ICEServer{*turn}
+	}
+	c.Send(&Message{Type: "ice-diagnostics", To: c.ID, Data: data})
+
+	c.hub.emit(Event{
+		Type:     EventTURNRequired,
+		RoomID:   c.Room.ID,
+		ClientID: c.ID,
+		Data: map[string]interface{}{
+			"peerId":        peerID,
+			"failures":      count,
+			"turnAvailable": turn != nil,
+		},
+	})
+}
@@ -0,0 +1,156 @@
+package signaling
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Failed checks in a row after which an ICE server is left out of the
+// servers handed to clients; one successful check brings it back
+const iceUnhealthyAfter = 2
+
+// ICEServerStatus is the health of one configured STUN or TURN URL
+type ICEServerStatus struct {
+	URL      string    `json:"url"`
+	Healthy  bool      `json:"healthy"`
+	Checked  time.Time `json:"checked"`
+	Failures int       `json:"failures"` // Failed checks in a row
+	Error    string    `json:"error,omitempty"`
+}
+
+// IsTURN reports whether the URL is a TURN server's
+func (s ICEServerStatus) IsTURN() bool {
+	return strings.HasPrefix(s.URL, "turn:") || strings.HasPrefix(s.URL, "turns:")
+}
+
+// ICEServerURLs returns the URLs of the configured TURN servers and of the
+// ICE servers pushed to clients, for health checks
+func (h *Hub) ICEServerURLs() []string {
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+	urls := slices.Clone(h.turn.URLs)
+	for _, server := range h.clientConfig.ICEServers {
+		for _, url := range server.URLs {
+			if !slices.Contains(urls, url) {
+				urls = append(urls, url)
+			}
+		}
+	}
+	return urls
+}
+
+// RecordICEServerCheck records the outcome of a health check of a URL, nil
+// when the server answered. When a server becomes unhealthy or recovers,
+// connected clients get a config-update without it or with it again
+func (h *Hub) RecordICEServerCheck(url string, err error) {
+	h.roomsMutex.Lock()
+	if h.iceHealth == nil {
+		h.iceHealth = make(map[string]*ICEServerStatus)
+	}
+	status, found := h.iceHealth[url]
+	if !found {
+		status = &ICEServerStatus{URL: url, Healthy: true}
+		h.iceHealth[url] = status
+	}
+	wasHealthy := status.Healthy
+	status.Checked = time.Now()
+	if err == nil {
+		status.Failures = 0
+		status.Error = ""
+		status.Healthy = true
+	} else {
+		status.Failures++
+		status.Error = err.Error()
+		status.Healthy = status.Failures < iceUnhealthyAfter
+	}
+	healthy := status.Healthy
+	turnDown := len(h.turn.URLs) > 0 && h.healthyTURNLocked() == 0
+	h.roomsMutex.Unlock()
+
+	if healthy == wasHealthy {
+		return
+	}
+	if healthy {
+		util.Info("ICE server %s is healthy again", url)
+	} else {
+		util.Warn("ICE server %s is unhealthy: %v", url, err)
+		if turnDown {
+			util.Error("No healthy TURN servers left; clients behind symmetric NATs can't connect")
+		}
+	}
+	for _, client := range h.connectedClients() {
+		client.Send(client.configUpdate())
+	}
+}
+
+// healthyTURNLocked counts the configured TURN URLs that are healthy
+func (h *Hub) healthyTURNLocked() int {
+	count := 0
+	for _, url := range h.turn.URLs {
+		if h.iceHealthyLocked(url) {
+			count++
+		}
+	}
+	return count
+}
+
+// iceHealthyLocked reports whether a URL may be handed to clients; URLs not
+// checked yet are
+func (h *Hub) iceHealthyLocked(url string) bool {
+	status, found := h.iceHealth[url]
+	return !found || status.Healthy
+}
+
+// ICEServerHealth returns the health of every checked URL, sorted by URL.
+// URLs no longer configured are left out
+func (h *Hub) ICEServerHealth() []ICEServerStatus {
+	if h == nil {
+		return nil
+	}
+	urls := h.ICEServerURLs()
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+	var statuses []ICEServerStatus
+	for _, url := range urls {
+		if status, found := h.iceHealth[url]; found {
+			statuses = append(statuses, *status)
+		}
+	}
+	slices.SortFunc(statuses, func(a, b ICEServerStatus) int { return strings.Compare(a.URL, b.URL) })
+	return statuses
+}
+
+// healthyICEServers returns the servers with their unhealthy URLs removed,
+// leaving out servers without any healthy URL
+func (h *Hub) healthyICEServers(servers []ICEServer) []ICEServer {
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+	var healthy []ICEServer
+	for _, server := range servers {
+		urls := slices.DeleteFunc(slices.Clone(server.URLs), func(url string) bool {
+			return !h.iceHealthyLocked(url)
+		})
+		if len(urls) > 0 {
+			server.URLs = urls
+			healthy = append(healthy, server)
+		}
+	}
+	return healthy
+}
+
+// ICEServers returns the healthy ICE servers for a client: those pushed to
+// clients, then the TURN server with fresh credentials
+func (h *Hub) ICEServers(clientID string) []ICEServer {
+	config, _ := h.ClientConfig()
+	var servers []ICEServer
+	if h != nil {
+		servers = h.healthyICEServers(config.ICEServers)
+	}
+	if turn := h.TURNCredentials(clientID); turn != nil {
+		servers = append(servers, *turn)
+	}
+	return servers
+}
@@ -0,0 +1,42 @@
+package signaling
+
+import (
+	"errors"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// ErrRoomNotFound is returned for rooms the hub doesn't have
+var ErrRoomNotFound = errors.New("room not found")
+
+// Invitee is someone asked to join a room
+type Invitee struct {
+	UserID string `json:"userId,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Email  string `json:"email,omitempty"`
+}
+
+// Invite asks someone to join a room by emitting a room-invite event, which
+// integrations such as chat webhooks deliver. The room must exist
+func (h *Hub) Invite(roomID string, invitee Invitee) error {
+	if invitee.UserID == "" && invitee.Email == "" {
+		return errors.New("userId or email is required")
+	}
+	if h.FindRoom(roomID) == nil {
+		return ErrRoomNotFound
+	}
+
+	data := map[string]interface{}{}
+	if invitee.UserID != "" {
+		data["userId"] = invitee.UserID
+	}
+	if invitee.Name != "" {
+		data["name"] = invitee.Name
+	}
+	if invitee.Email != "" {
+		data["email"] = invitee.Email
+	}
+	util.Debug("Inviting %s%s to room %s", invitee.UserID, invitee.Email, roomID)
+	h.emit(Event{Type: EventRoomInvite, RoomID: roomID, Data: data})
+	return nil
+}
@@ -0,0 +1,132 @@
+package signaling
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// staleGrace is how long past its pong window a connection may stay silent
+// before the janitor evicts it; its own read deadline normally ends it
+// well before
+const staleGrace = 30 * time.Second
+
+// StartJanitor evicts, at the given interval until the returned stop
+// function is called, clients whose connection went silent past its pong
+// window without being cleaned up. Every node evicts its own clients
+func (h *Hub) StartJanitor(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				h.sweepClients(now)
+			}
+		}
+	}()
+	util.Info("Sweeping stale clients every %v", interval)
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// SweepIdleRooms closes rooms nobody has been in for roomTTL at the given
+// interval until ctx is done. Persistent rooms are kept. It is a singleton
+// job: with a backplane, every node is told to close its idle rooms, so the
+// one node running it sweeps the whole cluster
+func (h *Hub) SweepIdleRooms(ctx context.Context, interval, roomTTL time.Duration) {
+	util.Info("Sweeping rooms idle for %v every %v", roomTTL, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if h.backplane != nil {
+				h.publishCluster(remoteMessage{Node: h.node, IdleTTL: roomTTL})
+			} else {
+				h.sweepRooms(now, roomTTL)
+			}
+		}
+	}
+}
+
+// sweepClients evicts stale clients once
+func (h *Hub) sweepClients(now time.Time) {
+	for _, room := range h.allRooms() {
+		for _, client := range room.GetClients() {
+			if client.stale(now) {
+				util.Warn("Evicting client %s of room %s, silent since %v", client.ID, room.ID, client.silentSince())
+				client.mutex.Lock()
+				client.leaveReason = LeaveTimeout
+				client.mutex.Unlock()
+				client.Close()
+			}
+		}
+	}
+}
+
+// sweepRooms closes the rooms of this node idle for longer than roomTTL once
+func (h *Hub) sweepRooms(now time.Time, roomTTL time.Duration) {
+	for _, room := range h.allRooms() {
+		if room.idle(now, roomTTL) {
+			util.Info("Closing room %s, idle for more than %v", room.ID, roomTTL)
+			if room.IsEmpty() {
+				h.RemoveRoom(room.ID)
+			} else {
+				// Clients waiting for a host who never came are sent away
+				h.CloseRoom(room.ID, "idle")
+			}
+		}
+	}
+}
+
+// allRooms returns the rooms open on this node
+func (h *Hub) allRooms() []*Room {
+	h.roomsMutex.RLock()
+	defer h.roomsMutex.RUnlock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// idle reports whether nobody has been in the room for ttl. Persistent rooms
+// are never idle
+func (r *Room) idle(now time.Time, ttl time.Duration) bool {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	if r.settings.Persistent || len(r.clients) > 0 {
+		return false
+	}
+	return (r.state == RoomCreated || r.state == RoomEnding) && now.Sub(r.stateSince) > ttl
+}
+
+// silentSince returns when the client's connection last showed signs of
+// life, or the zero time if it never read from one
+func (c *Client) silentSince() time.Time {
+	if seen := c.lastSeen.Load(); seen != 0 {
+		return time.Unix(0, seen)
+	}
+	return time.Time{}
+}
+
+// stale reports whether the client's connection has been silent for longer
+// than its read deadline allows, though the client is still around. Clients
+// waiting to resume have a timer of their own, and clients without a
+// connection, such as bridges, are never stale
+func (c *Client) stale(now time.Time) bool {
+	c.mutex.Lock()
+	connected := c.conn != nil && c.lost == nil && !c.closed
+	c.mutex.Unlock()
+	since := c.silentSince()
+	return connected && !since.IsZero() && now.Sub(since) > c.readWait()+staleGrace
+}
@@ -0,0 +1,321 @@
+package signaling
+
+import (
+	"maps"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Types of room events
+const (
+	RoomEventState      = "state"      // The room moved to State
+	RoomEventJoined     = "joined"     // Member joined
+	RoomEventLeft       = "left"       // ClientID left
+	RoomEventSettings   = "settings"   // Settings were replaced, now at Version
+	RoomEventHost       = "host"       // HostID became host, or nobody if empty
+	RoomEventModeration = "moderation" // By took Action on ClientID
+)
+
+// Moderation actions recorded in the event log
+const (
+	ModerationCloseRoom      = "close-room"
+	ModerationStartSidebar   = "start-sidebar"
+	ModerationEndSidebar     = "end-sidebar"
+	ModerationRecordRequest  = "record-participant"
+	ModerationStartRecording = "start-recording"
+	ModerationStopRecording  = "stop-recording"
+	ModerationKick           = "kick"
+	ModerationBan            = "ban"
+	ModerationLock           = "lock-room"
+	ModerationUnlock         = "unlock-room"
+)
+
+// Events written between snapshots of a room
+const snapshotInterval = 100
+
+// RoomEvent is one change to a room's state. A room's events, replayed in
+// order from its latest snapshot, rebuild its state
+type RoomEvent struct {
+	Seq      int64     `json:"seq"`
+	RoomID   string    `json:"roomId"`
+	Type     string    `json:"type"`
+	At       time.Time `json:"at"`
+	ClientID string    `json:"clientId,omitempty"`
+
+	Member   *Member       `json:"member,omitempty"`
+	State    RoomState     `json:"state,omitempty"`
+	Settings *RoomSettings `json:"settings,omitempty"`
+	Version  int64         `json:"version,omitempty"`
+	HostID   string        `json:"hostId,omitempty"`
+	Action   string        `json:"action,omitempty"`
+	By       string        `json:"by,omitempty"`
+
+	// Free-form context, e.g. why a room was closed or which recording was
+	// requested
+	Detail string `json:"detail,omitempty"`
+}
+
+// EventLog is an append-only log of room events with periodic snapshots
+type EventLog interface {
+	// Append writes events, giving each the next sequence number of its room
+	Append(events []RoomEvent) error
+
+	// Events returns a room's events after a sequence number, in order
+	Events(roomID string, afterSeq int64) ([]RoomEvent, error)
+
+	// SaveSnapshot replaces a room's snapshot
+	SaveSnapshot(view RoomView) error
+
+	// Snapshot returns a room's latest snapshot, or an empty view
+	Snapshot(roomID string) (RoomView, error)
+
+	// RoomIDs lists the rooms that have events
+	RoomIDs() ([]string, error)
+}
+
+// RoomView is a room's state as rebuilt from its events
+type RoomView struct {
+	RoomID          string            `json:"roomId"`
+	Seq             int64             `json:"seq"`
+	State           RoomState         `json:"state"`
+	Settings        RoomSettings      `json:"settings"`
+	SettingsVersion int64             `json:"settingsVersion"`
+	HostID          string            `json:"hostId,omitempty"`
+	Members         map[string]Member `json:"members,omitempty"`
+	OpenedAt        time.Time         `json:"openedAt"`
+	UpdatedAt       time.Time         `json:"updatedAt"`
+}
+
+// Apply folds an event into the view
+func (v *RoomView) Apply(event RoomEvent) {
+	v.RoomID = event.RoomID
+	v.Seq = event.Seq
+	v.UpdatedAt = event.At
+	switch event.Type {
+	case RoomEventState:
+		if v.State == RoomClosed || v.State == roomNew {
+			// A closed room's ID may be opened again
+			*v = RoomView{RoomID: v.RoomID, Seq: v.Seq, UpdatedAt: v.UpdatedAt, OpenedAt: event.At}
+		}
+		v.State = event.State
+		if event.State == RoomClosed {
+			v.Members = nil
+		}
+	case RoomEventJoined:
+		if event.Member == nil {
+			return
+		}
+		if v.Members == nil {
+			v.Members = make(map[string]Member)
+		}
+		v.Members[event.ClientID] = *event.Member
+	case RoomEventLeft:
+		delete(v.Members, event.ClientID)
+	case RoomEventSettings:
+		if event.Settings != nil {
+			v.Settings = *event.Settings
+			v.SettingsVersion = event.Version
+		}
+	case RoomEventHost:
+		v.HostID = event.HostID
+	}
+}
+
+// clone copies the view so it can be handed out while the room changes
+func (v RoomView) clone() RoomView {
+	v.Members = maps.Clone(v.Members)
+	return v
+}
+
+// ReplayRoom rebuilds a room's state from its latest snapshot and the events
+// after it
+func ReplayRoom(log EventLog, roomID string) (RoomView, error) {
+	view, err := log.Snapshot(roomID)
+	if err != nil {
+		return RoomView{}, err
+	}
+	events, err := log.Events(roomID, view.Seq)
+	if err != nil {
+		return RoomView{}, err
+	}
+	for _, event := range events {
+		view.Apply(event)
+	}
+	view.RoomID = roomID
+	return view, nil
+}
+
+// SetEventLog sets where room events are written; rooms created afterwards
+// log their joins, leaves, settings, host changes and moderation to it
+func (h *Hub) SetEventLog(log EventLog) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.eventLog = log
+}
+
+// OnRoomEvent registers a handler for every event written to the event log.
+// Handlers run outside the room's locks, in the order events were written
+func (h *Hub) OnRoomEvent(handler func(RoomEvent)) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.roomEventHandlers = append(h.roomEventHandlers, handler)
+}
+
+// RecoverRooms reopens the rooms the event log shows open, e.g. after a
+// crash, with their settings and host. Their former members are logged as
+// having left; they reconnect like after any restart. Rooms that already
+// exist, such as restored persistent rooms, are skipped
+func (h *Hub) RecoverRooms() (int, error) {
+	h.roomsMutex.RLock()
+	log := h.eventLog
+	h.roomsMutex.RUnlock()
+	if log == nil {
+		return 0, nil
+	}
+	roomIDs, err := log.RoomIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, roomID := range roomIDs {
+		view, err := ReplayRoom(log, roomID)
+		if err != nil {
+			util.Warn("Skipping room %s whose events can't be read: %v", roomID, err)
+			continue
+		}
+		if view.State == roomNew || view.State == RoomClosed {
+			continue
+		}
+		room, created := h.getOrCreateRoom(roomID, func(settings *RoomSettings) {
+			*settings = view.Settings
+		})
+		if !created {
+			continue
+		}
+		room.recover(view)
+		room.flushChanges()
+		recovered++
+		util.Info("Recovered room %s from its event log (host %s, %d former members)", roomID, view.HostID, len(view.Members))
+	}
+	return recovered, nil
+}
+
+// recover carries a replayed view over to a reopened room. The next flush
+// logs the host and settings version again, after the former members leave
+func (r *Room) recover(view RoomView) {
+	r.clientMutex.Lock()
+	defer r.clientMutex.Unlock()
+
+	r.hostID = view.HostID
+	if view.SettingsVersion > 0 {
+		r.version = view.SettingsVersion
+	}
+	if !view.OpenedAt.IsZero() {
+		r.createdAt = view.OpenedAt
+	}
+	for clientID := range view.Members {
+		r.pendingEvents = append(r.pendingEvents, RoomEvent{Type: RoomEventLeft, ClientID: clientID, Detail: "recovered"})
+	}
+}
+
+// logModeration records a moderation action in the room's event log
+func (r *Room) logModeration(action, by, clientID, detail string) {
+	if r.eventLog == nil {
+		return
+	}
+	defer r.flushChanges()
+	r.clientMutex.Lock()
+	defer r.clientMutex.Unlock()
+	r.pendingEvents = append(r.pendingEvents, RoomEvent{
+		Type:     RoomEventModeration,
+		Action:   action,
+		By:       by,
+		ClientID: clientID,
+		Detail:   detail,
+	})
+}
+
+// journalLocked turns the changes since the last flush into events. A room
+// opening comes first, then queued moderation, joins and leaves, new settings
+// or host, and the room closing last. The caller must hold clientMutex and
+// hookMutex
+func (r *Room) journalLocked(transitions []RoomTransition, changes []memberChange) []RoomEvent {
+	if r.eventLog == nil {
+		return nil
+	}
+	var events, closing []RoomEvent
+	for _, transition := range transitions {
+		event := RoomEvent{Type: RoomEventState, State: transition.To, At: transition.At}
+		if transition.To == RoomClosed {
+			closing = append(closing, event)
+		} else {
+			events = append(events, event)
+		}
+	}
+	events = append(events, r.pendingEvents...)
+	r.pendingEvents = nil
+	for _, change := range changes {
+		event := RoomEvent{Type: RoomEventJoined, ClientID: change.member.ClientID, At: change.member.JoinedAt}
+		if change.left {
+			event.Type = RoomEventLeft
+		} else {
+			member := change.member
+			event.Member = &member
+		}
+		events = append(events, event)
+	}
+	if r.version != r.view.SettingsVersion {
+		settings := r.settings
+		events = append(events, RoomEvent{Type: RoomEventSettings, Settings: &settings, Version: r.version})
+	}
+	if r.hostID != r.view.HostID {
+		events = append(events, RoomEvent{Type: RoomEventHost, HostID: r.hostID})
+	}
+	events = append(events, closing...)
+
+	now := time.Now()
+	for i := range events {
+		events[i].RoomID = r.ID
+		if events[i].At.IsZero() {
+			events[i].At = now
+		}
+	}
+	return events
+}
+
+// writeJournal appends events to the log, applies them to the room's view,
+// snapshots it every snapshotInterval events and when the room closes, and
+// passes the events to the hub's handlers. The caller must hold hookMutex
+func (r *Room) writeJournal(events []RoomEvent) {
+	if len(events) == 0 {
+		return
+	}
+	if err := r.eventLog.Append(events); err != nil {
+		util.Warn("Error writing %d events of room %s: %v", len(events), r.ID, err)
+		return
+	}
+	for _, event := range events {
+		r.view.Apply(event)
+	}
+	r.sinceSnapshot += len(events)
+	if r.sinceSnapshot >= snapshotInterval || r.view.State == RoomClosed {
+		r.sinceSnapshot = 0
+		if err := r.eventLog.SaveSnapshot(r.view.clone()); err != nil {
+			util.Warn("Error saving snapshot of room %s: %v", r.ID, err)
+		}
+	}
+
+	if r.hub == nil {
+		return
+	}
+	r.hub.roomsMutex.RLock()
+	handlers := r.hub.roomEventHandlers
+	r.hub.roomsMutex.RUnlock()
+	for _, event := range events {
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package signaling
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Most keywords a room can watch for
+const maxKeywords = 100
+
+// SetKeywords replaces the keywords the host is alerted about. Only the host
+// may change them
+func (r *Room) SetKeywords(host *Client, keywords []string) ([]string, error) {
+	keywords, _, err := r.SetKeywordsIf(host, 0, keywords)
+	return keywords, err
+}
+
+// SetKeywordsIf is SetKeywords applied only if the room's settings are still
+// at the given version, or unconditionally when version is 0. It returns the
+// settings' new version, or their current one on ErrSettingsConflict
+func (r *Room) SetKeywordsIf(host *Client, version int64, keywords []string) ([]string, int64, error) {
+	if r.GetHost() != host.ID {
+		return nil, 0, errors.New("only the host can set keywords")
+	}
+	keywords = ParseKeywords(keywords)
+	if len(keywords) > maxKeywords {
+		return nil, 0, errors.New("too many keywords")
+	}
+	version, err := r.UpdateSettingsIf(version, func(settings *RoomSettings) error {
+		settings.Keywords = keywords
+		return nil
+	})
+	if err != nil {
+		return nil, version, err
+	}
+	util.Info("Host %s set %d alert keywords in room %s", host.ID, len(keywords), r.ID)
+	return keywords, version, nil
+}
+
+// ParseKeywords lowercases and deduplicates keywords, dropping empty ones.
+// A keyword may be a phrase of several words
+func ParseKeywords(keywords []string) []string {
+	var parsed []string
+	for _, keyword := range keywords {
+		keyword = strings.Join(words(keyword), " ")
+		if keyword != "" && !slices.Contains(parsed, keyword) {
+			parsed = append(parsed, keyword)
+		}
+	}
+	return parsed
+}
+
+// matchKeywords returns the keywords spoken in a text. Keywords match whole
+// words regardless of case and punctuation, so "price" doesn't match "prices"
+func matchKeywords(keywords []string, text string) []string {
+	if len(keywords) == 0 {
+		return nil
+	}
+	spoken := " " + strings.Join(words(text), " ") + " "
+	var matched []string
+	for _, keyword := range keywords {
+		if strings.Contains(spoken, " "+keyword+" ") {
+			matched = append(matched, keyword)
+		}
+	}
+	return matched
+}
+
+// words splits text into lowercase words
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+// alertKeywords privately tells the host about keywords in a final caption
+func (r *Room) alertKeywords(keywords []string, caption Caption, seq int) {
+	matched := matchKeywords(keywords, caption.Text)
+	if len(matched) == 0 {
+		return
+	}
+	hostID := r.GetHost()
+	host := r.client(hostID)
+	if host == nil {
+		return
+	}
+	for _, keyword := range matched {
+		host.Send(&Message{
+			Type: "keyword-alert",
+			Data: map[string]interface{}{
+				"keyword": keyword,
+				"speaker": caption.Speaker,
+				"text":    caption.Text,
+				"lang":    caption.Lang,
+				"seq":     seq,
+				"at":      caption.At,
+			},
+		})
+	}
+	r.hub.emit(Event{
+		Type:     EventKeywordDetected,
+		RoomID:   r.ID,
+		ClientID: caption.Speaker,
+		Data: map[string]interface{}{
+			"keywords": matched,
+			"text":     caption.Text,
+			"at":       caption.At,
+		},
+	})
+}
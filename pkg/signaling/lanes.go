@@ -0,0 +1,93 @@
+package signaling
+
+import "time"
+
+// lane is a priority class in a client's send queue. Lower values are
+// written first, so offers and ICE candidates never wait behind a burst of
+// reactions
+type lane int
+
+const (
+	laneSignaling lane = iota
+	laneModeration
+	laneChat
+	laneBulk
+	laneCount
+)
+
+// Messages queued for broadcast in each room; see Configure
+var broadcastBuffer = 100
+
+// Buffer sizes per lane; see Configure
+var laneCapacity = [laneCount]int{
+	laneSignaling:  100,
+	laneModeration: 50,
+	laneChat:       100,
+	laneBulk:       50,
+}
+
+// laneFor returns the lane a message type is queued on. Anything not listed
+// is treated as signaling so new control messages are never starved
+func laneFor(msgType string) lane {
+	switch msgType {
+	case "host-change", "host-status", "moderation-ack", "room-locked":
+		return laneModeration
+	case "chat", AppDataType:
+		return laneChat
+	case "reaction", "stats", "digest":
+		return laneBulk
+	default:
+		return laneSignaling
+	}
+}
+
+// newLanes creates the send queues for a client
+func newLanes() [laneCount]chan *Message {
+	var lanes [laneCount]chan *Message
+	for l := range lanes {
+		lanes[l] = make(chan *Message, laneCapacity[l])
+	}
+	return lanes
+}
+
+// queue returns the channel for a lane. Clients built without lanes (as in
+// tests) queue everything on send
+func (c *Client) queue(l lane) chan *Message {
+	if c.lanes[l] != nil {
+		return c.lanes[l]
+	}
+	return c.send
+}
+
+// nextMessage returns the highest-priority queued message without blocking.
+// found is false when every lane is empty; open is false once the queues
+// have been closed
+func (c *Client) nextMessage() (msg *Message, open bool, found bool) {
+	for l := laneSignaling; l < laneCount; l++ {
+		select {
+		case msg, ok := <-c.queue(l):
+			return msg, ok, true
+		default:
+		}
+	}
+	return nil, true, false
+}
+
+// waitMessage blocks until a message is queued on any lane, wake fires or
+// done is closed
+func (c *Client) waitMessage(wake <-chan time.Time, done <-chan struct{}) (msg *Message, open bool, woke bool) {
+	select {
+	case msg, ok := <-c.queue(laneSignaling):
+		return msg, ok, false
+	case msg, ok := <-c.queue(laneModeration):
+		return msg, ok, false
+	case msg, ok := <-c.queue(laneChat):
+		return msg, ok, false
+	case msg, ok := <-c.queue(laneBulk):
+		return msg, ok, false
+	case <-wake:
+		return nil, true, true
+	case <-done:
+		return nil, true, true
+	}
+}
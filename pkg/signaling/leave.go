@@ -0,0 +1,87 @@
+package signaling
+
+import (
+	"errors"
+	"net"
+)
+
+// Reasons a participant left, carried by user-left and kept in meeting
+// records
+const (
+	// The client closed its connection or hung up
+	LeaveNormal = "normal"
+
+	// A host removed or banned the client
+	LeaveKicked = "kicked"
+
+	// The connection stopped answering pings
+	LeaveTimeout = "timeout"
+
+	// The connection broke and the client didn't resume it in time
+	LeaveNetworkError = "network-error"
+
+	// The server shut down
+	LeaveShutdown = "shutdown"
+
+	// A newer connection of the same client took its place
+	LeaveReplaced = "replaced"
+
+	// The room was ended for everyone
+	LeaveRoomEnded = "room-ended"
+
+	// The client's access was revoked
+	LeaveRevoked = "revoked"
+
+	// The client sent too many messages
+	LeaveRateLimited = "rate-limited"
+
+	// The client didn't read its messages fast enough
+	LeaveSlowConnection = "slow-connection"
+
+	// A host turned the client away from the waiting room
+	LeaveDenied = "denied"
+)
+
+// leaveReasons maps the close codes the server closes connections with to
+// why the client left
+var leaveReasons = map[int]string{
+	CloseReplaced:    LeaveReplaced,
+	CloseJoinDenied:  LeaveDenied,
+	CloseRoomEnded:   LeaveRoomEnded,
+	CloseRevoked:     LeaveRevoked,
+	CloseKicked:      LeaveKicked,
+	CloseShutdown:    LeaveShutdown,
+	CloseRateLimited: LeaveRateLimited,
+}
+
+// connectionLeaveReason tells why a connection ended from the error that
+// ended it: a clean close, a missed deadline or anything else
+func connectionLeaveReason(left bool, err error) string {
+	var netErr net.Error
+	switch {
+	case left:
+		return LeaveNormal
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return LeaveTimeout
+	default:
+		return LeaveNetworkError
+	}
+}
+
+// LeaveReason returns why the client left, or is leaving. The close code
+// the server closed it with comes first, then how its connection ended
+func (c *Client) LeaveReason() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.leaveReasonLocked()
+}
+
+func (c *Client) leaveReasonLocked() string {
+	if reason, ok := leaveReasons[c.closeCode]; ok {
+		return reason
+	}
+	if c.leaveReason != "" {
+		return c.leaveReason
+	}
+	return LeaveNormal
+}
@@ -0,0 +1,195 @@
+package signaling
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// RoomState is the lifecycle stage of a room
+type RoomState int
+
+const (
+	// roomNew is a room that hasn't been registered with a hub yet
+	roomNew RoomState = iota
+
+	// RoomCreated means the room exists but nobody has joined yet
+	RoomCreated
+
+	// RoomActive means at least one client is in the room
+	RoomActive
+
+	// RoomEnding means the last client left or the room is being shut down
+	RoomEnding
+
+	// RoomClosed means the room has been removed from the hub
+	RoomClosed
+)
+
+// String returns the protocol name of a room state
+func (s RoomState) String() string {
+	switch s {
+	case roomNew:
+		return "new"
+	case RoomCreated:
+		return "created"
+	case RoomActive:
+		return "active"
+	case RoomEnding:
+		return "ending"
+	case RoomClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// MarshalText encodes the state by name in JSON payloads
+func (s RoomState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a state from its name
+func (s *RoomState) UnmarshalText(text []byte) error {
+	for state := roomNew; state <= RoomClosed; state++ {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown room state %q", text)
+}
+
+// RoomTransition describes a change of room state
+type RoomTransition struct {
+	From RoomState
+	To   RoomState
+	At   time.Time
+}
+
+// RoomHook is called after a room changes state. Hooks for one room run in
+// order, outside the room's locks, so they may call back into the room
+type RoomHook func(room *Room, transition RoomTransition)
+
+// canRoomTransition reports whether a room may move between two states
+func canRoomTransition(from, to RoomState) bool {
+	switch to {
+	case RoomCreated:
+		// Persistent rooms go back to created when the last client leaves
+		return from == roomNew || from == RoomEnding
+	case RoomActive:
+		// A client joining while the room winds down keeps it alive. Rooms
+		// built without a hub start out new
+		return from == roomNew || from == RoomCreated || from == RoomEnding
+	case RoomEnding:
+		return from == RoomActive
+	case RoomClosed:
+		return from == RoomCreated || from == RoomEnding
+	default:
+		return false
+	}
+}
+
+// State returns the room's lifecycle state
+func (r *Room) State() RoomState {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.state
+}
+
+// transitionLocked moves the room to a new state and records the transition
+// for flushChanges; the caller must hold clientMutex
+func (r *Room) transitionLocked(to RoomState) bool {
+	if !canRoomTransition(r.state, to) {
+		return false
+	}
+	transition := RoomTransition{From: r.state, To: to, At: time.Now()}
+	r.state = to
+	r.stateSince = transition.At
+	r.transitions = append(r.transitions, transition)
+	util.Info("Room %s is now %s", r.ID, to)
+	return true
+}
+
+// flushChanges writes the room's events, runs the hooks for recorded
+// transitions, saves the room if its persisted configuration changed and
+// updates the state store. It must be called without clientMutex held;
+// hookMutex keeps events and hooks in order
+func (r *Room) flushChanges() {
+	r.hookMutex.Lock()
+	defer r.hookMutex.Unlock()
+
+	r.clientMutex.Lock()
+	transitions := r.transitions
+	r.transitions = nil
+	hooks := r.hooks
+	dirty := r.dirty
+	r.dirty = false
+	changes := r.memberChanges
+	r.memberChanges = nil
+	record := r.recordLocked()
+	events := r.journalLocked(transitions, changes)
+	tenant := r.settings.Tenant
+	r.clientMutex.Unlock()
+
+	r.writeJournal(events)
+	r.emitChanges(tenant, transitions, changes)
+
+	for _, transition := range transitions {
+		r.trackMeeting(transition)
+		r.stopEmptyRoomRecording(transition)
+		for _, hook := range hooks {
+			hook(r, transition)
+		}
+	}
+	if dirty {
+		r.persist()
+	}
+	if dirty || len(transitions) > 0 || len(changes) > 0 {
+		r.syncState(changes, record)
+	}
+}
+
+// OnRoomTransition registers a hook that runs whenever any room of the hub
+// changes state, e.g. to persist rooms or finalize recordings on close
+func (h *Hub) OnRoomTransition(hook RoomHook) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.hooks = append(h.hooks, hook)
+}
+
+// CloseRoom ends a room: every client is disconnected and the room is
+// removed from the hub. It reports whether the room existed
+func (h *Hub) CloseRoom(roomID string, reason string) bool {
+	return h.closeRoom(roomID, reason, "")
+}
+
+// closeRoom ends a room on behalf of a host, or of the server if by is empty
+func (h *Hub) closeRoom(roomID, reason, by string) bool {
+	h.roomsMutex.RLock()
+	room, exists := h.rooms[roomID]
+	h.roomsMutex.RUnlock()
+	if !exists {
+		return false
+	}
+
+	util.Info("Closing room %s: %s", roomID, reason)
+	room.logModeration(ModerationCloseRoom, by, "", reason)
+	room.clientMutex.Lock()
+	room.transitionLocked(RoomEnding)
+	room.clientMutex.Unlock()
+	room.flushChanges()
+
+	for _, client := range room.GetClients() {
+		client.CloseWithReason(CloseRoomEnded, reason)
+	}
+	for _, client := range room.Waiting() {
+		client.CloseWithReason(CloseRoomEnded, reason)
+	}
+
+	// Closing a room removes it for good, even if it is persistent
+	h.removeRoom(roomID, true)
+	room.forget()
+	return true
+}
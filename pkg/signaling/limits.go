@@ -0,0 +1,137 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ConnectionConfig tunes client connections and rooms. Zero fields keep
+// their defaults
+type ConnectionConfig struct {
+	// Time allowed to write a message to a client
+	WriteWait time.Duration
+
+	// Time allowed to read the next pong from a client
+	PongWait time.Duration
+
+	// How often clients are pinged; must be shorter than PongWait. Zero is
+	// nine tenths of PongWait
+	PingPeriod time.Duration
+
+	// Largest message accepted from a client, in bytes
+	MaxMessageSize int64
+
+	// Limits of message types that differ from MaxMessageSize, in bytes.
+	// They replace the defaults of the types they name
+	MessageLimits map[string]int64
+
+	// Messages queued for a client on its signaling and chat lanes before
+	// they are dropped; the moderation and bulk lanes take half as many
+	SendBuffer int
+
+	// Messages queued for broadcast in each room
+	BroadcastBuffer int
+
+	// Signaling messages held for a client that isn't ready yet
+	MaxPendingSignals int
+
+	// Messages a client may send per second on average, and in a burst;
+	// messages over the limit are dropped
+	MessageRate  int
+	MessageBurst int
+
+	// How long a client that lost its connection keeps its place in its
+	// room for a new connection to resume it
+	ResumeWindow time.Duration
+}
+
+// DefaultConnectionConfig returns the settings used unless Configure says
+// otherwise
+func DefaultConnectionConfig() ConnectionConfig {
+	return ConnectionConfig{
+		WriteWait:         10 * time.Second,
+		PongWait:          60 * time.Second,
+		PingPeriod:        54 * time.Second,
+		MaxMessageSize:    10000,
+		SendBuffer:        100,
+		BroadcastBuffer:   100,
+		MaxPendingSignals: 200,
+		MessageRate:       20,
+		MessageBurst:      50,
+		ResumeWindow:      30 * time.Second,
+	}
+}
+
+// Configure applies connection settings. It must be called before the hub
+// serves clients, as connections and rooms read the settings unguarded
+func Configure(config ConnectionConfig) error {
+	defaults := DefaultConnectionConfig()
+	if config.WriteWait == 0 {
+		config.WriteWait = defaults.WriteWait
+	}
+	if config.PongWait == 0 {
+		config.PongWait = defaults.PongWait
+	}
+	if config.PingPeriod == 0 {
+		config.PingPeriod = config.PongWait * 9 / 10
+	}
+	if config.MaxMessageSize == 0 {
+		config.MaxMessageSize = defaults.MaxMessageSize
+	}
+	if config.SendBuffer == 0 {
+		config.SendBuffer = defaults.SendBuffer
+	}
+	if config.BroadcastBuffer == 0 {
+		config.BroadcastBuffer = defaults.BroadcastBuffer
+	}
+	if config.MaxPendingSignals == 0 {
+		config.MaxPendingSignals = defaults.MaxPendingSignals
+	}
+	if config.MessageRate == 0 {
+		config.MessageRate = defaults.MessageRate
+	}
+	if config.MessageBurst == 0 {
+		config.MessageBurst = defaults.MessageBurst
+	}
+	if config.ResumeWindow == 0 {
+		config.ResumeWindow = defaults.ResumeWindow
+	}
+
+	switch {
+	case config.WriteWait < 0 || config.PongWait < 0 || config.PingPeriod < 0 || config.ResumeWindow < 0:
+		return errors.New("timeouts can't be negative")
+	case config.PingPeriod >= config.PongWait:
+		return errors.New("the ping period must be shorter than the pong wait")
+	case config.MaxMessageSize < 0 || config.SendBuffer < 0 || config.BroadcastBuffer < 0 || config.MaxPendingSignals < 0:
+		return errors.New("sizes can't be negative")
+	case config.MessageRate < 0 || config.MessageBurst < 0:
+		return errors.New("message limits can't be negative")
+	}
+	limits := DefaultMessageLimits()
+	for msgType, limit := range config.MessageLimits {
+		if limit <= 0 {
+			return fmt.Errorf("the size limit of %s messages must be positive", msgType)
+		}
+		limits[msgType] = limit
+	}
+
+	writeWait = config.WriteWait
+	pongWait = config.PongWait
+	pingPeriod = config.PingPeriod
+	maxMessageSize = config.MaxMessageSize
+	messageLimits = limits
+	maxFrameSize = largestLimit(maxMessageSize, messageLimits)
+	laneCapacity = [laneCount]int{
+		laneSignaling:  config.SendBuffer,
+		laneModeration: max(config.SendBuffer/2, 1),
+		laneChat:       config.SendBuffer,
+		laneBulk:       max(config.SendBuffer/2, 1),
+	}
+	broadcastBuffer = config.BroadcastBuffer
+	maxPendingSignals = config.MaxPendingSignals
+	messageRate = config.MessageRate
+	messageBurst = config.MessageBurst
+	resumeWindow = config.ResumeWindow
+	return nil
+}
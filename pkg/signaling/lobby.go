@@ -0,0 +1,223 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// ErrWaitingRoom is returned by Room.Join when the client was parked in the
+// room's waiting room until the host admits it
+var ErrWaitingRoom = errors.New("waiting for the host to admit the client")
+
+// ErrNotWaiting is returned when the host admits or denies a client that
+// isn't in the waiting room
+var ErrNotWaiting = errors.New("client is not in the waiting room")
+
+// waitsLocked reports whether a new client has to wait for the host to admit
+// it. The room's host, and the first client of a room without one, join
+// directly; the caller must hold clientMutex
+func (r *Room) waitsLocked(client *Client) bool {
+	return r.settings.WaitingRoom && r.hostID != "" && client.ID != r.hostID
+}
+
+// parkLocked puts a client in the waiting room and asks the host to admit
+// it, returning an earlier connection of the same client that was waiting;
+// the caller must hold clientMutex
+func (r *Room) parkLocked(client *Client) *Client {
+	if r.lobby == nil {
+		r.lobby = make(map[string]*Client)
+	}
+	replaced := r.lobby[client.ID]
+	if replaced != nil && !replaced.sameParticipant(client) {
+		// Only the same verified user takes a waiting client's place
+		r.renameDuplicateLocked(client)
+		replaced = nil
+	}
+	r.lobby[client.ID] = client
+
+	client.mutex.Lock()
+	client.waiting = true
+	client.mutex.Unlock()
+	util.Info("Client %s is waiting to join room %s", client.ID, r.ID)
+
+	client.Send(&Message{
+		Type: "waiting-room",
+		To:   client.ID,
+		Data: map[string]interface{}{"roomId": r.ID, "clientId": client.ID},
+	})
+	if host, exists := r.clients[r.hostID]; exists {
+		host.Send(admissionRequest(client))
+	}
+	return replaced
+}
+
+// admissionRequest asks the host to admit a waiting client
+func admissionRequest(client *Client) *Message {
+	request := &Message{
+		Type: "admission-request",
+		Data: map[string]interface{}{
+			"clientId":  client.ID,
+			"accountId": client.userKey(),
+			"deviceId":  client.DeviceID,
+			"verified":  client.Verified,
+		},
+	}
+	client.Persona().addTo(request.Data)
+	return request
+}
+
+// sendAdmissionRequestsLocked asks a new host to admit everyone waiting; the
+// caller must hold clientMutex
+func (r *Room) sendAdmissionRequestsLocked(host *Client) {
+	for _, client := range r.lobby {
+		host.Send(admissionRequest(client))
+	}
+}
+
+// sendAdmissionRequests asks the host to admit everyone waiting
+func (r *Room) sendAdmissionRequests(host *Client) {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	r.sendAdmissionRequestsLocked(host)
+}
+
+// Waiting returns the clients waiting for the host to admit them
+func (r *Room) Waiting() []*Client {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	waiting := make([]*Client, 0, len(r.lobby))
+	for _, client := range r.lobby {
+		waiting = append(waiting, client)
+	}
+	return waiting
+}
+
+// Admit lets a waiting client into the room on behalf of the host. The
+// client is welcomed and the room told about it as if it had just joined,
+// and a join message it sent while waiting is handled now
+func (r *Room) Admit(host *Client, clientID string) error {
+	client, joinMsg, err := r.admitClient(host, clientID)
+	if err != nil {
+		return err
+	}
+	util.Info("Host %s admitted client %s to room %s", host.ID, clientID, r.ID)
+
+	r.traceClient(TraceJoin, client)
+	client.announceJoin()
+	client.connectMedia()
+	if joinMsg != nil {
+		client.handleMessage(joinMsg)
+	}
+	return nil
+}
+
+// admitClient moves a waiting client into the room, returning the join
+// message it sent while waiting
+func (r *Room) admitClient(host *Client, clientID string) (*Client, *Message, error) {
+	defer r.flushChanges()
+	r.clientMutex.Lock()
+	defer r.clientMutex.Unlock()
+
+	if host.ID != r.hostID {
+		return nil, nil, fmt.Errorf("admitting clients: %w", ErrRoleDenied)
+	}
+	client, waiting := r.lobby[clientID]
+	if !waiting {
+		return nil, nil, ErrNotWaiting
+	}
+	if _, exists := r.clients[clientID]; exists {
+		return nil, nil, ErrDuplicateClient
+	}
+	if r.fullLocked() {
+		return nil, nil, ErrRoomFull
+	}
+
+	// A client that is disconnecting stays in the waiting room, where Close
+	// looks for it
+	client.mutex.Lock()
+	if client.closed {
+		client.mutex.Unlock()
+		return nil, nil, ErrNotWaiting
+	}
+	client.waiting = false
+	joinMsg := client.lobbyJoin
+	client.lobbyJoin = nil
+	client.transitionLocked(StateJoined)
+	client.mutex.Unlock()
+
+	delete(r.lobby, clientID)
+	r.addClientLocked(client)
+	return client, joinMsg, nil
+}
+
+// Deny turns a waiting client away on behalf of the host; it gets a
+// join-denied message with the reason and is disconnected
+func (r *Room) Deny(host *Client, clientID, reason string) error {
+	r.clientMutex.Lock()
+	if host.ID != r.hostID {
+		r.clientMutex.Unlock()
+		return fmt.Errorf("denying clients: %w", ErrRoleDenied)
+	}
+	client, waiting := r.lobby[clientID]
+	delete(r.lobby, clientID)
+	r.clientMutex.Unlock()
+	if !waiting {
+		return ErrNotWaiting
+	}
+
+	util.Info("Host %s denied client %s entry to room %s", host.ID, clientID, r.ID)
+	message := "the host denied entry"
+	if reason != "" {
+		message = reason
+	}
+	client.dismiss(CloseJoinDenied, "denied", &Message{
+		Type: "join-denied",
+		To:   clientID,
+		Data: map[string]interface{}{"reason": "denied", "message": message},
+	})
+	return nil
+}
+
+// leaveLobby removes a client that disconnected while waiting, telling the
+// host it no longer needs admitting
+func (r *Room) leaveLobby(client *Client) {
+	r.clientMutex.Lock()
+	defer r.clientMutex.Unlock()
+
+	if current, waiting := r.lobby[client.ID]; !waiting || current != client {
+		return
+	}
+	delete(r.lobby, client.ID)
+	if host, exists := r.clients[r.hostID]; exists {
+		host.Send(&Message{Type: "admission-cancelled", Data: map[string]interface{}{"clientId": client.ID}})
+	}
+}
+
+// Waiting reports whether the client is in its room's waiting room
+func (c *Client) Waiting() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.waiting
+}
+
+// holdWhileWaiting keeps a waiting client's messages from the room. Its
+// join message is kept for when it is admitted; anything else is refused.
+// It reports whether the message was held
+func (c *Client) holdWhileWaiting(msg *Message) bool {
+	c.mutex.Lock()
+	if !c.waiting {
+		c.mutex.Unlock()
+		return false
+	}
+	if msg.Type == "join" {
+		c.lobbyJoin = msg
+	}
+	c.mutex.Unlock()
+
+	if msg.Type != "join" {
+		c.sendError(msg, ErrorNotPermitted, ErrWaitingRoom)
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package signaling
+
+import (
+	"errors"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// ErrNoMediaServer is returned when a room in SFU mode is created on a hub
+// without a media server
+var ErrNoMediaServer = errors.New("SFU mode requires the SFU to be enabled")
+
+// MediaServer forwards media between the participants of rooms in SFU mode,
+// such as the server's own SFU. It makes every offer; participants answer
+type MediaServer interface {
+	// Connect starts a client's peer connection and returns its session ID.
+	// offer is called with each SDP offer the client must answer, the first
+	// one and those made whenever tracks come and go
+	Connect(roomID, clientID string, offer func(sdp string)) (string, error)
+
+	// Answer applies the client's answer to the session's last offer
+	Answer(sessionID, sdp string) error
+
+	// RestartICE sends the client an offer that restarts ICE, e.g. after
+	// it moved to another network
+	RestartICE(sessionID string) error
+
+	// CloseSession ends a client's peer connection
+	CloseSession(sessionID string) error
+}
+
+// SetMediaServer sets the media server of rooms in SFU mode. Rooms already
+// open, such as restored persistent rooms, get it too; it must be called
+// before clients connect
+func (h *Hub) SetMediaServer(media MediaServer) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.media = media
+	for _, room := range h.rooms {
+		room.media = media
+	}
+}
+
+// connectMedia connects a client of a room in SFU mode to the media server,
+// which sends it sfu-offer messages to answer with sfu-answer
+func (c *Client) connectMedia() {
+	room := c.Room
+	if room.Settings().MediaMode() != ModeSFU {
+		return
+	}
+	if room.media == nil {
+		util.Warn("Room %s is in SFU mode but no media server is configured", room.ID)
+		return
+	}
+
+	sessionID, err := room.media.Connect(room.ID, c.ID, func(sdp string) {
+		c.Send(&Message{Type: "sfu-offer", To: c.ID, Data: map[string]interface{}{"sdp": sdp}})
+	})
+	if err != nil {
+		util.Warn("Error connecting client %s to the SFU in room %s: %v", c.ID, room.ID, err)
+		c.Send(&Message{Type: "sfu-error", To: c.ID, Data: map[string]interface{}{"reason": err.Error()}})
+		return
+	}
+
+	c.mutex.Lock()
+	closed := c.closed
+	if !closed {
+		c.mediaSession = sessionID
+	}
+	c.mutex.Unlock()
+	if closed {
+		// The client left while connecting
+		room.media.CloseSession(sessionID)
+	}
+}
+
+// answerMedia passes the client's answer to the media server
+func (c *Client) answerMedia(sdp string) error {
+	c.mutex.Lock()
+	sessionID := c.mediaSession
+	c.mutex.Unlock()
+	if sessionID == "" {
+		return errors.New("not connected to the SFU")
+	}
+	if sdp == "" {
+		return errors.New("sdp is required")
+	}
+	return c.Room.media.Answer(sessionID, sdp)
+}
+
+// restartMedia restarts ICE on the client's peer connection with the media
+// server, if it has one
+func (c *Client) restartMedia() {
+	c.mutex.Lock()
+	sessionID := c.mediaSession
+	c.mutex.Unlock()
+	if sessionID == "" {
+		return
+	}
+	if err := c.Room.media.RestartICE(sessionID); err != nil {
+		util.Warn("Error restarting ICE of client %s with the SFU: %v", c.ID, err)
+	}
+}
+
+// disconnectMedia ends the client's peer connection with the media server
+func (c *Client) disconnectMedia() {
+	c.mutex.Lock()
+	sessionID := c.mediaSession
+	c.mediaSession = ""
+	c.mutex.Unlock()
+	if sessionID == "" {
+		return
+	}
+	if err := c.Room.media.CloseSession(sessionID); err != nil {
+		util.Debug("Error closing SFU session of client %s: %v", c.ID, err)
+	}
+}
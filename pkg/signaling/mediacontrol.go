@@ -0,0 +1,141 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Kinds of media the host can turn off
+const (
+	MediaAudio = "audio"
+	MediaVideo = "video"
+)
+
+// errMediaKind is returned for an unmute-self of something other than
+// audio or video
+var errMediaKind = errors.New(`kind must be "audio" or "video"`)
+
+// MediaControl is what the host turned off of a participant's media. It
+// holds until the participant turns it back on, and is forgotten when the
+// participant leaves
+type MediaControl struct {
+	AudioMuted   bool `json:"serverMuted,omitempty"`
+	VideoStopped bool `json:"videoStopped,omitempty"`
+}
+
+// MediaControlOf returns what the host turned off of a client's media
+func (r *Room) MediaControlOf(clientID string) MediaControl {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.controlled[clientID]
+}
+
+// setControlLocked records that the host turned off a kind of a client's
+// media; the caller must hold clientMutex
+func (r *Room) setControlLocked(clientID, kind string) {
+	if r.controlled == nil {
+		r.controlled = make(map[string]MediaControl)
+	}
+	control := r.controlled[clientID]
+	if kind == MediaVideo {
+		control.VideoStopped = true
+	} else {
+		control.AudioMuted = true
+	}
+	r.controlled[clientID] = control
+}
+
+// ControlMedia turns off a participant's microphone (mute-user) or camera
+// (stop-video-user) on behalf of the host. The others in the room get the
+// message with isHost set, so clients can tell it carries the host's
+// authority; the participant turns its media off when it arrives
+func (r *Room) ControlMedia(host *Client, msgType, clientID string) error {
+	kind := MediaAudio
+	if msgType == "stop-video-user" {
+		kind = MediaVideo
+	}
+
+	r.clientMutex.Lock()
+	if host.ID != r.hostID {
+		r.clientMutex.Unlock()
+		return fmt.Errorf("%s: %w", msgType, ErrRoleDenied)
+	}
+	if _, exists := r.clients[clientID]; !exists {
+		r.clientMutex.Unlock()
+		return ErrRecipientNotFound
+	}
+	r.setControlLocked(clientID, kind)
+	r.clientMutex.Unlock()
+
+	util.Info("Host %s turned off %s of client %s in room %s", host.ID, kind, clientID, r.ID)
+	r.Broadcast(&Message{
+		Type:   msgType,
+		From:   host.ID,
+		IsHost: true,
+		Data:   map[string]interface{}{"clientId": clientID, "kind": kind, "by": host.ID},
+	}, "")
+	return nil
+}
+
+// MuteAll mutes everyone in the room but the host, on the host's behalf.
+// The others get mute-all with the clientIds muted
+func (r *Room) MuteAll(host *Client) error {
+	r.clientMutex.Lock()
+	if host.ID != r.hostID {
+		r.clientMutex.Unlock()
+		return fmt.Errorf("mute-all: %w", ErrRoleDenied)
+	}
+	muted := []string{}
+	for clientID := range r.clients {
+		if clientID != host.ID {
+			r.setControlLocked(clientID, MediaAudio)
+			muted = append(muted, clientID)
+		}
+	}
+	r.clientMutex.Unlock()
+	sort.Strings(muted)
+
+	util.Info("Host %s muted %d clients in room %s", host.ID, len(muted), r.ID)
+	r.Broadcast(&Message{
+		Type:   "mute-all",
+		From:   host.ID,
+		IsHost: true,
+		Data:   map[string]interface{}{"clientIds": muted, "kind": MediaAudio, "by": host.ID},
+	}, "")
+	return nil
+}
+
+// unmuteSelf lifts what the host turned off once the client turns it back
+// on, telling the room. Nothing is sent if the host hadn't turned it off
+func (c *Client) unmuteSelf(kind string) error {
+	if kind != MediaAudio && kind != MediaVideo {
+		return errMediaKind
+	}
+	room := c.Room
+	room.clientMutex.Lock()
+	control, found := room.controlled[c.ID]
+	lifted := found && (kind == MediaAudio && control.AudioMuted || kind == MediaVideo && control.VideoStopped)
+	if kind == MediaVideo {
+		control.VideoStopped = false
+	} else {
+		control.AudioMuted = false
+	}
+	if control == (MediaControl{}) {
+		delete(room.controlled, c.ID)
+	} else if found {
+		room.controlled[c.ID] = control
+	}
+	room.clientMutex.Unlock()
+
+	if lifted {
+		room.Broadcast(&Message{
+			Type: "unmute-self",
+			From: c.ID,
+			Data: map[string]interface{}{"clientId": c.ID, "kind": kind},
+		}, c.ID)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// How long summarizing a meeting may take
+const summarizeTimeout = 2 * time.Minute
+
+// ErrMeetingNotFound is returned for unknown meeting IDs
+var ErrMeetingNotFound = errors.New("meeting not found")
+
+// Meeting is the record of one call in a room, from the first join until
+// the last participant leaves
+type Meeting struct {
+	ID           string               `json:"id"`
+	RoomID       string               `json:"roomId"`
+	Tenant       string               `json:"tenant,omitempty"`
+	StartedAt    time.Time            `json:"startedAt"`
+	EndedAt      time.Time            `json:"endedAt,omitzero"`
+	Participants []MeetingParticipant `json:"participants"`
+	Transcript   []Caption            `json:"transcript,omitempty"`
+	TalkTime     map[string]TalkTime  `json:"talkTime,omitempty"`
+	Summary      *MeetingSummary      `json:"summary,omitempty"`
+}
+
+// MeetingParticipant is one stay of a client in a meeting
+type MeetingParticipant struct {
+	ClientID string    `json:"clientId"`
+	UserID   string    `json:"userId,omitempty"`
+	JoinedAt time.Time `json:"joinedAt"`
+	LeftAt   time.Time `json:"leftAt,omitzero"`
+
+	// Why the participant left, e.g. "kicked" or "network-error"
+	LeaveReason string `json:"leaveReason,omitempty"`
+}
+
+// MeetingSummary is what a summarizer made of a meeting's transcript
+type MeetingSummary struct {
+	Summary     string    `json:"summary"`
+	ActionItems []string  `json:"actionItems"`
+	GeneratedAt time.Time `json:"generatedAt"`
+}
+
+// MeetingStore keeps meeting records after their rooms are gone
+type MeetingStore interface {
+	SaveMeeting(meeting Meeting) error
+}
+
+// Summarizer turns a finished meeting's transcript into a summary and
+// action items, e.g. through an LLM
+type Summarizer interface {
+	Summarize(ctx context.Context, meeting Meeting) (MeetingSummary, error)
+}
+
+// SetMeetingStore sets where meeting records are saved. It must be called
+// before rooms are created
+func (h *Hub) SetMeetingStore(store MeetingStore) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.meetingStore = store
+}
+
+// SetSummarizer sets the summarizer run on transcripts of finished meetings.
+// It must be called before rooms are created
+func (h *Hub) SetSummarizer(summarizer Summarizer) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.summarizer = summarizer
+}
+
+// Meeting returns the record of the room's current meeting, if one is running
+func (r *Room) Meeting() (Meeting, bool) {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	if r.meeting == nil {
+		return Meeting{}, false
+	}
+	return r.meeting.clone(), true
+}
+
+// clone copies a meeting so it can be used outside the room lock
+func (m *Meeting) clone() Meeting {
+	meeting := *m
+	meeting.Participants = slices.Clone(m.Participants)
+	meeting.TalkTime = maps.Clone(m.TalkTime)
+	return meeting
+}
+
+// meetingJoinLocked records a client joining, starting a meeting if none is
+// running; the caller must hold clientMutex
+func (r *Room) meetingJoinLocked(client *Client) {
+	now := time.Now()
+	if r.meeting == nil {
+		r.meeting = &Meeting{
+			ID:        randomToken(8),
+			RoomID:    r.ID,
+			Tenant:    r.settings.Tenant,
+			StartedAt: now,
+		}
+		r.meetingStarted = true
+	}
+	r.meeting.Participants = append(r.meeting.Participants, MeetingParticipant{
+		ClientID: client.ID,
+		UserID:   client.UserID,
+		JoinedAt: now,
+	})
+}
+
+// meetingLeaveLocked records a client leaving and why; the caller must hold
+// clientMutex
+func (r *Room) meetingLeaveLocked(clientID, reason string) {
+	r.stopSpeakingLocked(clientID, time.Now())
+	if r.meeting == nil {
+		return
+	}
+	for i := len(r.meeting.Participants) - 1; i >= 0; i-- {
+		participant := &r.meeting.Participants[i]
+		if participant.ClientID == clientID && participant.LeftAt.IsZero() {
+			participant.LeftAt = time.Now()
+			participant.LeaveReason = reason
+			return
+		}
+	}
+}
+
+// trackMeeting saves a meeting when it starts and finishes it once the
+// room winds down for good. A client joining while the room is ending
+// continues the same meeting
+func (r *Room) trackMeeting(transition RoomTransition) {
+	r.clientMutex.Lock()
+	started := r.meetingStarted
+	r.meetingStarted = false
+	var meeting *Meeting
+	if r.meeting != nil && transition.From == RoomEnding &&
+		(transition.To == RoomClosed || transition.To == RoomCreated) {
+		meeting = r.meeting
+		r.meeting = nil
+	}
+	var current Meeting
+	saveCurrent := started && r.meeting != nil
+	if saveCurrent {
+		current = r.meeting.clone()
+	}
+	r.clientMutex.Unlock()
+
+	if saveCurrent {
+		r.saveMeeting(current)
+	}
+	if meeting != nil {
+		r.finishMeeting(meeting, transition.At)
+	}
+}
+
+// finishMeeting saves the record of a finished meeting with its transcript
+// and starts summarizing it
+func (r *Room) finishMeeting(meeting *Meeting, endedAt time.Time) {
+	meeting.EndedAt = endedAt
+	meeting.Transcript = r.takeTranscript()
+	r.saveMeeting(*meeting)
+	util.Info("Meeting %s in room %s ended after %s with %d participants",
+		meeting.ID, r.ID, endedAt.Sub(meeting.StartedAt).Round(time.Second), len(meeting.Participants))
+
+	r.hub.emit(Event{
+		Type:   EventMeetingEnded,
+		RoomID: r.ID,
+		Tenant: meeting.Tenant,
+		Data: map[string]interface{}{
+			"meetingId": meeting.ID,
+			"startedAt": meeting.StartedAt,
+			"endedAt":   meeting.EndedAt,
+		},
+	})
+	if r.summarizer != nil && len(meeting.Transcript) > 0 {
+		go r.summarizeMeeting(*meeting)
+	}
+}
+
+// summarizeMeeting runs the summarizer, attaches the result to the meeting
+// record and emits a meeting-summary event for delivery
+func (r *Room) summarizeMeeting(meeting Meeting) {
+	ctx, cancel := context.WithTimeout(context.Background(), summarizeTimeout)
+	defer cancel()
+
+	summary, err := r.summarizer.Summarize(ctx, meeting)
+	if err != nil {
+		util.Error("Error summarizing meeting %s of room %s: %v", meeting.ID, meeting.RoomID, err)
+		return
+	}
+	if summary.GeneratedAt.IsZero() {
+		summary.GeneratedAt = time.Now()
+	}
+	meeting.Summary = &summary
+	r.saveMeeting(meeting)
+	util.Info("Summarized meeting %s of room %s with %d action items", meeting.ID, meeting.RoomID, len(summary.ActionItems))
+
+	r.hub.emit(Event{
+		Type:   EventMeetingSummary,
+		RoomID: meeting.RoomID,
+		Tenant: meeting.Tenant,
+		Data: map[string]interface{}{
+			"meetingId":   meeting.ID,
+			"startedAt":   meeting.StartedAt,
+			"endedAt":     meeting.EndedAt,
+			"summary":     summary.Summary,
+			"actionItems": summary.ActionItems,
+		},
+	})
+}
+
+// saveMeeting stores a meeting record if the hub has a meeting store
+func (r *Room) saveMeeting(meeting Meeting) {
+	if r.meetingStore == nil {
+		return
+	}
+	if err := r.meetingStore.SaveMeeting(meeting); err != nil {
+		util.Error("Error saving meeting %s of room %s: %v", meeting.ID, r.ID, err)
+	}
+}
@@ -16,4 +16,23 @@ type Message struct {
 
 	// Host status indication
 	IsHost bool `json:"isHost,omitempty"`
+
+	// Optional ID set by the sender; "error" messages answering this one
+	// carry it as relatedMessageId
+	ID string `json:"id,omitempty"`
+
+	// Optional ID set by the sender to have the server record how the
+	// message was delivered to each recipient
+	TraceID string `json:"traceId,omitempty"`
+
+	// Bytes of application data, written to clients as a binary frame;
+	// see AppDataType
+	Payload []byte `json:"payload,omitempty"`
+
+	// Closed by the broadcast loop instead of being delivered; see Room.settle
+	barrier chan struct{}
+
+	// The connection is closed once this message is written; see
+	// Client.dismiss
+	final bool
 }
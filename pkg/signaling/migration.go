@@ -0,0 +1,291 @@
+package signaling
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Messages kept for a client that lost its connection until it resumes
+const maxBacklog = 500
+
+// Connection states of a participant as the others see them
+const (
+	ConnectionConnected    = "connected"
+	ConnectionReconnecting = "reconnecting"
+	ConnectionLeft         = "left"
+)
+
+// ErrResumeExpired is returned when a connection presents a resume token
+// that doesn't belong to a client still in its room
+var ErrResumeExpired = errors.New("resume token is invalid or expired")
+
+// resumeTokens maps the resume token of each client in a room to the
+// client. Tokens are only known to the node that issued them
+type resumeTokens struct {
+	mutex   sync.Mutex
+	clients map[string]*Client
+}
+
+// replace registers a client's new token in place of its old one
+func (t *resumeTokens) replace(old, token string, client *Client) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.clients == nil {
+		t.clients = make(map[string]*Client)
+	}
+	delete(t.clients, old)
+	t.clients[token] = client
+}
+
+// take removes a token, returning its client or nil
+func (t *resumeTokens) take(token string) *Client {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	client := t.clients[token]
+	delete(t.clients, token)
+	return client
+}
+
+// forget drops the token of a client that left
+func (t *resumeTokens) forget(token string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.clients, token)
+}
+
+// issueResumeToken gives the client a new resume token, replacing any
+// earlier one, so each token resumes a client at most once
+func (c *Client) issueResumeToken() string {
+	token := newResumeToken()
+	c.mutex.Lock()
+	old := c.resumeToken
+	c.resumeToken = token
+	c.mutex.Unlock()
+	if c.hub != nil {
+		c.hub.resumes.replace(old, token, c)
+	}
+	return token
+}
+
+// newResumeToken returns a random resume token
+func newResumeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// connection returns the client's current connection
+func (c *Client) connection() *websocket.Conn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn
+}
+
+// ConnectionState returns whether the client is connected, lost its
+// connection and may still resume, or left
+func (c *Client) ConnectionState() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch {
+	case c.closed:
+		return ConnectionLeft
+	case c.lost != nil:
+		return ConnectionReconnecting
+	default:
+		return ConnectionConnected
+	}
+}
+
+// announceConnectionState tells the other participants that the client's
+// connection state changed, so they can explain a frozen tile, e.g. with
+// "Alice is reconnecting". deadline is when a reconnecting client gives up
+func (c *Client) announceConnectionState(state string, deadline time.Time) {
+	data := map[string]interface{}{"clientId": c.ID, "state": state}
+	if name := c.Persona().DisplayName; name != "" {
+		data["displayName"] = name
+	}
+	if !deadline.IsZero() {
+		data["deadline"] = deadline.UnixMilli()
+	}
+	c.Room.Broadcast(&Message{Type: "peer-state", From: c.ID, Data: data}, c.ID)
+}
+
+// connectionLost handles the end of a connection of the client. A client
+// that lost its connection without leaving, e.g. as its phone moved from
+// Wi-Fi to LTE, keeps its place in the room for resumeWindow: messages
+// for it are kept in its backlog, and the other participants get
+// peer-reconnecting. Clients that left, were closed by the server or have
+// no resume token are closed right away. reason is why the connection
+// ended, which the client leaves with if it doesn't resume
+func (c *Client) connectionLost(conn *websocket.Conn, reason string) {
+	c.mutex.Lock()
+	if c.closed || conn != c.conn || c.lost != nil {
+		// The client was closed or its connection replaced already
+		c.mutex.Unlock()
+		return
+	}
+	c.leaveReason = reason
+	if reason == LeaveNormal || c.resumeToken == "" || c.closeCode != 0 || c.waiting || c.hub.Draining() {
+		c.mutex.Unlock()
+		c.Close()
+		return
+	}
+	close(c.connDone)
+	deadline := time.Now().Add(resumeWindow)
+	c.lost = time.AfterFunc(resumeWindow, func() { c.resumeExpired(conn) })
+	c.mutex.Unlock()
+	conn.Close()
+
+	util.Info("Lost the connection of client %s in room %s; it may resume within %v", c.ID, c.Room.ID, resumeWindow)
+	c.Room.Broadcast(&Message{
+		Type: "peer-reconnecting",
+		From: c.ID,
+		Data: map[string]interface{}{"clientId": c.ID},
+	}, c.ID)
+	c.announceConnectionState(ConnectionReconnecting, deadline)
+}
+
+// resumeExpired closes a client that didn't resume in time
+func (c *Client) resumeExpired(conn *websocket.Conn) {
+	c.mutex.Lock()
+	expired := c.lost != nil && c.conn == conn
+	c.mutex.Unlock()
+	if expired {
+		util.Info("Client %s didn't resume within %v", c.ID, resumeWindow)
+		c.Close()
+	}
+}
+
+// backlogLocked keeps a message for a client that lost its connection, so
+// it learns on resuming of what happened meanwhile, such as being made
+// host or sent to a sidebar. Offers, answers and ICE candidates are
+// dropped, as the client restarts ICE when it resumes, and so are
+// reactions, stats and digests. When the backlog is full, the oldest chat
+// message makes room for anything else; the caller must hold the mutex
+func (c *Client) backlogLocked(message *Message) {
+	if isPeerSignal(message.Type) || message.Type == "sfu-offer" || laneFor(message.Type) == laneBulk {
+		c.traceDelivery(message, DeliveryDropped, "connection lost")
+		return
+	}
+	if len(c.backlog) >= maxBacklog {
+		evict := -1
+		if laneFor(message.Type) != laneChat {
+			evict = slices.IndexFunc(c.backlog, func(m *Message) bool { return laneFor(m.Type) == laneChat })
+		}
+		if evict < 0 {
+			util.Warn("Backlog of client %s full, dropping %s", c.ID, message.Type)
+			c.traceDelivery(message, DeliveryDropped, "backlog full")
+			c.countDropped()
+			return
+		}
+		c.traceDelivery(c.backlog[evict], DeliveryDropped, "backlog full")
+		c.countDropped()
+		c.backlog = slices.Delete(c.backlog, evict, evict+1)
+	}
+	c.backlog = append(c.backlog, message)
+	c.traceDelivery(message, DeliveryHeld, "")
+}
+
+// Resume hands a client to a new connection that presents its resume
+// token, e.g. from a new network after the old connection was lost or
+// while it is still dying. The client keeps its ID, role, host status,
+// media session and room state. It gets resumed with a new token, then
+// its backlog, ahead of anything newer. An ICE restart with its peers
+// follows; address is the IP the new connection comes from, which bans
+// cover
+func (h *Hub) Resume(token string, conn *websocket.Conn, address string) (*Client, error) {
+	client := h.resumes.take(token)
+	if client == nil {
+		return nil, ErrResumeExpired
+	}
+	room := client.Room
+	room.clientMutex.RLock()
+	banned := room.banned.covers(&Client{ID: client.ID, UserID: client.UserID, address: address})
+	room.clientMutex.RUnlock()
+	if banned {
+		client.Close()
+		return nil, ErrBanned
+	}
+
+	resumed := &Message{
+		Type: "resumed",
+		To:   client.ID,
+		Data: map[string]interface{}{
+			"roomId":     room.ID,
+			"clientId":   client.ID,
+			"role":       client.Role(),
+			"iceRestart": true,
+		},
+	}
+	next := newResumeToken()
+
+	client.mutex.Lock()
+	if client.closed {
+		client.mutex.Unlock()
+		return nil, ErrResumeExpired
+	}
+	old := client.conn
+	client.leaveReason = ""
+	if client.lost != nil {
+		client.lost.Stop()
+		client.lost = nil
+	} else {
+		close(client.connDone)
+	}
+	client.conn = conn
+	client.connDone = make(chan struct{})
+	client.address = address
+	client.resumeToken = next
+	done := client.connDone
+
+	// The new connection writes the backlog before anything queued after
+	// the lock is released. Chat for a client that isn't ready yet waits
+	// for it as usual
+	resumed.Data["isHost"] = client.isHost
+	resumed.Data["resumeToken"] = next
+	first := []*Message{resumed}
+	for _, message := range client.backlog {
+		if client.state < StateReady && isRelayed(message.Type) {
+			client.deliverLocked(message)
+			continue
+		}
+		first = append(first, message)
+		client.countRelayed(message.Type)
+	}
+	resumed.Data["backlog"] = len(first) - 1
+	client.backlog = nil
+	client.mutex.Unlock()
+	h.resumes.replace(token, next, client)
+	if old != nil {
+		old.Close()
+	}
+
+	go client.readPump(conn)
+	go client.writePump(conn, done, first)
+	client.announceResume()
+	return client, nil
+}
+
+// announceResume tells a client that resumed which participants are in
+// the room now, since some may have left while it was away. The other
+// participants get peer-migrated and answer the ICE restart offers it
+// makes; in SFU mode the media server makes the offer instead
+func (c *Client) announceResume() {
+	room := c.Room
+	c.sendUserList()
+	room.Broadcast(&Message{
+		Type: "peer-migrated",
+		From: c.ID,
+		Data: map[string]interface{}{"clientId": c.ID},
+	}, c.ID)
+	c.announceConnectionState(ConnectionConnected, time.Time{})
+	c.restartMedia()
+	util.Info("Client %s resumed in room %s from %s", c.ID, room.ID, c.address)
+}
@@ -0,0 +1,368 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Moderation commands a host sends to the room
+const (
+	CommandKick       = "kick"
+	CommandBan        = "ban"
+	CommandEndMeeting = "end-meeting"
+	CommandLock       = "lock-room"
+	CommandUnlock     = "unlock-room"
+)
+
+// By of the commands operators issue rather than a host
+const operatorID = "admin"
+
+// How long commands wait for an earlier one that was sequenced but never
+// arrived, e.g. because its node crashed before publishing it
+const commandGapTimeout = 2 * time.Second
+
+// ErrRoomLocked is returned when a new client joins a room its host locked
+var ErrRoomLocked = errors.New("room is locked")
+
+// ErrBanned is returned when a client the host banned joins the room again
+var ErrBanned = errors.New("banned from the room")
+
+// ModerationCommand is a host's command, numbered for the whole cluster so
+// every node applies a room's commands once and in the same order
+type ModerationCommand struct {
+	// Chosen by the host; a retried command keeps its ID and is applied once
+	ID      string `json:"id"`
+	Seq     int64  `json:"seq"`
+	Command string `json:"command"`
+	By      string `json:"by"`
+	Node    string `json:"node"` // Node of the host, which acknowledges the command
+
+	ClientID string `json:"clientId,omitempty"` // Who is kicked or banned
+	Reason   string `json:"reason,omitempty"`
+
+	// User and IP address of a banned client, when its node is the host's
+	UserID  string `json:"userId,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// banList is the clients, users and IP addresses the host banned from a
+// room
+type banList struct {
+	clients   map[string]bool
+	users     map[string]bool
+	addresses map[string]bool
+}
+
+// Bans are the clients, users and IP addresses banned from a room, as
+// persistent rooms keep them
+type Bans struct {
+	Clients   []string `json:"clients,omitempty"`
+	Users     []string `json:"users,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// commandQueue orders a room's moderation commands. Without a backplane the
+// room numbers its commands itself
+type commandQueue struct {
+	applied int64                       // Sequence number of the last command applied
+	waiting map[int64]ModerationCommand // Commands waiting for an earlier one
+	gap     *time.Timer
+
+	issued int64            // Last sequence number given out without a backplane
+	ids    map[string]int64 // Sequence numbers given out without a backplane, by command ID
+}
+
+// Moderate runs a host's command on every node the room is open on. The
+// host is acknowledged with moderation-ack once its node applies the
+// command, or right away when the command ID was already used
+func (r *Room) Moderate(host *Client, command ModerationCommand) error {
+	if !host.IsHost() || r.GetHost() != host.ID {
+		return fmt.Errorf("only the host can %s", command.Command)
+	}
+	switch command.Command {
+	case CommandKick, CommandBan:
+		if command.ClientID == "" || command.ClientID == host.ID {
+			return errors.New("a participant other than the host must be named")
+		}
+		target := r.client(command.ClientID)
+		if _, remote := r.remoteNode(command.ClientID); !remote && target == nil {
+			return ErrRecipientNotFound
+		}
+		if command.Command == CommandBan && target != nil {
+			command.UserID = target.UserID
+			command.Address = target.address
+		}
+	case CommandEndMeeting, CommandLock, CommandUnlock:
+	default:
+		return fmt.Errorf("unknown moderation command %q", command.Command)
+	}
+	if command.ID == "" {
+		command.ID = randomToken(8)
+	}
+	command.By = host.ID
+	command.Node = r.node
+
+	first, err := r.issueCommand(&command)
+	if err != nil || first {
+		return err
+	}
+	util.Info("Ignoring repeated %s %s from %s in room %s", command.Command, command.ID, host.ID, r.ID)
+	host.Send(command.ack(true))
+	return nil
+}
+
+// Disconnect removes a participant on behalf of an operator, e.g. through
+// the admin API, on whichever node it is connected to. The client is told
+// with a kicked message, as when its host kicks it, and may join again
+func (r *Room) Disconnect(clientID, reason string) error {
+	if _, remote := r.remoteNode(clientID); !remote && r.client(clientID) == nil {
+		return ErrRecipientNotFound
+	}
+	_, err := r.issueCommand(&ModerationCommand{
+		ID:       randomToken(8),
+		Command:  CommandKick,
+		By:       operatorID,
+		Node:     r.node,
+		ClientID: clientID,
+		Reason:   reason,
+	})
+	return err
+}
+
+// issueCommand numbers a command and runs it on every node the room is open
+// on. It reports false, running nothing, when the command ID was used before
+func (r *Room) issueCommand(command *ModerationCommand) (bool, error) {
+	seq, first, err := r.sequenceCommand(command.ID)
+	if err != nil {
+		return false, fmt.Errorf("sequencing %s: %w: %w", command.Command, errTemporary, err)
+	}
+	command.Seq = seq
+	if !first {
+		return false, nil
+	}
+
+	if r.backplane == nil {
+		r.receiveCommand(*command)
+		return true, nil
+	}
+	// This node applies the command when it comes back from the backplane,
+	// in the same order as every other node
+	return true, r.publishCommand(*command)
+}
+
+// sequenceCommand numbers a command, reporting whether its ID is new
+func (r *Room) sequenceCommand(commandID string) (int64, bool, error) {
+	if r.backplane != nil {
+		return r.backplane.Sequence(r.ID, commandID)
+	}
+	r.commandMutex.Lock()
+	defer r.commandMutex.Unlock()
+	if seq, used := r.commands.ids[commandID]; used {
+		return seq, false, nil
+	}
+	if r.commands.ids == nil {
+		r.commands.ids = make(map[string]int64)
+	}
+	r.commands.issued++
+	r.commands.ids[commandID] = r.commands.issued
+	return r.commands.issued, true, nil
+}
+
+// receiveCommand applies a command and any that were waiting for it, in
+// sequence order. Commands already applied are dropped; a node that opened
+// the room after earlier commands starts from the first one it receives
+func (r *Room) receiveCommand(command ModerationCommand) {
+	r.commandMutex.Lock()
+	defer r.commandMutex.Unlock()
+
+	queue := &r.commands
+	if command.Seq <= queue.applied {
+		return
+	}
+	if queue.applied == 0 && len(queue.waiting) == 0 {
+		queue.applied = command.Seq - 1
+	}
+	if queue.waiting == nil {
+		queue.waiting = make(map[int64]ModerationCommand)
+	}
+	queue.waiting[command.Seq] = command
+	r.applyWaitingLocked()
+}
+
+// applyWaitingLocked applies the commands that are next in sequence and
+// waits commandGapTimeout for a missing one before skipping it; the caller
+// must hold commandMutex
+func (r *Room) applyWaitingLocked() {
+	queue := &r.commands
+	for {
+		command, ready := queue.waiting[queue.applied+1]
+		if !ready {
+			break
+		}
+		delete(queue.waiting, command.Seq)
+		queue.applied = command.Seq
+		r.applyCommand(command)
+	}
+
+	if queue.gap != nil {
+		queue.gap.Stop()
+		queue.gap = nil
+	}
+	if len(queue.waiting) == 0 {
+		return
+	}
+	queue.gap = time.AfterFunc(commandGapTimeout, func() {
+		r.commandMutex.Lock()
+		defer r.commandMutex.Unlock()
+		next := int64(0)
+		for seq := range queue.waiting {
+			if next == 0 || seq < next {
+				next = seq
+			}
+		}
+		if next == 0 {
+			return
+		}
+		util.Warn("Skipping moderation commands %d to %d of room %s that never arrived", queue.applied+1, next-1, r.ID)
+		queue.applied = next - 1
+		r.applyWaitingLocked()
+	})
+}
+
+// applyCommand carries out a command on this node. The host's node logs it
+// and acknowledges it; the caller must hold commandMutex
+func (r *Room) applyCommand(command ModerationCommand) {
+	issued := command.Node == r.node
+	host := r.client(command.By)
+	if issued && host != nil && command.Command == CommandEndMeeting {
+		// Acknowledged before the host's connection closes with the room
+		host.Send(command.ack(false))
+	}
+
+	switch command.Command {
+	case CommandKick, CommandBan:
+		banned := command.Command == CommandBan
+		if issued {
+			action := ModerationKick
+			if banned {
+				action = ModerationBan
+			}
+			r.logModeration(action, command.By, command.ClientID, command.Reason)
+		}
+		if banned {
+			r.clientMutex.Lock()
+			r.banned.add(command)
+			r.dirty = true
+			r.clientMutex.Unlock()
+			r.flushChanges()
+		}
+		if client := r.client(command.ClientID); client != nil {
+			util.Info("Client %s removed from room %s by %s (banned: %t)", command.ClientID, r.ID, command.By, banned)
+			client.dismiss(CloseKicked, command.Reason, &Message{
+				Type: "kicked",
+				To:   client.ID,
+				Data: map[string]interface{}{"reason": command.Reason, "by": command.By, "banned": banned},
+			})
+		}
+	case CommandEndMeeting:
+		if r.hub != nil {
+			r.hub.closeRoom(r.ID, command.Reason, command.By)
+		}
+	case CommandLock, CommandUnlock:
+		locked := command.Command == CommandLock
+		if issued {
+			action := ModerationUnlock
+			if locked {
+				action = ModerationLock
+			}
+			r.logModeration(action, command.By, "", command.Reason)
+		}
+		r.clientMutex.Lock()
+		r.locked = locked
+		r.clientMutex.Unlock()
+		r.Broadcast(&Message{
+			Type: "room-locked",
+			Data: map[string]interface{}{"locked": locked, "by": command.By},
+		}, "")
+	}
+
+	if issued && host != nil && command.Command != CommandEndMeeting {
+		host.Send(command.ack(false))
+	}
+}
+
+// ack is the acknowledgement the host gets for a command
+func (c ModerationCommand) ack(duplicate bool) *Message {
+	data := map[string]interface{}{
+		"commandId": c.ID,
+		"command":   c.Command,
+		"seq":       c.Seq,
+	}
+	if c.ClientID != "" {
+		data["clientId"] = c.ClientID
+	}
+	if duplicate {
+		data["duplicate"] = true
+	}
+	return &Message{Type: "moderation-ack", To: c.By, Data: data}
+}
+
+// Locked reports whether the room's host locked it against new clients
+func (r *Room) Locked() bool {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.locked
+}
+
+// add bans a command's client, and its user and address when known
+func (b *banList) add(command ModerationCommand) {
+	if b.clients == nil {
+		b.clients = make(map[string]bool)
+		b.users = make(map[string]bool)
+		b.addresses = make(map[string]bool)
+	}
+	if command.ClientID != "" {
+		b.clients[command.ClientID] = true
+	}
+	if command.UserID != "" {
+		b.users[command.UserID] = true
+	}
+	if command.Address != "" {
+		b.addresses[command.Address] = true
+	}
+}
+
+// list returns the bans, sorted
+func (b *banList) list() Bans {
+	return Bans{
+		Clients:   slices.Sorted(maps.Keys(b.clients)),
+		Users:     slices.Sorted(maps.Keys(b.users)),
+		Addresses: slices.Sorted(maps.Keys(b.addresses)),
+	}
+}
+
+// restore adds persisted bans to the list
+func (b *banList) restore(bans Bans) {
+	for _, clientID := range bans.Clients {
+		b.add(ModerationCommand{ClientID: clientID})
+	}
+	for _, userID := range bans.Users {
+		b.add(ModerationCommand{UserID: userID})
+	}
+	for _, address := range bans.Addresses {
+		b.add(ModerationCommand{Address: address})
+	}
+}
+
+// covers reports whether a client joining is banned by its ID, user or
+// address
+func (b *banList) covers(client *Client) bool {
+	return b.clients[client.ID] ||
+		(client.UserID != "" && b.users[client.UserID]) ||
+		(client.address != "" && b.addresses[client.address])
+}
@@ -0,0 +1,194 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// ErrRoomFull is returned when joining a room that has reached its capacity
+var ErrRoomFull = errors.New("room is full")
+
+// errMaxParticipants is returned for settings with a negative participant limit
+var errMaxParticipants = errors.New("maxParticipants can't be negative")
+
+// Most overflow rooms tried for one join before giving up, in case other
+// clients keep filling them first
+const maxOverflowAttempts = 5
+
+// EventOverflowCreated is emitted when an event room spills over into a new
+// overflow room; the overflow room's ID is in the event's data
+const EventOverflowCreated = "overflow-created"
+
+// OverflowSettings makes a room an event room whose latecomers spill over
+// into linked overflow rooms once it is full
+type OverflowSettings struct {
+	// Most participants in the event room and in each of its overflow rooms
+	Capacity int `json:"capacity"`
+}
+
+// validate checks the overflow settings of a room
+func (o *OverflowSettings) validate() error {
+	if o != nil && o.Capacity <= 0 {
+		return errors.New("overflow capacity must be positive")
+	}
+	return nil
+}
+
+// RoomLinks describes how a room is linked to its event or main room, its
+// overflow rooms and the rooms it shares chat with
+type RoomLinks struct {
+	// Event room an overflow room belongs to, or main room of a sidebar
+	MainRoomID string `json:"mainRoomId,omitempty"`
+
+	// Overflow rooms of an event room, oldest first
+	OverflowRooms []OverflowRoom `json:"overflowRooms,omitempty"`
+
+	// Federation the room shares its chat in
+	ChatFederation string `json:"chatFederation,omitempty"`
+}
+
+// OverflowRoom is one overflow room of an event room
+type OverflowRoom struct {
+	RoomID       string `json:"roomId"`
+	Participants int    `json:"participants"`
+}
+
+// fullLocked reports whether the room has no space for another client; the
+// caller must hold clientMutex
+func (r *Room) fullLocked() bool {
+	capacity := r.capacityLocked()
+	return capacity > 0 && len(r.clients) >= capacity
+}
+
+// capacityLocked returns the most clients the room takes, the lower of its
+// participant limit and overflow capacity, or 0 for no limit; the caller
+// must hold clientMutex
+func (r *Room) capacityLocked() int {
+	capacity := r.settings.MaxParticipants
+	if overflow := r.settings.Overflow; overflow != nil && overflow.Capacity > 0 && (capacity == 0 || overflow.Capacity < capacity) {
+		capacity = overflow.Capacity
+	}
+	return capacity
+}
+
+// IsFull reports whether the room has reached its capacity
+func (r *Room) IsFull() bool {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.fullLocked()
+}
+
+// Capacity returns the most clients the room takes, or 0 for no limit
+func (r *Room) Capacity() int {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.capacityLocked()
+}
+
+// OverflowOf returns the event room an overflow room belongs to, or ""
+func (r *Room) OverflowOf() string {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.overflowOf
+}
+
+// Links returns the links of a room to other rooms
+func (h *Hub) Links(roomID string) RoomLinks {
+	h.roomsMutex.RLock()
+	var links RoomLinks
+	if room := h.rooms[roomID]; room != nil {
+		links.MainRoomID = room.OverflowOf()
+		if links.MainRoomID == "" {
+			links.MainRoomID = room.SidebarOf()
+		}
+	}
+	if federation, exists := h.ChatFederationOf(roomID); exists {
+		links.ChatFederation = federation.ID
+	}
+	rooms := make([]*Room, 0, len(h.overflows[roomID]))
+	for _, id := range h.overflows[roomID] {
+		if room := h.rooms[id]; room != nil {
+			rooms = append(rooms, room)
+		}
+	}
+	h.roomsMutex.RUnlock()
+
+	for _, room := range rooms {
+		links.OverflowRooms = append(links.OverflowRooms, OverflowRoom{
+			RoomID:       room.ID,
+			Participants: len(room.GetClients()),
+		})
+	}
+	return links
+}
+
+// joinOverflow adds a client that didn't fit into a full event room to one of
+// its overflow rooms, creating a new overflow room when all are full. The
+// client's Room is set to the room it joined
+func (h *Hub) joinOverflow(main *Room, client *Client) (*Client, error) {
+	for attempt := 0; attempt < maxOverflowAttempts; attempt++ {
+		room := h.overflowRoom(main)
+		client.Room = room
+		replaced, err := room.Join(client)
+		if errors.Is(err, ErrRoomFull) || errors.Is(err, ErrRoomClosed) {
+			continue
+		}
+		if err == nil {
+			util.Info("Client %s spilled over from room %s into %s", client.ID, main.ID, room.ID)
+		}
+		return replaced, err
+	}
+	return nil, ErrRoomFull
+}
+
+// overflowRoom returns an overflow room of the event room with space left,
+// creating one if there is none. New overflow rooms copy the event room's
+// settings but are never persistent
+func (h *Hub) overflowRoom(main *Room) *Room {
+	h.roomsMutex.Lock()
+	for _, id := range h.overflows[main.ID] {
+		if room := h.rooms[id]; room != nil && !room.IsFull() {
+			h.roomsMutex.Unlock()
+			return room
+		}
+	}
+
+	var roomID string
+	for n := 1; ; n++ {
+		roomID = fmt.Sprintf("%s-overflow-%d", main.ID, n)
+		if _, taken := h.rooms[roomID]; !taken {
+			break
+		}
+	}
+	settings := main.Settings()
+	settings.Persistent = false
+	room := h.createRoomLocked(roomID, settings)
+	room.overflowOf = main.ID
+	h.overflows[main.ID] = append(h.overflows[main.ID], roomID)
+	h.roomsMutex.Unlock()
+
+	room.flushChanges()
+	h.federateOverflow(main.ID, roomID)
+	util.Info("Room %s is full, opened overflow room %s", main.ID, roomID)
+	h.emit(Event{Type: EventRoomCreated, RoomID: roomID})
+	h.emit(Event{Type: EventOverflowCreated, RoomID: main.ID, Data: map[string]interface{}{"overflowRoomId": roomID}})
+	return room
+}
+
+// unlinkOverflowLocked forgets the overflow links of a room that is removed;
+// the caller must hold roomsMutex
+func (h *Hub) unlinkOverflowLocked(room *Room) {
+	delete(h.overflows, room.ID)
+	if room.overflowOf == "" {
+		return
+	}
+	siblings := h.overflows[room.overflowOf]
+	for i, id := range siblings {
+		if id == room.ID {
+			h.overflows[room.overflowOf] = append(siblings[:i:i], siblings[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package signaling
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrPasswordRequired is returned when joining a password-protected room
+	// without a password
+	ErrPasswordRequired = errors.New("room requires a password")
+
+	// ErrWrongPassword is returned when joining with the wrong password
+	ErrWrongPassword = errors.New("wrong room password")
+)
+
+// HashPassword hashes a room password with bcrypt for RoomSnapshot
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// validatePasswordHash checks that a snapshot's password hash, if any, is a
+// bcrypt hash
+func validatePasswordHash(hash string) error {
+	if hash == "" {
+		return nil
+	}
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return errors.New("invalid password hash")
+	}
+	return nil
+}
+
+// HasPassword reports whether clients need a password to join the room
+func (r *Room) HasPassword() bool {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.passwordHash != ""
+}
+
+// checkPassword compares a joining client's password with the room's. The
+// comparison is slow on purpose, so it runs without holding the room's locks
+func (r *Room) checkPassword(password string) error {
+	r.clientMutex.RLock()
+	hash := r.passwordHash
+	r.clientMutex.RUnlock()
+	if hash == "" {
+		return nil
+	}
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
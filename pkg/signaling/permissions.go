@@ -0,0 +1,138 @@
+package signaling
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Permission is an ability granted to a client on top of its role
+type Permission string
+
+// PermRecord lets a client ask participants to record their tracks
+const PermRecord Permission = "record"
+
+// ParsePermission returns the permission with the given name
+func ParsePermission(name string) (Permission, error) {
+	switch permission := Permission(name); permission {
+	case PermRecord:
+		return permission, nil
+	default:
+		return "", fmt.Errorf("unknown permission %q", name)
+	}
+}
+
+// RoleRule grants a role and permissions to identities whose claim holds a value
+type RoleRule struct {
+	Claim       string       `json:"claim"` // e.g. "groups" or "roles"
+	Value       string       `json:"value"`
+	Role        Role         `json:"role,omitempty"`
+	Permissions []Permission `json:"permissions,omitempty"`
+}
+
+// RoleMapping turns the claims of identity tokens into roles and permissions
+type RoleMapping struct {
+	Rules []RoleRule `json:"rules"`
+
+	// Role and permissions of verified users that no rule gives a role
+	DefaultRole        Role         `json:"defaultRole,omitempty"`
+	DefaultPermissions []Permission `json:"defaultPermissions,omitempty"`
+
+	// Role of guests without an identity token; guests get no permissions
+	GuestRole Role `json:"guestRole,omitempty"`
+}
+
+// LoadRoleMapping reads a role mapping from a JSON file
+func LoadRoleMapping(path string) (*RoleMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mapping RoleMapping
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := mapping.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &mapping, nil
+}
+
+// validate checks that the mapping only names known roles and permissions
+func (m *RoleMapping) validate() error {
+	check := func(role Role, permissions []Permission) error {
+		if role != "" {
+			if _, err := ParseRole(string(role)); err != nil {
+				return err
+			}
+		}
+		for _, permission := range permissions {
+			if _, err := ParsePermission(string(permission)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, rule := range m.Rules {
+		if rule.Claim == "" || rule.Value == "" {
+			return fmt.Errorf("rule %d: claim and value are required", i)
+		}
+		if err := check(rule.Role, rule.Permissions); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	if err := check(m.DefaultRole, m.DefaultPermissions); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	return check(m.GuestRole, nil)
+}
+
+// Evaluate returns the most the holder of a token may be in a room and what
+// they may do there. claims looks up the values of a claim and is nil for
+// guests. The role is the most privileged one of all matching rules, and the
+// permissions are those of every matching rule; users no rule gives a role get
+// the defaults
+func (m *RoleMapping) Evaluate(claims func(name string) []string) (Role, []Permission) {
+	if claims == nil {
+		return m.GuestRole, []Permission{}
+	}
+
+	var role Role
+	matched := false
+	permissions := []Permission{}
+	for _, rule := range m.Rules {
+		if !slices.Contains(claims(rule.Claim), rule.Value) {
+			continue
+		}
+		if rule.Role != "" && (!matched || !role.Allows(rule.Role)) {
+			role = rule.Role
+			matched = true
+		}
+		permissions = appendPermissions(permissions, rule.Permissions)
+	}
+	if !matched {
+		role = m.DefaultRole
+		permissions = appendPermissions(permissions, m.DefaultPermissions)
+	}
+	return role, permissions
+}
+
+// appendPermissions adds the permissions that aren't in the list yet
+func appendPermissions(list, permissions []Permission) []Permission {
+	for _, permission := range permissions {
+		if !slices.Contains(list, permission) {
+			list = append(list, permission)
+		}
+	}
+	return list
+}
+
+// may reports whether the client has a permission. Clients without explicit
+// permissions have those of their role, so only the host may record
+func (c *Client) may(permission Permission) bool {
+	if c.permissions == nil {
+		return c.IsHost()
+	}
+	return slices.Contains(c.permissions, permission)
+}
@@ -0,0 +1,184 @@
+package signaling
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// RoomSnapshot is the persisted configuration of a room
+type RoomSnapshot struct {
+	ID        string       `json:"id"`
+	Settings  RoomSettings `json:"settings"`
+	HostID    string       `json:"hostId,omitempty"`
+	CreatedAt time.Time    `json:"createdAt"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+
+	// Version of the settings, kept so ETags stay valid across restarts
+	SettingsVersion int64 `json:"settingsVersion,omitempty"`
+
+	// Bcrypt hash of the room's password, from HashPassword
+	PasswordHash string `json:"passwordHash,omitempty"`
+
+	// Who the host banned, so they stay out after a restart
+	Bans Bans `json:"bans"`
+}
+
+// RoomStore persists rooms flagged as persistent so they survive restarts
+type RoomStore interface {
+	SaveRoom(snapshot RoomSnapshot) error
+	DeleteRoom(roomID string) error
+	LoadRooms() ([]RoomSnapshot, error)
+}
+
+// ErrRoomExists is returned when importing a room whose ID is already taken
+var ErrRoomExists = errors.New("room already exists")
+
+// SetRoomStore sets where persistent rooms are saved. It must be called
+// before rooms are created
+func (h *Hub) SetRoomStore(store RoomStore) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.store = store
+}
+
+// RestoreRooms re-creates all persisted rooms and returns how many were loaded
+func (h *Hub) RestoreRooms() (int, error) {
+	h.roomsMutex.RLock()
+	store := h.store
+	h.roomsMutex.RUnlock()
+	if store == nil {
+		return 0, nil
+	}
+
+	snapshots, err := store.LoadRooms()
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	for _, snapshot := range snapshots {
+		room := h.GetRoomWithSettings(snapshot.ID, func(settings *RoomSettings) {
+			*settings = snapshot.Settings
+		})
+		room.restore(snapshot)
+		room.persist()
+		restored++
+		util.Info("Restored persistent room %s (host %s)", snapshot.ID, snapshot.HostID)
+	}
+	return restored, nil
+}
+
+// ImportRoom creates a room from an exported snapshot. The snapshot's host
+// designation, settings and bans are kept; its timestamps and settings version are
+// reset
+func (h *Hub) ImportRoom(snapshot RoomSnapshot) (*Room, error) {
+	if snapshot.ID == "" {
+		return nil, errors.New("room ID is required")
+	}
+	if err := snapshot.Settings.Overflow.validate(); err != nil {
+		return nil, err
+	}
+	if snapshot.Settings.MaxParticipants < 0 {
+		return nil, errMaxParticipants
+	}
+	if err := validateRoles(snapshot.Settings.Roles); err != nil {
+		return nil, err
+	}
+	if err := validateCustomEventRules(snapshot.Settings.CustomEvents); err != nil {
+		return nil, err
+	}
+	mode, err := ParseRoomMode(string(snapshot.Settings.Mode))
+	if err != nil {
+		return nil, err
+	}
+	if mode == ModeSFU && h.media == nil {
+		return nil, ErrNoMediaServer
+	}
+	if err := validatePasswordHash(snapshot.PasswordHash); err != nil {
+		return nil, err
+	}
+
+	room, created := h.getOrCreateRoom(snapshot.ID, func(settings *RoomSettings) {
+		*settings = snapshot.Settings
+	})
+	if !created {
+		return nil, ErrRoomExists
+	}
+	snapshot.CreatedAt = time.Time{}
+	snapshot.SettingsVersion = 0
+	room.restore(snapshot)
+	room.persist()
+	util.Info("Imported room %s", snapshot.ID)
+	return room, nil
+}
+
+// Snapshot returns the room's persistable configuration
+func (r *Room) Snapshot() RoomSnapshot {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.snapshotLocked()
+}
+
+// snapshotLocked builds a snapshot; the caller must hold clientMutex
+func (r *Room) snapshotLocked() RoomSnapshot {
+	return RoomSnapshot{
+		ID:        r.ID,
+		Settings:  r.settings,
+		HostID:    r.hostID,
+		CreatedAt: r.createdAt,
+		UpdatedAt: time.Now(),
+
+		SettingsVersion: r.version,
+		PasswordHash:    r.passwordHash,
+		Bans:            r.banned.list(),
+	}
+}
+
+// restore applies persisted state to a freshly created room
+func (r *Room) restore(snapshot RoomSnapshot) {
+	r.clientMutex.Lock()
+	defer r.clientMutex.Unlock()
+
+	r.hostID = snapshot.HostID
+	r.passwordHash = snapshot.PasswordHash
+	r.banned.restore(snapshot.Bans)
+	if !snapshot.CreatedAt.IsZero() {
+		r.createdAt = snapshot.CreatedAt
+	}
+	if snapshot.SettingsVersion > 0 {
+		r.version = snapshot.SettingsVersion
+	}
+}
+
+// persist saves the room if it is flagged as persistent
+func (r *Room) persist() {
+	r.clientMutex.RLock()
+	store := r.store
+	persistent := r.settings.Persistent
+	snapshot := r.snapshotLocked()
+	r.clientMutex.RUnlock()
+
+	if store == nil || !persistent {
+		return
+	}
+	if err := store.SaveRoom(snapshot); err != nil {
+		util.Error("Error saving persistent room %s: %v", r.ID, err)
+	}
+}
+
+// forget deletes the room from the store, e.g. when it is closed for good
+func (r *Room) forget() {
+	r.clientMutex.RLock()
+	store := r.store
+	persistent := r.settings.Persistent
+	r.clientMutex.RUnlock()
+
+	if store == nil || !persistent {
+		return
+	}
+	if err := store.DeleteRoom(r.ID); err != nil {
+		util.Error("Error deleting persistent room %s: %v", r.ID, err)
+	}
+}
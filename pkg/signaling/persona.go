@@ -0,0 +1,123 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits of what a client may say about itself
+const (
+	maxDisplayName  = 64   // Characters
+	maxAvatarURL    = 2048 // Bytes
+	maxMetadataSize = 4096 // Bytes of JSON
+)
+
+// ErrInvalidPersona is returned for a display name, avatar URL or metadata
+// that is malformed or over its limit
+var ErrInvalidPersona = errors.New("invalid display name, avatar or metadata")
+
+// Persona is how a client presents itself to the other participants, who
+// would otherwise only see its client ID
+type Persona struct {
+	DisplayName string `json:"displayName,omitempty"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+
+	// Whatever else the app shows about the participant, e.g. a title or
+	// team; passed on as given
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Validate trims the display name and checks the persona against its
+// limits. Avatars must be http or https URLs
+func (p *Persona) Validate() error {
+	p.DisplayName = strings.TrimSpace(p.DisplayName)
+	if utf8.RuneCountInString(p.DisplayName) > maxDisplayName || strings.ContainsFunc(p.DisplayName, unicode.IsControl) {
+		return fmt.Errorf("%w: display name must be at most %d characters without control characters", ErrInvalidPersona, maxDisplayName)
+	}
+	if p.AvatarURL != "" {
+		avatar, err := url.Parse(p.AvatarURL)
+		if err != nil || (avatar.Scheme != "https" && avatar.Scheme != "http") || avatar.Host == "" || len(p.AvatarURL) > maxAvatarURL {
+			return fmt.Errorf("%w: avatar URL must be an http or https URL of at most %d bytes", ErrInvalidPersona, maxAvatarURL)
+		}
+	}
+	if p.Metadata != nil {
+		data, err := json.Marshal(p.Metadata)
+		if err != nil || len(data) > maxMetadataSize {
+			return fmt.Errorf("%w: metadata must be a JSON object of at most %d bytes", ErrInvalidPersona, maxMetadataSize)
+		}
+	}
+	return nil
+}
+
+// ParseMetadata decodes metadata given as a JSON object, e.g. in the query
+// string of a WebSocket request
+func ParseMetadata(s string) (map[string]interface{}, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &metadata); err != nil || metadata == nil {
+		return nil, fmt.Errorf("%w: metadata must be a JSON object", ErrInvalidPersona)
+	}
+	return metadata, nil
+}
+
+// addTo adds the fields of the persona that are set to message data
+func (p Persona) addTo(data map[string]interface{}) {
+	if p.DisplayName != "" {
+		data["displayName"] = p.DisplayName
+	}
+	if p.AvatarURL != "" {
+		data["avatarUrl"] = p.AvatarURL
+	}
+	if p.Metadata != nil {
+		data["metadata"] = p.Metadata
+	}
+}
+
+// Persona returns how the client presents itself
+func (c *Client) Persona() Persona {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.persona
+}
+
+// updatePersona applies the displayName, avatarUrl and metadata of a join
+// message to the client's persona. Fields the message leaves out keep
+// their value
+func (c *Client) updatePersona(data map[string]interface{}) error {
+	persona := c.Persona()
+	changed := false
+	if name, found := data["displayName"]; found {
+		persona.DisplayName, _ = name.(string)
+		changed = true
+	}
+	if avatar, found := data["avatarUrl"]; found {
+		persona.AvatarURL, _ = avatar.(string)
+		changed = true
+	}
+	if metadata, found := data["metadata"]; found {
+		fields, ok := metadata.(map[string]interface{})
+		if metadata != nil && !ok {
+			return fmt.Errorf("%w: metadata must be a JSON object", ErrInvalidPersona)
+		}
+		persona.Metadata = fields
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if err := persona.Validate(); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.persona = persona
+	c.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,120 @@
+package signaling
+
+import (
+	"slices"
+)
+
+// Capabilities a client may need to take part in a room. Clients list the
+// ones they support when checking a join in advance
+const (
+	// Negotiates media with the server's SFU rather than with each peer
+	CapabilitySFU = "sfu"
+
+	// Records itself, which compliance rooms require of everyone
+	CapabilityRecording = "recording"
+
+	// Draws the room's watermark over the video it shows
+	CapabilityWatermark = "watermark"
+)
+
+// JoinCheck answers whether a join would succeed, for clients to show why
+// it wouldn't on their pre-join screen. Reason is what join-denied would
+// carry, or "capabilities" when the client lacks some the room requires
+type JoinCheck struct {
+	RoomID  string `json:"roomId"`
+	CanJoin bool   `json:"canJoin"`
+	Reason  string `json:"reason,omitempty"`
+
+	// A join creates rooms that don't exist yet
+	Exists          bool `json:"exists"`
+	Participants    int  `json:"participants"`
+	MaxParticipants int  `json:"maxParticipants,omitempty"`
+
+	// The room is full, so the client would be sent to an overflow room
+	Overflow bool `json:"overflow,omitempty"`
+
+	Locked           bool `json:"locked"`
+	PasswordRequired bool `json:"passwordRequired"`
+
+	// The host has to admit the client first
+	WaitingRoom bool `json:"waitingRoom"`
+
+	RequiredCapabilities []string `json:"requiredCapabilities"`
+	MissingCapabilities  []string `json:"missingCapabilities,omitempty"`
+}
+
+// CheckJoin tells whether a client could join a room now, without joining
+// it, the way Room.Join would decide. clientID, userID and address are
+// matched against the room's bans and may be empty; caps are the
+// capabilities the client supports. A password is reported as required
+// but not checked
+func (h *Hub) CheckJoin(roomID, clientID, userID, address string, caps []string) JoinCheck {
+	check := JoinCheck{RoomID: roomID, RequiredCapabilities: []string{}}
+	room := h.FindRoom(roomID)
+	if room != nil {
+		room.checkJoin(&check, &Client{ID: clientID, UserID: userID, address: address})
+	}
+	for _, capability := range check.RequiredCapabilities {
+		if !slices.Contains(caps, capability) {
+			check.MissingCapabilities = append(check.MissingCapabilities, capability)
+		}
+	}
+
+	switch {
+	case h.Draining():
+		check.Reason = "shutdown"
+	case check.Reason != "":
+	case len(check.MissingCapabilities) > 0:
+		check.Reason = "capabilities"
+	}
+	check.CanJoin = check.Reason == ""
+	return check
+}
+
+// checkJoin fills in the state of the room and the reason it would refuse
+// the client, if any
+func (r *Room) checkJoin(check *JoinCheck, client *Client) {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	if r.state == RoomClosed {
+		// A join creates the room afresh
+		return
+	}
+
+	check.Exists = true
+	check.Participants = len(r.clients)
+	check.MaxParticipants = r.capacityLocked()
+	check.Locked = r.locked
+	check.PasswordRequired = r.passwordHash != ""
+	if r.settings.MediaMode() == ModeSFU {
+		check.RequiredCapabilities = append(check.RequiredCapabilities, CapabilitySFU)
+	}
+	if r.compliance != nil {
+		check.RequiredCapabilities = append(check.RequiredCapabilities, CapabilityRecording)
+	}
+	if r.settings.Watermark != nil {
+		check.RequiredCapabilities = append(check.RequiredCapabilities, CapabilityWatermark)
+	}
+
+	existing, exists := r.clients[client.ID]
+	exists = exists && client.ID != ""
+	// Join adds an unverified duplicate as a new participant, under another ID
+	duplicate := exists && r.settings.DuplicatePolicy == DuplicateReplace && !existing.sameParticipant(client)
+	exists = exists && !duplicate
+	switch {
+	case r.banned.covers(client):
+		check.Reason = "banned"
+	case r.locked && !exists && (client.ID == "" || client.ID != r.hostID || duplicate):
+		check.Reason = "locked"
+	case exists && r.settings.DuplicatePolicy == DuplicateReject:
+		check.Reason = "duplicate"
+	case r.fullLocked() && (!exists || r.settings.DuplicatePolicy == DuplicateMultiDevice):
+		if r.overflowOf == "" && r.settings.Overflow != nil {
+			check.Overflow = true
+		} else {
+			check.Reason = "full"
+		}
+	default:
+		check.WaitingRoom = r.waitsLocked(client)
+	}
+}
@@ -0,0 +1,370 @@
+package signaling
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/gorilla/websocket"
+)
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// errTruncated is returned for protobuf frames that end inside a field
+var errTruncated = errors.New("truncated protobuf frame")
+
+// protobufCodec encodes frames as the Frame message of message.proto, with
+// Data as a google.protobuf.Struct. The wire format is written by hand, so
+// the server needs no generated code; clients generate theirs from
+// message.proto
+type protobufCodec struct{}
+
+func (protobufCodec) Encode(batch []*Message) ([]byte, error) {
+	var frame []byte
+	for _, msg := range batch {
+		var err error
+		frame, err = appendNested(frame, 1, func(b []byte) ([]byte, error) { return appendMessage(b, msg) })
+		if err != nil {
+			return nil, err
+		}
+	}
+	return frame, nil
+}
+
+func (protobufCodec) Decode(frame []byte) ([]*Message, error) {
+	var messages []*Message
+	err := eachField(frame, func(field, wire int, _ uint64, data []byte) error {
+		if field != 1 || wire != wireBytes {
+			return nil
+		}
+		msg, err := decodeMessage(data)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, msg)
+		return nil
+	})
+	return messages, err
+}
+
+func (protobufCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+// appendMessage encodes a Message
+func appendMessage(b []byte, msg *Message) ([]byte, error) {
+	b = appendStringField(b, 1, msg.Type)
+	b = appendStringField(b, 2, msg.From)
+	b = appendStringField(b, 3, msg.To)
+	if len(msg.Data) > 0 {
+		var err error
+		b, err = appendNested(b, 4, func(b []byte) ([]byte, error) { return appendStruct(b, msg.Data) })
+		if err != nil {
+			return nil, err
+		}
+	}
+	if msg.IsHost {
+		b = appendVarintField(b, 5, 1)
+	}
+	b = appendStringField(b, 6, msg.ID)
+	b = appendStringField(b, 7, msg.TraceID)
+	return b, nil
+}
+
+// appendStruct encodes the fields of a google.protobuf.Struct, in key
+// order so equal data encodes the same
+func appendStruct(b []byte, fields map[string]interface{}) ([]byte, error) {
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		var err error
+		b, err = appendNested(b, 1, func(b []byte) ([]byte, error) {
+			b = appendStringField(b, 1, key)
+			return appendNested(b, 2, func(b []byte) ([]byte, error) { return appendValue(b, fields[key]) })
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return b, nil
+}
+
+// appendList encodes the values of a google.protobuf.ListValue
+func appendList[T any](b []byte, items []T) ([]byte, error) {
+	for _, item := range items {
+		var err error
+		b, err = appendNested(b, 1, func(b []byte) ([]byte, error) { return appendValue(b, item) })
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// appendValue encodes the kind of a google.protobuf.Value. Values of types
+// JSON doesn't decode to, such as structs, are converted through their
+// JSON form, so binary clients see what JSON clients see
+func appendValue(b []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return appendVarintField(b, 1, 0), nil
+	case float64:
+		return appendDoubleField(b, 2, v), nil
+	case float32:
+		return appendDoubleField(b, 2, float64(v)), nil
+	case int:
+		return appendDoubleField(b, 2, float64(v)), nil
+	case int64:
+		return appendDoubleField(b, 2, float64(v)), nil
+	case int32:
+		return appendDoubleField(b, 2, float64(v)), nil
+	case uint64:
+		return appendDoubleField(b, 2, float64(v)), nil
+	case uint32:
+		return appendDoubleField(b, 2, float64(v)), nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return appendDoubleField(b, 2, f), nil
+	case string:
+		// Unlike a string field, an empty string value is still written
+		return appendString(appendTag(b, 3, wireBytes), v), nil
+	case bool:
+		if v {
+			return appendVarintField(b, 4, 1), nil
+		}
+		return appendVarintField(b, 4, 0), nil
+	case map[string]interface{}:
+		return appendNested(b, 5, func(b []byte) ([]byte, error) { return appendStruct(b, v) })
+	case []interface{}:
+		return appendNested(b, 6, func(b []byte) ([]byte, error) { return appendList(b, v) })
+	case []string:
+		if v == nil {
+			return appendVarintField(b, 1, 0), nil
+		}
+		return appendNested(b, 6, func(b []byte) ([]byte, error) { return appendList(b, v) })
+	case []map[string]interface{}:
+		if v == nil {
+			return appendVarintField(b, 1, 0), nil
+		}
+		return appendNested(b, 6, func(b []byte) ([]byte, error) { return appendList(b, v) })
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return nil, err
+		}
+		return appendValue(b, generic)
+	}
+}
+
+// appendNested encodes a length-delimited field in place: the length is
+// assumed to fit one byte, and the contents are moved along when it
+// doesn't
+func appendNested(b []byte, field int, encode func([]byte) ([]byte, error)) ([]byte, error) {
+	b = appendTag(b, field, wireBytes)
+	start := len(b)
+	b, err := encode(append(b, 0))
+	if err != nil {
+		return nil, err
+	}
+	length := len(b) - start - 1
+	if length < 0x80 {
+		b[start] = byte(length)
+		return b, nil
+	}
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(length))
+	b = append(b, prefix[:n-1]...)
+	copy(b[start+n:], b[start+1:start+1+length])
+	copy(b[start:], prefix[:n])
+	return b, nil
+}
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+func appendDoubleField(b []byte, field int, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(appendTag(b, field, wireFixed64), math.Float64bits(v))
+}
+
+// appendStringField encodes a string field, leaving it out when empty as
+// proto3 does
+func appendStringField(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return appendString(appendTag(b, field, wireBytes), s)
+}
+
+// appendString appends the length and bytes of a string
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// eachField calls fn with each field of an encoded message: its number,
+// wire type, and its value for varint and fixed fields or its bytes for
+// length-delimited ones
+func eachField(b []byte, fn func(field, wire int, value uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		field, wire := int(key>>3), int(key&7)
+		if field == 0 {
+			return errors.New("invalid protobuf field number 0")
+		}
+
+		var value uint64
+		var data []byte
+		switch wire {
+		case wireVarint:
+			value, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			value, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			value, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return errTruncated
+			}
+			data, b = b[n:n+int(length)], b[n+int(length):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wire)
+		}
+		if err := fn(field, wire, value, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeMessage decodes a Message, skipping unknown fields
+func decodeMessage(b []byte) (*Message, error) {
+	msg := &Message{}
+	err := eachField(b, func(field, wire int, value uint64, data []byte) error {
+		switch {
+		case field == 1 && wire == wireBytes:
+			msg.Type = string(data)
+		case field == 2 && wire == wireBytes:
+			msg.From = string(data)
+		case field == 3 && wire == wireBytes:
+			msg.To = string(data)
+		case field == 4 && wire == wireBytes:
+			fields, err := decodeStruct(data)
+			if err != nil {
+				return err
+			}
+			msg.Data = fields
+		case field == 5 && wire == wireVarint:
+			msg.IsHost = value != 0
+		case field == 6 && wire == wireBytes:
+			msg.ID = string(data)
+		case field == 7 && wire == wireBytes:
+			msg.TraceID = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// decodeStruct decodes a google.protobuf.Struct into the map JSON would
+// have decoded
+func decodeStruct(b []byte) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	err := eachField(b, func(field, wire int, _ uint64, entry []byte) error {
+		if field != 1 || wire != wireBytes {
+			return nil
+		}
+		var key string
+		var value interface{}
+		err := eachField(entry, func(field, wire int, _ uint64, data []byte) error {
+			var err error
+			switch {
+			case field == 1 && wire == wireBytes:
+				key = string(data)
+			case field == 2 && wire == wireBytes:
+				value, err = decodeValue(data)
+			}
+			return err
+		})
+		fields[key] = value
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// decodeValue decodes a google.protobuf.Value into what JSON would have
+// decoded: nil, float64, string, bool, a map or a slice
+func decodeValue(b []byte) (interface{}, error) {
+	var value interface{}
+	err := eachField(b, func(field, wire int, bits uint64, data []byte) error {
+		var err error
+		switch {
+		case field == 1 && wire == wireVarint:
+			value = nil
+		case field == 2 && wire == wireFixed64:
+			value = math.Float64frombits(bits)
+		case field == 3 && wire == wireBytes:
+			value = string(data)
+		case field == 4 && wire == wireVarint:
+			value = bits != 0
+		case field == 5 && wire == wireBytes:
+			value, err = decodeStruct(data)
+		case field == 6 && wire == wireBytes:
+			list := []interface{}{}
+			err = eachField(data, func(field, wire int, _ uint64, item []byte) error {
+				if field != 1 || wire != wireBytes {
+					return nil
+				}
+				decoded, err := decodeValue(item)
+				list = append(list, decoded)
+				return err
+			})
+			value = list
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
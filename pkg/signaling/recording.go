@@ -0,0 +1,365 @@
+package signaling
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Recording kinds
+const (
+	// Tracks of one participant, recorded by that participant's browser
+	RecordingParticipant = "participant"
+)
+
+// Recording statuses
+const (
+	RecordingPending   = "pending"   // Waiting for the participant's consent
+	RecordingDeclined  = "declined"  // The participant said no or left before answering
+	RecordingActive    = "recording" // The participant's browser is recording
+	RecordingStopped   = "stopped"   // Recording ended, the artifact is being uploaded
+	RecordingAvailable = "ready"     // The artifact can be downloaded
+)
+
+// Tracks a participant recording may cover
+const (
+	RecordTracksScreen = "screen"
+	RecordTracksCamera = "camera"
+	RecordTracksAll    = "all"
+)
+
+// ErrRecordingNotFound is returned for unknown recording IDs
+var ErrRecordingNotFound = errors.New("recording not found")
+
+// Recording describes a recording and, once uploaded, its artifact
+type Recording struct {
+	ID          string    `json:"id"`
+	RoomID      string    `json:"roomId"`
+	Kind        string    `json:"kind"`
+	ClientID    string    `json:"clientId,omitempty"`
+	UserID      string    `json:"userId,omitempty"`
+	Tracks      string    `json:"tracks,omitempty"`
+	RequestedBy string    `json:"requestedBy"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+
+	// Set once the artifact is stored
+	File        string `json:"file,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+}
+
+// Artifact is the stored media of a recording
+type Artifact struct {
+	File        string
+	ContentType string
+	Size        int64
+	SHA256      string
+}
+
+// RecordingStore keeps recording metadata so it outlives the room
+type RecordingStore interface {
+	SaveRecording(recording Recording) error
+}
+
+// roomRecording is a recording of a live room with the token its
+// participant uploads the artifact with
+type roomRecording struct {
+	Recording
+	uploadToken string
+}
+
+// SetRecordingStore sets where recording metadata is saved. It must be
+// called before rooms are created
+func (h *Hub) SetRecordingStore(store RecordingStore) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.recordingStore = store
+}
+
+// RequestRecording asks a participant to consent to recording their tracks.
+// Only clients with the record permission may ask, by default the host
+func (r *Room) RequestRecording(host *Client, clientID, tracks string) (Recording, error) {
+	if !host.may(PermRecord) {
+		return Recording{}, fmt.Errorf("requesting recordings: %w", ErrRoleDenied)
+	}
+	switch tracks {
+	case "":
+		tracks = RecordTracksAll
+	case RecordTracksScreen, RecordTracksCamera, RecordTracksAll:
+	default:
+		return Recording{}, fmt.Errorf("unknown tracks %q", tracks)
+	}
+	target := r.client(clientID)
+	if target == nil {
+		return Recording{}, fmt.Errorf("client %s is not in room %s", clientID, r.ID)
+	}
+
+	now := time.Now()
+	recording := Recording{
+		ID:          randomToken(8),
+		RoomID:      r.ID,
+		Kind:        RecordingParticipant,
+		ClientID:    clientID,
+		UserID:      target.UserID,
+		Tracks:      tracks,
+		RequestedBy: host.ID,
+		Status:      RecordingPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	r.recordingMutex.Lock()
+	r.recordings[recording.ID] = &roomRecording{Recording: recording}
+	r.recordingMutex.Unlock()
+	r.saveRecording(recording)
+
+	util.Info("Host %s asked %s to record %s tracks in room %s", host.ID, clientID, tracks, r.ID)
+	r.logModeration(ModerationRecordRequest, host.ID, clientID, recording.ID)
+	target.Send(&Message{
+		Type: "recording-consent-request",
+		Data: map[string]interface{}{
+			"recordingId": recording.ID,
+			"requestedBy": host.ID,
+			"tracks":      tracks,
+		},
+	})
+	host.Send(recordingStatusMessage(recording))
+	return recording, nil
+}
+
+// AnswerRecordingConsent applies a participant's answer to a recording
+// request. Once they accept, their browser is told to start recording and
+// the room learns who is being recorded
+func (r *Room) AnswerRecordingConsent(client *Client, recordingID string, accepted bool) (Recording, error) {
+	r.recordingMutex.Lock()
+	entry, exists := r.recordings[recordingID]
+	if !exists || entry.ClientID != client.ID {
+		r.recordingMutex.Unlock()
+		return Recording{}, ErrRecordingNotFound
+	}
+	if entry.Status != RecordingPending {
+		r.recordingMutex.Unlock()
+		return Recording{}, fmt.Errorf("recording %s is %s", recordingID, entry.Status)
+	}
+	entry.UpdatedAt = time.Now()
+	if accepted {
+		entry.Status = RecordingActive
+		entry.uploadToken = randomToken(16)
+	} else {
+		entry.Status = RecordingDeclined
+	}
+	recording, token := entry.Recording, entry.uploadToken
+	r.recordingMutex.Unlock()
+	r.saveRecording(recording)
+
+	if !accepted {
+		util.Info("Client %s declined recording %s in room %s", client.ID, recordingID, r.ID)
+		if host := r.client(recording.RequestedBy); host != nil {
+			host.Send(recordingStatusMessage(recording))
+		}
+		return recording, nil
+	}
+
+	util.Info("Client %s is recording %s tracks for %s in room %s", client.ID, recording.Tracks, recordingID, r.ID)
+	client.Send(&Message{
+		Type: "recording-start",
+		Data: map[string]interface{}{
+			"recordingId": recording.ID,
+			"tracks":      recording.Tracks,
+			"uploadUrl":   fmt.Sprintf("/api/rooms/%s/recordings/%s/artifact", r.ID, recording.ID),
+			"uploadToken": token,
+		},
+	})
+	r.Broadcast(recordingStatusMessage(recording), "")
+	return recording, nil
+}
+
+// StopRecording ends a participant recording or a call recording. The host
+// and the recorded participant may stop it; the participant's browser, or
+// the call recorder, then stores the artifact
+func (r *Room) StopRecording(client *Client, recordingID string) (Recording, error) {
+	hostID := r.GetHost()
+	r.recordingMutex.Lock()
+	entry, exists := r.recordings[recordingID]
+	if !exists || (client.ID != entry.ClientID && client.ID != hostID) {
+		r.recordingMutex.Unlock()
+		return Recording{}, ErrRecordingNotFound
+	}
+	if entry.Status != RecordingActive {
+		r.recordingMutex.Unlock()
+		return Recording{}, fmt.Errorf("recording %s is %s", recordingID, entry.Status)
+	}
+	if entry.Kind == RecordingCompliance {
+		r.recordingMutex.Unlock()
+		return Recording{}, errors.New("compliance recordings run until the participant leaves")
+	}
+	entry.Status = RecordingStopped
+	entry.UpdatedAt = time.Now()
+	recording := entry.Recording
+	r.recordingMutex.Unlock()
+	r.saveRecording(recording)
+
+	util.Info("Recording %s in room %s stopped by %s", recordingID, r.ID, client.ID)
+	if client.ID != recording.ClientID {
+		r.logModeration(ModerationStopRecording, client.ID, recording.ClientID, recording.ID)
+	}
+	if recording.Kind == RecordingCall {
+		r.stopCallRecording(recording)
+	}
+	if target := r.client(recording.ClientID); target != nil {
+		target.Send(&Message{
+			Type: "recording-stop",
+			Data: map[string]interface{}{"recordingId": recording.ID},
+		})
+	}
+	r.Broadcast(recordingStatusMessage(recording), "")
+	return recording, nil
+}
+
+// endRecordingsOf settles the recordings of a participant who left:
+// unanswered requests count as declined and running recordings as stopped.
+// An upload that is already underway is still accepted
+func (r *Room) endRecordingsOf(clientID string) {
+	var ended []Recording
+	r.recordingMutex.Lock()
+	for _, entry := range r.recordings {
+		if entry.ClientID != clientID {
+			continue
+		}
+		switch entry.Status {
+		case RecordingPending:
+			entry.Status = RecordingDeclined
+		case RecordingActive:
+			entry.Status = RecordingStopped
+		default:
+			continue
+		}
+		entry.UpdatedAt = time.Now()
+		ended = append(ended, entry.Recording)
+	}
+	r.recordingMutex.Unlock()
+
+	for _, recording := range ended {
+		r.saveRecording(recording)
+		r.Broadcast(recordingStatusMessage(recording), "")
+	}
+}
+
+// AuthorizeRecordingUpload checks the upload token of a recording and
+// returns the recording if its artifact may be uploaded
+func (r *Room) AuthorizeRecordingUpload(recordingID, token string) (Recording, error) {
+	r.recordingMutex.Lock()
+	defer r.recordingMutex.Unlock()
+
+	entry, exists := r.recordings[recordingID]
+	if !exists || entry.uploadToken == "" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(entry.uploadToken)) != 1 {
+		return Recording{}, ErrRecordingNotFound
+	}
+	if entry.Status != RecordingActive && entry.Status != RecordingStopped {
+		return Recording{}, fmt.Errorf("recording %s is %s", recordingID, entry.Status)
+	}
+	return entry.Recording, nil
+}
+
+// CompleteRecording records the stored artifact of a recording, tells the
+// host it is ready and emits a recording-ready event. Artifacts of compliance
+// rooms have their hash written to the audit log
+func (h *Hub) CompleteRecording(roomID, recordingID string, artifact Artifact) (Recording, error) {
+	room := h.FindRoom(roomID)
+	if room == nil {
+		return Recording{}, ErrRecordingNotFound
+	}
+
+	room.recordingMutex.Lock()
+	entry, exists := room.recordings[recordingID]
+	if !exists {
+		room.recordingMutex.Unlock()
+		return Recording{}, ErrRecordingNotFound
+	}
+	entry.Status = RecordingAvailable
+	entry.File = artifact.File
+	entry.ContentType = artifact.ContentType
+	entry.Size = artifact.Size
+	entry.SHA256 = artifact.SHA256
+	entry.UpdatedAt = time.Now()
+	entry.uploadToken = ""
+	recording := entry.Recording
+	room.recordingMutex.Unlock()
+	room.saveRecording(recording)
+	if room.compliance != nil {
+		room.audit(AuditEntry{
+			Action:      AuditRecordingStored,
+			ClientID:    recording.ClientID,
+			RecordingID: recording.ID,
+			SHA256:      recording.SHA256,
+			Size:        recording.Size,
+		})
+	}
+
+	util.Info("Recording %s of client %s in room %s is ready (%d bytes)", recordingID, recording.ClientID, roomID, recording.Size)
+	if host := room.client(room.GetHost()); host != nil {
+		host.Send(recordingStatusMessage(recording))
+	}
+	h.emitRecordingReady(recording)
+	return recording, nil
+}
+
+// emitRecordingReady emits a recording-ready event with the artifact's URL
+func (h *Hub) emitRecordingReady(recording Recording) {
+	h.emit(Event{
+		Type:     EventRecordingReady,
+		RoomID:   recording.RoomID,
+		ClientID: recording.ClientID,
+		Data: map[string]interface{}{
+			"recordingId": recording.ID,
+			"kind":        recording.Kind,
+			"url":         "/api/recordings/" + recording.ID + "/artifact",
+		},
+	})
+}
+
+// saveRecording stores recording metadata if the hub has a recording store
+func (r *Room) saveRecording(recording Recording) {
+	if r.recordingStore == nil {
+		return
+	}
+	if err := r.recordingStore.SaveRecording(recording); err != nil {
+		util.Error("Error saving recording %s of room %s: %v", recording.ID, r.ID, err)
+	}
+}
+
+// client returns a client of the room by ID, or nil
+func (r *Room) client(clientID string) *Client {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.clients[clientID]
+}
+
+// recordingStatusMessage tells clients where a recording stands
+func recordingStatusMessage(recording Recording) *Message {
+	return &Message{
+		Type: "recording-status",
+		Data: map[string]interface{}{
+			"recordingId": recording.ID,
+			"kind":        recording.Kind,
+			"clientId":    recording.ClientID,
+			"tracks":      recording.Tracks,
+			"status":      recording.Status,
+		},
+	}
+}
+
+// randomToken returns n random bytes as hex
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,86 @@
+package signaling
+
+import (
+	"errors"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Codes of the "error" messages the server sends when it rejects or fails
+// to process a client's message
+const (
+	ErrorMalformed    = "malformed"     // The message isn't valid JSON
+	ErrorUnknownType  = "unknown-type"  // The server doesn't handle the message type
+	ErrorNotPermitted = "not-permitted" // The sender's role or the room's policy forbids it
+	ErrorNotFound     = "not-found"     // A client, call or recording it names doesn't exist
+	ErrorConflict     = "conflict"      // The room changed since the sender last saw it
+	ErrorUnavailable  = "unavailable"   // A feature it needs is off in this room or server
+	ErrorInvalid      = "invalid"       // Its content is malformed or wrong for the room's current state
+	ErrorFailed       = "failed"        // The server couldn't process it this time
+)
+
+// errTemporary marks failures the sender may retry, such as the backplane
+// being unreachable
+var errTemporary = errors.New("temporary failure")
+
+// retryableErrors are the codes whose messages may succeed if sent again
+var retryableErrors = map[string]bool{
+	ErrorConflict: true,
+	ErrorFailed:   true,
+}
+
+// errorCode classifies an error returned while handling a client message
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, errTemporary):
+		return ErrorFailed
+	case errors.Is(err, ErrRoleDenied), errors.Is(err, ErrPrivateChatBlocked),
+		errors.Is(err, ErrBanned), errors.Is(err, ErrRoomLocked), errors.Is(err, ErrWaitingRoom):
+		return ErrorNotPermitted
+	case errors.Is(err, ErrRecipientNotFound), errors.Is(err, ErrCallNotFound),
+		errors.Is(err, ErrRecordingNotFound), errors.Is(err, ErrRoomNotFound),
+		errors.Is(err, ErrNotWaiting):
+		return ErrorNotFound
+	case errors.Is(err, ErrSettingsConflict):
+		return ErrorConflict
+	case errors.Is(err, ErrNoMediaServer), errors.Is(err, ErrTranscriptionDisabled),
+		errors.Is(err, ErrRoomClosed):
+		return ErrorUnavailable
+	default:
+		return ErrorInvalid
+	}
+}
+
+// errorMessage builds the "error" message answering a rejected message;
+// related is nil when the message couldn't be parsed
+func errorMessage(related *Message, code string, err error) *Message {
+	data := map[string]interface{}{
+		"code":      code,
+		"message":   err.Error(),
+		"retryable": retryableErrors[code],
+	}
+	if related != nil {
+		data["type"] = related.Type
+		if related.ID != "" {
+			data["relatedMessageId"] = related.ID
+		}
+	}
+	var schemaErr *SchemaError
+	if errors.As(err, &schemaErr) {
+		data["field"] = schemaErr.Field
+	}
+	return &Message{Type: "error", Data: data}
+}
+
+// reject tells the client why one of its messages was refused
+func (c *Client) reject(msg *Message, err error) {
+	c.sendError(msg, errorCode(err), err)
+}
+
+// sendError sends the client an "error" message with the given code
+func (c *Client) sendError(related *Message, code string, err error) {
+	util.Debug("Sending %s error to client %s: %v", code, c.ID, err)
+	response := errorMessage(related, code, err)
+	response.To = c.ID
+	c.Send(response)
+}
@@ -0,0 +1,109 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Role is what a client may do in a room. Roles are ordered: a host may do
+// everything a participant may, and a participant everything a viewer may
+type Role string
+
+const (
+	// RoleHost may be made host of the room
+	RoleHost Role = "host"
+
+	// RoleParticipant takes part in the call but can't become host
+	RoleParticipant Role = "participant"
+
+	// RoleViewer watches and listens without chatting, reacting or captioning
+	RoleViewer Role = "viewer"
+)
+
+// ErrRoleDenied is returned when a client's role doesn't permit an action
+var ErrRoleDenied = errors.New("not permitted for this role")
+
+// ParseRole returns the role with the given name
+func ParseRole(name string) (Role, error) {
+	switch role := Role(name); role {
+	case RoleHost, RoleParticipant, RoleViewer:
+		return role, nil
+	default:
+		return "", fmt.Errorf("unknown role %q", name)
+	}
+}
+
+// validateRoles checks the roles a room gives its users
+func validateRoles(roles map[string]Role) error {
+	for userID, role := range roles {
+		if userID == "" {
+			return errors.New("roles must name a user")
+		}
+		if _, err := ParseRole(string(role)); err != nil {
+			return fmt.Errorf("role of %s: %w", userID, err)
+		}
+	}
+	return nil
+}
+
+// limitRoleLocked narrows a joining client's role to what the room gives
+// its user. Roles only narrow, so claiming someone else's user ID gains
+// nothing; the caller must hold clientMutex
+func (r *Room) limitRoleLocked(client *Client) {
+	if role := r.settings.Roles[client.userKey()]; role != "" {
+		client.mutex.Lock()
+		client.maxRole = client.maxRole.Min(role)
+		client.mutex.Unlock()
+	}
+}
+
+// rank orders roles; an empty role means no limit
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleParticipant:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// Allows reports whether a client limited to r may act as role
+func (r Role) Allows(role Role) bool {
+	return r.rank() >= role.rank()
+}
+
+// Min returns the more restrictive of two roles
+func (r Role) Min(other Role) Role {
+	if other != "" && other.rank() < r.rank() {
+		return other
+	}
+	return r
+}
+
+// Role returns what the client currently is in its room
+func (c *Client) Role() Role {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.isHost {
+		return RoleHost
+	}
+	return RoleParticipant.Min(c.maxRole)
+}
+
+// canHost reports whether the client's role limit lets it become host
+func (c *Client) canHost() bool {
+	return c.maxRole.Allows(RoleHost)
+}
+
+// checkRole refuses messages the client's role doesn't permit
+func (c *Client) checkRole(msgType string) error {
+	switch msgType {
+	case "chat", "reaction", "caption", "dtmf", AppDataType:
+		if !c.maxRole.Allows(RoleParticipant) {
+			return fmt.Errorf("%s: %w", msgType, ErrRoleDenied)
+		}
+	}
+	return nil
+}
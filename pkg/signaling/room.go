@@ -1,7 +1,12 @@
 package signaling
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/nikhilsahni7/chat-video-app/pkg/util"
 )
@@ -13,15 +18,163 @@ type Room struct {
 	clientMutex sync.RWMutex
 	broadcast   chan *Message
 	hostID      string // Host client ID
+
+	// Closed once the room is removed, ending its broadcast loop
+	stopped  chan struct{}
+	stopOnce sync.Once
+	settings RoomSettings
+	version  int64 // Bumped on every settings change, for optimistic concurrency
+
+	// Publishing device per user, keyed by user ID
+	publishers map[string]string
+
+	// Lifecycle state and since when, transitions waiting for their hooks,
+	// and the hooks
+	state       RoomState
+	stateSince  time.Time
+	transitions []RoomTransition
+	hooks       []RoomHook
+	hookMutex   sync.Mutex
+
+	// Persistence of rooms flagged as persistent; dirty marks unsaved changes
+	store     RoomStore
+	dirty     bool
+	createdAt time.Time
+
+	// Shared membership and state, the joins and leaves not yet written to
+	// it, and the node the room lives on
+	stateStore    StateStore
+	memberChanges []memberChange
+	node          string
+
+	// Event log, the events waiting to be written to it, and the room's
+	// state as written so far; view and sinceSnapshot are guarded by
+	// hookMutex
+	eventLog      EventLog
+	pendingEvents []RoomEvent
+	view          *RoomView
+	sinceSnapshot int
+
+	// Relays client traffic to the room's clients on other nodes
+	backplane Backplane
+
+	// Moderation commands in sequence order, whether the host locked the
+	// room against new clients and who it banned; locked and banned are
+	// guarded by clientMutex
+	commands     commandQueue
+	commandMutex sync.Mutex
+	locked       bool
+	banned       banList
+
+	// Clients waiting for the host to admit them, by ID; guarded by
+	// clientMutex
+	lobby map[string]*Client
+
+	// What the host turned off of participants' media, by client ID;
+	// guarded by clientMutex
+	controlled map[string]MediaControl
+
+	// What participants report of their media by client ID, the hands
+	// raised in order, and who the host pinned; guarded by clientMutex
+	mediaStates map[string]MediaState
+	hands       []RaisedHand
+	pinned      string
+
+	// Custom events kept for clients that join later
+	customHistory []customEvent
+	customMutex   sync.Mutex
+
+	// Bcrypt hash of the password clients need to join; empty for none
+	passwordHash string
+
+	// Non-critical messages waiting to be sent as one digest
+	digest      []*Message
+	digestTimer *time.Timer
+	digestMutex sync.Mutex
+
+	// Signal trace of rooms with tracing enabled
+	trace *signalTrace
+
+	// Hub-wide log of messages that carry a trace ID
+	deliveries *deliveryLog
+
+	// Errors reported by the room's clients
+	errors clientErrorLog
+
+	// Recent ICE failures between pairs of clients
+	iceFailures iceFailureTracker
+
+	// Inputs of the room's health score
+	health healthTracker
+
+	// Traffic counters for metrics
+	counters messageCounters
+
+	// Recordings requested in the room
+	recordings     map[string]*roomRecording
+	recordingStore RecordingStore
+	recordingMutex sync.Mutex
+
+	// Caption subscriptions and transcript of rooms with transcription
+	captions   captionState
+	translator Translator
+
+	// Forwards media between the clients of a room in SFU mode
+	media MediaServer
+
+	// Record of the call in progress; meetingStarted is set until the new
+	// meeting has been saved
+	meeting        *Meeting
+	meetingStarted bool
+	meetingStore   MeetingStore
+	summarizer     Summarizer
+
+	// When clients currently speaking started their turn
+	speakingSince map[string]time.Time
+
+	// Policy of rooms whose tenant is in compliance mode, and the audit log
+	// they record to
+	compliance *CompliancePolicy
+	auditLog   AuditLog
+
+	// Hub the room is registered with; nil for rooms built on their own
+	hub *Hub
+
+	// Event room whose participants this overflow room takes when it is full
+	overflowOf string
+
+	// Main room of a sidebar room
+	sidebarOf string
 }
 
+// ErrDuplicateClient is returned when a client ID is already connected to a
+// room whose duplicate policy rejects second connections
+var ErrDuplicateClient = errors.New("client is already connected to this room")
+
+// ErrRoomClosed is returned when joining a room that was already removed from its hub
+var ErrRoomClosed = errors.New("room is closed")
+
+// ErrRecipientNotFound is returned when a message is addressed to a client
+// that isn't in the room
+var ErrRecipientNotFound = errors.New("recipient is not in the room")
+
+// ErrSettingsConflict is returned when room settings changed since the
+// version a conditional update was based on
+var ErrSettingsConflict = errors.New("room settings were changed by someone else")
+
 // NewRoom creates a new chat room
 func NewRoom(id string) *Room {
 	room := &Room{
-		ID:        id,
-		clients:   make(map[string]*Client),
-		broadcast: make(chan *Message, 100),
-		hostID:    "", // No host initially
+		ID:         id,
+		clients:    make(map[string]*Client),
+		broadcast:  make(chan *Message, broadcastBuffer),
+		stopped:    make(chan struct{}),
+		hostID:     "", // No host initially
+		settings:   DefaultRoomSettings(),
+		version:    1,
+		publishers: make(map[string]string),
+		createdAt:  time.Now(),
+		recordings: make(map[string]*roomRecording),
 	}
 
 	// Start broadcast handling
@@ -30,18 +183,125 @@ func NewRoom(id string) *Room {
 	return room
 }
 
-// AddClient adds a client to the room
-func (r *Room) AddClient(client *Client) {
+// AddClient adds a client to the room, refusing it with ErrRoomFull once the
+// room has reached its capacity
+func (r *Room) AddClient(client *Client) error {
+	defer r.flushChanges()
 	r.clientMutex.Lock()
 	defer r.clientMutex.Unlock()
 
+	if _, exists := r.clients[client.ID]; !exists && r.fullLocked() {
+		return ErrRoomFull
+	}
+	r.addClientLocked(client)
+	return nil
+}
+
+// Join adds a client to the room, applying the room's duplicate join policy
+// when the client's ID is already taken. Under the multi-device policy the
+// client's ID is changed to a free per-device ID; under the replace policy the
+// existing connection is returned so the caller can close it, provided the
+// new one is the same verified user. Other duplicates get a per-device ID
+func (r *Room) Join(client *Client) (*Client, error) {
+	defer r.flushChanges()
+	r.clientMutex.Lock()
+	defer r.clientMutex.Unlock()
+
+	if r.state == RoomClosed {
+		return nil, ErrRoomClosed
+	}
+
+	if r.banned.covers(client) {
+		return nil, ErrBanned
+	}
+	r.limitRoleLocked(client)
+	existing, exists := r.clients[client.ID]
+	if exists && r.settings.DuplicatePolicy == DuplicateReplace && !existing.sameParticipant(client) {
+		// Client IDs are self-declared and seen by everyone, so only the
+		// same verified user takes an existing connection's place. Anyone
+		// else joins beside it as a new participant
+		baseID := client.ID
+		r.renameDuplicateLocked(client)
+		util.WarnContext(r.clientLogContextLocked(client.ID), "Client %s is already in room %s; unverified duplicate joins as %s", baseID, r.ID, client.ID)
+		existing, exists = nil, false
+	}
+	if r.locked && !exists && client.ID != r.hostID {
+		return nil, ErrRoomLocked
+	}
+	if !exists {
+		if r.fullLocked() {
+			return nil, ErrRoomFull
+		}
+		if r.waitsLocked(client) {
+			return r.parkLocked(client), ErrWaitingRoom
+		}
+		r.addClientLocked(client)
+		return nil, nil
+	}
+
+	switch r.settings.DuplicatePolicy {
+	case DuplicateReject:
+		util.WarnContext(r.clientLogContextLocked(client.ID), "Rejecting duplicate join of client %s in room %s", client.ID, r.ID)
+		return nil, ErrDuplicateClient
+	case DuplicateMultiDevice:
+		if r.fullLocked() {
+			return nil, ErrRoomFull
+		}
+		baseID := client.ID
+		r.renameDuplicateLocked(client)
+		util.InfoContext(r.clientLogContextLocked(client.ID), "Client %s joined room %s from another device as %s", baseID, r.ID, client.ID)
+		r.addClientLocked(client)
+		return nil, nil
+	default:
+		util.InfoContext(r.clientLogContextLocked(client.ID), "Client %s reconnected to room %s, replacing the previous connection", client.ID, r.ID)
+		delete(r.clients, client.ID)
+		r.addClientLocked(client)
+		return existing, nil
+	}
+}
+
+// renameDuplicateLocked gives a client whose ID is taken the first free
+// per-device ID; the caller must hold clientMutex
+func (r *Room) renameDuplicateLocked(client *Client) {
+	baseID := client.ID
+	for device := 2; ; device++ {
+		candidate := fmt.Sprintf("%s-d%d", baseID, device)
+		_, taken := r.clients[candidate]
+		_, waiting := r.lobby[candidate]
+		if !taken && !waiting {
+			client.ID = candidate
+			return
+		}
+	}
+}
+
+// addClientLocked registers a client; the caller must hold clientMutex
+func (r *Room) addClientLocked(client *Client) {
 	r.clients[client.ID] = client
+	r.recordJoinLocked(client)
+	r.health.recordJoin(client.ID)
+	r.meetingJoinLocked(client)
+	if r.state != RoomActive {
+		r.transitionLocked(RoomActive)
+	}
+
+	// The first device of a user publishes media until the user switches
+	if _, exists := r.publishers[client.userKey()]; !exists {
+		r.publishers[client.userKey()] = client.ID
+	}
 
-	// If this is the first client and no host is set, make them the host
-	if len(r.clients) == 1 && r.hostID == "" {
+	// If no host is set, e.g. because this is the first client, make them
+	// the host if their role allows it. The client learns about it from the
+	// welcome message
+	if r.hostID == "" && client.canHost() {
 		r.hostID = client.ID
-		client.SetHost(true)
-		util.Info("Client %s automatically set as host for room %s", client.ID, r.ID)
+		r.dirty = true
+		client.setHostFlag(true)
+		util.InfoContext(r.clientLogContextLocked(client.ID), "Client %s automatically set as host for room %s", client.ID, r.ID)
+	} else if r.hostID == client.ID && client.canHost() {
+		// A verified replacement connection, or the designated host of a
+		// persistent room coming back, holds host status
+		client.setHostFlag(true)
 	} else if r.hostID != "" {
 		// If there's already a host, notify the new client
 		client.Send(&Message{
@@ -60,60 +320,121 @@ func (r *Room) AddClient(client *Client) {
 			userList = append(userList, clientID)
 		}
 	}
-	util.Info("Room %s state - clients: %v, host: %s, new client: %s",
+	ctx := r.clientLogContextLocked(client.ID)
+	util.InfoContext(ctx, "Room %s state - clients: %v, host: %s, new client: %s",
 		r.ID, userList, r.hostID, client.ID)
 
-	util.Info("Client %s joined room %s", client.ID, r.ID)
+	util.InfoContext(ctx, "Client %s joined room %s", client.ID, r.ID)
 }
 
 // RemoveClient removes a client from the room
 func (r *Room) RemoveClient(clientID string) {
+	defer r.flushChanges()
 	r.clientMutex.Lock()
 	defer r.clientMutex.Unlock()
 
 	if _, exists := r.clients[clientID]; exists {
-		delete(r.clients, clientID)
-		util.Info("Client %s left room %s", clientID, r.ID)
-
-		// If the host left, assign a new host if there are other clients
-		if clientID == r.hostID && len(r.clients) > 0 {
-			// Pick the first client as the new host
-			for newHostID := range r.clients {
-				r.hostID = newHostID
-
-				// Notify all clients about the new host
-				r.broadcast <- &Message{
-					Type: "host-change",
-					Data: map[string]interface{}{
-						"hostId": r.hostID,
-					},
-				}
+		r.removeClientLocked(clientID)
+	}
+}
+
+// removeConnection removes a client only if it is still the registered
+// connection for its ID, so a replaced connection can't evict its successor
+func (r *Room) removeConnection(client *Client) {
+	defer r.flushChanges()
+	r.clientMutex.Lock()
+	defer r.clientMutex.Unlock()
 
-				util.Info("New host assigned for room %s: %s", r.ID, r.hostID)
-				break
+	if current, exists := r.clients[client.ID]; exists && current == client {
+		r.removeClientLocked(client.ID)
+	}
+}
+
+// removeClientLocked deletes a client and hands over host status if needed;
+// the caller must hold clientMutex
+func (r *Room) removeClientLocked(clientID string) {
+	client := r.clients[clientID]
+	reason := LeaveNormal
+	if client != nil {
+		reason = client.LeaveReason()
+	}
+	delete(r.clients, clientID)
+	delete(r.controlled, clientID)
+	delete(r.mediaStates, clientID)
+	r.lowerHandLocked(clientID)
+	if r.pinned == clientID {
+		r.pinned = ""
+	}
+	r.recordLeaveLocked(clientID, reason)
+	r.health.recordLeave(clientID)
+	r.meetingLeaveLocked(clientID, reason)
+	util.InfoContext(r.clientLogContextLocked(clientID), "Client %s left room %s", clientID, r.ID)
+
+	r.handOverPublishingLocked(client)
+
+	// If the host left, assign a new host if another client may host
+	newHostFound := false
+	if clientID == r.hostID {
+		// Pick the first client allowed to host as the new host
+		for newHostID, newHost := range r.clients {
+			if !newHost.canHost() {
+				continue
 			}
+			newHostFound = true
+			r.hostID = newHostID
+			r.dirty = true
+			newHost.SetHost(true)
+			r.sendAdmissionRequestsLocked(newHost)
+
+			// Notify all clients about the new host
+			r.enqueue(&Message{
+				Type: "host-change",
+				Data: map[string]interface{}{
+					"hostId": r.hostID,
+				},
+			})
+
+			util.InfoContext(r.logContextLocked(context.Background()), "New host assigned for room %s: %s", r.ID, r.hostID)
+			break
 		}
 	}
+	if clientID == r.hostID && !newHostFound && !r.settings.Persistent {
+		// The room is empty, so the next client to join becomes host.
+		// Persistent rooms keep their designated host
+		r.hostID = ""
+	}
+
+	if len(r.clients) == 0 {
+		r.transitionLocked(RoomEnding)
+	}
 }
 
 // SetHost explicitly sets a client as the host
 func (r *Room) SetHost(clientID string) bool {
+	defer r.flushChanges()
 	r.clientMutex.Lock()
 	defer r.clientMutex.Unlock()
 
-	// Verify the client exists in this room
-	if _, exists := r.clients[clientID]; !exists {
+	// Verify the client exists in this room and may host it
+	if client, exists := r.clients[clientID]; !exists {
 		util.Warn("Cannot set client %s as host: not in room %s", clientID, r.ID)
 		return false
+	} else if !client.canHost() {
+		util.Warn("Cannot set client %s as host: its role is limited to %s", clientID, client.maxRole)
+		return false
 	}
 
 	// Set the new host
 	previousHost := r.hostID
 	r.hostID = clientID
+	r.dirty = true
 
-	// Set the host flag on the client
+	// Set the host flag on the client and have it admit anyone waiting
 	if client, exists := r.clients[clientID]; exists {
 		client.SetHost(true)
+		if previousHost != clientID {
+			r.sendAdmissionRequestsLocked(client)
+		}
 	}
 
 	// Remove host status from previous host
@@ -138,6 +459,76 @@ func (r *Room) SetHost(clientID string) bool {
 	return true
 }
 
+// Settings returns a copy of the room's settings
+func (r *Room) Settings() RoomSettings {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.settings
+}
+
+// LogContext returns ctx with the room and its tenant as log attributes
+func (r *Room) LogContext(ctx context.Context) context.Context {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.logContextLocked(ctx)
+}
+
+// logContextLocked is LogContext for callers holding clientMutex
+func (r *Room) logContextLocked(ctx context.Context) context.Context {
+	return util.WithTenant(util.WithRoom(ctx, r.ID), r.settings.Tenant)
+}
+
+// clientLogContextLocked returns a context naming a client of the room in
+// log lines; the caller must hold clientMutex
+func (r *Room) clientLogContextLocked(clientID string) context.Context {
+	return util.WithClient(r.logContextLocked(context.Background()), clientID)
+}
+
+// VersionedSettings returns a copy of the room's settings and their version
+func (r *Room) VersionedSettings() (RoomSettings, int64) {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.settings, r.version
+}
+
+// UpdateSettings applies a change to the room's settings. A room that stops
+// being persistent is deleted from the store
+func (r *Room) UpdateSettings(update func(*RoomSettings)) {
+	r.UpdateSettingsIf(0, func(settings *RoomSettings) error {
+		update(settings)
+		return nil
+	})
+}
+
+// UpdateSettingsIf applies a change to the room's settings only if they are
+// still at the given version, so two editors can't silently overwrite each
+// other; version 0 skips the check. It returns the new version, and nothing
+// changes when update returns an error
+func (r *Room) UpdateSettingsIf(version int64, update func(*RoomSettings) error) (int64, error) {
+	defer r.flushChanges()
+	r.clientMutex.Lock()
+	defer r.clientMutex.Unlock()
+
+	if version != 0 && version != r.version {
+		return r.version, ErrSettingsConflict
+	}
+	settings := r.settings
+	if err := update(&settings); err != nil {
+		return r.version, err
+	}
+	wasPersistent := r.settings.Persistent
+	r.settings = settings
+	r.version++
+	r.dirty = true
+
+	if wasPersistent && !r.settings.Persistent && r.store != nil {
+		if err := r.store.DeleteRoom(r.ID); err != nil {
+			util.Error("Error deleting room %s from the store: %v", r.ID, err)
+		}
+	}
+	return r.version, nil
+}
+
 // GetHost returns the current host ID
 func (r *Room) GetHost() string {
 	r.clientMutex.RLock()
@@ -145,6 +536,14 @@ func (r *Room) GetHost() string {
 	return r.hostID
 }
 
+// CreatedAt returns when the room was opened, or first opened for rooms
+// restored after a restart
+func (r *Room) CreatedAt() time.Time {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.createdAt
+}
+
 // GetClients returns all clients in the room
 func (r *Room) GetClients() []*Client {
 	r.clientMutex.RLock()
@@ -172,20 +571,146 @@ func (r *Room) Broadcast(msg *Message, excludeClientID string) {
 	util.Debug("Room %s broadcasting message type %s to %d clients: %v",
 		r.ID, msg.Type, len(recipients), recipients)
 
+	// Messages for a client on another node go to that node only; the rest
+	// reach the other nodes as well as this one
+	if msg.To != "" && !slices.Contains(recipients, msg.To) {
+		if node, remote := r.remoteNode(msg.To); remote {
+			r.publishNode(node, msg)
+			return
+		}
+	}
+	if msg.To == "" {
+		r.publishRemote(msg, excludeClientID)
+	}
+
+	// Low-power rooms batch non-critical messages into periodic digests
+	if interval := r.Settings().Profile.params().digestInterval; interval > 0 && isDigestible(msg) {
+		r.queueDigest(msg, interval)
+		return
+	}
+
 	// Send to all clients via the broadcast channel
-	r.broadcast <- msg
+	r.enqueue(msg)
+}
+
+// enqueue hands a message to the broadcast loop. Messages for a room that
+// was removed are dropped, as nobody is left to get them
+func (r *Room) enqueue(msg *Message) {
+	select {
+	case r.broadcast <- msg:
+	case <-r.stopped:
+		util.Debug("Dropping %s for removed room %s", msg.Type, r.ID)
+	}
+}
+
+// stop ends the room's broadcast loop once it is removed from its hub
+func (r *Room) stop() {
+	r.stopOnce.Do(func() { close(r.stopped) })
 }
 
-// IsEmpty checks if the room has no clients
+// SendTo delivers a message to the client in its To field and nobody else,
+// routed to the client's node if it is connected elsewhere. Clients pulled
+// aside into a sidebar are only reachable from the sidebar
+func (r *Room) SendTo(msg *Message) error {
+	recipient := r.client(msg.To)
+	if recipient == nil {
+		if node, remote := r.remoteNode(msg.To); remote {
+			r.publishNode(node, msg)
+			return nil
+		}
+	}
+	if recipient == nil || recipient.asideFrom(r) {
+		r.traceUndeliverable(msg)
+		return ErrRecipientNotFound
+	}
+	recipient.Send(msg)
+	return nil
+}
+
+// isDigestible reports whether a message may be delayed and batched
+func isDigestible(msg *Message) bool {
+	if msg.To != "" {
+		return false
+	}
+	switch msg.Type {
+	case "reaction", "stats":
+		return true
+	default:
+		return false
+	}
+}
+
+// queueDigest holds a message for the next digest, scheduling one if needed
+func (r *Room) queueDigest(msg *Message, interval time.Duration) {
+	r.digestMutex.Lock()
+	defer r.digestMutex.Unlock()
+
+	r.digest = append(r.digest, msg)
+	if r.digestTimer == nil {
+		r.digestTimer = time.AfterFunc(interval, r.flushDigest)
+	}
+}
+
+// flushDigest broadcasts all held messages as a single digest message.
+// Recipients filter out entries they sent themselves
+func (r *Room) flushDigest() {
+	r.digestMutex.Lock()
+	messages := r.digest
+	r.digest = nil
+	r.digestTimer = nil
+	r.digestMutex.Unlock()
+
+	if len(messages) == 0 {
+		return
+	}
+
+	util.Debug("Room %s sending digest of %d messages", r.ID, len(messages))
+	r.enqueue(&Message{
+		Type: "digest",
+		Data: map[string]interface{}{
+			"messages": messages,
+		},
+	})
+}
+
+// IsEmpty checks if the room has no clients, in it or waiting to be admitted
 func (r *Room) IsEmpty() bool {
 	r.clientMutex.RLock()
 	defer r.clientMutex.RUnlock()
-	return len(r.clients) == 0
+	return len(r.clients) == 0 && len(r.lobby) == 0
+}
+
+// settle waits until every message broadcast so far has been handed to its
+// recipients
+func (r *Room) settle() {
+	barrier := make(chan struct{})
+	select {
+	case r.broadcast <- &Message{barrier: barrier}:
+	case <-r.stopped:
+		return
+	}
+	// The room may stop before its loop gets to the barrier
+	select {
+	case <-barrier:
+	case <-r.stopped:
+	}
 }
 
 // broadcastLoop handles broadcasting messages to all clients in the room
 func (r *Room) broadcastLoop() {
-	for msg := range r.broadcast {
+	for {
+		var msg *Message
+		select {
+		case msg = <-r.broadcast:
+		case <-r.stopped:
+			return
+		}
+		if msg.barrier != nil {
+			close(msg.barrier)
+			continue
+		}
+
+		started := time.Now()
 		r.clientMutex.RLock()
 		recipientCount := 0
 
@@ -200,6 +725,7 @@ func (r *Room) broadcastLoop() {
 			} else {
 				util.Warn("Unable to find recipient %s for message type=%s in room %s",
 					msg.To, msg.Type, r.ID)
+				r.traceUndeliverable(msg)
 			}
 		} else {
 			// Create a list of clients to send to (to avoid blocking during send)
@@ -209,6 +735,10 @@ func (r *Room) broadcastLoop() {
 				if msg.From == client.ID && msg.From != "" {
 					continue
 				}
+				// Clients in a sidebar negotiate media only there
+				if isPeerSignal(msg.Type) && client.asideFrom(r) {
+					continue
+				}
 				clientsToSend = append(clientsToSend, client)
 			}
 			r.clientMutex.RUnlock()
@@ -219,11 +749,14 @@ func (r *Room) broadcastLoop() {
 				recipientCount++
 			}
 
+			r.observeFanOut(time.Since(started))
+
 			util.Debug("Broadcasted message type=%s from=%s to %d clients in room %s",
 				msg.Type, msg.From, recipientCount, r.ID)
 			continue
 		}
 
 		r.clientMutex.RUnlock()
+		r.observeFanOut(time.Since(started))
 	}
 }
@@ -1,6 +1,15 @@
 package signaling
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -164,3 +173,1675 @@ func TestBroadcast(t *testing.T) {
 		t.Error("Expected client to receive the broadcast message")
 	}
 }
+
+func TestJoinDuplicatePolicies(t *testing.T) {
+	// Replace: the old connection is handed back and the new one of the same
+	// verified user takes its place
+	room := NewRoom("test-room")
+	old := &Client{ID: "dup", UserID: "alice", Verified: true}
+	room.AddClient(old)
+
+	// Anyone else claiming the ID joins beside it, without host status
+	impostor := &Client{ID: "dup", UserID: "alice"}
+	if replaced, err := room.Join(impostor); err != nil || replaced != nil {
+		t.Fatalf("Expected an unverified duplicate to join without replacing, got %v and %v", replaced, err)
+	}
+	if impostor.ID != "dup-d2" || room.clients["dup"] != old || impostor.isHost {
+		t.Errorf("Expected the impostor to join as dup-d2 without host status, got %s", impostor.ID)
+	}
+	room.removeConnection(impostor)
+
+	replacement := &Client{ID: "dup", UserID: "alice", Verified: true}
+	replaced, err := room.Join(replacement)
+	if err != nil {
+		t.Fatalf("Expected replace policy to accept the join, got %v", err)
+	}
+	if replaced != old {
+		t.Error("Expected the old connection to be returned for closing")
+	}
+	if room.clients["dup"] != replacement {
+		t.Error("Expected the new connection to be registered")
+	}
+	if !replacement.isHost {
+		t.Error("Expected the replacement to inherit host status")
+	}
+
+	// Removing the replaced connection must not evict its successor
+	room.removeConnection(old)
+	if room.clients["dup"] != replacement {
+		t.Error("Expected the replacement to survive removal of the old connection")
+	}
+
+	// Multi-device: the new connection gets a per-device ID
+	room = NewRoom("test-room")
+	room.UpdateSettings(func(s *RoomSettings) { s.DuplicatePolicy = DuplicateMultiDevice })
+	room.AddClient(&Client{ID: "dup"})
+	second := &Client{ID: "dup"}
+	third := &Client{ID: "dup"}
+	room.Join(second)
+	room.Join(third)
+	if second.ID != "dup-d2" || third.ID != "dup-d3" {
+		t.Errorf("Expected device IDs dup-d2 and dup-d3, got %s and %s", second.ID, third.ID)
+	}
+	if len(room.clients) != 3 {
+		t.Errorf("Expected 3 clients, got %d", len(room.clients))
+	}
+
+	// Reject: the second connection is refused
+	room = NewRoom("test-room")
+	room.UpdateSettings(func(s *RoomSettings) { s.DuplicatePolicy = DuplicateReject })
+	room.AddClient(&Client{ID: "dup"})
+	if _, err := room.Join(&Client{ID: "dup"}); err != ErrDuplicateClient {
+		t.Errorf("Expected ErrDuplicateClient, got %v", err)
+	}
+	if len(room.clients) != 1 {
+		t.Errorf("Expected 1 client after rejected join, got %d", len(room.clients))
+	}
+}
+
+func TestSwitchDevice(t *testing.T) {
+	room := NewRoom("test-room")
+	phone := &Client{ID: "alice-phone", UserID: "alice", DeviceID: "phone"}
+	laptop := &Client{ID: "alice-laptop", UserID: "alice", DeviceID: "laptop"}
+	bob := &Client{ID: "bob"}
+	room.AddClient(phone)
+	room.AddClient(laptop)
+	room.AddClient(bob)
+
+	// The first device publishes
+	if publisher := room.Publisher("alice"); publisher != phone.ID {
+		t.Errorf("Expected %s to publish, got %s", phone.ID, publisher)
+	}
+
+	// Both devices are grouped under one participant
+	participants := room.Participants()
+	if len(participants) != 2 {
+		t.Fatalf("Expected 2 participants, got %d", len(participants))
+	}
+	if participants[0].UserID != "alice" || len(participants[0].Devices) != 2 {
+		t.Errorf("Expected alice with 2 devices, got %+v", participants[0])
+	}
+
+	// Hand publishing to the laptop
+	if err := room.SwitchDevice(phone, laptop.ID); err != nil {
+		t.Fatalf("Expected switch to succeed, got %v", err)
+	}
+	if publisher := room.Publisher("alice"); publisher != laptop.ID {
+		t.Errorf("Expected %s to publish after switch, got %s", laptop.ID, publisher)
+	}
+
+	// Switching to another user's device is refused
+	if err := room.SwitchDevice(phone, bob.ID); err == nil {
+		t.Error("Expected switching to another user's device to fail")
+	}
+
+	// When the publishing device leaves, the remaining device takes over
+	room.RemoveClient(laptop.ID)
+	if publisher := room.Publisher("alice"); publisher != phone.ID {
+		t.Errorf("Expected %s to publish after laptop left, got %s", phone.ID, publisher)
+	}
+}
+
+func TestLowPowerDigest(t *testing.T) {
+	room := NewRoom("test-room")
+	room.UpdateSettings(func(s *RoomSettings) { s.Profile = ProfileLowPower })
+	client := &Client{ID: "test-client", send: make(chan *Message, 10), state: StateReady}
+	room.AddClient(client)
+
+	room.Broadcast(&Message{Type: "reaction", From: "a"}, "a")
+	room.Broadcast(&Message{Type: "stats", From: "b"}, "b")
+
+	// Non-critical messages are held back
+	time.Sleep(50 * time.Millisecond)
+	if len(client.send) != 0 {
+		t.Fatalf("Expected reactions to be held for the digest, got %d queued messages", len(client.send))
+	}
+
+	// Critical messages are still delivered immediately
+	room.Broadcast(&Message{Type: "offer", From: "a"}, "a")
+	time.Sleep(50 * time.Millisecond)
+	if len(client.send) != 1 {
+		t.Fatalf("Expected the offer to be delivered immediately, got %d queued messages", len(client.send))
+	}
+	<-client.send
+
+	room.flushDigest()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case digest := <-client.send:
+		if digest.Type != "digest" {
+			t.Fatalf("Expected a digest message, got %s", digest.Type)
+		}
+		if messages := digest.Data["messages"].([]*Message); len(messages) != 2 {
+			t.Errorf("Expected 2 messages in the digest, got %d", len(messages))
+		}
+	default:
+		t.Error("Expected the digest to be delivered")
+	}
+}
+
+func TestClientErrorReports(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("error-room")
+	client := &Client{ID: "client-a", Room: room}
+	room.AddClient(client)
+
+	client.handleMessage(&Message{Type: "client-error", From: "client-a", Data: map[string]interface{}{
+		"kind": "ice", "message": "connection failed", "peerId": "client-b",
+	}})
+	client.handleMessage(&Message{Type: "client-error", From: "client-a", Data: map[string]interface{}{
+		"kind": "getUserMedia", "message": "NotAllowedError",
+	}})
+	hub.ReportClientError(ClientErrorReport{RoomID: "missing-room", Kind: "weird"})
+
+	summary := room.ClientErrors()
+	if summary.Counts[ClientErrorICE] != 1 || summary.Counts[ClientErrorMedia] != 1 {
+		t.Errorf("Unexpected error counts: %v", summary.Counts)
+	}
+	if summary.Recent[0].PeerID != "client-b" {
+		t.Errorf("Expected the ICE report to keep its peer, got %+v", summary.Recent[0])
+	}
+
+	// Reports for unknown rooms are kept hub-wide without creating the room
+	summaries := hub.ClientErrors()
+	if len(summaries) != 2 || summaries[0].RoomID != "" || summaries[0].Counts[ClientErrorOther] != 1 {
+		t.Errorf("Unexpected hub summaries: %+v", summaries)
+	}
+	if hub.FindRoom("missing-room") != nil {
+		t.Error("Expected no room to be created by an HTTP report")
+	}
+}
+
+func TestICEFailureSuggestsTURN(t *testing.T) {
+	hub := NewHub()
+	hub.SetTURNConfig(TURNConfig{URLs: []string{"turn:turn.example.com:3478"}, Secret: "secret"})
+	var events []Event
+	hub.OnEvent(func(event Event) {
+		if event.Type == EventTURNRequired {
+			events = append(events, event)
+		}
+	})
+
+	room := hub.GetRoom("ice-room")
+	client := &Client{ID: "client-a", Room: room, hub: hub, send: make(chan *Message, 10)}
+	room.AddClient(client)
+
+	failure := &Message{Type: "client-error", From: "client-a", Data: map[string]interface{}{
+		"kind": "ice", "message": "failed", "peerId": "client-b",
+	}}
+	client.handleMessage(failure)
+	if len(client.send) != 0 || len(events) != 0 {
+		t.Fatal("Expected no diagnostics after a single failure")
+	}
+
+	client.handleMessage(failure)
+	if len(client.send) != 1 {
+		t.Fatalf("Expected ice-diagnostics after repeated failures, got %d messages", len(client.send))
+	}
+	diagnostics := <-client.send
+	servers, _ := diagnostics.Data["iceServers"].([]ICEServer)
+	if diagnostics.Type != "ice-diagnostics" || len(servers) != 1 || servers[0].Credential == "" {
+		t.Errorf("Expected diagnostics with TURN credentials, got %+v", diagnostics)
+	}
+	if len(events) != 1 || events[0].Data["peerId"] != "client-b" {
+		t.Errorf("Expected a turn-required event, got %+v", events)
+	}
+
+	// Clients get TURN credentials up front in welcome
+	client.announceJoin()
+	welcome := <-client.send
+	servers, _ = welcome.Data["iceServers"].([]ICEServer)
+	if welcome.Type != "welcome" || len(servers) != 1 || !strings.HasSuffix(servers[0].Username, ":client-a") {
+		t.Errorf("Expected welcome with TURN credentials, got %+v", welcome)
+	}
+}
+
+func TestRoomHealth(t *testing.T) {
+	room := NewRoom("health-room")
+	host := &Client{ID: "host", Room: room, send: make(chan *Message, 10)}
+	guest := &Client{ID: "guest", Room: room}
+	room.AddClient(host)
+	room.AddClient(guest)
+
+	if health := room.Health(); health.Score != 100 || health.Status != HealthGood {
+		t.Errorf("Expected a perfect score for a fresh room, got %+v", health)
+	}
+
+	// Heavy packet loss pushes the room towards audio-only
+	room.recordStats("host", &Message{Data: map[string]interface{}{"packetLoss": 0.3}})
+	room.recordStats("guest", &Message{Data: map[string]interface{}{"packetLoss": 0.3}})
+	health := room.Health()
+	if health.Score != 60 || health.Status != HealthFair || health.Recommendation != "audio-only" {
+		t.Errorf("Expected a fair room recommending audio-only, got %+v", health)
+	}
+
+	// Rejoining under the same ID counts as a reconnect
+	room.RemoveClient("guest")
+	room.AddClient(guest)
+	if health := room.Health(); health.Reconnects != 1 {
+		t.Errorf("Expected 1 reconnect, got %d", health.Reconnects)
+	}
+
+	room.sendHealth()
+	if msg := <-host.send; msg.Type != "room-health" {
+		t.Errorf("Expected the host to get room-health, got %s", msg.Type)
+	}
+}
+
+// memoryRecordingStore keeps the latest metadata of each recording
+type memoryRecordingStore map[string]Recording
+
+func (s memoryRecordingStore) SaveRecording(recording Recording) error {
+	s[recording.ID] = recording
+	return nil
+}
+
+// drainTypes returns the types of all messages queued for a client
+func drainTypes(client *Client) []string {
+	var types []string
+	for len(client.send) > 0 {
+		types = append(types, (<-client.send).Type)
+	}
+	return types
+}
+
+func TestParticipantRecording(t *testing.T) {
+	hub := NewHub()
+	store := memoryRecordingStore{}
+	hub.SetRecordingStore(store)
+	var events []Event
+	hub.OnEvent(func(event Event) {
+		if event.Type == EventRecordingStarted || event.Type == EventRecordingReady {
+			events = append(events, event)
+		}
+	})
+
+	room := hub.GetRoom("recording-room")
+	host := &Client{ID: "host", Room: room, hub: hub, send: make(chan *Message, 20)}
+	presenter := &Client{ID: "presenter", Room: room, hub: hub, send: make(chan *Message, 20)}
+	room.AddClient(host)
+	room.AddClient(presenter)
+	drainTypes(presenter)
+
+	// Only the host may ask
+	if _, err := room.RequestRecording(presenter, host.ID, RecordTracksScreen); err == nil {
+		t.Error("Expected a non-host request to be rejected")
+	}
+
+	recording, err := room.RequestRecording(host, presenter.ID, RecordTracksScreen)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, got %v", err)
+	}
+	if types := drainTypes(presenter); len(types) != 1 || types[0] != "recording-consent-request" {
+		t.Errorf("Expected a consent request, got %v", types)
+	}
+	if store[recording.ID].Status != RecordingPending {
+		t.Errorf("Expected a pending recording in the store, got %+v", store[recording.ID])
+	}
+
+	// Nothing can be uploaded before consent
+	if _, err := room.AuthorizeRecordingUpload(recording.ID, ""); err == nil {
+		t.Error("Expected upload before consent to be rejected")
+	}
+
+	// Only the recorded participant answers
+	if _, err := room.AnswerRecordingConsent(host, recording.ID, true); err == nil {
+		t.Error("Expected the host to be unable to consent for the presenter")
+	}
+	presenter.handleMessage(&Message{Type: "recording-consent", From: presenter.ID, Data: map[string]interface{}{
+		"recordingId": recording.ID, "accepted": true,
+	}})
+	room.settle()
+	start := <-presenter.send
+	token, _ := start.Data["uploadToken"].(string)
+	if start.Type != "recording-start" || token == "" {
+		t.Fatalf("Expected recording-start with an upload token, got %+v", start)
+	}
+	if len(events) != 1 || events[0].Type != EventRecordingStarted || events[0].ClientID != presenter.ID {
+		t.Errorf("Expected a recording-started event, got %+v", events)
+	}
+
+	if _, err := room.StopRecording(host, recording.ID); err != nil {
+		t.Fatalf("Expected host to stop the recording, got %v", err)
+	}
+	if _, err := room.AuthorizeRecordingUpload(recording.ID, "wrong"); err == nil {
+		t.Error("Expected upload with a wrong token to be rejected")
+	}
+	if _, err := room.AuthorizeRecordingUpload(recording.ID, token); err != nil {
+		t.Fatalf("Expected upload to be authorized, got %v", err)
+	}
+
+	done, err := hub.CompleteRecording(room.ID, recording.ID, Artifact{File: recording.ID + ".webm", ContentType: "video/webm", Size: 1234})
+	if err != nil || done.Status != RecordingAvailable || store[recording.ID].Size != 1234 {
+		t.Fatalf("Expected a ready recording, got %+v, %v", done, err)
+	}
+	if len(events) != 2 || events[1].Type != EventRecordingReady {
+		t.Errorf("Expected a recording-ready event, got %+v", events)
+	}
+
+	// The token is single use
+	if _, err := room.AuthorizeRecordingUpload(recording.ID, token); err == nil {
+		t.Error("Expected a second upload to be rejected")
+	}
+
+	// A participant who leaves without answering declines
+	second, _ := room.RequestRecording(host, presenter.ID, RecordTracksCamera)
+	room.RemoveClient(presenter.ID)
+	room.endRecordingsOf(presenter.ID)
+	if store[second.ID].Status != RecordingDeclined {
+		t.Errorf("Expected unanswered request to be declined, got %s", store[second.ID].Status)
+	}
+}
+
+// upperTranslator "translates" by upper-casing and tagging the language
+type upperTranslator struct{}
+
+func (upperTranslator) Translate(ctx context.Context, text, from, to string) (string, error) {
+	return to + ":" + strings.ToUpper(text), nil
+}
+
+func TestCaptionChannels(t *testing.T) {
+	hub := NewHub()
+	hub.SetTranslator(upperTranslator{})
+	room := hub.GetRoomWithSettings("captions", func(settings *RoomSettings) {
+		settings.Transcription = true
+		settings.CaptionLanguages = ParseCaptionLanguages("es, fr,es")
+	})
+	if channels := room.CaptionChannels(); len(channels) != 3 {
+		t.Fatalf("Expected original, es and fr, got %v", channels)
+	}
+
+	speaker := &Client{ID: "speaker", Room: room, hub: hub, send: make(chan *Message, 10)}
+	original := &Client{ID: "original", Room: room, hub: hub, send: make(chan *Message, 10)}
+	spanish := &Client{ID: "spanish", Room: room, hub: hub, send: make(chan *Message, 10)}
+	english := &Client{ID: "english", Room: room, hub: hub, send: make(chan *Message, 10)}
+	for _, client := range []*Client{speaker, original, spanish, english} {
+		room.AddClient(client)
+		drainTypes(client)
+	}
+	room.SubscribeCaptions(original.ID, CaptionOriginal)
+	room.SubscribeCaptions(spanish.ID, "es")
+	if err := room.SubscribeCaptions(english.ID, "de"); err == nil {
+		t.Error("Expected subscribing to an unknown channel to fail")
+	}
+
+	// Interim captions are not translated
+	speaker.handleMessage(&Message{Type: "caption", From: speaker.ID, Data: map[string]interface{}{
+		"text": "hello", "lang": "en", "final": false,
+	}})
+	if len(original.send) != 1 || len(spanish.send) != 0 || len(speaker.send) != 0 {
+		t.Fatalf("Expected the interim caption only on the original channel, got %d/%d", len(original.send), len(spanish.send))
+	}
+	<-original.send
+
+	if err := room.PublishCaption(Caption{Speaker: speaker.ID, Text: "hello world", Lang: "en", Final: true}); err != nil {
+		t.Fatalf("Expected caption to be published, got %v", err)
+	}
+	select {
+	case msg := <-spanish.send:
+		if msg.Data["channel"] != "es" || msg.Data["text"] != "es:HELLO WORLD" {
+			t.Errorf("Expected the Spanish translation, got %+v", msg.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a translated caption")
+	}
+	if msg := <-original.send; msg.Data["text"] != "hello world" {
+		t.Errorf("Expected the original text, got %+v", msg.Data)
+	}
+	if transcript := room.Transcript(); len(transcript) != 1 || transcript[0].Text != "hello world" {
+		t.Errorf("Expected one final caption in the transcript, got %+v", transcript)
+	}
+
+	// Rooms without transcription refuse captions
+	plain := hub.GetRoom("plain")
+	if err := plain.PublishCaption(Caption{Text: "hi"}); err != ErrTranscriptionDisabled {
+		t.Errorf("Expected ErrTranscriptionDisabled, got %v", err)
+	}
+}
+
+func TestKeywordAlerts(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoomWithSettings("sales", func(settings *RoomSettings) {
+		settings.Transcription = true
+	})
+	host := &Client{ID: "host", Room: room, hub: hub, send: make(chan *Message, 10)}
+	guest := &Client{ID: "guest", Room: room, hub: hub, send: make(chan *Message, 10)}
+	room.AddClient(host)
+	room.AddClient(guest)
+	drainTypes(host)
+	drainTypes(guest)
+
+	if _, err := room.SetKeywords(guest, []string{"price"}); err == nil {
+		t.Error("Expected only the host to set keywords")
+	}
+	host.handleMessage(&Message{Type: "set-keywords", From: host.ID, Data: map[string]interface{}{
+		"keywords": []interface{}{"Price", " discount  code ", "price"},
+	}})
+	if msg := <-host.send; msg.Type != "keywords-set" || len(msg.Data["keywords"].([]string)) != 2 {
+		t.Fatalf("Expected two keywords to be set, got %+v", msg)
+	}
+	room.settle()
+	drainTypes(host)
+	drainTypes(guest)
+
+	room.PublishCaption(Caption{Speaker: guest.ID, Text: "What's the price?", Final: false})
+	room.PublishCaption(Caption{Speaker: guest.ID, Text: "Prices are high", Final: true})
+	room.PublishCaption(Caption{Speaker: guest.ID, Text: "Do you have a DISCOUNT code for the price?", Final: true})
+	if types := drainTypes(host); len(types) != 2 || types[0] != "keyword-alert" {
+		t.Fatalf("Expected two alerts for the last caption only, got %v", types)
+	}
+	if len(guest.send) != 0 {
+		t.Error("Expected alerts to go to the host only")
+	}
+}
+
+// memoryAuditLog collects audit entries
+type memoryAuditLog struct {
+	mutex   sync.Mutex
+	entries []AuditEntry
+}
+
+func (l *memoryAuditLog) Append(entry AuditEntry) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+func TestComplianceMode(t *testing.T) {
+	hub := NewHub()
+	store := memoryRecordingStore{}
+	audit := &memoryAuditLog{}
+	hub.SetRecordingStore(store)
+	hub.SetAuditLog(audit)
+	hub.SetCompliancePolicies([]CompliancePolicy{{Tenant: "bank", Announcement: "This call is recorded", BlockPrivateChat: true}})
+
+	room := hub.GetRoomWithSettings("trading", func(settings *RoomSettings) {
+		settings.Tenant = "bank"
+	})
+	alice := &Client{ID: "alice", Room: room, hub: hub, send: make(chan *Message, 20)}
+	bob := &Client{ID: "bob", Room: room, hub: hub, send: make(chan *Message, 20)}
+	for _, client := range []*Client{alice, bob} {
+		room.AddClient(client)
+		client.announceJoin()
+	}
+	room.settle()
+
+	var recordingID string
+	types := []string{}
+	for len(alice.send) > 0 {
+		msg := <-alice.send
+		types = append(types, msg.Type)
+		switch msg.Type {
+		case "welcome":
+			if msg.Data["compliance"] == nil {
+				t.Error("Expected the welcome to announce compliance mode")
+			}
+		case "chat":
+			if msg.From != "compliance" || msg.Data["text"] != "This call is recorded" {
+				t.Errorf("Expected the announcement, got %+v", msg)
+			}
+		case "recording-start":
+			recordingID, _ = msg.Data["recordingId"].(string)
+		}
+	}
+	if recordingID == "" || store[recordingID].Kind != RecordingCompliance {
+		t.Fatalf("Expected a mandatory recording to start without consent, got %v", types)
+	}
+	if _, err := room.StopRecording(alice, recordingID); err == nil {
+		t.Error("Expected the compliance recording not to be stoppable")
+	}
+
+	alice.handleMessage(&Message{Type: "chat", From: alice.ID, To: bob.ID, Data: map[string]interface{}{"text": "psst"}})
+	if msg := <-alice.send; msg.Type != "chat-rejected" {
+		t.Errorf("Expected private chat to be rejected, got %s", msg.Type)
+	}
+
+	if _, err := hub.CompleteRecording(room.ID, recordingID, Artifact{File: "a.webm", Size: 4, SHA256: "abc"}); err != nil {
+		t.Fatalf("Expected recording to complete, got %v", err)
+	}
+	actions := []string{}
+	for _, entry := range audit.entries {
+		actions = append(actions, entry.Action)
+		if entry.Action == AuditRecordingStored && (entry.SHA256 != "abc" || entry.Tenant != "bank") {
+			t.Errorf("Expected the artifact's hash in the audit log, got %+v", entry)
+		}
+	}
+	if len(actions) != 4 || actions[3] != AuditRecordingStored {
+		t.Errorf("Expected two starts, a blocked chat and a stored recording, got %v", actions)
+	}
+
+	// Rooms of other tenants are unaffected
+	if hub.GetRoom("lobby").Compliance() != nil {
+		t.Error("Expected rooms without a tenant policy to be unrestricted")
+	}
+}
+
+func TestWatermark(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("board")
+	alice := &Client{ID: "c1", UserID: "alice@example.com", Room: room, hub: hub, send: make(chan *Message, 10)}
+	guest := &Client{ID: "c2", Room: room, hub: hub, send: make(chan *Message, 10)}
+	room.AddClient(alice)
+	room.AddClient(guest)
+	drainTypes(alice)
+	drainTypes(guest)
+
+	if err := room.SetWatermark(&Watermark{Template: "{user} in {room}", Opacity: 2}); err == nil {
+		t.Error("Expected an opacity above 1 to be rejected")
+	}
+	if err := room.SetWatermark(&Watermark{Template: "{user} in {room}"}); err != nil {
+		t.Fatalf("Expected watermark to be set, got %v", err)
+	}
+	if msg := <-alice.send; msg.Type != "watermark" || msg.Data["text"] != "alice@example.com in board" {
+		t.Errorf("Expected Alice's own watermark, got %+v", msg)
+	}
+	if msg := <-guest.send; msg.Data["text"] != "c2 in board" {
+		t.Errorf("Expected the guest's client ID in the watermark, got %+v", msg.Data)
+	}
+
+	room.SetWatermark(nil)
+	if msg := <-alice.send; msg.Data["text"] != "" {
+		t.Errorf("Expected the watermark to be removed, got %+v", msg.Data)
+	}
+}
+
+func TestScreenCaptureReports(t *testing.T) {
+	hub := NewHub()
+	audit := &memoryAuditLog{}
+	hub.SetAuditLog(audit)
+	room := hub.GetRoom("board")
+	host := &Client{ID: "host", Room: room, hub: hub, send: make(chan *Message, 10)}
+	guest := &Client{ID: "guest", Room: room, hub: hub, send: make(chan *Message, 10)}
+	room.AddClient(host)
+	room.AddClient(guest)
+	drainTypes(host)
+
+	guest.handleMessage(&Message{Type: "screen-capture-detected", From: guest.ID, Data: map[string]interface{}{"kind": "screenshot", "detail": "PrintScreen"}})
+	guest.handleMessage(&Message{Type: "screen-capture-detected", From: guest.ID, Data: map[string]interface{}{"kind": "screenshot"}})
+	if err := room.ReportScreenCapture(guest, "webcam", ""); err == nil {
+		t.Error("Expected an unknown capture kind to be rejected")
+	}
+
+	msg := <-host.send
+	if msg.Type != "screen-capture-alert" || msg.Data["clientId"] != "guest" || msg.Data["kind"] != CaptureScreenshot {
+		t.Errorf("Expected the host to be alerted, got %+v", msg)
+	}
+	if len(host.send) != 0 {
+		t.Error("Expected the repeated report to be throttled")
+	}
+	if len(audit.entries) != 1 || audit.entries[0].Action != AuditScreenCapture || audit.entries[0].ClientID != "guest" {
+		t.Errorf("Expected one audit entry, got %+v", audit.entries)
+	}
+}
+
+func TestRoleLimits(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("webinar")
+	viewer := &Client{ID: "viewer", Room: room, hub: hub, maxRole: RoleViewer, send: make(chan *Message, 10)}
+	alice := &Client{ID: "alice", Room: room, hub: hub, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: room, hub: hub, maxRole: RoleParticipant, send: make(chan *Message, 10)}
+
+	room.AddClient(viewer)
+	if viewer.IsHost() || room.GetHost() != "" {
+		t.Fatal("Expected a viewer not to become host of an empty room")
+	}
+	room.AddClient(alice)
+	room.AddClient(bob)
+	if room.GetHost() != alice.ID || alice.Role() != RoleHost {
+		t.Fatalf("Expected the first client allowed to host to become host, got %q", room.GetHost())
+	}
+	if room.SetHost(bob.ID) || bob.Role() != RoleParticipant {
+		t.Error("Expected a participant-limited client not to be made host")
+	}
+
+	room.RemoveClient(alice.ID)
+	if room.GetHost() != "" || bob.IsHost() || viewer.IsHost() {
+		t.Errorf("Expected host status not to pass to limited clients, got %q", room.GetHost())
+	}
+
+	drainTypes(viewer)
+	viewer.handleMessage(&Message{Type: "chat", From: viewer.ID, Data: map[string]interface{}{"text": "hi"}})
+	if msg := <-viewer.send; msg.Type != "not-permitted" || msg.Data["type"] != "chat" {
+		t.Errorf("Expected a viewer's chat to be refused, got %+v", msg)
+	}
+}
+
+func TestRoleMapping(t *testing.T) {
+	mapping := &RoleMapping{
+		Rules: []RoleRule{
+			{Claim: "groups", Value: "eng-leads", Role: RoleHost, Permissions: []Permission{PermRecord}},
+			{Claim: "groups", Value: "staff", Role: RoleParticipant},
+			{Claim: "roles", Value: "recorder", Permissions: []Permission{PermRecord}},
+		},
+		DefaultRole: RoleViewer,
+		GuestRole:   RoleViewer,
+	}
+	if err := mapping.validate(); err != nil {
+		t.Fatal(err)
+	}
+	claims := func(values map[string][]string) func(string) []string {
+		return func(name string) []string { return values[name] }
+	}
+
+	role, permissions := mapping.Evaluate(claims(map[string][]string{"groups": {"staff", "eng-leads"}}))
+	if role != RoleHost || len(permissions) != 1 {
+		t.Errorf("Expected the most privileged matching role, got %s %v", role, permissions)
+	}
+	role, permissions = mapping.Evaluate(claims(map[string][]string{"groups": {"staff"}, "roles": {"recorder"}}))
+	if role != RoleParticipant || len(permissions) != 1 {
+		t.Errorf("Expected a participant allowed to record, got %s %v", role, permissions)
+	}
+	role, permissions = mapping.Evaluate(claims(map[string][]string{"roles": {"recorder"}}))
+	if role != RoleViewer || len(permissions) != 1 {
+		t.Errorf("Expected the default role with the rule's permissions, got %s %v", role, permissions)
+	}
+	if role, permissions = mapping.Evaluate(nil); role != RoleViewer || permissions == nil || len(permissions) != 0 {
+		t.Errorf("Expected guests to be viewers without permissions, got %s %v", role, permissions)
+	}
+	if err := (&RoleMapping{Rules: []RoleRule{{Claim: "groups", Value: "x", Role: "owner"}}}).validate(); err == nil {
+		t.Error("Expected an unknown role to be rejected")
+	}
+
+	hub := NewHub()
+	room := hub.GetRoom("review")
+	host := &Client{ID: "host", Room: room, hub: hub, permissions: []Permission{}, send: make(chan *Message, 10)}
+	recorder := &Client{ID: "recorder", Room: room, hub: hub, maxRole: RoleParticipant, permissions: []Permission{PermRecord}, send: make(chan *Message, 10)}
+	room.AddClient(host)
+	room.AddClient(recorder)
+	if _, err := room.RequestRecording(host, recorder.ID, ""); !errors.Is(err, ErrRoleDenied) {
+		t.Errorf("Expected a host without the record permission to be refused, got %v", err)
+	}
+	if _, err := room.RequestRecording(recorder, host.ID, ""); err != nil {
+		t.Errorf("Expected the record permission to allow recording, got %v", err)
+	}
+}
+
+func TestOverflowRooms(t *testing.T) {
+	hub := NewHub()
+	if _, err := hub.ImportRoom(RoomSnapshot{ID: "keynote", Settings: RoomSettings{Overflow: &OverflowSettings{}}}); err == nil {
+		t.Error("Expected an overflow without capacity to be rejected")
+	}
+	settings := DefaultRoomSettings()
+	settings.Overflow = &OverflowSettings{Capacity: 2}
+	if _, err := hub.ImportRoom(RoomSnapshot{ID: "keynote", Settings: settings}); err != nil {
+		t.Fatal(err)
+	}
+
+	clients := make([]*Client, 4)
+	for i := range clients {
+		clients[i] = &Client{ID: fmt.Sprintf("c%d", i+1), hub: hub, send: make(chan *Message, 10)}
+		if err := clients[i].join("keynote"); err != nil {
+			t.Fatalf("Expected client %d to join, got %v", i+1, err)
+		}
+		clients[i].MarkReady()
+	}
+	if clients[1].Room.ID != "keynote" || clients[2].Room.ID != "keynote-overflow-1" || clients[3].Room != clients[2].Room {
+		t.Fatalf("Expected the third and fourth client in the first overflow room, got %s and %s", clients[2].Room.ID, clients[3].Room.ID)
+	}
+	if clients[2].Room.OverflowOf() != "keynote" || clients[2].Room.Settings().Persistent {
+		t.Error("Expected the overflow room to be linked to the event room")
+	}
+	links := hub.Links("keynote")
+	if len(links.OverflowRooms) != 1 || links.OverflowRooms[0] != (OverflowRoom{RoomID: "keynote-overflow-1", Participants: 2}) {
+		t.Errorf("Expected the event room to list its overflow room, got %+v", links)
+	}
+
+	// Chat is shared between the event room and its overflow rooms
+	for _, client := range clients {
+		drainTypes(client)
+	}
+	clients[3].handleMessage(&Message{Type: "chat", From: "c4", Data: map[string]interface{}{"text": "hello"}})
+	clients[0].Room.settle()
+	msg := <-clients[0].send
+	if msg.Type != "chat" || msg.Data["text"] != "hello" || msg.Data["originRoomId"] != "keynote-overflow-1" {
+		t.Errorf("Expected the overflow chat in the event room, got %+v", msg)
+	}
+
+	// Closing the overflow room unlinks it
+	clients[2].Room.RemoveClient("c3")
+	clients[2].Room.RemoveClient("c4")
+	hub.RemoveRoom("keynote-overflow-1")
+	if links := hub.Links("keynote"); len(links.OverflowRooms) != 0 {
+		t.Errorf("Expected no overflow rooms after closing, got %+v", links)
+	}
+}
+
+func TestMaxParticipants(t *testing.T) {
+	hub := NewHub()
+	settings := DefaultRoomSettings()
+	settings.MaxParticipants = -1
+	if _, err := hub.ImportRoom(RoomSnapshot{ID: "huddle", Settings: settings}); err == nil {
+		t.Error("Expected a negative limit to be rejected")
+	}
+	settings.MaxParticipants = 2
+	room, err := hub.ImportRoom(RoomSnapshot{ID: "huddle", Settings: settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"alice", "bob"} {
+		if err := room.AddClient(&Client{ID: id, Room: room, hub: hub, send: make(chan *Message, 10)}); err != nil {
+			t.Fatalf("Expected %s to join, got %v", id, err)
+		}
+	}
+	if err := room.AddClient(&Client{ID: "carol", Room: room, hub: hub, send: make(chan *Message, 10)}); err != ErrRoomFull {
+		t.Errorf("Expected carol to be refused, got %v", err)
+	}
+	if err := room.AddClient(&Client{ID: "bob", Room: room, hub: hub, send: make(chan *Message, 10)}); err != nil {
+		t.Errorf("Expected bob to reconnect to the full room, got %v", err)
+	}
+
+	// Rooms without overflow rooms refuse latecomers instead of spilling over
+	dave := &Client{ID: "dave", hub: hub, send: make(chan *Message, 10)}
+	if err := dave.join("huddle"); err != ErrRoomFull || hub.FindRoom("huddle-overflow-1") != nil {
+		t.Errorf("Expected dave to be refused without an overflow room, got %v", err)
+	}
+}
+
+func TestChatFederation(t *testing.T) {
+	hub := NewHub()
+	main := hub.GetRoom("all-hands")
+	breakout := hub.GetRoom("all-hands-qa")
+	hub.GetRoomWithSettings("other-tenant", func(s *RoomSettings) { s.Tenant = "globex" })
+
+	if _, err := hub.FederateChat("all-hands", []string{"all-hands"}); err == nil {
+		t.Error("Expected a federation of one room to be rejected")
+	}
+	if _, err := hub.FederateChat("mixed", []string{"all-hands", "other-tenant"}); err == nil {
+		t.Error("Expected rooms of different tenants not to be federated")
+	}
+	if _, err := hub.FederateChat("all-hands", []string{"all-hands", "all-hands-qa", "all-hands-qa", "later"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.FederateChat("second", []string{"all-hands-qa", "lobby"}); err == nil {
+		t.Error("Expected a room to be in one federation only")
+	}
+
+	speaker := &Client{ID: "speaker", Room: main, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	asker := &Client{ID: "asker", Room: breakout, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	main.AddClient(speaker)
+	breakout.AddClient(asker)
+	drainTypes(speaker)
+	drainTypes(asker)
+
+	asker.handleMessage(&Message{Type: "chat", From: asker.ID, Data: map[string]interface{}{"text": "question"}})
+	breakout.settle()
+	main.settle()
+	if msg := <-speaker.send; msg.From != "asker" || msg.Data["text"] != "question" || msg.Data["originRoomId"] != "all-hands-qa" {
+		t.Errorf("Expected the question in the main room, got %+v", msg)
+	}
+
+	// Private chat stays in its room
+	speaker.handleMessage(&Message{Type: "chat", From: speaker.ID, To: "asker", Data: map[string]interface{}{"text": "psst"}})
+	main.settle()
+	breakout.settle()
+	if len(asker.send) != 0 {
+		t.Error("Expected private chat not to cross rooms")
+	}
+
+	if err := hub.DissolveChatFederation("all-hands"); err != nil || len(hub.ChatFederations()) != 0 {
+		t.Errorf("Expected the federation to be dissolved, got %v", err)
+	}
+}
+
+func TestSidebar(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("standup")
+	host := &Client{ID: "host", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	alice := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(host)
+	room.AddClient(alice)
+	room.AddClient(bob)
+	drainTypes(host)
+	drainTypes(alice)
+	drainTypes(bob)
+
+	if _, err := room.StartSidebar(alice, bob.ID); err == nil {
+		t.Error("Expected only the host to start a sidebar")
+	}
+	host.handleMessage(&Message{Type: "start-sidebar", From: host.ID, Data: map[string]interface{}{"clientId": "alice"}})
+	room.settle()
+	if msg := <-alice.send; msg.Type != "sidebar-started" || msg.Data["sidebarRoomId"] != "standup-sidebar-1" {
+		t.Fatalf("Expected alice to be pulled aside, got %+v", msg)
+	}
+	if msg := <-bob.send; msg.Type != "participant-aside" || msg.Data["aside"] != true {
+		t.Errorf("Expected bob to learn the pair stepped aside, got %+v", msg)
+	}
+	if links := hub.Links("standup-sidebar-1"); links.MainRoomID != "standup" {
+		t.Errorf("Expected the sidebar to be linked to its room, got %+v", links)
+	}
+	drainTypes(host)
+	drainTypes(alice)
+
+	// Offers stay within the sidebar, while chat still reaches the main room
+	bob.handleMessage(&Message{Type: "offer", From: bob.ID, To: "alice"})
+	bob.handleMessage(&Message{Type: "offer", From: bob.ID})
+	host.handleMessage(&Message{Type: "offer", From: host.ID, To: "alice"})
+	alice.handleMessage(&Message{Type: "chat", From: alice.ID, Data: map[string]interface{}{"text": "brb"}})
+	room.settle()
+	hub.FindRoom("standup-sidebar-1").settle()
+	if types := drainTypes(alice); len(types) != 1 || types[0] != "offer" {
+		t.Errorf("Expected alice to only get the host's offer, got %v", types)
+	}
+	if msg := <-bob.send; msg.Type != "recipient-not-found" || msg.Data["to"] != "alice" {
+		t.Errorf("Expected bob to learn alice can't be reached from the main room, got %+v", msg)
+	}
+	if msg := <-bob.send; msg.Type != "error" || msg.Data["code"] != ErrorNotFound {
+		t.Errorf("Expected a not-found error for the offer, got %+v", msg)
+	}
+	if msg := <-bob.send; msg.Type != "chat" {
+		t.Errorf("Expected chat from the sidebar in the main room, got %+v", msg)
+	}
+	for _, participant := range room.Participants() {
+		if aside := participant.Devices[0].Aside; aside != (participant.UserID != "bob") {
+			t.Errorf("Expected %s aside=%v in the roster", participant.UserID, !aside)
+		}
+	}
+
+	if err := alice.EndSidebar(); err != nil {
+		t.Fatal(err)
+	}
+	if types := drainTypes(alice); len(types) < 1 || types[0] != "sidebar-ended" {
+		t.Errorf("Expected alice to be back in the room, got %v", types)
+	}
+	if hub.FindRoom("standup-sidebar-1") != nil || alice.asideRoom() != nil || host.asideRoom() != nil {
+		t.Error("Expected the sidebar room to be removed")
+	}
+}
+
+func TestRelayDTMF(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("dial-out")
+	alice := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	gateway := &Client{ID: "sip-gateway", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	viewer := &Client{ID: "viewer", Room: room, hub: hub, state: StateReady, maxRole: RoleViewer, send: make(chan *Message, 10)}
+	for _, client := range []*Client{alice, gateway, bob, viewer} {
+		room.AddClient(client)
+	}
+	room.settle()
+	for _, client := range []*Client{alice, gateway, bob, viewer} {
+		drainTypes(client)
+	}
+
+	alice.handleMessage(&Message{Type: "dtmf", From: alice.ID, To: gateway.ID, Data: map[string]interface{}{"digits": "1#b", "duration": float64(10)}})
+	msg := <-gateway.send
+	if msg.Type != "dtmf" || msg.From != "alice" || msg.Data["digits"] != "1#B" || msg.Data["duration"] != minDTMFDuration {
+		t.Errorf("Expected normalized tones for the gateway, got %+v", msg)
+	}
+	if types := drainTypes(bob); len(types) != 0 {
+		t.Errorf("Expected tones for the gateway to reach only it, got %v", types)
+	}
+
+	// Tones from the phone side reach everyone in the room
+	gateway.handleMessage(&Message{Type: "dtmf", From: gateway.ID, Data: map[string]interface{}{"digits": "5"}})
+	room.settle()
+	if msg := <-bob.send; msg.Type != "dtmf" || msg.Data["duration"] != defaultDTMFDuration {
+		t.Errorf("Expected bob to get the gateway's tones, got %+v", msg)
+	}
+
+	if err := room.RelayDTMF(alice, &Message{Type: "dtmf", Data: map[string]interface{}{"digits": "12x"}}); err == nil {
+		t.Error("Expected an invalid digit to be refused")
+	}
+	drainTypes(viewer)
+	viewer.handleMessage(&Message{Type: "dtmf", From: viewer.ID, Data: map[string]interface{}{"digits": "0"}})
+	if msg := <-viewer.send; msg.Type != "not-permitted" {
+		t.Errorf("Expected viewers not to send tones, got %+v", msg)
+	}
+}
+
+func TestTargetedSignaling(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("mesh")
+	var clients []*Client
+	for _, id := range []string{"a", "b", "c"} {
+		client := &Client{ID: id, Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+		room.AddClient(client)
+		clients = append(clients, client)
+	}
+	room.settle()
+	for _, client := range clients {
+		drainTypes(client)
+	}
+	a, b, c := clients[0], clients[1], clients[2]
+
+	// In a mesh call each peer connection's signals reach only that peer
+	a.handleMessage(&Message{Type: "offer", From: a.ID, To: b.ID})
+	a.handleMessage(&Message{Type: "ice-candidate", From: a.ID, To: c.ID})
+	room.settle()
+	if types := drainTypes(b); len(types) != 1 || types[0] != "offer" {
+		t.Errorf("Expected b to get only its offer, got %v", types)
+	}
+	if types := drainTypes(c); len(types) != 1 || types[0] != "ice-candidate" {
+		t.Errorf("Expected c to get only its candidate, got %v", types)
+	}
+
+	// The sender hears about peers that are gone
+	a.handleMessage(&Message{Type: "answer", From: a.ID, To: "gone"})
+	if msg := <-a.send; msg.Type != "recipient-not-found" || msg.Data["to"] != "gone" || msg.Data["type"] != "answer" {
+		t.Errorf("Expected recipient-not-found for a missing peer, got %+v", msg)
+	}
+	if err := room.SendTo(&Message{Type: "offer", To: "gone"}); err != ErrRecipientNotFound {
+		t.Errorf("Expected ErrRecipientNotFound, got %v", err)
+	}
+}
+
+func TestRoomPassword(t *testing.T) {
+	hub := NewHub()
+	if _, err := hub.ImportRoom(RoomSnapshot{ID: "board", PasswordHash: "plain"}); err == nil {
+		t.Error("Expected a password hash that isn't bcrypt to be refused")
+	}
+	hash, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	room, err := hub.ImportRoom(RoomSnapshot{ID: "board", PasswordHash: hash})
+	if err != nil || !room.HasPassword() {
+		t.Fatalf("Expected a password-protected room, got %v", err)
+	}
+	for password, want := range map[string]error{"": ErrPasswordRequired, "guess": ErrWrongPassword, "s3cret": nil} {
+		if err := room.checkPassword(password); err != want {
+			t.Errorf("Expected %v for %q, got %v", want, password, err)
+		}
+	}
+	if room.Snapshot().PasswordHash != hash {
+		t.Error("Expected the hash to be persisted with the room")
+	}
+}
+
+// fakeMediaServer records the SFU sessions of a room in SFU mode
+type fakeMediaServer struct {
+	offers   map[string]func(sdp string)
+	answers  map[string]string
+	restarts []string
+	closed   []string
+}
+
+func (m *fakeMediaServer) Connect(roomID, clientID string, offer func(sdp string)) (string, error) {
+	m.offers[clientID] = offer
+	offer("offer-" + clientID)
+	return "session-" + clientID, nil
+}
+
+func (m *fakeMediaServer) Answer(sessionID, sdp string) error {
+	m.answers[sessionID] = sdp
+	return nil
+}
+
+func (m *fakeMediaServer) RestartICE(sessionID string) error {
+	m.restarts = append(m.restarts, sessionID)
+	return nil
+}
+
+func (m *fakeMediaServer) CloseSession(sessionID string) error {
+	m.closed = append(m.closed, sessionID)
+	return nil
+}
+
+func TestSFUMode(t *testing.T) {
+	hub := NewHub()
+	if _, err := hub.ImportRoom(RoomSnapshot{ID: "town-hall", Settings: RoomSettings{Mode: ModeSFU}}); err != ErrNoMediaServer {
+		t.Fatalf("Expected SFU mode to require a media server, got %v", err)
+	}
+	media := &fakeMediaServer{offers: map[string]func(string){}, answers: map[string]string{}}
+	hub.SetMediaServer(media)
+	room, err := hub.ImportRoom(RoomSnapshot{ID: "town-hall", Settings: RoomSettings{Mode: ModeSFU}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	if _, err := room.Join(client); err != nil {
+		t.Fatal(err)
+	}
+	client.connectMedia()
+	offer := <-client.send
+	if offer.Type != "sfu-offer" || offer.Data["sdp"] != "offer-alice" {
+		t.Fatalf("Expected the SFU's offer, got %s %v", offer.Type, offer.Data)
+	}
+	client.handleMessage(&Message{Type: "sfu-answer", From: "alice", Data: map[string]interface{}{"sdp": "answer"}})
+	if media.answers["session-alice"] != "answer" {
+		t.Errorf("Expected the answer to reach the SFU, got %v", media.answers)
+	}
+
+	settings := room.Settings()
+	settings.DuplicatePolicy = DuplicateReplace
+	settings.Profile = ProfileStandard
+	if _, err := room.ReplaceSettings(0, settings); err != nil {
+		t.Fatalf("Expected settings in the same mode to be accepted, got %v", err)
+	}
+	settings.Mode = ModeMesh
+	if _, err := room.ReplaceSettings(0, settings); err == nil {
+		t.Error("Expected the room's mode to be fixed once it is created")
+	}
+
+	client.Close()
+	if len(media.closed) != 1 || media.closed[0] != "session-alice" {
+		t.Errorf("Expected the SFU session closed with the client, got %v", media.closed)
+	}
+}
+
+// fakeCallRecorder records which call recordings ran
+type fakeCallRecorder struct {
+	started, stopped []string
+}
+
+func (r *fakeCallRecorder) StartRecording(recording Recording) error {
+	r.started = append(r.started, recording.ID)
+	return nil
+}
+
+func (r *fakeCallRecorder) StopRecording(recording Recording) error {
+	r.stopped = append(r.stopped, recording.ID)
+	return nil
+}
+
+func TestCallRecording(t *testing.T) {
+	hub := NewHub()
+	store := memoryRecordingStore{}
+	hub.SetRecordingStore(store)
+	hub.SetMediaServer(&fakeMediaServer{offers: map[string]func(string){}, answers: map[string]string{}})
+	recorder := &fakeCallRecorder{}
+	hub.SetCallRecorder(recorder)
+
+	mesh := hub.GetRoom("mesh-room")
+	meshHost := &Client{ID: "host", Room: mesh, hub: hub, send: make(chan *Message, 20)}
+	mesh.AddClient(meshHost)
+	if _, err := mesh.StartCallRecording(meshHost); err == nil {
+		t.Error("Expected recording to require SFU mode")
+	}
+
+	room, err := hub.ImportRoom(RoomSnapshot{ID: "sfu-room", Settings: RoomSettings{Mode: ModeSFU}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := &Client{ID: "host", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	guest := &Client{ID: "guest", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	room.AddClient(host)
+	room.AddClient(guest)
+	room.settle()
+	drainTypes(guest)
+
+	if _, err := room.StartCallRecording(guest); err == nil {
+		t.Error("Expected a guest to be refused")
+	}
+	host.handleMessage(&Message{Type: "start-recording", From: host.ID})
+	room.settle()
+	if len(recorder.started) != 1 || store[recorder.started[0]].Status != RecordingActive {
+		t.Fatalf("Expected a running recording, got %v %v", recorder.started, store)
+	}
+	if types := drainTypes(guest); len(types) != 1 || types[0] != "recording-status" {
+		t.Errorf("Expected the room told about the recording, got %v", types)
+	}
+	if _, err := room.StartCallRecording(host); err == nil {
+		t.Error("Expected one recording at a time")
+	}
+
+	// Without an ID, stop-recording stops the running call recording
+	host.handleMessage(&Message{Type: "stop-recording", From: host.ID})
+	recordingID := recorder.started[0]
+	if len(recorder.stopped) != 1 || store[recordingID].Status != RecordingStopped {
+		t.Fatalf("Expected the recording stopped, got %v %+v", recorder.stopped, store[recordingID])
+	}
+
+	// The artifact may be stored after the room closed
+	hub.CloseRoom(room.ID, "done")
+	recording := hub.CompleteCallRecording(store[recordingID], Artifact{File: recordingID + ".zip", ContentType: "application/zip", Size: 42})
+	if recording.Status != RecordingAvailable || store[recordingID].File != recordingID+".zip" {
+		t.Errorf("Expected the recording ready, got %+v", store[recordingID])
+	}
+
+	// Recordings stop when the room empties
+	room, err = hub.ImportRoom(RoomSnapshot{ID: "sfu-room-2", Settings: RoomSettings{Mode: ModeSFU}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	host = &Client{ID: "host", Room: room, hub: hub, send: make(chan *Message, 20)}
+	room.AddClient(host)
+	if _, err := room.StartCallRecording(host); err != nil {
+		t.Fatal(err)
+	}
+	room.RemoveClient(host.ID)
+	if len(recorder.stopped) != 2 {
+		t.Errorf("Expected the recording of the empty room stopped, got %v", recorder.stopped)
+	}
+}
+
+func TestTraffic(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("traffic-room")
+	alice := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	bob := &Client{ID: "bob", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(alice)
+	room.AddClient(bob)
+	room.settle()
+	drainTypes(alice)
+	drainTypes(bob)
+	before := hub.Traffic()
+
+	room.Broadcast(&Message{Type: "chat", Data: map[string]interface{}{"text": "hi"}}, "")
+	room.settle()
+	traffic := hub.Traffic()
+	if relayed := traffic.Relayed["chat"] - before.Relayed["chat"]; relayed != 2 {
+		t.Errorf("Expected the chat relayed to both clients, got %d", relayed)
+	}
+	if traffic.FanOut.Count <= before.FanOut.Count || len(traffic.FanOut.Counts) != len(FanOutBuckets) {
+		t.Errorf("Expected the fan-out observed, got %+v", traffic.FanOut)
+	}
+
+	// Chat sheds load when a client's buffer is full
+	drainTypes(bob)
+	for i := 0; i <= cap(bob.send); i++ {
+		bob.Send(&Message{Type: "chat"})
+	}
+	if dropped := hub.Traffic().Dropped - before.Dropped; dropped != 1 {
+		t.Errorf("Expected a dropped message, got %d", dropped)
+	}
+
+	hub.CountWebSocketError(WebSocketUpgrade)
+	if errors := hub.Traffic().WebSocketErrors[WebSocketUpgrade]; errors != 1 {
+		t.Errorf("Expected an upgrade error, got %d", errors)
+	}
+}
+
+func TestKickAndBan(t *testing.T) {
+	hub := NewHub()
+	store := memoryRoomStore{}
+	hub.SetRoomStore(store)
+	room := hub.GetRoomWithSettings("moderated", func(settings *RoomSettings) { settings.Persistent = true })
+	host := &Client{ID: "host", Room: room, hub: hub, isHost: true, state: StateReady, send: make(chan *Message, 10)}
+	carol := &Client{ID: "carol", UserID: "carol@example.com", address: "198.51.100.7", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	dave := &Client{ID: "dave", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(host)
+	room.AddClient(carol)
+	room.AddClient(dave)
+	room.SetHost(host.ID)
+	room.settle()
+	drainTypes(carol)
+	drainTypes(dave)
+
+	if err := room.Moderate(dave, ModerationCommand{Command: CommandBan, ClientID: "carol"}); err == nil {
+		t.Error("Expected only the host to ban")
+	}
+
+	// A kicked client gets kicked before its connection closes and may rejoin
+	host.handleMessage(&Message{Type: CommandKick, From: host.ID, Data: map[string]interface{}{"clientId": "dave", "reason": "noise"}})
+	if msg := <-dave.send; msg.Type != "kicked" || msg.Data["banned"] != false || msg.Data["reason"] != "noise" || !msg.final {
+		t.Errorf("Expected dave to be told of the kick, got %+v", msg)
+	}
+	if room.client("dave") != nil || dave.closeCode != CloseKicked {
+		t.Errorf("Expected dave disconnected, got close code %d", dave.closeCode)
+	}
+	if _, err := room.Join(&Client{ID: "dave", Room: room, hub: hub, send: make(chan *Message, 10)}); err != nil {
+		t.Errorf("Expected a kicked client to rejoin, got %v", err)
+	}
+
+	// A banned client can't come back under its ID, user or address
+	room.settle()
+	drainTypes(carol)
+	host.handleMessage(&Message{Type: CommandBan, From: host.ID, Data: map[string]interface{}{"clientId": "carol"}})
+	if msg := <-carol.send; msg.Type != "kicked" || msg.Data["banned"] != true {
+		t.Errorf("Expected carol to be told of the ban, got %+v", msg)
+	}
+	if room.client("carol") != nil {
+		t.Error("Expected carol disconnected")
+	}
+	for _, client := range []*Client{
+		{ID: "carol"},
+		{ID: "carol-laptop", UserID: "carol@example.com"},
+		{ID: "someone", address: "198.51.100.7"},
+	} {
+		client.Room, client.hub, client.send = room, hub, make(chan *Message, 10)
+		if _, err := room.Join(client); err != ErrBanned {
+			t.Errorf("Expected %s refused, got %v", client.ID, err)
+		}
+	}
+
+	// Persistent rooms keep their bans across restarts
+	expected := Bans{Clients: []string{"carol"}, Users: []string{"carol@example.com"}, Addresses: []string{"198.51.100.7"}}
+	if bans := store["moderated"].Bans; !reflect.DeepEqual(bans, expected) {
+		t.Errorf("Expected the ban saved with the room, got %+v", bans)
+	}
+	restarted := NewHub()
+	restarted.SetRoomStore(store)
+	if n, err := restarted.RestoreRooms(); err != nil || n != 1 {
+		t.Fatalf("Expected the room restored, got %d and %v", n, err)
+	}
+	if check := restarted.CheckJoin("moderated", "carol-phone", "carol@example.com", "", nil); check.Reason != "banned" {
+		t.Errorf("Expected carol still banned after a restart, got %+v", check)
+	}
+}
+
+// memoryRoomStore keeps the latest snapshot of each persistent room
+type memoryRoomStore map[string]RoomSnapshot
+
+func (s memoryRoomStore) SaveRoom(snapshot RoomSnapshot) error {
+	s[snapshot.ID] = snapshot
+	return nil
+}
+
+func (s memoryRoomStore) DeleteRoom(roomID string) error {
+	delete(s, roomID)
+	return nil
+}
+
+func (s memoryRoomStore) LoadRooms() ([]RoomSnapshot, error) {
+	return slices.Collect(maps.Values(s)), nil
+}
+
+func TestLeaveReasons(t *testing.T) {
+	hub := NewHub()
+	var reasons []interface{}
+	hub.OnEvent(func(event Event) {
+		if event.Type == EventUserLeft {
+			reasons = append(reasons, event.Data["reason"])
+		}
+	})
+	room := hub.GetRoom("leaving")
+	host := &Client{ID: "host", Room: room, hub: hub, isHost: true, state: StateReady, send: make(chan *Message, 10)}
+	carol := &Client{ID: "carol", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	dave := &Client{ID: "dave", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(host)
+	room.AddClient(carol)
+	room.AddClient(dave)
+	room.SetHost(host.ID)
+	room.settle()
+	drainTypes(host)
+
+	userLeft := func(client *Client) *Message {
+		room.settle()
+		for len(client.send) > 0 {
+			if msg := <-client.send; msg.Type == "user-left" {
+				return msg
+			}
+		}
+		return nil
+	}
+
+	host.handleMessage(&Message{Type: CommandKick, From: host.ID, Data: map[string]interface{}{"clientId": "dave"}})
+	if msg := userLeft(host); msg == nil || msg.Data["reason"] != LeaveKicked {
+		t.Errorf("Expected dave to leave kicked, got %+v", msg)
+	}
+
+	// A connection that stops answering times out; clients without a
+	// resume token don't wait to resume
+	carol.connectionLost(nil, connectionLeaveReason(false, os.ErrDeadlineExceeded))
+	if msg := userLeft(host); msg == nil || msg.Data["reason"] != LeaveTimeout {
+		t.Errorf("Expected carol to leave timed out, got %+v", msg)
+	}
+
+	room.clientMutex.RLock()
+	participants := slices.Clone(room.meeting.Participants)
+	room.clientMutex.RUnlock()
+	for _, participant := range participants {
+		expected := map[string]string{"dave": LeaveKicked, "carol": LeaveTimeout}[participant.ClientID]
+		if participant.LeaveReason != expected {
+			t.Errorf("Expected %s's meeting record to leave %q, got %q", participant.ClientID, expected, participant.LeaveReason)
+		}
+	}
+	if !reflect.DeepEqual(reasons, []interface{}{LeaveKicked, LeaveTimeout}) {
+		t.Errorf("Expected user-left events with the reasons, got %v", reasons)
+	}
+
+	if reason := connectionLeaveReason(true, nil); reason != LeaveNormal {
+		t.Errorf("Expected a clean close to leave normally, got %q", reason)
+	}
+}
+
+func TestLiveState(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("live")
+	host := &Client{ID: "host", Room: room, hub: hub, isHost: true, state: StateReady, send: make(chan *Message, 20)}
+	carol := &Client{ID: "carol", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	dave := &Client{ID: "dave", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	room.AddClient(host)
+	room.AddClient(carol)
+	room.AddClient(dave)
+	room.SetHost(host.ID)
+	room.settle()
+	drainTypes(host)
+	drainTypes(carol)
+
+	carol.handleMessage(&Message{Type: "media-state", From: carol.ID, Data: map[string]interface{}{"audioMuted": true, "screenSharing": true}})
+	dave.handleMessage(&Message{Type: "raise-hand", From: dave.ID})
+	carol.handleMessage(&Message{Type: "raise-hand", From: carol.ID})
+	host.handleMessage(&Message{Type: "stop-video-user", From: host.ID, Data: map[string]interface{}{"clientId": "dave"}})
+	host.handleMessage(&Message{Type: "pin", From: host.ID, Data: map[string]interface{}{"clientId": "carol"}})
+	room.settle()
+	if types := drainTypes(carol); !slices.Equal(types, []string{"raise-hand", "stop-video-user", "pin"}) {
+		t.Errorf("Expected carol to follow the others' changes, got %v", types)
+	}
+
+	// Only the host lowers others' hands or pins
+	carol.handleMessage(&Message{Type: "lower-hand", From: carol.ID, Data: map[string]interface{}{"clientId": "dave"}})
+	carol.handleMessage(&Message{Type: "pin", From: carol.ID, Data: map[string]interface{}{"clientId": "carol"}})
+	room.settle()
+	for _, msgType := range []string{"lower-hand", "pin"} {
+		if msg := <-carol.send; msg.Type != "error" || msg.Data["code"] != ErrorNotPermitted || msg.Data["type"] != msgType {
+			t.Errorf("Expected carol's %s to be refused, got %+v", msgType, msg)
+		}
+	}
+
+	expected := LiveState{
+		HostID:        "host",
+		Muted:         []string{"carol"},
+		VideoOff:      []string{"dave"},
+		ScreenSharing: []string{"carol"},
+		Pinned:        "carol",
+	}
+	state := room.LiveState()
+	hands := state.RaisedHands
+	state.RaisedHands = nil
+	if !reflect.DeepEqual(state, expected) || len(hands) != 2 || hands[0].ClientID != "dave" || hands[1].ClientID != "carol" {
+		t.Errorf("Expected %+v with dave's and carol's hands raised, got %+v and %+v", expected, state, hands)
+	}
+	if msg := room.stateMessage("erin"); msg.Type != "room-state" || msg.To != "erin" || msg.Data["pinned"] != "carol" {
+		t.Errorf("Expected a room-state snapshot for erin, got %+v", msg)
+	}
+
+	// Leaving takes a participant's state along
+	carol.Close()
+	room.settle()
+	state = room.LiveState()
+	if state.Pinned != "" || len(state.ScreenSharing) != 0 || len(state.RaisedHands) != 1 {
+		t.Errorf("Expected carol's state gone, got %+v", state)
+	}
+}
+
+func TestHostMediaControls(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("controlled")
+	host := &Client{ID: "host", Room: room, hub: hub, isHost: true, state: StateReady, send: make(chan *Message, 10)}
+	carol := &Client{ID: "carol", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	dave := &Client{ID: "dave", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(host)
+	room.AddClient(carol)
+	room.AddClient(dave)
+	room.SetHost(host.ID)
+	room.settle()
+	drainTypes(host)
+	drainTypes(carol)
+	drainTypes(dave)
+
+	// Only the host may turn off others' media
+	dave.handleMessage(&Message{Type: "mute-user", From: dave.ID, Data: map[string]interface{}{"clientId": "carol"}})
+	room.settle()
+	if msg := <-dave.send; msg.Type != "error" || msg.Data["code"] != ErrorNotPermitted {
+		t.Errorf("Expected dave's mute-user to be refused, got %+v", msg)
+	}
+	if types := drainTypes(carol); len(types) != 0 {
+		t.Errorf("Expected carol to hear nothing, got %v", types)
+	}
+
+	host.handleMessage(&Message{Type: "stop-video-user", From: host.ID, Data: map[string]interface{}{"clientId": "carol"}})
+	room.settle()
+	if msg := <-carol.send; msg.Type != "stop-video-user" || !msg.IsHost || msg.Data["clientId"] != "carol" || msg.Data["kind"] != MediaVideo {
+		t.Errorf("Expected carol to be told to stop video with the host's authority, got %+v", msg)
+	}
+	host.handleMessage(&Message{Type: "mute-all", From: host.ID})
+	room.settle()
+	if msg := <-dave.send; msg.Type != "stop-video-user" {
+		t.Errorf("Expected dave to see carol's video stopped, got %+v", msg)
+	}
+	if msg := <-dave.send; msg.Type != "mute-all" || !msg.IsHost || !slices.Equal(msg.Data["clientIds"].([]string), []string{"carol", "dave"}) {
+		t.Errorf("Expected everyone but the host muted, got %+v", msg)
+	}
+	if control := room.MediaControlOf("carol"); !control.AudioMuted || !control.VideoStopped {
+		t.Errorf("Expected carol muted with video stopped, got %+v", control)
+	}
+
+	// Late joiners see who the host turned off in the roster
+	for _, participant := range room.Participants() {
+		device := participant.Devices[0]
+		if muted := device.ClientID == "carol" || device.ClientID == "dave"; device.AudioMuted != muted {
+			t.Errorf("Expected %s serverMuted=%v, got %+v", device.ClientID, muted, device)
+		}
+	}
+
+	// Turning the microphone back on lifts the mute, and leaving forgets it
+	drainTypes(host)
+	carol.handleMessage(&Message{Type: "unmute-self", From: carol.ID, Data: map[string]interface{}{"kind": MediaAudio}})
+	room.settle()
+	if msg := <-host.send; msg.Type != "unmute-self" || msg.From != "carol" {
+		t.Errorf("Expected the host to hear carol unmuted, got %+v", msg)
+	}
+	if control := room.MediaControlOf("carol"); control.AudioMuted || !control.VideoStopped {
+		t.Errorf("Expected only carol's video still stopped, got %+v", control)
+	}
+	room.RemoveClient("dave")
+	if control := room.MediaControlOf("dave"); control != (MediaControl{}) {
+		t.Errorf("Expected dave's mute forgotten once gone, got %+v", control)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("operated")
+	erin := &Client{ID: "erin", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 10)}
+	room.AddClient(erin)
+	room.settle()
+	drainTypes(erin)
+
+	if err := room.Disconnect("nobody", "spam"); err != ErrRecipientNotFound {
+		t.Errorf("Expected ErrRecipientNotFound for a missing client, got %v", err)
+	}
+	if err := room.Disconnect("erin", "spam"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-erin.send; msg.Type != "kicked" || msg.Data["by"] != operatorID || msg.Data["reason"] != "spam" {
+		t.Errorf("Expected erin to be told of the removal, got %+v", msg)
+	}
+	if room.client("erin") != nil || erin.closeCode != CloseKicked {
+		t.Errorf("Expected erin disconnected, got close code %d", erin.closeCode)
+	}
+}
+
+func TestWaitingRoom(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("lobby")
+	room.UpdateSettings(func(settings *RoomSettings) { settings.WaitingRoom = true })
+	host := &Client{ID: "host", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	bob := &Client{ID: "bob", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	room.AddClient(host)
+	room.AddClient(bob)
+	room.SetHost(host.ID)
+	room.settle()
+	drainTypes(host)
+	drainTypes(bob)
+
+	// New participants wait, and the host is asked about them
+	guest := &Client{ID: "guest", Room: room, hub: hub, send: make(chan *Message, 20)}
+	if _, err := room.Join(guest); err != ErrWaitingRoom {
+		t.Fatalf("Expected the guest to wait, got %v", err)
+	}
+	if msg := <-guest.send; msg.Type != "waiting-room" {
+		t.Errorf("Expected waiting-room, got %+v", msg)
+	}
+	if msg := <-host.send; msg.Type != "admission-request" || msg.Data["clientId"] != "guest" {
+		t.Errorf("Expected an admission request for the guest, got %+v", msg)
+	}
+
+	// Waiting clients can't talk to the room; their join waits too
+	guest.handleMessage(&Message{Type: "chat", From: guest.ID, Data: map[string]interface{}{"text": "hi"}})
+	guest.handleMessage(&Message{Type: "join", From: guest.ID})
+	if msg := <-guest.send; msg.Type != "error" || msg.Data["code"] != ErrorNotPermitted {
+		t.Errorf("Expected chat from the waiting room to be refused, got %+v", msg)
+	}
+	room.settle()
+	if types := drainTypes(bob); len(types) != 0 {
+		t.Errorf("Expected bob to hear nothing from the waiting room, got %v", types)
+	}
+
+	if err := room.Admit(bob, "guest"); err == nil {
+		t.Error("Expected only the host to admit")
+	}
+	host.handleMessage(&Message{Type: "admit", From: host.ID, Data: map[string]interface{}{"clientId": "guest"}})
+	room.settle()
+	if room.client("guest") == nil || guest.Waiting() || guest.State() != StateReady {
+		t.Errorf("Expected the guest admitted and ready, got state %v", guest.State())
+	}
+	if types := drainTypes(guest); !slices.Contains(types, "welcome") {
+		t.Errorf("Expected the guest to be welcomed once admitted, got %v", types)
+	}
+	if types := drainTypes(bob); !slices.Contains(types, "user-joined") {
+		t.Errorf("Expected bob to learn the guest joined, got %v", types)
+	}
+
+	// A denied client is told why and disconnected
+	eve := &Client{ID: "eve", Room: room, hub: hub, send: make(chan *Message, 20)}
+	room.Join(eve)
+	drainTypes(eve)
+	drainTypes(host)
+	host.handleMessage(&Message{Type: "deny", From: host.ID, Data: map[string]interface{}{"clientId": "eve", "reason": "invite only"}})
+	if msg := <-eve.send; msg.Type != "join-denied" || msg.Data["message"] != "invite only" || !msg.final {
+		t.Errorf("Expected eve to be denied, got %+v", msg)
+	}
+	if eve.closeCode != CloseJoinDenied || len(room.Waiting()) != 0 {
+		t.Errorf("Expected eve disconnected, got close code %d", eve.closeCode)
+	}
+	host.handleMessage(&Message{Type: "admit", From: host.ID, Data: map[string]interface{}{"clientId": "eve"}})
+	if msg := <-host.send; msg.Type != "error" || msg.Data["code"] != ErrorNotFound {
+		t.Errorf("Expected admitting a client that isn't waiting to fail, got %+v", msg)
+	}
+
+	// The host hears when someone stops waiting
+	frank := &Client{ID: "frank", Room: room, hub: hub, send: make(chan *Message, 20)}
+	room.Join(frank)
+	drainTypes(host)
+	frank.Close()
+	if msg := <-host.send; msg.Type != "admission-cancelled" || msg.Data["clientId"] != "frank" {
+		t.Errorf("Expected admission-cancelled for frank, got %+v", msg)
+	}
+	room.settle()
+	if types := drainTypes(bob); slices.Contains(types, "user-left") {
+		t.Errorf("Expected no user-left for a client that never joined, got %v", types)
+	}
+}
+
+func TestCustomEvents(t *testing.T) {
+	hub := NewHub()
+	room := hub.GetRoom("app")
+	settings := DefaultRoomSettings()
+	settings.CustomEvents = []CustomEventRule{{Namespace: "poll", Recipients: "everyone"}}
+	if _, err := room.ReplaceSettings(0, settings); err == nil {
+		t.Error("Expected unknown recipients to be refused")
+	}
+	settings.CustomEvents = []CustomEventRule{
+		{Namespace: "whiteboard", History: true},
+		{Namespace: "poll", Sender: RoleHost},
+		{Namespace: "notes", Recipients: RecipientsHost, History: true},
+	}
+	if _, err := room.ReplaceSettings(0, settings); err != nil {
+		t.Fatalf("ReplaceSettings failed: %v", err)
+	}
+	host := &Client{ID: "host", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	alice := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	viewer := &Client{ID: "viewer", Room: room, hub: hub, maxRole: RoleViewer, state: StateReady, send: make(chan *Message, 20)}
+	room.AddClient(host)
+	room.AddClient(alice)
+	room.AddClient(viewer)
+	room.SetHost(host.ID)
+	room.settle()
+	for _, client := range []*Client{host, alice, viewer} {
+		drainTypes(client)
+	}
+
+	// Namespaces without a rule go from participants to the room
+	alice.handleMessage(&Message{Type: "custom:cursor:move", From: alice.ID, Data: map[string]interface{}{"x": 1.0}})
+	viewer.handleMessage(&Message{Type: "custom:cursor:move", From: viewer.ID})
+	room.settle()
+	if types := drainTypes(host); !slices.Equal(types, []string{"custom:cursor:move"}) {
+		t.Errorf("Expected the host to get alice's cursor only, got %v", types)
+	}
+	if msg := <-viewer.send; msg.Type != "error" || msg.Data["code"] != ErrorNotPermitted {
+		t.Errorf("Expected viewers not to send custom events by default, got %+v", msg)
+	}
+	drainTypes(viewer)
+	drainTypes(alice)
+
+	// Rules limit senders and recipients
+	alice.handleMessage(&Message{Type: "custom:poll:open", From: alice.ID})
+	if msg := <-alice.send; msg.Type != "error" || msg.Data["code"] != ErrorNotPermitted {
+		t.Errorf("Expected only the host to open polls, got %+v", msg)
+	}
+	host.handleMessage(&Message{Type: "custom:poll:open", From: host.ID})
+	alice.handleMessage(&Message{Type: "custom:notes:add", From: alice.ID, Data: map[string]interface{}{"note": "follow up"}})
+	alice.handleMessage(&Message{Type: "custom:whiteboard:stroke", From: alice.ID, Data: map[string]interface{}{"path": "M0 0"}})
+	alice.handleMessage(&Message{Type: "custom:whiteboard", From: alice.ID})
+	room.settle()
+	if types := drainTypes(viewer); !slices.Equal(types, []string{"custom:poll:open", "custom:whiteboard:stroke"}) {
+		t.Errorf("Expected the viewer to get the poll and stroke but not the note, got %v", types)
+	}
+	if types := drainTypes(host); !slices.Contains(types, "custom:notes:add") {
+		t.Errorf("Expected the host to get the note, got %v", types)
+	}
+	if types := drainTypes(alice); !slices.Contains(types, "custom:poll:open") || !slices.Contains(types, "error") {
+		t.Errorf("Expected alice to get the poll and an error for the event without a name, got %v", types)
+	}
+
+	// Latecomers get the stored events they may see
+	bob := &Client{ID: "bob", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	room.AddClient(bob)
+	bob.announceJoin()
+	var history *Message
+	for len(bob.send) > 0 {
+		if msg := <-bob.send; msg.Type == "custom-history" {
+			history = msg
+		}
+	}
+	if history == nil {
+		t.Fatal("Expected bob to get the custom event history")
+	}
+	events := history.Data["events"].([]map[string]interface{})
+	if len(events) != 1 || events[0]["type"] != "custom:whiteboard:stroke" || events[0]["from"] != "alice" {
+		t.Errorf("Expected only the stored stroke, got %v", events)
+	}
+}
+
+func TestChatHistory(t *testing.T) {
+	hub := NewHub()
+	hub.SetChatStore(NewMemoryChatStore(3))
+	room := hub.GetRoom("history")
+	alice := &Client{ID: "alice", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	room.AddClient(alice)
+
+	for _, text := range []string{"one", "two", "three", "four"} {
+		alice.handleMessage(&Message{Type: "chat", From: alice.ID, Data: map[string]interface{}{"text": text}})
+	}
+	alice.handleMessage(&Message{Type: "chat", From: alice.ID, To: "carol", Data: map[string]interface{}{"text": "private"}})
+
+	// The store keeps the latest messages, and private ones aren't kept
+	messages, err := hub.ChatHistory("history", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || messages[0].Data["text"] != "two" || messages[2].Seq != 4 || messages[2].From != "alice" {
+		t.Fatalf("Expected the last three messages, got %+v", messages)
+	}
+	if page, _ := hub.ChatHistory("history", 4, 1); len(page) != 1 || page[0].Data["text"] != "three" {
+		t.Errorf("Expected the message before the fourth, got %+v", page)
+	}
+
+	// Latecomers get the conversation so far
+	bob := &Client{ID: "bob", Room: room, hub: hub, state: StateReady, send: make(chan *Message, 20)}
+	room.AddClient(bob)
+	bob.announceJoin()
+	var history *Message
+	for len(bob.send) > 0 {
+		if msg := <-bob.send; msg.Type == "chat-history" {
+			history = msg
+		}
+	}
+	if history == nil {
+		t.Fatal("Expected bob to get the chat history")
+	}
+	if got := history.Data["messages"].([]ChatMessage); len(got) != 3 || got[2].Data["text"] != "four" {
+		t.Errorf("Expected the stored messages, got %+v", got)
+	}
+
+	hub.SetChatStore(nil)
+	if messages, err := hub.ChatHistory("history", 0, 10); err != nil || messages != nil {
+		t.Errorf("Expected no history without a store, got %v, %v", messages, err)
+	}
+}
@@ -0,0 +1,190 @@
+package signaling
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// MediaState is what a participant reports of its own media, so the room
+// can tell late joiners
+type MediaState struct {
+	AudioMuted    bool `json:"audioMuted"`
+	VideoOff      bool `json:"videoOff"`
+	ScreenSharing bool `json:"screenSharing"`
+}
+
+// RaisedHand is a participant waiting to speak, since when
+type RaisedHand struct {
+	ClientID string    `json:"clientId"`
+	At       time.Time `json:"at"`
+}
+
+// LiveState is the state of a call a participant gets in room-state on
+// joining, instead of inferring it from the events it missed. Muted and
+// VideoOff include what the host turned off
+type LiveState struct {
+	HostID        string       `json:"hostId,omitempty"`
+	Muted         []string     `json:"muted"`
+	VideoOff      []string     `json:"videoOff"`
+	ScreenSharing []string     `json:"screenSharing"`
+	RaisedHands   []RaisedHand `json:"raisedHands"`
+	Pinned        string       `json:"pinned,omitempty"`
+}
+
+// LiveState returns the room's current state; raised hands are in the order
+// they were raised
+func (r *Room) LiveState() LiveState {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+
+	state := LiveState{
+		HostID:        r.hostID,
+		Muted:         []string{},
+		VideoOff:      []string{},
+		ScreenSharing: []string{},
+		RaisedHands:   slices.Clone(r.hands),
+		Pinned:        r.pinned,
+	}
+	if state.RaisedHands == nil {
+		state.RaisedHands = []RaisedHand{}
+	}
+	for clientID := range r.clients {
+		media, control := r.mediaStates[clientID], r.controlled[clientID]
+		if media.AudioMuted || control.AudioMuted {
+			state.Muted = append(state.Muted, clientID)
+		}
+		if media.VideoOff || control.VideoStopped {
+			state.VideoOff = append(state.VideoOff, clientID)
+		}
+		if media.ScreenSharing {
+			state.ScreenSharing = append(state.ScreenSharing, clientID)
+		}
+	}
+	sort.Strings(state.Muted)
+	sort.Strings(state.VideoOff)
+	sort.Strings(state.ScreenSharing)
+	return state
+}
+
+// stateMessage builds the room-state snapshot sent to a client that joins
+func (r *Room) stateMessage(to string) *Message {
+	state := r.LiveState()
+	return &Message{
+		Type: "room-state",
+		To:   to,
+		Data: map[string]interface{}{
+			"hostId":        state.HostID,
+			"muted":         state.Muted,
+			"videoOff":      state.VideoOff,
+			"screenSharing": state.ScreenSharing,
+			"raisedHands":   state.RaisedHands,
+			"pinned":        state.Pinned,
+		},
+	}
+}
+
+// SetMediaState records what a participant reports of its media and tells
+// the other participants with media-state
+func (r *Room) SetMediaState(client *Client, state MediaState) {
+	r.clientMutex.Lock()
+	if r.mediaStates == nil {
+		r.mediaStates = make(map[string]MediaState)
+	}
+	if state == (MediaState{}) {
+		delete(r.mediaStates, client.ID)
+	} else {
+		r.mediaStates[client.ID] = state
+	}
+	r.clientMutex.Unlock()
+
+	r.Broadcast(&Message{
+		Type: "media-state",
+		From: client.ID,
+		Data: map[string]interface{}{
+			"clientId":      client.ID,
+			"audioMuted":    state.AudioMuted,
+			"videoOff":      state.VideoOff,
+			"screenSharing": state.ScreenSharing,
+		},
+	}, client.ID)
+}
+
+// RaiseHand raises a participant's hand, telling the others with
+// raise-hand. Raising it again keeps its place
+func (r *Room) RaiseHand(client *Client) {
+	r.clientMutex.Lock()
+	if slices.ContainsFunc(r.hands, func(hand RaisedHand) bool { return hand.ClientID == client.ID }) {
+		r.clientMutex.Unlock()
+		return
+	}
+	r.hands = append(r.hands, RaisedHand{ClientID: client.ID, At: time.Now()})
+	r.clientMutex.Unlock()
+
+	r.Broadcast(&Message{
+		Type: "raise-hand",
+		From: client.ID,
+		Data: map[string]interface{}{"clientId": client.ID},
+	}, "")
+}
+
+// LowerHand lowers a raised hand, telling the others with lower-hand.
+// Participants lower their own; the host may lower anyone's
+func (r *Room) LowerHand(client *Client, clientID string) error {
+	if clientID == "" {
+		clientID = client.ID
+	}
+	r.clientMutex.Lock()
+	if clientID != client.ID && client.ID != r.hostID {
+		r.clientMutex.Unlock()
+		return fmt.Errorf("lower-hand: %w", ErrRoleDenied)
+	}
+	raised := r.lowerHandLocked(clientID)
+	r.clientMutex.Unlock()
+
+	if raised {
+		r.Broadcast(&Message{
+			Type: "lower-hand",
+			From: client.ID,
+			Data: map[string]interface{}{"clientId": clientID, "by": client.ID},
+		}, "")
+	}
+	return nil
+}
+
+// lowerHandLocked lowers a client's hand and reports whether it was
+// raised; the caller must hold clientMutex
+func (r *Room) lowerHandLocked(clientID string) bool {
+	before := len(r.hands)
+	r.hands = slices.DeleteFunc(r.hands, func(hand RaisedHand) bool { return hand.ClientID == clientID })
+	return len(r.hands) != before
+}
+
+// Pin makes the host's choice of participant the one everyone's layout
+// features, or clears it when clientID is empty. The others get pin with
+// isHost set
+func (r *Room) Pin(host *Client, clientID string) error {
+	r.clientMutex.Lock()
+	if host.ID != r.hostID {
+		r.clientMutex.Unlock()
+		return fmt.Errorf("pin: %w", ErrRoleDenied)
+	}
+	if _, exists := r.clients[clientID]; clientID != "" && !exists {
+		r.clientMutex.Unlock()
+		return ErrRecipientNotFound
+	}
+	r.pinned = clientID
+	r.clientMutex.Unlock()
+
+	util.Info("Host %s pinned %q in room %s", host.ID, clientID, r.ID)
+	r.Broadcast(&Message{
+		Type:   "pin",
+		From:   host.ID,
+		IsHost: true,
+		Data:   map[string]interface{}{"clientId": clientID, "by": host.ID},
+	}, "")
+	return nil
+}
@@ -0,0 +1,94 @@
+package signaling
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SchemaError is a message whose Data lacks a field its type needs, or holds
+// it in the wrong shape. The error sent back names the field
+type SchemaError struct {
+	Type    string
+	Field   string
+	Problem string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: data.%s %s", e.Type, e.Field, e.Problem)
+}
+
+// fieldRule checks one field of a message's Data, returning what is wrong
+// with its value or "" when it's fine
+type fieldRule struct {
+	field string
+	check func(value interface{}) string
+}
+
+// messageSchemas are the fields the server checks before relaying a message,
+// by type, so peers aren't sent garbage they would fail on. Other types are
+// checked by their handlers, if at all
+var messageSchemas = map[string][]fieldRule{
+	"offer":         {{"sdp", sessionDescription}},
+	"answer":        {{"sdp", sessionDescription}},
+	"ice-candidate": {{"candidate", iceCandidate}},
+	"chat":          {{"text", chatText}},
+}
+
+// validateMessage checks a message from a client against the schema of its
+// type
+func validateMessage(msg *Message) error {
+	for _, rule := range messageSchemas[msg.Type] {
+		problem := "is missing"
+		if value := msg.Data[rule.field]; value != nil {
+			problem = rule.check(value)
+		}
+		if problem != "" {
+			return &SchemaError{Type: msg.Type, Field: rule.field, Problem: problem}
+		}
+	}
+	return nil
+}
+
+// sessionDescription accepts an SDP string, or a description object such as
+// a browser's localDescription
+func sessionDescription(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		if value != "" {
+			return ""
+		}
+	case map[string]interface{}:
+		if sdp, _ := value["sdp"].(string); sdp != "" {
+			return ""
+		}
+	}
+	return "must be a session description"
+}
+
+// iceCandidate accepts a candidate string, or a candidate object. An object
+// with an empty candidate marks the end of candidates
+func iceCandidate(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return ""
+	case map[string]interface{}:
+		if _, ok := value["candidate"].(string); ok {
+			return ""
+		}
+	}
+	return "must be an ICE candidate"
+}
+
+// chatText accepts text that isn't blank and fits the chat size limit
+func chatText(value interface{}) string {
+	text, ok := value.(string)
+	switch {
+	case !ok:
+		return "must be a string"
+	case strings.TrimSpace(text) == "":
+		return "is empty"
+	case int64(len(text)) > sizeLimit("chat"):
+		return fmt.Sprintf("is longer than %d bytes", sizeLimit("chat"))
+	}
+	return ""
+}
@@ -0,0 +1,251 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// DuplicateJoinPolicy controls what happens when a client ID that is already
+// present in a room connects again
+type DuplicateJoinPolicy string
+
+const (
+	// DuplicateReplace closes the old connection and lets the new one take its place
+	DuplicateReplace DuplicateJoinPolicy = "replace"
+
+	// DuplicateMultiDevice keeps both connections, suffixing the new client ID per device
+	DuplicateMultiDevice DuplicateJoinPolicy = "multi-device"
+
+	// DuplicateReject refuses the new connection
+	DuplicateReject DuplicateJoinPolicy = "reject"
+)
+
+// ParseDuplicateJoinPolicy converts a string into a DuplicateJoinPolicy
+func ParseDuplicateJoinPolicy(value string) (DuplicateJoinPolicy, error) {
+	switch policy := DuplicateJoinPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case DuplicateReplace, DuplicateMultiDevice, DuplicateReject:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid duplicate join policy: %q", value)
+	}
+}
+
+// RoomProfile selects a set of signaling and media parameters for a room
+type RoomProfile string
+
+const (
+	// ProfileStandard is the default profile
+	ProfileStandard RoomProfile = "standard"
+
+	// ProfileLowPower trades responsiveness for battery and CPU on long mobile calls
+	ProfileLowPower RoomProfile = "low-power"
+)
+
+// ParseRoomProfile converts a string into a RoomProfile
+func ParseRoomProfile(value string) (RoomProfile, error) {
+	switch profile := RoomProfile(strings.ToLower(strings.TrimSpace(value))); profile {
+	case ProfileStandard, ProfileLowPower:
+		return profile, nil
+	default:
+		return "", fmt.Errorf("invalid room profile: %q", value)
+	}
+}
+
+// RoomMode selects how media flows between the participants of a room
+type RoomMode string
+
+const (
+	// ModeMesh connects every pair of participants directly; the server
+	// only relays their signaling
+	ModeMesh RoomMode = "mesh"
+
+	// ModeSFU connects each participant to the server's SFU, which forwards
+	// media between them
+	ModeSFU RoomMode = "sfu"
+)
+
+// ParseRoomMode converts a string into a RoomMode; empty means mesh
+func ParseRoomMode(value string) (RoomMode, error) {
+	switch mode := RoomMode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case "", ModeMesh:
+		return ModeMesh, nil
+	case ModeSFU:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid room mode: %q", value)
+	}
+}
+
+// profileParams holds the tunables a profile controls
+type profileParams struct {
+	// Keepalive timing for client connections
+	pongWait   time.Duration
+	pingPeriod time.Duration
+
+	// How long non-critical broadcasts are held back and sent as one digest; zero disables batching
+	digestInterval time.Duration
+
+	// Media constraints suggested to clients; zero means no limit
+	maxFrameRate int
+	maxBitrate   int
+}
+
+// params returns the tunables for a profile
+func (p RoomProfile) params() profileParams {
+	switch p {
+	case ProfileLowPower:
+		return profileParams{
+			pongWait:       3 * time.Minute,
+			pingPeriod:     (3 * time.Minute * 9) / 10,
+			digestInterval: 10 * time.Second,
+			maxFrameRate:   15,
+			maxBitrate:     300000,
+		}
+	default:
+		return profileParams{
+			pongWait:   pongWait,
+			pingPeriod: pingPeriod,
+		}
+	}
+}
+
+// MediaConstraints returns the media limits clients in this profile should apply
+func (p RoomProfile) MediaConstraints() map[string]interface{} {
+	params := p.params()
+	constraints := map[string]interface{}{}
+	if params.maxFrameRate > 0 {
+		constraints["maxFrameRate"] = params.maxFrameRate
+	}
+	if params.maxBitrate > 0 {
+		constraints["maxBitrate"] = params.maxBitrate
+	}
+	return constraints
+}
+
+// RoomSettings holds the configurable behaviour of a room
+type RoomSettings struct {
+	// How to handle a client ID joining twice
+	DuplicatePolicy DuplicateJoinPolicy `json:"duplicatePolicy"`
+
+	// Signaling and media profile
+	Profile RoomProfile `json:"profile"`
+
+	// Persistent rooms are saved to the room store and survive restarts
+	Persistent bool `json:"persistent"`
+
+	// Customer or organization the room belongs to, used to group rooms in
+	// metrics and integrations; empty for single-tenant deployments
+	Tenant string `json:"tenant,omitempty"`
+
+	// Traced rooms record every signaling message to a trace file for offline replay
+	Trace bool `json:"trace,omitempty"`
+
+	// Rooms with transcription accept captions and keep a transcript
+	Transcription bool `json:"transcription,omitempty"`
+
+	// Languages captions are machine-translated into, besides the original
+	CaptionLanguages []string `json:"captionLanguages,omitempty"`
+
+	// Words or phrases the host is alerted about when they appear in final captions
+	Keywords []string `json:"keywords,omitempty"`
+
+	// Confidential rooms overlay each viewer's identity on the video they see
+	Watermark *Watermark `json:"watermark,omitempty"`
+
+	// Event rooms spill latecomers over into linked overflow rooms once full
+	Overflow *OverflowSettings `json:"overflow,omitempty"`
+
+	// Most clients connected at once; 0 for no limit
+	MaxParticipants int `json:"maxParticipants,omitempty"`
+
+	// Rooms with a waiting room hold new participants until the host admits them
+	WaitingRoom bool `json:"waitingRoom,omitempty"`
+
+	// Who may send and receive custom:<namespace>:<event> messages, and
+	// which are stored, by namespace
+	CustomEvents []CustomEventRule `json:"customEvents,omitempty"`
+
+	// How media flows between participants; empty means mesh
+	Mode RoomMode `json:"mode,omitempty"`
+
+	// Most each user may be in this room, by user ID, or client ID for
+	// guests; on top of what their token allows
+	Roles map[string]Role `json:"roles,omitempty"`
+}
+
+// MediaMode returns how media flows between the room's participants
+func (s RoomSettings) MediaMode() RoomMode {
+	if s.Mode == "" {
+		return ModeMesh
+	}
+	return s.Mode
+}
+
+// DefaultRoomSettings returns the settings used for rooms when nothing else is configured
+func DefaultRoomSettings() RoomSettings {
+	return RoomSettings{
+		DuplicatePolicy: DuplicateReplace,
+		Profile:         ProfileStandard,
+	}
+}
+
+// ReplaceSettings replaces the room's settings if they are still at the given
+// version, or unconditionally when version is 0, and returns the new version.
+// Tracing and the room's mode can only be chosen when a room is created
+func (r *Room) ReplaceSettings(version int64, settings RoomSettings) (int64, error) {
+	var err error
+	if settings.DuplicatePolicy, err = ParseDuplicateJoinPolicy(string(settings.DuplicatePolicy)); err != nil {
+		return 0, err
+	}
+	if settings.Profile, err = ParseRoomProfile(string(settings.Profile)); err != nil {
+		return 0, err
+	}
+	if settings.Mode, err = ParseRoomMode(string(settings.Mode)); err != nil {
+		return 0, err
+	}
+	if err := settings.Overflow.validate(); err != nil {
+		return 0, err
+	}
+	if settings.MaxParticipants < 0 {
+		return 0, errMaxParticipants
+	}
+	if err := settings.Watermark.normalize(); err != nil {
+		return 0, err
+	}
+	if err := validateRoles(settings.Roles); err != nil {
+		return 0, err
+	}
+	if err := validateCustomEventRules(settings.CustomEvents); err != nil {
+		return 0, err
+	}
+	settings.Keywords = ParseKeywords(settings.Keywords)
+	if len(settings.Keywords) > maxKeywords {
+		return 0, errors.New("too many keywords")
+	}
+
+	var watermarkChanged bool
+	version, err = r.UpdateSettingsIf(version, func(current *RoomSettings) error {
+		if settings.Trace != current.Trace {
+			return errors.New("tracing can only be set when the room is created")
+		}
+		if settings.MediaMode() != current.MediaMode() {
+			return errors.New("the room's mode can only be set when the room is created")
+		}
+		watermarkChanged = !reflect.DeepEqual(settings.Watermark, current.Watermark)
+		*current = settings
+		return nil
+	})
+	if err != nil {
+		return version, err
+	}
+	if watermarkChanged {
+		r.sendWatermarks()
+	}
+	util.Info("Settings of room %s replaced (version %d)", r.ID, version)
+	return version, nil
+}
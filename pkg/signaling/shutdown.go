@@ -0,0 +1,89 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// ErrShuttingDown is returned to clients joining while the server drains
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// How often Shutdown checks whether every client has left
+const drainPollInterval = 100 * time.Millisecond
+
+// Shutdown drains the hub before the server stops. Every client gets a
+// server-shutdown message and has the grace period to reconnect elsewhere;
+// those still connected when it ends, or when ctx is done, are disconnected
+// with CloseShutdown. Rooms close as their last client leaves, and
+// persistent rooms stay in their store. New joins are refused from the
+// start. It returns how many clients had to be disconnected
+func (h *Hub) Shutdown(ctx context.Context, grace time.Duration) int {
+	h.draining.Store(true)
+
+	clients := h.connectedClients()
+	util.Info("Draining %d clients, grace period %v", len(clients), grace)
+	deadline := time.Now().Add(grace)
+	for _, client := range clients {
+		client.Send(&Message{
+			Type: "server-shutdown",
+			To:   client.ID,
+			Data: map[string]interface{}{
+				"gracePeriod": grace.Seconds(),
+				"deadline":    deadline.UnixMilli(),
+			},
+		})
+	}
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+drain:
+	for len(h.connectedClients()) > 0 {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			break drain
+		case <-ctx.Done():
+			break drain
+		}
+	}
+
+	remaining := h.connectedClients()
+	for _, client := range remaining {
+		client.CloseWithReason(CloseShutdown, "server shutdown")
+	}
+	util.Info("Drain finished, disconnected %d clients", len(remaining))
+	return len(remaining)
+}
+
+// Draining reports whether the hub is shutting down
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
+
+// connectedClients returns the clients of every room, including those in
+// waiting rooms
+func (h *Hub) connectedClients() []*Client {
+	h.roomsMutex.RLock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.roomsMutex.RUnlock()
+
+	seen := make(map[*Client]bool)
+	var clients []*Client
+	for _, room := range rooms {
+		for _, client := range append(room.GetClients(), room.Waiting()...) {
+			if !seen[client] {
+				seen[client] = true
+				clients = append(clients, client)
+			}
+		}
+	}
+	return clients
+}
@@ -0,0 +1,204 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Sidebar is a private call the host holds with one participant in a
+// temporary room linked to the main room. Both stay in the main room's
+// roster and chat, but negotiate media only with each other until it ends
+type Sidebar struct {
+	RoomID     string `json:"sidebarRoomId"`
+	MainRoomID string `json:"roomId"`
+	HostID     string `json:"hostId"`
+	ClientID   string `json:"clientId"`
+}
+
+// SidebarOf returns the main room of a sidebar room, or ""
+func (r *Room) SidebarOf() string {
+	r.clientMutex.RLock()
+	defer r.clientMutex.RUnlock()
+	return r.sidebarOf
+}
+
+// isPeerSignal reports whether a message type negotiates a peer connection
+func isPeerSignal(msgType string) bool {
+	switch msgType {
+	case "offer", "answer", "ice-candidate":
+		return true
+	default:
+		return false
+	}
+}
+
+// StartSidebar pulls a participant aside into a private call with the host.
+// The server creates a sidebar room, moves the pair's peer signaling into it
+// and tells the main room they are aside
+func (r *Room) StartSidebar(host *Client, clientID string) (Sidebar, error) {
+	if r.hub == nil {
+		return Sidebar{}, errors.New("room is not registered with a hub")
+	}
+	if r.GetHost() != host.ID {
+		return Sidebar{}, errors.New("only the host can start a sidebar")
+	}
+	target := r.client(clientID)
+	if target == nil || target == host {
+		return Sidebar{}, fmt.Errorf("client %s is not another participant of room %s", clientID, r.ID)
+	}
+	if host.asideRoom() != nil || target.asideRoom() != nil {
+		return Sidebar{}, errors.New("already in a sidebar")
+	}
+
+	sidebar := r.hub.createSidebar(r, host, target)
+	info := Sidebar{RoomID: sidebar.ID, MainRoomID: r.ID, HostID: host.ID, ClientID: target.ID}
+	util.Info("Host %s pulled %s aside into %s", host.ID, target.ID, sidebar.ID)
+
+	started := map[string]interface{}{
+		"sidebarRoomId": info.RoomID,
+		"roomId":        info.MainRoomID,
+		"hostId":        info.HostID,
+		"clientId":      info.ClientID,
+	}
+	r.logModeration(ModerationStartSidebar, host.ID, target.ID, "")
+	host.Send(&Message{Type: "sidebar-started", To: host.ID, Data: started})
+	target.Send(&Message{Type: "sidebar-started", To: target.ID, Data: started})
+	r.broadcastAside([]string{host.ID, target.ID}, true)
+	return info, nil
+}
+
+// EndSidebar brings both participants of the sender's sidebar back into the
+// main room. Either of them may end it
+func (c *Client) EndSidebar() error {
+	sidebar := c.asideRoom()
+	if sidebar == nil {
+		return errors.New("not in a sidebar")
+	}
+	if main := c.hub.FindRoom(sidebar.sidebarOf); main != nil {
+		main.logModeration(ModerationEndSidebar, c.ID, "", sidebar.ID)
+	}
+	c.hub.endSidebar(sidebar)
+	return nil
+}
+
+// createSidebar registers a sidebar room holding the host and the
+// participant and sets both aside from the main room
+func (h *Hub) createSidebar(main *Room, host, target *Client) *Room {
+	h.roomsMutex.Lock()
+	var roomID string
+	for n := 1; ; n++ {
+		roomID = fmt.Sprintf("%s-sidebar-%d", main.ID, n)
+		if _, taken := h.rooms[roomID]; !taken {
+			break
+		}
+	}
+	settings := main.Settings()
+	settings.Persistent = false
+	settings.Overflow = nil
+	settings.Trace = false
+	settings.Mode = ModeMesh // The pair keeps its SFU sessions in the main room
+	sidebar := h.createRoomLocked(roomID, settings)
+	sidebar.sidebarOf = main.ID
+	sidebar.eventLog = nil // Logged as moderation of the main room
+	sidebar.backplane = nil
+
+	// The pair is only registered for routing; host status, publishing
+	// devices and meeting records stay with the main room
+	sidebar.clientMutex.Lock()
+	sidebar.hostID = host.ID
+	for _, client := range []*Client{host, target} {
+		sidebar.clients[client.ID] = client
+		client.setAside(sidebar)
+	}
+	sidebar.transitionLocked(RoomActive)
+	sidebar.clientMutex.Unlock()
+	h.roomsMutex.Unlock()
+
+	sidebar.flushChanges()
+	h.emit(Event{Type: EventRoomCreated, RoomID: roomID})
+	return sidebar
+}
+
+// endSidebar returns the participants of a sidebar to the main room, which
+// tells everyone they are back, and removes the sidebar room
+func (h *Hub) endSidebar(sidebar *Room) {
+	sidebar.clientMutex.Lock()
+	clients := make([]*Client, 0, len(sidebar.clients))
+	for id, client := range sidebar.clients {
+		clients = append(clients, client)
+		delete(sidebar.clients, id)
+	}
+	mainID := sidebar.sidebarOf
+	if len(clients) > 0 {
+		sidebar.transitionLocked(RoomEnding)
+	}
+	sidebar.clientMutex.Unlock()
+	sidebar.flushChanges()
+	h.RemoveRoom(sidebar.ID)
+
+	if len(clients) == 0 {
+		return
+	}
+	util.Info("Sidebar %s ended", sidebar.ID)
+	returned := make([]string, 0, len(clients))
+	for _, client := range clients {
+		client.setAside(nil)
+		client.Send(&Message{Type: "sidebar-ended", To: client.ID, Data: map[string]interface{}{
+			"sidebarRoomId": sidebar.ID,
+			"roomId":        mainID,
+		}})
+		returned = append(returned, client.ID)
+	}
+	if main := h.FindRoom(mainID); main != nil {
+		main.broadcastAside(returned, false)
+		for _, client := range clients {
+			if main.client(client.ID) == client {
+				client.sendUserList()
+			}
+		}
+	}
+}
+
+// broadcastAside tells a room that participants stepped aside or came back,
+// so the others close or renegotiate their peer connections with them
+func (r *Room) broadcastAside(clientIDs []string, aside bool) {
+	r.Broadcast(&Message{
+		Type: "participant-aside",
+		Data: map[string]interface{}{
+			"clientIds": clientIDs,
+			"aside":     aside,
+		},
+	}, "")
+}
+
+// setAside records the sidebar room the client negotiates media in, or nil
+// once it is back in its main room
+func (c *Client) setAside(sidebar *Room) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.aside = sidebar
+}
+
+// asideRoom returns the sidebar room the client is in, or nil
+func (c *Client) asideRoom() *Room {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.aside
+}
+
+// asideFrom reports whether the client is in a sidebar other than the room,
+// so the room's peer signaling must not reach it
+func (c *Client) asideFrom(room *Room) bool {
+	aside := c.asideRoom()
+	return aside != nil && aside != room
+}
+
+// signalingRoom returns the room the client negotiates media in
+func (c *Client) signalingRoom() *Room {
+	if aside := c.asideRoom(); aside != nil {
+		return aside
+	}
+	return c.Room
+}
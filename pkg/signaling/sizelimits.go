@@ -0,0 +1,109 @@
+package signaling
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// ErrorTooLarge is the code of the error answering a message over the size
+// limit of its type
+const ErrorTooLarge = "too-large"
+
+// messageLimits are the largest messages accepted from a client by type, in
+// bytes; other types are limited to maxMessageSize
+var messageLimits = DefaultMessageLimits()
+
+// maxFrameSize is the largest frame read from a client, the largest limit
+// of any type. Larger frames close the connection, as they aren't read
+var maxFrameSize = largestLimit(maxMessageSize, messageLimits)
+
+// DefaultMessageLimits returns the size limits of types that differ from
+// the default: session descriptions with many candidates may be large,
+// while chat and reactions stay small
+func DefaultMessageLimits() map[string]int64 {
+	return map[string]int64{
+		"offer":      65536,
+		"answer":     65536,
+		"sfu-answer": 65536,
+		"chat":       4000,
+		"reaction":   512,
+	}
+}
+
+// ParseMessageLimits parses size limits such as "offer=65536,chat=2000", in
+// bytes by message type
+func ParseMessageLimits(value string) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		msgType, size, found := strings.Cut(entry, "=")
+		n, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+		if !found || strings.TrimSpace(msgType) == "" || err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid message limit %q, expected type=bytes", entry)
+		}
+		limits[strings.TrimSpace(msgType)] = n
+	}
+	return limits, nil
+}
+
+// MessageLimits are the size limits clients must keep their messages to,
+// as the capabilities endpoint reports them
+type MessageLimits struct {
+	// Limit of types that have none of their own
+	Default int64            `json:"default"`
+	Types   map[string]int64 `json:"types"`
+}
+
+// CurrentMessageLimits returns the size limits in effect
+func CurrentMessageLimits() MessageLimits {
+	return MessageLimits{Default: maxMessageSize, Types: maps.Clone(messageLimits)}
+}
+
+// largestLimit returns the largest of the default and per-type limits
+func largestLimit(def int64, limits map[string]int64) int64 {
+	largest := def
+	for _, limit := range limits {
+		largest = max(largest, limit)
+	}
+	return largest
+}
+
+// sizeLimit returns the largest message of a type accepted from a client
+func sizeLimit(msgType string) int64 {
+	if limit, ok := messageLimits[msgType]; ok {
+		return limit
+	}
+	return maxMessageSize
+}
+
+// messageSizes returns the size of each message decoded from a frame. A
+// frame holding one message is its size; the messages of a batch are each
+// encoded again, so a small message isn't refused for sharing a frame with
+// large ones
+func messageSizes(codec Codec, frame []byte, messages []*Message) ([]int, error) {
+	if len(messages) == 1 {
+		return []int{len(frame)}, nil
+	}
+	sizes := make([]int, len(messages))
+	for i, msg := range messages {
+		encoded, err := codec.Encode([]*Message{msg})
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = len(encoded)
+	}
+	return sizes, nil
+}
+
+// checkSize refuses a message of the given size when it is larger than its
+// type allows
+func checkSize(msg *Message, size int) error {
+	if limit := sizeLimit(msg.Type); int64(size) > limit {
+		return fmt.Errorf("%s messages are limited to %d bytes, got %d", msg.Type, limit, size)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package signaling
+
+import "fmt"
+
+// ClientState is the lifecycle stage of a client connection as tracked by
+// the server
+type ClientState int
+
+const (
+	// StateConnected means the WebSocket is upgraded but the client isn't in a room yet
+	StateConnected ClientState = iota
+
+	// StateJoined means the client is in the room but hasn't set up its peer connections
+	StateJoined
+
+	// StateReady means the client can take part in negotiation; only ready
+	// clients receive relayed peer messages
+	StateReady
+
+	// StateLeaving means the connection is being torn down
+	StateLeaving
+)
+
+// String returns the protocol name of a state
+func (s ClientState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateJoined:
+		return "joined"
+	case StateReady:
+		return "ready"
+	case StateLeaving:
+		return "leaving"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// MarshalText encodes the state by name in JSON payloads
+func (s ClientState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// canTransition reports whether a client may move between two states.
+// Clients only move forward, and may start leaving from any state
+func canTransition(from, to ClientState) bool {
+	switch to {
+	case StateJoined:
+		return from == StateConnected
+	case StateReady:
+		return from == StateJoined
+	case StateLeaving:
+		return from != StateLeaving
+	default:
+		return false
+	}
+}
+
+// isRelayed reports whether a message type is peer traffic that is only
+// delivered to ready clients. Clients that aren't ready yet get it once they
+// become ready
+func isRelayed(msgType string) bool {
+	switch msgType {
+	case "offer", "answer", "ice-candidate", "sfu-offer", "dtmf", "chat", "reaction", "stats", "digest", AppDataType:
+		return true
+	default:
+		return false
+	}
+}
+
+// State returns the client's lifecycle state
+func (c *Client) State() ClientState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state
+}
+
+// transitionLocked moves the client to a new state, refusing transitions
+// that are out of order; the caller must hold the mutex
+func (c *Client) transitionLocked(to ClientState) error {
+	if !canTransition(c.state, to) {
+		return fmt.Errorf("client %s cannot go from %s to %s", c.ID, c.state, to)
+	}
+	c.state = to
+	return nil
+}
@@ -0,0 +1,72 @@
+package signaling
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// messageCounters counts a room's signaling traffic
+type messageCounters struct {
+	received atomic.Uint64 // Messages read from clients
+	dropped  atomic.Uint64 // Messages that could not be queued for a recipient
+}
+
+// RoomStats is a point-in-time view of a room for metrics
+type RoomStats struct {
+	RoomID           string         `json:"roomId"`
+	Tenant           string         `json:"tenant,omitempty"`
+	Clients          int            `json:"clients"`
+	ReadyClients     int            `json:"readyClients"`
+	Health           RoomHealth     `json:"health"`
+	ClientErrors     map[string]int `json:"clientErrors"`
+	MessagesReceived uint64         `json:"messagesReceived"`
+	MessagesDropped  uint64         `json:"messagesDropped"`
+}
+
+// Stats returns the current stats of the room
+func (r *Room) Stats() RoomStats {
+	clients := r.GetClients()
+	ready := 0
+	for _, client := range clients {
+		if client.State() == StateReady {
+			ready++
+		}
+	}
+	return RoomStats{
+		RoomID:           r.ID,
+		Tenant:           r.Settings().Tenant,
+		Clients:          len(clients),
+		ReadyClients:     ready,
+		Health:           r.Health(),
+		ClientErrors:     r.ClientErrors().Counts,
+		MessagesReceived: r.counters.received.Load(),
+		MessagesDropped:  r.counters.dropped.Load(),
+	}
+}
+
+// Stats returns the stats of every room of the hub, sorted by room ID
+func (h *Hub) Stats() []RoomStats {
+	h.roomsMutex.RLock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.roomsMutex.RUnlock()
+
+	stats := make([]RoomStats, 0, len(rooms))
+	for _, room := range rooms {
+		stats = append(stats, room.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].RoomID < stats[j].RoomID })
+	return stats
+}
+
+// countDropped records a message that never reached this client
+func (c *Client) countDropped() {
+	if c.Room != nil {
+		c.Room.counters.dropped.Add(1)
+	}
+	if c.hub != nil {
+		c.hub.traffic.dropped.Add(1)
+	}
+}
@@ -0,0 +1,252 @@
+package signaling
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// StateStore holds the membership and state of open rooms. The hub writes
+// every join, leave and room change through to it, so nodes sharing a
+// backend such as Redis or SQL see the same rooms. Live connections stay on
+// the node that accepted them. Members double as the directory of which
+// node each client is connected to
+type StateStore interface {
+	PutRoom(record RoomRecord) error
+	DeleteRoom(roomID string) error
+	Rooms() ([]RoomRecord, error)
+	AddMember(roomID string, member Member) error
+	RemoveMember(roomID, clientID string) error
+	Members(roomID string) ([]Member, error)
+
+	// Member looks up one connection in a room, reporting whether it exists
+	Member(roomID, clientID string) (Member, bool, error)
+}
+
+// RoomRecord is the shared state of an open room
+type RoomRecord struct {
+	ID        string       `json:"id"`
+	State     RoomState    `json:"state"`
+	HostID    string       `json:"hostId,omitempty"`
+	Settings  RoomSettings `json:"settings"`
+	Node      string       `json:"node,omitempty"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+}
+
+// Member is one connection in a room
+type Member struct {
+	ClientID string    `json:"clientId"`
+	UserID   string    `json:"userId,omitempty"`
+	DeviceID string    `json:"deviceId,omitempty"`
+	Node     string    `json:"node,omitempty"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+// memberChange is a join or leave waiting to be written to the state store
+type memberChange struct {
+	member Member
+	left   bool
+	reason string
+}
+
+// SetStateStore sets where room membership and state are kept, replacing the
+// in-memory default. It must be called before rooms are created
+func (h *Hub) SetStateStore(store StateStore) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.state = store
+}
+
+// SetNodeName sets the node recorded with the rooms and members of this hub
+func (h *Hub) SetNodeName(name string) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.node = name
+}
+
+// Members returns the members of a room on every node sharing the store
+func (h *Hub) Members(roomID string) ([]Member, error) {
+	h.roomsMutex.RLock()
+	store := h.state
+	h.roomsMutex.RUnlock()
+	return store.Members(roomID)
+}
+
+// RoomRecords returns the open rooms of every node sharing the store
+func (h *Hub) RoomRecords() ([]RoomRecord, error) {
+	h.roomsMutex.RLock()
+	store := h.state
+	h.roomsMutex.RUnlock()
+	return store.Rooms()
+}
+
+// recordJoinLocked queues a join for the state store; the caller must hold
+// clientMutex
+func (r *Room) recordJoinLocked(client *Client) {
+	r.memberChanges = append(r.memberChanges, memberChange{member: Member{
+		ClientID: client.ID,
+		UserID:   client.UserID,
+		DeviceID: client.DeviceID,
+		Node:     r.node,
+		JoinedAt: time.Now(),
+	}})
+}
+
+// recordLeaveLocked queues a leave for the state store, with why the client
+// left; the caller must hold clientMutex
+func (r *Room) recordLeaveLocked(clientID, reason string) {
+	r.memberChanges = append(r.memberChanges, memberChange{member: Member{ClientID: clientID}, left: true, reason: reason})
+}
+
+// syncState writes queued joins and leaves and the room's current state to
+// the state store. Failures are logged; the node's own view stays correct
+func (r *Room) syncState(changes []memberChange, record RoomRecord) {
+	if r.stateStore == nil {
+		return
+	}
+	for _, change := range changes {
+		var err error
+		if change.left {
+			err = r.stateStore.RemoveMember(r.ID, change.member.ClientID)
+		} else {
+			err = r.stateStore.AddMember(r.ID, change.member)
+		}
+		if err != nil {
+			util.Warn("Error recording membership of %s in room %s: %v", change.member.ClientID, r.ID, err)
+		}
+	}
+	if record.State == RoomClosed {
+		r.forgetState()
+		return
+	}
+	if err := r.stateStore.PutRoom(record); err != nil {
+		util.Warn("Error recording state of room %s: %v", r.ID, err)
+	}
+}
+
+// forgetState removes a closed room's members on this node from the state
+// store, and the room itself unless it is still open on another node
+func (r *Room) forgetState() {
+	members, err := r.stateStore.Members(r.ID)
+	if err != nil {
+		util.Warn("Error reading members of room %s: %v", r.ID, err)
+	}
+	elsewhere := false
+	for _, member := range members {
+		if member.Node != r.node {
+			elsewhere = true
+		} else if err := r.stateStore.RemoveMember(r.ID, member.ClientID); err != nil {
+			util.Warn("Error removing %s of room %s from the state store: %v", member.ClientID, r.ID, err)
+		}
+	}
+	if elsewhere {
+		return
+	}
+	if err := r.stateStore.DeleteRoom(r.ID); err != nil {
+		util.Warn("Error removing room %s from the state store: %v", r.ID, err)
+	}
+}
+
+// recordLocked describes the room for the state store; the caller must hold
+// clientMutex
+func (r *Room) recordLocked() RoomRecord {
+	return RoomRecord{
+		ID:        r.ID,
+		State:     r.state,
+		HostID:    r.hostID,
+		Settings:  r.settings,
+		Node:      r.node,
+		UpdatedAt: time.Now(),
+	}
+}
+
+// MemoryStore is the default state store, keeping rooms and members in
+// process for a single node
+type MemoryStore struct {
+	mutex   sync.Mutex
+	rooms   map[string]RoomRecord
+	members map[string]map[string]Member
+}
+
+// NewMemoryStore creates an empty in-memory state store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		rooms:   make(map[string]RoomRecord),
+		members: make(map[string]map[string]Member),
+	}
+}
+
+// PutRoom records a room's state
+func (s *MemoryStore) PutRoom(record RoomRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rooms[record.ID] = record
+	return nil
+}
+
+// DeleteRoom forgets a room and its members
+func (s *MemoryStore) DeleteRoom(roomID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.rooms, roomID)
+	delete(s.members, roomID)
+	return nil
+}
+
+// Rooms returns all recorded rooms, ordered by ID
+func (s *MemoryStore) Rooms() ([]RoomRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	records := make([]RoomRecord, 0, len(s.rooms))
+	for _, record := range s.rooms {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
+
+// AddMember records a connection in a room, replacing one with the same ID
+func (s *MemoryStore) AddMember(roomID string, member Member) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.members[roomID] == nil {
+		s.members[roomID] = make(map[string]Member)
+	}
+	s.members[roomID][member.ClientID] = member
+	return nil
+}
+
+// RemoveMember forgets a connection in a room
+func (s *MemoryStore) RemoveMember(roomID, clientID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.members[roomID], clientID)
+	return nil
+}
+
+// Members returns the connections in a room, ordered by client ID
+func (s *MemoryStore) Members(roomID string) ([]Member, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return sortedMembers(s.members[roomID]), nil
+}
+
+// Member looks up one connection in a room
+func (s *MemoryStore) Member(roomID, clientID string) (Member, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	member, exists := s.members[roomID][clientID]
+	return member, exists, nil
+}
+
+// sortedMembers lists members ordered by client ID
+func sortedMembers(members map[string]Member) []Member {
+	list := make([]Member, 0, len(members))
+	for _, member := range members {
+		list = append(list, member)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ClientID < list[j].ClientID })
+	return list
+}
@@ -0,0 +1,242 @@
+package signaling
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Trace event kinds
+const (
+	TraceJoin    = "join"
+	TraceMessage = "message"
+	TraceLeave   = "leave"
+)
+
+// TraceEntry is one recorded event of a room's signal trace
+type TraceEntry struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	RoomID   string    `json:"roomId"`
+	ClientID string    `json:"clientId"`
+	UserID   string    `json:"userId,omitempty"`
+	DeviceID string    `json:"deviceId,omitempty"`
+	Message  *Message  `json:"message,omitempty"`
+}
+
+// signalTrace appends a room's trace entries to a JSON lines file
+type signalTrace struct {
+	path  string
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// SetTraceDir sets where traces of rooms with tracing enabled are written.
+// It must be called before rooms are created; tracing is off when unset
+func (h *Hub) SetTraceDir(dir string) {
+	h.roomsMutex.Lock()
+	defer h.roomsMutex.Unlock()
+	h.traceDir = dir
+}
+
+// newSignalTrace prepares a trace file for a room. The file is only created
+// once something is recorded
+func newSignalTrace(dir, roomID string) *signalTrace {
+	name := fmt.Sprintf("%s-%s.jsonl", url.PathEscape(roomID), time.Now().Format("20060102-150405"))
+	return &signalTrace{path: filepath.Join(dir, name)}
+}
+
+// record appends an entry, logging instead of failing the caller on errors
+func (t *signalTrace) record(entry TraceEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		util.Error("Error encoding trace entry for room %s: %v", entry.RoomID, err)
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.file == nil {
+		if err := os.MkdirAll(filepath.Dir(t.path), 0o755); err != nil {
+			util.Error("Error creating trace directory: %v", err)
+			return
+		}
+		file, err := os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			util.Error("Error opening trace file %s: %v", t.path, err)
+			return
+		}
+		t.file = file
+		util.Info("Recording signal trace of room %s to %s", entry.RoomID, t.path)
+	}
+	if _, err := t.file.Write(append(data, '\n')); err != nil {
+		util.Error("Error writing trace file %s: %v", t.path, err)
+	}
+}
+
+// close closes the trace file
+func (t *signalTrace) close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+// traceClient records a join or leave of a client if the room is traced
+func (r *Room) traceClient(event string, client *Client) {
+	if r.trace == nil {
+		return
+	}
+	r.trace.record(TraceEntry{
+		Time:     time.Now(),
+		Event:    event,
+		RoomID:   r.ID,
+		ClientID: client.ID,
+		UserID:   client.UserID,
+		DeviceID: client.DeviceID,
+	})
+}
+
+// traceMessage records a message received from a client if the room is traced
+func (r *Room) traceMessage(client *Client, msg *Message) {
+	if r.trace == nil {
+		return
+	}
+	r.trace.record(TraceEntry{
+		Time:     time.Now(),
+		Event:    TraceMessage,
+		RoomID:   r.ID,
+		ClientID: client.ID,
+		Message:  msg,
+	})
+}
+
+// closeTrace stops recording the room's trace
+func (r *Room) closeTrace() {
+	if r.trace != nil {
+		r.trace.close()
+	}
+}
+
+// ReadTrace parses a trace file written by a traced room
+func ReadTrace(reader io.Reader) ([]TraceEntry, error) {
+	var entries []TraceEntry
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*int(maxFrameSize))
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry TraceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Delivery is a message the server queued for a client during a replay
+type Delivery struct {
+	// Time offset of the trace entry that caused the delivery
+	Offset time.Duration `json:"offset"`
+
+	To      string   `json:"to"`
+	Message *Message `json:"message"`
+}
+
+// Replay feeds a recorded trace through a fresh hub and reports every message
+// the server would have sent, in order. Clients are simulated without
+// connections, so the replay runs the room logic exactly as in production
+// but never touches the network
+func Replay(entries []TraceEntry, deliver func(Delivery)) error {
+	hub := NewHub()
+	clients := make(map[string]*Client)
+	rooms := make(map[string]*Room)
+	var start time.Time
+
+	for i, entry := range entries {
+		if start.IsZero() {
+			start = entry.Time
+		}
+		offset := entry.Time.Sub(start)
+
+		switch entry.Event {
+		case TraceJoin:
+			client := &Client{
+				ID:       entry.ClientID,
+				UserID:   entry.UserID,
+				DeviceID: entry.DeviceID,
+				Room:     hub.GetRoom(entry.RoomID),
+				lanes:    newLanes(),
+				hub:      hub,
+			}
+			client.send = client.lanes[laneSignaling]
+			if _, err := client.Room.Join(client); err != nil {
+				return fmt.Errorf("entry %d: %w", i, err)
+			}
+			client.mutex.Lock()
+			client.transitionLocked(StateJoined)
+			client.mutex.Unlock()
+			clients[client.ID] = client
+			rooms[client.Room.ID] = client.Room
+			client.announceJoin()
+		case TraceMessage:
+			client := clients[entry.ClientID]
+			if client == nil || entry.Message == nil {
+				return fmt.Errorf("entry %d: message from unknown client %s", i, entry.ClientID)
+			}
+			msg := *entry.Message
+			msg.From = client.ID
+			client.handleMessage(&msg)
+		case TraceLeave:
+			if client := clients[entry.ClientID]; client != nil {
+				client.Room.settle()
+				drain(client, offset, deliver)
+				client.Close()
+				delete(clients, entry.ClientID)
+			}
+		default:
+			return fmt.Errorf("entry %d: unknown event %q", i, entry.Event)
+		}
+
+		for _, room := range rooms {
+			room.settle()
+		}
+		ids := make([]string, 0, len(clients))
+		for id := range clients {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			drain(clients[id], offset, deliver)
+		}
+	}
+	return nil
+}
+
+// drain reports and removes everything queued for a replayed client
+func drain(client *Client, offset time.Duration, deliver func(Delivery)) {
+	for {
+		msg, open, found := client.nextMessage()
+		if !found || !open {
+			return
+		}
+		deliver(Delivery{Offset: offset, To: client.ID, Message: msg})
+	}
+}
@@ -0,0 +1,94 @@
+package signaling
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSignalTraceRecording(t *testing.T) {
+	dir := t.TempDir()
+	hub := NewHub()
+	hub.SetTraceDir(dir)
+	room := hub.GetRoomWithSettings("traced-room", func(settings *RoomSettings) {
+		settings.Trace = true
+	})
+
+	client := &Client{ID: "client-a", Room: room}
+	room.AddClient(client)
+	room.traceClient(TraceJoin, client)
+	room.traceMessage(client, &Message{Type: "offer", From: "client-a", To: "client-b"})
+	room.closeTrace()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "traced-room-*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 trace file, got %d", len(files))
+	}
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	entries, err := ReadTrace(file)
+	if err != nil {
+		t.Fatalf("Error reading trace: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Event != TraceJoin || entries[1].Message.To != "client-b" {
+		t.Errorf("Unexpected trace entries: %+v", entries)
+	}
+
+	// Rooms without tracing enabled record nothing
+	if untraced := hub.GetRoom("plain-room"); untraced.trace != nil {
+		t.Error("Expected no trace for a room without tracing enabled")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	entries := []TraceEntry{
+		{Time: at(0), Event: TraceJoin, RoomID: "room", ClientID: "a"},
+		{Time: at(10), Event: TraceJoin, RoomID: "room", ClientID: "b"},
+		{Time: at(20), Event: TraceMessage, RoomID: "room", ClientID: "a", Message: &Message{Type: "ready"}},
+		{Time: at(30), Event: TraceMessage, RoomID: "room", ClientID: "a", Message: &Message{Type: "offer", To: "b"}},
+		{Time: at(40), Event: TraceMessage, RoomID: "room", ClientID: "b", Message: &Message{Type: "ready"}},
+		{Time: at(50), Event: TraceLeave, RoomID: "room", ClientID: "a"},
+	}
+
+	var deliveries []Delivery
+	if err := Replay(entries, func(d Delivery) { deliveries = append(deliveries, d) }); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+
+	// The offer reaches b only once b is ready
+	var offer *Delivery
+	for i := range deliveries {
+		if deliveries[i].Message.Type == "offer" {
+			offer = &deliveries[i]
+		}
+	}
+	if offer == nil {
+		t.Fatal("Expected the offer to be delivered")
+	}
+	if offer.To != "b" || offer.Offset != 40*time.Millisecond {
+		t.Errorf("Expected the offer delivered to b at 40ms, got %s at %v", offer.To, offer.Offset)
+	}
+
+	left := false
+	for _, d := range deliveries {
+		if d.Message.Type == "user-left" && d.To == "b" && d.Offset == 50*time.Millisecond {
+			left = true
+		}
+	}
+	if !left {
+		t.Error("Expected b to be told that a left")
+	}
+
+	// Unknown senders make the trace invalid
+	bad := []TraceEntry{{Event: TraceMessage, ClientID: "ghost", Message: &Message{Type: "offer"}}}
+	if err := Replay(bad, func(Delivery) {}); err == nil {
+		t.Error("Expected an error for a message from an unknown client")
+	}
+}
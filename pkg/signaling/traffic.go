@@ -0,0 +1,137 @@
+package signaling
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of WebSocket errors counted in TrafficStats
+const (
+	WebSocketUpgrade   = "upgrade"   // The HTTP connection couldn't be upgraded
+	WebSocketRead      = "read"      // The connection closed unexpectedly
+	WebSocketWrite     = "write"     // A message or ping couldn't be written
+	WebSocketMalformed = "malformed" // A client sent a message that isn't JSON
+)
+
+// FanOutBuckets are the upper bounds, in seconds, of the fan-out latency
+// histogram
+var FanOutBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1}
+
+// hubTraffic counts the signaling traffic of all rooms since the server
+// started, so totals don't drop when rooms close
+type hubTraffic struct {
+	relayed  sync.Map // Messages queued for clients, by type, as *atomic.Uint64
+	errors   sync.Map // WebSocket errors, by kind, as *atomic.Uint64
+	dropped  atomic.Uint64
+	fanMutex sync.Mutex
+	fanOut   Histogram
+}
+
+// Histogram is a cumulative histogram in the Prometheus convention: Counts
+// holds, for each bound, the observations up to it
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Count  uint64    `json:"count"`
+	Sum    float64   `json:"sum"`
+}
+
+// TrafficStats is the hub's signaling traffic since the server started
+type TrafficStats struct {
+	// Messages queued for clients, by message type. A message broadcast to
+	// several clients counts once for each
+	Relayed map[string]uint64 `json:"relayed"`
+
+	// Messages not delivered because a client's send buffer was full
+	Dropped uint64 `json:"dropped"`
+
+	// WebSocket errors, by kind
+	WebSocketErrors map[string]uint64 `json:"webSocketErrors"`
+
+	// Time, in seconds, to hand broadcast messages to every recipient
+	FanOut Histogram `json:"fanOut"`
+}
+
+// Traffic returns the hub's signaling traffic since the server started
+func (h *Hub) Traffic() TrafficStats {
+	h.traffic.fanMutex.Lock()
+	fanOut := Histogram{
+		Bounds: FanOutBuckets,
+		Counts: append([]uint64(nil), h.traffic.fanOut.Counts...),
+		Count:  h.traffic.fanOut.Count,
+		Sum:    h.traffic.fanOut.Sum,
+	}
+	h.traffic.fanMutex.Unlock()
+	if fanOut.Counts == nil {
+		fanOut.Counts = make([]uint64, len(FanOutBuckets))
+	}
+
+	return TrafficStats{
+		Relayed:         counts(&h.traffic.relayed),
+		Dropped:         h.traffic.dropped.Load(),
+		WebSocketErrors: counts(&h.traffic.errors),
+		FanOut:          fanOut,
+	}
+}
+
+// CountWebSocketError records a WebSocket error of the given kind, such as
+// WebSocketUpgrade
+func (h *Hub) CountWebSocketError(kind string) {
+	increment(&h.traffic.errors, kind)
+}
+
+// countRelayed records a message queued for this client
+func (c *Client) countRelayed(msgType string) {
+	if c.hub != nil {
+		increment(&c.hub.traffic.relayed, msgType)
+	}
+}
+
+// countWebSocketError records an error on this client's connection
+func (c *Client) countWebSocketError(kind string) {
+	if c.hub != nil {
+		c.hub.CountWebSocketError(kind)
+	}
+}
+
+// observeFanOut records how long a broadcast took to reach its recipients
+func (r *Room) observeFanOut(elapsed time.Duration) {
+	if r.hub == nil {
+		return
+	}
+	traffic := &r.hub.traffic
+	seconds := elapsed.Seconds()
+
+	traffic.fanMutex.Lock()
+	defer traffic.fanMutex.Unlock()
+	if traffic.fanOut.Counts == nil {
+		traffic.fanOut.Counts = make([]uint64, len(FanOutBuckets))
+	}
+	for i, bound := range FanOutBuckets {
+		if seconds <= bound {
+			traffic.fanOut.Counts[i]++
+		}
+	}
+	traffic.fanOut.Count++
+	traffic.fanOut.Sum += seconds
+}
+
+// increment adds one to a counter of a sync.Map of counters
+func increment(counters *sync.Map, key string) {
+	counter, found := counters.Load(key)
+	if !found {
+		counter, _ = counters.LoadOrStore(key, new(atomic.Uint64))
+	}
+	counter.(*atomic.Uint64).Add(1)
+}
+
+// counts copies a sync.Map of counters
+func counts(counters *sync.Map) map[string]uint64 {
+	copied := make(map[string]uint64)
+	counters.Range(func(key, counter interface{}) bool {
+		copied[key.(string)] = counter.(*atomic.Uint64).Load()
+		return true
+	})
+	return copied
+}
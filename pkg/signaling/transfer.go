@@ -0,0 +1,100 @@
+package signaling
+
+import (
+	"errors"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// callTransfer links a call to the call it was placed to transfer
+type callTransfer struct {
+	from     *Call   // Call being transferred
+	by       *Client // Connection of the party handing the other one over
+	attended bool    // from stays up until the new call is accepted
+}
+
+// TransferCall hands the other party of the client's active call over to
+// another user: the server rings the user from the other party's connection.
+// A blind transfer ends the call right away. An attended one keeps it up
+// until the user accepts, and sends the client transfer-failed if they don't
+func (c *Client) TransferCall(callID, userID string, attended bool) (Call, error) {
+	h := c.hub
+	h.calls.mutex.Lock()
+	call := h.calls.byID[callID]
+	if call == nil || call.State != CallActive || !call.isParty(c) {
+		h.calls.mutex.Unlock()
+		return Call{}, ErrCallNotFound
+	}
+	if userID == "" || userID == call.Caller || userID == call.Callee {
+		h.calls.mutex.Unlock()
+		return Call{}, errors.New("a call needs to be transferred to a third user")
+	}
+	if call.transferring {
+		h.calls.mutex.Unlock()
+		return Call{}, errors.New("call is already being transferred")
+	}
+	party := call.otherParty(c)
+	transfer := &callTransfer{from: call, by: c, attended: attended}
+	if attended {
+		call.transferring = true
+	} else {
+		call.end(CallTransferred)
+		h.forgetCallLocked(call)
+	}
+	h.calls.mutex.Unlock()
+
+	util.Info("Client %s transfers call %s to %s (attended: %v)", c.ID, call.ID, userID, attended)
+	if !attended {
+		data := call.data()
+		data["reason"] = CallTransferred
+		data["by"] = c.userKey()
+		data["transferredTo"] = userID
+		c.Send(&Message{Type: "call-ended", To: c.ID, Data: data})
+		party.Send(&Message{Type: "call-ended", To: party.ID, Data: data})
+	}
+	return h.placeCall(party, userID, transfer)
+}
+
+// completeTransferLocked ends the call an accepted call was placed to
+// transfer with attendance, and returns it unless it already ended. The
+// caller must hold the calls mutex
+func (h *Hub) completeTransferLocked(call *Call) *Call {
+	transfer := call.transfer
+	if transfer == nil || !transfer.attended || h.calls.byID[transfer.from.ID] != transfer.from {
+		return nil
+	}
+	from := transfer.from
+	from.end(CallTransferred)
+	h.forgetCallLocked(from)
+	return from
+}
+
+// endTransferred tells both parties of a call that it was transferred into
+// the accepted call
+func (call *Call) endTransferred(into *Call) {
+	util.Info("Call %s transferred into call %s", call.ID, into.ID)
+	data := call.data()
+	data["reason"] = CallTransferred
+	data["by"] = into.TransferredBy
+	data["transferredTo"] = into.Callee
+	data["transferCallId"] = into.ID
+	for _, party := range []*Client{call.caller, call.answerer} {
+		party.Send(&Message{Type: "call-ended", To: party.ID, Data: data})
+	}
+}
+
+// transferFailed tells the party who started an attended transfer that the
+// target didn't take the call, which stays up
+func (call *Call) transferFailed() {
+	transfer := call.transfer
+	if transfer == nil || !transfer.attended {
+		return
+	}
+	util.Info("Transfer of call %s to %s failed: %s", transfer.from.ID, call.Callee, call.Outcome)
+	transfer.by.Send(&Message{Type: "transfer-failed", To: transfer.by.ID, Data: map[string]interface{}{
+		"callId":         transfer.from.ID,
+		"transferCallId": call.ID,
+		"userId":         call.Callee,
+		"reason":         call.Outcome,
+	}})
+}
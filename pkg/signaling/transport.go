@@ -0,0 +1,59 @@
+package signaling
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// ICE transports a client's media can end up on, as the client reports them
+const (
+	TransportUDP     = "udp"      // Directly over UDP
+	TransportTCP     = "tcp"      // Directly over ICE-TCP
+	TransportTURNUDP = "turn-udp" // Relayed, reaching the TURN server over UDP
+	TransportTURNTCP = "turn-tcp" // Relayed, over TCP
+	TransportTURNTLS = "turn-tls" // Relayed, over TLS, e.g. on port 443
+)
+
+// Transports lists the ICE transports from the most to the least direct
+var Transports = []string{TransportUDP, TransportTCP, TransportTURNUDP, TransportTURNTCP, TransportTURNTLS}
+
+// reportTransport records the transport the client's media ended up on
+// after ICE connected with a peer, or with the SFU. Clients with several
+// peer connections are counted with the latest one
+func (c *Client) reportTransport(msg *Message) error {
+	transport, _ := msg.Data["transport"].(string)
+	if !slices.Contains(Transports, transport) {
+		return fmt.Errorf("unknown ICE transport %q", transport)
+	}
+	peerID, _ := msg.Data["peerId"].(string)
+
+	c.mutex.Lock()
+	c.transport = transport
+	c.mutex.Unlock()
+	util.Debug("Client %s connected to %s over %s in room %s", c.ID, peerID, transport, c.Room.ID)
+	return nil
+}
+
+// Transport returns the ICE transport the client last reported, or ""
+func (c *Client) Transport() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.transport
+}
+
+// Transports counts the connected clients by the ICE transport they
+// reported. Clients that haven't reported one aren't counted
+func (h *Hub) Transports() map[string]int {
+	counts := make(map[string]int, len(Transports))
+	for _, transport := range Transports {
+		counts[transport] = 0
+	}
+	for _, client := range h.connectedClients() {
+		if transport := client.Transport(); transport != "" {
+			counts[transport]++
+		}
+	}
+	return counts
+}
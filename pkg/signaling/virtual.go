@@ -0,0 +1,39 @@
+package signaling
+
+// NewVirtualClient adds a participant that has no WebSocket, such as a
+// gateway to another signaling network. Messages the server sends the client
+// are passed to deliver on a dedicated goroutine; messages from the client
+// are fed in with Receive. The client is ready as soon as it joins and stays
+// in the room until Close is called
+func NewVirtualClient(id string, hub *Hub, roomID string, opts ClientOptions, deliver func(*Message)) (*Client, error) {
+	client := newClient(id, nil, hub, opts)
+	if err := client.join(roomID); err != nil {
+		return nil, err
+	}
+
+	go client.deliverPump(deliver)
+	client.announceJoin()
+	client.MarkReady()
+	return client, nil
+}
+
+// Receive handles a message as if the client had sent it over a connection
+func (c *Client) Receive(msg *Message) {
+	msg.From = c.ID
+	c.handleMessage(msg)
+}
+
+// deliverPump hands queued messages to a virtual client's callback in
+// priority order until the client is closed
+func (c *Client) deliverPump(deliver func(*Message)) {
+	for {
+		msg, open, found := c.nextMessage()
+		if !found {
+			msg, open, _ = c.waitMessage(nil, nil)
+		}
+		if !open {
+			return
+		}
+		deliver(msg)
+	}
+}
@@ -0,0 +1,93 @@
+package signaling
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Most characters of a watermark template
+const maxWatermarkLength = 200
+
+// Watermark overlays the identity of each viewer on the video they see, so
+// leaked screenshots and screen recordings can be traced back to them
+type Watermark struct {
+	// Text shown to each viewer; {user}, {client} and {room} are replaced
+	// with the viewer's user ID (or client ID for guests), client ID and room
+	Template string `json:"template"`
+
+	// Opacity of the overlay from 0 to 1; zero means the client's default
+	Opacity float64 `json:"opacity,omitempty"`
+}
+
+// SetWatermark turns the room's viewer watermark on, or off when watermark
+// is nil, and sends every participant their own rendering of it
+func (r *Room) SetWatermark(watermark *Watermark) error {
+	_, err := r.SetWatermarkIf(0, watermark)
+	return err
+}
+
+// SetWatermarkIf is SetWatermark applied only if the room's settings are
+// still at the given version; it returns their new version
+func (r *Room) SetWatermarkIf(version int64, watermark *Watermark) (int64, error) {
+	if err := watermark.normalize(); err != nil {
+		return 0, err
+	}
+	version, err := r.UpdateSettingsIf(version, func(settings *RoomSettings) error {
+		settings.Watermark = watermark
+		return nil
+	})
+	if err != nil {
+		return version, err
+	}
+	r.sendWatermarks()
+	return version, nil
+}
+
+// sendWatermarks sends every participant their rendering of the watermark
+func (r *Room) sendWatermarks() {
+	for _, client := range r.GetClients() {
+		client.Send(r.watermarkMessage(client))
+	}
+}
+
+// normalize defaults an empty template and checks the watermark's limits
+func (w *Watermark) normalize() error {
+	if w == nil {
+		return nil
+	}
+	w.Template = strings.TrimSpace(w.Template)
+	if w.Template == "" {
+		w.Template = "{user}"
+	}
+	if len(w.Template) > maxWatermarkLength {
+		return errors.New("watermark template is too long")
+	}
+	if w.Opacity < 0 || w.Opacity > 1 {
+		return errors.New("watermark opacity must be between 0 and 1")
+	}
+	return nil
+}
+
+// watermarkMessage tells a client what to overlay on the videos it shows;
+// an empty text removes the overlay
+func (r *Room) watermarkMessage(client *Client) *Message {
+	data := map[string]interface{}{"text": ""}
+	if watermark := r.Settings().Watermark; watermark != nil {
+		data["text"] = watermark.render(r.ID, client)
+		if watermark.Opacity > 0 {
+			data["opacity"] = watermark.Opacity
+		}
+		data["issuedAt"] = time.Now()
+	}
+	return &Message{Type: "watermark", To: client.ID, Data: data}
+}
+
+// render fills in the template for one viewer
+func (w *Watermark) render(roomID string, client *Client) string {
+	user := client.UserID
+	if user == "" {
+		user = client.ID
+	}
+	return strings.NewReplacer("{user}", user, "{client}", client.ID, "{room}", roomID).Replace(w.Template)
+}
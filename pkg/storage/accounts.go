@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+var (
+	// ErrAccountNotFound is returned for unknown user and group IDs
+	ErrAccountNotFound = errors.New("account not found")
+
+	// ErrAccountExists is returned when a user name or group name is taken
+	ErrAccountExists = errors.New("account already exists")
+
+	// ErrUnknownMember is returned when a group member isn't a provisioned user
+	ErrUnknownMember = errors.New("unknown group member")
+
+	// ErrInvalidAccount is returned when a required attribute is missing
+	ErrInvalidAccount = errors.New("invalid account")
+)
+
+// Account is a user provisioned by the identity provider. UserName is what
+// identity tokens identify the user by, i.e. their email or subject
+type Account struct {
+	ID          string    `json:"id"`
+	UserName    string    `json:"userName"`
+	ExternalID  string    `json:"externalId,omitempty"`
+	DisplayName string    `json:"displayName,omitempty"`
+	Emails      []string  `json:"emails,omitempty"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+
+	// Deleted accounts are kept so their users stay locked out
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Group is a set of accounts provisioned by the identity provider
+type Group struct {
+	ID          string    `json:"id"`
+	DisplayName string    `json:"displayName"`
+	ExternalID  string    `json:"externalId,omitempty"`
+	Members     []string  `json:"members"` // Account IDs
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// identifies reports whether a user ID from an identity token is this account's
+func (a Account) identifies(userID string) bool {
+	return a.UserName == userID || slices.Contains(a.Emails, userID)
+}
+
+// accountFile is the layout of the account store's file
+type accountFile struct {
+	Users  []Account `json:"users"`
+	Groups []Group   `json:"groups"`
+}
+
+// AccountStore keeps provisioned users and groups in a JSON file
+type AccountStore struct {
+	path  string
+	data  accountFile
+	mutex sync.Mutex
+}
+
+// NewAccountStore opens the accounts file at path, creating it if needed
+func NewAccountStore(path string) (*AccountStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating account directory: %w", err)
+	}
+	store := &AccountStore{path: path, data: accountFile{Users: []Account{}, Groups: []Group{}}}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &store.data); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+	util.Info("Accounts loaded from %s: %d users, %d groups", path, len(store.data.Users), len(store.data.Groups))
+	return store, nil
+}
+
+// CreateUser provisions a user. A deleted account with the same user name is
+// replaced
+func (s *AccountStore) CreateUser(account Account) (Account, error) {
+	if account.UserName == "" {
+		return Account{}, fmt.Errorf("%w: userName is required", ErrInvalidAccount)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	users := slices.Clone(s.data.Users)
+	if i := slices.IndexFunc(users, func(a Account) bool { return a.UserName == account.UserName }); i >= 0 {
+		if !users[i].Deleted {
+			return Account{}, ErrAccountExists
+		}
+		users = slices.Delete(users, i, i+1)
+	}
+	now := time.Now()
+	account.ID = newAccountID()
+	account.CreatedAt = now
+	account.UpdatedAt = now
+	account.Deleted = false
+	users = append(users, account)
+	if err := s.saveLocked(accountFile{Users: users, Groups: s.data.Groups}); err != nil {
+		return Account{}, err
+	}
+	s.data.Users = users
+	return account, nil
+}
+
+// User returns a user by ID
+func (s *AccountStore) User(id string) (Account, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if i := s.userIndexLocked(id); i >= 0 {
+		return s.data.Users[i], nil
+	}
+	return Account{}, ErrAccountNotFound
+}
+
+// Users returns the users that aren't deleted
+func (s *AccountStore) Users() []Account {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	users := make([]Account, 0, len(s.data.Users))
+	for _, account := range s.data.Users {
+		if !account.Deleted {
+			users = append(users, account)
+		}
+	}
+	return users
+}
+
+// ReplaceUser overwrites a user's attributes and returns the stored user
+func (s *AccountStore) ReplaceUser(id string, account Account) (Account, error) {
+	if account.UserName == "" {
+		return Account{}, fmt.Errorf("%w: userName is required", ErrInvalidAccount)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := s.userIndexLocked(id)
+	if i < 0 {
+		return Account{}, ErrAccountNotFound
+	}
+	if slices.ContainsFunc(s.data.Users, func(a Account) bool {
+		return a.ID != id && !a.Deleted && a.UserName == account.UserName
+	}) {
+		return Account{}, ErrAccountExists
+	}
+	users := slices.Clone(s.data.Users)
+	account.ID = id
+	account.CreatedAt = users[i].CreatedAt
+	account.UpdatedAt = time.Now()
+	users[i] = account
+	if err := s.saveLocked(accountFile{Users: users, Groups: s.data.Groups}); err != nil {
+		return Account{}, err
+	}
+	s.data.Users = users
+	return account, nil
+}
+
+// DeleteUser deprovisions a user for good and removes them from all groups.
+// It returns the deleted user
+func (s *AccountStore) DeleteUser(id string) (Account, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := s.userIndexLocked(id)
+	if i < 0 {
+		return Account{}, ErrAccountNotFound
+	}
+	users := slices.Clone(s.data.Users)
+	users[i].Active = false
+	users[i].Deleted = true
+	users[i].UpdatedAt = time.Now()
+	groups := slices.Clone(s.data.Groups)
+	for j := range groups {
+		if k := slices.Index(groups[j].Members, id); k >= 0 {
+			groups[j].Members = slices.Delete(slices.Clone(groups[j].Members), k, k+1)
+		}
+	}
+	if err := s.saveLocked(accountFile{Users: users, Groups: groups}); err != nil {
+		return Account{}, err
+	}
+	s.data = accountFile{Users: users, Groups: groups}
+	return users[i], nil
+}
+
+// Deprovisioned reports whether a user ID from an identity token belongs to
+// a deactivated or deleted account. Users that were never provisioned aren't
+// deprovisioned; a nil store never deprovisions anybody
+func (s *AccountStore) Deprovisioned(userID string) bool {
+	if s == nil {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	deprovisioned := false
+	for _, account := range s.data.Users {
+		if account.identifies(userID) {
+			if account.Active && !account.Deleted {
+				return false
+			}
+			deprovisioned = true
+		}
+	}
+	return deprovisioned
+}
+
+// GroupsOf returns the names of the groups an active user is a member of
+func (s *AccountStore) GroupsOf(userID string) []string {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var names []string
+	for _, account := range s.data.Users {
+		if !account.Active || account.Deleted || !account.identifies(userID) {
+			continue
+		}
+		for _, group := range s.data.Groups {
+			if slices.Contains(group.Members, account.ID) && !slices.Contains(names, group.DisplayName) {
+				names = append(names, group.DisplayName)
+			}
+		}
+	}
+	return names
+}
+
+// CreateGroup provisions a group
+func (s *AccountStore) CreateGroup(group Group) (Group, error) {
+	if group.DisplayName == "" {
+		return Group{}, fmt.Errorf("%w: displayName is required", ErrInvalidAccount)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if slices.ContainsFunc(s.data.Groups, func(g Group) bool { return g.DisplayName == group.DisplayName }) {
+		return Group{}, ErrAccountExists
+	}
+	if err := s.checkMembersLocked(group.Members); err != nil {
+		return Group{}, err
+	}
+	now := time.Now()
+	group.ID = newAccountID()
+	group.CreatedAt = now
+	group.UpdatedAt = now
+	if group.Members == nil {
+		group.Members = []string{}
+	}
+	groups := append(slices.Clone(s.data.Groups), group)
+	if err := s.saveLocked(accountFile{Users: s.data.Users, Groups: groups}); err != nil {
+		return Group{}, err
+	}
+	s.data.Groups = groups
+	return group, nil
+}
+
+// Group returns a group by ID
+func (s *AccountStore) Group(id string) (Group, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if i := s.groupIndexLocked(id); i >= 0 {
+		return s.data.Groups[i], nil
+	}
+	return Group{}, ErrAccountNotFound
+}
+
+// Groups returns all groups
+func (s *AccountStore) Groups() []Group {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.data.Groups)
+}
+
+// ReplaceGroup overwrites a group's name and members and returns the stored group
+func (s *AccountStore) ReplaceGroup(id string, group Group) (Group, error) {
+	if group.DisplayName == "" {
+		return Group{}, fmt.Errorf("%w: displayName is required", ErrInvalidAccount)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := s.groupIndexLocked(id)
+	if i < 0 {
+		return Group{}, ErrAccountNotFound
+	}
+	if slices.ContainsFunc(s.data.Groups, func(g Group) bool { return g.ID != id && g.DisplayName == group.DisplayName }) {
+		return Group{}, ErrAccountExists
+	}
+	if err := s.checkMembersLocked(group.Members); err != nil {
+		return Group{}, err
+	}
+	groups := slices.Clone(s.data.Groups)
+	group.ID = id
+	group.CreatedAt = groups[i].CreatedAt
+	group.UpdatedAt = time.Now()
+	if group.Members == nil {
+		group.Members = []string{}
+	}
+	groups[i] = group
+	if err := s.saveLocked(accountFile{Users: s.data.Users, Groups: groups}); err != nil {
+		return Group{}, err
+	}
+	s.data.Groups = groups
+	return group, nil
+}
+
+// DeleteGroup removes a group
+func (s *AccountStore) DeleteGroup(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := s.groupIndexLocked(id)
+	if i < 0 {
+		return ErrAccountNotFound
+	}
+	groups := slices.Delete(slices.Clone(s.data.Groups), i, i+1)
+	if err := s.saveLocked(accountFile{Users: s.data.Users, Groups: groups}); err != nil {
+		return err
+	}
+	s.data.Groups = groups
+	return nil
+}
+
+// userIndexLocked finds a user that isn't deleted; the caller must hold the mutex
+func (s *AccountStore) userIndexLocked(id string) int {
+	return slices.IndexFunc(s.data.Users, func(a Account) bool { return a.ID == id && !a.Deleted })
+}
+
+// groupIndexLocked finds a group; the caller must hold the mutex
+func (s *AccountStore) groupIndexLocked(id string) int {
+	return slices.IndexFunc(s.data.Groups, func(g Group) bool { return g.ID == id })
+}
+
+// checkMembersLocked refuses members that aren't provisioned users; the
+// caller must hold the mutex
+func (s *AccountStore) checkMembersLocked(members []string) error {
+	for _, member := range members {
+		if s.userIndexLocked(member) < 0 {
+			return fmt.Errorf("%w %s", ErrUnknownMember, member)
+		}
+	}
+	return nil
+}
+
+// saveLocked writes the accounts file; the caller must hold the mutex
+func (s *AccountStore) saveLocked(data accountFile) error {
+	encoded, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, encoded, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// newAccountID returns a random ID for a user or group
+func newAccountID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
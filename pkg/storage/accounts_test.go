@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccountStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	store, err := NewAccountStore(path)
+	if err != nil {
+		t.Fatalf("Expected account store to open, got %v", err)
+	}
+	alice, err := store.CreateUser(Account{UserName: "alice", Emails: []string{"alice@example.com"}, Active: true})
+	if err != nil {
+		t.Fatalf("Expected user to be created, got %v", err)
+	}
+	if _, err := store.CreateUser(Account{UserName: "alice"}); !errors.Is(err, ErrAccountExists) {
+		t.Errorf("Expected ErrAccountExists for a taken user name, got %v", err)
+	}
+	if _, err := store.CreateGroup(Group{DisplayName: "eng", Members: []string{"nobody"}}); !errors.Is(err, ErrUnknownMember) {
+		t.Errorf("Expected ErrUnknownMember, got %v", err)
+	}
+	group, err := store.CreateGroup(Group{DisplayName: "eng", Members: []string{alice.ID}})
+	if err != nil {
+		t.Fatalf("Expected group to be created, got %v", err)
+	}
+
+	// Accounts survive a restart and are found by email too
+	store, _ = NewAccountStore(path)
+	if groups := store.GroupsOf("alice@example.com"); len(groups) != 1 || groups[0] != "eng" {
+		t.Errorf("Expected Alice to be in eng, got %v", groups)
+	}
+	if store.Deprovisioned("alice") || store.Deprovisioned("stranger") {
+		t.Error("Expected active and unknown users not to be deprovisioned")
+	}
+
+	alice.Active = false
+	if _, err := store.ReplaceUser(alice.ID, alice); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Deprovisioned("alice@example.com") || store.GroupsOf("alice") != nil {
+		t.Error("Expected a deactivated user to be deprovisioned without groups")
+	}
+
+	if _, err := store.DeleteUser(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.User(alice.ID); !errors.Is(err, ErrAccountNotFound) || !store.Deprovisioned("alice") {
+		t.Errorf("Expected a deleted user to be gone but still locked out, got %v", err)
+	}
+	if group, _ = store.Group(group.ID); len(group.Members) != 0 {
+		t.Errorf("Expected the deleted user to leave their groups, got %v", group.Members)
+	}
+	if _, err := store.CreateUser(Account{UserName: "alice", Active: true}); err != nil || store.Deprovisioned("alice") {
+		t.Errorf("Expected a deleted user to be provisioned again, got %v", err)
+	}
+}
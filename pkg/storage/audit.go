@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// ErrAuditTampered is returned when the audit log's hash chain is broken
+var ErrAuditTampered = errors.New("audit log has been modified")
+
+// AuditLog appends audit entries to a JSON lines file. Each entry carries
+// the hash of the one before it, so changing or removing an entry breaks
+// the chain from there on
+type AuditLog struct {
+	path  string
+	file  *os.File
+	last  string
+	mutex sync.Mutex
+}
+
+// NewAuditLog opens the audit log at path, creating it if needed, and
+// continues its hash chain
+func NewAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating audit log directory: %w", err)
+	}
+	entries, err := ReadAuditLog(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	log := &AuditLog{path: path, file: file}
+	if len(entries) > 0 {
+		log.last = entries[len(entries)-1].Hash
+	}
+	util.Info("Audit log opened at %s with %d entries", path, len(entries))
+	return log, nil
+}
+
+// Append chains an entry to the log and writes it
+func (l *AuditLog) Append(entry signaling.AuditEntry) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry.Prev = l.last
+	entry.Hash = ""
+	hash, err := auditHash(entry)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	l.last = hash
+	return l.file.Sync()
+}
+
+// Entries reads and verifies the log
+func (l *AuditLog) Entries() ([]signaling.AuditEntry, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return ReadAuditLog(l.path)
+}
+
+// Close closes the log file
+func (l *AuditLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+// ReadAuditLog reads an audit log and verifies its hash chain. When the
+// chain is broken, the entries up to the break are returned with an error
+// wrapping ErrAuditTampered
+func ReadAuditLog(path string) ([]signaling.AuditEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []signaling.AuditEntry
+	prev := ""
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry signaling.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, fmt.Errorf("line %d: %w: %v", line, ErrAuditTampered, err)
+		}
+		hash := entry.Hash
+		entry.Hash = ""
+		expected, err := auditHash(entry)
+		if err != nil {
+			return entries, err
+		}
+		if entry.Prev != prev || hash != expected {
+			return entries, fmt.Errorf("line %d: %w", line, ErrAuditTampered)
+		}
+		entry.Hash = hash
+		entries = append(entries, entry)
+		prev = hash
+	}
+	return entries, scanner.Err()
+}
+
+// auditHash hashes an entry without its own hash
+func auditHash(entry signaling.AuditEntry) (string, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := NewAuditLog(path)
+	if err != nil {
+		t.Fatalf("Expected audit log to open, got %v", err)
+	}
+	log.Append(signaling.AuditEntry{Action: signaling.AuditRecordingStarted, RecordingID: "r1"})
+	log.Append(signaling.AuditEntry{Action: signaling.AuditRecordingStored, RecordingID: "r1", SHA256: "abc"})
+	log.Close()
+
+	// Reopening continues the chain
+	log, err = NewAuditLog(path)
+	if err != nil {
+		t.Fatalf("Expected audit log to reopen, got %v", err)
+	}
+	log.Append(signaling.AuditEntry{Action: signaling.AuditRecordingStarted, RecordingID: "r2"})
+	entries, err := log.Entries()
+	log.Close()
+	if err != nil || len(entries) != 3 || entries[2].Prev != entries[1].Hash {
+		t.Fatalf("Expected three chained entries, got %+v, %v", entries, err)
+	}
+
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(data), `"sha256":"abc"`, `"sha256":"abd"`, 1)), 0o600)
+	entries, err = ReadAuditLog(path)
+	if !errors.Is(err, ErrAuditTampered) || len(entries) != 1 {
+		t.Errorf("Expected tampering to be detected at the second entry, got %d entries, %v", len(entries), err)
+	}
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// File name suffixes of a room's events and snapshot
+const (
+	eventsSuffix   = ".events.jsonl"
+	snapshotSuffix = ".snapshot.json"
+)
+
+// FileEventLog keeps each room's events as an append-only JSON lines file
+// in a directory, next to a JSON file with the room's latest snapshot
+type FileEventLog struct {
+	dir   string
+	mutex sync.Mutex
+	seqs  map[string]int64 // Last sequence number of each room, read on first use
+}
+
+// NewFileEventLog creates an event log in dir, creating the directory if
+// needed
+func NewFileEventLog(dir string) (*FileEventLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating event log directory: %w", err)
+	}
+	util.Info("Event log initialized in %s", dir)
+	return &FileEventLog{dir: dir, seqs: make(map[string]int64)}, nil
+}
+
+// Append writes events to the files of their rooms, numbering them in order
+func (l *FileEventLog) Append(events []signaling.RoomEvent) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	lines := make(map[string][]byte)
+	var order []string
+	for i := range events {
+		roomID := events[i].RoomID
+		seq, err := l.lastSeqLocked(roomID)
+		if err != nil {
+			return err
+		}
+		events[i].Seq = seq + 1
+		data, err := json.Marshal(events[i])
+		if err != nil {
+			return err
+		}
+		if _, seen := lines[roomID]; !seen {
+			order = append(order, roomID)
+		}
+		lines[roomID] = append(append(lines[roomID], data...), '\n')
+		l.seqs[roomID] = seq + 1
+	}
+
+	for _, roomID := range order {
+		if err := appendFile(l.path(roomID, eventsSuffix), lines[roomID]); err != nil {
+			// Read the sequence back from the file next time
+			delete(l.seqs, roomID)
+			return err
+		}
+	}
+	return nil
+}
+
+// Events returns a room's events after a sequence number. A line cut short
+// by a crash is skipped
+func (l *FileEventLog) Events(roomID string, afterSeq int64) ([]signaling.RoomEvent, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.readLocked(roomID, afterSeq)
+}
+
+// SaveSnapshot replaces a room's snapshot
+func (l *FileEventLog) SaveSnapshot(view signaling.RoomView) error {
+	data, err := json.MarshalIndent(view, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Write to a temporary file first so a crash never leaves a half-written snapshot
+	path := l.path(view.RoomID, snapshotSuffix)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Snapshot returns a room's latest snapshot, or an empty view if it has none
+func (l *FileEventLog) Snapshot(roomID string) (signaling.RoomView, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var view signaling.RoomView
+	data, err := os.ReadFile(l.path(roomID, snapshotSuffix))
+	if os.IsNotExist(err) {
+		return view, nil
+	}
+	if err != nil {
+		return view, err
+	}
+	if err := json.Unmarshal(data, &view); err != nil {
+		return view, fmt.Errorf("reading snapshot of room %s: %w", roomID, err)
+	}
+	return view, nil
+}
+
+// RoomIDs lists the rooms that have events
+func (l *FileEventLog) RoomIDs() ([]string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var roomIDs []string
+	for _, entry := range entries {
+		name, found := strings.CutSuffix(entry.Name(), eventsSuffix)
+		if entry.IsDir() || !found {
+			continue
+		}
+		roomID, err := url.PathUnescape(name)
+		if err != nil {
+			util.Warn("Skipping event file with an invalid name: %s", entry.Name())
+			continue
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+	return roomIDs, nil
+}
+
+// lastSeqLocked returns the last sequence number written for a room; the
+// caller must hold the mutex. On first use, a line cut short by a crash is
+// cut off so the next event starts on a line of its own
+func (l *FileEventLog) lastSeqLocked(roomID string) (int64, error) {
+	if seq, cached := l.seqs[roomID]; cached {
+		return seq, nil
+	}
+	path := l.path(roomID, eventsSuffix)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		util.Warn("Cutting off a partial event of room %s", roomID)
+		if err := os.Truncate(path, int64(bytes.LastIndexByte(data, '\n')+1)); err != nil {
+			return 0, err
+		}
+	}
+	events, err := l.readLocked(roomID, 0)
+	if err != nil {
+		return 0, err
+	}
+	var seq int64
+	if len(events) > 0 {
+		seq = events[len(events)-1].Seq
+	}
+	l.seqs[roomID] = seq
+	return seq, nil
+}
+
+// readLocked reads a room's events after a sequence number; the caller must
+// hold the mutex
+func (l *FileEventLog) readLocked(roomID string, afterSeq int64) ([]signaling.RoomEvent, error) {
+	file, err := os.Open(l.path(roomID, eventsSuffix))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []signaling.RoomEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for scanner.Scan() {
+		var event signaling.RoomEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			util.Warn("Skipping invalid event of room %s: %v", roomID, err)
+			continue
+		}
+		if event.Seq > afterSeq {
+			events = append(events, event)
+		}
+	}
+	return events, scanner.Err()
+}
+
+// path returns a file of a room; IDs are escaped so they can't leave the
+// directory
+func (l *FileEventLog) path(roomID, suffix string) string {
+	return filepath.Join(l.dir, url.PathEscape(roomID)+suffix)
+}
+
+// appendFile appends data to a file, creating it if needed, and syncs it
+func appendFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
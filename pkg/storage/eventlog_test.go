@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestFileEventLog(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewFileEventLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []signaling.RoomEvent{
+		{RoomID: "team/standup", Type: signaling.RoomEventState, State: signaling.RoomCreated},
+		{RoomID: "team/standup", Type: signaling.RoomEventHost, HostID: "alice"},
+		{RoomID: "all-hands", Type: signaling.RoomEventState, State: signaling.RoomCreated},
+	}
+	if err := log.Append(events); err != nil {
+		t.Fatal(err)
+	}
+	if events[1].Seq != 2 || events[2].Seq != 1 {
+		t.Errorf("Expected sequence numbers per room, got %+v", events)
+	}
+	if err := log.SaveSnapshot(signaling.RoomView{RoomID: "team/standup", Seq: 1, State: signaling.RoomCreated}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reopened log continues each room's sequence and skips a line cut
+	// short by a crash
+	file, _ := os.OpenFile(filepath.Join(dir, "team%2Fstandup.events.jsonl"), os.O_WRONLY|os.O_APPEND, 0o644)
+	file.WriteString(`{"seq": 3, "roomId": "team/st`)
+	file.Close()
+	log, _ = NewFileEventLog(dir)
+	more := []signaling.RoomEvent{{RoomID: "team/standup", Type: signaling.RoomEventState, State: signaling.RoomClosed}}
+	if err := log.Append(more); err != nil || more[0].Seq != 3 {
+		t.Fatalf("Expected the next sequence number 3, got %d: %v", more[0].Seq, err)
+	}
+
+	view, err := signaling.ReplayRoom(log, "team/standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Seq != 3 || view.State != signaling.RoomClosed || view.HostID != "alice" {
+		t.Errorf("Expected the snapshot plus later events, got %+v", view)
+	}
+	roomIDs, _ := log.RoomIDs()
+	if len(roomIDs) != 2 || roomIDs[0] != "all-hands" || roomIDs[1] != "team/standup" {
+		t.Errorf("Expected both rooms, got %v", roomIDs)
+	}
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// FileStore keeps persistent rooms as one JSON file per room in a directory
+type FileStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewFileStore creates a store in dir, creating the directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating room store directory: %w", err)
+	}
+	util.Info("Room store initialized in %s", dir)
+	return &FileStore{dir: dir}, nil
+}
+
+// SaveRoom writes a room snapshot, replacing any previous version
+func (s *FileStore) SaveRoom(snapshot signaling.RoomSnapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Write to a temporary file first so a crash never leaves a half-written room
+	path := s.path(snapshot.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// DeleteRoom removes a room snapshot; deleting a missing room is not an error
+func (s *FileStore) DeleteRoom(roomID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.Remove(s.path(roomID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// LoadRooms reads all room snapshots. Unreadable files are logged and skipped
+func (s *FileStore) LoadRooms() ([]signaling.RoomSnapshot, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]signaling.RoomSnapshot, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			util.Warn("Skipping unreadable room file %s: %v", entry.Name(), err)
+			continue
+		}
+
+		var snapshot signaling.RoomSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			util.Warn("Skipping invalid room file %s: %v", entry.Name(), err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// path returns the file for a room; IDs are escaped so they can't leave the directory
+func (s *FileStore) path(roomID string) string {
+	return filepath.Join(s.dir, url.PathEscape(roomID)+".json")
+}
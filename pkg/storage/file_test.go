@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected store to be created, got %v", err)
+	}
+
+	settings := signaling.DefaultRoomSettings()
+	settings.Persistent = true
+	snapshot := signaling.RoomSnapshot{ID: "team/standup", Settings: settings, HostID: "alice"}
+	if err := store.SaveRoom(snapshot); err != nil {
+		t.Fatalf("Expected save to succeed, got %v", err)
+	}
+
+	rooms, err := store.LoadRooms()
+	if err != nil {
+		t.Fatalf("Expected load to succeed, got %v", err)
+	}
+	if len(rooms) != 1 || rooms[0].ID != "team/standup" || rooms[0].HostID != "alice" {
+		t.Errorf("Expected the saved room back, got %+v", rooms)
+	}
+
+	if err := store.DeleteRoom("team/standup"); err != nil {
+		t.Fatalf("Expected delete to succeed, got %v", err)
+	}
+	if err := store.DeleteRoom("team/standup"); err != nil {
+		t.Errorf("Expected deleting a missing room to succeed, got %v", err)
+	}
+	if rooms, _ := store.LoadRooms(); len(rooms) != 0 {
+		t.Errorf("Expected no rooms after delete, got %d", len(rooms))
+	}
+}
+
+func TestPersistentRoomSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStore(dir)
+
+	hub := signaling.NewHub()
+	hub.SetRoomStore(store)
+	room := hub.GetRoomWithSettings("team", func(s *signaling.RoomSettings) { s.Persistent = true })
+	room.AddClient(&signaling.Client{ID: "alice"})
+	room.RemoveClient("alice")
+	hub.RemoveRoom("team")
+
+	// The empty persistent room stays registered
+	if len(hub.GetActiveRooms()) != 1 {
+		t.Fatal("Expected the persistent room to be kept when empty")
+	}
+
+	// A new hub restores it from disk with its host designation
+	restarted := signaling.NewHub()
+	restarted.SetRoomStore(store)
+	if n, err := restarted.RestoreRooms(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 restored room, got %d (%v)", n, err)
+	}
+	restored := restarted.GetRoom("team")
+	if !restored.Settings().Persistent || restored.GetHost() != "alice" {
+		t.Errorf("Expected persistent room hosted by alice, got %+v host %s", restored.Settings(), restored.GetHost())
+	}
+
+	// Closing the room deletes it from the store
+	restarted.CloseRoom("team", "done")
+	if rooms, _ := store.LoadRooms(); len(rooms) != 0 {
+		t.Errorf("Expected closed room to be deleted from the store, got %d rooms", len(rooms))
+	}
+}
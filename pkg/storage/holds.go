@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// What a legal hold applies to
+const (
+	HoldUser = "user"
+	HoldRoom = "room"
+)
+
+// ErrHoldNotFound is returned for unknown hold IDs
+var ErrHoldNotFound = errors.New("legal hold not found")
+
+// LegalHold keeps everything recorded about a user or room, exempting it
+// from retention purging and deletion until the hold is released
+type LegalHold struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+
+	// User ID (or client ID for guests) or room ID on hold
+	Target string `json:"target"`
+
+	// Matter or case the hold is for
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// HoldStore keeps legal holds in a JSON file
+type HoldStore struct {
+	path  string
+	holds []LegalHold
+	mutex sync.Mutex
+}
+
+// NewHoldStore opens the holds file at path, creating it if needed
+func NewHoldStore(path string) (*HoldStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating legal hold directory: %w", err)
+	}
+	store := &HoldStore{path: path, holds: []LegalHold{}}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &store.holds); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+	util.Info("Legal holds loaded from %s: %d active", path, len(store.holds))
+	return store, nil
+}
+
+// Place puts a user or room on hold and returns the new hold
+func (s *HoldStore) Place(kind, target, reason string) (LegalHold, error) {
+	if kind != HoldUser && kind != HoldRoom {
+		return LegalHold{}, fmt.Errorf("unknown hold kind %q", kind)
+	}
+	if target == "" {
+		return LegalHold{}, errors.New("hold target is required")
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	hold := LegalHold{
+		ID:        hex.EncodeToString(id),
+		Kind:      kind,
+		Target:    target,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	holds := append(slices.Clone(s.holds), hold)
+	if err := s.saveLocked(holds); err != nil {
+		return LegalHold{}, err
+	}
+	s.holds = holds
+	return hold, nil
+}
+
+// Release lifts a hold and returns it
+func (s *HoldStore) Release(id string) (LegalHold, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := slices.IndexFunc(s.holds, func(hold LegalHold) bool { return hold.ID == id })
+	if i < 0 {
+		return LegalHold{}, ErrHoldNotFound
+	}
+	hold := s.holds[i]
+	holds := slices.Delete(slices.Clone(s.holds), i, i+1)
+	if err := s.saveLocked(holds); err != nil {
+		return LegalHold{}, err
+	}
+	s.holds = holds
+	return hold, nil
+}
+
+// Holds returns the active holds
+func (s *HoldStore) Holds() []LegalHold {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.holds)
+}
+
+// Covers returns the hold, if any, that applies to something recorded in a
+// room about the given users. A nil store holds nothing
+func (s *HoldStore) Covers(roomID string, userIDs ...string) (LegalHold, bool) {
+	if s == nil {
+		return LegalHold{}, false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, hold := range s.holds {
+		switch hold.Kind {
+		case HoldRoom:
+			if hold.Target == roomID {
+				return hold, true
+			}
+		case HoldUser:
+			if slices.Contains(userIDs, hold.Target) {
+				return hold, true
+			}
+		}
+	}
+	return LegalHold{}, false
+}
+
+// CoversRecording returns the hold, if any, on a recording's room or participant
+func (s *HoldStore) CoversRecording(recording signaling.Recording) (LegalHold, bool) {
+	return s.Covers(recording.RoomID, recording.ClientID, recording.UserID)
+}
+
+// CoversMeeting returns the hold, if any, on a meeting's room or one of its participants
+func (s *HoldStore) CoversMeeting(meeting signaling.Meeting) (LegalHold, bool) {
+	var users []string
+	for _, participant := range meeting.Participants {
+		users = append(users, participant.ClientID)
+		if participant.UserID != "" {
+			users = append(users, participant.UserID)
+		}
+	}
+	return s.Covers(meeting.RoomID, users...)
+}
+
+// saveLocked writes the holds file; the caller must hold the mutex
+func (s *HoldStore) saveLocked(holds []LegalHold) error {
+	data, err := json.MarshalIndent(holds, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestHoldStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holds.json")
+	store, err := NewHoldStore(path)
+	if err != nil {
+		t.Fatalf("Expected hold store to open, got %v", err)
+	}
+	if _, err := store.Place("tenant", "x", ""); err == nil {
+		t.Error("Expected an unknown hold kind to be rejected")
+	}
+	hold, err := store.Place(HoldUser, "alice@example.com", "Case 2026-17")
+	if err != nil {
+		t.Fatalf("Expected hold to be placed, got %v", err)
+	}
+
+	// Holds survive a restart
+	store, _ = NewHoldStore(path)
+	if _, held := store.CoversMeeting(signaling.Meeting{RoomID: "r", Participants: []signaling.MeetingParticipant{
+		{ClientID: "c1", UserID: "alice@example.com"},
+	}}); !held {
+		t.Error("Expected a meeting with the held user to be covered")
+	}
+	if _, held := store.CoversRecording(signaling.Recording{RoomID: "r", ClientID: "bob"}); held {
+		t.Error("Expected another user's recording not to be covered")
+	}
+
+	if _, err := store.Release(hold.ID); err != nil {
+		t.Fatalf("Expected hold to be released, got %v", err)
+	}
+	if _, err := store.Release(hold.ID); err != ErrHoldNotFound {
+		t.Errorf("Expected ErrHoldNotFound, got %v", err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	dir := t.TempDir()
+	recordings, _ := NewRecordingStore(filepath.Join(dir, "recordings"))
+	meetings, _ := NewMeetingStore(filepath.Join(dir, "meetings"))
+	holds, _ := NewHoldStore(filepath.Join(dir, "holds.json"))
+	holds.Place(HoldRoom, "board", "")
+
+	old := time.Now().Add(-48 * time.Hour)
+	recordings.SaveRecording(signaling.Recording{ID: "old", RoomID: "standup", CreatedAt: old})
+	recordings.SaveRecording(signaling.Recording{ID: "held", RoomID: "board", CreatedAt: old})
+	recordings.SaveRecording(signaling.Recording{ID: "new", RoomID: "standup", CreatedAt: time.Now()})
+	meetings.SaveMeeting(signaling.Meeting{ID: "m1", RoomID: "standup", StartedAt: old, EndedAt: old})
+	meetings.SaveMeeting(signaling.Meeting{ID: "m2", RoomID: "standup", StartedAt: old})
+
+	retention := &Retention{MaxAge: 24 * time.Hour, Recordings: recordings, Meetings: meetings, Holds: holds}
+	purged, held, err := retention.Purge(time.Now())
+	if err != nil || purged != 2 || held != 1 {
+		t.Fatalf("Expected 2 purged and 1 held, got %d, %d, %v", purged, held, err)
+	}
+	if left, _ := recordings.Recordings(""); len(left) != 2 {
+		t.Errorf("Expected the held and new recordings to remain, got %+v", left)
+	}
+	if _, err := meetings.Meeting("m2"); err != nil {
+		t.Errorf("Expected the running meeting to remain, got %v", err)
+	}
+}
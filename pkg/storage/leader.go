@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/redis"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// LeaderElection elects the node that runs the cluster's singleton jobs,
+// such as retention purges, through a lease in Redis under <prefix>leader.
+// The leader renews the lease every third of its TTL. When it dies the lease
+// expires and the next node to campaign takes over
+type LeaderElection struct {
+	client *redis.Client
+	key    string
+	node   string
+	ttl    time.Duration
+
+	mutex  sync.Mutex
+	jobs   []func(ctx context.Context)
+	leader bool
+	cancel context.CancelFunc // Stops the jobs once leadership is lost
+}
+
+// NewLeaderElection creates the election of a node with leases lasting ttl
+func NewLeaderElection(client *redis.Client, prefix, node string, ttl time.Duration) *LeaderElection {
+	return &LeaderElection{client: client, key: prefix + "leader", node: node, ttl: ttl}
+}
+
+// OnElected adds a job run while this node leads. Its context is cancelled
+// when the node loses the lease. It must be called before Run
+func (e *LeaderElection) OnElected(job func(ctx context.Context)) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.jobs = append(e.jobs, job)
+}
+
+// Run campaigns for the lease until the context is cancelled, then gives it
+// up so another node takes over right away
+func (e *LeaderElection) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		e.campaign()
+		select {
+		case <-ctx.Done():
+			e.resign()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// IsLeader reports whether this node holds the lease
+func (e *LeaderElection) IsLeader() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.leader
+}
+
+// Leader returns the node holding the lease, or "" when nobody does
+func (e *LeaderElection) Leader() (string, error) {
+	reply, err := e.client.Do("GET", e.key)
+	if err != nil || reply == nil {
+		return "", err
+	}
+	node, _ := reply.(string)
+	return node, nil
+}
+
+// campaign renews the lease this node holds or takes it when it is free,
+// and starts or stops the jobs when leadership changes. A node that can't
+// reach Redis steps down, since its lease may expire meanwhile
+func (e *LeaderElection) campaign() {
+	leader, err := e.holdLease()
+	if err != nil {
+		util.Warn("Error campaigning for leadership: %v", err)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	switch {
+	case leader && !e.leader:
+		util.Info("Node %s elected leader, running %d singleton jobs", e.node, len(e.jobs))
+		ctx, cancel := context.WithCancel(context.Background())
+		e.cancel = cancel
+		for _, job := range e.jobs {
+			go job(ctx)
+		}
+	case !leader && e.leader:
+		util.Warn("Node %s lost leadership, stopping its singleton jobs", e.node)
+		e.cancel()
+		e.cancel = nil
+	}
+	e.leader = leader
+}
+
+// holdLease reports whether this node holds the lease after renewing or
+// taking it
+func (e *LeaderElection) holdLease() (bool, error) {
+	ttl := strconv.FormatInt(e.ttl.Milliseconds(), 10)
+	holder, err := e.Leader()
+	if err != nil {
+		return false, err
+	}
+	if holder == e.node {
+		if _, err := e.client.Do("PEXPIRE", e.key, ttl); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if holder != "" {
+		return false, nil
+	}
+	reply, err := e.client.Do("SET", e.key, e.node, "NX", "PX", ttl)
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+// resign stops the jobs and frees the lease if this node holds it
+func (e *LeaderElection) resign() {
+	e.mutex.Lock()
+	leader := e.leader
+	if leader {
+		e.cancel()
+		e.cancel = nil
+		e.leader = false
+	}
+	e.mutex.Unlock()
+
+	if !leader {
+		return
+	}
+	if holder, err := e.Leader(); err == nil && holder == e.node {
+		if _, err := e.client.Do("DEL", e.key); err != nil {
+			util.Warn("Error giving up leadership: %v", err)
+		}
+	}
+}
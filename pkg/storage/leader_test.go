@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/redis"
+)
+
+func TestLeaderElection(t *testing.T) {
+	addr := startFakeRedis(t)
+	client, err := redis.New(redis.Options{Addr: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	running := make(chan string, 4)
+	elections := map[string]*LeaderElection{}
+	for _, node := range []string{"node-a", "node-b"} {
+		election := NewLeaderElection(client, "test:", node, time.Minute)
+		election.OnElected(func(ctx context.Context) {
+			running <- node + " started"
+			<-ctx.Done()
+			running <- node + " stopped"
+		})
+		elections[node] = election
+	}
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-running:
+			if got != want {
+				t.Errorf("Expected %q, got %q", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %q", want)
+		}
+	}
+
+	// The first node to campaign leads and runs the jobs; the other waits
+	elections["node-a"].campaign()
+	elections["node-b"].campaign()
+	expect("node-a started")
+	if !elections["node-a"].IsLeader() || elections["node-b"].IsLeader() {
+		t.Fatal("Expected node-a alone to lead")
+	}
+	elections["node-a"].campaign()
+	if leader, _ := elections["node-b"].Leader(); leader != "node-a" {
+		t.Errorf("Expected node-a to keep the lease, got %q", leader)
+	}
+
+	// Node-a's lease expires; node-b takes over and node-a stops its jobs
+	client.Do("DEL", "test:leader")
+	elections["node-b"].campaign()
+	expect("node-b started")
+	elections["node-a"].campaign()
+	expect("node-a stopped")
+	if elections["node-a"].IsLeader() || !elections["node-b"].IsLeader() {
+		t.Error("Expected node-b to lead after failover")
+	}
+
+	// A leader shutting down frees the lease
+	elections["node-b"].resign()
+	expect("node-b stopped")
+	if leader, _ := elections["node-a"].Leader(); leader != "" {
+		t.Errorf("Expected the lease to be free, got %q", leader)
+	}
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// MeetingStore keeps meeting records as one JSON file per meeting in a directory
+type MeetingStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewMeetingStore creates a store in dir, creating the directory if needed
+func NewMeetingStore(dir string) (*MeetingStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating meeting directory: %w", err)
+	}
+	util.Info("Meeting store initialized in %s", dir)
+	return &MeetingStore{dir: dir}, nil
+}
+
+// SaveMeeting writes a meeting record, replacing any previous version
+func (s *MeetingStore) SaveMeeting(meeting signaling.Meeting) error {
+	if !validID(meeting.ID) {
+		return fmt.Errorf("invalid meeting ID %q", meeting.ID)
+	}
+	data, err := json.MarshalIndent(meeting, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path := filepath.Join(s.dir, meeting.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Meeting returns a meeting record
+func (s *MeetingStore) Meeting(id string) (signaling.Meeting, error) {
+	var meeting signaling.Meeting
+	if !validID(id) {
+		return meeting, signaling.ErrMeetingNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, id+".json"))
+	if os.IsNotExist(err) {
+		return meeting, signaling.ErrMeetingNotFound
+	}
+	if err != nil {
+		return meeting, err
+	}
+	err = json.Unmarshal(data, &meeting)
+	return meeting, err
+}
+
+// DeleteMeeting removes a meeting record; deleting a missing meeting is not an error
+func (s *MeetingStore) DeleteMeeting(id string) error {
+	if !validID(id) {
+		return signaling.ErrMeetingNotFound
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.Remove(filepath.Join(s.dir, id+".json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Meetings returns the meetings of a room, or of all rooms when roomID is
+// empty, oldest first
+func (s *MeetingStore) Meetings(roomID string) ([]signaling.Meeting, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	meetings := []signaling.Meeting{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		meeting, err := s.Meeting(id)
+		if err != nil {
+			util.Warn("Skipping invalid meeting file %s: %v", entry.Name(), err)
+			continue
+		}
+		if roomID == "" || meeting.RoomID == roomID {
+			meetings = append(meetings, meeting)
+		}
+	}
+	sort.Slice(meetings, func(i, j int) bool {
+		return meetings[i].StartedAt.Before(meetings[j].StartedAt)
+	})
+	return meetings, nil
+}
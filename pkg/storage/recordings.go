@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// ErrArtifactTooLarge is returned when an uploaded artifact exceeds the limit
+var ErrArtifactTooLarge = errors.New("artifact is too large")
+
+// RecordingStore keeps recording metadata and artifacts in a directory, as
+// <id>.json next to the artifact file
+type RecordingStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewRecordingStore creates a store in dir, creating the directory if needed
+func NewRecordingStore(dir string) (*RecordingStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating recording directory: %w", err)
+	}
+	util.Info("Recording store initialized in %s", dir)
+	return &RecordingStore{dir: dir}, nil
+}
+
+// SaveRecording writes recording metadata, replacing any previous version
+func (s *RecordingStore) SaveRecording(recording signaling.Recording) error {
+	if !validID(recording.ID) {
+		return fmt.Errorf("invalid recording ID %q", recording.ID)
+	}
+	data, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path := filepath.Join(s.dir, recording.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Recording returns the metadata of a recording
+func (s *RecordingStore) Recording(id string) (signaling.Recording, error) {
+	var recording signaling.Recording
+	if !validID(id) {
+		return recording, signaling.ErrRecordingNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, id+".json"))
+	if os.IsNotExist(err) {
+		return recording, signaling.ErrRecordingNotFound
+	}
+	if err != nil {
+		return recording, err
+	}
+	err = json.Unmarshal(data, &recording)
+	return recording, err
+}
+
+// DeleteRecording removes a recording's metadata and artifact
+func (s *RecordingStore) DeleteRecording(recording signaling.Recording) error {
+	if !validID(recording.ID) {
+		return signaling.ErrRecordingNotFound
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if recording.File != "" && filepath.Base(recording.File) == recording.File {
+		if err := os.Remove(filepath.Join(s.dir, recording.File)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(s.dir, recording.ID+".json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Recordings returns the recordings of a room, or of all rooms when roomID
+// is empty, oldest first
+func (s *RecordingStore) Recordings(roomID string) ([]signaling.Recording, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := []signaling.Recording{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		recording, err := s.Recording(id)
+		if err != nil {
+			util.Warn("Skipping invalid recording file %s: %v", entry.Name(), err)
+			continue
+		}
+		if roomID == "" || recording.RoomID == roomID {
+			recordings = append(recordings, recording)
+		}
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].CreatedAt.Before(recordings[j].CreatedAt)
+	})
+	return recordings, nil
+}
+
+// WriteArtifact stores the uploaded media of a recording, reading at most
+// limit bytes, and returns the file name and size
+func (s *RecordingStore) WriteArtifact(id, contentType string, r io.Reader, limit int64) (signaling.Artifact, error) {
+	if !validID(id) {
+		return signaling.Artifact{}, signaling.ErrRecordingNotFound
+	}
+	name := id + artifactExtension(contentType)
+	path := filepath.Join(s.dir, name)
+	tmp := path + ".tmp"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return signaling.Artifact{}, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(r, limit+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > limit {
+		err = ErrArtifactTooLarge
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return signaling.Artifact{}, err
+	}
+	artifact := signaling.Artifact{
+		File:        name,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+	}
+	return artifact, os.Rename(tmp, path)
+}
+
+// TrackDir returns the directory the server writes the tracks of a call
+// recording to until they are packed into its artifact, <id>.tracks
+func (s *RecordingStore) TrackDir(id string) (string, error) {
+	if !validID(id) {
+		return "", signaling.ErrRecordingNotFound
+	}
+	return filepath.Join(s.dir, id+".tracks"), nil
+}
+
+// OpenArtifact opens the stored media of a recording
+func (s *RecordingStore) OpenArtifact(recording signaling.Recording) (*os.File, error) {
+	if recording.File == "" || filepath.Base(recording.File) != recording.File {
+		return nil, signaling.ErrRecordingNotFound
+	}
+	return os.Open(filepath.Join(s.dir, recording.File))
+}
+
+// artifactExtension picks a file extension for an uploaded media type
+func artifactExtension(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "video/webm", "audio/webm":
+		return ".webm"
+	case "video/mp4", "audio/mp4":
+		return ".mp4"
+	case "application/zip":
+		return ".zip"
+	default:
+		return ".bin"
+	}
+}
+
+// validID reports whether a recording ID is safe to use as a file name
+func validID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
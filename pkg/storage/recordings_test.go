@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestRecordingStore(t *testing.T) {
+	store, err := NewRecordingStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected store to be created, got %v", err)
+	}
+
+	recording := signaling.Recording{ID: "abc123", RoomID: "demo", Kind: signaling.RecordingParticipant, Status: signaling.RecordingStopped}
+	if err := store.SaveRecording(recording); err != nil {
+		t.Fatalf("Expected save to succeed, got %v", err)
+	}
+	if err := store.SaveRecording(signaling.Recording{ID: "../escape"}); err == nil {
+		t.Error("Expected an ID with a path to be rejected")
+	}
+
+	if _, err := store.WriteArtifact(recording.ID, "video/webm", strings.NewReader("too long"), 4); !errors.Is(err, ErrArtifactTooLarge) {
+		t.Errorf("Expected oversized artifact to be rejected, got %v", err)
+	}
+	artifact, err := store.WriteArtifact(recording.ID, "video/webm;codecs=vp8", strings.NewReader("webm"), 4)
+	if err != nil || artifact.File != "abc123.webm" || artifact.Size != 4 {
+		t.Fatalf("Expected abc123.webm of 4 bytes, got %+v, %v", artifact, err)
+	}
+	if artifact.SHA256 != "ed55d4a2399b114cff437979c536b15ce6a641448b3ceb33a68377451692dac2" {
+		t.Errorf("Expected the SHA-256 of the artifact, got %s", artifact.SHA256)
+	}
+	recording.File = artifact.File
+	recording.Status = signaling.RecordingAvailable
+	store.SaveRecording(recording)
+
+	recordings, err := store.Recordings("demo")
+	if err != nil || len(recordings) != 1 || recordings[0].File != artifact.File {
+		t.Fatalf("Expected the recording back, got %+v, %v", recordings, err)
+	}
+	if recordings, _ := store.Recordings("other"); len(recordings) != 0 {
+		t.Errorf("Expected no recordings for another room, got %d", len(recordings))
+	}
+
+	opened, err := store.OpenArtifact(recordings[0])
+	if err != nil {
+		t.Fatalf("Expected artifact to open, got %v", err)
+	}
+	defer opened.Close()
+	if data, _ := io.ReadAll(opened); string(data) != "webm" {
+		t.Errorf("Expected artifact contents, got %q", data)
+	}
+	if _, err := store.Recording("missing"); !errors.Is(err, signaling.ErrRecordingNotFound) {
+		t.Errorf("Expected a missing recording to be reported, got %v", err)
+	}
+}
+
+func TestMeetingStore(t *testing.T) {
+	store, err := NewMeetingStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected store to be created, got %v", err)
+	}
+
+	start := time.Now()
+	store.SaveMeeting(signaling.Meeting{ID: "m2", RoomID: "demo", StartedAt: start.Add(time.Hour)})
+	store.SaveMeeting(signaling.Meeting{ID: "m1", RoomID: "demo", StartedAt: start})
+	store.SaveMeeting(signaling.Meeting{ID: "m3", RoomID: "other", StartedAt: start})
+
+	meetings, err := store.Meetings("demo")
+	if err != nil || len(meetings) != 2 || meetings[0].ID != "m1" {
+		t.Fatalf("Expected demo's meetings oldest first, got %+v, %v", meetings, err)
+	}
+	if _, err := store.Meeting("missing"); !errors.Is(err, signaling.ErrMeetingNotFound) {
+		t.Errorf("Expected ErrMeetingNotFound, got %v", err)
+	}
+}
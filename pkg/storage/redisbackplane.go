@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/redis"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// How long to wait before resubscribing after the connection drops
+const backplaneRetryDelay = time.Second
+
+// How long a moderation command ID stays claimed, so retries are recognized
+const commandClaimTTL = 24 * time.Hour
+
+// RedisBackplane relays room traffic between nodes over Redis pub/sub, with
+// one channel per room, <prefix>room-traffic:<roomId>, one per node for
+// messages routed to it, <prefix>node-traffic:<node>, and <prefix>cluster for
+// heartbeats
+type RedisBackplane struct {
+	client *redis.Client
+	prefix string
+	node   string
+
+	mutex   sync.Mutex
+	rooms   map[string]bool
+	sub     *redis.Subscription
+	handler func(roomID string, payload []byte)
+	closed  bool
+}
+
+// NewRedisBackplane creates the backplane of a node, publishing through
+// client. It subscribes on a connection of its own once a handler is set
+func NewRedisBackplane(client *redis.Client, prefix, node string) *RedisBackplane {
+	return &RedisBackplane{client: client, prefix: prefix, node: node, rooms: make(map[string]bool)}
+}
+
+// Publish sends a payload to the nodes following a room
+func (b *RedisBackplane) Publish(roomID string, payload []byte) error {
+	_, err := b.client.Do("PUBLISH", b.channel(roomID), string(payload))
+	return err
+}
+
+// PublishNode sends a payload to one node
+func (b *RedisBackplane) PublishNode(node string, payload []byte) error {
+	_, err := b.client.Do("PUBLISH", b.prefix+"node-traffic:"+node, string(payload))
+	return err
+}
+
+// PublishCluster sends a payload to every node
+func (b *RedisBackplane) PublishCluster(payload []byte) error {
+	_, err := b.client.Do("PUBLISH", b.clusterChannel(), string(payload))
+	return err
+}
+
+// Subscribe starts following a room
+func (b *RedisBackplane) Subscribe(roomID string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.rooms[roomID] = true
+	if b.sub == nil {
+		return nil // Subscribed when the connection is up
+	}
+	return b.sub.Subscribe(b.channel(roomID))
+}
+
+// Unsubscribe stops following a room
+func (b *RedisBackplane) Unsubscribe(roomID string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.rooms, roomID)
+	if b.sub == nil {
+		return nil
+	}
+	return b.sub.Unsubscribe(b.channel(roomID))
+}
+
+// OnMessage sets the handler of payloads for followed rooms and starts
+// receiving them
+func (b *RedisBackplane) OnMessage(handler func(roomID string, payload []byte)) {
+	b.mutex.Lock()
+	start := b.handler == nil
+	b.handler = handler
+	b.mutex.Unlock()
+	if start {
+		go b.receive()
+	}
+}
+
+// Sequence claims a moderation command ID with SET NX and numbers the
+// command with INCR on <prefix>room-commands:<roomId>. Claims expire after
+// commandClaimTTL
+func (b *RedisBackplane) Sequence(roomID, commandID string) (int64, bool, error) {
+	claim := b.prefix + "room-command:" + roomID + ":" + commandID
+	if seq, claimed, err := b.claimedSeq(claim); err != nil || claimed {
+		return seq, false, err
+	}
+	reply, err := b.client.Do("INCR", b.prefix+"room-commands:"+roomID)
+	if err != nil {
+		return 0, false, err
+	}
+	seq, _ := reply.(int64)
+	reply, err = b.client.Do("SET", claim, strconv.FormatInt(seq, 10), "NX", "EX", strconv.Itoa(int(commandClaimTTL.Seconds())))
+	if err != nil {
+		return 0, false, err
+	}
+	if reply == nil {
+		// Another node claimed the ID in the meantime; the number taken
+		// here is skipped by the nodes once they stop waiting for it
+		seq, _, err := b.claimedSeq(claim)
+		return seq, false, err
+	}
+	return seq, true, nil
+}
+
+// claimedSeq returns the sequence number of a claimed command ID
+func (b *RedisBackplane) claimedSeq(claim string) (int64, bool, error) {
+	reply, err := b.client.Do("GET", claim)
+	if err != nil || reply == nil {
+		return 0, false, err
+	}
+	value, _ := reply.(string)
+	seq, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid sequence number of %s: %w", claim, err)
+	}
+	return seq, true, nil
+}
+
+// Close stops receiving
+func (b *RedisBackplane) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	if b.sub != nil {
+		return b.sub.Close()
+	}
+	return nil
+}
+
+// receive subscribes to the followed rooms and passes their payloads to the
+// handler, resubscribing whenever the connection drops
+func (b *RedisBackplane) receive() {
+	for {
+		sub, err := b.connect()
+		if sub == nil && err == nil {
+			return // Closed
+		}
+		if err != nil {
+			util.Warn("Error subscribing to the backplane: %v", err)
+			time.Sleep(backplaneRetryDelay)
+			continue
+		}
+
+		for {
+			channel, payload, err := sub.Receive()
+			if err != nil {
+				break
+			}
+			roomID, found := strings.CutPrefix(channel, b.prefix+"room-traffic:")
+			if channel == b.nodeChannel() || channel == b.clusterChannel() {
+				roomID = "" // Routed to this node, or for every node
+			} else if !found {
+				continue
+			}
+			b.mutex.Lock()
+			handler := b.handler
+			b.mutex.Unlock()
+			handler(roomID, []byte(payload))
+		}
+
+		b.mutex.Lock()
+		closed := b.closed
+		b.sub = nil
+		b.mutex.Unlock()
+		sub.Close()
+		if closed {
+			return
+		}
+		util.Warn("Backplane connection lost, resubscribing")
+		time.Sleep(backplaneRetryDelay)
+	}
+}
+
+// connect opens a subscription to the followed rooms, or returns nil once
+// the backplane is closed
+func (b *RedisBackplane) connect() (*redis.Subscription, error) {
+	sub, err := redis.Subscribe(b.client.Options())
+	if err != nil {
+		return nil, err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		sub.Close()
+		return nil, nil
+	}
+	channels := []string{b.nodeChannel(), b.clusterChannel()}
+	for roomID := range b.rooms {
+		channels = append(channels, b.channel(roomID))
+	}
+	if err := sub.Subscribe(channels...); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	b.sub = sub
+	return sub, nil
+}
+
+// nodeChannel returns the pub/sub channel of messages routed to this node
+func (b *RedisBackplane) nodeChannel() string {
+	return b.prefix + "node-traffic:" + b.node
+}
+
+// clusterChannel returns the pub/sub channel every node follows
+func (b *RedisBackplane) clusterChannel() string {
+	return b.prefix + "cluster"
+}
+
+// channel returns the pub/sub channel of a room
+func (b *RedisBackplane) channel(roomID string) string {
+	return b.prefix + "room-traffic:" + roomID
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/redis"
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+// RedisStateStore shares room membership and state between nodes through
+// Redis. Each room is a JSON string under <prefix>room:<id>, listed in the
+// set <prefix>rooms, and its members are a hash <prefix>members:<id> of
+// JSON values by client ID
+type RedisStateStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStateStore creates a state store using keys starting with prefix
+func NewRedisStateStore(client *redis.Client, prefix string) *RedisStateStore {
+	return &RedisStateStore{client: client, prefix: prefix}
+}
+
+// PutRoom records a room's state
+func (s *RedisStateStore) PutRoom(record signaling.RoomRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.Do("SET", s.prefix+"room:"+record.ID, string(data)); err != nil {
+		return err
+	}
+	_, err = s.client.Do("SADD", s.prefix+"rooms", record.ID)
+	return err
+}
+
+// DeleteRoom forgets a room and its members
+func (s *RedisStateStore) DeleteRoom(roomID string) error {
+	if _, err := s.client.Do("DEL", s.prefix+"room:"+roomID, s.prefix+"members:"+roomID); err != nil {
+		return err
+	}
+	_, err := s.client.Do("SREM", s.prefix+"rooms", roomID)
+	return err
+}
+
+// Rooms returns all recorded rooms, ordered by ID
+func (s *RedisStateStore) Rooms() ([]signaling.RoomRecord, error) {
+	ids, err := s.client.Strings("SMEMBERS", s.prefix+"rooms")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	records := make([]signaling.RoomRecord, 0, len(ids))
+	for _, id := range ids {
+		reply, err := s.client.Do("GET", s.prefix+"room:"+id)
+		if err != nil {
+			return nil, err
+		}
+		data, ok := reply.(string)
+		if !ok {
+			continue // Deleted since SMEMBERS
+		}
+		var record signaling.RoomRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// AddMember records a connection in a room, replacing one with the same ID
+func (s *RedisStateStore) AddMember(roomID string, member signaling.Member) error {
+	data, err := json.Marshal(member)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do("HSET", s.prefix+"members:"+roomID, member.ClientID, string(data))
+	return err
+}
+
+// RemoveMember forgets a connection in a room
+func (s *RedisStateStore) RemoveMember(roomID, clientID string) error {
+	_, err := s.client.Do("HDEL", s.prefix+"members:"+roomID, clientID)
+	return err
+}
+
+// Member looks up one connection in a room with HGET
+func (s *RedisStateStore) Member(roomID, clientID string) (signaling.Member, bool, error) {
+	var member signaling.Member
+	reply, err := s.client.Do("HGET", s.prefix+"members:"+roomID, clientID)
+	if err != nil || reply == nil {
+		return member, false, err
+	}
+	data, _ := reply.(string)
+	if err := json.Unmarshal([]byte(data), &member); err != nil {
+		return member, false, err
+	}
+	return member, true, nil
+}
+
+// Members returns the connections in a room, ordered by client ID
+func (s *RedisStateStore) Members(roomID string) ([]signaling.Member, error) {
+	fields, err := s.client.Strings("HGETALL", s.prefix+"members:"+roomID)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]signaling.Member, 0, len(fields)/2)
+	for i := 1; i < len(fields); i += 2 {
+		var member signaling.Member
+		if err := json.Unmarshal([]byte(fields[i]), &member); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ClientID < members[j].ClientID })
+	return members, nil
+}
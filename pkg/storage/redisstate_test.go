@@ -0,0 +1,340 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/redis"
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+// fakeRedis serves the string, set, hash and pub/sub commands the state
+// store and backplane use
+type fakeRedis struct {
+	mutex   sync.Mutex
+	strings map[string]string
+	sets    map[string]map[string]bool
+	hashes  map[string]map[string]string
+	subs    map[string]map[*fakeConn]bool
+}
+
+// fakeConn serializes the replies and pushed messages of a connection
+type fakeConn struct {
+	net.Conn
+	mutex sync.Mutex
+}
+
+func (c *fakeConn) write(reply string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	io.WriteString(c.Conn, reply)
+}
+
+func startFakeRedis(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server := &fakeRedis{
+		strings: make(map[string]string),
+		sets:    make(map[string]map[string]bool),
+		hashes:  make(map[string]map[string]string),
+		subs:    make(map[string]map[*fakeConn]bool),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(&fakeConn{Conn: conn})
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn *fakeConn) {
+	defer func() {
+		f.mutex.Lock()
+		for _, subscribers := range f.subs {
+			delete(subscribers, conn)
+		}
+		f.mutex.Unlock()
+		conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		f.mutex.Lock()
+		reply := f.run(conn, args)
+		f.mutex.Unlock()
+		conn.write(reply)
+	}
+}
+
+func (f *fakeRedis) run(conn *fakeConn, args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		kind := strings.ToLower(args[0])
+		reply := ""
+		for _, channel := range args[1:] {
+			if f.subs[channel] == nil {
+				f.subs[channel] = make(map[*fakeConn]bool)
+			}
+			if kind == "subscribe" {
+				f.subs[channel][conn] = true
+			} else {
+				delete(f.subs[channel], conn)
+			}
+			reply += fmt.Sprintf("*3\r\n%s%s:1\r\n", bulk(kind), bulk(channel))
+		}
+		return reply
+	case "PUBLISH":
+		for subscriber := range f.subs[args[1]] {
+			subscriber.write(array([]string{"message", args[1], args[2]}))
+		}
+		return fmt.Sprintf(":%d\r\n", len(f.subs[args[1]]))
+	case "SET":
+		if _, exists := f.strings[args[1]]; exists && slices.Contains(args[3:], "NX") {
+			return "$-1\r\n"
+		}
+		f.strings[args[1]] = args[2]
+		return "+OK\r\n"
+	case "INCR":
+		value, _ := strconv.ParseInt(f.strings[args[1]], 10, 64)
+		f.strings[args[1]] = strconv.FormatInt(value+1, 10)
+		return fmt.Sprintf(":%d\r\n", value+1)
+	case "GET":
+		value, exists := f.strings[args[1]]
+		if !exists {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case "PEXPIRE":
+		if _, exists := f.strings[args[1]]; !exists {
+			return ":0\r\n"
+		}
+		return ":1\r\n"
+	case "DEL":
+		for _, key := range args[1:] {
+			delete(f.strings, key)
+			delete(f.hashes, key)
+		}
+		return ":1\r\n"
+	case "SADD", "SREM":
+		if f.sets[args[1]] == nil {
+			f.sets[args[1]] = make(map[string]bool)
+		}
+		if strings.ToUpper(args[0]) == "SADD" {
+			f.sets[args[1]][args[2]] = true
+		} else {
+			delete(f.sets[args[1]], args[2])
+		}
+		return ":1\r\n"
+	case "SMEMBERS":
+		var items []string
+		for member := range f.sets[args[1]] {
+			items = append(items, member)
+		}
+		return array(items)
+	case "HSET":
+		if f.hashes[args[1]] == nil {
+			f.hashes[args[1]] = make(map[string]string)
+		}
+		f.hashes[args[1]][args[2]] = args[3]
+		return ":1\r\n"
+	case "HDEL":
+		delete(f.hashes[args[1]], args[2])
+		return ":1\r\n"
+	case "HGET":
+		value, exists := f.hashes[args[1]][args[2]]
+		if !exists {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case "HGETALL":
+		var items []string
+		for field, value := range f.hashes[args[1]] {
+			items = append(items, field, value)
+		}
+		return array(items)
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, count)
+	for i := range args {
+		if _, err := reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		value, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(value, "\r\n")
+	}
+	return args, nil
+}
+
+func bulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func array(items []string) string {
+	reply := fmt.Sprintf("*%d\r\n", len(items))
+	for _, item := range items {
+		reply += bulk(item)
+	}
+	return reply
+}
+
+func TestRedisStateStore(t *testing.T) {
+	client, err := redis.New(redis.Options{Addr: startFakeRedis(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	store := NewRedisStateStore(client, "test:")
+
+	if err := store.PutRoom(signaling.RoomRecord{ID: "standup", State: signaling.RoomActive, HostID: "alice", Node: "node-a"}); err != nil {
+		t.Fatal(err)
+	}
+	store.PutRoom(signaling.RoomRecord{ID: "all-hands", State: signaling.RoomCreated})
+	store.AddMember("standup", signaling.Member{ClientID: "bob", Node: "node-b"})
+	store.AddMember("standup", signaling.Member{ClientID: "alice", Node: "node-a"})
+
+	records, err := store.Rooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].ID != "standup" || records[1].State != signaling.RoomActive || records[1].HostID != "alice" {
+		t.Fatalf("Expected both rooms with their state, got %+v", records)
+	}
+	members, err := store.Members("standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].ClientID != "alice" || members[1].Node != "node-b" {
+		t.Fatalf("Expected members from both nodes, got %+v", members)
+	}
+	if member, exists, err := store.Member("standup", "bob"); err != nil || !exists || member.Node != "node-b" {
+		t.Errorf("Expected bob on node-b, got %+v, %v", member, err)
+	}
+	if _, exists, err := store.Member("standup", "carol"); err != nil || exists {
+		t.Errorf("Expected carol not to be found, got %v", err)
+	}
+
+	store.RemoveMember("standup", "bob")
+	if members, _ := store.Members("standup"); len(members) != 1 {
+		t.Errorf("Expected bob to be removed, got %+v", members)
+	}
+	store.DeleteRoom("standup")
+	if records, _ := store.Rooms(); len(records) != 1 || records[0].ID != "all-hands" {
+		t.Errorf("Expected only all-hands left, got %+v", records)
+	}
+	if members, _ := store.Members("standup"); len(members) != 0 {
+		t.Errorf("Expected the room's members to be removed, got %+v", members)
+	}
+}
+
+func TestRedisBackplane(t *testing.T) {
+	addr := startFakeRedis(t)
+	var backplanes []*RedisBackplane
+	received := make(chan string, 10)
+	for _, node := range []string{"node-a", "node-b"} {
+		client, err := redis.New(redis.Options{Addr: addr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		backplane := NewRedisBackplane(client, "test:", node)
+		defer backplane.Close()
+		backplane.OnMessage(func(roomID string, payload []byte) {
+			received <- node + " " + roomID + " " + string(payload)
+		})
+		backplanes = append(backplanes, backplane)
+	}
+
+	// Command IDs are numbered once across nodes
+	for i, claim := range []struct {
+		node  int
+		id    string
+		seq   int64
+		first bool
+	}{{0, "kick-1", 1, true}, {1, "lock-1", 2, true}, {1, "kick-1", 1, false}} {
+		seq, first, err := backplanes[claim.node].Sequence("standup", claim.id)
+		if err != nil || seq != claim.seq || first != claim.first {
+			t.Errorf("Claim %d: expected %d/%v, got %d/%v (%v)", i, claim.seq, claim.first, seq, first, err)
+		}
+	}
+
+	backplanes[1].Subscribe("standup")
+	backplanes[1].Subscribe("all-hands")
+	backplanes[1].Unsubscribe("all-hands")
+
+	// Publish until node-b's subscription is up
+	deadline := time.After(5 * time.Second)
+	for {
+		backplanes[0].Publish("all-hands", []byte("ignored"))
+		backplanes[0].Publish("standup", []byte(`{"type":"chat"}`))
+		select {
+		case msg := <-received:
+			if msg != `node-b standup {"type":"chat"}` {
+				t.Fatalf("Expected standup traffic on node-b only, got %q", msg)
+			}
+		case <-time.After(50 * time.Millisecond):
+			continue
+		case <-deadline:
+			t.Fatal("Expected node-b to receive standup traffic")
+		}
+		break
+	}
+
+	// Messages routed to a node reach it without a room
+	backplanes[0].PublishNode("node-b", []byte(`{"type":"offer"}`))
+	select {
+	case msg := <-received:
+		if msg != `node-b  {"type":"offer"}` {
+			t.Errorf("Expected the offer on node-b alone, got %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected node-b to receive the routed offer")
+	}
+
+	// Heartbeats reach every node, the sender included
+	seen := map[string]bool{}
+	deadline = time.After(5 * time.Second)
+	for len(seen) < 2 {
+		backplanes[1].PublishCluster([]byte(`{"node":"node-b"}`))
+		select {
+		case msg := <-received:
+			node, rest, _ := strings.Cut(msg, " ")
+			if rest != ` {"node":"node-b"}` {
+				t.Fatalf("Expected node-b's heartbeat without a room, got %q", msg)
+			}
+			seen[node] = true
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("Expected the heartbeat on both nodes, got %v", seen)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Retention purges recordings and finished meeting records once they are
+// older than MaxAge, except those under legal hold. Stores left nil are skipped
+type Retention struct {
+	MaxAge     time.Duration
+	Recordings *RecordingStore
+	Meetings   *MeetingStore
+	Holds      *HoldStore
+
+	// Deletions are recorded here when set
+	Audit signaling.AuditLog
+}
+
+// Run purges every interval until the context is cancelled
+func (r *Retention) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, held, err := r.Purge(time.Now())
+		if err != nil {
+			util.Error("Error purging expired records: %v", err)
+		} else if purged > 0 || held > 0 {
+			util.Info("Retention purged %d records and kept %d under legal hold", purged, held)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge deletes what expired before now and reports how many records were
+// deleted and how many were kept because of a legal hold
+func (r *Retention) Purge(now time.Time) (purged, held int, err error) {
+	cutoff := now.Add(-r.MaxAge)
+
+	if r.Recordings != nil {
+		recordings, err := r.Recordings.Recordings("")
+		if err != nil {
+			return purged, held, err
+		}
+		for _, recording := range recordings {
+			if !recording.CreatedAt.Before(cutoff) {
+				continue
+			}
+			if _, onHold := r.Holds.CoversRecording(recording); onHold {
+				held++
+				continue
+			}
+			if err := r.Recordings.DeleteRecording(recording); err != nil {
+				return purged, held, err
+			}
+			r.audit(signaling.AuditEntry{
+				RoomID:      recording.RoomID,
+				ClientID:    recording.ClientID,
+				RecordingID: recording.ID,
+				SHA256:      recording.SHA256,
+				Details:     "retention",
+			})
+			purged++
+		}
+	}
+
+	if r.Meetings != nil {
+		meetings, err := r.Meetings.Meetings("")
+		if err != nil {
+			return purged, held, err
+		}
+		for _, meeting := range meetings {
+			if meeting.EndedAt.IsZero() || !meeting.EndedAt.Before(cutoff) {
+				continue
+			}
+			if _, onHold := r.Holds.CoversMeeting(meeting); onHold {
+				held++
+				continue
+			}
+			if err := r.Meetings.DeleteMeeting(meeting.ID); err != nil {
+				return purged, held, err
+			}
+			r.audit(signaling.AuditEntry{
+				RoomID:  meeting.RoomID,
+				Details: "retention: meeting " + meeting.ID,
+			})
+			purged++
+		}
+	}
+	return purged, held, nil
+}
+
+// audit records a deletion if an audit log is configured
+func (r *Retention) audit(entry signaling.AuditEntry) {
+	if r.Audit == nil {
+		return
+	}
+	entry.Time = time.Now()
+	entry.Action = signaling.AuditDeleted
+	if err := r.Audit.Append(entry); err != nil {
+		util.Error("Error writing audit entry: %v", err)
+	}
+}
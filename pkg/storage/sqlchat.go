@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+// Attempts to number a chat message when other nodes take the same number
+const chatAppendAttempts = 3
+
+// SQLChatStore keeps chat history in a SQL database such as SQLite or
+// PostgreSQL, in the table chat_messages, so it survives restarts and is
+// shared between nodes
+type SQLChatStore struct {
+	db       *sql.DB
+	numbered bool
+}
+
+// NewSQLChatStore creates the store's table if needed. driver is the name
+// the database was opened with and decides the placeholder style
+func NewSQLChatStore(db *sql.DB, driver string) (*SQLChatStore, error) {
+	s := &SQLChatStore{db: db, numbered: driver == "postgres" || driver == "pgx"}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS chat_messages (
+		room_id VARCHAR(255) NOT NULL,
+		seq BIGINT NOT NULL,
+		message TEXT NOT NULL,
+		PRIMARY KEY (room_id, seq)
+	)`); err != nil {
+		return nil, fmt.Errorf("creating chat table: %w", err)
+	}
+	return s, nil
+}
+
+// AppendChat stores a message after the room's last one. Nodes appending
+// to the same room at once collide on the key, and the loser tries again
+func (s *SQLChatStore) AppendChat(message signaling.ChatMessage) (signaling.ChatMessage, error) {
+	var err error
+	for range chatAppendAttempts {
+		if message, err = s.append(message); err == nil {
+			return message, nil
+		}
+	}
+	return message, err
+}
+
+// append numbers and inserts a message in one transaction
+func (s *SQLChatStore) append(message signaling.ChatMessage) (signaling.ChatMessage, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return message, err
+	}
+	defer tx.Rollback()
+
+	var last sql.NullInt64
+	if err := tx.QueryRow(s.query("SELECT MAX(seq) FROM chat_messages WHERE room_id = ?"), message.RoomID).Scan(&last); err != nil {
+		return message, err
+	}
+	message.Seq = last.Int64 + 1
+	data, err := json.Marshal(message)
+	if err != nil {
+		return message, err
+	}
+	if _, err := tx.Exec(s.query("INSERT INTO chat_messages (room_id, seq, message) VALUES (?, ?, ?)"),
+		message.RoomID, message.Seq, string(data)); err != nil {
+		return message, err
+	}
+	return message, tx.Commit()
+}
+
+// ChatHistory returns up to limit of a room's messages before seq, or its
+// latest if seq is 0, oldest first
+func (s *SQLChatStore) ChatHistory(roomID string, before int64, limit int) ([]signaling.ChatMessage, error) {
+	if before <= 0 {
+		before = 1<<63 - 1
+	}
+	rows, err := s.db.Query(s.query("SELECT message FROM chat_messages WHERE room_id = ? AND seq < ? ORDER BY seq DESC LIMIT ?"),
+		roomID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []signaling.ChatMessage
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var message signaling.ChatMessage
+		if err := json.Unmarshal([]byte(data), &message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Newest were read first
+	slices.Reverse(messages)
+	return messages, nil
+}
+
+// query rewrites placeholders for the database
+func (s *SQLChatStore) query(q string) string {
+	return placeholders(q, s.numbered)
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"modernc.org/sqlite"
+)
+
+// collidingDriver is SQLite whose next inserts of chat messages fail as if
+// another node had taken their number first
+type collidingDriver struct {
+	sqlite.Driver
+	collisions atomic.Int32
+}
+
+var colliding = &collidingDriver{}
+
+func init() {
+	sql.Register("sqlite-colliding", colliding)
+}
+
+func (d *collidingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &collidingConn{Conn: conn, driver: d}, nil
+}
+
+// collidingConn hides the optional interfaces of the SQLite connection, so
+// every statement is prepared
+type collidingConn struct {
+	driver.Conn
+	driver *collidingDriver
+}
+
+func (c *collidingConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.HasPrefix(query, "INSERT INTO chat_messages") && c.driver.collisions.Add(-1) >= 0 {
+		return nil, errors.New("UNIQUE constraint failed: chat_messages.room_id, chat_messages.seq")
+	}
+	return c.Conn.Prepare(query)
+}
+
+func chatMessage(roomID, text string) signaling.ChatMessage {
+	return signaling.ChatMessage{RoomID: roomID, From: "alice", Data: map[string]interface{}{"text": text}}
+}
+
+func TestSQLChatStoreHistory(t *testing.T) {
+	// SQLite takes numbered placeholders too, so both styles are checked
+	for _, name := range []string{"sqlite", "postgres"} {
+		t.Run(name, func(t *testing.T) {
+			store, err := NewSQLChatStore(openSQLite(t, "sqlite"), name)
+			if err != nil {
+				t.Fatalf("Expected store to be created, got %v", err)
+			}
+
+			for i, text := range []string{"one", "two", "three", "four", "five"} {
+				message, err := store.AppendChat(chatMessage("standup", text))
+				if err != nil {
+					t.Fatalf("Expected message to be appended, got %v", err)
+				}
+				if message.Seq != int64(i+1) {
+					t.Errorf("Expected %q to be numbered %d, got %d", text, i+1, message.Seq)
+				}
+			}
+			if message, _ := store.AppendChat(chatMessage("retro", "hello")); message.Seq != 1 {
+				t.Errorf("Expected each room to be numbered from 1, got %d", message.Seq)
+			}
+
+			texts := func(messages []signaling.ChatMessage) []string {
+				var texts []string
+				for _, message := range messages {
+					texts = append(texts, message.Data["text"].(string))
+				}
+				return texts
+			}
+			for _, test := range []struct {
+				before int64
+				limit  int
+				want   string
+			}{
+				{0, 2, "four five"},
+				{4, 2, "two three"},
+				{3, 10, "one two"},
+				{1, 10, ""},
+				{0, 10, "one two three four five"},
+			} {
+				messages, err := store.ChatHistory("standup", test.before, test.limit)
+				if err != nil {
+					t.Fatalf("Expected history, got %v", err)
+				}
+				if got := strings.Join(texts(messages), " "); got != test.want {
+					t.Errorf("Expected %d before %d to be %q, got %q", test.limit, test.before, test.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLChatStoreCollisions(t *testing.T) {
+	store, err := NewSQLChatStore(openSQLite(t, "sqlite-colliding"), "sqlite")
+	if err != nil {
+		t.Fatalf("Expected store to be created, got %v", err)
+	}
+	t.Cleanup(func() { colliding.collisions.Store(0) })
+
+	colliding.collisions.Store(chatAppendAttempts - 1)
+	message, err := store.AppendChat(chatMessage("standup", "one"))
+	if err != nil || message.Seq != 1 {
+		t.Fatalf("Expected the last attempt to append the message, got %+v, %v", message, err)
+	}
+
+	colliding.collisions.Store(chatAppendAttempts)
+	if _, err := store.AppendChat(chatMessage("standup", "two")); err == nil {
+		t.Error("Expected appending to fail once every attempt collides")
+	}
+
+	messages, err := store.ChatHistory("standup", 0, 10)
+	if err != nil || len(messages) != 1 || messages[0].Seq != 1 {
+		t.Errorf("Expected only the first message stored, got %+v, %v", messages, err)
+	}
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+// SQLStateStore shares room membership and state between nodes through a
+// SQL database, in the tables room_state and room_members. It uses only
+// portable SQL and works with any driver that takes question mark or
+// numbered placeholders
+type SQLStateStore struct {
+	db *sql.DB
+
+	// Numbered placeholders ($1) instead of question marks, for PostgreSQL
+	numbered bool
+}
+
+// NewSQLStateStore creates the store's tables if needed. driver is the name
+// the database was opened with and decides the placeholder style
+func NewSQLStateStore(db *sql.DB, driver string) (*SQLStateStore, error) {
+	s := &SQLStateStore{db: db, numbered: driver == "postgres" || driver == "pgx"}
+	for _, statement := range []string{
+		`CREATE TABLE IF NOT EXISTS room_state (
+			room_id VARCHAR(255) PRIMARY KEY,
+			record TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS room_members (
+			room_id VARCHAR(255) NOT NULL,
+			client_id VARCHAR(255) NOT NULL,
+			member TEXT NOT NULL,
+			PRIMARY KEY (room_id, client_id)
+		)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, fmt.Errorf("creating state tables: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// PutRoom records a room's state
+func (s *SQLStateStore) PutRoom(record signaling.RoomRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.replace(
+		"DELETE FROM room_state WHERE room_id = ?",
+		"INSERT INTO room_state (room_id, record) VALUES (?, ?)",
+		[]interface{}{record.ID}, []interface{}{record.ID, string(data)})
+}
+
+// DeleteRoom forgets a room and its members
+func (s *SQLStateStore) DeleteRoom(roomID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(s.query("DELETE FROM room_members WHERE room_id = ?"), roomID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.query("DELETE FROM room_state WHERE room_id = ?"), roomID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Rooms returns all recorded rooms, ordered by ID
+func (s *SQLStateStore) Rooms() ([]signaling.RoomRecord, error) {
+	rows, err := s.db.Query("SELECT record FROM room_state ORDER BY room_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []signaling.RoomRecord{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var record signaling.RoomRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// AddMember records a connection in a room, replacing one with the same ID
+func (s *SQLStateStore) AddMember(roomID string, member signaling.Member) error {
+	data, err := json.Marshal(member)
+	if err != nil {
+		return err
+	}
+	return s.replace(
+		"DELETE FROM room_members WHERE room_id = ? AND client_id = ?",
+		"INSERT INTO room_members (room_id, client_id, member) VALUES (?, ?, ?)",
+		[]interface{}{roomID, member.ClientID}, []interface{}{roomID, member.ClientID, string(data)})
+}
+
+// RemoveMember forgets a connection in a room
+func (s *SQLStateStore) RemoveMember(roomID, clientID string) error {
+	_, err := s.db.Exec(s.query("DELETE FROM room_members WHERE room_id = ? AND client_id = ?"), roomID, clientID)
+	return err
+}
+
+// Members returns the connections in a room, ordered by client ID
+func (s *SQLStateStore) Members(roomID string) ([]signaling.Member, error) {
+	rows, err := s.db.Query(s.query("SELECT member FROM room_members WHERE room_id = ? ORDER BY client_id"), roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []signaling.Member{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var member signaling.Member
+		if err := json.Unmarshal([]byte(data), &member); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// Member looks up one connection in a room
+func (s *SQLStateStore) Member(roomID, clientID string) (signaling.Member, bool, error) {
+	var member signaling.Member
+	var data string
+	err := s.db.QueryRow(s.query("SELECT member FROM room_members WHERE room_id = ? AND client_id = ?"), roomID, clientID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return member, false, nil
+	}
+	if err != nil {
+		return member, false, err
+	}
+	if err := json.Unmarshal([]byte(data), &member); err != nil {
+		return member, false, err
+	}
+	return member, true, nil
+}
+
+// replace deletes and inserts a row in one transaction, since upserts are
+// spelled differently by every database
+func (s *SQLStateStore) replace(del, insert string, delArgs, insertArgs []interface{}) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(s.query(del), delArgs...); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.query(insert), insertArgs...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// query rewrites question mark placeholders for databases that number them
+func (s *SQLStateStore) query(q string) string {
+	return placeholders(q, s.numbered)
+}
+
+// placeholders rewrites question marks as numbered placeholders ($1) when
+// the database needs them, as PostgreSQL does
+func placeholders(q string, numbered bool) string {
+	if !numbered {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
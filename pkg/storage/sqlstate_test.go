@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	_ "modernc.org/sqlite"
+)
+
+// openSQLite opens a SQLite database in a file, since each connection to
+// an in-memory database gets a database of its own
+func openSQLite(t *testing.T, driver string) *sql.DB {
+	db, err := sql.Open(driver, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLStateStoreMembers(t *testing.T) {
+	// SQLite takes numbered placeholders too, so both styles are checked
+	for _, driver := range []string{"sqlite", "postgres"} {
+		t.Run(driver, func(t *testing.T) {
+			store, err := NewSQLStateStore(openSQLite(t, "sqlite"), driver)
+			if err != nil {
+				t.Fatalf("Expected store to be created, got %v", err)
+			}
+
+			joined := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			for _, member := range []signaling.Member{
+				{ClientID: "carol", Node: "node-a", JoinedAt: joined},
+				{ClientID: "alice", Node: "node-a", JoinedAt: joined},
+				{ClientID: "alice", UserID: "u-alice", Node: "node-b", JoinedAt: joined},
+			} {
+				if err := store.AddMember("standup", member); err != nil {
+					t.Fatalf("Expected member to be added, got %v", err)
+				}
+			}
+			if err := store.AddMember("retro", signaling.Member{ClientID: "bob", JoinedAt: joined}); err != nil {
+				t.Fatalf("Expected member to be added, got %v", err)
+			}
+
+			members, err := store.Members("standup")
+			if err != nil {
+				t.Fatalf("Expected members, got %v", err)
+			}
+			if len(members) != 2 || members[0].ClientID != "alice" || members[1].ClientID != "carol" {
+				t.Fatalf("Expected alice and carol in order, got %+v", members)
+			}
+			if members[0].Node != "node-b" || members[0].UserID != "u-alice" {
+				t.Errorf("Expected alice's second connection to replace the first, got %+v", members[0])
+			}
+			if !members[1].JoinedAt.Equal(joined) {
+				t.Errorf("Expected the join time to round trip, got %v", members[1].JoinedAt)
+			}
+
+			member, ok, err := store.Member("retro", "bob")
+			if err != nil || !ok || member.ClientID != "bob" {
+				t.Errorf("Expected to find bob in retro, got %+v, %v, %v", member, ok, err)
+			}
+			if _, ok, err := store.Member("retro", "alice"); ok || err != nil {
+				t.Errorf("Expected alice not to be in retro, got %v, %v", ok, err)
+			}
+
+			if err := store.RemoveMember("standup", "alice"); err != nil {
+				t.Fatalf("Expected member to be removed, got %v", err)
+			}
+			if members, _ := store.Members("standup"); len(members) != 1 || members[0].ClientID != "carol" {
+				t.Errorf("Expected only carol left, got %+v", members)
+			}
+
+			if err := store.PutRoom(signaling.RoomRecord{ID: "standup"}); err != nil {
+				t.Fatalf("Expected room to be put, got %v", err)
+			}
+			if err := store.DeleteRoom("standup"); err != nil {
+				t.Fatalf("Expected room to be deleted, got %v", err)
+			}
+			if members, _ := store.Members("standup"); len(members) != 0 {
+				t.Errorf("Expected deleting the room to forget its members, got %+v", members)
+			}
+			if rooms, _ := store.Rooms(); len(rooms) != 0 {
+				t.Errorf("Expected no rooms, got %+v", rooms)
+			}
+		})
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	q := "SELECT member FROM room_members WHERE room_id = ? AND client_id = ?"
+	if got := placeholders(q, false); got != q {
+		t.Errorf("Expected question marks to be kept, got %q", got)
+	}
+	want := "SELECT member FROM room_members WHERE room_id = $1 AND client_id = $2"
+	if got := placeholders(q, true); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := placeholders("SELECT 1", true); got != "SELECT 1" {
+		t.Errorf("Expected a query without placeholders to be kept, got %q", got)
+	}
+}
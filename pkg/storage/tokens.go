@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Scopes an API token may be granted
+const (
+	ScopeRoomsRead      = "rooms:read"
+	ScopeRoomsWrite     = "rooms:write"
+	ScopeRecordingsRead = "recordings:read"
+	ScopeAdmin          = "admin" // Grants every scope
+)
+
+// Prefix of every API token secret, so leaked tokens are easy to spot
+const tokenPrefix = "cvt_"
+
+// How often a token's last use is written to disk at most
+const lastUsedSaveInterval = time.Minute
+
+var (
+	// ErrTokenNotFound is returned for unknown token IDs
+	ErrTokenNotFound = errors.New("API token not found")
+
+	// ErrTokenInvalid is returned for unknown, expired or revoked secrets
+	ErrTokenInvalid = errors.New("invalid API token")
+
+	// ErrTokenScope is returned when a valid token lacks the required scope
+	ErrTokenScope = errors.New("API token lacks the required scope")
+)
+
+// APIToken describes an API token. The secret itself is only returned when
+// the token is created; the store keeps its hash
+type APIToken struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Scopes     []string  `json:"scopes"`
+	Hint       string    `json:"hint"` // Start of the secret, to tell tokens apart
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	LastUsedAt time.Time `json:"lastUsedAt,omitzero"`
+	RevokedAt  time.Time `json:"revokedAt,omitzero"`
+}
+
+// storedToken is a token as kept in the tokens file
+type storedToken struct {
+	APIToken
+	Hash string `json:"hash"`
+}
+
+// TokenStore keeps API tokens in a JSON file
+type TokenStore struct {
+	path      string
+	tokens    []storedToken
+	lastSaved map[string]time.Time // When each token's last use was written
+	mutex     sync.Mutex
+}
+
+// NewTokenStore opens the tokens file at path, creating it if needed
+func NewTokenStore(path string) (*TokenStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating token directory: %w", err)
+	}
+	store := &TokenStore{path: path, tokens: []storedToken{}, lastSaved: make(map[string]time.Time)}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &store.tokens); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+	util.Info("API tokens loaded from %s: %d", path, len(store.tokens))
+	return store, nil
+}
+
+// ValidScope reports whether a scope can be granted
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeRoomsRead, ScopeRoomsWrite, ScopeRecordingsRead, ScopeAdmin:
+		return true
+	default:
+		return false
+	}
+}
+
+// Create issues a token with the given scopes that expires after ttl. It
+// returns the token and its secret, which can't be retrieved later
+func (s *TokenStore) Create(name string, scopes []string, ttl time.Duration) (APIToken, string, error) {
+	if name == "" {
+		return APIToken{}, "", errors.New("token name is required")
+	}
+	if len(scopes) == 0 {
+		return APIToken{}, "", errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !ValidScope(scope) {
+			return APIToken{}, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if ttl <= 0 {
+		return APIToken{}, "", errors.New("token lifetime must be positive")
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	raw := make([]byte, 24)
+	rand.Read(raw)
+	secret := tokenPrefix + hex.EncodeToString(raw)
+	now := time.Now()
+	token := storedToken{
+		APIToken: APIToken{
+			ID:        hex.EncodeToString(id),
+			Name:      name,
+			Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+			Hint:      secret[:len(tokenPrefix)+6],
+			CreatedAt: now,
+			ExpiresAt: now.Add(ttl),
+		},
+		Hash: hashToken(secret),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tokens := append(slices.Clone(s.tokens), token)
+	if err := s.saveLocked(tokens); err != nil {
+		return APIToken{}, "", err
+	}
+	s.tokens = tokens
+	return token.APIToken, secret, nil
+}
+
+// Tokens returns all tokens, including expired and revoked ones
+func (s *TokenStore) Tokens() []APIToken {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tokens := make([]APIToken, len(s.tokens))
+	for i, token := range s.tokens {
+		tokens[i] = token.APIToken
+	}
+	return tokens
+}
+
+// Revoke makes a token unusable and returns it
+func (s *TokenStore) Revoke(id string) (APIToken, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := slices.IndexFunc(s.tokens, func(token storedToken) bool { return token.ID == id })
+	if i < 0 {
+		return APIToken{}, ErrTokenNotFound
+	}
+	tokens := slices.Clone(s.tokens)
+	if tokens[i].RevokedAt.IsZero() {
+		tokens[i].RevokedAt = time.Now()
+		if err := s.saveLocked(tokens); err != nil {
+			return APIToken{}, err
+		}
+		s.tokens = tokens
+	}
+	return tokens[i].APIToken, nil
+}
+
+// Authenticate checks a secret against the tokens and that it grants scope,
+// recording when the token was last used
+func (s *TokenStore) Authenticate(secret, scope string) (APIToken, error) {
+	hash := hashToken(secret)
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i := slices.IndexFunc(s.tokens, func(token storedToken) bool { return token.Hash == hash })
+	if i < 0 || !s.tokens[i].RevokedAt.IsZero() || now.After(s.tokens[i].ExpiresAt) {
+		return APIToken{}, ErrTokenInvalid
+	}
+	token := &s.tokens[i]
+	if !slices.Contains(token.Scopes, scope) && !slices.Contains(token.Scopes, ScopeAdmin) {
+		return token.APIToken, ErrTokenScope
+	}
+
+	// Last use is kept in memory and only written now and then, so busy
+	// integrations don't rewrite the file on every request
+	token.LastUsedAt = now
+	if now.Sub(s.lastSaved[token.ID]) >= lastUsedSaveInterval {
+		s.lastSaved[token.ID] = now
+		if err := s.saveLocked(s.tokens); err != nil {
+			util.Error("Error saving last use of API token %s: %v", token.ID, err)
+		}
+	}
+	return token.APIToken, nil
+}
+
+// saveLocked writes the tokens file; the caller must hold the mutex
+func (s *TokenStore) saveLocked(tokens []storedToken) error {
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// hashToken returns the hash a token's secret is stored as
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
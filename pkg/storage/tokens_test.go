@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store, err := NewTokenStore(path)
+	if err != nil {
+		t.Fatalf("Expected token store to open, got %v", err)
+	}
+	if _, _, err := store.Create("ci", []string{"rooms:delete"}, time.Hour); err == nil {
+		t.Error("Expected an unknown scope to be rejected")
+	}
+	token, secret, err := store.Create("ci", []string{ScopeRecordingsRead}, time.Hour)
+	if err != nil || !strings.HasPrefix(secret, tokenPrefix) || !strings.HasPrefix(secret, token.Hint) {
+		t.Fatalf("Expected a token, got %+v %q %v", token, secret, err)
+	}
+	expired, expiredSecret, _ := store.Create("old", []string{ScopeAdmin}, time.Nanosecond)
+
+	// Only hashes are kept, and tokens survive a restart
+	store, _ = NewTokenStore(path)
+	if used, err := store.Authenticate(secret, ScopeRecordingsRead); err != nil || used.LastUsedAt.IsZero() {
+		t.Errorf("Expected the token to authenticate and be marked used, got %+v, %v", used, err)
+	}
+	if _, err := store.Authenticate(secret, ScopeRoomsWrite); !errors.Is(err, ErrTokenScope) {
+		t.Errorf("Expected ErrTokenScope, got %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := store.Authenticate(expiredSecret, ScopeRoomsRead); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected an expired token to be invalid, got %v", err)
+	}
+
+	if _, err := store.Revoke(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate(secret, ScopeRecordingsRead); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected a revoked token to be invalid, got %v", err)
+	}
+	if _, err := store.Revoke("missing"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Expected ErrTokenNotFound, got %v", err)
+	}
+	if tokens := store.Tokens(); len(tokens) != 2 || tokens[1].ID != expired.ID {
+		t.Errorf("Expected revoked and expired tokens to stay listed, got %+v", tokens)
+	}
+}
@@ -0,0 +1,59 @@
+package turnserver
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/redis"
+)
+
+// CoturnTrafficPattern matches the channels coturn publishes its periodic
+// traffic reports to when it's configured with redis-statsdb
+const CoturnTrafficPattern = "turn/realm/*/user/*/allocation/*/traffic"
+
+// ConsumeCoturn records the traffic reports coturn publishes over Redis
+// until the subscription fails or is closed. Bandwidth caps aren't enforced
+// on coturn's relays; configure its own limits for that
+func (m *Meter) ConsumeCoturn(sub *redis.Subscription) error {
+	if err := sub.PSubscribe(CoturnTrafficPattern); err != nil {
+		return err
+	}
+	for {
+		channel, payload, err := sub.Receive()
+		if err != nil {
+			return err
+		}
+		m.recordCoturn(channel, payload)
+	}
+}
+
+// recordCoturn records one traffic report, such as "rcvp=10, rcvb=1200,
+// sentp=8, sentb=900" on turn/realm/<realm>/user/<username>/allocation/<id>/traffic.
+// Each report covers the traffic since the previous one. coturn counts from
+// the client's side, so what it received is what was sent to peers
+func (m *Meter) recordCoturn(channel, payload string) {
+	_, rest, found := strings.Cut(channel, "/user/")
+	if !found || !strings.HasSuffix(channel, "/traffic") {
+		return
+	}
+	username, _, found := strings.Cut(rest, "/allocation/")
+	if !found {
+		return
+	}
+
+	var received, sent uint64
+	for _, field := range strings.Split(payload, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		bytes, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch name {
+		case "rcvb":
+			received = bytes
+		case "sentb":
+			sent = bytes
+		}
+	}
+	m.Record(m.attribute(clientOf(username)), received, sent)
+}
@@ -0,0 +1,78 @@
+package turnserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pion/stun/v3"
+)
+
+// Time a probe waits for an answer unless its context ends sooner
+const probeTimeout = 5 * time.Second
+
+// ErrProbeUnsupported is returned for URLs Probe can't check, such as TURN
+// over DTLS
+var ErrProbeUnsupported = errors.New("probing this transport is not supported")
+
+// Probe checks that the STUN or TURN server of a URL, such as
+// "turn:turn.example.com:3478?transport=tcp", answers a STUN binding request
+// over the URL's transport. TURN servers answer them without credentials
+func Probe(ctx context.Context, url string) error {
+	uri, err := stun.ParseURI(url)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", url, err)
+	}
+	secure := uri.Scheme == stun.SchemeTypeSTUNS || uri.Scheme == stun.SchemeTypeTURNS
+	if secure && uri.Proto == stun.ProtoTypeUDP {
+		return ErrProbeUnsupported
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	address := net.JoinHostPort(uri.Host, strconv.Itoa(uri.Port))
+	var conn net.Conn
+	switch {
+	case secure:
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: uri.Host}}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	case uri.Proto == stun.ProtoTypeTCP:
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	default:
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "udp", address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	request := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if _, err := conn.Write(request.Raw); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	response := &stun.Message{Raw: buf[:n]}
+	if err := response.Decode(); err != nil {
+		return fmt.Errorf("decoding the response: %w", err)
+	}
+	if response.TransactionID != request.TransactionID {
+		return errors.New("response to another request")
+	}
+	if response.Type != stun.BindingSuccess {
+		return fmt.Errorf("unexpected response %s", response.Type)
+	}
+	return nil
+}
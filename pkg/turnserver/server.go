@@ -0,0 +1,192 @@
+package turnserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/pion/turn/v4"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Realm of the server's credentials unless Config.Realm says otherwise
+const defaultRealm = "chat-video-app"
+
+// Config configures the embedded TURN/STUN server
+type Config struct {
+	// UDP and TCP address to listen on, e.g. ":3478", which accepts IPv4
+	// and IPv6 clients, or "[::1]:3478"
+	ListenAddr string
+
+	// IPv4 address clients reach the server and its relays on. Relays are
+	// always IPv4, as pion allocates them over UDP4
+	PublicIP net.IP
+
+	// IPv6 address clients can also reach the server on, if it has one;
+	// they are given IPv4 relays like everyone else
+	PublicIPv6 net.IP
+
+	// Ports relays are allocated from; both 0 lets the system choose
+	MinPort, MaxPort uint16
+
+	// Local IPv4 address relays bind to; nil binds them to all interfaces
+	BindIP net.IP
+
+	// Address of a TURN over TLS listener, such as ":443" for networks that
+	// only let HTTPS out; empty disables it
+	TLSListenAddr string
+
+	// Certificate of the TLS listener
+	TLSConfig *tls.Config
+
+	// Name clients reach the TLS listener on, which the certificate covers
+	TLSHost string
+
+	Realm string
+
+	// Shared secret of the time-limited credentials (TURN REST API)
+	Secret string
+
+	// Attributes relayed traffic to rooms and caps it per tenant, if set
+	Meter *Meter
+}
+
+// Server is a TURN server that also answers STUN binding requests, so
+// clients behind symmetric NATs can relay their media through it
+type Server struct {
+	turn *turn.Server
+	urls []string
+}
+
+// Start listens on config.ListenAddr and serves until Close is called
+func Start(config Config) (*Server, error) {
+	if config.PublicIP.To4() == nil {
+		return nil, errors.New("public IPv4 address is required")
+	}
+	if config.PublicIPv6 != nil && config.PublicIPv6.To4() != nil {
+		return nil, errors.New("public IPv6 address is not an IPv6 address")
+	}
+	if config.Secret == "" {
+		return nil, errors.New("credential secret is required")
+	}
+	if config.Realm == "" {
+		config.Realm = defaultRealm
+	}
+	if config.TLSListenAddr != "" && (config.TLSConfig == nil || config.TLSHost == "") {
+		return nil, errors.New("TURN over TLS needs a certificate and the host name it covers")
+	}
+
+	udp, err := net.ListenPacket("udp", config.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on UDP: %w", err)
+	}
+	port := udp.LocalAddr().(*net.UDPAddr).Port
+	tcp, err := net.Listen("tcp", net.JoinHostPort(hostOf(config.ListenAddr), strconv.Itoa(port)))
+	if err != nil {
+		udp.Close()
+		return nil, fmt.Errorf("listening on TCP: %w", err)
+	}
+
+	listeners := []net.Listener{tcp}
+	if config.TLSListenAddr != "" {
+		secure, err := tls.Listen("tcp", config.TLSListenAddr, config.TLSConfig)
+		if err != nil {
+			udp.Close()
+			tcp.Close()
+			return nil, fmt.Errorf("listening on TLS: %w", err)
+		}
+		listeners = append(listeners, secure)
+	}
+
+	relays := relayGenerator(config)
+	var events turn.EventHandler
+	if config.Meter != nil {
+		relays = meteredRelays{RelayAddressGenerator: relays, meter: config.Meter}
+		events.OnAllocationCreated = func(_, _ net.Addr, _, username, _ string, relayAddr net.Addr, _ int) {
+			config.Meter.allocated(relayAddr.String(), username)
+		}
+	}
+	listenerConfigs := make([]turn.ListenerConfig, 0, len(listeners))
+	for _, listener := range listeners {
+		listenerConfigs = append(listenerConfigs, turn.ListenerConfig{Listener: listener, RelayAddressGenerator: relays})
+	}
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:             config.Realm,
+		AuthHandler:       turn.LongTermTURNRESTAuthHandler(config.Secret, nil),
+		PacketConnConfigs: []turn.PacketConnConfig{{PacketConn: udp, RelayAddressGenerator: relays}},
+		ListenerConfigs:   listenerConfigs,
+		EventHandler:      events,
+	})
+	if err != nil {
+		udp.Close()
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		return nil, err
+	}
+
+	var urls []string
+	for _, ip := range []net.IP{config.PublicIP, config.PublicIPv6} {
+		if ip == nil {
+			continue
+		}
+		address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		util.Info("TURN server listening on port %d for %s", port, address)
+		urls = append(urls,
+			"stun:"+address,
+			"turn:"+address+"?transport=udp",
+			"turn:"+address+"?transport=tcp",
+		)
+	}
+	if len(listeners) > 1 {
+		tlsPort := listeners[1].Addr().(*net.TCPAddr).Port
+		urls = append(urls, "turns:"+net.JoinHostPort(config.TLSHost, strconv.Itoa(tlsPort))+"?transport=tcp")
+		util.Info("TURN over TLS listening on port %d for %s", tlsPort, config.TLSHost)
+	}
+	return &Server{turn: server, urls: urls}, nil
+}
+
+// URLs returns the STUN and TURN URLs clients reach the server on
+func (s *Server) URLs() []string {
+	return s.urls
+}
+
+// Allocations returns how many relays are currently allocated
+func (s *Server) Allocations() int {
+	return s.turn.AllocationCount()
+}
+
+// Close stops the server and releases its relays
+func (s *Server) Close() error {
+	return s.turn.Close()
+}
+
+// relayGenerator allocates relays on the public IP, within the configured
+// port range if there is one
+func relayGenerator(config Config) turn.RelayAddressGenerator {
+	address := "0.0.0.0"
+	if config.BindIP != nil {
+		address = config.BindIP.String()
+	}
+	if config.MinPort != 0 || config.MaxPort != 0 {
+		return &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: config.PublicIP,
+			Address:      address,
+			MinPort:      config.MinPort,
+			MaxPort:      config.MaxPort,
+		}
+	}
+	return &turn.RelayAddressGeneratorStatic{RelayAddress: config.PublicIP, Address: address}
+}
+
+// hostOf returns the host part of a listen address, which may be empty
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
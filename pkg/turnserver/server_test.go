@@ -0,0 +1,314 @@
+package turnserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v4"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+)
+
+func TestServer(t *testing.T) {
+	server, err := Start(Config{ListenAddr: "127.0.0.1:0", PublicIP: net.ParseIP("127.0.0.1"), Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	urls := server.URLs()
+	if len(urls) != 3 || !strings.HasPrefix(urls[0], "stun:127.0.0.1:") || !strings.HasSuffix(urls[2], "?transport=tcp") {
+		t.Fatalf("Expected STUN, TURN/UDP and TURN/TCP URLs, got %v", urls)
+	}
+	address := strings.TrimPrefix(urls[0], "stun:")
+
+	// Credentials the hub hands to clients allocate relays
+	hub := signaling.NewHub()
+	hub.SetTURNConfig(signaling.TURNConfig{URLs: urls, Secret: "s3cret"})
+	credentials := hub.TURNCredentials("alice")
+	allocate := func(username, password string) error {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		client, err := turn.NewClient(&turn.ClientConfig{
+			STUNServerAddr: address,
+			TURNServerAddr: address,
+			Conn:           conn,
+			Username:       username,
+			Password:       password,
+			Realm:          defaultRealm,
+			RTO:            100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if err := client.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.SendBindingRequest(); err != nil {
+			t.Fatalf("Expected a STUN binding response, got %v", err)
+		}
+		relay, err := client.Allocate()
+		if err != nil {
+			return err
+		}
+		relay.Close()
+		return nil
+	}
+	if err := allocate(credentials.Username, credentials.Credential); err != nil {
+		t.Errorf("Expected a relay with the hub's credentials, got %v", err)
+	}
+	if err := allocate(credentials.Username, "forged"); err == nil {
+		t.Error("Expected a wrong credential to be refused")
+	}
+	expired, password, _ := turn.GenerateLongTermTURNRESTCredentials("s3cret", "alice", -time.Minute)
+	if err := allocate(expired, password); err == nil {
+		t.Error("Expected expired credentials to be refused")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	server, err := Start(Config{ListenAddr: "127.0.0.1:0", PublicIP: net.ParseIP("127.0.0.1"), Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := server.URLs()
+	for _, url := range urls {
+		if err := Probe(context.Background(), url); err != nil {
+			t.Errorf("Expected %s to answer, got %v", url, err)
+		}
+	}
+	if err := Probe(context.Background(), "turns:127.0.0.1:5349?transport=udp"); !errors.Is(err, ErrProbeUnsupported) {
+		t.Errorf("Expected TURN over DTLS to be unsupported, got %v", err)
+	}
+
+	server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	for _, url := range urls {
+		if err := Probe(ctx, url); err == nil {
+			t.Errorf("Expected %s to fail once the server is closed", url)
+		}
+	}
+}
+
+func TestServerDualStack(t *testing.T) {
+	if _, err := Start(Config{PublicIP: net.ParseIP("::1"), Secret: "s3cret"}); err == nil {
+		t.Error("Expected an IPv6 relay address to be refused")
+	}
+
+	server, err := Start(Config{ListenAddr: ":0", PublicIP: net.ParseIP("127.0.0.1"), PublicIPv6: net.ParseIP("::1"), Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	urls := server.URLs()
+	if len(urls) != 6 || !strings.HasPrefix(urls[3], "stun:[::1]:") {
+		t.Fatalf("Expected URLs for both families, got %v", urls)
+	}
+	// One listener takes clients of either family
+	for _, url := range urls {
+		if err := Probe(context.Background(), url); err != nil {
+			t.Errorf("Expected %s to answer, got %v", url, err)
+		}
+	}
+}
+
+func TestMeter(t *testing.T) {
+	caps, err := ParseCaps("acme=80, *=8")
+	if err != nil || caps["acme"] != 10000 || caps[DefaultCap] != 1000 {
+		t.Fatalf("Expected caps in bytes per second, got %v, %v", caps, err)
+	}
+	if _, err := ParseCaps("acme=fast"); err == nil {
+		t.Error("Expected an invalid cap to be refused")
+	}
+
+	now := time.Unix(1000, 0)
+	meter := NewMeter(func(clientID string) Attribution {
+		tenant, room, _ := strings.Cut(clientID, "-")
+		return Attribution{Room: room, Tenant: tenant}
+	}, caps)
+	meter.now = func() time.Time { return now }
+	meter.allocated("relay-1", "1700000000:acme-standup")
+	meter.allocated("relay-2", "1700000000:globex-review")
+
+	// A full second's worth passes, then the tenant waits for the bucket
+	if !meter.relayed("relay-1", 6000, true) || !meter.relayed("relay-1", 4000, false) {
+		t.Error("Expected traffic within the cap to pass")
+	}
+	if meter.relayed("relay-1", 100, true) {
+		t.Error("Expected traffic over the cap to be dropped")
+	}
+	now = now.Add(100 * time.Millisecond)
+	if !meter.relayed("relay-1", 1000, true) {
+		t.Error("Expected the bucket to refill over time")
+	}
+	if meter.relayed("relay-2", 2000, true) {
+		t.Error("Expected the default cap for tenants without their own")
+	}
+	meter.recordCoturn("turn/realm/example.com/user/1700000000:acme-standup/allocation/42/traffic", "rcvp=3, rcvb=300, sentp=2, sentb=200")
+	meter.recordCoturn("turn/realm/example.com/user/1700000000:acme-standup/allocation/42/total_traffic", "rcvb=1")
+
+	usage := meter.Usage()
+	if len(usage) != 2 || usage[0].Tenant != "acme" || usage[1].Tenant != "globex" {
+		t.Fatalf("Expected usage of both rooms sorted by tenant, got %+v", usage)
+	}
+	want := Usage{Attribution: Attribution{Room: "standup", Tenant: "acme"}, BytesSent: 7300, BytesReceived: 4200, BytesDropped: 100, Allocations: 1}
+	if usage[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, usage[0])
+	}
+	if usage[1].BytesDropped != 2000 {
+		t.Errorf("Expected globex's traffic dropped, got %+v", usage[1])
+	}
+}
+
+func TestMeteredRelay(t *testing.T) {
+	meter := NewMeter(func(clientID string) Attribution {
+		return Attribution{Room: "standup", Tenant: "acme"}
+	}, nil)
+	server, err := Start(Config{ListenAddr: "127.0.0.1:0", PublicIP: net.ParseIP("127.0.0.1"), Secret: "s3cret", Meter: meter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	address := strings.TrimPrefix(server.URLs()[0], "stun:")
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	username, password, _ := turn.GenerateLongTermTURNRESTCredentials("s3cret", "alice", time.Hour)
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: address,
+		TURNServerAddr: address,
+		Conn:           conn,
+		Username:       username,
+		Password:       password,
+		Realm:          defaultRealm,
+		RTO:            100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	relay, err := client.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	if _, err := relay.WriteTo(make([]byte, 500), peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1500)
+	if n, _, err := peer.ReadFrom(buf); err != nil || n != 500 {
+		t.Fatalf("Expected the peer to get 500 bytes through the relay, got %d, %v", n, err)
+	}
+
+	usage := meter.Usage()
+	if len(usage) != 1 || usage[0].Room != "standup" || usage[0].BytesSent != 500 || usage[0].Allocations != 1 {
+		t.Errorf("Expected the relayed bytes attributed to the room, got %+v", usage)
+	}
+}
+
+// selfSigned returns a TLS configuration with a self-signed certificate for
+// localhost, and a pool that trusts it
+func selfSigned(t *testing.T) (*tls.Config, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, roots
+}
+
+func TestServerTLS(t *testing.T) {
+	if _, err := Start(Config{ListenAddr: "127.0.0.1:0", PublicIP: net.ParseIP("127.0.0.1"), Secret: "s3cret", TLSListenAddr: "127.0.0.1:0"}); err == nil {
+		t.Error("Expected TURN over TLS without a certificate to be refused")
+	}
+
+	tlsConfig, roots := selfSigned(t)
+	server, err := Start(Config{
+		ListenAddr:    "127.0.0.1:0",
+		PublicIP:      net.ParseIP("127.0.0.1"),
+		Secret:        "s3cret",
+		TLSListenAddr: "127.0.0.1:0",
+		TLSConfig:     tlsConfig,
+		TLSHost:       "localhost",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	urls := server.URLs()
+	if len(urls) != 4 || !strings.HasPrefix(urls[3], "turns:localhost:") || !strings.HasSuffix(urls[3], "?transport=tcp") {
+		t.Fatalf("Expected a TURN over TLS URL, got %v", urls)
+	}
+
+	// A client that can only reach the server over TLS allocates a relay
+	address := strings.TrimSuffix(strings.TrimPrefix(urls[3], "turns:"), "?transport=tcp")
+	conn, err := tls.Dial("tcp", strings.Replace(address, "localhost", "127.0.0.1", 1), &tls.Config{ServerName: "localhost", RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	username, password, _ := turn.GenerateLongTermTURNRESTCredentials("s3cret", "alice", time.Minute)
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: address,
+		TURNServerAddr: address,
+		Conn:           turn.NewSTUNConn(conn),
+		Username:       username,
+		Password:       password,
+		Realm:          defaultRealm,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	relay, err := client.Allocate()
+	if err != nil {
+		t.Fatalf("Expected a relay over TLS, got %v", err)
+	}
+	relay.Close()
+}
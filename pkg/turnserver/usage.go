@@ -0,0 +1,224 @@
+package turnserver
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/ratelimit"
+	"github.com/pion/turn/v4"
+)
+
+// DefaultCap keys the bandwidth cap of tenants without one of their own
+const DefaultCap = "*"
+
+// Attribution names the room and tenant relayed traffic is billed to. Relays
+// of clients that aren't in a room on this node have an empty one
+type Attribution struct {
+	Room   string `json:"room"`
+	Tenant string `json:"tenant"`
+}
+
+// Usage is the traffic relayed for a room since the server started
+type Usage struct {
+	Attribution
+	BytesSent     uint64 `json:"bytesSent"`     // Relayed from clients to their peers
+	BytesReceived uint64 `json:"bytesReceived"` // Relayed from peers to the clients
+	BytesDropped  uint64 `json:"bytesDropped"`  // Dropped over the tenant's bandwidth cap
+	Allocations   int    `json:"allocations"`   // Relays allocated
+}
+
+// Meter attributes relayed traffic to rooms and tenants and holds each
+// tenant to its bandwidth cap
+type Meter struct {
+	attribute func(clientID string) Attribution
+	caps      map[string]int64 // Bytes per second by tenant
+
+	mutex   sync.Mutex
+	usage   map[Attribution]*Usage
+	relays  map[string]Attribution       // By relay address
+	buckets map[string]*ratelimit.Bucket // By tenant
+	now     func() time.Time
+}
+
+// NewMeter creates a meter. attribute names the room and tenant of a client
+// when it allocates a relay; caps limits tenants' relayed bytes per second,
+// with DefaultCap applying to tenants not listed. Tenants without a cap
+// aren't limited
+func NewMeter(attribute func(clientID string) Attribution, caps map[string]int64) *Meter {
+	return &Meter{
+		attribute: attribute,
+		caps:      caps,
+		usage:     make(map[Attribution]*Usage),
+		relays:    make(map[string]Attribution),
+		buckets:   make(map[string]*ratelimit.Bucket),
+		now:       time.Now,
+	}
+}
+
+// ParseCaps parses bandwidth caps such as "acme=20000,*=5000", in kilobits
+// per second by tenant, into bytes per second
+func ParseCaps(value string) (map[string]int64, error) {
+	caps := make(map[string]int64)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		tenant, rate, found := strings.Cut(entry, "=")
+		kbps, err := strconv.ParseInt(strings.TrimSpace(rate), 10, 64)
+		if !found || strings.TrimSpace(tenant) == "" || err != nil || kbps <= 0 {
+			return nil, fmt.Errorf("invalid bandwidth cap %q, expected tenant=kbps", entry)
+		}
+		caps[strings.TrimSpace(tenant)] = kbps * 1000 / 8
+	}
+	return caps, nil
+}
+
+// clientOf returns the client ID of a TURN REST username, <expiry>:<clientId>
+func clientOf(username string) string {
+	if _, clientID, found := strings.Cut(username, ":"); found {
+		return clientID
+	}
+	return username
+}
+
+// allocated attributes a relay to the room of the client that allocated it
+func (m *Meter) allocated(relay, username string) {
+	attribution := m.attribute(clientOf(username))
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.relays[relay] = attribution
+	m.usageLocked(attribution).Allocations++
+}
+
+// released forgets a relay once its allocation is gone
+func (m *Meter) released(relay string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.relays, relay)
+}
+
+// relayed counts n bytes through a relay and reports whether they fit the
+// tenant's cap; bytes that don't are counted as dropped
+func (m *Meter) relayed(relay string, n int, sent bool) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	attribution := m.relays[relay]
+	usage := m.usageLocked(attribution)
+	if !m.allowLocked(attribution.Tenant, n) {
+		usage.BytesDropped += uint64(n)
+		return false
+	}
+	if sent {
+		usage.BytesSent += uint64(n)
+	} else {
+		usage.BytesReceived += uint64(n)
+	}
+	return true
+}
+
+// Record adds traffic relayed elsewhere, such as by coturn, to a room
+func (m *Meter) Record(attribution Attribution, sent, received uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	usage := m.usageLocked(attribution)
+	usage.BytesSent += sent
+	usage.BytesReceived += received
+}
+
+// Usage returns the traffic of every room, sorted by tenant and room
+func (m *Meter) Usage() []Usage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	usage := make([]Usage, 0, len(m.usage))
+	for _, u := range m.usage {
+		usage = append(usage, *u)
+	}
+	slices.SortFunc(usage, func(a, b Usage) int {
+		if c := strings.Compare(a.Tenant, b.Tenant); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Room, b.Room)
+	})
+	return usage
+}
+
+// usageLocked returns the usage of a room, creating it
+func (m *Meter) usageLocked(attribution Attribution) *Usage {
+	usage, found := m.usage[attribution]
+	if !found {
+		usage = &Usage{Attribution: attribution}
+		m.usage[attribution] = usage
+	}
+	return usage
+}
+
+// allowLocked takes n bytes from the tenant's bucket, if it has a cap
+func (m *Meter) allowLocked(tenant string, n int) bool {
+	rate, capped := m.caps[tenant]
+	if !capped {
+		rate, capped = m.caps[DefaultCap]
+	}
+	if !capped {
+		return true
+	}
+	// Each bucket holds up to one second's worth of bytes
+	b, found := m.buckets[tenant]
+	now := m.now()
+	if !found {
+		b = ratelimit.NewBucket(float64(rate), float64(rate), now)
+		m.buckets[tenant] = b
+	}
+	return b.Take(float64(n), now)
+}
+
+// meteredRelays allocates relays whose traffic goes through the meter
+type meteredRelays struct {
+	turn.RelayAddressGenerator
+	meter *Meter
+}
+
+// AllocatePacketConn allocates a metered relay
+func (g meteredRelays) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := g.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &meteredConn{PacketConn: conn, meter: g.meter, relay: addr.String()}, addr, nil
+}
+
+// meteredConn is a relay that counts its traffic and drops what exceeds
+// its tenant's cap
+type meteredConn struct {
+	net.PacketConn
+	meter *Meter
+	relay string
+}
+
+// ReadFrom reads the next packet from a peer within the cap
+func (c *meteredConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil || c.meter.relayed(c.relay, n, false) {
+			return n, addr, err
+		}
+	}
+}
+
+// WriteTo sends a packet to a peer, or drops it over the cap
+func (c *meteredConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if !c.meter.relayed(c.relay, len(p), true) {
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// Close releases the relay
+func (c *meteredConn) Close() error {
+	c.meter.released(c.relay)
+	return c.PacketConn.Close()
+}
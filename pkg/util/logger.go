@@ -1,8 +1,10 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"runtime"
 	"strings"
@@ -32,19 +34,31 @@ var (
 
 // SetLogLevel sets the current logging level
 func SetLogLevel(level string) {
-	level = strings.ToUpper(level)
+	parsed, err := ParseLogLevel(level)
+	if err != nil {
+		log.Printf("Invalid log level: %s, using INFO", level)
+		parsed = LevelInfo
+	}
+	levelsMutex.Lock()
+	currentLevel = parsed
+	levelsMutex.Unlock()
+}
+
+// ParseLogLevel converts a level name in any case into one of the log levels
+func ParseLogLevel(level string) (string, error) {
+	level = strings.ToUpper(strings.TrimSpace(level))
 	switch level {
 	case LevelDebug, LevelInfo, LevelWarn, LevelError:
-		currentLevel = level
+		return level, nil
 	default:
-		log.Printf("Invalid log level: %s, using INFO", level)
-		currentLevel = LevelInfo
+		return "", fmt.Errorf("invalid log level: %q", level)
 	}
 }
 
 // shouldLog determines if a message at the given level should be logged
-func shouldLog(level string) bool {
-	switch currentLevel {
+// when the threshold is the given level
+func shouldLog(threshold, level string) bool {
+	switch threshold {
 	case LevelDebug:
 		return true
 	case LevelInfo:
@@ -58,66 +72,71 @@ func shouldLog(level string) bool {
 	}
 }
 
-// getCallerInfo gets the caller file and line number
-func getCallerInfo() string {
-	_, file, line, ok := runtime.Caller(3) // Skip getCallerInfo, logWithLevel, and the log function
-	if !ok {
-		return "unknown:0"
-	}
-	// Get just the file name, not the full path
-	parts := strings.Split(file, "/")
-	file = parts[len(parts)-1]
-	return fmt.Sprintf("%s:%d", file, line)
+// callerPC returns the program counter of the call site of a log function
+func callerPC() uintptr {
+	var pcs [1]uintptr
+	runtime.Callers(4, pcs[:]) // Skip Callers, callerPC, logWithLevel, and the log function
+	return pcs[0]
 }
 
-// logWithLevel logs a message with the specified level
-func logWithLevel(level, format string, args ...interface{}) {
-	if !shouldLog(level) {
+// logWithLevel logs a message with the specified level and the attributes
+// carried by ctx
+func logWithLevel(ctx context.Context, level, format string, args ...interface{}) {
+	// The caller is only looked up when a module may log at another level
+	threshold, modules := levels()
+	var pc uintptr
+	if modules {
+		pc = callerPC()
+		threshold = moduleLevel(moduleOf(pc), threshold)
+	}
+	if !shouldLog(threshold, level) {
 		return
 	}
-
-	var color string
-	switch level {
-	case LevelDebug:
-		color = colorBlue
-	case LevelInfo:
-		color = colorGreen
-	case LevelWarn:
-		color = colorYellow
-	case LevelError:
-		color = colorRed
+	if level == LevelDebug && !logSampler.sampleFormat(format) {
+		return
 	}
+	args = logRedactor.args(args)
 
-	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-	caller := getCallerInfo()
-	message := fmt.Sprintf(format, args...)
+	now := time.Now()
+	message := logRedactor.line(fmt.Sprintf(format, args...))
+	admitted, suppressed := logSampler.admit(level, message, now)
+	if !admitted {
+		return
+	}
+	if suppressed > 0 {
+		message += fmt.Sprintf(" (repeated %d more times)", suppressed)
+	}
+	if pc == 0 {
+		pc = callerPC()
+	}
 
-	log.Printf("%s%s [%s] %s - %s%s", color, timestamp, level, caller, message, colorReset)
+	record := slog.NewRecord(now, slogLevel(level), message, pc)
+	currentHandler().Handle(ctx, record)
 }
 
 // Debug logs a debug message
 func Debug(format string, args ...interface{}) {
-	logWithLevel(LevelDebug, format, args...)
+	logWithLevel(context.Background(), LevelDebug, format, args...)
 }
 
 // Info logs an info message
 func Info(format string, args ...interface{}) {
-	logWithLevel(LevelInfo, format, args...)
+	logWithLevel(context.Background(), LevelInfo, format, args...)
 }
 
 // Warn logs a warning message
 func Warn(format string, args ...interface{}) {
-	logWithLevel(LevelWarn, format, args...)
+	logWithLevel(context.Background(), LevelWarn, format, args...)
 }
 
 // Error logs an error message
 func Error(format string, args ...interface{}) {
-	logWithLevel(LevelError, format, args...)
+	logWithLevel(context.Background(), LevelError, format, args...)
 }
 
 // Fatal logs an error message and exits
 func Fatal(format string, args ...interface{}) {
-	logWithLevel(LevelError, format, args...)
+	logWithLevel(context.Background(), LevelError, format, args...)
 	os.Exit(1)
 }
 
@@ -127,10 +146,26 @@ func Init() {
 	if level := os.Getenv("LOG_LEVEL"); level != "" {
 		SetLogLevel(level)
 	}
+	if spec := os.Getenv("LOG_MODULE_LEVELS"); spec != "" {
+		modules, err := ParseModuleLevels(spec)
+		if err != nil {
+			log.Printf("Invalid LOG_MODULE_LEVELS: %v", err)
+		}
+		SetModuleLevels(modules)
+	}
+	initSampling()
+	initRedaction()
+	if format := os.Getenv("LOG_FORMAT"); format != "" {
+		if err := SetLogFormat(format); err != nil {
+			log.Printf("Invalid LOG_FORMAT: %s, using text", format)
+		}
+	}
 
-	// Configure standard logger to not print time (we add our own timestamp)
-	log.SetFlags(0)
-	log.SetOutput(os.Stdout)
+	// Libraries logging through log or log/slog log through this package;
+	// the file flag makes slog record where log was called from
+	log.SetFlags(log.Lshortfile)
+	slog.SetDefault(Logger())
 
-	Info("Logger initialized with level: %s", currentLevel)
+	level, modules := LogLevels()
+	Info("Logger initialized with level: %s%s%s", level, moduleSummary(modules), samplingSummary())
 }
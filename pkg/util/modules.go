@@ -0,0 +1,145 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// Guards currentLevel and moduleLevels, which change at runtime
+	levelsMutex sync.RWMutex
+
+	// Levels of modules logging at other than the current level, by module
+	moduleLevels map[string]string
+
+	// Module of each logging call site, by program counter
+	callerModules sync.Map
+)
+
+// SetModuleLevel sets the level of one module, such as "signaling" or
+// "sfu"; an empty level makes it log at the current level again. Modules
+// are named after their package; the server itself is "main"
+func SetModuleLevel(module, level string) error {
+	module = strings.ToLower(strings.TrimSpace(module))
+	if module == "" {
+		return errors.New("module is required")
+	}
+	if level != "" {
+		var err error
+		if level, err = ParseLogLevel(level); err != nil {
+			return err
+		}
+	}
+
+	levelsMutex.Lock()
+	defer levelsMutex.Unlock()
+	if level == "" {
+		delete(moduleLevels, module)
+		return nil
+	}
+	if moduleLevels == nil {
+		moduleLevels = make(map[string]string)
+	}
+	moduleLevels[module] = level
+	return nil
+}
+
+// SetModuleLevels replaces the levels of all modules
+func SetModuleLevels(levels map[string]string) {
+	levelsMutex.Lock()
+	moduleLevels = nil
+	levelsMutex.Unlock()
+	for module, level := range levels {
+		SetModuleLevel(module, level)
+	}
+}
+
+// LogLevels returns the current level and the levels of the modules that
+// log at another
+func LogLevels() (string, map[string]string) {
+	levelsMutex.RLock()
+	defer levelsMutex.RUnlock()
+	modules := make(map[string]string, len(moduleLevels))
+	for module, level := range moduleLevels {
+		modules[module] = level
+	}
+	return currentLevel, modules
+}
+
+// ParseModuleLevels parses module levels such as
+// "signaling=debug,sfu=info,main=warn". Valid entries are returned even
+// when others are not
+func ParseModuleLevels(spec string) (map[string]string, error) {
+	levels := make(map[string]string)
+	var invalid []string
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		module, level, found := strings.Cut(entry, "=")
+		module = strings.ToLower(strings.TrimSpace(module))
+		parsed, err := ParseLogLevel(level)
+		if !found || module == "" || err != nil {
+			invalid = append(invalid, strings.TrimSpace(entry))
+			continue
+		}
+		levels[module] = parsed
+	}
+	if len(invalid) > 0 {
+		return levels, fmt.Errorf("invalid module levels: %s", strings.Join(invalid, ", "))
+	}
+	return levels, nil
+}
+
+// levels returns the current level and whether any module logs at another
+func levels() (string, bool) {
+	levelsMutex.RLock()
+	defer levelsMutex.RUnlock()
+	return currentLevel, len(moduleLevels) > 0
+}
+
+// moduleLevel returns the level a module logs at
+func moduleLevel(module, current string) string {
+	levelsMutex.RLock()
+	defer levelsMutex.RUnlock()
+	if level, found := moduleLevels[module]; found {
+		return level
+	}
+	return current
+}
+
+// moduleOf returns the module of a call site: the last element of its
+// package path, e.g. "signaling" for pkg/signaling
+func moduleOf(pc uintptr) string {
+	if module, found := callerModules.Load(pc); found {
+		return module.(string)
+	}
+	module := ""
+	if function := runtime.FuncForPC(pc); function != nil {
+		// Names look like github.com/user/app/pkg/signaling.(*Room).Join
+		name := function.Name()
+		if slash := strings.LastIndex(name, "/"); slash >= 0 {
+			name = name[slash+1:]
+		}
+		module, _, _ = strings.Cut(name, ".")
+	}
+	callerModules.Store(pc, module)
+	return module
+}
+
+// moduleSummary describes the module levels for the startup line
+func moduleSummary(levels map[string]string) string {
+	if len(levels) == 0 {
+		return ""
+	}
+	entries := make([]string, 0, len(levels))
+	for module, level := range levels {
+		entries = append(entries, module+"="+level)
+	}
+	sort.Strings(entries)
+	return " (" + strings.Join(entries, ", ") + ")"
+}
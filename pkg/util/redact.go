@@ -0,0 +1,307 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redacted replaces the values of sensitive fields in log output
+const redacted = "[REDACTED]"
+
+// DefaultRedactedFields are the fields redacted from log output unless
+// LOG_REDACT_FIELDS says otherwise: credentials, session descriptions and
+// ICE candidates, and chat and caption text
+var DefaultRedactedFields = []string{
+	"token", "password", "secret", "credential", "authorization",
+	"sdp", "candidate", "text",
+}
+
+var (
+	// Bearer credentials and API tokens are redacted from formatted lines
+	// regardless of the fields
+	bearerPattern   = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`)
+	apiTokenPattern = regexp.MustCompile(`\bcvt_[A-Za-z0-9_-]+`)
+
+	// Session descriptions, raw or JSON-escaped, up to the end of the string
+	sdpPattern = regexp.MustCompile(`v=0(?:\\r\\n|\\n|\r?\n)o=(?:[^"\\]|\\[^"])*`)
+
+	fieldPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// redactor holds the redacted fields and the pattern matching them as
+// key=value pairs in formatted lines
+type redactor struct {
+	mutex  sync.RWMutex
+	fields map[string]bool
+	pairs  *regexp.Regexp
+}
+
+var logRedactor = newRedactor(DefaultRedactedFields)
+
+func newRedactor(fields []string) *redactor {
+	r := &redactor{}
+	r.set(fields)
+	return r
+}
+
+// SetRedactedFields replaces the fields whose values are redacted from log
+// output. Names are matched case-insensitively against map keys and JSON
+// field names, also as the last word of camelCase or snake_case names, so
+// "token" covers "uploadToken" and "access_token". No fields turns
+// redaction of structured values off
+func SetRedactedFields(fields []string) {
+	logRedactor.set(fields)
+}
+
+// Redact returns a copy of a map, slice or struct with the values of
+// redacted fields replaced, as it would be logged. Values without any
+// redacted field are returned as they are
+func Redact(value interface{}) interface{} {
+	redactedValue, _ := logRedactor.value(value)
+	return redactedValue
+}
+
+// ParseRedactedFields parses a comma-separated field list, such as
+// "token,sdp,text". "none" means no fields
+func ParseRedactedFields(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "none" {
+		return nil, nil
+	}
+	var fields []string
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !fieldPattern.MatchString(field) {
+			return nil, fmt.Errorf("invalid field name: %q", field)
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("no fields given")
+	}
+	return fields, nil
+}
+
+func (r *redactor) set(fields []string) {
+	lowered := make(map[string]bool, len(fields))
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		field = strings.ToLower(field)
+		if !lowered[field] {
+			lowered[field] = true
+			names = append(names, regexp.QuoteMeta(field))
+		}
+	}
+	var pairs *regexp.Regexp
+	if len(names) > 0 {
+		// key=value as in query strings, key: value as in %+v output and
+		// "key":"value" as in JSON. Unquoted values, such as chat text, run
+		// to the next delimiter or the end of the line
+		pairs = regexp.MustCompile(`(?i)\b(\w*(?:` + strings.Join(names, "|") + `))("?\s*[=:]\s*)("(?:[^"\\]|\\.)*"|[^&,{}\[\]"\r\n]+)`)
+	}
+
+	r.mutex.Lock()
+	r.fields = lowered
+	r.pairs = pairs
+	r.mutex.Unlock()
+}
+
+// sensitive reports whether a key names a redacted field
+func (r *redactor) sensitive(key string) bool {
+	lowered := strings.ToLower(key)
+	if r.fields[lowered] {
+		return true
+	}
+	for field := range r.fields {
+		if !strings.HasSuffix(lowered, field) {
+			continue
+		}
+		// The field must be a whole word: uploadToken or access_token, not
+		// context
+		boundary := len(key) - len(field)
+		if boundary < 1 {
+			continue
+		}
+		if key[boundary-1] == '_' || key[boundary-1] == '-' ||
+			(key[boundary] >= 'A' && key[boundary] <= 'Z') {
+			return true
+		}
+	}
+	return false
+}
+
+// args redacts the structured arguments of a log line
+func (r *redactor) args(args []interface{}) []interface{} {
+	var redactedArgs []interface{}
+	for i, arg := range args {
+		value, replaced := r.value(arg)
+		if !replaced {
+			continue
+		}
+		if redactedArgs == nil {
+			redactedArgs = make([]interface{}, len(args))
+			copy(redactedArgs, args)
+		}
+		redactedArgs[i] = value
+	}
+	if redactedArgs == nil {
+		return args
+	}
+	return redactedArgs
+}
+
+// value redacts a map, slice or struct by walking its JSON form, so struct
+// fields are matched by their JSON names. It reports whether anything was
+// redacted. Errors, Stringers and scalars are logged as they are
+func (r *redactor) value(value interface{}) (interface{}, bool) {
+	switch value.(type) {
+	case nil, error, fmt.Stringer:
+		return value, false
+	}
+	kind := reflect.TypeOf(value).Kind()
+	if kind == reflect.Pointer {
+		kind = reflect.TypeOf(value).Elem().Kind()
+	}
+	switch kind {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+	default:
+		return value, false
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if len(r.fields) == 0 {
+		return value, false
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return value, false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil || !r.walk(generic) {
+		return value, false
+	}
+	return generic, true
+}
+
+// walk replaces the values of redacted fields in a decoded JSON value,
+// reporting whether any was replaced
+func (r *redactor) walk(value interface{}) bool {
+	replaced := false
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if field != nil && r.sensitive(key) {
+				value[key] = redacted
+				replaced = true
+			} else if r.walk(field) {
+				replaced = true
+			}
+		}
+	case []interface{}:
+		for _, element := range value {
+			if r.walk(element) {
+				replaced = true
+			}
+		}
+	}
+	return replaced
+}
+
+// line redacts credentials and session descriptions left in a formatted
+// log line
+func (r *redactor) line(message string) string {
+	r.mutex.RLock()
+	if r.fields["sdp"] {
+		message = sdpPattern.ReplaceAllString(message, redacted)
+	}
+	if r.pairs != nil {
+		message = r.redactPairs(message)
+	}
+	r.mutex.RUnlock()
+
+	// After the pairs, so "Authorization: Bearer ..." is redacted once
+	message = bearerPattern.ReplaceAllString(message, "Bearer "+redacted)
+	return apiTokenPattern.ReplaceAllString(message, redacted)
+}
+
+// redactPairs replaces the values of redacted fields in key=value pairs.
+// The value of any other field may hold pairs of its own, since unquoted
+// values run to the end of the line
+func (r *redactor) redactPairs(message string) string {
+	return r.pairs.ReplaceAllStringFunc(message, func(pair string) string {
+		match := r.pairs.FindStringSubmatch(pair)
+		if !r.sensitive(match[1]) {
+			return match[1] + match[2] + r.redactPairs(match[3])
+		}
+		if strings.HasPrefix(match[3], `"`) {
+			return match[1] + match[2] + `"` + redacted + `"`
+		}
+		return match[1] + match[2] + redacted
+	})
+}
+
+// attr redacts a slog attribute: all of its value if its key is a redacted
+// field, otherwise the redacted fields and credentials within the value
+func (r *redactor) attr(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	if attr.Value.Kind() == slog.KindGroup {
+		members := attr.Value.Group()
+		redactedMembers := make([]slog.Attr, len(members))
+		for i, member := range members {
+			redactedMembers[i] = r.attr(member)
+		}
+		return slog.Attr{Key: attr.Key, Value: slog.GroupValue(redactedMembers...)}
+	}
+
+	r.mutex.RLock()
+	hidden := r.sensitive(attr.Key)
+	r.mutex.RUnlock()
+	switch {
+	case hidden:
+		return slog.String(attr.Key, redacted)
+	case attr.Value.Kind() == slog.KindString:
+		return slog.String(attr.Key, r.line(attr.Value.String()))
+	case attr.Value.Kind() == slog.KindAny:
+		if value, replaced := r.value(attr.Value.Any()); replaced {
+			return slog.Any(attr.Key, value)
+		}
+	}
+	return attr
+}
+
+// attrs redacts slog attributes into a new slice
+func (r *redactor) attrs(attrs []slog.Attr) []slog.Attr {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redactedAttrs[i] = r.attr(attr)
+	}
+	return redactedAttrs
+}
+
+// initRedaction reads LOG_REDACT_FIELDS
+func initRedaction() {
+	spec := os.Getenv("LOG_REDACT_FIELDS")
+	if spec == "" {
+		return
+	}
+	fields, err := ParseRedactedFields(spec)
+	if err != nil {
+		log.Printf("Invalid LOG_REDACT_FIELDS: %v, redacting the default fields", err)
+		return
+	}
+	SetRedactedFields(fields)
+}
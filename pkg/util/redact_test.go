@@ -0,0 +1,146 @@
+package util
+
+import (
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactorSensitive(t *testing.T) {
+	r := newRedactor(DefaultRedactedFields)
+	for key, want := range map[string]bool{
+		"token":        true,
+		"Token":        true,
+		"TEXT":         true,
+		"uploadToken":  true,
+		"access_token": true,
+		"upload-token": true,
+		"iceCandidate": true,
+		"sdp":          true,
+		"context":      false,
+		"subtext":      false,
+		"texts":        false,
+		"roomId":       false,
+		"":             false,
+	} {
+		if got := r.sensitive(key); got != want {
+			t.Errorf("Expected sensitive(%q) to be %v", key, want)
+		}
+	}
+
+	if newRedactor(nil).sensitive("token") {
+		t.Error("Expected no fields to be sensitive without redacted fields")
+	}
+}
+
+func TestRedactorLine(t *testing.T) {
+	r := newRedactor(DefaultRedactedFields)
+	for _, test := range []struct {
+		line, want string
+	}{
+		{"/ws?roomId=a&token=abc123&clientId=b", "/ws?roomId=a&token=[REDACTED]&clientId=b"},
+		{"chat from alice text: hello world", "chat from alice text: [REDACTED]"},
+		{"relaying text=secret chat line", "relaying text=[REDACTED]"},
+		{"map[from:alice text:hello world to:bob] sent", "map[from:alice text:[REDACTED]] sent"},
+		{`{"text":"hi there","from":"alice"}`, `{"text":"[REDACTED]","from":"alice"}`},
+		{`{"uploadToken": "a \"quoted\" token"}`, `{"uploadToken": "[REDACTED]"}`},
+		{"context: shown, token: hidden", "context: shown, token: [REDACTED]"},
+		{"context: shown token: hidden", "context: shown token: [REDACTED]"},
+		{"Authorization: Bearer abc.def", "Authorization: [REDACTED]"},
+		{"sent bearer abc.def/ghi= upstream", "sent Bearer [REDACTED] upstream"},
+		{"key cvt_abc_123 used", "key [REDACTED] used"},
+		{"offer v=0\r\no=- 123 2 IN IP4 127.0.0.1\r\ns=-", "offer [REDACTED]"},
+		{`{"sdp":"v=0\r\no=- 1 2 IN IP4 0.0.0.0\r\n","type":"offer"}`, `{"sdp":"[REDACTED]","type":"offer"}`},
+		{"room standup has 3 clients", "room standup has 3 clients"},
+	} {
+		if got := r.line(test.line); got != test.want {
+			t.Errorf("Expected %q to be redacted as %q, got %q", test.line, test.want, got)
+		}
+	}
+
+	// Credentials are redacted without any fields; the rest isn't
+	none := newRedactor(nil)
+	if got := none.line("token=abc Bearer abc text: hi"); got != "token=abc Bearer [REDACTED] text: hi" {
+		t.Errorf("Expected only the bearer credential redacted, got %q", got)
+	}
+}
+
+func TestRedactorValue(t *testing.T) {
+	type upload struct {
+		Name        string `json:"name"`
+		UploadToken string `json:"uploadToken"`
+	}
+	r := newRedactor(DefaultRedactedFields)
+	for _, test := range []struct {
+		name     string
+		value    interface{}
+		want     interface{}
+		replaced bool
+	}{
+		{
+			"map",
+			map[string]interface{}{"token": "abc", "room": "r"},
+			map[string]interface{}{"token": redacted, "room": "r"},
+			true,
+		},
+		{
+			"nested structs",
+			[]upload{{Name: "a.webm", UploadToken: "secret"}},
+			[]interface{}{map[string]interface{}{"name": "a.webm", "uploadToken": redacted}},
+			true,
+		},
+		{
+			"pointer",
+			&upload{Name: "a.webm", UploadToken: "secret"},
+			map[string]interface{}{"name": "a.webm", "uploadToken": redacted},
+			true,
+		},
+		{
+			"null field",
+			map[string]interface{}{"token": nil},
+			map[string]interface{}{"token": nil},
+			false,
+		},
+		{
+			"nothing sensitive",
+			map[string]int{"count": 3},
+			map[string]int{"count": 3},
+			false,
+		},
+		{"string", "token=abc", "token=abc", false},
+		{"error", errors.New("token=abc"), errors.New("token=abc"), false},
+	} {
+		got, replaced := r.value(test.value)
+		if replaced != test.replaced || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %#v (%v), got %#v (%v)", test.name, test.want, test.replaced, got, replaced)
+		}
+	}
+
+	if _, replaced := newRedactor(nil).value(map[string]string{"token": "abc"}); replaced {
+		t.Error("Expected nothing redacted without redacted fields")
+	}
+}
+
+func TestLoggerRedactsAttrs(t *testing.T) {
+	output := captureLog(t, textOutput)
+	Logger().With("password", "hunter2").Info("chat relayed",
+		"text", "hello world",
+		"url", "/ws?roomId=a&token=abc123",
+		"data", map[string]interface{}{"sdp": "v=0", "type": "offer"},
+		slog.Group("request", "authorization", "Bearer abc123", "room", "standup"),
+		"count", 3)
+
+	line := output.String()
+	for _, leaked := range []string{"hunter2", "hello world", "abc123", "v=0"} {
+		if strings.Contains(line, leaked) {
+			t.Errorf("Expected %q to be redacted, got %q", leaked, line)
+		}
+	}
+	for _, kept := range []string{"password=[REDACTED]", "text=[REDACTED]", "token=[REDACTED]", "request.authorization=[REDACTED]", "request.room=standup", "count=3", "type:offer"} {
+		if !strings.Contains(line, kept) {
+			t.Errorf("Expected %q in %q", kept, line)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package util
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How many distinct messages are remembered for deduplication before the
+// ones whose window passed are forgotten
+const maxRememberedMessages = 10000
+
+// sampler thins out high-volume log lines: debug lines are sampled per
+// format string, and identical messages are logged at most once per window
+type sampler struct {
+	mutex sync.Mutex
+
+	every  int            // Log one in every this many debug lines of a format; 1 or less logs all
+	counts map[string]int // Debug lines seen, by format string
+
+	window time.Duration             // Identical messages within it are dropped; zero disables
+	recent map[string]*recentMessage // Messages logged within the window, by level and text
+}
+
+// recentMessage is a message logged recently, and how often it was dropped
+// since
+type recentMessage struct {
+	logged     time.Time
+	suppressed int
+}
+
+var logSampler sampler
+
+// SetSampling logs only one in every debug lines of each format string, and
+// drops a message identical to one logged less than window ago. The first
+// message logged after the window notes how many were dropped. Errors are
+// never dropped. Zero values turn either off
+func SetSampling(every int, window time.Duration) {
+	logSampler.mutex.Lock()
+	defer logSampler.mutex.Unlock()
+	logSampler.every = every
+	logSampler.counts = make(map[string]int)
+	logSampler.window = window
+	logSampler.recent = make(map[string]*recentMessage)
+}
+
+// sampleFormat reports whether a debug line of the given format is the one
+// logged of its sample
+func (s *sampler) sampleFormat(format string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.every <= 1 {
+		return true
+	}
+	count := s.counts[format]
+	s.counts[format] = (count + 1) % s.every
+	return count == 0
+}
+
+// admit reports whether a message should be logged, with how many identical
+// ones were dropped since it was last logged
+func (s *sampler) admit(level, message string, now time.Time) (bool, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.window <= 0 || level == LevelError {
+		return true, 0
+	}
+
+	key := level + " " + message
+	if recent, found := s.recent[key]; found {
+		if now.Sub(recent.logged) < s.window {
+			recent.suppressed++
+			return false, 0
+		}
+		suppressed := recent.suppressed
+		recent.logged, recent.suppressed = now, 0
+		return true, suppressed
+	}
+
+	if len(s.recent) >= maxRememberedMessages {
+		for key, recent := range s.recent {
+			if now.Sub(recent.logged) >= s.window {
+				delete(s.recent, key)
+			}
+		}
+		if len(s.recent) >= maxRememberedMessages {
+			return true, 0 // Too many distinct messages to deduplicate
+		}
+	}
+	s.recent[key] = &recentMessage{logged: now}
+	return true, 0
+}
+
+// initSampling reads LOG_DEBUG_SAMPLE and LOG_DEDUPE_WINDOW
+func initSampling() {
+	every := 0
+	if value := os.Getenv("LOG_DEBUG_SAMPLE"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			log.Printf("Invalid LOG_DEBUG_SAMPLE: %s, logging every debug line", value)
+		} else {
+			every = parsed
+		}
+	}
+
+	var window time.Duration
+	if value := os.Getenv("LOG_DEDUPE_WINDOW"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			log.Printf("Invalid LOG_DEDUPE_WINDOW: %s, logging identical messages", value)
+		} else {
+			window = parsed
+		}
+	}
+
+	SetSampling(every, window)
+}
+
+// samplingSummary describes the sampling in effect for the startup line
+func samplingSummary() string {
+	logSampler.mutex.Lock()
+	defer logSampler.mutex.Unlock()
+	summary := ""
+	if logSampler.every > 1 {
+		summary += fmt.Sprintf(", 1 in %d debug lines", logSampler.every)
+	}
+	if logSampler.window > 0 {
+		summary += fmt.Sprintf(", identical messages once per %v", logSampler.window)
+	}
+	return summary
+}
@@ -0,0 +1,136 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSampler(every int, window time.Duration) *sampler {
+	return &sampler{
+		every:  every,
+		counts: make(map[string]int),
+		window: window,
+		recent: make(map[string]*recentMessage),
+	}
+}
+
+func TestSampleFormat(t *testing.T) {
+	s := newSampler(3, 0)
+	var sampled []bool
+	for range 7 {
+		sampled = append(sampled, s.sampleFormat("Received %s from client %s"))
+	}
+	want := []bool{true, false, false, true, false, false, true}
+	if fmt.Sprint(sampled) != fmt.Sprint(want) {
+		t.Errorf("Expected one in every 3 lines sampled, got %v", sampled)
+	}
+	if !s.sampleFormat("Sent %s to %s") {
+		t.Error("Expected each format to be sampled on its own")
+	}
+
+	all := newSampler(1, 0)
+	for range 3 {
+		if !all.sampleFormat("Received %s from client %s") {
+			t.Error("Expected every line logged without sampling")
+		}
+	}
+}
+
+func TestAdmitRepeats(t *testing.T) {
+	s := newSampler(0, 10*time.Second)
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	admit := func(level, message string, after time.Duration) string {
+		admitted, suppressed := s.admit(level, message, start.Add(after))
+		return fmt.Sprint(admitted, suppressed)
+	}
+
+	for _, step := range []struct {
+		level, message string
+		after          time.Duration
+		want           string
+	}{
+		{LevelWarn, "Recipient bob not found", 0, "true 0"},
+		{LevelWarn, "Recipient bob not found", time.Second, "false 0"},
+		{LevelWarn, "Recipient bob not found", 9 * time.Second, "false 0"},
+		// Another level or message is logged on its own
+		{LevelInfo, "Recipient bob not found", 2 * time.Second, "true 0"},
+		{LevelWarn, "Recipient carol not found", 2 * time.Second, "true 0"},
+		// After the window, with the count of those dropped
+		{LevelWarn, "Recipient bob not found", 10 * time.Second, "true 2"},
+		{LevelWarn, "Recipient bob not found", 11 * time.Second, "false 0"},
+		{LevelWarn, "Recipient bob not found", 25 * time.Second, "true 1"},
+		// Errors are never dropped
+		{LevelError, "Backplane unavailable", 0, "true 0"},
+		{LevelError, "Backplane unavailable", time.Second, "true 0"},
+	} {
+		if got := admit(step.level, step.message, step.after); got != step.want {
+			t.Errorf("Expected %s %q after %v to give %s, got %s", step.level, step.message, step.after, step.want, got)
+		}
+	}
+
+	off := newSampler(0, 0)
+	for range 2 {
+		if admitted, _ := off.admit(LevelWarn, "Recipient bob not found", start); !admitted {
+			t.Error("Expected repeats logged without a window")
+		}
+	}
+}
+
+func TestAdmitBounded(t *testing.T) {
+	s := newSampler(0, 10*time.Second)
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := range maxRememberedMessages {
+		s.admit(LevelWarn, fmt.Sprintf("message %d", i), start)
+	}
+	if len(s.recent) != maxRememberedMessages {
+		t.Fatalf("Expected %d messages remembered, got %d", maxRememberedMessages, len(s.recent))
+	}
+
+	// With every message still in its window, new ones are logged but not
+	// remembered
+	later := start.Add(time.Second)
+	for range 2 {
+		if admitted, _ := s.admit(LevelWarn, "overflow", later); !admitted {
+			t.Error("Expected a message beyond the limit to be logged")
+		}
+	}
+	if len(s.recent) != maxRememberedMessages {
+		t.Errorf("Expected the map to stay at %d, got %d", maxRememberedMessages, len(s.recent))
+	}
+
+	// Once their window passed, the old messages are forgotten
+	if admitted, _ := s.admit(LevelWarn, "fresh", start.Add(10*time.Second)); !admitted {
+		t.Error("Expected a new message to be logged")
+	}
+	if len(s.recent) != 1 {
+		t.Errorf("Expected only the new message remembered, got %d", len(s.recent))
+	}
+	if admitted, _ := s.admit(LevelWarn, "fresh", start.Add(11*time.Second)); admitted {
+		t.Error("Expected the new message to be deduplicated")
+	}
+}
+
+func TestRepeatedNote(t *testing.T) {
+	SetSampling(0, time.Hour)
+	defer SetSampling(0, 0)
+	output := captureLog(t, textOutput)
+	for range 3 {
+		Warn("Recipient %s not found", "bob")
+	}
+	if lines := strings.Count(output.String(), "\n"); lines != 1 {
+		t.Fatalf("Expected the repeats dropped, got %d lines", lines)
+	}
+
+	// Move the logged message out of its window
+	logSampler.mutex.Lock()
+	for _, recent := range logSampler.recent {
+		recent.logged = recent.logged.Add(-time.Hour)
+	}
+	logSampler.mutex.Unlock()
+	Warn("Recipient %s not found", "bob")
+	if !strings.Contains(output.String(), "Recipient bob not found (repeated 2 more times)") {
+		t.Errorf("Expected the next line to count the dropped ones, got %q", output.String())
+	}
+}
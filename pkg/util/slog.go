@@ -0,0 +1,312 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Log output formats
+const (
+	FormatText = "text" // Colored lines for terminals
+	FormatJSON = "json" // One JSON object per line for log collectors
+)
+
+var (
+	// Guards handler, which LOG_FORMAT may replace at startup
+	handlerMutex sync.RWMutex
+
+	// Writes every record the package logs
+	handler slog.Handler = contextHandler{newTextHandler(os.Stdout)}
+)
+
+// SetLogFormat switches the output between FormatText and FormatJSON
+func SetLogFormat(format string) error {
+	var next slog.Handler
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case FormatText:
+		next = newTextHandler(os.Stdout)
+	case FormatJSON:
+		next = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug})
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	handlerMutex.Lock()
+	handler = contextHandler{next}
+	handlerMutex.Unlock()
+	return nil
+}
+
+// currentHandler returns the handler records are written with
+func currentHandler() slog.Handler {
+	handlerMutex.RLock()
+	defer handlerMutex.RUnlock()
+	return handler
+}
+
+// Logger returns a slog logger that logs through this package: at the
+// current and module levels, with redaction, and in the configured format
+func Logger() *slog.Logger {
+	return slog.New(&bridgeHandler{})
+}
+
+// slogLevel converts a log level into a slog level
+func slogLevel(level string) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// levelName converts a slog level into a log level
+func levelName(level slog.Level) string {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+// logAttrsKey is the context key of the log attributes a context carries
+type logAttrsKey struct{}
+
+// WithAttrs returns a context whose log lines carry the given attributes
+// besides those ctx already carries
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing := ContextAttrs(ctx)
+	combined := make([]slog.Attr, 0, len(existing)+len(attrs))
+	combined = append(combined, existing...)
+	combined = append(combined, attrs...)
+	return context.WithValue(ctx, logAttrsKey{}, combined)
+}
+
+// WithRoom returns a context whose log lines name the room
+func WithRoom(ctx context.Context, roomID string) context.Context {
+	return WithAttrs(ctx, slog.String("room", roomID))
+}
+
+// WithClient returns a context whose log lines name the client
+func WithClient(ctx context.Context, clientID string) context.Context {
+	return WithAttrs(ctx, slog.String("client", clientID))
+}
+
+// WithTenant returns a context whose log lines name the tenant; an empty
+// tenant adds nothing
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	if tenant == "" {
+		return ctx
+	}
+	return WithAttrs(ctx, slog.String("tenant", tenant))
+}
+
+// ContextAttrs returns the log attributes a context carries
+func ContextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// DebugContext logs a debug message with the attributes ctx carries
+func DebugContext(ctx context.Context, format string, args ...interface{}) {
+	logWithLevel(ctx, LevelDebug, format, args...)
+}
+
+// InfoContext logs an info message with the attributes ctx carries
+func InfoContext(ctx context.Context, format string, args ...interface{}) {
+	logWithLevel(ctx, LevelInfo, format, args...)
+}
+
+// WarnContext logs a warning with the attributes ctx carries
+func WarnContext(ctx context.Context, format string, args ...interface{}) {
+	logWithLevel(ctx, LevelWarn, format, args...)
+}
+
+// ErrorContext logs an error with the attributes ctx carries
+func ErrorContext(ctx context.Context, format string, args ...interface{}) {
+	logWithLevel(ctx, LevelError, format, args...)
+}
+
+// contextHandler adds the attributes a context carries to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs := ContextAttrs(ctx); len(attrs) > 0 {
+		record = record.Clone()
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// bridgeHandler logs records of slog loggers as this package's functions
+// would: filtered by level, redacted and written with the current handler
+type bridgeHandler struct {
+	attrs  []slog.Attr
+	groups []string
+}
+
+func (h *bridgeHandler) Enabled(_ context.Context, level slog.Level) bool {
+	threshold, modules := levels()
+	// A module may log below the current level; Handle decides
+	return modules || shouldLog(threshold, levelName(level))
+}
+
+func (h *bridgeHandler) Handle(ctx context.Context, record slog.Record) error {
+	threshold, modules := levels()
+	if modules && record.PC != 0 {
+		threshold = moduleLevel(moduleOf(record.PC), threshold)
+	}
+	if !shouldLog(threshold, levelName(record.Level)) {
+		return nil
+	}
+	redacted := slog.NewRecord(record.Time, record.Level, logRedactor.line(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(logRedactor.attr(attr))
+		return true
+	})
+
+	target := currentHandler()
+	if len(h.attrs) > 0 {
+		target = target.WithAttrs(logRedactor.attrs(h.attrs))
+	}
+	for _, group := range h.groups {
+		target = target.WithGroup(group)
+	}
+	return target.Handle(ctx, redacted)
+}
+
+func (h *bridgeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(h.groups) > 0 {
+		// Attributes added within a group belong to it
+		attrs = []slog.Attr{{Key: strings.Join(h.groups, "."), Value: slog.GroupValue(attrs...)}}
+	}
+	return &bridgeHandler{attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...), groups: h.groups}
+}
+
+func (h *bridgeHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &bridgeHandler{attrs: h.attrs, groups: append(append([]string(nil), h.groups...), name)}
+}
+
+// textHandler writes records as colored lines:
+// "<time> [LEVEL] file.go:42 - message key=value"
+type textHandler struct {
+	mutex  *sync.Mutex
+	out    io.Writer
+	attrs  string // Preformatted attributes added with WithAttrs
+	prefix string // Groups opened with WithGroup, joined with dots
+}
+
+func newTextHandler(out io.Writer) *textHandler {
+	return &textHandler{mutex: &sync.Mutex{}, out: out}
+}
+
+func (h *textHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *textHandler) Handle(_ context.Context, record slog.Record) error {
+	var color string
+	switch levelName(record.Level) {
+	case LevelDebug:
+		color = colorBlue
+	case LevelInfo:
+		color = colorGreen
+	case LevelWarn:
+		color = colorYellow
+	case LevelError:
+		color = colorRed
+	}
+
+	caller := "unknown:0"
+	if record.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		if frame.File != "" {
+			caller = frame.File[strings.LastIndex(frame.File, "/")+1:] + ":" + strconv.Itoa(frame.Line)
+		}
+	}
+
+	var line strings.Builder
+	line.WriteString(color)
+	line.WriteString(record.Time.Format("2006-01-02 15:04:05.000"))
+	line.WriteString(" [" + levelName(record.Level) + "] " + caller + " - " + record.Message)
+	line.WriteString(h.attrs)
+	record.Attrs(func(attr slog.Attr) bool {
+		appendAttr(&line, h.prefix, attr)
+		return true
+	})
+	line.WriteString(colorReset + "\n")
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	_, err := io.WriteString(h.out, line.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var formatted strings.Builder
+	for _, attr := range attrs {
+		appendAttr(&formatted, h.prefix, attr)
+	}
+	return &textHandler{mutex: h.mutex, out: h.out, attrs: h.attrs + formatted.String(), prefix: h.prefix}
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &textHandler{mutex: h.mutex, out: h.out, attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+// appendAttr writes an attribute as " key=value", groups as their members
+// with dotted keys. Values with spaces or quotes are quoted
+func appendAttr(line *strings.Builder, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			appendAttr(line, prefix, member)
+		}
+		return
+	}
+	value := attr.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	line.WriteString(" " + prefix + attr.Key + "=" + value)
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// captureLog writes the package's log output to a buffer, with the handler
+// newHandler creates, for the rest of the test
+func captureLog(t *testing.T, newHandler func(io.Writer) slog.Handler) *bytes.Buffer {
+	var buffer bytes.Buffer
+	handlerMutex.Lock()
+	previous := handler
+	handler = contextHandler{newHandler(&buffer)}
+	handlerMutex.Unlock()
+	t.Cleanup(func() {
+		handlerMutex.Lock()
+		handler = previous
+		handlerMutex.Unlock()
+	})
+	return &buffer
+}
+
+func textOutput(out io.Writer) slog.Handler {
+	return newTextHandler(out)
+}
+
+func jsonOutput(out io.Writer) slog.Handler {
+	return slog.NewJSONHandler(out, nil)
+}
+
+func TestContextAttrs(t *testing.T) {
+	ctx := WithTenant(WithClient(WithRoom(context.Background(), "standup"), "alice"), "acme")
+	if attrs := ContextAttrs(WithTenant(ctx, "")); len(attrs) != 3 {
+		t.Errorf("Expected an empty tenant to add nothing, got %v", attrs)
+	}
+
+	logs := map[string]func(){
+		"InfoContext": func() { InfoContext(ctx, "Client joined") },
+		"slog logger": func() { Logger().InfoContext(ctx, "Client joined") },
+	}
+	for name, log := range logs {
+		t.Run(name+" text", func(t *testing.T) {
+			output := captureLog(t, textOutput)
+			log()
+			line := output.String()
+			for _, attr := range []string{"Client joined", " room=standup", " client=alice", " tenant=acme"} {
+				if !strings.Contains(line, attr) {
+					t.Errorf("Expected %q in %q", attr, line)
+				}
+			}
+		})
+		t.Run(name+" json", func(t *testing.T) {
+			output := captureLog(t, jsonOutput)
+			log()
+			var record map[string]interface{}
+			if err := json.Unmarshal(output.Bytes(), &record); err != nil {
+				t.Fatalf("Expected a JSON record, got %q: %v", output.String(), err)
+			}
+			if record["msg"] != "Client joined" || record["room"] != "standup" || record["client"] != "alice" || record["tenant"] != "acme" {
+				t.Errorf("Expected the message with the context's attributes, got %v", record)
+			}
+		})
+	}
+
+	// Lines without a context carry no attributes
+	output := captureLog(t, textOutput)
+	Info("Started")
+	if line := output.String(); strings.Contains(line, "room=") || !strings.Contains(line, "Started") {
+		t.Errorf("Expected a plain line, got %q", line)
+	}
+}
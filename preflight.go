@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// handleCanJoin answers whether a join of the room would succeed, for the
+// pre-join screen. caps lists the client's capabilities, comma-separated;
+// clientId and userId are checked against the room's bans along with the
+// caller's address
+func handleCanJoin(w http.ResponseWriter, r *http.Request) {
+	var caps []string
+	for _, capability := range strings.Split(r.URL.Query().Get("caps"), ",") {
+		if capability = strings.TrimSpace(capability); capability != "" {
+			caps = append(caps, capability)
+		}
+	}
+	writeJSON(w, http.StatusOK, hub.CheckJoin(r.PathValue("id"),
+		r.URL.Query().Get("clientId"), r.URL.Query().Get("userId"), clientAddress(r), caps))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/sfu"
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+var (
+	// Name of this server in pre-warming reports and metric tags
+	nodeName string
+
+	// Rooms pre-warmed for scheduled events, by room ID
+	prewarmed      = make(map[string]prewarm)
+	prewarmedMutex sync.Mutex
+)
+
+// prewarm is a room prepared ahead of a large scheduled event
+type prewarm struct {
+	ExpectedParticipants int       `json:"expectedParticipants"`
+	StartsAt             time.Time `json:"startsAt,omitzero"`
+	PreparedAt           time.Time `json:"preparedAt"`
+}
+
+// prewarmReport tells whether a pre-warmed room is ready for its event
+type prewarmReport struct {
+	RoomID string `json:"roomId"`
+	Node   string `json:"node"` // Node the room is pinned to; route its participants here
+	prewarm
+	Ready       bool          `json:"ready"`
+	Room        bool          `json:"room"`        // The room exists
+	SFUReserved int           `json:"sfuReserved"` // SFU sessions set aside for the room
+	Capacity    *sfu.Capacity `json:"capacity,omitempty"`
+}
+
+// registerPrewarmAPI adds the endpoints to prepare rooms for large events
+func registerPrewarmAPI(mux *http.ServeMux) {
+	mux.HandleFunc("PUT /api/rooms/{id}/prewarm", requireAdmin(handlePrewarmRoom))
+	mux.HandleFunc("GET /api/rooms/{id}/prewarm", requireAdmin(handlePrewarmStatus))
+	mux.HandleFunc("DELETE /api/rooms/{id}/prewarm", requireAdmin(handleCancelPrewarm))
+}
+
+// handlePrewarmRoom prepares a room for {"expectedParticipants", "startsAt",
+// "settings"}: SFU sessions are reserved for everyone expected and the room
+// is created if needed. When this node can't take the event it answers 503,
+// so the caller can try another node
+func handlePrewarmRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := r.PathValue("id")
+	request := struct {
+		ExpectedParticipants int                     `json:"expectedParticipants"`
+		StartsAt             time.Time               `json:"startsAt"`
+		Settings             *signaling.RoomSettings `json:"settings"`
+	}{}
+	if err := decodeJSON(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if request.ExpectedParticipants <= 0 {
+		writeError(w, http.StatusBadRequest, "expectedParticipants must be positive")
+		return
+	}
+
+	if mediaSFU != nil {
+		if err := mediaSFU.Reserve(roomID, request.ExpectedParticipants); errors.Is(err, sfu.ErrNoCapacity) {
+			util.Warn("Node %s can't pre-warm room %s for %d participants: %v", nodeName, roomID, request.ExpectedParticipants, err)
+			writeError(w, http.StatusServiceUnavailable, "node "+nodeName+": "+err.Error())
+			return
+		} else if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if hub.FindRoom(roomID) == nil {
+		settings := hub.DefaultSettings()
+		if request.Settings != nil {
+			settings = *request.Settings
+		}
+		if _, err := hub.ImportRoom(signaling.RoomSnapshot{ID: roomID, Settings: settings}); err != nil && !errors.Is(err, signaling.ErrRoomExists) {
+			if mediaSFU != nil {
+				mediaSFU.Release(roomID)
+			}
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	prewarmedMutex.Lock()
+	prewarmed[roomID] = prewarm{
+		ExpectedParticipants: request.ExpectedParticipants,
+		StartsAt:             request.StartsAt,
+		PreparedAt:           time.Now(),
+	}
+	prewarmedMutex.Unlock()
+
+	util.Info("Room %s pre-warmed on node %s for %d participants", roomID, nodeName, request.ExpectedParticipants)
+	report, _ := prewarmStatus(roomID)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handlePrewarmStatus reports whether a pre-warmed room is ready
+func handlePrewarmStatus(w http.ResponseWriter, r *http.Request) {
+	report, exists := prewarmStatus(r.PathValue("id"))
+	if !exists {
+		writeError(w, http.StatusNotFound, "room is not pre-warmed")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleCancelPrewarm releases a room's reservation; the room itself stays
+func handleCancelPrewarm(w http.ResponseWriter, r *http.Request) {
+	roomID := r.PathValue("id")
+	if !releasePrewarm(roomID) {
+		writeError(w, http.StatusNotFound, "room is not pre-warmed")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// prewarmStatus checks a pre-warmed room's readiness
+func prewarmStatus(roomID string) (prewarmReport, bool) {
+	prewarmedMutex.Lock()
+	warm, exists := prewarmed[roomID]
+	prewarmedMutex.Unlock()
+	if !exists {
+		return prewarmReport{}, false
+	}
+
+	report := prewarmReport{RoomID: roomID, Node: nodeName, prewarm: warm, Room: hub.FindRoom(roomID) != nil}
+	report.Ready = report.Room
+	if mediaSFU != nil {
+		capacity := mediaSFU.Capacity()
+		report.Capacity = &capacity
+		report.SFUReserved = mediaSFU.Reservation(roomID)
+		report.Ready = report.Ready && report.SFUReserved >= warm.ExpectedParticipants
+	}
+	return report, true
+}
+
+// releaseClosedPrewarm is a room hook releasing pre-warmed rooms once closed
+func releaseClosedPrewarm(room *signaling.Room, transition signaling.RoomTransition) {
+	if transition.To == signaling.RoomClosed {
+		releasePrewarm(room.ID)
+	}
+}
+
+// releasePrewarm forgets a pre-warmed room and frees its SFU reservation. It
+// reports whether the room was pre-warmed
+func releasePrewarm(roomID string) bool {
+	prewarmedMutex.Lock()
+	_, exists := prewarmed[roomID]
+	delete(prewarmed, roomID)
+	prewarmedMutex.Unlock()
+
+	if exists && mediaSFU != nil {
+		mediaSFU.Release(roomID)
+	}
+	return exists
+}
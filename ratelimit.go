@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/ratelimit"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Default requests per minute allowed to each REST API client, to each
+// address creating rooms, and to each address opening WebSocket connections
+const (
+	defaultRateLimit           = 600
+	defaultRoomCreationLimit   = 30
+	defaultConnectionRateLimit = 60
+)
+
+// Prefix IPv6 clients are limited by. Hosts are usually given a whole /64,
+// so a limit per address could be dodged by moving through it
+const ipv6LimitPrefix = 64
+
+var (
+	// Limits all REST API requests per token, or per address without one; nil disables it
+	apiRateLimit *ratelimit.Limiter
+
+	// Limits room creation per address; nil disables it
+	roomCreationLimit *ratelimit.Limiter
+
+	// Limits WebSocket connections per address; nil disables it
+	connectionRateLimit *ratelimit.Limiter
+)
+
+// rateLimitMiddleware refuses REST API requests over their client's limits
+// with 429 and reports the limits in RateLimit headers. WebSocket and page
+// requests aren't limited here
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") && !strings.HasPrefix(r.URL.Path, "/scim/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		address := clientAddress(r)
+		var results []ratelimit.Result
+		if apiRateLimit != nil {
+			key := "ip:" + limitKey(address)
+			if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+				sum := sha256.Sum256([]byte(strings.TrimPrefix(auth, "Bearer ")))
+				key = "token:" + hex.EncodeToString(sum[:8])
+			}
+			results = append(results, apiRateLimit.Allow(key))
+		}
+		// A made-up bearer token mustn't get around the room creation limit,
+		// so it always counts per address
+		if roomCreationLimit != nil && createsRoom(r) {
+			results = append(results, roomCreationLimit.Allow(limitKey(address)))
+		}
+		if len(results) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Report the limit closest to running out
+		tightest := results[0]
+		allowed := true
+		for _, result := range results {
+			allowed = allowed && result.Allowed
+			if !result.Allowed || result.Remaining < tightest.Remaining {
+				tightest = result
+			}
+		}
+		reset := strconv.Itoa(int(math.Ceil(tightest.Reset.Seconds())))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+		w.Header().Set("RateLimit-Reset", reset)
+		if !allowed {
+			util.Warn("Rate limited %s %s from %s", r.Method, r.URL.Path, address)
+			w.Header().Set("Retry-After", reset)
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowConnection counts a WebSocket connection against its address's
+// limit, answering 429 before the upgrade when it is over
+func allowConnection(w http.ResponseWriter, r *http.Request) bool {
+	if connectionRateLimit == nil {
+		return true
+	}
+	address := clientAddress(r)
+	result := connectionRateLimit.Allow(limitKey(address))
+	if result.Allowed {
+		return true
+	}
+	util.Warn("Rate limited WebSocket connection from %s", address)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+	http.Error(w, "too many connections", http.StatusTooManyRequests)
+	return false
+}
+
+// createsRoom reports whether a request creates a room
+func createsRoom(r *http.Request) bool {
+	return r.Method == http.MethodPost && (r.URL.Path == "/api/rooms" || r.URL.Path == "/api/rooms/import" || r.URL.Path == "/api/bulk/rooms")
+}
+
+// clientAddress returns the IP address a request came from. IPv4 clients
+// of a dual-stack listener are given as IPv4 rather than IPv4-mapped IPv6
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		return ip.To4().String()
+	}
+	return host
+}
+
+// limitKey returns what an address is rate limited by: IPv4 addresses
+// themselves, and the network of IPv6 addresses
+func limitKey(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() != nil {
+		return address
+	}
+	network := net.IPNet{IP: ip.Mask(net.CIDRMask(ipv6LimitPrefix, 128)), Mask: net.CIDRMask(ipv6LimitPrefix, 128)}
+	return network.String()
+}
+
+// newRateLimit creates a per-minute limiter from an environment variable,
+// or nil if it is set to 0
+func newRateLimit(name string, fallback int) *ratelimit.Limiter {
+	limit := fallback
+	if value := os.Getenv(name); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			util.Fatal("Invalid %s: %q", name, value)
+		}
+		limit = parsed
+	}
+	if limit == 0 {
+		return nil
+	}
+	return ratelimit.New(limit, time.Minute)
+}
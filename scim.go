@@ -0,0 +1,663 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/storage"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// SCIM 2.0 schema URNs
+const (
+	scimUserSchema   = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema  = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema  = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// Most resources returned in one page of a SCIM list
+const scimMaxResults = 200
+
+var (
+	// Users and groups provisioned over SCIM; the SCIM API is disabled when nil
+	accounts *storage.AccountStore
+
+	// Bearer token identity providers provision with
+	scimAPIKey string
+)
+
+// scimFilter matches the equality filters identity providers look users and
+// groups up with, e.g. userName eq "alice@example.com"
+var scimFilter = regexp.MustCompile(`^(\w+) (?i:eq) "([^"]*)"$`)
+
+// scimValue is a multi-valued attribute entry such as an email or a member
+type scimValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// scimMeta describes a returned resource
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// scimUser is the SCIM representation of an account
+type scimUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []scimValue `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"` // Users are active unless provisioned otherwise
+	Groups      []scimValue `json:"groups,omitempty"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+// scimGroup is the SCIM representation of a group
+type scimGroup struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []scimValue `json:"members"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+// scimPatch is a PATCH request's list of operations
+type scimPatch struct {
+	Operations []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	} `json:"Operations"`
+}
+
+// registerSCIMAPI adds the SCIM 2.0 endpoints identity providers provision
+// users and groups through
+func registerSCIMAPI(mux *http.ServeMux) {
+	scim := func(handler http.HandlerFunc) http.HandlerFunc {
+		return requireKey(&scimAPIKey, "SCIM", func(w http.ResponseWriter, r *http.Request) {
+			if accounts == nil {
+				writeSCIMError(w, http.StatusServiceUnavailable, "", "provisioning is disabled")
+				return
+			}
+			handler(w, r)
+		})
+	}
+	mux.HandleFunc("GET /scim/v2/ServiceProviderConfig", scim(handleSCIMConfig))
+	mux.HandleFunc("GET /scim/v2/Users", scim(handleSCIMListUsers))
+	mux.HandleFunc("POST /scim/v2/Users", scim(handleSCIMCreateUser))
+	mux.HandleFunc("GET /scim/v2/Users/{id}", scim(handleSCIMGetUser))
+	mux.HandleFunc("PUT /scim/v2/Users/{id}", scim(handleSCIMReplaceUser))
+	mux.HandleFunc("PATCH /scim/v2/Users/{id}", scim(handleSCIMPatchUser))
+	mux.HandleFunc("DELETE /scim/v2/Users/{id}", scim(handleSCIMDeleteUser))
+	mux.HandleFunc("GET /scim/v2/Groups", scim(handleSCIMListGroups))
+	mux.HandleFunc("POST /scim/v2/Groups", scim(handleSCIMCreateGroup))
+	mux.HandleFunc("GET /scim/v2/Groups/{id}", scim(handleSCIMGetGroup))
+	mux.HandleFunc("PUT /scim/v2/Groups/{id}", scim(handleSCIMReplaceGroup))
+	mux.HandleFunc("PATCH /scim/v2/Groups/{id}", scim(handleSCIMPatchGroup))
+	mux.HandleFunc("DELETE /scim/v2/Groups/{id}", scim(handleSCIMDeleteGroup))
+}
+
+// handleSCIMConfig tells identity providers which SCIM features are supported
+func handleSCIMConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{scimConfigSchema},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxResults},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]string{{
+			"type": "oauthbearertoken",
+			"name": "Bearer token",
+		}},
+	})
+}
+
+// handleSCIMListUsers lists users, optionally filtered by userName or externalId
+func handleSCIMListUsers(w http.ResponseWriter, r *http.Request) {
+	attribute, value, err := parseSCIMFilter(r)
+	if err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	var resources []interface{}
+	for _, account := range accounts.Users() {
+		switch strings.ToLower(attribute) {
+		case "":
+		case "username":
+			if !strings.EqualFold(account.UserName, value) {
+				continue
+			}
+		case "externalid":
+			if account.ExternalID != value {
+				continue
+			}
+		default:
+			writeSCIMError(w, http.StatusBadRequest, "invalidFilter", "users can only be filtered by userName or externalId")
+			return
+		}
+		resources = append(resources, toSCIMUser(account))
+	}
+	writeSCIMList(w, r, resources)
+}
+
+// handleSCIMCreateUser provisions a user
+func handleSCIMCreateUser(w http.ResponseWriter, r *http.Request) {
+	var user scimUser
+	if err := decodeSCIM(w, r, &user); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	account, err := accounts.CreateUser(fromSCIMUser(user))
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	util.Info("User %s provisioned over SCIM", account.UserName)
+	if !account.Active {
+		revokeAccount(account)
+	}
+	writeSCIM(w, http.StatusCreated, toSCIMUser(account))
+}
+
+// handleSCIMGetUser returns a user
+func handleSCIMGetUser(w http.ResponseWriter, r *http.Request) {
+	account, err := accounts.User(r.PathValue("id"))
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	writeSCIM(w, http.StatusOK, toSCIMUser(account))
+}
+
+// handleSCIMReplaceUser overwrites a user, deprovisioning them if they are
+// no longer active
+func handleSCIMReplaceUser(w http.ResponseWriter, r *http.Request) {
+	var user scimUser
+	if err := decodeSCIM(w, r, &user); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	updateSCIMUser(w, r.PathValue("id"), fromSCIMUser(user))
+}
+
+// handleSCIMPatchUser applies replace and add operations to a user's
+// attributes, which is how most identity providers deactivate users
+func handleSCIMPatchUser(w http.ResponseWriter, r *http.Request) {
+	var patch scimPatch
+	if err := decodeSCIM(w, r, &patch); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	account, err := accounts.User(r.PathValue("id"))
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	for _, op := range patch.Operations {
+		if kind := strings.ToLower(op.Op); kind != "replace" && kind != "add" {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", "users only support replace and add operations")
+			return
+		}
+		values := map[string]json.RawMessage{}
+		if op.Path == "" {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+				return
+			}
+		} else {
+			values[op.Path] = op.Value
+		}
+		for path, value := range values {
+			if err := patchAccount(&account, path, value); err != nil {
+				writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+				return
+			}
+		}
+	}
+	updateSCIMUser(w, account.ID, account)
+}
+
+// patchAccount sets one attribute of an account from a PATCH value
+func patchAccount(account *storage.Account, path string, value json.RawMessage) error {
+	switch strings.ToLower(path) {
+	case "active":
+		// Some identity providers send booleans as strings
+		var active interface{}
+		if err := json.Unmarshal(value, &active); err != nil {
+			return err
+		}
+		switch active := active.(type) {
+		case bool:
+			account.Active = active
+		case string:
+			parsed, err := strconv.ParseBool(active)
+			if err != nil {
+				return fmt.Errorf("active: %w", err)
+			}
+			account.Active = parsed
+		default:
+			return errors.New("active must be a boolean")
+		}
+		return nil
+	case "username":
+		return json.Unmarshal(value, &account.UserName)
+	case "displayname":
+		return json.Unmarshal(value, &account.DisplayName)
+	case "externalid":
+		return json.Unmarshal(value, &account.ExternalID)
+	case "emails":
+		var emails []scimValue
+		if err := json.Unmarshal(value, &emails); err != nil {
+			return err
+		}
+		account.Emails = emailAddresses(emails)
+		return nil
+	default:
+		// Attributes this server doesn't keep, such as name or title, are ignored
+		return nil
+	}
+}
+
+// updateSCIMUser stores an updated user and revokes the sessions of users
+// that were deactivated
+func updateSCIMUser(w http.ResponseWriter, id string, account storage.Account) {
+	previous, err := accounts.User(id)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	account, err = accounts.ReplaceUser(id, account)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	if previous.Active && !account.Active {
+		util.Info("User %s deactivated over SCIM", account.UserName)
+		revokeAccount(account)
+	}
+	writeSCIM(w, http.StatusOK, toSCIMUser(account))
+}
+
+// handleSCIMDeleteUser deprovisions a user for good
+func handleSCIMDeleteUser(w http.ResponseWriter, r *http.Request) {
+	account, err := accounts.DeleteUser(r.PathValue("id"))
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	util.Info("User %s deleted over SCIM", account.UserName)
+	revokeAccount(account)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeAccount disconnects every connection of a deprovisioned user. Their
+// identity tokens stay valid until they expire, but joins with them are refused
+func revokeAccount(account storage.Account) {
+	closed := hub.DisconnectUser(account.UserName, "deprovisioned")
+	for _, email := range account.Emails {
+		if email != account.UserName {
+			closed += hub.DisconnectUser(email, "deprovisioned")
+		}
+	}
+	if closed > 0 {
+		util.Info("Closed %d connections of deprovisioned user %s", closed, account.UserName)
+	}
+}
+
+// handleSCIMListGroups lists groups, optionally filtered by displayName or externalId
+func handleSCIMListGroups(w http.ResponseWriter, r *http.Request) {
+	attribute, value, err := parseSCIMFilter(r)
+	if err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	var resources []interface{}
+	for _, group := range accounts.Groups() {
+		switch strings.ToLower(attribute) {
+		case "":
+		case "displayname":
+			if group.DisplayName != value {
+				continue
+			}
+		case "externalid":
+			if group.ExternalID != value {
+				continue
+			}
+		default:
+			writeSCIMError(w, http.StatusBadRequest, "invalidFilter", "groups can only be filtered by displayName or externalId")
+			return
+		}
+		resources = append(resources, toSCIMGroup(group))
+	}
+	writeSCIMList(w, r, resources)
+}
+
+// handleSCIMCreateGroup provisions a group
+func handleSCIMCreateGroup(w http.ResponseWriter, r *http.Request) {
+	var group scimGroup
+	if err := decodeSCIM(w, r, &group); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	created, err := accounts.CreateGroup(fromSCIMGroup(group))
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	util.Info("Group %s provisioned over SCIM", created.DisplayName)
+	writeSCIM(w, http.StatusCreated, toSCIMGroup(created))
+}
+
+// handleSCIMGetGroup returns a group
+func handleSCIMGetGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := accounts.Group(r.PathValue("id"))
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	writeSCIM(w, http.StatusOK, toSCIMGroup(group))
+}
+
+// handleSCIMReplaceGroup overwrites a group's name and members
+func handleSCIMReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	var group scimGroup
+	if err := decodeSCIM(w, r, &group); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	updated, err := accounts.ReplaceGroup(r.PathValue("id"), fromSCIMGroup(group))
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	writeSCIM(w, http.StatusOK, toSCIMGroup(updated))
+}
+
+// scimMemberFilter picks one member in a remove path such as
+// members[value eq "2819c223"]
+var scimMemberFilter = regexp.MustCompile(`^members\[value (?i:eq) "([^"]*)"\]$`)
+
+// handleSCIMPatchGroup renames a group or adds and removes members
+func handleSCIMPatchGroup(w http.ResponseWriter, r *http.Request) {
+	var patch scimPatch
+	if err := decodeSCIM(w, r, &patch); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	group, err := accounts.Group(r.PathValue("id"))
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	for _, op := range patch.Operations {
+		var members []scimValue
+		switch path := strings.ToLower(op.Path); {
+		case path == "displayname":
+			err = json.Unmarshal(op.Value, &group.DisplayName)
+		case path == "members":
+			if err = json.Unmarshal(op.Value, &members); err != nil {
+				break
+			}
+			switch strings.ToLower(op.Op) {
+			case "add":
+				group.Members = addMembers(group.Members, members)
+			case "replace":
+				group.Members = addMembers(nil, members)
+			case "remove":
+				group.Members = removeMembers(group.Members, members)
+			}
+		case scimMemberFilter.MatchString(op.Path) && strings.EqualFold(op.Op, "remove"):
+			member := scimMemberFilter.FindStringSubmatch(op.Path)[1]
+			group.Members = removeMembers(group.Members, []scimValue{{Value: member}})
+		case path == "" && strings.EqualFold(op.Op, "replace"):
+			var values struct {
+				DisplayName string      `json:"displayName"`
+				Members     []scimValue `json:"members"`
+			}
+			if err = json.Unmarshal(op.Value, &values); err != nil {
+				break
+			}
+			if values.DisplayName != "" {
+				group.DisplayName = values.DisplayName
+			}
+			if values.Members != nil {
+				group.Members = addMembers(nil, values.Members)
+			}
+		default:
+			err = fmt.Errorf("unsupported %s of %q", op.Op, op.Path)
+		}
+		if err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+	}
+
+	updated, err := accounts.ReplaceGroup(group.ID, group)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	writeSCIM(w, http.StatusOK, toSCIMGroup(updated))
+}
+
+// addMembers adds member IDs that aren't in the list yet
+func addMembers(list []string, members []scimValue) []string {
+	for _, member := range members {
+		if !slices.Contains(list, member.Value) {
+			list = append(list, member.Value)
+		}
+	}
+	return list
+}
+
+// removeMembers drops the given member IDs from the list
+func removeMembers(list []string, members []scimValue) []string {
+	kept := []string{}
+	for _, id := range list {
+		removed := false
+		for _, member := range members {
+			removed = removed || member.Value == id
+		}
+		if !removed {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// handleSCIMDeleteGroup removes a group
+func handleSCIMDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	if err := accounts.DeleteGroup(r.PathValue("id")); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// toSCIMUser converts an account to its SCIM representation
+func toSCIMUser(account storage.Account) scimUser {
+	active := account.Active
+	user := scimUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          account.ID,
+		ExternalID:  account.ExternalID,
+		UserName:    account.UserName,
+		DisplayName: account.DisplayName,
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      account.CreatedAt,
+			LastModified: account.UpdatedAt,
+			Location:     "/scim/v2/Users/" + account.ID,
+		},
+	}
+	for i, email := range account.Emails {
+		user.Emails = append(user.Emails, scimValue{Value: email, Primary: i == 0})
+	}
+	for _, group := range accounts.Groups() {
+		if slices.Contains(group.Members, account.ID) {
+			user.Groups = append(user.Groups, scimValue{Value: group.ID, Display: group.DisplayName})
+		}
+	}
+	return user
+}
+
+// fromSCIMUser converts a SCIM user to an account
+func fromSCIMUser(user scimUser) storage.Account {
+	return storage.Account{
+		UserName:    user.UserName,
+		ExternalID:  user.ExternalID,
+		DisplayName: user.DisplayName,
+		Emails:      emailAddresses(user.Emails),
+		Active:      user.Active == nil || *user.Active,
+	}
+}
+
+// emailAddresses returns the addresses of SCIM emails, primary first
+func emailAddresses(emails []scimValue) []string {
+	var addresses []string
+	for _, email := range emails {
+		if email.Primary {
+			addresses = append([]string{email.Value}, addresses...)
+		} else {
+			addresses = append(addresses, email.Value)
+		}
+	}
+	return addresses
+}
+
+// toSCIMGroup converts a group to its SCIM representation
+func toSCIMGroup(group storage.Group) scimGroup {
+	members := []scimValue{}
+	for _, id := range group.Members {
+		member := scimValue{Value: id}
+		if account, err := accounts.User(id); err == nil {
+			member.Display = account.UserName
+		}
+		members = append(members, member)
+	}
+	return scimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          group.ID,
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     members,
+		Meta: &scimMeta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     "/scim/v2/Groups/" + group.ID,
+		},
+	}
+}
+
+// fromSCIMGroup converts a SCIM group to a stored group
+func fromSCIMGroup(group scimGroup) storage.Group {
+	return storage.Group{
+		DisplayName: group.DisplayName,
+		ExternalID:  group.ExternalID,
+		Members:     addMembers(nil, group.Members),
+	}
+}
+
+// parseSCIMFilter returns the attribute and value of a list request's filter
+func parseSCIMFilter(r *http.Request) (attribute, value string, err error) {
+	filter := r.URL.Query().Get("filter")
+	if filter == "" {
+		return "", "", nil
+	}
+	match := scimFilter.FindStringSubmatch(filter)
+	if match == nil {
+		return "", "", fmt.Errorf("unsupported filter %q", filter)
+	}
+	return match[1], match[2], nil
+}
+
+// writeSCIMList writes one page of a list response; startIndex is 1-based
+func writeSCIMList(w http.ResponseWriter, r *http.Request, resources []interface{}) {
+	start, _ := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if start < 1 {
+		start = 1
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count > scimMaxResults {
+		count = scimMaxResults
+	}
+	total := len(resources)
+	page := []interface{}{}
+	if start <= total && count > 0 {
+		page = resources[start-1 : min(start-1+count, total)]
+	}
+	writeSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas":      []string{scimListSchema},
+		"totalResults": total,
+		"startIndex":   start,
+		"itemsPerPage": len(page),
+		"Resources":    page,
+	})
+}
+
+// decodeSCIM parses a SCIM request body. Unlike decodeJSON it accepts
+// unknown fields, since identity providers send many attributes this server
+// doesn't keep
+func decodeSCIM(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// writeSCIM writes a SCIM response
+func writeSCIM(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		util.Error("Error writing SCIM response: %v", err)
+	}
+}
+
+// writeSCIMError writes a SCIM error response
+func writeSCIMError(w http.ResponseWriter, status int, scimType, detail string) {
+	body := map[string]interface{}{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	writeSCIM(w, status, body)
+}
+
+// writeAccountError maps account store errors to SCIM errors
+func writeAccountError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrAccountNotFound):
+		writeSCIMError(w, http.StatusNotFound, "", err.Error())
+	case errors.Is(err, storage.ErrAccountExists):
+		writeSCIMError(w, http.StatusConflict, "uniqueness", err.Error())
+	case errors.Is(err, storage.ErrUnknownMember), errors.Is(err, storage.ErrInvalidAccount):
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+	default:
+		util.Error("Error updating accounts: %v", err)
+		writeSCIMError(w, http.StatusInternalServerError, "", "could not update accounts")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// How long requests in progress may take to finish beyond the grace period
+const shutdownTimeout = 10 * time.Second
+
+// shutdownServer stops accepting connections and drains the hub's clients
+// for the grace period. Requests in progress finish meanwhile; WebSockets
+// are left to the hub. Another signal on stop exits right away
+func shutdownServer(server *http.Server, grace time.Duration, stop <-chan os.Signal) {
+	go func() {
+		<-stop
+		util.Warn("Second signal received, exiting without draining")
+		os.Exit(1)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace+shutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.Shutdown(ctx); err != nil {
+			util.Warn("Error shutting down the HTTP server: %v", err)
+		}
+	}()
+
+	if disconnected := hub.Shutdown(ctx, grace); disconnected > 0 {
+		util.Info("Disconnected %d clients that didn't leave within %v", disconnected, grace)
+	}
+	wg.Wait()
+}
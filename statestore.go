@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/redis"
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/storage"
+)
+
+// Prefix of the Redis keys the state store uses unless REDIS_PREFIX says otherwise
+const defaultRedisPrefix = "chatvideo:"
+
+// How long the leader's lease lasts unless LEADER_LEASE_TTL says otherwise
+const defaultLeaderLeaseTTL = 15 * time.Second
+
+// newStateStore opens the backend STATE_STORE names for room membership and
+// state: memory (the default) for a single node, or redis or sql for nodes
+// that share it
+func newStateStore(kind string) (signaling.StateStore, error) {
+	switch kind {
+	case "", "memory":
+		return signaling.NewMemoryStore(), nil
+	case "redis":
+		client, err := newRedisClient()
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewRedisStateStore(client, redisPrefix()), nil
+	case "sql":
+		driver, dsn := os.Getenv("STATE_SQL_DRIVER"), os.Getenv("STATE_SQL_DSN")
+		if driver == "" || dsn == "" {
+			return nil, fmt.Errorf("STATE_STORE=sql requires STATE_SQL_DRIVER and STATE_SQL_DSN")
+		}
+		db, err := sql.Open(driver, dsn)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Ping(); err != nil {
+			return nil, err
+		}
+		return storage.NewSQLStateStore(db, driver)
+	default:
+		return nil, fmt.Errorf("unknown STATE_STORE %q", kind)
+	}
+}
+
+// newBackplane opens the backplane BACKPLANE names for relaying client
+// traffic between nodes: none (the default) or redis. Messages for a
+// client are routed to the channel of its node
+func newBackplane(kind, node string) (signaling.Backplane, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "redis":
+		client, err := newRedisClient()
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewRedisBackplane(client, redisPrefix(), node), nil
+	default:
+		return nil, fmt.Errorf("unknown BACKPLANE %q", kind)
+	}
+}
+
+// newLeaderElection sets up the election LEADER_ELECTION names for running
+// singleton jobs on one node: none (the default), where every node runs
+// them, or redis. Leases last LEADER_LEASE_TTL
+func newLeaderElection(kind, node string) (*storage.LeaderElection, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "redis":
+		ttl := defaultLeaderLeaseTTL
+		if value := os.Getenv("LEADER_LEASE_TTL"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed < time.Second {
+				return nil, fmt.Errorf("invalid LEADER_LEASE_TTL %q", value)
+			}
+			ttl = parsed
+		}
+		client, err := newRedisClient()
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewLeaderElection(client, redisPrefix(), node, ttl), nil
+	default:
+		return nil, fmt.Errorf("unknown LEADER_ELECTION %q", kind)
+	}
+}
+
+// redisPrefix returns the prefix of this server's Redis keys and channels
+func redisPrefix() string {
+	if prefix := os.Getenv("REDIS_PREFIX"); prefix != "" {
+		return prefix
+	}
+	return defaultRedisPrefix
+}
+
+// newRedisClient connects to the server at REDIS_URL
+func newRedisClient() (*redis.Client, error) {
+	raw := os.Getenv("REDIS_URL")
+	if raw == "" {
+		return nil, fmt.Errorf("REDIS_URL is required")
+	}
+	options, err := redis.ParseURL(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	return redis.New(options)
+}
+
+// handleRoomMembers lists a room's connections on every node sharing the
+// state store
+func handleRoomMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := hub.Members(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+// handleClusterNodes lists the live nodes this node heard heartbeats from
+func handleClusterNodes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, hub.Nodes())
+}
@@ -8,6 +8,19 @@ class VideoChat {
     this.roomId = "default-room";
     this.clientId = null;
 
+    // Lets a new connection take our place back after the old one dropped
+    this.resumeToken = null;
+
+    // Room chat, oldest first, starting with what was said before joining
+    this.chatMessages = [];
+
+    // Media the host turned off, until we turn it back on
+    this.serverMuted = { audio: false, video: false };
+
+    // What peers report of their media by client ID, starting from the
+    // room-state snapshot we get on joining
+    this.peerMedia = {};
+
     // ICE servers for WebRTC (STUN/TURN)
     this.iceServers = {
       iceServers: [
@@ -32,6 +45,26 @@ class VideoChat {
   }
 
   addEventListeners() {
+    // Screenshot shortcuts are the one capture a page can see; tell the host
+    document.addEventListener("keyup", (event) => {
+      const macShortcut =
+        event.metaKey && event.shiftKey && ["3", "4", "5"].includes(event.key);
+      if (event.key === "PrintScreen" || macShortcut) {
+        this.sendSignalingMessage({
+          type: "screen-capture-detected",
+          data: { kind: "screenshot", detail: event.key },
+        });
+      }
+    });
+
+    // After a network switch (e.g. Wi-Fi to LTE) the old connection may
+    // linger; resume on a new one right away instead of waiting for it to die
+    const migrate = () => this.migrateSocket();
+    window.addEventListener("online", migrate);
+    if (navigator.connection) {
+      navigator.connection.addEventListener("change", migrate);
+    }
+
     // Join room button
     this.elements.joinButton.addEventListener("click", () => {
       this.roomId = this.elements.roomIdInput.value || "default-room";
@@ -48,6 +81,10 @@ class VideoChat {
           this.elements.audioButton.textContent = enabled
             ? "Mute Audio"
             : "Unmute Audio";
+          if (enabled) {
+            this.liftMediaControl("audio");
+          }
+          this.reportMediaState();
         }
       }
     });
@@ -62,6 +99,10 @@ class VideoChat {
           this.elements.videoButton.textContent = enabled
             ? "Turn Off Video"
             : "Turn On Video";
+          if (enabled) {
+            this.liftMediaControl("video");
+          }
+          this.reportMediaState();
         }
       }
     });
@@ -70,13 +111,31 @@ class VideoChat {
   // Connect to the signaling server
   connectSocket() {
     const protocol = window.location.protocol === "https:" ? "wss:" : "ws:";
-    const wsUrl = `${protocol}//${window.location.host}/ws?roomId=${this.roomId}`;
+    let wsUrl = `${protocol}//${window.location.host}/ws?roomId=${this.roomId}`;
+    // Signed-in users pass the identity token from their SSO login
+    const params = new URLSearchParams(window.location.search);
+    const token = params.get("token");
+    if (token) {
+      wsUrl += `&token=${encodeURIComponent(token)}`;
+    }
+    // Others see us by this name instead of our client ID
+    const displayName = params.get("name");
+    if (displayName) {
+      wsUrl += `&displayName=${encodeURIComponent(displayName)}`;
+    }
+    // A resumed connection keeps our place in the room
+    const resuming = this.resumeToken !== null;
+    if (resuming) {
+      wsUrl = `${protocol}//${window.location.host}/ws?resume=${this.resumeToken}`;
+    }
 
     this.socket = new WebSocket(wsUrl);
 
     this.socket.onopen = () => {
-      this.updateStatus("Connected to server");
-      this.sendJoinMessage();
+      this.updateStatus(resuming ? "Reconnecting to the room" : "Connected to server");
+      if (!resuming) {
+        this.sendJoinMessage();
+      }
     };
 
     this.socket.onmessage = (event) => {
@@ -91,15 +150,49 @@ class VideoChat {
 
     this.socket.onclose = () => {
       this.updateStatus("Disconnected from server");
-      // Try to reconnect after a delay
-      setTimeout(() => {
-        if (this.socket.readyState === WebSocket.CLOSED) {
-          this.connectSocket();
-        }
-      }, 5000);
+      // The server ends our recordings when we leave; upload what we have
+      Object.keys(this.recorders || {}).forEach((recordingId) =>
+        this.stopParticipantRecording(recordingId)
+      );
+      // Try to reconnect after a delay, soon enough to resume in time
+      setTimeout(
+        () => {
+          if (this.socket.readyState === WebSocket.CLOSED) {
+            this.connectSocket();
+          }
+        },
+        this.resumeToken ? 1000 : 5000
+      );
     };
   }
 
+  // Move to a new connection after a network change. The server closes the
+  // old one once the new one resumes
+  migrateSocket() {
+    if (!this.resumeToken || !this.socket) {
+      return;
+    }
+    const old = this.socket;
+    old.onclose = null;
+    old.onmessage = null;
+    old.onerror = null;
+    this.connectSocket();
+  }
+
+  // Restart ICE with every peer after our address changed
+  handleResumed(data) {
+    this.resumeToken = data.resumeToken;
+    // What we missed meanwhile, such as a host change, follows
+    this.updateStatus(
+      `Reconnected to room: ${data.roomId}` +
+        (data.backlog ? ` (${data.backlog} missed messages)` : "")
+    );
+    Object.keys(this.peerConnections).forEach((peerId) => {
+      this.peerConnections[peerId].restartIce();
+      this.createOffer(peerId);
+    });
+  }
+
   // Send a join message to the server
   sendJoinMessage() {
     if (this.socket && this.socket.readyState === WebSocket.OPEN) {
@@ -112,8 +205,45 @@ class VideoChat {
     }
   }
 
+  // Ask the server whether we could join, so a refusal is explained before
+  // the camera turns on; null when the check itself fails
+  async checkJoin() {
+    try {
+      const response = await fetch(
+        `/api/rooms/${encodeURIComponent(this.roomId)}/can-join?caps=recording,watermark`
+      );
+      return response.ok ? await response.json() : null;
+    } catch (error) {
+      return null;
+    }
+  }
+
+  // Describe why the server would refuse the join
+  joinRefusal(check) {
+    switch (check.reason) {
+      case "full":
+        return `Room ${check.roomId} is full (${check.maxParticipants} participants)`;
+      case "locked":
+        return `Room ${check.roomId} is locked`;
+      case "banned":
+        return `You can't join room ${check.roomId}`;
+      case "capabilities":
+        return `Room ${check.roomId} needs ${check.missingCapabilities.join(", ")}, which this client doesn't support`;
+      case "shutdown":
+        return "The server is restarting; try again shortly";
+      default:
+        return `Can't join room ${check.roomId} (${check.reason})`;
+    }
+  }
+
   // Join a room and start video chat
   async joinRoom() {
+    const check = await this.checkJoin();
+    if (check && !check.canJoin) {
+      this.updateStatus(this.joinRefusal(check));
+      return;
+    }
+
     try {
       // Get local media stream
       this.localStream = await navigator.mediaDevices.getUserMedia({
@@ -126,20 +256,67 @@ class VideoChat {
 
       // Connect to signaling server
       this.connectSocket();
+      this.detectSpeaking();
 
       this.updateStatus("Joined room: " + this.roomId);
     } catch (error) {
       this.updateStatus(`Error accessing media devices: ${error.message}`);
       console.error("Error accessing media devices:", error);
+      this.reportError("media", error.message, null, { name: error.name });
     }
   }
 
   // Handle incoming signaling messages
   handleSignalingMessage(message) {
     switch (message.type) {
+      case "welcome":
+        this.clientId = message.data.clientId;
+        this.resumeToken = message.data.resumeToken || null;
+        if (message.data.serverMuted) {
+          this.applyMediaControl("audio");
+        }
+        if (message.data.videoStopped) {
+          this.applyMediaControl("video");
+        }
+        // Viewers watch and listen without sending their own media
+        if (message.data.role === "viewer" && this.localStream) {
+          this.localStream.getTracks().forEach((track) => {
+            track.enabled = false;
+          });
+          this.updateStatus("Joined room " + this.roomId + " as a viewer");
+        }
+        break;
       case "user-joined":
+        this.updateStatus(
+          `${message.data.displayName || message.data.accountId || message.from} joined` +
+            (message.data.verified ? " (verified)" : " (guest)")
+        );
         this.handleUserJoined(message.from);
         break;
+      case "resumed":
+        this.handleResumed(message.data);
+        break;
+      case "mute-user":
+      case "stop-video-user":
+      case "mute-all":
+        this.handleMediaControl(message);
+        break;
+      case "peer-state":
+        this.showPeerState(message.data);
+        break;
+      case "room-state":
+        this.handleRoomState(message.data);
+        break;
+      case "media-state":
+        this.peerMedia[message.data.clientId] = message.data;
+        this.showPeerMedia(message.data.clientId);
+        break;
+      case "join-denied":
+        if (message.data.reason === "resume") {
+          // Our place in the room is gone; join again from scratch
+          this.resumeToken = null;
+        }
+        break;
       case "user-list":
         this.handleUserList(message.data.users);
         break;
@@ -152,6 +329,64 @@ class VideoChat {
       case "ice-candidate":
         this.handleIceCandidate(message);
         break;
+      case "ice-diagnostics":
+        this.handleIceDiagnostics(message.data);
+        break;
+      case "recording-consent-request":
+        this.handleRecordingConsentRequest(message.data);
+        break;
+      case "recording-start":
+        this.startParticipantRecording(message.data);
+        break;
+      case "recording-stop":
+        this.stopParticipantRecording(message.data.recordingId);
+        break;
+      case "caption":
+        this.showCaption(message.data);
+        break;
+      case "chat":
+        if (message.data.announcement) {
+          this.showAnnouncement(message.data);
+        }
+        if (!message.to) {
+          this.chatMessages.push({ from: message.from, data: message.data });
+        }
+        break;
+      case "chat-history":
+        this.chatMessages = message.data.messages.concat(this.chatMessages);
+        this.updateStatus(
+          `${message.data.messages.length} earlier messages in this room`
+        );
+        break;
+      case "watermark":
+        this.showWatermark(message.data);
+        break;
+      case "screen-capture-alert":
+        this.updateStatus(
+          `${message.data.userId || message.data.clientId} may have taken a ${message.data.kind}`
+        );
+        break;
+      case "rate-limited":
+        this.updateStatus(
+          message.data.disconnect
+            ? "Disconnected for sending too many messages"
+            : "Sending too many messages; some were dropped"
+        );
+        break;
+      case "not-permitted":
+      case "chat-rejected":
+        this.updateStatus(`Message not sent: ${message.data.reason}`);
+        break;
+      case "keyword-alert":
+        this.updateStatus(
+          `"${message.data.keyword}" said by ${message.data.speaker}`
+        );
+        break;
+      case "recording-status":
+        this.updateStatus(
+          `Recording of ${message.data.clientId}: ${message.data.status}`
+        );
+        break;
       default:
         console.log("Unknown message type:", message.type);
     }
@@ -203,6 +438,12 @@ class VideoChat {
       console.log(
         `Connection state with ${userId}: ${peerConnection.connectionState}`
       );
+      if (peerConnection.connectionState === "failed") {
+        this.reportError("ice", "Peer connection failed", userId);
+      }
+      if (peerConnection.connectionState === "connected") {
+        this.reportTransport(peerConnection, userId);
+      }
     };
 
     // Handle remote streams
@@ -226,6 +467,7 @@ class VideoChat {
         peerContainer.appendChild(videoElement);
         peerContainer.appendChild(peerLabel);
         this.elements.remoteVideos.appendChild(peerContainer);
+        this.showPeerMedia(userId);
       }
 
       // Set the stream as the source for the video element
@@ -259,6 +501,23 @@ class VideoChat {
     }
   }
 
+  // Fall back to TURN relays for a peer we keep failing to reach
+  handleIceDiagnostics(data) {
+    console.warn("ICE diagnostics from server:", data.hints);
+    if (data.iceServers) {
+      this.iceServers.iceServers.push(...data.iceServers);
+    }
+    const peerConnection = this.peerConnections[data.peerId];
+    if (peerConnection) {
+      peerConnection.setConfiguration({
+        ...this.iceServers,
+        iceTransportPolicy: data.iceTransportPolicy,
+      });
+      peerConnection.restartIce();
+      this.createOffer(data.peerId);
+    }
+  }
+
   // Handle incoming offer
   async handleOffer(message) {
     const userId = message.from;
@@ -315,6 +574,219 @@ class VideoChat {
     }
   }
 
+  // Receive captions of one channel ("original" or a language code); null unsubscribes
+  subscribeCaptions(channel) {
+    this.sendSignalingMessage({
+      type: "caption-subscribe",
+      data: { channel: channel || "" },
+    });
+  }
+
+  // Alert the host when someone says one of these words or phrases
+  setKeywords(keywords) {
+    this.sendSignalingMessage({
+      type: "set-keywords",
+      data: { keywords },
+    });
+  }
+
+  // Report when the local microphone starts and stops picking up speech, so
+  // the server can compute talk time per participant
+  detectSpeaking() {
+    const context = new AudioContext();
+    const analyser = context.createAnalyser();
+    analyser.fftSize = 512;
+    context.createMediaStreamSource(this.localStream).connect(analyser);
+    const samples = new Uint8Array(analyser.fftSize);
+    let speaking = false;
+    let quietSince = 0;
+
+    setInterval(() => {
+      analyser.getByteTimeDomainData(samples);
+      let sum = 0;
+      for (const sample of samples) {
+        sum += ((sample - 128) / 128) ** 2;
+      }
+      const audioTrack = this.localStream.getAudioTracks()[0];
+      const loud =
+        audioTrack && audioTrack.enabled && Math.sqrt(sum / samples.length) > 0.02;
+
+      // Short pauses between words don't end a turn
+      if (loud) {
+        quietSince = 0;
+      } else if (!quietSince) {
+        quietSince = Date.now();
+      }
+      const now = loud || (speaking && Date.now() - quietSince < 800);
+      if (now !== speaking) {
+        speaking = now;
+        this.sendSignalingMessage({ type: "speaking", data: { speaking } });
+      }
+    }, 100);
+  }
+
+  // Show the latest caption of a speaker below the videos
+  showCaption(data) {
+    let captions = document.getElementById("captions");
+    if (!captions) {
+      captions = document.createElement("div");
+      captions.id = "captions";
+      this.elements.remoteVideos.after(captions);
+    }
+    captions.textContent = `${data.speaker}: ${data.text}`;
+  }
+
+  // Overlay our own identity across the remote videos of confidential rooms
+  showWatermark(data) {
+    let overlay = document.getElementById("watermark");
+    if (!data.text) {
+      if (overlay) overlay.remove();
+      return;
+    }
+    if (!overlay) {
+      overlay = document.createElement("div");
+      overlay.id = "watermark";
+      Object.assign(overlay.style, {
+        position: "absolute",
+        inset: "0",
+        pointerEvents: "none",
+        overflow: "hidden",
+        color: "white",
+        fontSize: "18px",
+        lineHeight: "80px",
+        transform: "rotate(-25deg) scale(1.5)",
+        whiteSpace: "pre-wrap",
+        wordSpacing: "60px",
+      });
+      this.elements.remoteVideos.style.position = "relative";
+      this.elements.remoteVideos.appendChild(overlay);
+    }
+    overlay.style.opacity = data.opacity || 0.15;
+    overlay.textContent = `${data.text} `.repeat(200);
+  }
+
+  // Show a compliance notice and play its recorded version
+  showAnnouncement(data) {
+    this.updateStatus(data.text);
+    if (data.audioUrl) {
+      new Audio(data.audioUrl)
+        .play()
+        .catch((error) => console.error("Error playing announcement:", error));
+    }
+  }
+
+  // Host only: ask a participant to record their "screen", "camera" or "all" tracks
+  recordParticipant(clientId, tracks) {
+    this.sendSignalingMessage({
+      type: "record-participant",
+      data: { clientId, tracks },
+    });
+  }
+
+  // Host or recorded participant: end a participant recording
+  stopRecording(recordingId) {
+    this.sendSignalingMessage({
+      type: "stop-participant-recording",
+      data: { recordingId },
+    });
+  }
+
+  // Ask the user whether the host may record them
+  handleRecordingConsentRequest(data) {
+    const accepted = window.confirm(
+      `The host wants to record your ${data.tracks} tracks. Allow?`
+    );
+    this.sendSignalingMessage({
+      type: "recording-consent",
+      data: { recordingId: data.recordingId, accepted },
+    });
+  }
+
+  // Record our own tracks locally; they are uploaded when recording stops
+  async startParticipantRecording(data) {
+    try {
+      let stream = this.localStream;
+      if (data.tracks === "screen") {
+        stream = await navigator.mediaDevices.getDisplayMedia({ video: true });
+      }
+      const recorder = new MediaRecorder(stream, { mimeType: "video/webm" });
+      const chunks = [];
+      recorder.ondataavailable = (event) => chunks.push(event.data);
+      recorder.onstop = () => {
+        if (stream !== this.localStream) {
+          stream.getTracks().forEach((track) => track.stop());
+        }
+        fetch(data.uploadUrl, {
+          method: "PUT",
+          headers: {
+            "Content-Type": "video/webm",
+            Authorization: `Bearer ${data.uploadToken}`,
+          },
+          body: new Blob(chunks, { type: "video/webm" }),
+        }).catch((error) => console.error("Error uploading recording:", error));
+      };
+      // Ending a screen share from the browser UI stops the recording too
+      stream.getVideoTracks().forEach((track) => {
+        track.onended = () => this.stopRecording(data.recordingId);
+      });
+      this.recorders = { ...this.recorders, [data.recordingId]: recorder };
+      recorder.start(1000);
+    } catch (error) {
+      console.error("Error starting recording:", error);
+      this.reportError("media", error.message, null, { recordingId: data.recordingId });
+      this.stopRecording(data.recordingId);
+    }
+  }
+
+  // Finish a local recording and upload it
+  stopParticipantRecording(recordingId) {
+    const recorder = this.recorders && this.recorders[recordingId];
+    if (recorder && recorder.state !== "inactive") {
+      recorder.stop();
+    }
+  }
+
+  // Tell the server which transport ICE settled on with a peer:
+  // udp, tcp, or turn-udp, turn-tcp or turn-tls when relayed
+  async reportTransport(peerConnection, peerId) {
+    try {
+      const stats = await peerConnection.getStats();
+      let pair;
+      stats.forEach((report) => {
+        if (report.type === "transport" && report.selectedCandidatePairId) {
+          pair = stats.get(report.selectedCandidatePairId);
+        } else if (report.type === "candidate-pair" && report.selected) {
+          pair = report; // Firefox
+        }
+      });
+      const local = pair && stats.get(pair.localCandidateId);
+      if (!local) {
+        return;
+      }
+      const transport =
+        local.candidateType === "relay"
+          ? `turn-${local.relayProtocol || "udp"}`
+          : local.protocol;
+      this.sendSignalingMessage({ type: "ice-transport", data: { peerId, transport } });
+    } catch (error) {
+      console.error("Error reading connection stats:", error);
+    }
+  }
+
+  // Report an error to the server, over HTTP if the socket isn't open
+  reportError(kind, message, peerId, context) {
+    const data = { kind, message, peerId: peerId || undefined, context };
+    if (this.socket && this.socket.readyState === WebSocket.OPEN) {
+      this.sendSignalingMessage({ type: "client-error", data });
+      return;
+    }
+    fetch("/api/client-errors", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ roomId: this.roomId, clientId: this.clientId || "", ...data }),
+    }).catch(() => {});
+  }
+
   // Send signaling message through WebSocket
   sendSignalingMessage(message) {
     if (this.socket && this.socket.readyState === WebSocket.OPEN) {
@@ -322,6 +794,103 @@ class VideoChat {
     }
   }
 
+  // The host turned off someone's microphone or camera; only the host's
+  // say-so, marked by isHost, counts
+  handleMediaControl(message) {
+    const targets = message.data.clientIds || [message.data.clientId];
+    if (!message.isHost || !targets.includes(this.clientId)) {
+      return;
+    }
+    this.applyMediaControl(message.data.kind);
+    this.updateStatus(
+      message.data.kind === "audio"
+        ? "The host muted you"
+        : "The host turned off your camera"
+    );
+  }
+
+  // Turn off local audio or video as the host asked
+  applyMediaControl(kind) {
+    this.serverMuted[kind] = true;
+    if (!this.localStream) {
+      return;
+    }
+    const tracks =
+      kind === "audio"
+        ? this.localStream.getAudioTracks()
+        : this.localStream.getVideoTracks();
+    tracks.forEach((track) => {
+      track.enabled = false;
+    });
+    if (kind === "audio") {
+      this.elements.audioButton.textContent = "Unmute Audio";
+    } else {
+      this.elements.videoButton.textContent = "Turn On Video";
+    }
+  }
+
+  // Tell the room we turned back on what the host turned off
+  liftMediaControl(kind) {
+    if (this.serverMuted[kind]) {
+      this.serverMuted[kind] = false;
+      this.sendSignalingMessage({ type: "unmute-self", data: { kind } });
+    }
+  }
+
+  // Tell the room whether our microphone and camera are on
+  reportMediaState() {
+    const off = (tracks) => tracks.length > 0 && !tracks[0].enabled;
+    this.sendSignalingMessage({
+      type: "media-state",
+      data: {
+        audioMuted: off(this.localStream.getAudioTracks()),
+        videoOff: off(this.localStream.getVideoTracks()),
+      },
+    });
+  }
+
+  // Take the state of the room as it was when we joined, rather than
+  // guessing it from the events we missed
+  handleRoomState(data) {
+    this.peerMedia = {};
+    const mark = (ids, field) =>
+      ids.forEach((id) => {
+        this.peerMedia[id] = { ...this.peerMedia[id], [field]: true };
+      });
+    mark(data.muted, "audioMuted");
+    mark(data.videoOff, "videoOff");
+    mark(data.screenSharing, "screenSharing");
+    Object.keys(this.peerMedia).forEach((id) => this.showPeerMedia(id));
+  }
+
+  // Mark the tile of a peer that muted itself or turned its camera off
+  showPeerMedia(clientId) {
+    const video = document.getElementById(`remote-video-${clientId}`);
+    const media = this.peerMedia[clientId] || {};
+    if (video) {
+      video.parentElement.classList.toggle("muted", !!media.audioMuted);
+      video.parentElement.classList.toggle("video-off", !!media.videoOff);
+    }
+  }
+
+  // Dim the tile of a peer that lost its connection instead of leaving it
+  // frozen, and say why
+  showPeerState(data) {
+    const name = data.displayName || data.clientId;
+    const video = document.getElementById(`remote-video-${data.clientId}`);
+    if (video) {
+      video.parentElement.classList.toggle(
+        "reconnecting",
+        data.state === "reconnecting"
+      );
+    }
+    if (data.state === "reconnecting") {
+      this.updateStatus(`${name} is reconnecting…`);
+    } else if (data.state === "connected") {
+      this.updateStatus(`${name} reconnected`);
+    }
+  }
+
   // Update status display
   updateStatus(message) {
     this.elements.statusDiv.textContent = message;
@@ -331,4 +900,12 @@ class VideoChat {
 // Initialize when the document is loaded
 document.addEventListener("DOMContentLoaded", () => {
   window.videoChat = new VideoChat();
+
+  // Report uncaught exceptions so operators see what breaks in browsers
+  window.addEventListener("error", (event) => {
+    window.videoChat.reportError("exception", event.message, null, {
+      source: event.filename,
+      line: event.lineno,
+    });
+  });
 });
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/config"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// How often the certificate file is checked for a renewed certificate
+const certificateCheckInterval = time.Minute
+
+// configureTLS sets up the server's TLS configuration from a certificate and
+// key or through autocert, and returns the handler of the plain HTTP
+// listener, which answers ACME challenges and redirects to HTTPS
+func configureTLS(server *http.Server, cfg config.TLSConfig) (http.Handler, error) {
+	if domains := cfg.Domains(); len(domains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		util.Info("Getting certificates for %v from Let's Encrypt, cached in %s", domains, cfg.AutocertCacheDir)
+		return manager.HTTPHandler(redirectToHTTPS(server.Addr)), nil
+	}
+
+	loader := &certificateLoader{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	if err := loader.load(); err != nil {
+		return nil, err
+	}
+	server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: loader.getCertificate}
+	util.Info("Serving HTTPS and WSS with the certificate in %s", cfg.CertFile)
+	return redirectToHTTPS(server.Addr), nil
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL over HTTPS, on
+// the port of the HTTPS address
+func redirectToHTTPS(addr string) http.Handler {
+	_, port, _ := net.SplitHostPort(addr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			host = name
+		}
+		// IPv6 hosts come bracketed when the request has no port
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		switch {
+		case port != "" && port != "443":
+			host = net.JoinHostPort(host, port)
+		case strings.Contains(host, ":"):
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// certificateLoader serves a certificate from files and loads it again
+// when the certificate file changes, so renewals need no restart
+type certificateLoader struct {
+	certFile, keyFile string
+
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	modified    time.Time
+	checked     time.Time
+}
+
+// load reads the certificate and key
+func (l *certificateLoader) load() error {
+	info, err := os.Stat(l.certFile)
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return err
+	}
+	l.certificate = &certificate
+	l.modified = info.ModTime()
+	l.checked = time.Now()
+	return nil
+}
+
+// getCertificate returns the certificate, loading it again first if the
+// file changed since the last check. A renewal that fails to load keeps
+// the previous certificate
+func (l *certificateLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if time.Since(l.checked) < certificateCheckInterval {
+		return l.certificate, nil
+	}
+	l.checked = time.Now()
+	if info, err := os.Stat(l.certFile); err == nil && !info.ModTime().Equal(l.modified) {
+		if err := l.load(); err != nil {
+			util.Error("Error loading the renewed certificate, keeping the previous one: %v", err)
+		} else {
+			util.Info("Loaded the renewed certificate in %s", l.certFile)
+		}
+	}
+	return l.certificate, nil
+}
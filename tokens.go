@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/storage"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+// Lifetime of API tokens created without a ttl
+const defaultTokenTTL = 90 * 24 * time.Hour
+
+// Scoped API tokens; only the admin API key is accepted when nil
+var apiTokens *storage.TokenStore
+
+// registerTokenAPI adds the endpoints to issue and revoke API tokens
+func registerTokenAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/tokens", requireAdmin(handleListTokens))
+	mux.HandleFunc("POST /api/tokens", requireAdmin(handleCreateToken))
+	mux.HandleFunc("DELETE /api/tokens/{tokenId}", requireAdmin(handleRevokeToken))
+}
+
+// handleListTokens returns all API tokens without their secrets
+func handleListTokens(w http.ResponseWriter, r *http.Request) {
+	if apiTokens == nil {
+		writeError(w, http.StatusServiceUnavailable, "API tokens are disabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, apiTokens.Tokens())
+}
+
+// handleCreateToken issues a token from {"name", "scopes", "ttl"}, where ttl
+// is a duration such as "720h". The secret is only returned in this response
+func handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if apiTokens == nil {
+		writeError(w, http.StatusServiceUnavailable, "API tokens are disabled")
+		return
+	}
+	var request struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		TTL    string   `json:"ttl"`
+	}
+	if err := decodeJSON(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ttl := defaultTokenTTL
+	if request.TTL != "" {
+		parsed, err := time.ParseDuration(request.TTL)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid ttl: "+err.Error())
+			return
+		}
+		ttl = parsed
+	}
+	token, secret, err := apiTokens.Create(request.Name, request.Scopes, ttl)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	util.Info("API token %s (%s) created with scopes %v by %s", token.ID, token.Name, token.Scopes, r.RemoteAddr)
+	recordAudit(signaling.AuditEntry{
+		Action:  signaling.AuditTokenCreated,
+		Details: tokenDetails(token),
+	})
+	writeJSON(w, http.StatusCreated, struct {
+		storage.APIToken
+		Token string `json:"token"`
+	}{token, secret})
+}
+
+// handleRevokeToken makes a token unusable
+func handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if apiTokens == nil {
+		writeError(w, http.StatusServiceUnavailable, "API tokens are disabled")
+		return
+	}
+	token, err := apiTokens.Revoke(r.PathValue("tokenId"))
+	if errors.Is(err, storage.ErrTokenNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		util.Error("Error revoking API token: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not revoke API token")
+		return
+	}
+
+	util.Info("API token %s (%s) revoked by %s", token.ID, token.Name, r.RemoteAddr)
+	recordAudit(signaling.AuditEntry{
+		Action:  signaling.AuditTokenRevoked,
+		Details: tokenDetails(token),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tokenDetails describes a token for the audit log
+func tokenDetails(token storage.APIToken) string {
+	return fmt.Sprintf("API token %s (%s) with scopes %s", token.ID, token.Name, strings.Join(token.Scopes, ", "))
+}
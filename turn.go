@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nikhilsahni7/chat-video-app/pkg/config"
+	"github.com/nikhilsahni7/chat-video-app/pkg/redis"
+	"github.com/nikhilsahni7/chat-video-app/pkg/signaling"
+	"github.com/nikhilsahni7/chat-video-app/pkg/storage"
+	"github.com/nikhilsahni7/chat-video-app/pkg/turnserver"
+	"github.com/nikhilsahni7/chat-video-app/pkg/util"
+)
+
+const (
+	// Port of the embedded TURN server unless TURN_LISTEN says otherwise
+	defaultTURNListen = ":3478"
+
+	// How long to wait before subscribing to coturn's reports again
+	coturnRetryInterval = 5 * time.Second
+)
+
+var (
+	// Embedded TURN/STUN server; nil unless TURN_ENABLED is set
+	turnServer *turnserver.Server
+
+	// Meter of relayed traffic; nil unless the embedded TURN server or
+	// coturn reports are enabled
+	turnMeter *turnserver.Meter
+)
+
+// registerTURNAPI adds the endpoints that hand out TURN credentials and ICE
+// servers
+func registerTURNAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/turn/credentials", requireScope(storage.ScopeRoomsRead, handleTURNCredentials))
+	mux.HandleFunc("GET /api/ice-servers", requireScope(storage.ScopeRoomsRead, handleICEServers))
+	mux.HandleFunc("GET /api/usage", requireAdmin(handleUsage))
+}
+
+// startTURNServer starts the embedded TURN server from TURN_LISTEN,
+// TURN_PUBLIC_IP, TURN_PUBLIC_IPV6, TURN_REALM and TURN_RELAY_PORTS, falling
+// back to the media settings for the public IPs and port range, and returns
+// the secret its credentials are signed with: TURN_SECRET, or a random one.
+// With TURN_TLS_LISTEN it also accepts TURN over TLS, with the server's
+// certificate, on the host TURN_TLS_HOST or the first autocert domain
+func startTURNServer(cfg config.Config, tlsConfig *tls.Config) (*turnserver.Server, string, error) {
+	media := cfg.Media
+	config := turnserver.Config{
+		ListenAddr: os.Getenv("TURN_LISTEN"),
+		Realm:      os.Getenv("TURN_REALM"),
+		Secret:     os.Getenv("TURN_SECRET"),
+		MinPort:    uint16(media.UDPPortMin),
+		MaxPort:    uint16(media.UDPPortMax),
+		BindIP:     firstIP(media.BindIPs(), true),
+		Meter:      turnMeter,
+
+		TLSListenAddr: os.Getenv("TURN_TLS_LISTEN"),
+		TLSConfig:     tlsConfig,
+		TLSHost:       os.Getenv("TURN_TLS_HOST"),
+	}
+	if domains := cfg.TLS.Domains(); config.TLSHost == "" && len(domains) > 0 {
+		config.TLSHost = domains[0]
+	}
+	if config.ListenAddr == "" {
+		config.ListenAddr = defaultTURNListen
+	}
+	var publicIPs []net.IP
+	for _, entry := range media.PublicIPList() {
+		public, _, _ := strings.Cut(entry, "/")
+		publicIPs = append(publicIPs, net.ParseIP(public))
+	}
+	config.PublicIP = firstIP(publicIPs, true)
+	if publicIP := os.Getenv("TURN_PUBLIC_IP"); publicIP != "" {
+		config.PublicIP = net.ParseIP(publicIP)
+	}
+	if config.PublicIP.To4() == nil {
+		return nil, "", fmt.Errorf("TURN_PUBLIC_IP or media.public_ips must include the server's public IPv4 address")
+	}
+	config.PublicIPv6 = firstIP(publicIPs, false)
+	if publicIP := os.Getenv("TURN_PUBLIC_IPV6"); publicIP != "" {
+		if config.PublicIPv6 = net.ParseIP(strings.Trim(publicIP, "[]")); config.PublicIPv6 == nil || config.PublicIPv6.To4() != nil {
+			return nil, "", fmt.Errorf("invalid TURN_PUBLIC_IPV6 %q", publicIP)
+		}
+	}
+	if ports := os.Getenv("TURN_RELAY_PORTS"); ports != "" {
+		low, high, _ := strings.Cut(ports, "-")
+		minPort, errMin := strconv.ParseUint(low, 10, 16)
+		maxPort, errMax := strconv.ParseUint(high, 10, 16)
+		if errMin != nil || errMax != nil || minPort == 0 || minPort > maxPort {
+			return nil, "", fmt.Errorf("invalid TURN_RELAY_PORTS %q", ports)
+		}
+		config.MinPort, config.MaxPort = uint16(minPort), uint16(maxPort)
+	}
+	if config.Secret == "" {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		config.Secret = hex.EncodeToString(secret)
+	}
+
+	server, err := turnserver.Start(config)
+	if err != nil {
+		return nil, "", err
+	}
+	return server, config.Secret, nil
+}
+
+// firstIP returns the first IPv4 or IPv6 address of a list, or nil
+func firstIP(ips []net.IP, ipv4 bool) net.IP {
+	for _, ip := range ips {
+		if (ip.To4() != nil) == ipv4 {
+			return ip
+		}
+	}
+	return nil
+}
+
+// handleTURNCredentials returns fresh, time-limited TURN credentials as an
+// iceServers list, for the client named by ?clientId=
+func handleTURNCredentials(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("clientId")
+	if clientID == "" {
+		writeError(w, http.StatusBadRequest, "clientId is required")
+		return
+	}
+	server := hub.TURNCredentials(clientID)
+	if server == nil {
+		writeError(w, http.StatusServiceUnavailable, "TURN is not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"iceServers": []signaling.ICEServer{*server},
+	})
+}
+
+// handleICEServers returns the healthy ICE servers for the client named by
+// ?clientId=, with fresh TURN credentials, as an iceServers list
+func handleICEServers(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("clientId")
+	if clientID == "" {
+		writeError(w, http.StatusBadRequest, "clientId is required")
+		return
+	}
+	servers := hub.ICEServers(clientID)
+	if servers == nil {
+		servers = []signaling.ICEServer{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"iceServers": servers})
+}
+
+// newTURNMeter creates the meter of relayed traffic, capped per tenant by
+// TURN_BANDWIDTH_CAPS
+func newTURNMeter() (*turnserver.Meter, error) {
+	caps, err := turnserver.ParseCaps(os.Getenv("TURN_BANDWIDTH_CAPS"))
+	if err != nil {
+		return nil, err
+	}
+	return turnserver.NewMeter(attributeTURNClient, caps), nil
+}
+
+// attributeTURNClient names the room and tenant of a client allocating a
+// relay
+func attributeTURNClient(clientID string) turnserver.Attribution {
+	room := hub.LocateClient(clientID)
+	if room == nil {
+		return turnserver.Attribution{}
+	}
+	return turnserver.Attribution{Room: room.ID, Tenant: room.Settings().Tenant}
+}
+
+// consumeCoturnReports records the traffic reports coturn publishes to
+// Redis, subscribing again whenever the subscription fails
+func consumeCoturnReports(options redis.Options) {
+	for {
+		sub, err := redis.Subscribe(options)
+		if err == nil {
+			err = turnMeter.ConsumeCoturn(sub)
+			sub.Close()
+		}
+		util.Warn("Error receiving coturn traffic reports, retrying in %v: %v", coturnRetryInterval, err)
+		time.Sleep(coturnRetryInterval)
+	}
+}
+
+// tenantUsage is the relayed traffic of a tenant's rooms
+type tenantUsage struct {
+	Tenant        string `json:"tenant"`
+	BytesSent     uint64 `json:"bytesSent"`
+	BytesReceived uint64 `json:"bytesReceived"`
+	BytesDropped  uint64 `json:"bytesDropped"`
+	Allocations   int    `json:"allocations"`
+}
+
+// handleUsage returns the traffic relayed for each room and tenant since
+// the server started, optionally for the tenant in ?tenant=
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	if turnMeter == nil {
+		writeError(w, http.StatusServiceUnavailable, "TURN usage metering is not enabled")
+		return
+	}
+	tenant, filtered := r.URL.Query().Get("tenant"), r.URL.Query().Has("tenant")
+	rooms := []turnserver.Usage{}
+	tenants := []tenantUsage{}
+	for _, usage := range turnMeter.Usage() {
+		if filtered && usage.Tenant != tenant {
+			continue
+		}
+		rooms = append(rooms, usage)
+		// Usage is sorted by tenant
+		if len(tenants) == 0 || tenants[len(tenants)-1].Tenant != usage.Tenant {
+			tenants = append(tenants, tenantUsage{Tenant: usage.Tenant})
+		}
+		total := &tenants[len(tenants)-1]
+		total.BytesSent += usage.BytesSent
+		total.BytesReceived += usage.BytesReceived
+		total.BytesDropped += usage.BytesDropped
+		total.Allocations += usage.Allocations
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"relay": map[string]interface{}{"rooms": rooms, "tenants": tenants},
+	})
+}